@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
 	"runtime"
-	"runtime/debug"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	pflag "github.com/spf13/pflag"
@@ -45,107 +52,545 @@ var exampleUsage = strings.TrimSpace(`
   walship --config $HOME/.walship/config.toml --once
 `)
 
-func getVersion() string {
-	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
-		return info.Main.Version
-	}
-	return "dev"
-}
-
 func main() {
 	cfg := agent.DefaultConfig()
 	var cfgPath string
+	var watchFiles []string
+
+	var output string
+	var showEvents bool
+	var ledgerQuery agent.LedgerQuery
+	var ledgerTime string
+	var replayQuery agent.ReplayQuery
+	var backfillFrom, backfillTo int64
+	var historyDiff bool
+	var doctorJSON bool
 
 	log := agent.Logger()
 
+	// resolveConfig layers the config file, WALSHIP_* environment and flags
+	// (in increasing precedence) into cfg and validates the result.
+	resolveConfig := func(cmd *cobra.Command) error {
+		if err := agent.SetLogFormat(output); err != nil {
+			return err
+		}
+		log = agent.Logger()
+
+		for _, spec := range watchFiles {
+			wf, err := agent.ParseWatchFile(spec)
+			if err != nil {
+				return err
+			}
+			cfg.WatchFiles = append(cfg.WatchFiles, wf)
+		}
+
+		return agent.LoadConfig(&cfg, cfgPath, changedFlags(cmd))
+	}
+
 	root := &cobra.Command{
-		Use:     "walship",
-		Short:   "Stream your node's consensus feed to apphash.io without slowing your validator",
-		Long:    longHelp,
-		Example: exampleUsage,
-		Version: fmt.Sprintf("%s %s/%s", getVersion(), runtime.GOOS, runtime.GOARCH),
+		Use:           "walship",
+		Short:         "Stream your node's consensus feed to apphash.io without slowing your validator",
+		Long:          longHelp,
+		Example:       exampleUsage,
+		Version:       fmt.Sprintf("%s %s/%s", agent.Version(), runtime.GOOS, runtime.GOARCH),
+		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Load config file first (default $HOME/.walship/config.toml), then apply flag overrides
-			// Determine config path
-			cfgFile := cfgPath
-			if cfgFile == "" {
-				cfgFile = agent.DefaultConfigPath()
+			if err := resolveConfig(cmd); err != nil {
+				return err
 			}
 
-			// Build set of changed flags
-			changed := map[string]bool{}
-			cmd.Flags().Visit(func(f *pflag.Flag) { changed[f.Name] = true })
-
-			if cfgFile != "" && agent.FileExists(cfgFile) {
-				fc, err := agent.LoadFileConfig(cfgFile)
-				if err != nil {
-					return fmt.Errorf("load config: %w", err)
-				}
-				if err := agent.ApplyFileConfig(&cfg, fc, changed); err != nil {
-					return err
+			// SIGINT and SIGTERM stop the agent in order. SIGHUP re-reads
+			// the config file; flags keep their values.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			go func() {
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-hup:
+					}
+					next, err := agent.ReloadConfig(cfg, cfgPath, changedFlags(cmd))
+					if err == nil {
+						err = agent.Reload(next)
+					}
+					if err != nil {
+						log.Error().Err(err).Msg("config reload failed, keeping the running config")
+					}
 				}
+			}()
+
+			// The supervisor restarts the agent after a crash, until its
+			// restart budget is spent.
+			if err := agent.NewSupervisor(cfg).Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the committed WAL position and how far it is behind",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
+				return err
+			}
+			st, err := agent.ReadStatus(cfg)
+			if err != nil {
+				return err
+			}
+			if showEvents {
+				// A missing file just means the agent has not recorded
+				// anything yet.
+				st.Events, _ = agent.ReadEvents(cfg)
+			}
+			return printStatus(os.Stdout, output, st)
+		},
+	}
+	statusCmd.Flags().BoolVar(&showEvents, "events", false, "also list the agent's recent sends, errors and state changes")
+	root.AddCommand(statusCmd)
+
+	root.AddCommand(&cobra.Command{
+		Use:   "reset-counters",
+		Short: "Zero the cumulative shipped frame and byte counters",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
+				return err
+			}
+			if err := agent.ResetCounters(cfg); err != nil {
+				return err
 			}
+			fmt.Fprintln(os.Stdout, "counters reset")
+			return nil
+		},
+	})
 
-			// Apply environment variables (WALSHIP_*)
-			// These override file config but are overridden by flags (checked via changed map)
-			agent.ApplyEnvConfig(&cfg, changed)
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect walship configuration",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Describe every configuration option (flag, env var, file key, default)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printSchema(os.Stdout, output, agent.ConfigSchema())
+		},
+	})
+	configHistoryCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the config snapshots shipped from this node, newest first (see --config-history)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
+				return err
+			}
+			entries, err := agent.ReadConfigHistory(cfg)
+			if errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("no config history in %s yet", cfg.StateDir)
+			}
+			if err != nil {
+				return err
+			}
+			return printConfigHistory(os.Stdout, output, entries, historyDiff)
+		},
+	}
+	configHistoryCmd.Flags().BoolVar(&historyDiff, "diff", false, "also print what changed in each snapshot")
+	configCmd.AddCommand(configHistoryCmd)
+	root.AddCommand(configCmd)
 
-			// Load node info (ChainID, NodeID) from files if needed
-			if err := agent.LoadNodeInfo(&cfg); err != nil {
+	ledgerCmd := &cobra.Command{
+		Use:   "ledger",
+		Short: "Inspect the local ledger of delivered batches (see --ledger)",
+	}
+	ledgerQueryCmd := &cobra.Command{
+		Use:   "query",
+		Short: "Show which batches delivered a height or time; without either, the latest batches",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
+				return err
+			}
+			q := ledgerQuery
+			if ledgerTime != "" {
+				t, err := time.Parse(time.RFC3339, ledgerTime)
+				if err != nil {
+					return fmt.Errorf("--time: %w", err)
+				}
+				q.Time = t
+			}
+			entries, err := agent.QueryLedger(cfg, q)
+			if err != nil {
 				return err
 			}
+			if len(entries) == 0 && (q.Height > 0 || !q.Time.IsZero()) {
+				return fmt.Errorf("no delivered batch matches")
+			}
+			return printLedger(os.Stdout, output, entries)
+		},
+	}
+	ledgerQueryCmd.Flags().Int64Var(&ledgerQuery.Height, "height", 0, "consensus height to look up")
+	ledgerQueryCmd.Flags().StringVar(&ledgerTime, "time", "", "record time to look up (RFC3339)")
+	ledgerQueryCmd.Flags().IntVar(&ledgerQuery.Limit, "limit", 0, "maximum batches to list (default 100)")
+	ledgerCmd.AddCommand(ledgerQueryCmd)
+	root.AddCommand(ledgerCmd)
 
-			// Validate and set derived defaults
-			if err := cfg.Validate(); err != nil {
+	replayCmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Re-send the decoded consensus events of a height range, e.g. only votes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
 				return err
 			}
+			if replayQuery.ToHeight == 0 {
+				replayQuery.ToHeight = replayQuery.FromHeight
+			}
+			n, err := agent.Replay(context.Background(), cfg, replayQuery)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "replayed %d consensus events for heights %d-%d\n", n, replayQuery.FromHeight, replayQuery.ToHeight)
+			return nil
+		},
+	}
+	replayCmd.Flags().Int64Var(&replayQuery.FromHeight, "from-height", 0, "first consensus height to replay")
+	replayCmd.Flags().Int64Var(&replayQuery.ToHeight, "to-height", 0, "last consensus height to replay (default --from-height)")
+	replayCmd.Flags().StringVar(&replayQuery.Kinds, "kinds", "", "comma-separated consensus kinds to replay, e.g. prevote,precommit (default all)")
+	root.AddCommand(replayCmd)
 
-			// Log configuration (masking API key)
-			logCfg := cfg
-			if len(logCfg.AuthKey) > 0 {
-				logCfg.AuthKey = "*****"
+	backfillCmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Ship the raw frames of a height range from the archive and WAL, marked as backfill",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := resolveConfig(cmd); err != nil {
+				return err
 			}
-			log.Info().Interface("config", logCfg).Msg("configuration")
+			if backfillTo == 0 {
+				backfillTo = backfillFrom
+			}
+			res, err := agent.Backfill(context.Background(), cfg, backfillFrom, backfillTo)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return json.NewEncoder(os.Stdout).Encode(res)
+			}
+			fmt.Fprintf(os.Stdout, "backfilled %d frames (%d bytes) for heights %d-%d\n", res.Frames, res.Bytes, backfillFrom, backfillTo)
+			return nil
+		},
+	}
+	backfillCmd.Flags().Int64Var(&backfillFrom, "from-height", 0, "first consensus height to backfill")
+	backfillCmd.Flags().Int64Var(&backfillTo, "to-height", 0, "last consensus height to backfill (default --from-height)")
+	root.AddCommand(backfillCmd)
 
-			if err := agent.Run(context.Background(), cfg); err != nil {
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check permissions, state, service reachability and auth, clock skew and fsnotify support",
+		Args:  cobra.NoArgs,
+		// Failing checks are in the report; usage would bury them.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if doctorJSON {
+				output = "json"
+			}
+			if err := resolveConfig(cmd); err != nil {
+				return err
+			}
+			report := agent.Doctor(context.Background(), cfg)
+			if err := printDoctor(os.Stdout, output, report); err != nil {
 				return err
 			}
+			if !report.OK {
+				return fmt.Errorf("doctor found failing checks")
+			}
 			return nil
 		},
 	}
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "print the report as JSON (same as --output json)")
+	root.AddCommand(doctorCmd)
 
 	// Flags
-	root.Flags().StringVar(&cfgPath, "config", "", "path to config file (default: $HOME/.walship/config.toml)")
-	root.Flags().StringVar(&cfg.NodeHome, "node-home", "", "application home directory")
-	root.Flags().StringVar(&cfg.WALDir, "wal-dir", cfg.WALDir, "WAL directory containing .idx/.gz pairs")
+	root.PersistentFlags().StringVar(&cfgPath, "config", "", "path to a TOML or YAML (.yaml/.yml) config file (default: $WALSHIP_CONFIG, else $HOME/.walship/config.toml)")
+	root.PersistentFlags().StringVarP(&output, "output", "o", "text", "output format: text or json")
+	root.PersistentFlags().StringVar(&cfg.NodeHome, "node-home", "", "application home directory")
+	root.PersistentFlags().StringVar(&cfg.WALDir, "wal-dir", cfg.WALDir, "WAL directory containing .idx/.gz pairs")
+	root.PersistentFlags().StringSliceVar(&cfg.NodeHomes, "node-homes", nil, "ship several nodes from one process: their home directories or glob patterns (comma-separated or repeated; instead of --node-home)")
 
-	root.Flags().StringVar(&cfg.ServiceURL, "service-url", cfg.ServiceURL, fmt.Sprintf("base service URL (defaults to %s; override only for internal testing)", agent.DefaultServiceURL))
-	if err := root.Flags().MarkHidden("service-url"); err != nil {
+	root.PersistentFlags().StringVar(&cfg.IngestPathPrefix, "ingest-path-prefix", cfg.IngestPathPrefix, "path of the ingest endpoints under the service URL, for gateways that rewrite paths (/ for the root)")
+	root.PersistentFlags().StringVar(&cfg.ServiceURL, "service-url", cfg.ServiceURL, fmt.Sprintf("base service URL (defaults to %s; override only for internal testing)", agent.DefaultServiceURL))
+	if err := root.PersistentFlags().MarkHidden("service-url"); err != nil {
 		log.Info().Err(err).Msg("failed to hide service-url flag")
 	}
-	root.Flags().StringVar(&cfg.AuthKey, "auth-key", cfg.AuthKey, "API key for authentication")
+	root.PersistentFlags().StringVar(&cfg.AuthKey, "auth-key", cfg.AuthKey, "API key for authentication")
+	root.PersistentFlags().StringToStringVar(&cfg.AuthKeys, "auth-keys", cfg.AuthKeys, "per-chain API keys as chain-id=key,... (chains without an entry use --auth-key)")
+	root.PersistentFlags().StringSliceVar(&cfg.TLSPins, "tls-pins", nil, "pin the service's certificate: sha256/<base64 public key hash> or cert-sha256/<hex fingerprint>, comma-separated or repeated (optional)")
+	root.PersistentFlags().StringVar(&cfg.ClientCertFile, "tls-client-cert", cfg.ClientCertFile, "PEM client certificate for services behind mutual TLS (requires --tls-client-key)")
+	root.PersistentFlags().StringVar(&cfg.ClientKeyFile, "tls-client-key", cfg.ClientKeyFile, "PEM private key of --tls-client-cert")
+	root.PersistentFlags().StringVar(&cfg.CAFile, "tls-ca-file", cfg.CAFile, "PEM CA certificates to trust in addition to the system roots")
+	root.PersistentFlags().StringVar(&cfg.ProxyURL, "proxy-url", cfg.ProxyURL, "send HTTP requests through this http://, https://, socks5:// or socks5h:// proxy instead of HTTPS_PROXY (NO_PROXY still applies)")
+	root.PersistentFlags().BoolVar(&cfg.InsecureSkipVerify, "tls-insecure-skip-verify", cfg.InsecureSkipVerify, "accept any server certificate (testing only; --tls-pins are then checked on every connection)")
+	root.PersistentFlags().StringVar(&cfg.GRPCTarget, "grpc-target", cfg.GRPCTarget, "stream frames over gRPC to this host:port instead of HTTP (optional)")
+	root.PersistentFlags().BoolVar(&cfg.GRPCInsecure, "grpc-insecure", cfg.GRPCInsecure, "disable TLS for --grpc-target")
+	root.PersistentFlags().StringSliceVar(&cfg.KafkaBrokers, "kafka-brokers", nil, "experimental: publish frames to these Kafka brokers (host:port, comma-separated) instead of the service (optional)")
+	root.PersistentFlags().StringVar(&cfg.KafkaTopic, "kafka-topic", cfg.KafkaTopic, "Kafka topic for --kafka-brokers")
+	root.PersistentFlags().BoolVar(&cfg.KafkaTLS, "kafka-tls", cfg.KafkaTLS, "use TLS to the Kafka brokers, with the service's client certificate, CA file and TLS pins")
+	root.PersistentFlags().StringVar(&cfg.ObjectStoreBucket, "object-store-bucket", cfg.ObjectStoreBucket, "write batches as objects to this S3-compatible bucket instead of the service (optional)")
+	root.PersistentFlags().StringVar(&cfg.ObjectStoreEndpoint, "object-store-endpoint", cfg.ObjectStoreEndpoint, "object storage endpoint URL (default AWS S3 in --object-store-region)")
+	root.PersistentFlags().StringVar(&cfg.ObjectStoreRegion, "object-store-region", cfg.ObjectStoreRegion, "object storage region to sign requests for (default us-east-1)")
+	root.PersistentFlags().StringVar(&cfg.ObjectStorePrefix, "object-store-prefix", cfg.ObjectStorePrefix, "prefix of the object keys")
+	root.PersistentFlags().StringVar(&cfg.ObjectStoreSSE, "object-store-sse", cfg.ObjectStoreSSE, "server-side encryption of the objects: AES256 or aws:kms")
+	root.PersistentFlags().StringVar(&cfg.ObjectStoreKMSKeyID, "object-store-kms-key-id", cfg.ObjectStoreKMSKeyID, "KMS key for --object-store-sse aws:kms")
+	root.PersistentFlags().StringVar(&cfg.RemoteWriteURL, "remote-write-url", cfg.RemoteWriteURL, "Prometheus remote-write URL for agent metrics (optional)")
+	root.PersistentFlags().StringVar(&cfg.StatsDAddr, "statsd-addr", cfg.StatsDAddr, "StatsD/DogStatsD host:port for agent metrics (optional)")
+	root.PersistentFlags().StringVar(&cfg.StatsDFlavor, "statsd-flavor", cfg.StatsDFlavor, "statsd metric format: dogstatsd (tags) or statsd")
+	root.PersistentFlags().DurationVar(&cfg.NodeMetricsInterval, "node-metrics-interval", cfg.NodeMetricsInterval, "scrape the node's Prometheus endpoint and ship it to the service this often (0 disables)")
+	root.PersistentFlags().StringVar(&cfg.NodeMetricsURL, "node-metrics-url", cfg.NodeMetricsURL, "the node's Prometheus endpoint (default from config.toml)")
+	root.PersistentFlags().DurationVar(&cfg.AckLatencySLO, "ack-latency-slo", cfg.AckLatencySLO, "record a degraded event while the ack-latency-percentile of WAL-write-to-ack latency exceeds this (0 disables)")
+	root.PersistentFlags().IntVar(&cfg.AckLatencyPercentile, "ack-latency-percentile", cfg.AckLatencyPercentile, "percentile of ack latency checked against ack-latency-slo: 50, 95 or 99")
+	root.PersistentFlags().StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "export OpenTelemetry spans of the send pipeline: otlp-http or otlp-grpc (optional)")
+	root.PersistentFlags().StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint, "OTLP collector: a URL for otlp-http, host:port for otlp-grpc")
+	root.PersistentFlags().BoolVar(&cfg.Tracing.Insecure, "tracing-insecure", cfg.Tracing.Insecure, "disable TLS to an otlp-grpc collector")
+	root.PersistentFlags().Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", cfg.Tracing.SampleRatio, "fraction of batches traced (0-1)")
+	root.PersistentFlags().StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log events at this level and above: debug, info, warn or error")
+	root.PersistentFlags().StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "serve Prometheus metrics at /metrics and expvars at /debug/vars on this host:port (optional)")
+	root.PersistentFlags().StringVar(&cfg.AdminAddr, "admin-addr", cfg.AdminAddr, "serve the admin API (state, WAL position, lag, plugins, POST /flush) on this host:port, loopback unless --admin-token is set (optional)")
+	root.PersistentFlags().StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "require this bearer token on every admin API request (optional)")
 
-	root.Flags().DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "poll interval when idle")
-	root.Flags().DurationVar(&cfg.SendInterval, "send-interval", cfg.SendInterval, "soft send interval")
-	root.Flags().DurationVar(&cfg.HardInterval, "hard-interval", cfg.HardInterval, "hard send interval (override gating)")
-	root.Flags().IntVar(&cfg.MaxBatchBytes, "max-batch-bytes", cfg.MaxBatchBytes, "maximum compressed bytes per batch")
+	root.PersistentFlags().DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "poll interval when idle")
+	root.PersistentFlags().DurationVar(&cfg.MaxPollInterval, "max-poll", cfg.MaxPollInterval, "poll interval cap after the WAL has been idle for a minute")
+	root.PersistentFlags().DurationVar(&cfg.SendInterval, "send-interval", cfg.SendInterval, "soft send interval")
+	root.PersistentFlags().DurationVar(&cfg.HardInterval, "hard-interval", cfg.HardInterval, "hard send interval (override gating)")
+	root.PersistentFlags().StringVar(&cfg.CommitMode, "commit-mode", cfg.CommitMode, "when to persist the read position: ack (after the service accepts frames) or periodic (also every commit-interval)")
+	root.PersistentFlags().StringVar(&cfg.Preflight, "preflight", cfg.Preflight, "startup checks policy: off, warn (log and continue) or strict (refuse to start)")
+	root.PersistentFlags().StringVar(&cfg.StartFrom, "start-from", cfg.StartFrom, "where to start without saved state: oldest, latest, time:<RFC3339> or height:<n>")
+	root.PersistentFlags().StringVar(&cfg.ChainMismatch, "on-chain-mismatch", cfg.ChainMismatch, "when the state directory belongs to another chain: refuse to start, or reset and start over")
+	root.PersistentFlags().StringVar(&cfg.WALRelocate, "wal-relocate", cfg.WALRelocate, "when wal-dir loses its WAL but another node-<id> dir beside it has one: off, warn (log it) or follow (switch to it)")
+	root.PersistentFlags().DurationVar(&cfg.CommitInterval, "commit-interval", cfg.CommitInterval, "how often to persist the read position in periodic commit mode")
+	root.PersistentFlags().IntVar(&cfg.MaxBatchBytes, "max-batch-bytes", cfg.MaxBatchBytes, "maximum compressed bytes per batch")
+	root.PersistentFlags().IntVar(&cfg.CompressionLevel, "compression-level", cfg.CompressionLevel, "gzip level (1-9) for upload bodies the agent compresses itself")
+	root.PersistentFlags().StringVar(&cfg.FrameEncoding, "frame-encoding", cfg.FrameEncoding, "encoding of uploaded frames: gzip (as written) or zstd (shared dictionary)")
+	root.PersistentFlags().Float64Var(&cfg.CanaryPercent, "canary-percent", cfg.CanaryPercent, "percentage of batches sent over --canary-grpc-target or with --canary-frame-encoding instead of the stable HTTP path")
+	root.PersistentFlags().StringVar(&cfg.CanaryGRPCTarget, "canary-grpc-target", cfg.CanaryGRPCTarget, "stream canary batches over gRPC to this host:port")
+	root.PersistentFlags().StringVar(&cfg.CanaryFrameEncoding, "canary-frame-encoding", cfg.CanaryFrameEncoding, "frame encoding of canary batches: gzip or zstd")
+	root.PersistentFlags().IntVar(&cfg.ResumableUploadBytes, "resumable-upload-bytes", cfg.ResumableUploadBytes, "send batches of at least this many bytes as resumable upload sessions (0 disables)")
+	root.PersistentFlags().IntVar(&cfg.StreamUploadBytes, "stream-upload-bytes", cfg.StreamUploadBytes, "while catching up, stream batches into one chunked request of up to this many bytes (0 disables)")
+	root.PersistentFlags().IntVar(&cfg.MaxUploadBytesPerSec, "max-upload-bytes-per-sec", cfg.MaxUploadBytesPerSec, "cap HTTP frame uploads at this many bytes per second (0 disables)")
+	root.PersistentFlags().IntVar(&cfg.MaxReadBytesPerSec, "max-read-bytes-per-sec", cfg.MaxReadBytesPerSec, "cap WAL frame reads at this many bytes per second (0 disables)")
+	root.PersistentFlags().IntVar(&cfg.SpoolMaxBytes, "spool-max-bytes", cfg.SpoolMaxBytes, "spool undeliverable batches to disk up to this many bytes and drain them on recovery (0 disables)")
+	root.PersistentFlags().DurationVar(&cfg.SpoolMaxAge, "spool-max-age", cfg.SpoolMaxAge, "evict spooled batches older than this (0 disables)")
+	root.PersistentFlags().StringVar(&cfg.ArchiveDir, "archive-dir", cfg.ArchiveDir, "copy WAL segments here (e.g. an NFS or S3 mount) and verify the copy before cleanup deletes them")
+	root.PersistentFlags().DurationVar(&cfg.ArchiveAfter, "archive-after", cfg.ArchiveAfter, "archive and remove WAL segments older than this, regardless of WAL dir size (0 disables; requires --archive-dir)")
+	root.PersistentFlags().DurationVar(&cfg.RetentionMaxAge, "retention-max-age", cfg.RetentionMaxAge, "remove acknowledged WAL segments older than this (0 disables; any retention rule replaces the cleanup size watermarks)")
+	root.PersistentFlags().IntVar(&cfg.RetentionMaxBytes, "retention-max-bytes", cfg.RetentionMaxBytes, "remove acknowledged WAL segments, oldest first, while the WAL dir is larger than this (0 disables)")
+	root.PersistentFlags().Float64Var(&cfg.RetentionMinFreePercent, "retention-min-free-percent", cfg.RetentionMinFreePercent, "remove acknowledged WAL segments, oldest first, while the WAL disk has less than this percent free (0 disables; Linux only)")
+	root.PersistentFlags().DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "bound on each stage of an ordered shutdown; a stage that overruns is abandoned")
 
-	root.Flags().Float64Var(&cfg.CPUThreshold, "cpu-threshold", cfg.CPUThreshold, "max CPU usage fraction before delaying send")
-	root.Flags().Float64Var(&cfg.NetThreshold, "net-threshold", cfg.NetThreshold, "max network usage fraction before delaying send")
-	root.Flags().StringVar(&cfg.Iface, "iface", cfg.Iface, "network interface to monitor (optional)")
-	root.Flags().IntVar(&cfg.IfaceSpeedMbps, "iface-speed", cfg.IfaceSpeedMbps, "interface speed in Mbps (used for utilization)")
+	root.PersistentFlags().Float64Var(&cfg.CPUThreshold, "cpu-threshold", cfg.CPUThreshold, "max CPU usage fraction before delaying send")
+	root.PersistentFlags().Float64Var(&cfg.NetThreshold, "net-threshold", cfg.NetThreshold, "max network usage fraction before delaying send")
+	root.PersistentFlags().StringVar(&cfg.Iface, "iface", cfg.Iface, "network interface to monitor (default: the one carrying the default route)")
+	root.PersistentFlags().StringVar(&cfg.NetProbe, "net-probe", cfg.NetProbe, "whose network usage net-threshold applies to: host or process (the node's network namespace)")
+	root.PersistentFlags().IntVar(&cfg.IfaceSpeedMbps, "iface-speed", cfg.IfaceSpeedMbps, "interface speed in Mbps (used for utilization; 0 reads it from sysfs)")
 
-	root.Flags().StringVar(&cfg.StateDir, "state-dir", cfg.StateDir, "state directory for status.json (defaults to wal-dir)")
-	if err := root.Flags().MarkHidden("state-dir"); err != nil {
+	root.PersistentFlags().StringVar(&cfg.StateDir, "state-dir", cfg.StateDir, "state directory for status.json (defaults to wal-dir)")
+	if err := root.PersistentFlags().MarkHidden("state-dir"); err != nil {
 		log.Info().Err(err).Msg("failed to hide state-dir flag")
 	}
-	root.Flags().DurationVar(&cfg.HTTPTimeout, "timeout", cfg.HTTPTimeout, "HTTP timeout")
-	root.Flags().BoolVar(&cfg.Verify, "verify", cfg.Verify, "verify CRC/line counts while reading (debug)")
-	root.Flags().BoolVar(&cfg.Meta, "meta", cfg.Meta, "print frame metadata to stderr (debug)")
-	root.Flags().BoolVar(&cfg.Once, "once", cfg.Once, "process available frames and exit")
+	root.PersistentFlags().StringVar(&cfg.StateBackend, "state-backend", cfg.StateBackend, "where the shipping position is kept: json (status.json), bolt (state.bolt) or sqlite (state.db, cgo builds only)")
+	root.PersistentFlags().DurationVar(&cfg.HTTPTimeout, "timeout", cfg.HTTPTimeout, "HTTP timeout")
+	root.PersistentFlags().IntVar(&cfg.SendMaxAttempts, "send-max-attempts", cfg.SendMaxAttempts, "attempts per batch upload before backing off until the next pass")
+	root.PersistentFlags().DurationVar(&cfg.SendRetryBase, "send-retry-base", cfg.SendRetryBase, "first retry delay for a failed batch upload")
+	root.PersistentFlags().DurationVar(&cfg.SendRetryMax, "send-retry-max", cfg.SendRetryMax, "longest retry delay, also capping Retry-After")
+	root.PersistentFlags().BoolVar(&cfg.Verify, "verify", cfg.Verify, "verify CRC/line counts while reading (debug)")
+	root.PersistentFlags().BoolVar(&cfg.NoAtime, "noatime", cfg.NoAtime, "open WAL and node config files with O_NOATIME (Linux)")
+	root.PersistentFlags().BoolVar(&cfg.Meta, "meta", cfg.Meta, "print frame metadata to stderr (debug)")
+	root.PersistentFlags().BoolVar(&cfg.Once, "once", cfg.Once, "process available frames and exit")
+	root.PersistentFlags().BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "read, batch and gate as usual but print each batch instead of sending it; the saved position is not moved")
+	root.PersistentFlags().BoolVar(&cfg.DecodeConsensus, "decode-consensus", cfg.DecodeConsensus, "also send proposals, votes and block parts as structured events")
+	root.PersistentFlags().BoolVar(&cfg.Ledger, "ledger", cfg.Ledger, "record delivered batches and their heights in a local SQLite ledger")
+	root.PersistentFlags().BoolVar(&cfg.FrameTypeStats, "frame-type-stats", cfg.FrameTypeStats, "count records by message type and send the counts with each batch")
+	root.PersistentFlags().IntVar(&cfg.SampleEveryN, "sample-every-n", cfg.SampleEveryN, "ship only frames whose number is a multiple of N (0 ships every frame)")
+	root.PersistentFlags().StringVar(&cfg.SampleTypes, "sample-types", cfg.SampleTypes, "comma-separated message types (vote,proposal,block_part,timeout,other); ship only frames holding one of them")
+	root.PersistentFlags().IntVar(&cfg.SampleHeightModulo, "sample-height-modulo", cfg.SampleHeightModulo, "ship only frames with consensus messages at heights that are a multiple of N (0 ships every frame)")
+	root.PersistentFlags().BoolVar(&cfg.ReportGaps, "report-gaps", cfg.ReportGaps, "send detected WAL gaps to the service")
+	root.PersistentFlags().StringVar(&cfg.CSWALDir, "cs-wal-dir", cfg.CSWALDir, "CometBFT consensus WAL dir (data/cs.wal) to ship consensus events from, relative to node-home")
+	root.PersistentFlags().StringVar(&cfg.ConsensusKinds, "consensus-kinds", cfg.ConsensusKinds, "comma-separated consensus event kinds to send (proposal,prevote,precommit,block_part); empty sends all")
+	root.PersistentFlags().BoolVar(&cfg.VoteLatency, "vote-latency", cfg.VoteLatency, "also send per peer and validator vote latencies derived from vote timestamps")
+	root.PersistentFlags().BoolVar(&cfg.HeightSummaries, "height-summaries", cfg.HeightSummaries, "also send per height round counts and step durations derived from the WAL")
+	root.PersistentFlags().BoolVar(&cfg.Anonymize, "anonymize", cfg.Anonymize, "hash node and peer IDs before upload and withhold the hostname")
+	root.PersistentFlags().StringVar(&cfg.AnonymizeSalt, "anonymize-salt", cfg.AnonymizeSalt, "per-operator secret used to hash IDs in anonymize mode")
+	root.PersistentFlags().IntVar(&cfg.ConfigChurnLimit, "config-churn-limit", cfg.ConfigChurnLimit, "warn when config files change more than this many times within config-churn-window (0 disables)")
+	root.PersistentFlags().DurationVar(&cfg.ConfigChurnWindow, "config-churn-window", cfg.ConfigChurnWindow, "window for config-churn-limit")
+	root.PersistentFlags().IntVar(&cfg.ConfigHistory, "config-history", cfg.ConfigHistory, "shipped config snapshots (hash, time, diff) to keep locally for `walship config history` (0 keeps none)")
+	root.PersistentFlags().BoolVar(&cfg.ShipConfig, "ship-config", cfg.ShipConfig, "watch and ship app.toml/config.toml")
+	root.PersistentFlags().BoolVar(&cfg.ShipClientConfig, "ship-client-config", cfg.ShipClientConfig, "also ship client.toml with the config files")
+	root.PersistentFlags().StringSliceVar(&cfg.ConfigRedact, "config-redact", cfg.ConfigRedact, "key patterns (* wildcards) redacted from app.toml/config.toml/client.toml before shipping; replaces the defaults")
+	root.PersistentFlags().BoolVar(&cfg.ShipGenesis, "ship-genesis", cfg.ShipGenesis, "also ship genesis.json (SHA-256, size and first 64KiB) with the config files")
+	root.PersistentFlags().StringVar(&cfg.WALWriterFile, "wal-writer-file", cfg.WALWriterFile, "lock or heartbeat file kept fresh by the node's WAL writer, relative to wal-dir")
+	root.PersistentFlags().DurationVar(&cfg.WALWriterTimeout, "wal-writer-timeout", cfg.WALWriterTimeout, "heartbeat age after which the WAL writer is reported stalled")
+	root.PersistentFlags().StringVar(&cfg.PreSendExec, "pre-send-exec", cfg.PreSendExec, "shell command to run before the first send; sends wait until it succeeds")
+	root.PersistentFlags().StringVar(&cfg.PostSendExec, "post-send-exec", cfg.PostSendExec, "shell command to run after each batch, with WALSHIP_BATCH_* variables set")
+	root.PersistentFlags().StringSliceVar(&cfg.Disable, "disable", cfg.Disable, "built-in subsystems to turn off (comma-separated): "+strings.Join(agent.Subsystems, ", "))
+	root.PersistentFlags().StringArrayVar(&watchFiles, "watch-file", nil, "extra file under node-home to ship, as path[:redact_key,...] (repeatable)")
 
 	if err := root.Execute(); err != nil {
 		log.Error().Err(err).Msg("walship")
 		os.Exit(1)
 	}
 }
+
+// changedFlags returns the names of the flags set on cmd's command line.
+func changedFlags(cmd *cobra.Command) map[string]bool {
+	changed := map[string]bool{}
+	cmd.Flags().Visit(func(f *pflag.Flag) { changed[f.Name] = true })
+	return changed
+}
+
+func printStatus(w io.Writer, format string, st agent.Status) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	fmt.Fprintf(w, "index:        %s\n", st.IdxPath)
+	fmt.Fprintf(w, "offset:       %d\n", st.IdxOffset)
+	fmt.Fprintf(w, "last frame:   %s #%d\n", st.LastFile, st.LastFrame)
+	fmt.Fprintf(w, "last send:    %s\n", formatTime(st.LastSendAt))
+	fmt.Fprintf(w, "last commit:  %s\n", formatTime(st.LastCommitAt))
+	fmt.Fprintf(w, "behind:       %d frames (%d bytes)\n", st.LagFrames, st.LagBytes)
+	fmt.Fprintf(w, "shipped:      %d frames (%d bytes) since %s\n", st.ShippedFrames, st.ShippedBytes, formatTime(st.ShippedSince))
+	if n := len(st.Gaps); n > 0 {
+		g := st.Gaps[n-1]
+		fmt.Fprintf(w, "wal gaps:     %d, last %s between %s and %s\n", n, g.Reason, g.After, g.Before)
+	}
+	if len(st.Events) > 0 {
+		fmt.Fprintln(w, "recent events:")
+		for _, ev := range st.Events {
+			fmt.Fprintf(w, "  %s  %-5s  %s\n", ev.Time.Local().Format(time.DateTime), ev.Type, ev.Message)
+		}
+	}
+	return nil
+}
+
+func printSchema(w io.Writer, format string, opts []agent.ConfigOption) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(opts)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tENV\tFILE\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, o := range opts {
+		flag := ""
+		if o.Flag != "" {
+			flag = "--" + o.Flag
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", orDash(flag), orDash(o.Env), orDash(o.File), o.Type, orDash(o.Default), o.Description)
+	}
+	return tw.Flush()
+}
+
+func printDoctor(w io.Writer, format string, r agent.DoctorReport) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range r.Checks {
+		name := c.Name
+		if c.Node != "" {
+			name = c.Node + " " + name
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(c.Result), name, c.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if r.OK {
+		fmt.Fprintln(w, "no failing checks")
+	}
+	return nil
+}
+
+func printConfigHistory(w io.Writer, format string, entries []agent.ConfigHistoryEntry, diff bool) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	if !diff {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SHIPPED\tHASH\tCHANGED")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", formatTime(e.ShippedAt), shortHash(e.Hash), changedFiles(e))
+		}
+		return tw.Flush()
+	}
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s  %s  %s\n", formatTime(e.ShippedAt), shortHash(e.Hash), changedFiles(e))
+		fmt.Fprint(w, e.Diff)
+	}
+	return nil
+}
+
+func changedFiles(e agent.ConfigHistoryEntry) string {
+	if e.Diff == "" && len(e.Changed) == 0 {
+		return "(first snapshot)"
+	}
+	return strings.Join(e.Changed, ", ")
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+func printLedger(w io.Writer, format string, entries []agent.LedgerEntry) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BATCH\tSENT\tSEGMENT\tFRAMES\tBYTES\tHEIGHTS\tRECORDS")
+	for _, e := range entries {
+		heights := "-"
+		if e.MaxHeight > 0 {
+			heights = fmt.Sprintf("%d-%d", e.MinHeight, e.MaxHeight)
+		}
+		segment := fmt.Sprintf("%s #%d-%d", e.Segment, e.FirstFrame, e.LastFrame)
+		if e.Spooled {
+			segment += " (spooled)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%s\t%s..%s\n", e.Batch, formatTime(e.SentAt), segment, e.Frames, e.Bytes,
+			heights, formatTime(e.FirstTime), formatTime(e.LastTime))
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
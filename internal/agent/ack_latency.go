@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+// defaultAckLatencyPercentile is the percentile AckLatencySLO applies to
+// unless AckLatencyPercentile is set.
+const defaultAckLatencyPercentile = 95
+
+var (
+	// ackLatencyWindow is how far back the percentiles look, and
+	// ackLatencyCap how many frames they keep at most.
+	ackLatencyWindow = 5 * time.Minute
+	ackLatencyCap    = 4096
+	// ackLatencyMinSamples is how many frames the window needs before the
+	// SLO is checked, so a single slow batch after a restart does not
+	// count as a violation.
+	ackLatencyMinSamples = 20
+
+	metricAckLatency = metrics.NewHistogram("walship_ack_latency_seconds",
+		"Time from frames being written to the WAL to the service acknowledging them.",
+		0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 900)
+)
+
+func init() { metrics.Register(metricAckLatency) }
+
+type ackSample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// ackLatencies keeps the ack latencies of the frames shipped within
+// ackLatencyWindow, across every pipeline of the agent.
+type ackLatencies struct {
+	mu       sync.Mutex
+	samples  []ackSample // ring of at most ackLatencyCap
+	next     int
+	violated bool
+}
+
+var ackLatency = &ackLatencies{}
+
+// AckLatency holds percentiles of the time from frames being written to the
+// WAL to the service acknowledging them, over the last few minutes.
+type AckLatency struct {
+	Frames int     `json:"frames"`
+	P50    float64 `json:"p50_seconds"`
+	P95    float64 `json:"p95_seconds"`
+	P99    float64 `json:"p99_seconds"`
+	// SLOViolated is true while the AckLatencySLO is exceeded.
+	SLOViolated bool `json:"slo_violated"`
+}
+
+// percentile returns the p-th (50, 95 or 99) percentile of l in seconds.
+func (l AckLatency) percentile(p int) float64 {
+	switch p {
+	case 50:
+		return l.P50
+	case 99:
+		return l.P99
+	}
+	return l.P95
+}
+
+// observeAckLatency records the latency of frames the service acknowledged
+// at now, measured from the timestamp of their last record, and checks
+// cfg's SLO. Frames without timestamps are skipped.
+func observeAckLatency(cfg Config, frames []batchFrame, now time.Time) {
+	a := ackLatency
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, fr := range frames {
+		if fr.Meta.LastTS <= 0 {
+			continue
+		}
+		d := now.Sub(time.Unix(0, fr.Meta.LastTS))
+		if d < 0 {
+			d = 0 // clock steps
+		}
+		metricAckLatency.Observe(d.Seconds())
+		s := ackSample{at: now, latency: d}
+		if len(a.samples) < ackLatencyCap {
+			a.samples = append(a.samples, s)
+		} else {
+			a.samples[a.next] = s
+			a.next = (a.next + 1) % len(a.samples)
+		}
+	}
+	a.checkLocked(cfg, now)
+}
+
+// snapshot returns the percentiles over the window ending at now.
+func (a *ackLatencies) snapshot(now time.Time) AckLatency {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshotLocked(now)
+}
+
+func (a *ackLatencies) snapshotLocked(now time.Time) AckLatency {
+	var ds []time.Duration
+	for _, s := range a.samples {
+		if now.Sub(s.at) <= ackLatencyWindow {
+			ds = append(ds, s.latency)
+		}
+	}
+	l := AckLatency{Frames: len(ds), SLOViolated: a.violated}
+	if len(ds) == 0 {
+		return l
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	// Nearest rank.
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(ds)))) - 1
+		return ds[max(i, 0)].Seconds()
+	}
+	l.P50, l.P95, l.P99 = rank(0.50), rank(0.95), rank(0.99)
+	return l
+}
+
+// checkLocked compares the configured percentile with cfg.AckLatencySLO,
+// recording a degraded event when it starts being exceeded and a state
+// event when it recovers.
+func (a *ackLatencies) checkLocked(cfg Config, now time.Time) {
+	if cfg.AckLatencySLO <= 0 {
+		a.violated = false
+		return
+	}
+	p := cfg.AckLatencyPercentile
+	if p == 0 {
+		p = defaultAckLatencyPercentile
+	}
+	l := a.snapshotLocked(now)
+	if l.Frames < ackLatencyMinSamples {
+		return
+	}
+	got := time.Duration(l.percentile(p) * float64(time.Second)).Round(time.Millisecond)
+	violated := got > cfg.AckLatencySLO
+	if violated == a.violated {
+		return
+	}
+	a.violated = violated
+	if violated {
+		logger.Warn().Int("percentile", p).Dur("ack_latency", got).Dur("slo", cfg.AckLatencySLO).Msg("ack latency SLO violated")
+		recordEvent(EventDegraded, fmt.Sprintf("ack latency p%d %s exceeds SLO %s", p, got, cfg.AckLatencySLO))
+	} else {
+		logger.Info().Int("percentile", p).Dur("ack_latency", got).Dur("slo", cfg.AckLatencySLO).Msg("ack latency back within SLO")
+		recordEvent(EventState, fmt.Sprintf("ack latency p%d %s back within SLO %s", p, got, cfg.AckLatencySLO))
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+// framesWrittenAt returns n frames whose last record was written at ts.
+func framesWrittenAt(n int, ts time.Time) []batchFrame {
+	frames := make([]batchFrame, n)
+	for i := range frames {
+		frames[i].Meta = FrameMeta{Frame: uint64(i + 1), LastTS: ts.UnixNano()}
+	}
+	return frames
+}
+
+func TestAckLatency_Percentiles(t *testing.T) {
+	old := ackLatency
+	ackLatency = &ackLatencies{}
+	defer func() { ackLatency = old }()
+
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		observeAckLatency(Config{}, framesWrittenAt(1, now.Add(-time.Duration(i)*time.Second)), now)
+	}
+	observeAckLatency(Config{}, []batchFrame{{}}, now) // no timestamp
+
+	got := ackLatency.snapshot(now)
+	want := AckLatency{Frames: 100, P50: 50, P95: 95, P99: 99}
+	if got != want {
+		t.Errorf("snapshot = %+v, want %+v", got, want)
+	}
+	if got := ackLatency.snapshot(now.Add(ackLatencyWindow + time.Second)); got.Frames != 0 || got.P99 != 0 {
+		t.Errorf("after the window: %+v, want no frames", got)
+	}
+}
+
+func TestAckLatency_SLO(t *testing.T) {
+	old := ackLatency
+	ackLatency = &ackLatencies{}
+	defer func() { ackLatency = old }()
+	oldEvents := recentEvents
+	recentEvents = &eventRing{}
+	defer func() { recentEvents = oldEvents }()
+
+	cfg := Config{AckLatencySLO: 30 * time.Second, AckLatencyPercentile: 95}
+	now := time.Now()
+	observeAckLatency(cfg, framesWrittenAt(ackLatencyMinSamples-1, now.Add(-time.Minute)), now)
+	if ackLatency.snapshot(now).SLOViolated {
+		t.Fatal("SLO checked before enough frames were acknowledged")
+	}
+	observeAckLatency(cfg, framesWrittenAt(1, now.Add(-time.Minute)), now)
+	if !ackLatency.snapshot(now).SLOViolated {
+		t.Fatal("p95 of 1m did not violate a 30s SLO")
+	}
+	ev := recentEvents.Snapshot()
+	if len(ev) != 1 || ev[0].Type != EventDegraded {
+		t.Fatalf("events = %+v, want one degraded event", ev)
+	}
+
+	// Once the slow frames age out of the window, fast ones recover it.
+	later := now.Add(ackLatencyWindow + time.Second)
+	observeAckLatency(cfg, framesWrittenAt(ackLatencyMinSamples, later.Add(-time.Second)), later)
+	if ackLatency.snapshot(later).SLOViolated {
+		t.Error("SLO still violated after recovery")
+	}
+	if ev := recentEvents.Snapshot(); len(ev) != 2 || ev[1].Type != EventState {
+		t.Errorf("events = %+v, want a recovery event", ev)
+	}
+}
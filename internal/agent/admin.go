@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// adminFlushTimeout bounds how long POST /flush waits for the pipelines.
+var adminFlushTimeout = 30 * time.Second
+
+// AdminState is the body of the admin API's GET /state.
+type AdminState struct {
+	State string `json:"state"`
+	Ready bool   `json:"ready"`
+}
+
+// AdminNode is one pipeline in the admin API's GET /status: its WAL
+// position, last send and lag, as the status command reports them.
+type AdminNode struct {
+	StateDir string `json:"state_dir"`
+	Status
+	Error string `json:"error,omitempty"`
+}
+
+// AdminPlugins is the body of the admin API's GET /plugins.
+type AdminPlugins struct {
+	// Hooks are the type names of the send hooks, in call order.
+	Hooks []string `json:"hooks"`
+	// Scrapers reports whether each scraper is enabled.
+	Scrapers map[string]bool `json:"scrapers"`
+}
+
+// AdminFlush is the body of the admin API's POST /flush.
+type AdminFlush struct {
+	Frames int    `json:"frames"`
+	Error  string `json:"error,omitempty"`
+}
+
+// serveAdmin serves the admin API on addr until ctx is done:
+//
+//	GET  /state    lifecycle state and readiness
+//	GET  /status   per node WAL position, last send time and lag
+//	GET  /plugins  send hooks and scrapers
+//	GET  /healthz  plugin health (see Health); 503 if any is unhealthy
+//	POST /flush    send the pending batches now (see Flush)
+//
+// If token is set every request must carry it as a bearer token; without
+// one, addr must be a loopback address.
+func serveAdmin(ctx context.Context, addr, token string) error {
+	if err := checkAdminAddr(addr, token); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("admin listener: %w", err)
+	}
+	srv := &http.Server{Handler: adminHandler(token), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("admin listener")
+		}
+	}()
+	logger.Info().Str("addr", ln.Addr().String()).Msg("serving admin api")
+	return nil
+}
+
+func adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", adminGet(func() any {
+		state, _ := lifecycle.Load().(string)
+		return AdminState{State: state, Ready: CurrentStats().Ready}
+	}))
+	mux.HandleFunc("/status", adminGet(func() any {
+		nodes := []AdminNode{}
+		for _, p := range runningPipelines() {
+			n := AdminNode{StateDir: p.stateDir}
+			st, err := ReadStatus(Config{StateDir: p.stateDir})
+			if err != nil {
+				n.Error = err.Error()
+			}
+			n.Status = st
+			nodes = append(nodes, n)
+		}
+		return nodes
+	}))
+	mux.HandleFunc("/plugins", adminGet(func() any {
+		out := AdminPlugins{Hooks: []string{}, Scrapers: Scrapers()}
+		if ps := runningPipelines(); len(ps) > 0 {
+			for _, h := range ps[0].hooks {
+				out.Hooks = append(out.Hooks, hookName(h))
+			}
+		}
+		if out.Scrapers == nil {
+			out.Scrapers = map[string]bool{}
+		}
+		return out
+	}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h := Health()
+		code := http.StatusOK
+		if !h.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeAdminJSON(w, code, h)
+	})
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAdminJSON(w, http.StatusMethodNotAllowed, AdminFlush{Error: "use POST"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), adminFlushTimeout)
+		defer cancel()
+		frames, err := Flush(ctx)
+		if err != nil {
+			writeAdminJSON(w, http.StatusServiceUnavailable, AdminFlush{Frames: frames, Error: err.Error()})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, AdminFlush{Frames: frames})
+	})
+	if token == "" {
+		return mux
+	}
+	return adminAuth(token, mux)
+}
+
+// adminAuth answers 401 to requests without "Authorization: Bearer token".
+func adminAuth(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="walship"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAdminAddr refuses to expose the admin API beyond the host without a
+// token, as it can flush and reports every node's state.
+func checkAdminAddr(addr, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("admin addr must be host:port: %w", err)
+	}
+	if token == "" && !isLoopbackHost(host) {
+		return fmt.Errorf("admin addr %q is not a loopback address; set an admin token to expose it", addr)
+	}
+	return nil
+}
+
+// isLoopbackHost reports whether host, from a host:port, only accepts local
+// connections. An empty host listens on every interface.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminGet wraps a read-only admin endpoint.
+func adminGet(fn func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, fn())
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,249 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler_NotRunning(t *testing.T) {
+	ts := httptest.NewServer(adminHandler(""))
+	defer ts.Close()
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/state", http.StatusOK},
+		{http.MethodGet, "/status", http.StatusOK},
+		{http.MethodGet, "/plugins", http.StatusOK},
+		{http.MethodPost, "/status", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/flush", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/flush", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// gateHook lets sends through only while open.
+type gateHook struct{ open atomic.Bool }
+
+func (h *gateHook) BeforeSend(SendInfo) bool  { return h.open.Load() }
+func (h *gateHook) AfterSend(SendInfo, error) {}
+
+func TestRun_AdminAPI(t *testing.T) {
+	got := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		}
+	}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	hook := &gateHook{}
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.WALDir, cfg.StateDir, cfg.AdminAddr = ts.URL, walDir, stateDir, addr
+	cfg.PollInterval, cfg.MaxPollInterval = time.Millisecond, time.Hour
+	cfg.PluginHooks = []PluginHook{hook}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	call := func(method, path string, v any) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, "http://"+addr+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp.StatusCode
+	}
+
+	var state AdminState
+	deadline := time.Now().Add(5 * time.Second)
+	for !state.Ready && time.Now().Before(deadline) {
+		call(http.MethodGet, "/state", &state)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state != (AdminState{State: StateRunning, Ready: true}) {
+		t.Fatalf("state = %+v", state)
+	}
+
+	var nodes []AdminNode
+	if code := call(http.MethodGet, "/status", &nodes); code != http.StatusOK || len(nodes) != 1 {
+		t.Fatalf("status: %d %+v", code, nodes)
+	}
+	if n := nodes[0]; n.StateDir != stateDir || n.LagFrames != 2 || n.Error != "" {
+		t.Errorf("node = %+v, want 2 frames of lag in %s", n, stateDir)
+	}
+
+	var plugins AdminPlugins
+	call(http.MethodGet, "/plugins", &plugins)
+	if len(plugins.Hooks) != 1 || plugins.Hooks[0] != "*agent.gateHook" {
+		t.Errorf("hooks = %v", plugins.Hooks)
+	}
+
+	// The hook refuses the send, so the flush cannot deliver the batch.
+	var flush AdminFlush
+	if code := call(http.MethodPost, "/flush", &flush); code != http.StatusServiceUnavailable || flush.Error == "" {
+		t.Errorf("refused flush: %d %+v", code, flush)
+	}
+
+	hook.open.Store(true)
+	flush = AdminFlush{}
+	if code := call(http.MethodPost, "/flush", &flush); code != http.StatusOK || flush.Error != "" {
+		t.Fatalf("flush: %d %+v", code, flush)
+	}
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush sent nothing")
+	}
+}
+
+func TestAdminHandler_Token(t *testing.T) {
+	ts := httptest.NewServer(adminHandler("secret"))
+	defer ts.Close()
+
+	tests := []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.auth, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/state", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_AdminAddrNeedsToken(t *testing.T) {
+	cfg := Config{WALDir: t.TempDir(), StateDir: t.TempDir(), ServiceURL: "http://127.0.0.1:1", AdminAddr: "0.0.0.0:0"}
+	err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "not a loopback address") {
+		t.Errorf("Run = %v, want the admin addr refused without a token", err)
+	}
+}
+
+func TestRun_FlushHonorsResourceGating(t *testing.T) {
+	oldResources := resources
+	// A sample in the future keeps the CPU over the threshold for the
+	// whole test, without reading /proc.
+	future := time.Now().Add(time.Hour)
+	resources = &resourceMonitor{last: future, samples: []resourceSample{{at: future, cpu: 1}}}
+	defer func() { resources = oldResources }()
+
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			posts.Add(1)
+		}
+	}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The first frame goes out at once, past HardInterval since no send;
+	// the second waits for SendInterval.
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.WALDir, cfg.StateDir = ts.URL, walDir, stateDir
+	cfg.PollInterval, cfg.MaxPollInterval = time.Millisecond, time.Hour
+	cfg.SendInterval, cfg.HardInterval = time.Hour, time.Hour
+	cfg.CPUThreshold = 0.5
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !CurrentStats().Ready && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var err error
+	for time.Now().Before(deadline) {
+		flushCtx, flushCancel := context.WithTimeout(ctx, time.Second)
+		_, err = Flush(flushCtx)
+		flushCancel()
+		if err != nil && strings.Contains(err.Error(), "resource gating") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err == nil || !strings.Contains(err.Error(), "resource gating") {
+		t.Fatalf("Flush() error = %v, want resource gating", err)
+	}
+	if n := posts.Load(); n != 1 {
+		t.Errorf("%d posts, want only the first frame's", n)
+	}
+}
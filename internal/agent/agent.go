@@ -3,29 +3,41 @@ package agent
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
 )
 
+// Ingest endpoints, at their default paths under DefaultIngestPathPrefix;
+// requests go to ingestURL.
 const (
-	walFramesEndpoint = "/v1/ingest/wal-frames"
-	configEndpoint    = "/v1/ingest/config"
+	walFramesEndpoint     = "/v1/ingest/wal-frames"
+	configEndpoint        = "/v1/ingest/config"
+	consensusEndpoint     = "/v1/ingest/consensus-events"
+	voteLatencyEndpoint   = "/v1/ingest/vote-latency"
+	heightSummaryEndpoint = "/v1/ingest/height-summaries"
 )
 
 type batchFrame struct {
 	Meta       FrameMeta
 	Compressed []byte
 	IdxLineLen int
+	Hash       frameHash
+	// Types counts the frame's records by message type when FrameTypeStats
+	// is set.
+	Types map[consensus.MessageType]int
 }
 
+// Run ships the WAL of cfg's node, or of every node in cfg.NodeHomes, until
+// ctx is done or, with Once, the WAL is drained.
 func Run(ctx context.Context, cfg Config) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -33,26 +45,315 @@ func Run(ctx context.Context, cfg Config) error {
 	if cfg.ServiceURL == "" {
 		return fmt.Errorf("service-url is required")
 	}
+	// Reload validates the config it is given; the pipelines compare it
+	// against this one, so fill in the same defaults.
+	cfg.normalize()
+	nodes := []Config{cfg}
+	if len(cfg.NodeHomes) > 0 {
+		var err error
+		if nodes, err = nodeConfigs(cfg); err != nil {
+			return err
+		}
+	}
+	for _, n := range nodes {
+		if err := checkStateDir(n.WALDir, n.StateDir); err != nil {
+			return err
+		}
+	}
+	if err := SetLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	logBanner(newBanner(cfg, nodes))
+	useNoatime.Store(cfg.NoAtime)
+	setLifecycle(StateStarting)
+	defer setLifecycle(StateStopped)
+
+	// Recent events cover the whole process; a multi-node agent keeps them
+	// in the shared state dir, if one is set.
+	if cfg.StateDir != "" {
+		if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
+			return fmt.Errorf("state dir: %w", err)
+		}
+		recentEvents.persistTo(cfg.StateDir)
+		defer recentEvents.persistTo("")
+	}
+
+	if cfg.InsecureSkipVerify {
+		logger.Warn().Msg("tls server certificates are not verified (tls-insecure-skip-verify)")
+	}
+	if cfg.MetricsAddr != "" {
+		if err := serveMetrics(ctx, cfg.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	if cfg.AdminAddr != "" {
+		if err := serveAdmin(ctx, cfg.AdminAddr, cfg.AdminToken); err != nil {
+			return err
+		}
+	}
+	stopTracing, err := startTracing(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	if len(cfg.NodeHomes) == 0 {
+		return runPipeline(ctx, cfg)
+	}
+	return runNodes(ctx, nodes)
+}
+
+// runPipeline ships the WAL of one node until ctx is done.
+func runPipeline(ctx context.Context, cfg Config) error {
+	base := cfg // as configured, to tell which fields a reload changes
+	if cfg.Anonymize {
+		cfg.NodeID = anonymizeID(cfg.AnonymizeSalt, cfg.NodeID)
+	}
+	if cfg.PreSendExec != "" || cfg.PostSendExec != "" {
+		cfg.PluginHooks = append(append([]PluginHook(nil), cfg.PluginHooks...), newExecHook(cfg))
+	}
+	if cfg.DryRun {
+		dir, err := dryRunStateDir(cfg.StateDir)
+		if err != nil {
+			return fmt.Errorf("dry run state dir: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		logger.Warn().Str("state_dir", dir).Str("target", dryRunTarget(cfg)).Msg("dry run: nothing is sent and the saved position is not moved")
+		cfg.StateDir = dir
+	}
 	if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
 		return fmt.Errorf("state dir: %w", err)
 	}
+	opened, err := openStateStore(cfg.StateDir, cfg.StateBackend)
+	if err != nil {
+		return err
+	}
+	store := &sealableStateStore{stateStore: opened}
+	defer store.close()
+	useStateStore(cfg.StateDir, store)
+	defer useStateStore(cfg.StateDir, nil)
+	if err := checkChainIdentity(cfg); err != nil {
+		return err
+	}
+
+	if c, err := loadCounters(cfg.StateDir); err == nil {
+		setShippedTotals(cfg.StateDir, c)
+	}
+	if err := initPlugins(cfg, cfg.PluginHooks); err != nil {
+		return err
+	}
+
+	httpClient := newHTTPClient(cfg)
+	if !cfg.Anonymize && cfg.enabled(SubsystemBanner) {
+		go postBanner(ctx, cfg, httpClient, newBanner(cfg, []Config{cfg}))
+	}
+
+	p := &pipeline{stateDir: cfg.StateDir, reloads: make(chan Config, 1), flushes: make(chan chan flushResult), wake: make(chan struct{}, 1),
+		tombstonesBack: newBackoff(time.Second, time.Minute), gapsBack: newBackoff(time.Second, time.Minute),
+		derived: newDerivedQueue()}
+	p.sendCtx, p.cancelSend = context.WithCancel(context.Background())
+	defer p.cancelSend()
+	defer func() {
+		// Posts queued by a run that ended on its own, e.g. with Once.
+		timeout := cfg.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		dctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = p.derived.drain(dctx)
+	}()
+	if cfg.GRPCTarget != "" {
+		gs, err := newGRPCSender(cfg)
+		if err != nil {
+			return err
+		}
+		defer gs.Close()
+		p.grpc = gs
+	}
+	if cfg.CanaryGRPCTarget != "" {
+		cc := cfg
+		cc.GRPCTarget = cfg.CanaryGRPCTarget
+		gs, err := newGRPCSender(cc)
+		if err != nil {
+			return err
+		}
+		defer gs.Close()
+		p.canary = gs
+	}
+	if len(cfg.KafkaBrokers) > 0 {
+		ks, err := newKafkaSender(cfg)
+		if err != nil {
+			return err
+		}
+		defer ks.Close()
+		p.kafka = ks
+	}
+	if cfg.ObjectStoreBucket != "" {
+		obj, err := newObjectStoreSender(cfg)
+		if err != nil {
+			return err
+		}
+		p.objstore = obj
+	}
+
+	shipped, err := openFrameCache(cfg.StateDir, dedupCacheFrames)
+	if err != nil {
+		return fmt.Errorf("shipped frames: %w", err)
+	}
+	defer shipped.Close()
+	p.shipped = shipped
+
+	if cfg.SpoolMaxBytes > 0 {
+		sp, err := openSpool(cfg)
+		if err != nil {
+			return err
+		}
+		p.spool = sp
+	}
+
+	if cfg.Ledger {
+		l, err := openLedger(ledgerFile(cfg.StateDir))
+		if err != nil {
+			return err
+		}
+		p.ledger = l
+	}
 
-	// Start config watcher for dynamic configuration updates
-	cfgPtr := &cfg
-	watcher := NewConfigWatcher(cfgPtr)
-	go watcher.Run(ctx)
-	go walCleanupLoop(ctx, cfg.WALDir, cfg.StateDir)
+	if cfg.Preflight == PreflightWarn || cfg.Preflight == PreflightStrict {
+		findings := runPreflight(ctx, cfg, httpClient)
+		for _, f := range findings {
+			logger.Warn().Str("check", f.Check).Err(f.Err).Msg("preflight check failed")
+			recordEvent(EventError, "preflight "+f.String())
+		}
+		if len(findings) > 0 && cfg.Preflight == PreflightStrict {
+			msgs := make([]string, len(findings))
+			for i, f := range findings {
+				msgs[i] = f.String()
+			}
+			return fmt.Errorf("preflight checks failed: %s", strings.Join(msgs, "; "))
+		}
+		setPreflightFindings(findings)
+	}
 
-	// Load prior state; if none, start from the oldest index (first logs)
+	// Cleanup is restarted if the WAL is relocated.
+	startCleanup := func(walDir string) context.CancelFunc {
+		cctx, cancel := context.WithCancel(ctx)
+		go walCleanupLoop(cctx, walDir, cfg.StateDir, newWALArchive(cfg), newWALRetention(cfg))
+		return cancel
+	}
+	stopCleanup := startCleanup(cfg.WALDir)
+	defer func() { stopCleanup() }()
+
+	// Auxiliary scrapers can be toggled at runtime via SetScraperEnabled.
+	// The config watcher's initial upload is queued in the background and
+	// never delays WAL shipping. Config files name peers and addresses, so
+	// they are not shipped by default when anonymizing. Scrapers outlive
+	// ctx so that a shutdown can stop them after the final commit. Each
+	// scraper works on its own copy of cfg; those talking to the service
+	// are restarted when a reload changes it.
+	scrapersCtx, cancelScrapers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelScrapers()
+	scrapers := newScraperManager(scrapersCtx)
+	watchConfig := func(cfg Config, httpClient *http.Client) scraperFunc {
+		return func(ctx context.Context) { newConfigWatcher(&cfg, httpClient).Run(ctx) }
+	}
+	if cfg.enabled(SubsystemConfig) {
+		scrapers.Register("config", watchConfig(cfg, httpClient), cfg.ShipConfig && !cfg.Anonymize)
+	}
+	if cfg.enabled(SubsystemLag) {
+		scrapers.RegisterScraper(lagScraper{stateDir: cfg.StateDir, noHeartbeat: !cfg.enabled(SubsystemHeartbeat)}, true)
+	}
+	if cfg.WALWriterFile != "" {
+		scrapers.RegisterScraper(newWALWriterScraper(cfg, httpClient), true)
+	}
+	if cfg.NodeHome != "" && cfg.enabled(SubsystemKeys) {
+		scrapers.RegisterScraper(newKeyScraper(cfg, httpClient), !cfg.Anonymize)
+	}
+	if cfg.CSWALDir != "" {
+		scrapers.RegisterScraper(newCSWALScraper(cfg, httpClient), true)
+	}
+	// latestNode carries node metrics to remote-write, if it is set.
+	var latestNode *nodeSamples
+	if cfg.RemoteWriteURL != "" {
+		latestNode = &nodeSamples{}
+		scrapers.RegisterScraper(remoteWriteScraper{cfg: cfg, w: newRemoteWriter(cfg.RemoteWriteURL, httpClient), node: latestNode}, true)
+	}
+	if cfg.NodeMetricsInterval > 0 {
+		scrapers.RegisterScraper(newNodeMetricsScraper(cfg, httpClient, latestNode), !cfg.Anonymize)
+	}
+	if cfg.StatsDAddr != "" {
+		cfg := cfg
+		scrapers.Register("statsd", func(ctx context.Context) {
+			e, err := newStatsdEmitter(cfg.StatsDAddr, cfg.StatsDFlavor)
+			if err != nil {
+				logger.Error().Err(err).Msg("statsd")
+				return
+			}
+			defer e.Close()
+			scheduleScraper(statsdScraper{cfg: cfg, e: e}, nil)(ctx)
+		}, true)
+	}
+	p.scrapers, p.hooks = scrapers, cfg.PluginHooks
+	if cfg.HeightSummaries {
+		p.steps = &consensus.StepTracker{}
+	}
+	registerPipeline(p)
+	defer unregisterPipeline(p)
+
+	// Load prior state; if none, start where StartFrom says (oldest by
+	// default).
 	st, _ := loadState(cfg.StateDir)
+	if err := migrateCounters(cfg.StateDir, &st); err != nil {
+		logger.Error().Err(err).Msg("migrate counters")
+	}
+	if len(st.Journal) > 0 || st.InFlight != nil {
+		reconcileJournal(cfg, httpClient, &st)
+	}
+	st.resumeRead()
+	reloc := newWALRelocator(base) // base has the node ID before anonymizing
+	if dir, ok := reloc.check(cfg.WALDir, true); ok {
+		if err := relocatePosition(cfg, dir, &st); err != nil {
+			return fmt.Errorf("relocate wal: %w", err)
+		}
+		logWALRelocation(cfg.WALDir, dir, st)
+		cfg.WALDir = dir
+		_ = saveState(cfg.StateDir, st)
+		stopCleanup()
+		stopCleanup = startCleanup(cfg.WALDir)
+	}
 	if st.IdxPath == "" {
-		idxPath, err := oldestIndex(cfg.WALDir)
+		sf, err := parseStartFrom(cfg.StartFrom)
+		if err != nil {
+			return err
+		}
+		idxPath, off, err := startPosition(cfg.WALDir, sf)
+		if err != nil && cfg.CSWALDir != "" {
+			// Without a memlogger WAL only the consensus WAL is shipped.
+			logger.Info().Err(err).Str("cs_wal_dir", csWALDir(cfg)).Msg("no memlogger WAL; shipping the consensus WAL only")
+			p.setReady(true)
+			if cfg.Once {
+				_ = scrapers.SetEnabled("cs-wal", false)
+				return scrapeOnce(ctx, newCSWALScraper(cfg, httpClient))
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case done := <-p.flushes:
+					done <- flushResult{}
+				}
+			}
+		}
 		if err != nil {
 			return err
 		}
 		st.IdxPath = idxPath
-		st.IdxOffset = 0
+		st.IdxOffset = off
 		_ = saveState(cfg.StateDir, st)
+		if cfg.StartFrom != "" && cfg.StartFrom != StartFromOldest {
+			logger.Info().Str("start_from", cfg.StartFrom).Str("idx", idxPath).Int64("offset", off).Msg("fresh start position")
+		}
 	}
 
 	idx, r, err := openIdx(st.IdxPath)
@@ -73,39 +374,210 @@ func Run(ctx context.Context, cfg Config) error {
 			gz = f
 		}
 	}
-	httpClient := &http.Client{Timeout: cfg.HTTPTimeout}
 	back := newBackoff(500*time.Millisecond, 10*time.Second)
 
+	p.setReady(true)
+	setLifecycle(StateRunning)
+	logger.Info().Str("node_id", cfg.NodeID).Str("idx", st.IdxPath).Int64("offset", st.IdxOffset).Msg("wal pipeline running")
+
 	var (
 		batch      []batchFrame
 		batchBytes int
 		lastSend   time.Time
+		lastCommit = time.Now()
+		gaps       gapDetector
 	)
+	idle := newIdlePoller(cfg.PollInterval, cfg.MaxPollInterval)
+	sampler := newFrameSampler(cfg)
+	var sampled sampledRun
+
+	// shutdown stops the pipeline in order once ctx is done. The pending
+	// batch is flushed on copies and the commit only takes the flushed
+	// position if the flush finished. A flush that overruns its stage has
+	// its uploads cancelled and is waited for before the senders are
+	// closed, and the commit seals the state store, so a flush that is
+	// still running cannot save over the committed state; at worst its
+	// frames are sent again after a restart.
+	shutdown := func() {
+		setLifecycle(StateStopping)
+		p.setReady(false)
+		sampled.flush(cfg)
+		flushed := make(chan state, 1)
+		flushDone := make(chan struct{})
+		runShutdown([]shutdownStage{
+			{ShutdownStopReaders, func(context.Context) error {
+				var err error
+				if idx != nil {
+					err = idx.Close()
+				}
+				if gz != nil {
+					gz.Close()
+					gz = nil
+				}
+				return err
+			}},
+			{ShutdownFlushBatch, func(context.Context) error {
+				defer close(flushDone)
+				b, bb, s := append([]batchFrame(nil), batch...), batchBytes, st
+				var noGz *os.File
+				trySend(cfg, httpClient, &b, &bb, &s, filepath.Base(s.IdxPath), &noGz, time.Time{}, newBackoff(0, 0))
+				flushed <- s
+				if len(b) > 0 {
+					return fmt.Errorf("%d frames not delivered", len(b))
+				}
+				return nil
+			}},
+			{ShutdownDrainSender, func(ctx context.Context) error {
+				errs := []error{p.derived.drain(ctx)}
+				p.cancelSend()
+				select {
+				case <-flushDone:
+				case <-ctx.Done():
+					return errors.Join(append(errs, errors.New("flush still running; senders left open"))...)
+				}
+				if p.grpc != nil {
+					errs = append(errs, p.grpc.Close())
+				}
+				if p.kafka != nil {
+					errs = append(errs, p.kafka.Close())
+				}
+				return errors.Join(errs...)
+			}},
+			{ShutdownCommitState, func(context.Context) error {
+				select {
+				case s := <-flushed:
+					st = s
+				default:
+				}
+				return store.seal(st)
+			}},
+			{ShutdownStopScrapers, func(context.Context) error {
+				scrapers.StopAll()
+				return nil
+			}},
+			{ShutdownStopPlugins, func(ctx context.Context) error {
+				return shutdownPlugins(ctx, cfg.PluginHooks)
+			}},
+		}, cfg.ShutdownTimeout)
+	}
 
 	for {
 		// Handle context cancellation
 		select {
 		case <-ctx.Done():
+			shutdown()
 			return ctx.Err()
 		default:
 		}
 
-		fm, line, nerr := func() (FrameMeta, []byte, error) { return nextFrame(r) }()
+		if next, ok := p.pendingReload(); ok {
+			changed, restart := applyReload(&cfg, &base, next)
+			logReload(cfg, changed, restart)
+			if len(changed) > 0 {
+				idle = newIdlePoller(cfg.PollInterval, cfg.MaxPollInterval)
+				sampler = newFrameSampler(cfg)
+			}
+			if reloadsService(changed) {
+				httpClient.CloseIdleConnections()
+				httpClient = newHTTPClient(cfg)
+				if cfg.enabled(SubsystemConfig) {
+					scrapers.Replace("config", watchConfig(cfg, httpClient))
+				}
+				if cfg.WALWriterFile != "" {
+					s := newWALWriterScraper(cfg, httpClient)
+					scrapers.ReplaceScraper(s)
+				}
+				if cfg.CSWALDir != "" {
+					s := newCSWALScraper(cfg, httpClient)
+					scrapers.ReplaceScraper(s)
+				}
+				if cfg.NodeHome != "" && cfg.enabled(SubsystemKeys) {
+					s := newKeyScraper(cfg, httpClient)
+					scrapers.ReplaceScraper(s)
+				}
+				if cfg.NodeMetricsInterval > 0 {
+					s := newNodeMetricsScraper(cfg, httpClient, latestNode)
+					scrapers.ReplaceScraper(s)
+				}
+			}
+		}
+
+		if done, ok := p.pendingFlush(); ok {
+			// A forced send ignores SendInterval but, like any send before
+			// HardInterval, waits for the host resources.
+			n := len(batch)
+			gated := n > 0 && time.Since(lastSend) < cfg.HardInterval && !resourcesOK(cfg)
+			switch {
+			case gated:
+			case n > 0 || p.streaming():
+				trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, time.Time{}, back)
+				lastSend = st.LastSendAt
+			default:
+				retrySpool(cfg, httpClient, &st, back)
+			}
+			done <- flushResult{Frames: n - len(batch), Pending: len(batch), Gated: gated}
+		}
+
+		if cfg.CommitMode == CommitModePeriodic && time.Since(lastCommit) >= cfg.CommitInterval {
+			commitReadPosition(cfg, &st, p.unackedFrames(batch))
+			lastCommit = time.Now()
+		}
+
+		if idx == nil {
+			// Released while idle, below; a missing index is taken for
+			// the end of the segment, to discover the next one.
+			if idx2, r2, oerr := openIdx(st.IdxPath); oerr == nil {
+				if st.IdxOffset > 0 {
+					if _, err := idx2.Seek(st.IdxOffset, io.SeekStart); err == nil {
+						r2.Reset(idx2)
+					}
+				}
+				idx, r = idx2, r2
+			}
+		}
+		fm, line, nerr := func() (FrameMeta, []byte, error) {
+			if idx == nil {
+				return FrameMeta{}, nil, io.EOF
+			}
+			return nextFrame(r)
+		}()
+
+		// skipLine moves the committed offset past an index line whose
+		// frame will not be uploaded.
+		skipLine := func() {
+			if n := len(batch); n > 0 {
+				batch[n-1].IdxLineLen += len(line)
+			} else {
+				st.IdxOffset += int64(len(line))
+				_ = saveState(cfg.StateDir, st)
+			}
+		}
+
 		if nerr != nil {
 			if errors.Is(nerr, os.ErrClosed) {
 				return nerr
 			}
 			if errors.Is(nerr, io.EOF) {
 				// Flush pending batch
-				if len(batch) > 0 {
+				if len(batch) > 0 || p.streaming() {
 					trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
 					lastSend = st.LastSendAt
+				} else {
+					retrySpool(cfg, httpClient, &st, back)
 				}
+				sampled.flush(cfg)
+				p.flushTombstones(cfg, httpClient)
+				p.flushGaps(cfg, httpClient, &st)
 				if cfg.Once {
 					return nil
 				}
+				if p.streaming() {
+					// Streamed frames of this segment await resending.
+					sleepCtx(ctx, idle.Next(), p.wake)
+					continue
+				}
 				// rotation discovery: move to next index after current
-				if next, ok, _ := nextIndexAfter(st.IdxPath); ok {
+				if next, ok, _ := wal.NextIndexAfter(st.IdxPath); ok {
 					idx.Close()
 					if gz != nil {
 						gz.Close()
@@ -117,8 +589,79 @@ func Run(ctx context.Context, cfg Config) error {
 						_ = saveState(cfg.StateDir, st)
 						continue
 					}
+				} else if next, missing, ok, _ := wal.NextExistingIndexAfter(st.IdxPath); ok {
+					// A later segment exists, so the next one in sequence
+					// was deleted before it could be read.
+					if idx2, r2, oerr := openIdx(next); oerr == nil {
+						idx.Close()
+						if gz != nil {
+							gz.Close()
+							gz = nil
+						}
+						g := gaps.skipSegments(st.IdxPath, next, missing)
+						idx, r = idx2, r2
+						st.IdxPath, st.IdxOffset, st.CurGz = next, 0, ""
+						recordGap(cfg, &st, g)
+						continue
+					}
 				}
-				time.Sleep(cfg.PollInterval)
+				// A WAL that moved to another node dir is followed only
+				// between batches, as a new position may not line up with
+				// the pending one.
+				if dir, ok := reloc.check(cfg.WALDir, false); ok && len(batch) == 0 {
+					moved := st
+					if err := relocatePosition(cfg, dir, &moved); err != nil {
+						logger.Error().Err(err).Str("wal_dir", dir).Msg("relocate wal")
+					} else if idx2, r2, oerr := openIdx(moved.IdxPath); oerr == nil {
+						idx.Close()
+						if gz != nil {
+							gz.Close()
+							gz = nil
+						}
+						if moved.IdxOffset > 0 {
+							if _, err := idx2.Seek(moved.IdxOffset, io.SeekStart); err == nil {
+								r2.Reset(idx2)
+							}
+						}
+						idx, r, st = idx2, r2, moved
+						logWALRelocation(cfg.WALDir, dir, st)
+						cfg.WALDir = dir
+						_ = saveState(cfg.StateDir, st)
+						stopCleanup()
+						stopCleanup = startCleanup(cfg.WALDir)
+						continue
+					}
+				}
+				// Where open files pin their names, the node can rotate
+				// and prune segments only once walship closes them, so
+				// they are closed while idle and reopened at the read
+				// position, which with no batch pending is st.IdxOffset.
+				if len(batch) == 0 && idx != nil && walPinsOpenFiles() {
+					idx.Close()
+					idx = nil
+					if gz != nil {
+						gz.Close()
+						gz = nil
+					}
+				}
+				sleepCtx(ctx, idle.Next(), p.wake)
+				continue
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				// A complete line that does not parse names no frame that
+				// could be read; it is skipped and reported.
+				off := st.IdxOffset
+				for _, bf := range batch {
+					off += int64(bf.IdxLineLen)
+				}
+				logger.Warn().Err(nerr).Str("idx", st.IdxPath).Int64("offset", off).Msg("skipping corrupt index line")
+				addTombstone(cfg, Tombstone{Segment: filepath.Base(st.IdxPath), IdxOffset: off, Reason: TombstoneCorruptIndex, Detail: nerr.Error()})
+				gaps.skipCorrupt()
+				skipLine()
+				continue
+			}
+			if line != nil {
+				skipLine()
 				continue
 			}
 			// other read error
@@ -126,6 +669,17 @@ func Run(ctx context.Context, cfg Config) error {
 			continue
 		}
 
+		idle.Active()
+		if g, ok := gaps.frame(st.IdxPath, fm); ok {
+			recordGap(cfg, &st, g)
+		}
+		if !sampler.keepMeta(fm) {
+			metricFramesSampledOut.Inc()
+			sampled.add(cfg, sampler, st.IdxPath, fm)
+			skipLine()
+			continue
+		}
+
 		// Ensure gz open for this frame
 		if gz == nil || filepath.Base(st.CurGz) != fm.File {
 			if gz != nil {
@@ -155,26 +709,67 @@ func Run(ctx context.Context, cfg Config) error {
 			time.Sleep(cfg.PollInterval)
 			continue
 		}
+		metricFramesRead.Inc()
+		throttleRead(ctx, cfg, len(b))
 		if cfg.Verify {
 			_ = verifyFrame(fm, io.NopCloser(bytes.NewReader(b)))
 		}
 
+		h := hashFrame(b)
+		if p.shipped.Contains(h) {
+			recordDuplicateFrame()
+			logger.Debug().Str("file", fm.File).Uint64("frame", fm.Frame).Msg("skipping duplicate frame")
+			skipLine()
+			continue
+		}
+
+		var types map[consensus.MessageType]int
+		if cfg.FrameTypeStats {
+			types = frameTypes(b)
+		}
+		if !sampler.keepContent(b, types) {
+			metricFramesSampledOut.Inc()
+			sampled.add(cfg, sampler, st.IdxPath, fm)
+			skipLine()
+			continue
+		}
+		sampled.flush(cfg)
+
+		if cfg.Anonymize {
+			// A frame that cannot be anonymized is dropped rather than
+			// uploaded with identifying data.
+			var aerr error
+			if fm, b, aerr = anonymizeFrame(cfg, fm, b); aerr != nil {
+				logger.Warn().Err(aerr).Str("file", fm.File).Uint64("frame", fm.Frame).Msg("dropping frame that cannot be anonymized")
+				addTombstone(cfg, Tombstone{Segment: filepath.Base(st.IdxPath), File: fm.File, FirstFrame: fm.Frame, LastFrame: fm.Frame,
+					Frames: 1, Bytes: int64(fm.Len), Reason: TombstoneAnonymizeFailed})
+				skipLine()
+				continue
+			}
+		}
+
 		// Large frame: send alone
 		if cfg.MaxBatchBytes > 0 && len(b) > cfg.MaxBatchBytes {
-			bf := batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line)}
+			bf := batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line), Hash: h, Types: types}
 			batch = append(batch, bf)
 			batchBytes += len(b)
+			p.traceFrame(cfg)
 			trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
 			lastSend = st.LastSendAt
 			continue
 		}
 		// Normal batch
 		if cfg.MaxBatchBytes > 0 && batchBytes+len(b) > cfg.MaxBatchBytes {
-			trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
-			lastSend = st.LastSendAt
+			if p.streamBatch(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), back) {
+				lastSend = time.Now()
+			} else {
+				trySend(cfg, httpClient, &batch, &batchBytes, &st, filepath.Base(st.IdxPath), &gz, lastSend, back)
+				lastSend = st.LastSendAt
+			}
 		}
-		batch = append(batch, batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line)})
+		batch = append(batch, batchFrame{Meta: fm, Compressed: b, IdxLineLen: len(line), Hash: h, Types: types})
 		batchBytes += len(b)
+		p.traceFrame(cfg)
 
 		// Time-based send
 		if time.Since(lastSend) >= cfg.SendInterval || time.Since(lastSend) >= cfg.HardInterval {
@@ -185,7 +780,9 @@ func Run(ctx context.Context, cfg Config) error {
 }
 
 func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchBytes *int, st *state, curIdxBase string, gz **os.File, lastSend time.Time, back *backoff) {
-	if len(*batch) == 0 {
+	// Streamed frames precede the batch.
+	p := activePipeline(cfg)
+	if !p.endStream(cfg, httpClient, st, back) || len(*batch) == 0 {
 		return
 	}
 	// Resource gating (soft)
@@ -194,99 +791,163 @@ func trySend(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchByte
 		return
 	}
 
-	// Build payload
-	manifest := make([]FrameMeta, 0, len(*batch))
-	var advance int64
-	for _, fr := range *batch {
-		manifest = append(manifest, fr.Meta)
-		advance += int64(fr.IdxLineLen)
+	// Spooled batches go first; while they cannot be delivered, new batches
+	// queue behind them to keep frames in order.
+	sp := p.activeSpool()
+	if sp != nil && sp.Len() > 0 {
+		if err := drainSpool(cfg, httpClient, st, sp); err != nil {
+			logger.Error().Err(err).Int("spooled_batches", sp.Len()).Msg("drain spool")
+			recordEvent(EventError, "drain spool: "+err.Error())
+			spoolPending(cfg, sp, st, batch, batchBytes, curIdxBase)
+			p.traceSent(len(*batch))
+			back.Sleep()
+			return
+		}
 	}
-	url := cfg.ServiceURL + walFramesEndpoint
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
 
-	manifestJSON, err := json.Marshal(manifest)
-	if err != nil {
-		logger.Error().Err(err).Msg("marshal manifest")
-		back.Sleep()
-		return
-	}
-	manifestPart, err := writer.CreateFormField("manifest")
-	if err != nil {
-		logger.Error().Err(err).Msg("create manifest field")
-		back.Sleep()
-		return
-	}
-	if _, err := manifestPart.Write(manifestJSON); err != nil {
-		logger.Error().Err(err).Msg("write manifest field")
-		back.Sleep()
+	if !runBeforeSend(cfg.PluginHooks, sendInfo(curIdxBase, *batch)) {
 		return
 	}
 
-	framesPart, err := writer.CreateFormFile("frames", curIdxBase)
-	if err != nil {
-		logger.Error().Err(err).Msg("create frames field")
-		back.Sleep()
-		return
-	}
-	for _, fr := range *batch {
-		if _, err := framesPart.Write(fr.Compressed); err != nil {
-			logger.Error().Err(err).Msg("write frames payload")
-			back.Sleep()
-			return
+	var sent int
+	var err error
+	start := time.Now()
+	path := sendPathStable
+	if cfg.DryRun {
+		sent = reportDryRun(cfg, *batch, curIdxBase)
+	} else if canaryBatch(cfg) {
+		path = sendPathCanary
+		sent, err = sendCanary(cfg, p, httpClient, st, *batch, curIdxBase)
+	} else if gs := p.activeGRPC(); gs != nil {
+		span := p.traceSend(*batch, curIdxBase, "grpc")
+		sent, err = sendGRPC(cfg, gs, *batch, curIdxBase)
+		endSendSpan(span, sent, err)
+	} else if ks := p.activeKafka(); ks != nil {
+		span := p.traceSend(*batch, curIdxBase, "kafka")
+		sent, err = sendKafka(cfg, ks, *batch, curIdxBase)
+		endSendSpan(span, sent, err)
+	} else if obj := p.activeObjectStore(); obj != nil {
+		span := p.traceSend(*batch, curIdxBase, "object-store")
+		sent, err = sendObjectStore(cfg, obj, *batch, curIdxBase)
+		endSendSpan(span, sent, err)
+	} else if cfg.ResumableUploadBytes > 0 && *batchBytes >= cfg.ResumableUploadBytes {
+		span := p.traceSend(*batch, curIdxBase, "resumable")
+		if err = sendResumable(cfg, tracedClient(httpClient, span.Context()), *batch, curIdxBase, st); err == nil {
+			sent = len(*batch)
 		}
+		endSendSpan(span, sent, err)
+	} else {
+		span := p.traceSend(*batch, curIdxBase, "http")
+		sent, err = sendSplitting(cfg, tracedClient(httpClient, span.Context()), *batch, curIdxBase, newBatchJournal(cfg, st))
+		endSendSpan(span, sent, err)
 	}
-	if err := writer.Close(); err != nil {
-		logger.Error().Err(err).Msg("finalize multipart payload")
-		back.Sleep()
-		return
+	metricSendDuration.Observe(time.Since(start).Seconds())
+	if !cfg.DryRun {
+		observeCanary(cfg, path, err)
 	}
-
-	req, err := http.NewRequest(http.MethodPost, url, &body)
-	if err != nil {
-		return
+	accepted := sendInfo(curIdxBase, (*batch)[:sent])
+	if sent > 0 {
+		commitBatch(cfg, st, (*batch)[:sent], curIdxBase)
+		shipDecoded(cfg, httpClient, (*batch)[:sent], curIdxBase)
+		for _, fr := range (*batch)[:sent] {
+			*batchBytes -= len(fr.Compressed)
+		}
+		n := copy(*batch, (*batch)[sent:])
+		*batch = (*batch)[:n]
+		if n == 0 {
+			*batchBytes = 0
+		}
 	}
-	req.Header.Set("Authorization", "Bearer "+cfg.AuthKey)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("X-Agent-Hostname", hostname())
-	req.Header.Set("X-Agent-OSArch", runtime.GOOS+"/"+runtime.GOARCH)
-	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", cfg.ChainID)
-	req.Header.Set("X-Cosmos-Analyzer-Node-Id", cfg.NodeID)
-
-	resp, err := httpClient.Do(req)
+	runAfterSend(cfg.PluginHooks, accepted, err)
+	p.traceSent(len(*batch))
 	if err != nil {
-		logger.Error().Err(err).Msg("send batch")
+		var se *statusError
+		if errors.As(err, &se) {
+			logServerError(logger.Error(), err).Msg("server returned error")
+		} else {
+			logger.Error().Err(err).Msg("send batch")
+		}
+		recordEvent(EventError, "send batch: "+err.Error())
+		metricSendRetries.Inc()
+		if sp != nil && len(*batch) > 0 {
+			spoolPending(cfg, sp, st, batch, batchBytes, curIdxBase)
+			p.traceSent(len(*batch))
+		}
 		back.Sleep()
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode/100 != 2 {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Error().
-			Int("status", resp.StatusCode).
-			Str("body", string(body)).
-			Msg("server returned error")
-		back.Sleep()
-		return
+	back.Reset()
+}
+
+// commitBatch advances the committed index offset past frames, which the
+// service has accepted, and persists the new state.
+func commitBatch(cfg Config, st *state, frames []batchFrame, curIdxBase string) {
+	manifest := make([]FrameMeta, 0, len(frames))
+	var advance int64
+	var bytes int
+	shipped := activePipeline(cfg).shippedFrames()
+	for _, fr := range frames {
+		manifest = append(manifest, fr.Meta)
+		advance += int64(fr.IdxLineLen)
+		bytes += len(fr.Compressed)
+		shipped.Add(fr.Hash)
 	}
+	startOffset := st.IdxOffset
 
 	logger.Info().
-		Int("frames", len(*batch)).
-		Int("bytes", *batchBytes).
+		Int("frames", len(frames)).
+		Int("bytes", bytes).
+		Str("segment", curIdxBase).
+		Int64("start_offset", startOffset).
+		Int64("end_offset", startOffset+advance).
 		Msg("sent batch")
+	observeSent(frames)
+	observeAckLatency(cfg, frames, time.Now())
+	addFrameTypes(frames)
+	recordEvent(EventSend, fmt.Sprintf("sent %d frames (%d bytes) from %s", len(frames), bytes, curIdxBase))
 
 	// Success: commit idx offset
 	st.IdxOffset += advance
+	if st.Read != nil && st.IdxOffset >= st.Read.IdxOffset {
+		st.Read = nil
+	}
 	st.LastFile = manifest[len(manifest)-1].File
 	st.LastFrame = manifest[len(manifest)-1].Frame
-	st.LastSendAt = time.Now()
+	st.LastSendAt = time.Now().UTC()
 	st.LastCommitAt = st.LastSendAt
+	st.Shipped.add(len(frames), bytes)
+	pruneJournal(st)
 	_ = saveState(cfg.StateDir, *st)
+	noteShipped(cfg.StateDir, st.Shipped)
 
-	// reset batch
-	*batch = (*batch)[:0]
-	*batchBytes = 0
-	back.Reset()
+	ev := newSendSuccessEvent(curIdxBase, manifest, startOffset, st.IdxOffset, bytes, st.LastSendAt)
+	if cfg.OnSendSuccess != nil {
+		ev.MinHeight, ev.MaxHeight = batchHeights(frames)
+	}
+	recordDelivery(cfg, ev, frames, false)
+	if cfg.OnSendSuccess != nil {
+		cfg.OnSendSuccess(ev)
+	}
+}
+
+// commitReadPosition persists the position just past the last frame read,
+// ahead of the unacknowledged frames still in batch, as st.Read. It is kept
+// by every later save until the frames are acknowledged, and a crash loses
+// those frames instead of resending them.
+func commitReadPosition(cfg Config, st *state, batch []batchFrame) {
+	if len(batch) == 0 {
+		return
+	}
+	r := readPosition{IdxPath: st.IdxPath, IdxOffset: st.IdxOffset}
+	for _, fr := range batch {
+		r.IdxOffset += int64(fr.IdxLineLen)
+	}
+	last := batch[len(batch)-1].Meta
+	r.LastFile = last.File
+	r.LastFrame = last.Frame
+	st.Read = &r
+	st.LastCommitAt = time.Now().UTC()
+	_ = saveState(cfg.StateDir, *st)
 }
 
 func hostname() string {
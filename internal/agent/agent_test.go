@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -448,3 +450,194 @@ func TestTrySend_URLConstruction(t *testing.T) {
 		t.Errorf("Request path = %v, want %v", requestPath, expectedPath)
 	}
 }
+
+func TestRun_ReadyWhileConfigUploadHangs(t *testing.T) {
+	// The config endpoint never answers; the WAL pipeline must still come up.
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == configEndpoint {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	tmpDir := t.TempDir()
+	walDir := filepath.Join(tmpDir, "wal")
+	if err := os.MkdirAll(filepath.Join(tmpDir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(walDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.idx"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		NodeHome:     tmpDir,
+		WALDir:       walDir,
+		ServiceURL:   ts.URL,
+		PollInterval: time.Millisecond,
+		StateDir:     filepath.Join(tmpDir, ".walship"),
+		ShipConfig:   true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !CurrentStats().Ready {
+		if time.Now().After(deadline) {
+			t.Fatal("Run did not become ready while config upload was pending")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v", err)
+	}
+	if CurrentStats().Ready {
+		t.Error("Ready should be cleared after Run returns")
+	}
+}
+
+func TestTrySend_OnSendSuccess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var events []SendSuccessEvent
+	cfg := Config{
+		ServiceURL:    ts.URL,
+		StateDir:      t.TempDir(),
+		OnSendSuccess: func(ev SendSuccessEvent) { events = append(events, ev) },
+	}
+
+	proposal := func(h int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/ProposalMessage","value":{"proposal":{"type":32,"height":"%d","round":0,"pol_round":-1}}},"peer_key":""}}}`, h)
+	}
+	f1, f2 := gzipFrame(t, proposal(12)), gzipFrame(t, proposal(11), proposal(13))
+	batch := []batchFrame{
+		{Meta: FrameMeta{File: "seg-000003.wal.gz", Frame: 7, FirstTS: 100, LastTS: 150}, Compressed: f1, IdxLineLen: 20},
+		{Meta: FrameMeta{File: "seg-000003.wal.gz", Frame: 8, FirstTS: 160, LastTS: 200}, Compressed: f2, IdxLineLen: 30},
+	}
+	wantBytes := len(f1) + len(f2)
+	batchBytes := wantBytes
+	st := state{IdxOffset: 50}
+	back := newBackoff(time.Millisecond, time.Second)
+
+	trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "seg-000003.wal.idx", nil, time.Now(), back)
+
+	if len(events) != 1 {
+		t.Fatalf("events = %d, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Segment != "seg-000003.wal.idx" || ev.File != "seg-000003.wal.gz" {
+		t.Errorf("segment/file = %s/%s", ev.Segment, ev.File)
+	}
+	if ev.StartOffset != 50 || ev.EndOffset != 100 {
+		t.Errorf("offsets = %d..%d, want 50..100", ev.StartOffset, ev.EndOffset)
+	}
+	if ev.FirstFrame != 7 || ev.LastFrame != 8 {
+		t.Errorf("frames = %d..%d, want 7..8", ev.FirstFrame, ev.LastFrame)
+	}
+	if ev.FirstTS != 100 || ev.LastTS != 200 {
+		t.Errorf("timestamps = %d..%d, want 100..200", ev.FirstTS, ev.LastTS)
+	}
+	if ev.MinHeight != 11 || ev.MaxHeight != 13 {
+		t.Errorf("heights = %d..%d, want 11..13", ev.MinHeight, ev.MaxHeight)
+	}
+	if ev.Frames != 2 || ev.Bytes != wantBytes || ev.SentAt.IsZero() {
+		t.Errorf("event = %+v", ev)
+	}
+}
+
+func TestRun_ReleasesWALWhenPinned(t *testing.T) {
+	defer func(f func() bool) { walPinsOpenFiles = f }(walPinsOpenFiles)
+	walPinsOpenFiles = func() bool { return true }
+
+	walDir := t.TempDir()
+	// writeSeg writes segment seg with frames 1 to n, as the node would
+	// have appended them.
+	writeSeg := func(seg, n int) {
+		name := fmt.Sprintf("seg-%06d.wal", seg)
+		var data []byte
+		var metas []FrameMeta
+		for i := 1; i <= n; i++ {
+			b := gzipFrame(t, fmt.Sprintf(`{"seg":%d,"height":%d}`, seg, i))
+			metas = append(metas, FrameMeta{File: name + ".gz", Frame: uint64(i), Off: uint64(len(data)), Len: uint64(len(b))})
+			data = append(data, b...)
+		}
+		if err := os.WriteFile(filepath.Join(walDir, name+".gz"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		writeIdx(t, filepath.Join(walDir, name+".idx"), metas)
+	}
+	writeSeg(1, 2)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	var mu sync.Mutex
+	var sent []string
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), PollInterval: time.Millisecond,
+		MaxPollInterval: time.Millisecond, SendInterval: time.Millisecond, HardInterval: time.Millisecond,
+		OnSendSuccess: func(ev SendSuccessEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			for f := ev.FirstFrame; f <= ev.LastFrame; f++ {
+				sent = append(sent, fmt.Sprintf("%s/%d", ev.Segment, f))
+			}
+		}}
+	dups := CurrentStats().DuplicateFrames
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitSent := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			got := len(sent)
+			mu.Unlock()
+			if got >= n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		t.Fatalf("sent %v, want %d frames", sent, n)
+	}
+	waitSent(2)
+	// A frame appended to the segment read, then a rotation pruning it.
+	writeSeg(1, 3)
+	waitSent(3)
+	writeSeg(2, 1)
+	for _, ext := range []string{"idx", "gz"} {
+		if err := os.Remove(filepath.Join(walDir, "seg-000001.wal."+ext)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSent(4)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"seg-000001.wal.idx/1", "seg-000001.wal.idx/2", "seg-000001.wal.idx/3", "seg-000002.wal.idx/1"}
+	if fmt.Sprint(sent) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	// Reopened indexes resume at the read position, not re-reading frames.
+	if n := CurrentStats().DuplicateFrames - dups; n != 0 {
+		t.Errorf("%d frames read twice", n)
+	}
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+
+	"github.com/bft-labs/walship/pkg/batch"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// peerKeyRe matches the peer a consensus message was received from. It is
+// the only node-identifying field in WAL records; validator addresses and
+// signatures are public on chain and are left as they are.
+var peerKeyRe = regexp.MustCompile(`"peer_key":"([^"]+)"`)
+
+// anonymizeID replaces id with a keyed hash. The same salt always maps an ID
+// to the same value, so records stay linkable within one operator's data
+// without revealing the ID itself.
+func anonymizeID(salt, id string) string {
+	if id == "" {
+		return ""
+	}
+	m := hmac.New(sha256.New, []byte(salt))
+	m.Write([]byte(id))
+	return "anon-" + hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+// anonymizeFrame rewrites the peer IDs in a compressed frame. Frames without
+// peer IDs are returned unchanged; rewritten frames are recompressed and fm's
+// length and checksum updated to match.
+func anonymizeFrame(cfg Config, fm FrameMeta, compressed []byte) (FrameMeta, []byte, error) {
+	raw, err := wal.Decompress(compressed)
+	if err != nil {
+		return fm, nil, fmt.Errorf("decompress frame: %w", err)
+	}
+	out := peerKeyRe.ReplaceAllFunc(raw, func(m []byte) []byte {
+		id := peerKeyRe.FindSubmatch(m)[1]
+		return []byte(`"peer_key":"` + anonymizeID(cfg.AnonymizeSalt, string(id)) + `"`)
+	})
+	if bytes.Equal(out, raw) {
+		return fm, compressed, nil
+	}
+	b, err := batch.Gzip(out, cfg.CompressionLevel)
+	if err != nil {
+		return fm, nil, fmt.Errorf("compress frame: %w", err)
+	}
+	fm.Len = uint64(len(b))
+	fm.CRC32 = crc32.ChecksumIEEE(out)
+	return fm, b, nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func TestAnonymizeID(t *testing.T) {
+	a := anonymizeID("salt", "node1")
+	if a != anonymizeID("salt", "node1") {
+		t.Error("anonymizeID should be deterministic")
+	}
+	if a == anonymizeID("other", "node1") || a == anonymizeID("salt", "node2") {
+		t.Error("anonymizeID should depend on salt and ID")
+	}
+	if strings.Contains(a, "node1") || !strings.HasPrefix(a, "anon-") {
+		t.Errorf("anonymizeID = %q", a)
+	}
+	if anonymizeID("salt", "") != "" {
+		t.Error("empty ID should stay empty")
+	}
+}
+
+func TestAnonymizeFrame(t *testing.T) {
+	cfg := Config{AnonymizeSalt: "salt", CompressionLevel: DefaultCompressionLevel}
+
+	orig := gzipFrame(t, `{"msg":{"value":{"peer_key":"deadbeef"}}}`, `{"msg":{"value":{"peer_key":""}}}`)
+	fm, b, err := anonymizeFrame(cfg, FrameMeta{Len: uint64(len(orig))}, orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := wal.Decompress(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "deadbeef") || !strings.Contains(string(raw), anonymizeID("salt", "deadbeef")) {
+		t.Errorf("peer ID not replaced: %s", raw)
+	}
+	if fm.Len != uint64(len(b)) || fm.CRC32 != crc32.ChecksumIEEE(raw) {
+		t.Errorf("meta not updated: %+v", fm)
+	}
+
+	plain := gzipFrame(t, `{"msg":{"type":"tendermint/wal/TimeoutInfo"}}`)
+	if _, b, _ := anonymizeFrame(cfg, FrameMeta{}, plain); &b[0] != &plain[0] {
+		t.Error("frame without peer IDs should be returned unchanged")
+	}
+
+	if _, _, err := anonymizeFrame(cfg, FrameMeta{}, []byte("not gzip")); err == nil {
+		t.Error("expected error for corrupt frame")
+	}
+}
+
+func TestRun_Anonymize(t *testing.T) {
+	frame := gzipFrame(t, `{"msg":{"value":{"peer_key":"deadbeef"}}}`)
+	walDir := t.TempDir()
+	gz := append(append([]byte{}, frame...), "corrupt"...)
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), gz, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lens := writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(frame))},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: uint64(len(frame)), Len: 7},
+	})
+
+	var mu sync.Mutex
+	var nodeID, host, payload string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		nodeID, host = r.Header.Get("X-Cosmos-Analyzer-Node-Id"), r.Header.Get("X-Agent-Hostname")
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "frames" {
+				b, _ := io.ReadAll(part)
+				raw, _ := wal.Decompress(b)
+				payload = string(raw)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond,
+		NodeID: "node1", Anonymize: true, AnonymizeSalt: "salt", CompressionLevel: DefaultCompressionLevel}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if nodeID != anonymizeID("salt", "node1") || host != "" {
+		t.Errorf("node ID header = %q, hostname = %q", nodeID, host)
+	}
+	if strings.Contains(payload, "deadbeef") || !strings.Contains(payload, anonymizeID("salt", "deadbeef")) {
+		t.Errorf("uploaded frame not anonymized: %s", payload)
+	}
+	st, _ := loadState(cfg.StateDir)
+	if st.LastFrame != 1 || st.IdxOffset != int64(lens[0]+lens[1]) {
+		t.Errorf("state = frame %d offset %d; want frame 1 and the corrupt frame skipped", st.LastFrame, st.IdxOffset)
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// walArchive copies WAL segments to cold storage before cleanup deletes them.
+// A nil *walArchive archives nothing.
+type walArchive struct {
+	dir   string
+	after time.Duration
+}
+
+// newWALArchive returns the archive configured by cfg, or nil if ArchiveDir
+// is unset.
+func newWALArchive(cfg Config) *walArchive {
+	if cfg.ArchiveDir == "" {
+		return nil
+	}
+	return &walArchive{dir: cfg.ArchiveDir, after: cfg.ArchiveAfter}
+}
+
+// ageBased reports whether segments are archived by age as well as by size.
+func (a *walArchive) ageBased() bool {
+	return a != nil && a.after > 0
+}
+
+// expired reports whether seg was last written more than ArchiveAfter ago.
+func (a *walArchive) expired(seg walSegment, now time.Time) bool {
+	return a.ageBased() && now.Sub(seg.modTime) > a.after
+}
+
+// archive copies seg's .gz and .idx to the same day dir under the archive
+// and verifies the copies. Cleanup keeps a segment that fails to archive.
+func (a *walArchive) archive(seg walSegment) error {
+	if a == nil {
+		return nil
+	}
+	dst := filepath.Join(a.dir, seg.day)
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return fmt.Errorf("create archive dir: %w", err)
+	}
+	for _, src := range []string{seg.gzPath, seg.idxPath} {
+		if src == "" {
+			continue
+		}
+		if err := archiveFile(src, filepath.Join(dst, filepath.Base(src))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveFile copies src to dst through a temporary file, syncs it, and
+// re-reads dst to check that its SHA-256 matches src. An existing dst with
+// the same content is left alone, so an interrupted cleanup can rerun.
+func archiveFile(src, dst string) error {
+	want, err := fileSHA256(src)
+	if err != nil {
+		return err
+	}
+	if got, err := fileSHA256(dst); err == nil && bytes.Equal(got, want) {
+		return nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("check archived %s: %w", dst, err)
+	}
+
+	in, err := openReadOnly(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("archive %s: %w", src, err)
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("archive %s: %w", src, err)
+	}
+	if d, err := os.Open(filepath.Dir(dst)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+
+	got, err := fileSHA256(dst)
+	if err != nil {
+		return fmt.Errorf("verify archived %s: %w", dst, err)
+	}
+	if !bytes.Equal(got, want) {
+		os.Remove(dst)
+		return fmt.Errorf("verify archived %s: checksum mismatch", dst)
+	}
+	return nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWalCleanup_Archive(t *testing.T) {
+	tests := []struct {
+		name         string
+		high, low    int64
+		after        time.Duration
+		brokenTarget bool
+		wantArchived []string // segments archived and removed, as day/base
+		wantKept     []string
+	}{
+		{name: "size based", high: 300, low: 150,
+			wantArchived: []string{"2025-12-05/seg-000001", "2025-12-05/seg-000002"},
+			wantKept:     []string{"2025-12-06/seg-000001"}},
+		{name: "age based below watermark", high: 1 << 40, low: 1 << 39, after: time.Hour,
+			wantArchived: []string{"2025-12-05/seg-000001"},
+			wantKept:     []string{"2025-12-05/seg-000002", "2025-12-06/seg-000001"}},
+		{name: "archive failure keeps segments", high: 300, low: 150, brokenTarget: true,
+			wantKept: []string{"2025-12-05/seg-000001", "2025-12-05/seg-000002", "2025-12-06/seg-000001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(patchCleanupThresholds(tt.high, tt.low))
+			walDir, archDir := t.TempDir(), filepath.Join(t.TempDir(), "archive")
+			createSegment(t, filepath.Join(walDir, "2025-12-05"), "seg-000001", 120, 10)
+			createSegment(t, filepath.Join(walDir, "2025-12-05"), "seg-000002", 120, 10)
+			createSegment(t, filepath.Join(walDir, "2025-12-06"), "seg-000001", 120, 10)
+			old := time.Now().Add(-2 * time.Hour)
+			if err := os.Chtimes(filepath.Join(walDir, "2025-12-05", "seg-000001.wal.gz"), old, old); err != nil {
+				t.Fatal(err)
+			}
+			if tt.brokenTarget {
+				if err := os.WriteFile(archDir, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			walCleanupOnce(context.Background(), walDir, walDir, newWALArchive(Config{ArchiveDir: archDir, ArchiveAfter: tt.after}))
+
+			for _, seg := range tt.wantArchived {
+				for _, ext := range []string{".wal.gz", ".wal.idx"} {
+					if pathExists(filepath.Join(walDir, seg+ext)) {
+						t.Errorf("%s%s still in the WAL dir", seg, ext)
+					}
+				}
+				got, err := os.ReadFile(filepath.Join(archDir, seg+".wal.gz"))
+				if err != nil || !bytes.Equal(got, bytes.Repeat([]byte{0}, 120)) {
+					t.Errorf("archived %s.wal.gz = %d bytes, %v", seg, len(got), err)
+				}
+				if !pathExists(filepath.Join(archDir, seg+".wal.idx")) {
+					t.Errorf("%s.wal.idx not archived", seg)
+				}
+			}
+			for _, seg := range tt.wantKept {
+				if !pathExists(filepath.Join(walDir, seg+".wal.gz")) {
+					t.Errorf("%s removed, want kept", seg)
+				}
+				if pathExists(filepath.Join(archDir, seg+".wal.gz")) {
+					t.Errorf("%s archived, want not", seg)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveFile_ReplacesDifferentCopy(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("frames"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := archiveFile(src, dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "frames" {
+		t.Errorf("dst = %q, want the source content", got)
+	}
+	if pathExists(dst + ".tmp") {
+		t.Error("temporary file left behind")
+	}
+}
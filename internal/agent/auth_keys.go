@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseAuthKeys parses a comma-separated list of chain-id=key pairs.
+func ParseAuthKeys(list string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		chain, key, ok := strings.Cut(pair, "=")
+		chain, key = strings.TrimSpace(chain), strings.TrimSpace(key)
+		if !ok || chain == "" || key == "" {
+			return nil, fmt.Errorf("auth keys: want chain-id=key, got %q", pair)
+		}
+		keys[chain] = key
+	}
+	return keys, nil
+}
+
+// authKeyFor returns the credential for uploads tagged with chainID: its
+// entry in AuthKeys if there is one, otherwise AuthKey.
+func authKeyFor(cfg Config, chainID string) string {
+	if k, ok := cfg.AuthKeys[chainID]; ok {
+		return k
+	}
+	return cfg.AuthKey
+}
+
+func validateAuthKeys(c *Config) error {
+	for chain, key := range c.AuthKeys {
+		if chain == "" || key == "" {
+			return fmt.Errorf("auth keys: empty chain id or key")
+		}
+	}
+	if len(c.AuthKeys) > 0 && c.ChainID != "" && authKeyFor(*c, c.ChainID) == "" {
+		return fmt.Errorf("auth keys: no key for chain %q and no default auth-key", c.ChainID)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseAuthKeys(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"osmosis-1=k1", map[string]string{"osmosis-1": "k1"}, false},
+		{" osmosis-1 = k1 ,cosmoshub-4=k2,", map[string]string{"osmosis-1": "k1", "cosmoshub-4": "k2"}, false},
+		{"osmosis-1", nil, true},
+		{"=k1", nil, true},
+		{"osmosis-1=", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAuthKeys(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAuthKeys(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseAuthKeys(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("ParseAuthKeys(%q)[%s] = %q, want %q", tt.in, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestValidateAuthKeys(t *testing.T) {
+	keys := map[string]string{"osmosis-1": "k1"}
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"chain has key", Config{ChainID: "osmosis-1", AuthKeys: keys}, false},
+		{"fallback to default", Config{ChainID: "cosmoshub-4", AuthKey: "k", AuthKeys: keys}, false},
+		{"no key for chain", Config{ChainID: "cosmoshub-4", AuthKeys: keys}, true},
+		{"chain unknown yet", Config{AuthKeys: keys}, false},
+		{"empty key", Config{AuthKeys: map[string]string{"osmosis-1": ""}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateAuthKeys(&tt.cfg); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPostBatch_UsesChainAuthKey(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	frames := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1}, Compressed: []byte("x")}}
+	for _, tt := range []struct {
+		chain, want string
+	}{
+		{"osmosis-1", "Bearer k1"},
+		{"cosmoshub-4", "Bearer default"},
+	} {
+		cfg := Config{ServiceURL: ts.URL, ChainID: tt.chain, AuthKey: "default", AuthKeys: map[string]string{"osmosis-1": "k1"}}
+		if err := postBatch(context.Background(), cfg, ts.Client(), frames, "seg-000001.wal.idx"); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("chain %s: Authorization = %q, want %q", tt.chain, got, tt.want)
+		}
+	}
+}
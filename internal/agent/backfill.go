@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// backfillHeader marks frame uploads made by Backfill, so the service can
+// tell them from live data.
+const backfillHeader = "X-Cosmos-Analyzer-Backfill"
+
+// BackfillResult counts the frames Backfill shipped.
+type BackfillResult struct {
+	Frames int `json:"frames"`
+	Bytes  int `json:"bytes"`
+}
+
+// Backfill ships the raw frames covering heights from through to, read from
+// cfg.ArchiveDir (if set) and then cfg.WALDir, as backfill uploads. Frames
+// found in both are sent once. Heights the service lists at
+// backfillPrioritiesEndpoint are shipped first, and the list is checked
+// again every backfillChunkHeights heights. The shipping position is left
+// untouched.
+func Backfill(ctx context.Context, cfg Config, from, to int64) (BackfillResult, error) {
+	var res BackfillResult
+	if err := checkHeightRange(from, to); err != nil {
+		return res, err
+	}
+	var dirs []string
+	for _, dir := range []string{cfg.ArchiveDir, cfg.WALDir} {
+		if dir == "" {
+			continue
+		}
+		if _, err := wal.OldestIndex(dir); err != nil {
+			logger.Debug().Err(err).Str("dir", dir).Msg("backfill: skipping dir without WAL")
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) == 0 {
+		return res, fmt.Errorf("backfill: no WAL index files in the archive or WAL dir")
+	}
+
+	// Frames the running agent shipped recently are not sent again.
+	shipped, err := loadFrameCache(cfg.StateDir, dedupCacheFrames)
+	if err != nil {
+		return res, fmt.Errorf("backfill: shipped frames: %w", err)
+	}
+
+	httpClient := newHTTPClient(cfg)
+	httpClient.Transport = headerTransport{next: httpClient.Transport, key: backfillHeader, value: "true"}
+	var (
+		pending      []batchFrame
+		pendingBytes int
+		segment      string
+		seen         = map[frameHash]bool{}
+	)
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		n, err := sendSplitting(cfg, httpClient, pending, segment, nil)
+		res.Frames += n
+		res.Bytes += framesBytes(pending[:n])
+		if err != nil {
+			return fmt.Errorf("backfill %s: %w", segment, err)
+		}
+		pending, pendingBytes = nil, 0
+		return nil
+	}
+	visit := func(fr wal.Frame, _ []consensus.Event) error {
+		throttleRead(ctx, cfg, len(fr.Compressed))
+		h := hashFrame(fr.Compressed)
+		if seen[h] || shipped.Contains(h) {
+			return nil
+		}
+		seen[h] = true
+		fm, b := fr.Meta, fr.Compressed
+		if cfg.Anonymize {
+			var err error
+			if fm, b, err = anonymizeFrame(cfg, fm, b); err != nil {
+				logger.Warn().Err(err).Str("file", fm.File).Uint64("frame", fm.Frame).Msg("backfill: dropping frame that cannot be anonymized")
+				return nil
+			}
+		}
+		bf := batchFrame{Meta: fm, Compressed: b, IdxLineLen: fr.LineLen, Hash: h}
+		if cfg.FrameTypeStats {
+			bf.Types = frameTypes(b)
+		}
+
+		seg := filepath.Base(fr.Index)
+		if seg != segment || (cfg.MaxBatchBytes > 0 && pendingBytes+len(b) > cfg.MaxBatchBytes) {
+			if err := flush(); err != nil {
+				return err
+			}
+			segment = seg
+		}
+		pending = append(pending, bf)
+		pendingBytes += len(b)
+		return nil
+	}
+
+	// The heights are shipped in chunks, and the service asked before each
+	// which heights it wants first.
+	plan := newBackfillPlan(from, to)
+	askPriorities := true
+	for {
+		var priorities []heightRange
+		if askPriorities {
+			var err error
+			if priorities, err = fetchBackfillPriorities(ctx, cfg, httpClient); err != nil {
+				logServerError(logger.Warn().Err(err), err).Msg("backfill: no priorities from the service; shipping in height order")
+				askPriorities = false
+			}
+		}
+		r, ok := plan.next(priorities)
+		if !ok {
+			return res, nil
+		}
+		logger.Debug().Int64("from", r.From).Int64("to", r.To).Msg("backfill: shipping heights")
+		for _, dir := range dirs {
+			if err := walkHeights(ctx, dir, r.From, r.To, visit); err != nil {
+				return res, err
+			}
+		}
+		if err := flush(); err != nil {
+			return res, err
+		}
+		plan.done(r)
+	}
+}
+
+// headerTransport sets one header on every request.
+type headerTransport struct {
+	next       http.RoundTripper
+	key, value string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.key, t.value)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// backfillPrioritiesEndpoint is where the service lists the height ranges it
+// wants backfilled first, most wanted first:
+//
+//	GET {base}/v1/ingest/backfill/priorities -> {"ranges": [{"from": 100, "to": 120}]}
+//
+// A 404 means the service sets no priorities.
+const backfillPrioritiesEndpoint = "/v1/ingest/backfill/priorities"
+
+// backfillChunkHeights is how many heights Backfill ships between checks
+// for new priorities.
+var backfillChunkHeights int64 = 500
+
+// heightRange is an inclusive range of block heights.
+type heightRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// backfillPlan is the work left to a backfill: disjoint height ranges in
+// ascending order.
+type backfillPlan struct {
+	left []heightRange
+}
+
+func newBackfillPlan(from, to int64) *backfillPlan {
+	return &backfillPlan{left: []heightRange{{from, to}}}
+}
+
+// next returns the next range to ship, at most backfillChunkHeights long:
+// the first part of the first priority that is still to do, or else the
+// lowest heights left.
+func (p *backfillPlan) next(priorities []heightRange) (heightRange, bool) {
+	if len(p.left) == 0 {
+		return heightRange{}, false
+	}
+	r := p.left[0]
+	found := false
+	for _, want := range priorities {
+		for _, l := range p.left {
+			if want.From <= l.To && want.To >= l.From {
+				r, found = heightRange{max(want.From, l.From), min(want.To, l.To)}, true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if backfillChunkHeights > 0 && r.To-r.From >= backfillChunkHeights {
+		r.To = r.From + backfillChunkHeights - 1
+	}
+	return r, true
+}
+
+// done removes r from the work left.
+func (p *backfillPlan) done(r heightRange) {
+	var left []heightRange
+	for _, l := range p.left {
+		if r.To < l.From || r.From > l.To {
+			left = append(left, l)
+			continue
+		}
+		if l.From < r.From {
+			left = append(left, heightRange{l.From, r.From - 1})
+		}
+		if l.To > r.To {
+			left = append(left, heightRange{r.To + 1, l.To})
+		}
+	}
+	p.left = left
+}
+
+// fetchBackfillPriorities asks the service which heights it wants
+// backfilled first.
+func fetchBackfillPriorities(ctx context.Context, cfg Config, httpClient *http.Client) ([]heightRange, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ingestURL(cfg, backfillPrioritiesEndpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, newStatusError(resp)
+	}
+	var body struct {
+		Ranges []heightRange `json:"ranges"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode priorities: %w", err)
+	}
+	var ranges []heightRange
+	for _, r := range body.Ranges {
+		if checkHeightRange(r.From, r.To) == nil {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges, nil
+}
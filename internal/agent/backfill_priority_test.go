@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBackfillPlan(t *testing.T) {
+	defer func(n int64) { backfillChunkHeights = n }(backfillChunkHeights)
+	backfillChunkHeights = 10
+
+	p := newBackfillPlan(1, 30)
+	var got []heightRange
+	steps := [][]heightRange{
+		{{25, 40}},         // clipped to the heights left
+		{{25, 40}, {5, 6}}, // the first priority is done
+		nil,                // in height order, in chunks
+		{{100, 200}},       // outside the backfill
+		{{1, 30}},
+		{{1, 30}},
+	}
+	for _, priorities := range steps {
+		r, ok := p.next(priorities)
+		if !ok {
+			break
+		}
+		got = append(got, r)
+		p.done(r)
+	}
+	if want := "[{25 30} {5 6} {1 4} {7 16} {17 24}]"; fmt.Sprint(got) != want {
+		t.Errorf("chunks = %v, want %s", got, want)
+	}
+	if _, ok := p.next(nil); ok {
+		t.Errorf("work left: %v", p.left)
+	}
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	vote := func(height int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,"height":"%d","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`, height)
+	}
+	// writeSegment writes one frame per height to dir/seg-<seg>.
+	writeSegment := func(dir string, seg int, heights ...int) {
+		name := fmt.Sprintf("seg-%06d.wal", seg)
+		var gz []byte
+		var metas []FrameMeta
+		for _, h := range heights {
+			f := gzipFrame(t, vote(h))
+			metas = append(metas, FrameMeta{File: name + ".gz", Frame: uint64(h), Off: uint64(len(gz)), Len: uint64(len(f))})
+			gz = append(gz, f...)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".gz"), gz, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		writeIdx(t, filepath.Join(dir, name+".idx"), metas)
+	}
+	archiveDir, walDir := t.TempDir(), t.TempDir()
+	writeSegment(archiveDir, 1, 1, 2, 3)
+	writeSegment(walDir, 2, 3, 4, 5, 6) // height 3 is also archived
+
+	var mu sync.Mutex
+	var frames []string
+	var priorities string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(backfillHeader) != "true" {
+			t.Errorf("%s = %q, want true", backfillHeader, r.Header.Get(backfillHeader))
+		}
+		if r.URL.Path == backfillPrioritiesEndpoint {
+			mu.Lock()
+			defer mu.Unlock()
+			if priorities == "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(priorities))
+			return
+		}
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("parse multipart form: %v", err)
+			return
+		}
+		var manifest []FrameMeta
+		if err := json.Unmarshal([]byte(r.FormValue("manifest")), &manifest); err != nil {
+			t.Errorf("manifest: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, fm := range manifest {
+			frames = append(frames, fmt.Sprintf("%s#%d", fm.File, fm.Frame))
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		archive    string
+		from, to   int64
+		priorities string
+		shipped    []int // heights the running agent shipped
+		want       []string
+	}{
+		{name: "archive and WAL", archive: archiveDir, from: 2, to: 5,
+			want: []string{"seg-000001.wal.gz#2", "seg-000001.wal.gz#3", "seg-000002.wal.gz#4", "seg-000002.wal.gz#5"}},
+		{name: "WAL only", from: 2, to: 4,
+			want: []string{"seg-000002.wal.gz#3", "seg-000002.wal.gz#4"}},
+		{name: "skips frames the agent shipped", from: 2, to: 5, shipped: []int{4},
+			want: []string{"seg-000002.wal.gz#3", "seg-000002.wal.gz#5"}},
+		{name: "priorities first", archive: archiveDir, from: 1, to: 6, priorities: `{"ranges": [{"from": 5, "to": 9}, {"from": 2, "to": 2}]}`,
+			want: []string{"seg-000002.wal.gz#5", "seg-000002.wal.gz#6", "seg-000001.wal.gz#2", "seg-000001.wal.gz#1",
+				"seg-000001.wal.gz#3", "seg-000002.wal.gz#4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			frames, priorities = nil, tt.priorities
+			mu.Unlock()
+			stateDir := t.TempDir()
+			for _, h := range tt.shipped {
+				seedShippedFrames(t, stateDir, gzipFrame(t, vote(h)))
+			}
+			cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, ArchiveDir: tt.archive, SendMaxAttempts: 1}
+			res, err := Backfill(context.Background(), cfg, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if res.Frames != len(tt.want) || strings.Join(frames, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("backfilled %d %v, want %v", res.Frames, frames, tt.want)
+			}
+		})
+	}
+
+	if _, err := Backfill(context.Background(), Config{ServiceURL: ts.URL, WALDir: t.TempDir()}, 1, 2); err == nil {
+		t.Error("Backfill without any WAL succeeded")
+	}
+}
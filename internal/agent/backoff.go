@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"math/rand"
 	"time"
 )
@@ -13,7 +14,8 @@ type backoff struct {
 
 func newBackoff(base, max time.Duration) *backoff { return &backoff{base: base, max: max} }
 
-func (b *backoff) Sleep() {
+// next advances the backoff and returns the jittered delay to wait.
+func (b *backoff) next() time.Duration {
 	if b.cur <= 0 {
 		b.cur = b.base
 	} else {
@@ -24,7 +26,21 @@ func (b *backoff) Sleep() {
 	}
 	// jitter ~ +/-20%
 	j := 0.8 + 0.4*rand.Float64()
-	time.Sleep(time.Duration(float64(b.cur) * j))
+	return time.Duration(float64(b.cur) * j)
+}
+
+func (b *backoff) Sleep() { time.Sleep(b.next()) }
+
+// Wait is like Sleep but returns early with the context error if ctx is done.
+func (b *backoff) Wait(ctx context.Context) error {
+	t := time.NewTimer(b.next())
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
 
 func (b *backoff) Reset() { b.cur = 0 }
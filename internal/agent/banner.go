@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const agentInfoEndpoint = "/v1/ingest/agent-info"
+
+// Banner is the record logged once at startup, and registered with the
+// service for support triage: which build runs where, with which config and
+// nodes.
+type Banner struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// Modules maps each dependency's module path to its version.
+	Modules map[string]string `json:"modules,omitempty"`
+	OS      string            `json:"os"`
+	Arch    string            `json:"arch"`
+	// Container is the container runtime the agent runs under
+	// ("kubernetes", "docker", "podman", "containerd", "lxc"), or empty.
+	Container string `json:"container,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	// TimeZone and UTCOffset are the host's local zone (e.g. "CET") and
+	// its offset ("+01:00") at start. Every timestamp walship sends is UTC;
+	// these tell its times apart from the node's local-time logs.
+	TimeZone  string       `json:"time_zone"`
+	UTCOffset string       `json:"utc_offset"`
+	Nodes     []BannerNode `json:"nodes"`
+	// Config is the effective config with secrets masked.
+	Config Config `json:"config"`
+}
+
+// BannerNode is a node the agent ships, as discovered from its home.
+type BannerNode struct {
+	ChainID  string `json:"chain_id"`
+	NodeID   string `json:"node_id"`
+	NodeHome string `json:"node_home,omitempty"`
+	WALDir   string `json:"wal_dir"`
+	StateDir string `json:"state_dir,omitempty"`
+}
+
+// Version returns the agent's module version from the build info, or "dev".
+func Version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// RedactedConfig returns cfg with its credentials masked, for logs and the
+// banner.
+func RedactedConfig(cfg Config) Config {
+	if len(cfg.AuthKey) > 0 {
+		cfg.AuthKey = "*****"
+	}
+	if len(cfg.AdminToken) > 0 {
+		cfg.AdminToken = "*****"
+	}
+	if len(cfg.AuthKeys) > 0 {
+		masked := make(map[string]string, len(cfg.AuthKeys))
+		for chain := range cfg.AuthKeys {
+			masked[chain] = "*****"
+		}
+		cfg.AuthKeys = masked
+	}
+	if len(cfg.AnonymizeSalt) > 0 {
+		cfg.AnonymizeSalt = "*****"
+	}
+	for _, u := range []*string{&cfg.ServiceURL, &cfg.RemoteWriteURL, &cfg.ProxyURL, &cfg.NodeMetricsURL,
+		&cfg.ObjectStoreEndpoint, &cfg.Tracing.Endpoint} {
+		*u = redactURL(*u)
+	}
+	return cfg
+}
+
+// redactURL masks the password and the query values of s, either of which
+// may hold a token. Anything that does not parse as a URL is kept.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil && u.RawQuery == "" {
+		return s
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for _, vs := range q {
+			for i := range vs {
+				vs[i] = "xxxxx"
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// newBanner describes the agent running cfg for nodes. The hostname is
+// withheld under Anonymize.
+func newBanner(cfg Config, nodes []Config) Banner {
+	now := time.Now()
+	zone, _ := now.Zone()
+	b := Banner{
+		Version:   Version(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Container: detectContainer(),
+		TimeZone:  zone,
+		UTCOffset: now.Format("-07:00"),
+		Nodes:     []BannerNode{},
+		Config:    RedactedConfig(cfg),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Deps) > 0 {
+		b.Modules = make(map[string]string, len(info.Deps))
+		for _, m := range info.Deps {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+			b.Modules[m.Path] = m.Version
+		}
+	}
+	if !cfg.Anonymize {
+		b.Hostname = hostname()
+	}
+	for _, n := range nodes {
+		b.Nodes = append(b.Nodes, BannerNode{ChainID: n.ChainID, NodeID: n.NodeID, NodeHome: n.NodeHome, WALDir: n.WALDir, StateDir: n.StateDir})
+	}
+	return b
+}
+
+// containerRoot is where detectContainer looks for its marker files; tests
+// point it at a temp dir.
+var containerRoot = "/"
+
+// detectContainer guesses the container runtime from the environment and
+// the marker files and cgroups runtimes leave behind.
+func detectContainer() string {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if _, err := os.Stat(filepath.Join(containerRoot, ".dockerenv")); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat(filepath.Join(containerRoot, "run", ".containerenv")); err == nil {
+		return "podman"
+	}
+	cgroup, _ := os.ReadFile(filepath.Join(containerRoot, "proc", "1", "cgroup"))
+	for _, rt := range []struct{ marker, name string }{
+		{"kubepods", "kubernetes"},
+		{"docker", "docker"},
+		{"libpod", "podman"},
+		{"containerd", "containerd"},
+		{"lxc", "lxc"},
+	} {
+		if strings.Contains(string(cgroup), rt.marker) {
+			return rt.name
+		}
+	}
+	return ""
+}
+
+// logBanner logs b as a single record.
+func logBanner(b Banner) {
+	logger.Info().
+		Str("version", b.Version).
+		Str("go_version", b.GoVersion).
+		Interface("modules", b.Modules).
+		Str("os_arch", b.OS+"/"+b.Arch).
+		Str("container", b.Container).
+		Str("time_zone", b.TimeZone+" "+b.UTCOffset).
+		Str("hostname", b.Hostname).
+		Interface("nodes", b.Nodes).
+		Interface("config", b.Config).
+		Msg("walship starting")
+}
+
+// postBanner registers b with the service when a pipeline starts. It is
+// best effort: a service without the endpoint only costs a debug line.
+func postBanner(ctx context.Context, cfg Config, httpClient *http.Client, b Banner) {
+	if err := postDerived(cfg, httpClient, agentInfoEndpoint, b); err != nil && ctx.Err() == nil {
+		logger.Debug().Err(err).Msg("agent info upload failed")
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+// Send paths of a batch under a canary rollout.
+const (
+	sendPathStable = "stable"
+	sendPathCanary = "canary"
+)
+
+// canaryRand draws the number deciding whether a batch is a canary; tests
+// replace it.
+var canaryRand = rand.Float64
+
+// canaryOutcomes counts the batch uploads of each send path, by result.
+var canaryOutcomes = struct {
+	mu sync.Mutex
+	n  map[[2]string]uint64 // {path, result}
+}{n: map[[2]string]uint64{}}
+
+func init() { metrics.Register(metrics.CollectorFunc(collectCanary)) }
+
+func (c *Config) validateCanary() error {
+	if c.CanaryPercent < 0 || c.CanaryPercent > 100 {
+		return fmt.Errorf("canary percent must be between 0 and 100")
+	}
+	if c.CanaryGRPCTarget != "" {
+		if _, _, err := net.SplitHostPort(c.CanaryGRPCTarget); err != nil {
+			return fmt.Errorf("canary grpc target must be host:port: %w", err)
+		}
+		if c.CanaryFrameEncoding != "" {
+			return fmt.Errorf("canary-frame-encoding does not apply to canary-grpc-target")
+		}
+	}
+	switch c.CanaryFrameEncoding {
+	case "", FrameEncodingGzip, FrameEncodingZstd:
+	default:
+		return fmt.Errorf("canary frame encoding must be %q or %q", FrameEncodingGzip, FrameEncodingZstd)
+	}
+	if c.CanaryPercent == 0 {
+		return nil
+	}
+	if c.CanaryGRPCTarget == "" && c.CanaryFrameEncoding == "" {
+		return fmt.Errorf("canary-percent needs canary-grpc-target or canary-frame-encoding")
+	}
+	if c.GRPCTarget != "" || len(c.KafkaBrokers) > 0 || c.ObjectStoreBucket != "" {
+		return fmt.Errorf("canary-percent needs the HTTP transport as the stable path, not grpc-target, kafka-brokers or object-store-bucket")
+	}
+	return nil
+}
+
+// canaryBatch decides whether the next batch takes the canary path.
+func canaryBatch(cfg Config) bool {
+	return cfg.CanaryPercent > 0 && canaryRand()*100 < cfg.CanaryPercent
+}
+
+// sendCanary sends frames over the canary gRPC target or, failing that,
+// over HTTP with the canary frame encoding, like the stable paths of
+// trySend.
+func sendCanary(cfg Config, p *pipeline, httpClient *http.Client, st *state, frames []batchFrame, curIdxBase string) (int, error) {
+	if gs := p.activeCanaryGRPC(); gs != nil {
+		span := p.traceSend(frames, curIdxBase, "grpc-canary")
+		sent, err := sendGRPC(cfg, gs, frames, curIdxBase)
+		endSendSpan(span, sent, err)
+		return sent, err
+	}
+	if cfg.CanaryFrameEncoding != "" {
+		cfg.FrameEncoding = cfg.CanaryFrameEncoding
+	}
+	span := p.traceSend(frames, curIdxBase, "http-canary")
+	sent, err := sendSplitting(cfg, tracedClient(httpClient, span.Context()), frames, curIdxBase, newBatchJournal(cfg, st))
+	endSendSpan(span, sent, err)
+	return sent, err
+}
+
+// observeCanary counts a batch upload on path while a rollout is on.
+func observeCanary(cfg Config, path string, err error) {
+	if cfg.CanaryPercent <= 0 {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	canaryOutcomes.mu.Lock()
+	defer canaryOutcomes.mu.Unlock()
+	canaryOutcomes.n[[2]string{path, result}]++
+}
+
+func collectCanary() []metrics.Sample {
+	canaryOutcomes.mu.Lock()
+	defer canaryOutcomes.mu.Unlock()
+	var out []metrics.Sample
+	for _, path := range []string{sendPathStable, sendPathCanary} {
+		for _, result := range []string{"ok", "error"} {
+			out = append(out, metrics.Sample{Name: "walship_canary_batches_total",
+				Help: "Batch uploads during a canary rollout, by send path and result.",
+				Type: metrics.CounterType, Labels: map[string]string{"path": path, "result": result},
+				Value: float64(canaryOutcomes.n[[2]string{path, result}])})
+		}
+	}
+	return out
+}
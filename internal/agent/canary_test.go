@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+func TestValidateCanary(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"off", Config{}, ""},
+		{"codec", Config{CanaryPercent: 5, CanaryFrameEncoding: FrameEncodingZstd}, ""},
+		{"transport", Config{CanaryPercent: 5, CanaryGRPCTarget: "ingest:443"}, ""},
+		{"over 100", Config{CanaryPercent: 101, CanaryFrameEncoding: FrameEncodingZstd}, "between 0 and 100"},
+		{"nothing to try", Config{CanaryPercent: 5}, "needs canary-grpc-target or canary-frame-encoding"},
+		{"both", Config{CanaryPercent: 5, CanaryGRPCTarget: "ingest:443", CanaryFrameEncoding: FrameEncodingZstd}, "does not apply"},
+		{"bad target", Config{CanaryPercent: 5, CanaryGRPCTarget: "ingest"}, "host:port"},
+		{"bad codec", Config{CanaryPercent: 5, CanaryFrameEncoding: "brotli"}, "must be"},
+		{"stable not http", Config{CanaryPercent: 5, CanaryFrameEncoding: FrameEncodingZstd, GRPCTarget: "ingest:443"}, "HTTP transport"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.validateCanary()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validateCanary: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validateCanary = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCanaryBatch(t *testing.T) {
+	old := canaryRand
+	defer func() { canaryRand = old }()
+	cfg := Config{CanaryPercent: 5}
+	for _, tt := range []struct {
+		draw float64
+		want bool
+	}{{0, true}, {0.049, true}, {0.05, false}, {0.99, false}} {
+		canaryRand = func() float64 { return tt.draw }
+		if got := canaryBatch(cfg); got != tt.want {
+			t.Errorf("draw %v: canary = %v, want %v", tt.draw, got, tt.want)
+		}
+	}
+	canaryRand = func() float64 { return 0 }
+	if canaryBatch(Config{}) {
+		t.Error("canary batch without a rollout")
+	}
+}
+
+func TestSendCanary(t *testing.T) {
+	ing := &zstdIngest{dicts: map[uint32][]byte{}}
+	ts := httptest.NewServer(ing)
+	defer ts.Close()
+
+	frames, _ := zstdTestFrames(t, 3)
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), HTTPTimeout: 5 * time.Second, SendMaxAttempts: 1,
+		FrameEncoding: FrameEncodingGzip, CanaryPercent: 5, CanaryFrameEncoding: FrameEncodingZstd}
+	sent, err := sendCanary(cfg, nil, ts.Client(), &state{}, frames, "seg-000001.wal.idx")
+	if err != nil || sent != len(frames) {
+		t.Fatalf("sendCanary = %d, %v", sent, err)
+	}
+	if ing.encoding != FrameEncodingZstd {
+		t.Errorf("canary batch encoding = %q, want zstd", ing.encoding)
+	}
+
+	before := canaryCount(sendPathCanary, "ok")
+	observeCanary(cfg, sendPathCanary, nil)
+	observeCanary(Config{}, sendPathCanary, nil) // no rollout, not counted
+	if got := canaryCount(sendPathCanary, "ok"); got != before+1 {
+		t.Errorf("canary ok batches = %v, want %v", got, before+1)
+	}
+}
+
+func canaryCount(path, result string) float64 {
+	for _, s := range metrics.DefaultRegistry.Gather() {
+		if s.Name == "walship_canary_batches_total" && s.Labels["path"] == path && s.Labels["result"] == result {
+			return s.Value
+		}
+	}
+	return -1
+}
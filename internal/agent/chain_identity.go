@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Policies for a state directory left behind by another chain, e.g. after a
+// node is re-initialised in place.
+const (
+	// ChainMismatchRefuse refuses to start.
+	ChainMismatchRefuse = "refuse"
+	// ChainMismatchReset logs a warning, discards the saved position and
+	// spool, and starts over as a fresh agent.
+	ChainMismatchReset = "reset"
+)
+
+// genesisHash returns the hex SHA-256 of the node's genesis.json.
+func genesisHash(nodeHome string) (string, error) {
+	f, err := openReadOnly(rootify(filepath.Join(DefaultConfigDir, DefaultGenesisJSONName), nodeHome))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkChainIdentity compares the chain recorded in the saved state with the
+// one the node now runs and applies cfg.ChainMismatch when they differ. The
+// current identity is then recorded, so state written by older agents is
+// adopted as is.
+func checkChainIdentity(cfg Config) error {
+	var hash string
+	if cfg.NodeHome != "" {
+		h, err := genesisHash(cfg.NodeHome)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("genesis hash: %w", err)
+		}
+		hash = h
+	}
+
+	st, err := loadState(cfg.StateDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil // unreadable state is handled as a fresh start by Run
+	}
+	if reason := chainMismatch(st, cfg.ChainID, hash); reason != "" {
+		if cfg.ChainMismatch != ChainMismatchReset {
+			return fmt.Errorf("state dir %s belongs to another chain (%s); remove it or use --on-chain-mismatch reset", cfg.StateDir, reason)
+		}
+		logger.Warn().Str("state_dir", cfg.StateDir).Str("reason", reason).Msg("state belongs to another chain; starting over")
+		recordEvent(EventState, "chain changed, state reset: "+reason)
+		if err := os.RemoveAll(filepath.Join(cfg.StateDir, "spool")); err != nil {
+			return fmt.Errorf("reset spool: %w", err)
+		}
+		if err := os.Remove(counterResetFile(cfg.StateDir)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reset counters: %w", err)
+		}
+		st = state{}
+	}
+	if st.ChainID == cfg.ChainID && st.GenesisHash == hash {
+		return nil
+	}
+	st.ChainID, st.GenesisHash = cfg.ChainID, hash
+	return saveState(cfg.StateDir, st)
+}
+
+// chainMismatch describes how st's chain differs from chainID and hash, or
+// returns "" if it does not. Values missing on either side are not compared.
+func chainMismatch(st state, chainID, hash string) string {
+	if st.ChainID != "" && chainID != "" && st.ChainID != chainID {
+		return fmt.Sprintf("chain-id %q, now %q", st.ChainID, chainID)
+	}
+	if st.GenesisHash != "" && hash != "" && st.GenesisHash != hash {
+		return "genesis.json changed"
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckChainIdentity(t *testing.T) {
+	writeGenesis := func(t *testing.T, home, content string) {
+		t.Helper()
+		dir := filepath.Join(home, DefaultConfigDir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, DefaultGenesisJSONName), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		saved     state
+		chainID   string
+		genesis   string
+		policy    string
+		wantErr   string
+		wantReset bool
+	}{
+		{name: "legacy state adopted", saved: state{IdxPath: "/wal/a.idx"}, chainID: "c1", genesis: `{"chain_id":"c1"}`},
+		{name: "same chain", saved: state{IdxPath: "/wal/a.idx", ChainID: "c1"}, chainID: "c1", genesis: `{"chain_id":"c1"}`},
+		{name: "chain-id changed refuses", saved: state{IdxPath: "/wal/a.idx", ChainID: "c1"}, chainID: "c2", genesis: `{"chain_id":"c2"}`,
+			policy: ChainMismatchRefuse, wantErr: `chain-id "c1", now "c2"`},
+		{name: "genesis changed refuses", saved: state{IdxPath: "/wal/a.idx", ChainID: "c1", GenesisHash: "00"}, chainID: "c1", genesis: `{"chain_id":"c1"}`,
+			wantErr: "genesis.json changed"},
+		{name: "chain-id changed resets", saved: state{IdxPath: "/wal/a.idx", ChainID: "c1"}, chainID: "c2", genesis: `{"chain_id":"c2"}`,
+			policy: ChainMismatchReset, wantReset: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, stateDir := t.TempDir(), t.TempDir()
+			writeGenesis(t, home, tt.genesis)
+			if err := saveState(stateDir, tt.saved); err != nil {
+				t.Fatal(err)
+			}
+			spoolDir := filepath.Join(stateDir, "spool")
+			if err := os.MkdirAll(spoolDir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			if err := ResetCounters(Config{StateDir: stateDir}); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := Config{NodeHome: home, StateDir: stateDir, ChainID: tt.chainID, ChainMismatch: tt.policy}
+			err := checkChainIdentity(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if st, _ := loadState(stateDir); st.IdxPath != tt.saved.IdxPath {
+					t.Errorf("refused state was modified: %+v", st)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkChainIdentity: %v", err)
+			}
+
+			st, err := loadState(stateDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, _ := genesisHash(home)
+			if st.ChainID != tt.chainID || st.GenesisHash != hash {
+				t.Errorf("identity = %q/%q, want %q/%q", st.ChainID, st.GenesisHash, tt.chainID, hash)
+			}
+			if tt.wantReset {
+				if st.IdxPath != "" {
+					t.Errorf("IdxPath = %q after reset, want empty", st.IdxPath)
+				}
+				if _, err := os.Stat(spoolDir); !os.IsNotExist(err) {
+					t.Errorf("spool not removed: %v", err)
+				}
+				if fileExists(counterResetFile(stateDir)) {
+					t.Error("counters reset kept after state reset")
+				}
+			} else if st.IdxPath != tt.saved.IdxPath {
+				t.Errorf("IdxPath = %q, want %q", st.IdxPath, tt.saved.IdxPath)
+			}
+		})
+	}
+}
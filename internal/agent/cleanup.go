@@ -26,22 +26,34 @@ type walSegment struct {
 	idxPath string
 	gzSize  int64
 	idxSize int64
+	modTime time.Time // of the .gz
 }
 
 // walCleanupLoop runs a periodic cleanup that trims old WAL segments when the
 // directory grows beyond the high watermark. It removes the oldest segments
 // (by day dir then segment number) until the directory shrinks below the low
-// watermark, deleting the matching .idx alongside each .gz.
-func walCleanupLoop(ctx context.Context, walDir, stateDir string) {
+// watermark, deleting the matching .idx alongside each .gz. With an archive,
+// each segment is copied there first, and segments older than its age limit
+// are removed regardless of size. With a retention policy, only segments the
+// service has acknowledged are removed, as the policy's rules dictate,
+// instead of by the watermarks.
+func walCleanupLoop(ctx context.Context, walDir, stateDir string, arch *walArchive, ret *walRetention) {
 	if walDir == "" {
 		return
 	}
 
+	interval := walCleanupCheckInterval
+	once := func() { walCleanupOnce(ctx, walDir, stateDir, arch) }
+	if ret != nil {
+		interval = walRetentionCheckInterval
+		once = func() { walRetentionOnce(ctx, walDir, stateDir, arch, ret) }
+	}
+
 	if walCleanupTickerNow {
-		walCleanupOnce(ctx, walDir, stateDir)
+		once()
 	}
 
-	t := time.NewTicker(walCleanupCheckInterval)
+	t := time.NewTicker(interval)
 	defer t.Stop()
 
 	for {
@@ -49,18 +61,19 @@ func walCleanupLoop(ctx context.Context, walDir, stateDir string) {
 		case <-ctx.Done():
 			return
 		case <-t.C:
-			walCleanupOnce(ctx, walDir, stateDir)
+			once()
 		}
 	}
 }
 
-func walCleanupOnce(ctx context.Context, walDir, stateDir string) {
+func walCleanupOnce(ctx context.Context, walDir, stateDir string, arch *walArchive) {
 	curSize, err := walDirSize(walDir)
 	if err != nil {
 		logger.Error().Err(err).Msg("wal cleanup: size check failed")
 		return
 	}
-	if curSize <= walCleanupHighWatermark {
+	overHigh := curSize > walCleanupHighWatermark
+	if !overHigh && !arch.ageBased() {
 		return
 	}
 
@@ -75,15 +88,23 @@ func walCleanupOnce(ctx context.Context, walDir, stateDir string) {
 		return
 	}
 
+	now := time.Now()
 	removed := int64(0)
 	for _, seg := range segs {
 		if ctx.Err() != nil {
 			return
 		}
-		if curSize <= walCleanupLowWatermark {
-			break
+		if !(overHigh && curSize > walCleanupLowWatermark) && !arch.expired(seg, now) {
+			if !arch.ageBased() {
+				break
+			}
+			continue
 		}
 
+		if err := arch.archive(seg); err != nil {
+			logger.Error().Err(err).Str("segment", seg.gzPath).Msg("wal cleanup: archive failed, keeping segment")
+			continue
+		}
 		bytesFreed, rmErr := removeSegment(seg)
 		if rmErr != nil {
 			logger.Error().Err(rmErr).Str("segment", seg.gzPath).Msg("wal cleanup: remove failed")
@@ -198,6 +219,7 @@ func scanSegmentDir(dir, day string) ([]walSegment, error) {
 			seg.day = day
 			seg.gzPath = filepath.Join(dir, name)
 			seg.gzSize = info.Size()
+			seg.modTime = info.ModTime()
 		case strings.HasSuffix(name, ".wal.idx"):
 			num, ok := segmentNumber(name, ".wal.idx")
 			if !ok {
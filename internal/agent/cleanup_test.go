@@ -33,7 +33,7 @@ func TestWalCleanup_RemovesOldestUntilLowWatermark(t *testing.T) {
 	createSegment(t, dayA, "seg-000002", 120, 10)
 	createSegment(t, dayB, "seg-000001", 120, 10)
 
-	walCleanupOnce(context.Background(), walDir, walDir)
+	walCleanupOnce(context.Background(), walDir, walDir, nil)
 
 	if pathExists(filepath.Join(dayA, "seg-000001.wal.gz")) || pathExists(filepath.Join(dayA, "seg-000001.wal.idx")) {
 		t.Fatalf("expected oldest segment in %s to be removed", dayA)
@@ -63,7 +63,7 @@ func TestWalCleanup_RespectsSegmentOrderWithinDir(t *testing.T) {
 	createSegment(t, tmp, "seg-000001", 120, 0)
 	createSegment(t, tmp, "seg-000002", 40, 10)
 
-	walCleanupOnce(context.Background(), tmp, tmp)
+	walCleanupOnce(context.Background(), tmp, tmp, nil)
 
 	if pathExists(filepath.Join(tmp, "seg-000001.wal.gz")) || pathExists(filepath.Join(tmp, "seg-000001.wal.idx")) {
 		t.Fatalf("expected seg-000001 to be removed first")
@@ -98,7 +98,7 @@ func TestWalCleanup_SkipsActiveDay(t *testing.T) {
 		t.Fatalf("save state: %v", err)
 	}
 
-	walCleanupOnce(context.Background(), walDir, walDir)
+	walCleanupOnce(context.Background(), walDir, walDir, nil)
 
 	// Oldest day should be pruned
 	if pathExists(filepath.Join(dayA, "seg-000001.wal.gz")) || pathExists(filepath.Join(dayA, "seg-000002.wal.gz")) {
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runAgainstFailingServer runs the agent over two frames while every upload
+// fails, and returns the state persisted once until accepts it, or after
+// wait.
+func runAgainstFailingServer(t *testing.T, mode string, wait time.Duration, until func(state) bool) state {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	cfg := Config{
+		ServiceURL:      ts.URL,
+		WALDir:          walDir,
+		StateDir:        t.TempDir(),
+		PollInterval:    time.Millisecond,
+		SendInterval:    time.Hour,
+		HardInterval:    time.Hour,
+		CommitMode:      mode,
+		CommitInterval:  time.Millisecond,
+		SendMaxAttempts: 1,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	deadline := time.Now().Add(wait)
+	for {
+		// The state file appears once the start position is saved.
+		st, err := loadState(cfg.StateDir)
+		if err == nil && until(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCommitReadPosition(t *testing.T) {
+	dir := t.TempDir()
+	st := state{IdxPath: "seg-000001.wal.idx", IdxOffset: 100, LastFrame: 3}
+	batch := []batchFrame{
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 4}, IdxLineLen: 10},
+		{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 5}, IdxLineLen: 12},
+	}
+
+	commitReadPosition(Config{StateDir: dir}, &st, batch)
+	if st.IdxOffset != 100 {
+		t.Error("in-memory acknowledged offset must not move")
+	}
+	// Later saves, such as a journaled send, keep the read position.
+	_ = saveState(dir, st)
+
+	saved, err := loadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved.resumeRead()
+	if saved.IdxOffset != 122 || saved.LastFrame != 5 || saved.Read != nil {
+		t.Errorf("resumed = offset %d frame %d, want 122 / 5", saved.IdxOffset, saved.LastFrame)
+	}
+
+	// Another index's read position is stale.
+	saved = state{IdxPath: "seg-000002.wal.idx", Read: st.Read}
+	saved.resumeRead()
+	if saved.IdxOffset != 0 {
+		t.Errorf("resumed at %d in another index", saved.IdxOffset)
+	}
+}
+
+func TestRun_AckCommitWaitsForAcknowledgement(t *testing.T) {
+	st := runAgainstFailingServer(t, CommitModeAck, 200*time.Millisecond, func(state) bool { return false })
+	if st.IdxOffset != 0 {
+		t.Errorf("IdxOffset = %d, want 0 without an acknowledgement", st.IdxOffset)
+	}
+}
+
+func TestRun_PeriodicCommitPersistsReadPosition(t *testing.T) {
+	// Failed sends hold up reading for their backoff.
+	st := runAgainstFailingServer(t, CommitModePeriodic, 5*time.Second, func(st state) bool { return st.Read != nil && st.Read.LastFrame == 2 })
+	fi, err := os.Stat(st.IdxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.resumeRead()
+	if st.IdxOffset != fi.Size() || st.LastFrame != 2 {
+		t.Errorf("resumes at offset %d frame %d, want %d / 2 past the unsent frames", st.IdxOffset, st.LastFrame, fi.Size())
+	}
+}
@@ -0,0 +1,11 @@
+package agent
+
+import "github.com/bft-labs/walship/pkg/batch"
+
+const (
+	// minCompressSize is the smallest request body worth gzip-encoding.
+	minCompressSize = 1024
+
+	// DefaultCompressionLevel is batch.DefaultGzipLevel.
+	DefaultCompressionLevel = batch.DefaultGzipLevel
+)
@@ -1,69 +1,390 @@
 package agent
 
 import (
+	"compress/gzip"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
+
+	wlog "github.com/bft-labs/walship/pkg/log"
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
 )
 
 // DefaultServiceURL is the default endpoint for shipping WAL data.
 const DefaultServiceURL = "https://api.apphash.io"
 
-// FrameMeta matches tools/memlogger/writer.go schema for index lines.
-// Fields are used to locate and read gzip members from the .gz file.
-type FrameMeta struct {
-	File    string `json:"file"`
-	Frame   uint64 `json:"frame"`
-	Off     uint64 `json:"off"`
-	Len     uint64 `json:"len"`
-	Recs    uint32 `json:"recs"`
-	FirstTS int64  `json:"first_ts"`
-	LastTS  int64  `json:"last_ts"`
-	CRC32   uint32 `json:"crc32"`
-}
+// DefaultIngestPathPrefix is the path under ServiceURL of the ingest
+// endpoints.
+const DefaultIngestPathPrefix = "/v1/ingest"
+
+// Commit modes decide when the read position is persisted.
+const (
+	// CommitModeAck persists the position only once the service has accepted
+	// the frames before it. A crash may resend frames but never skips them.
+	CommitModeAck = "ack"
+	// CommitModePeriodic also persists the read position every
+	// CommitInterval, even while frames are unsent. A crash may skip frames
+	// but rarely resends them.
+	CommitModePeriodic = "periodic"
+)
+
+// FrameMeta describes one frame in a WAL index; see wal.FrameMeta.
+type FrameMeta = wal.FrameMeta
 
 type Config struct {
 	NodeHome string
 	NodeID   string
 	WALDir   string
+	// NodeHomes, used instead of NodeHome, lists the homes of several nodes
+	// to ship from one process; entries may be glob patterns. Each node gets
+	// its own pipeline, identity and state dir (see nodeConfigs).
+	NodeHomes []string
 
 	ChainID string
 
 	ServiceURL string
-	AuthKey    string
+	// IngestPathPrefix replaces DefaultIngestPathPrefix in the paths of the
+	// ingest endpoints, for a service behind a path-rewriting gateway; "/"
+	// puts them at the root of ServiceURL. Empty means the default.
+	IngestPathPrefix string
+	AuthKey          string
+	// AuthKeys maps chain IDs to their own credentials; uploads for a chain
+	// without an entry use AuthKey.
+	AuthKeys map[string]string
+	// TLSPins, if set, pin the ingestion service's certificate, and the
+	// Kafka brokers' with KafkaTLS: one of its chain must match a
+	// "sha256/<base64>" public key hash or a "cert-sha256/<hex>"
+	// certificate fingerprint.
+	TLSPins []string
+	// ClientCertFile and ClientKeyFile are the PEM certificate and key
+	// presented to servers that ask for one, for ingestion endpoints behind
+	// mutual TLS. CAFile adds PEM CA certificates to the system roots.
+	// InsecureSkipVerify accepts any server certificate; TLSPins are then
+	// checked on every connection, not only the service's.
+	ClientCertFile     string
+	ClientKeyFile      string
+	CAFile             string
+	InsecureSkipVerify bool
+	// ProxyURL is the http, https, socks5 or socks5h proxy of HTTP requests,
+	// in place of HTTPS_PROXY and HTTP_PROXY; NO_PROXY still applies.
+	ProxyURL string
+	// GRPCTarget, if set, is the host:port frames are streamed to over gRPC
+	// instead of HTTP; other uploads still use ServiceURL. GRPCInsecure
+	// disables TLS on that connection.
+	GRPCTarget   string
+	GRPCInsecure bool
+	// KafkaBrokers, if set, are the host:port addresses of a Kafka cluster
+	// frames are published to instead of the service, on KafkaTopic, keyed
+	// by chain and node ID. KafkaTLS secures the broker connections with
+	// the client certificate, CA, pins and InsecureSkipVerify used for the
+	// service. The Kafka sender is experimental: it has no SASL and does
+	// not compress records.
+	KafkaBrokers []string
+	KafkaTopic   string
+	KafkaTLS     bool
+	// ObjectStoreBucket, if set, is an S3-compatible bucket each batch is
+	// written to as an object instead of being sent to the service. The
+	// bucket is addressed in the path of ObjectStoreEndpoint (default the
+	// AWS endpoint of ObjectStoreRegion), keys start with ObjectStorePrefix,
+	// and ObjectStoreSSE ("AES256" or "aws:kms", with ObjectStoreKMSKeyID)
+	// asks for server-side encryption. Credentials are read from
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	ObjectStoreBucket   string
+	ObjectStoreEndpoint string
+	ObjectStoreRegion   string
+	ObjectStorePrefix   string
+	ObjectStoreSSE      string
+	ObjectStoreKMSKeyID string
+	// RemoteWriteURL, if set, receives agent metrics via Prometheus
+	// remote-write.
+	RemoteWriteURL string
+	// StatsDAddr, if set, receives agent metrics as StatsD gauges over UDP;
+	// StatsDFlavor selects "dogstatsd" (tags) or plain "statsd".
+	StatsDAddr   string
+	StatsDFlavor string
+	// NodeMetricsInterval, if set, is how often the node's own Prometheus
+	// endpoint is scraped and shipped; NodeMetricsURL overrides the endpoint
+	// config.toml's instrumentation section names.
+	NodeMetricsInterval time.Duration
+	NodeMetricsURL      string
+	// AckLatencySLO, if set, bounds the AckLatencyPercentile of the time
+	// from frames being written to the WAL to the service acknowledging
+	// them; while it is exceeded a degraded event is recorded. The
+	// percentile defaults to defaultAckLatencyPercentile.
+	AckLatencySLO        time.Duration
+	AckLatencyPercentile int
+	// LogLevel drops log events below "debug", "info", "warn" or "error".
+	LogLevel string
+	// MetricsAddr, if set, is the host:port of a listener serving
+	// Prometheus /metrics and the walship expvar at /debug/vars.
+	MetricsAddr string
+	// AdminAddr, if set, is the host:port of a listener serving the admin
+	// API (see serveAdmin). It must be a loopback address unless AdminToken
+	// is set.
+	AdminAddr string
+	// AdminToken, if set, is required as "Authorization: Bearer <token>" on
+	// every admin API request.
+	AdminToken string
+	// Tracing exports spans of the send pipeline to an OpenTelemetry
+	// collector.
+	Tracing TracingConfig
 
 	PollInterval time.Duration
-	SendInterval time.Duration
-	HardInterval time.Duration
-	HTTPTimeout  time.Duration
+	// MaxPollInterval caps how far polling slows down while the WAL is idle.
+	MaxPollInterval time.Duration
+	SendInterval    time.Duration
+	HardInterval    time.Duration
+	HTTPTimeout     time.Duration
+	// SendMaxAttempts bounds how often one HTTP batch upload is tried before
+	// the failure is reported; transient failures are retried with jittered
+	// exponential backoff from SendRetryBase up to SendRetryMax.
+	SendMaxAttempts int
+	SendRetryBase   time.Duration
+	SendRetryMax    time.Duration
+
+	CommitMode     string
+	CommitInterval time.Duration
+
+	// Preflight decides whether failed startup checks are ignored ("off"),
+	// logged ("warn") or fatal ("strict").
+	Preflight string
+
+	// StartFrom is where an agent without saved state begins: "oldest",
+	// "latest", "time:<RFC3339>" or "height:<n>". It is ignored once state
+	// exists.
+	StartFrom string
+
+	// ChainMismatch decides what happens when the state directory belongs to
+	// another chain: "refuse" to start or "reset" the state and start over.
+	ChainMismatch string
+	// WALRelocate decides what happens when WALDir loses its WAL while
+	// another node-<id> dir beside it has one: "off", "warn" to log the
+	// candidate, or "follow" to switch to it if it is node-<NodeID>.
+	WALRelocate string
+	// NetProbe decides whose traffic NetThreshold applies to: NetProbeHost
+	// for every interface the agent sees, or NetProbeProcess for the node
+	// process's network namespace, so that other workloads on a shared
+	// host do not delay sends. That only isolates the node when it has a
+	// namespace of its own, e.g. in a container; a node sharing the
+	// agent's namespace is measured as the host, with a warning.
+	NetProbe string
+
+	CPUThreshold     float64
+	NetThreshold     float64
+	Iface            string // "" detects the default route's interface
+	IfaceSpeedMbps   int    // 0 reads Iface's speed from sysfs
+	MaxBatchBytes    int
+	CompressionLevel int
+	// FrameEncoding is "gzip" to upload frames as written or "zstd" to
+	// re-encode them with a periodically retrained shared dictionary.
+	FrameEncoding string
+	// CanaryPercent of batches, chosen at random, are sent over gRPC to
+	// CanaryGRPCTarget or with CanaryFrameEncoding instead, to try a new
+	// transport or codec on part of the traffic first. The rest, and
+	// spooled batches, take the stable HTTP path.
+	CanaryPercent       float64
+	CanaryGRPCTarget    string
+	CanaryFrameEncoding string
+	// ResumableUploadBytes sends batches of at least this many bytes through
+	// a resumable upload session; 0 disables resumable uploads.
+	ResumableUploadBytes int
+	// StreamUploadBytes streams batches, while catching up, into a single
+	// chunked request of up to this many bytes, written as the frames are
+	// read with MaxBatchBytes marking each chunk; 0 disables streaming.
+	StreamUploadBytes int
+	// MaxUploadBytesPerSec caps the rate at which HTTP frame uploads are
+	// written to the network, across every node the agent ships; 0 leaves
+	// uploads unthrottled. The HTTPTimeout of each upload is extended by
+	// the time its body takes at this rate.
+	MaxUploadBytesPerSec int
+	// MaxReadBytesPerSec caps the rate at which frames are read from the
+	// WAL, by the pipelines of every node and by backfill alike, so that
+	// catching up does not starve the node's own disk I/O; 0 leaves reads
+	// unthrottled.
+	MaxReadBytesPerSec int
+	// SpoolMaxBytes enables spooling batches the service cannot take to
+	// StateDir/spool, bounded to this many bytes and SpoolMaxAge; 0 keeps
+	// failed batches in memory only.
+	SpoolMaxBytes int
+	SpoolMaxAge   time.Duration
+	StateDir      string
+	// StateBackend is where the shipping position is kept in StateDir:
+	// "json" (status.json), "bolt" (state.bolt) or "sqlite" (state.db, cgo
+	// builds only).
+	StateBackend string
+	// ArchiveDir, if set, receives a verified copy of every WAL segment
+	// before cleanup deletes it, e.g. an NFS or S3 bucket mount. With
+	// ArchiveAfter, segments older than that are archived and removed even
+	// while the WAL dir is below its size watermark.
+	ArchiveDir   string
+	ArchiveAfter time.Duration
+	// RetentionMaxAge, RetentionMaxBytes and RetentionMinFreePercent
+	// replace the size watermarks of WAL cleanup with a retention policy:
+	// segments the service has acknowledged are removed, oldest first,
+	// while any rule is broken. A zero value disables its rule.
+	RetentionMaxAge         time.Duration
+	RetentionMaxBytes       int
+	RetentionMinFreePercent float64
+	// ShutdownTimeout bounds each stage of a pipeline's shutdown: stopping
+	// the readers, flushing the batch, draining the sender, committing the
+	// state, stopping scrapers and shutting down plugins.
+	ShutdownTimeout time.Duration
+	// Ledger records every delivered batch, with its consensus heights, in
+	// StateDir/ledger.bolt for `walship ledger query`.
+	Ledger bool
+	Verify bool
+	// DecodeConsensus also decodes proposals, votes and block parts from
+	// shipped frames and sends them as structured events. ConsensusKinds
+	// optionally restricts which kinds are sent (comma-separated).
+	DecodeConsensus bool
+	ConsensusKinds  string
+	// VoteLatency derives per peer and validator vote latencies from the
+	// votes in shipped frames, their receive time less their timestamp, and
+	// sends them alongside.
+	VoteLatency bool
+	// HeightSummaries derives each height's round count and step durations
+	// from the round state records of shipped frames and sends them
+	// alongside.
+	HeightSummaries bool
+	// CSWALDir is CometBFT's own consensus WAL directory (data/cs.wal,
+	// relative to NodeHome unless absolute). If set, its proposals, votes and
+	// block parts are shipped as consensus events, which needs no patched
+	// node; the memlogger WAL is then optional.
+	CSWALDir string
+	// FrameTypeStats counts the records of each frame by message type and
+	// reports the counts with each upload and in Stats. It decompresses
+	// every frame, so it is off by default.
+	FrameTypeStats bool
+	// SampleEveryN ships only the frames whose number is a multiple of it,
+	// SampleTypes only frames holding a record of one of its message types
+	// (comma-separated), and SampleHeightModulo only frames with a proposal,
+	// vote or block part at a height that is a multiple of it. They reduce
+	// the upload of busy chains; 0 or empty ships every frame.
+	SampleEveryN       int
+	SampleTypes        string
+	SampleHeightModulo int
+	// ReportGaps sends the WAL gaps the agent detects, which it always
+	// records in the state file, to the service's gap report endpoint.
+	// Gaps in the consensus WAL (CSWALDir) are sent with the events read
+	// around them instead of being kept in the state file.
+	ReportGaps bool
+	// Anonymize replaces the node ID (in headers and metric labels) and the
+	// peer_key of shipped frames with hashes keyed by AnonymizeSalt,
+	// withholds the hostname (headers, banner, trace resource and the
+	// instance metric label), and disables config file, identity, key and
+	// node metrics shipping. Frame contents other than peer_key, such as
+	// validator addresses and signatures, are public on chain and shipped
+	// as they are.
+	Anonymize     bool
+	AnonymizeSalt string
+	// DryRun runs the pipeline but prints each batch instead of sending
+	// it. Other requests to the service are logged and not sent either,
+	// and the position is kept in a copy of StateDir that is discarded.
+	DryRun bool
+	// NoAtime opens WAL and node config files with O_NOATIME (Linux).
+	NoAtime bool
+	Meta    bool
+	Once    bool
+	// ShipConfig watches the node's config files and ships each change.
+	ShipConfig bool
+	// ShipClientConfig and ShipGenesis add client.toml and genesis.json
+	// (its hash, size and head) to the shipped configuration.
+	ShipClientConfig bool
+	ShipGenesis      bool
+	// ConfigRedact are key patterns (with * wildcards) whose values are
+	// replaced before app.toml, config.toml and client.toml are shipped.
+	ConfigRedact []string
+	WatchFiles   []WatchFile
+	// ConfigChurnLimit flags config uploads and logs a warning when the
+	// watched files change more than this many times within
+	// ConfigChurnWindow; 0 disables the check.
+	ConfigChurnLimit  int
+	ConfigChurnWindow time.Duration
+	// ConfigHistory is how many shipped config snapshots, with their diffs,
+	// are kept in StateDir for `walship config history`; 0 keeps none.
+	ConfigHistory int
+	// WALWriterFile is a lock or heartbeat file (relative to WALDir) that the
+	// node's WAL writer keeps fresh; if set, the agent reports a writer whose
+	// heartbeat is older than WALWriterTimeout as stalled.
+	WALWriterFile    string
+	WALWriterTimeout time.Duration
+	// PreSendExec is a shell command run before the first send; sends wait
+	// until it succeeds. PostSendExec runs after each batch upload with
+	// WALSHIP_BATCH_* variables describing the batch.
+	PreSendExec  string
+	PostSendExec string
+	// Disable turns off built-in subsystems, named as in Subsystems, for
+	// minimal deployments or operators who may not ship node config.
+	Disable []string
 
-	CPUThreshold   float64
-	NetThreshold   float64
-	Iface          string
-	IfaceSpeedMbps int
-	MaxBatchBytes  int
-	StateDir       string
-	Verify         bool
-	Meta           bool
-	Once           bool
+	// OnSendSuccess, if set, is called after each batch is committed.
+	OnSendSuccess func(SendSuccessEvent) `json:"-"`
+	// OnSendError, if set, is called when an HTTP batch upload gives up, and
+	// OnRetry before each retry.
+	OnSendError func(SendErrorEvent) `json:"-"`
+	OnRetry     func(RetryEvent)     `json:"-"`
+	// OnGapDetected, if set, is called when frames or segments are found
+	// missing from the WAL.
+	OnGapDetected func(GapEvent) `json:"-"`
+	// OnConfigShipped, if set, is called when a snapshot of the node's
+	// config files is accepted; see ShipConfig.
+	OnConfigShipped func(ConfigShippedEvent) `json:"-"`
+	// FrameFilter, if set, is asked about each frame before it is read;
+	// frames it rejects are not shipped and are reported as sampled
+	// tombstones. See also EveryNthFrame.
+	FrameFilter FrameFilter `json:"-"`
+
+	// PluginHooks are called around every batch upload; see PluginHook.
+	PluginHooks []PluginHook `json:"-"`
 }
 
 // DefaultConfig returns a Config with default values.
 func DefaultConfig() Config {
 	return Config{
-		NodeID:         "default",
-		ServiceURL:     DefaultServiceURL,
-		PollInterval:   500 * time.Millisecond,
-		SendInterval:   5 * time.Second,
-		HardInterval:   10 * time.Second,
-		HTTPTimeout:    15 * time.Second,
-		CPUThreshold:   0.85,
-		NetThreshold:   0.70,
-		IfaceSpeedMbps: 1000,
-		MaxBatchBytes:  4 << 20, // 4MB
-		StateDir:       defaultStateDir(),
-		AuthKey:        os.Getenv("WALSHIP_AUTH_KEY"),
+		NodeID:            "default",
+		ServiceURL:        DefaultServiceURL,
+		IngestPathPrefix:  DefaultIngestPathPrefix,
+		StatsDFlavor:      StatsDFlavorDogStatsD,
+		LogLevel:          "info",
+		Tracing:           TracingConfig{SampleRatio: 1},
+		PollInterval:      500 * time.Millisecond,
+		MaxPollInterval:   5 * time.Second,
+		CommitMode:        CommitModeAck,
+		CommitInterval:    5 * time.Second,
+		Preflight:         PreflightWarn,
+		StartFrom:         StartFromOldest,
+		ChainMismatch:     ChainMismatchRefuse,
+		WALRelocate:       WALRelocateWarn,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		SendInterval:      5 * time.Second,
+		HardInterval:      10 * time.Second,
+		HTTPTimeout:       15 * time.Second,
+		SendMaxAttempts:   3,
+		SendRetryBase:     500 * time.Millisecond,
+		SendRetryMax:      10 * time.Second,
+		CPUThreshold:      0.85,
+		NetThreshold:      0.70,
+		NetProbe:          NetProbeHost,
+		MaxBatchBytes:     4 << 20, // 4MB
+		CompressionLevel:  DefaultCompressionLevel,
+		FrameEncoding:     FrameEncodingGzip,
+		StateDir:          defaultStateDir(),
+		StateBackend:      StateBackendJSON,
+		AuthKey:           os.Getenv("WALSHIP_AUTH_KEY"),
+		ShipConfig:        true,
+		ConfigRedact:      append([]string(nil), DefaultConfigRedact...),
+		ConfigChurnLimit:  5,
+		ConfigChurnWindow: 10 * time.Minute,
+		ConfigHistory:     20,
+		SpoolMaxAge:       24 * time.Hour,
+		WALWriterTimeout:  2 * time.Minute,
 	}
 }
 
@@ -72,32 +393,89 @@ func defaultStateDir() string {
 	return ""
 }
 
-// Validate checks the configuration for errors and sets derived defaults.
-func (c *Config) Validate() error {
-	if c.NodeHome == "" {
-		return fmt.Errorf("node-home is required")
-	}
-
-	if c.WALDir == "" {
-		if c.NodeID != "" {
+// normalize sets the derived defaults Validate would, without checking
+// anything, so that a config Run was given unvalidated compares equal to
+// the same config validated.
+func (c *Config) normalize() {
+	// With NodeHomes, WALDir and StateDir are derived per node.
+	if len(c.NodeHomes) == 0 {
+		if c.WALDir == "" && c.NodeHome != "" && c.NodeID != "" {
 			// fallback derived layout
-			c.WALDir = fmt.Sprintf("%s/data/log.wal/node-%s", c.NodeHome, c.NodeID)
-		} else {
-			return fmt.Errorf("wal-dir is required (or node-home)")
+			c.WALDir = filepath.Join(c.NodeHome, "data", "log.wal", "node-"+c.NodeID)
+		}
+		if c.StateDir == "" {
+			c.StateDir = c.WALDir
 		}
-	}
-
-	if c.StateDir == "" {
-		c.StateDir = c.WALDir
 	}
 
 	if c.ServiceURL == "" {
 		c.ServiceURL = DefaultServiceURL
 	}
-
 	// Ensure no trailing slash
-	if len(c.ServiceURL) > 0 && c.ServiceURL[len(c.ServiceURL)-1] == '/' {
-		c.ServiceURL = c.ServiceURL[:len(c.ServiceURL)-1]
+	c.ServiceURL = strings.TrimSuffix(c.ServiceURL, "/")
+	if len(c.IngestPathPrefix) > 1 && strings.HasPrefix(c.IngestPathPrefix, "/") {
+		c.IngestPathPrefix = strings.TrimRight(c.IngestPathPrefix, "/")
+	}
+
+	if c.MaxPollInterval < c.PollInterval {
+		c.MaxPollInterval = c.PollInterval
+	}
+	if c.CommitMode == "" {
+		c.CommitMode = CommitModeAck
+	}
+	if c.Preflight == "" {
+		c.Preflight = PreflightOff
+	}
+	if c.CompressionLevel == 0 {
+		c.CompressionLevel = DefaultCompressionLevel
+	}
+	if c.FrameEncoding == "" {
+		c.FrameEncoding = FrameEncodingGzip
+	}
+	if c.AckLatencyPercentile == 0 {
+		c.AckLatencyPercentile = defaultAckLatencyPercentile
+	}
+	if c.StatsDFlavor == "" {
+		c.StatsDFlavor = StatsDFlavorDogStatsD
+	}
+	if c.StateBackend == "" {
+		c.StateBackend = StateBackendJSON
+	}
+	if c.ChainMismatch == "" {
+		c.ChainMismatch = ChainMismatchRefuse
+	}
+	if c.WALRelocate == "" {
+		c.WALRelocate = WALRelocateWarn
+	}
+	if c.NetProbe == "" {
+		c.NetProbe = NetProbeHost
+	}
+	if disable, err := normalizeSubsystems(c.Disable); err == nil {
+		c.Disable = disable
+	}
+}
+
+// Validate checks the configuration for errors and sets derived defaults.
+func (c *Config) Validate() error {
+	if len(c.NodeHomes) > 0 {
+		if c.NodeHome != "" || c.WALDir != "" || c.ChainID != "" || (c.NodeID != "" && c.NodeID != "default") {
+			return fmt.Errorf("node-homes cannot be combined with node-home, wal-dir, node-id or chain-id")
+		}
+		if c.RemoteWriteURL != "" || c.StatsDAddr != "" {
+			return fmt.Errorf("node-homes cannot be combined with remote-write-url or statsd-addr, which label metrics with a single node")
+		}
+	} else if c.NodeHome == "" {
+		return fmt.Errorf("node-home is required")
+	}
+	c.normalize()
+
+	if c.WALDir == "" && len(c.NodeHomes) == 0 {
+		return fmt.Errorf("wal-dir is required (or node-home)")
+	}
+	if c.IngestPathPrefix != "" {
+		if !strings.HasPrefix(c.IngestPathPrefix, "/") || strings.ContainsAny(c.IngestPathPrefix, "?#") {
+			return fmt.Errorf("ingest path prefix must be an absolute path, got %q", c.IngestPathPrefix)
+		}
 	}
 
 	if c.PollInterval <= 0 {
@@ -107,6 +485,259 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("send interval must be positive")
 	}
 
+	if c.WALWriterFile != "" && c.WALWriterTimeout <= 0 {
+		return fmt.Errorf("wal writer timeout must be positive")
+	}
+
+	if c.SendMaxAttempts < 0 {
+		return fmt.Errorf("send max attempts must not be negative")
+	}
+	if c.SendMaxAttempts > 1 && (c.SendRetryBase <= 0 || c.SendRetryMax < c.SendRetryBase) {
+		return fmt.Errorf("send retry base must be positive and at most send retry max")
+	}
+
+	switch c.CommitMode {
+	case CommitModeAck:
+	case CommitModePeriodic:
+		if c.CommitInterval <= 0 {
+			return fmt.Errorf("commit interval must be positive")
+		}
+	default:
+		return fmt.Errorf("commit mode must be %q or %q", CommitModeAck, CommitModePeriodic)
+	}
+
+	switch c.Preflight {
+	case PreflightOff, PreflightWarn, PreflightStrict:
+	default:
+		return fmt.Errorf("preflight must be %q, %q or %q", PreflightOff, PreflightWarn, PreflightStrict)
+	}
+
+	if c.IfaceSpeedMbps < 0 {
+		return fmt.Errorf("iface speed must not be negative")
+	}
+
+	if c.CompressionLevel < gzip.BestSpeed || c.CompressionLevel > gzip.BestCompression {
+		return fmt.Errorf("compression level must be between %d and %d", gzip.BestSpeed, gzip.BestCompression)
+	}
+
+	switch c.FrameEncoding {
+	case FrameEncodingGzip, FrameEncodingZstd:
+	default:
+		return fmt.Errorf("frame encoding must be %q or %q", FrameEncodingGzip, FrameEncodingZstd)
+	}
+
+	if err := c.validateProxy(); err != nil {
+		return err
+	}
+	if err := c.validateCanary(); err != nil {
+		return err
+	}
+
+	if c.RemoteWriteURL != "" {
+		u, err := url.Parse(c.RemoteWriteURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("remote write url must be an http(s) URL")
+		}
+	}
+
+	if c.NodeMetricsInterval < 0 {
+		return fmt.Errorf("node metrics interval must not be negative")
+	}
+	if c.NodeMetricsURL != "" {
+		u, err := url.Parse(c.NodeMetricsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("node metrics url must be an http(s) URL")
+		}
+	}
+
+	if c.AckLatencySLO < 0 {
+		return fmt.Errorf("ack latency slo must not be negative")
+	}
+	switch c.AckLatencyPercentile {
+	case 50, 95, 99:
+	default:
+		return fmt.Errorf("ack latency percentile must be 50, 95 or 99")
+	}
+
+	switch c.StatsDFlavor {
+	case StatsDFlavorDogStatsD, StatsDFlavorStatsD:
+	default:
+		return fmt.Errorf("statsd flavor must be %q or %q", StatsDFlavorDogStatsD, StatsDFlavorStatsD)
+	}
+
+	if _, err := wlog.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("metrics addr must be host:port: %w", err)
+		}
+	}
+	if c.AdminAddr != "" {
+		if err := checkAdminAddr(c.AdminAddr, c.AdminToken); err != nil {
+			return err
+		}
+	}
+
+	if err := validateTracing(c.Tracing); err != nil {
+		return err
+	}
+
+	if c.GRPCTarget != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCTarget); err != nil {
+			return fmt.Errorf("grpc target must be host:port: %w", err)
+		}
+	}
+
+	if len(c.KafkaBrokers) > 0 {
+		if c.GRPCTarget != "" {
+			return fmt.Errorf("kafka-brokers and grpc-target are mutually exclusive")
+		}
+		if c.KafkaTopic == "" {
+			return fmt.Errorf("kafka-brokers requires kafka-topic")
+		}
+		for _, b := range c.KafkaBrokers {
+			if _, _, err := net.SplitHostPort(b); err != nil {
+				return fmt.Errorf("kafka broker must be host:port: %w", err)
+			}
+		}
+	}
+
+	if c.ObjectStoreBucket != "" {
+		if c.GRPCTarget != "" || len(c.KafkaBrokers) > 0 {
+			return fmt.Errorf("object-store-bucket is mutually exclusive with grpc-target and kafka-brokers")
+		}
+		if c.ObjectStoreEndpoint != "" {
+			if u, err := url.Parse(c.ObjectStoreEndpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("object-store-endpoint must be an http(s) URL")
+			}
+		}
+		switch c.ObjectStoreSSE {
+		case "", sender.SSEAES256, sender.SSEKMS:
+		default:
+			return fmt.Errorf("object-store-sse must be %q or %q", sender.SSEAES256, sender.SSEKMS)
+		}
+		if c.ObjectStoreKMSKeyID != "" && c.ObjectStoreSSE != sender.SSEKMS {
+			return fmt.Errorf("object-store-kms-key-id requires object-store-sse %q", sender.SSEKMS)
+		}
+	}
+
+	if c.ResumableUploadBytes < 0 {
+		return fmt.Errorf("resumable upload bytes must not be negative")
+	}
+	if err := c.validateStreamUpload(); err != nil {
+		return err
+	}
+	if c.MaxUploadBytesPerSec < 0 {
+		return fmt.Errorf("max upload bytes per sec must not be negative")
+	}
+	if c.MaxReadBytesPerSec < 0 {
+		return fmt.Errorf("max read bytes per sec must not be negative")
+	}
+	if c.SpoolMaxBytes < 0 {
+		return fmt.Errorf("spool max bytes must not be negative")
+	}
+	if c.SpoolMaxAge < 0 {
+		return fmt.Errorf("spool max age must not be negative")
+	}
+	switch c.StateBackend {
+	case StateBackendJSON, StateBackendBolt:
+	case StateBackendSQLite:
+		if !sqliteStateAvailable {
+			return fmt.Errorf("state-backend %q needs a cgo build; use %q", StateBackendSQLite, StateBackendBolt)
+		}
+	default:
+		return fmt.Errorf("state-backend must be %q, %q or %q", StateBackendJSON, StateBackendBolt, StateBackendSQLite)
+	}
+
+	if c.ArchiveAfter < 0 {
+		return fmt.Errorf("archive after must not be negative")
+	}
+	if c.ArchiveAfter > 0 && c.ArchiveDir == "" {
+		return fmt.Errorf("archive-after requires archive-dir")
+	}
+	if c.RetentionMaxAge < 0 {
+		return fmt.Errorf("retention max age must not be negative")
+	}
+	if c.RetentionMaxBytes < 0 {
+		return fmt.Errorf("retention max bytes must not be negative")
+	}
+	if c.RetentionMinFreePercent < 0 || c.RetentionMinFreePercent > 100 {
+		return fmt.Errorf("retention min free percent must be between 0 and 100")
+	}
+	if c.RetentionMinFreePercent > 0 && !diskFreeSupported {
+		return fmt.Errorf("retention-min-free-percent is not supported on %s", runtime.GOOS)
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout must not be negative")
+	}
+
+	if err := validateAuthKeys(c); err != nil {
+		return err
+	}
+	if _, err := parseTLSPins(c.TLSPins); err != nil {
+		return err
+	}
+	if err := checkClientTLS(*c); err != nil {
+		return err
+	}
+
+	if c.Anonymize && c.AnonymizeSalt == "" {
+		return fmt.Errorf("anonymize requires anonymize-salt")
+	}
+
+	if _, err := parseStartFrom(c.StartFrom); err != nil {
+		return err
+	}
+
+	switch c.ChainMismatch {
+	case ChainMismatchRefuse, ChainMismatchReset:
+	default:
+		return fmt.Errorf("on-chain-mismatch must be %q or %q", ChainMismatchRefuse, ChainMismatchReset)
+	}
+
+	switch c.WALRelocate {
+	case WALRelocateOff, WALRelocateWarn, WALRelocateFollow:
+	default:
+		return fmt.Errorf("wal-relocate must be %q, %q or %q", WALRelocateOff, WALRelocateWarn, WALRelocateFollow)
+	}
+
+	switch c.NetProbe {
+	case NetProbeHost, NetProbeProcess:
+	default:
+		return fmt.Errorf("net-probe must be %q or %q", NetProbeHost, NetProbeProcess)
+	}
+
+	if _, err := parseConsensusKinds(c.ConsensusKinds); err != nil {
+		return err
+	}
+	if c.SampleEveryN < 0 || c.SampleHeightModulo < 0 {
+		return fmt.Errorf("sample-every-n and sample-height-modulo must not be negative")
+	}
+	if _, err := parseMessageTypes(c.SampleTypes); err != nil {
+		return fmt.Errorf("sample-types: %w", err)
+	}
+
+	if _, err := normalizeSubsystems(c.Disable); err != nil {
+		return fmt.Errorf("disable: %w", err)
+	}
+
+	if c.ConfigChurnLimit < 0 {
+		return fmt.Errorf("config churn limit must not be negative")
+	}
+	if c.ConfigChurnLimit > 0 && c.ConfigChurnWindow <= 0 {
+		return fmt.Errorf("config churn window must be positive")
+	}
+	if c.ConfigHistory < 0 {
+		return fmt.Errorf("config history must not be negative")
+	}
+
+	for _, wf := range c.WatchFiles {
+		if err := validateWatchFile(wf); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -166,6 +797,30 @@ func (s *configSetter) setBool(flag string, value *bool, dst *bool) {
 	*dst = *value
 }
 
+// setStrings sets a string list if not empty and flag not changed.
+func (s *configSetter) setStrings(flag string, value []string, dst *[]string) {
+	if len(value) == 0 || s.changed[flag] {
+		return
+	}
+	*dst = value
+}
+
+// setWatchFiles sets the watch file list if not empty and flag not changed.
+func (s *configSetter) setWatchFiles(flag string, value []WatchFile, dst *[]WatchFile) {
+	if len(value) == 0 || s.changed[flag] {
+		return
+	}
+	*dst = value
+}
+
+// setStringMap sets a map value if not empty and flag not changed.
+func (s *configSetter) setStringMap(flag string, value map[string]string, dst *map[string]string) {
+	if len(value) == 0 || s.changed[flag] {
+		return
+	}
+	*dst = value
+}
+
 // setIntFromString parses a string to int and sets the destination if valid.
 // Used for environment variables that come as strings.
 func (s *configSetter) setIntFromString(flag, value string, dst *int) error {
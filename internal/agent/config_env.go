@@ -1,6 +1,9 @@
 package agent
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 // ApplyEnvConfig applies configuration from environment variables (WALSHIP_*).
 // It respects flags that have been explicitly set (changed map).
@@ -10,15 +13,73 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 
 	s.setString("node-home", os.Getenv("WALSHIP_NODE_HOME"), &cfg.NodeHome)
 	s.setString("node-id", os.Getenv("WALSHIP_NODE_ID"), &cfg.NodeID)
+	if v := os.Getenv("WALSHIP_NODE_HOMES"); v != "" {
+		s.setStrings("node-homes", strings.Split(v, ","), &cfg.NodeHomes)
+	}
 	s.setString("wal-dir", os.Getenv("WALSHIP_WAL_DIR"), &cfg.WALDir)
 	s.setString("service-url", os.Getenv("WALSHIP_SERVICE_URL"), &cfg.ServiceURL)
+	s.setString("ingest-path-prefix", os.Getenv("WALSHIP_INGEST_PATH_PREFIX"), &cfg.IngestPathPrefix)
 	s.setString("auth-key", os.Getenv("WALSHIP_AUTH_KEY"), &cfg.AuthKey)
 	s.setString("iface", os.Getenv("WALSHIP_IFACE"), &cfg.Iface)
+	s.setString("net-probe", os.Getenv("WALSHIP_NET_PROBE"), &cfg.NetProbe)
+	s.setString("grpc-target", os.Getenv("WALSHIP_GRPC_TARGET"), &cfg.GRPCTarget)
+	if v := os.Getenv("WALSHIP_KAFKA_BROKERS"); v != "" {
+		s.setStrings("kafka-brokers", strings.Split(v, ","), &cfg.KafkaBrokers)
+	}
+	s.setString("kafka-topic", os.Getenv("WALSHIP_KAFKA_TOPIC"), &cfg.KafkaTopic)
+	s.setString("object-store-bucket", os.Getenv("WALSHIP_OBJECT_STORE_BUCKET"), &cfg.ObjectStoreBucket)
+	s.setString("object-store-endpoint", os.Getenv("WALSHIP_OBJECT_STORE_ENDPOINT"), &cfg.ObjectStoreEndpoint)
+	s.setString("object-store-region", os.Getenv("WALSHIP_OBJECT_STORE_REGION"), &cfg.ObjectStoreRegion)
+	s.setString("object-store-prefix", os.Getenv("WALSHIP_OBJECT_STORE_PREFIX"), &cfg.ObjectStorePrefix)
+	s.setString("object-store-sse", os.Getenv("WALSHIP_OBJECT_STORE_SSE"), &cfg.ObjectStoreSSE)
+	s.setString("object-store-kms-key-id", os.Getenv("WALSHIP_OBJECT_STORE_KMS_KEY_ID"), &cfg.ObjectStoreKMSKeyID)
+	s.setString("remote-write-url", os.Getenv("WALSHIP_REMOTE_WRITE_URL"), &cfg.RemoteWriteURL)
+	s.setString("statsd-addr", os.Getenv("WALSHIP_STATSD_ADDR"), &cfg.StatsDAddr)
+	s.setString("statsd-flavor", os.Getenv("WALSHIP_STATSD_FLAVOR"), &cfg.StatsDFlavor)
+	if err := s.setDuration("node-metrics-interval", os.Getenv("WALSHIP_NODE_METRICS_INTERVAL"), &cfg.NodeMetricsInterval); err != nil {
+		return err
+	}
+	s.setString("node-metrics-url", os.Getenv("WALSHIP_NODE_METRICS_URL"), &cfg.NodeMetricsURL)
+	if err := s.setDuration("ack-latency-slo", os.Getenv("WALSHIP_ACK_LATENCY_SLO"), &cfg.AckLatencySLO); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("ack-latency-percentile", os.Getenv("WALSHIP_ACK_LATENCY_PERCENTILE"), &cfg.AckLatencyPercentile); err != nil {
+		return err
+	}
+	s.setString("log-level", os.Getenv("WALSHIP_LOG_LEVEL"), &cfg.LogLevel)
+	s.setString("metrics-addr", os.Getenv("WALSHIP_METRICS_ADDR"), &cfg.MetricsAddr)
+	s.setString("admin-addr", os.Getenv("WALSHIP_ADMIN_ADDR"), &cfg.AdminAddr)
+	s.setString("admin-token", os.Getenv("WALSHIP_ADMIN_TOKEN"), &cfg.AdminToken)
+	s.setString("tracing-exporter", os.Getenv("WALSHIP_TRACING_EXPORTER"), &cfg.Tracing.Exporter)
+	s.setString("tracing-endpoint", os.Getenv("WALSHIP_TRACING_ENDPOINT"), &cfg.Tracing.Endpoint)
+	s.setBoolFromString("tracing-insecure", os.Getenv("WALSHIP_TRACING_INSECURE"), &cfg.Tracing.Insecure)
+	if err := s.setFloatFromString("tracing-sample-ratio", os.Getenv("WALSHIP_TRACING_SAMPLE_RATIO"), &cfg.Tracing.SampleRatio); err != nil {
+		return err
+	}
+	s.setString("frame-encoding", os.Getenv("WALSHIP_FRAME_ENCODING"), &cfg.FrameEncoding)
+	if err := s.setFloatFromString("canary-percent", os.Getenv("WALSHIP_CANARY_PERCENT"), &cfg.CanaryPercent); err != nil {
+		return err
+	}
+	s.setString("canary-grpc-target", os.Getenv("WALSHIP_CANARY_GRPC_TARGET"), &cfg.CanaryGRPCTarget)
+	s.setString("canary-frame-encoding", os.Getenv("WALSHIP_CANARY_FRAME_ENCODING"), &cfg.CanaryFrameEncoding)
+	s.setString("consensus-kinds", os.Getenv("WALSHIP_CONSENSUS_KINDS"), &cfg.ConsensusKinds)
+	s.setString("anonymize-salt", os.Getenv("WALSHIP_ANONYMIZE_SALT"), &cfg.AnonymizeSalt)
 	s.setString("state-dir", os.Getenv("WALSHIP_STATE_DIR"), &cfg.StateDir)
 
 	if err := s.setDuration("poll", os.Getenv("WALSHIP_POLL_INTERVAL"), &cfg.PollInterval); err != nil {
 		return err
 	}
+	if err := s.setDuration("max-poll", os.Getenv("WALSHIP_MAX_POLL_INTERVAL"), &cfg.MaxPollInterval); err != nil {
+		return err
+	}
+	s.setString("preflight", os.Getenv("WALSHIP_PREFLIGHT"), &cfg.Preflight)
+	s.setString("start-from", os.Getenv("WALSHIP_START_FROM"), &cfg.StartFrom)
+	s.setString("on-chain-mismatch", os.Getenv("WALSHIP_ON_CHAIN_MISMATCH"), &cfg.ChainMismatch)
+	s.setString("wal-relocate", os.Getenv("WALSHIP_WAL_RELOCATE"), &cfg.WALRelocate)
+	s.setString("commit-mode", os.Getenv("WALSHIP_COMMIT_MODE"), &cfg.CommitMode)
+	if err := s.setDuration("commit-interval", os.Getenv("WALSHIP_COMMIT_INTERVAL"), &cfg.CommitInterval); err != nil {
+		return err
+	}
 	if err := s.setDuration("send-interval", os.Getenv("WALSHIP_SEND_INTERVAL"), &cfg.SendInterval); err != nil {
 		return err
 	}
@@ -28,6 +89,15 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	if err := s.setDuration("timeout", os.Getenv("WALSHIP_HTTP_TIMEOUT"), &cfg.HTTPTimeout); err != nil {
 		return err
 	}
+	if err := s.setIntFromString("send-max-attempts", os.Getenv("WALSHIP_SEND_MAX_ATTEMPTS"), &cfg.SendMaxAttempts); err != nil {
+		return err
+	}
+	if err := s.setDuration("send-retry-base", os.Getenv("WALSHIP_SEND_RETRY_BASE"), &cfg.SendRetryBase); err != nil {
+		return err
+	}
+	if err := s.setDuration("send-retry-max", os.Getenv("WALSHIP_SEND_RETRY_MAX"), &cfg.SendRetryMax); err != nil {
+		return err
+	}
 
 	if err := s.setFloatFromString("cpu-threshold", os.Getenv("WALSHIP_CPU_THRESHOLD"), &cfg.CPUThreshold); err != nil {
 		return err
@@ -42,10 +112,112 @@ func ApplyEnvConfig(cfg *Config, changed map[string]bool) error {
 	if err := s.setIntFromString("max-batch-bytes", os.Getenv("WALSHIP_MAX_BATCH_BYTES"), &cfg.MaxBatchBytes); err != nil {
 		return err
 	}
+	if err := s.setIntFromString("compression-level", os.Getenv("WALSHIP_COMPRESSION_LEVEL"), &cfg.CompressionLevel); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("config-churn-limit", os.Getenv("WALSHIP_CONFIG_CHURN_LIMIT"), &cfg.ConfigChurnLimit); err != nil {
+		return err
+	}
+	if err := s.setDuration("config-churn-window", os.Getenv("WALSHIP_CONFIG_CHURN_WINDOW"), &cfg.ConfigChurnWindow); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("config-history", os.Getenv("WALSHIP_CONFIG_HISTORY"), &cfg.ConfigHistory); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("resumable-upload-bytes", os.Getenv("WALSHIP_RESUMABLE_UPLOAD_BYTES"), &cfg.ResumableUploadBytes); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("stream-upload-bytes", os.Getenv("WALSHIP_STREAM_UPLOAD_BYTES"), &cfg.StreamUploadBytes); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("max-upload-bytes-per-sec", os.Getenv("WALSHIP_MAX_UPLOAD_BYTES_PER_SEC"), &cfg.MaxUploadBytesPerSec); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("max-read-bytes-per-sec", os.Getenv("WALSHIP_MAX_READ_BYTES_PER_SEC"), &cfg.MaxReadBytesPerSec); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("spool-max-bytes", os.Getenv("WALSHIP_SPOOL_MAX_BYTES"), &cfg.SpoolMaxBytes); err != nil {
+		return err
+	}
+	if err := s.setDuration("spool-max-age", os.Getenv("WALSHIP_SPOOL_MAX_AGE"), &cfg.SpoolMaxAge); err != nil {
+		return err
+	}
+	s.setString("state-backend", os.Getenv("WALSHIP_STATE_BACKEND"), &cfg.StateBackend)
+	s.setString("archive-dir", os.Getenv("WALSHIP_ARCHIVE_DIR"), &cfg.ArchiveDir)
+	if err := s.setDuration("archive-after", os.Getenv("WALSHIP_ARCHIVE_AFTER"), &cfg.ArchiveAfter); err != nil {
+		return err
+	}
+	if err := s.setDuration("retention-max-age", os.Getenv("WALSHIP_RETENTION_MAX_AGE"), &cfg.RetentionMaxAge); err != nil {
+		return err
+	}
+	if err := s.setIntFromString("retention-max-bytes", os.Getenv("WALSHIP_RETENTION_MAX_BYTES"), &cfg.RetentionMaxBytes); err != nil {
+		return err
+	}
+	if err := s.setFloatFromString("retention-min-free-percent", os.Getenv("WALSHIP_RETENTION_MIN_FREE_PERCENT"), &cfg.RetentionMinFreePercent); err != nil {
+		return err
+	}
+	if err := s.setDuration("shutdown-timeout", os.Getenv("WALSHIP_SHUTDOWN_TIMEOUT"), &cfg.ShutdownTimeout); err != nil {
+		return err
+	}
 
 	s.setBoolFromString("verify", os.Getenv("WALSHIP_VERIFY"), &cfg.Verify)
+	s.setBoolFromString("decode-consensus", os.Getenv("WALSHIP_DECODE_CONSENSUS"), &cfg.DecodeConsensus)
+	s.setBoolFromString("vote-latency", os.Getenv("WALSHIP_VOTE_LATENCY"), &cfg.VoteLatency)
+	s.setBoolFromString("height-summaries", os.Getenv("WALSHIP_HEIGHT_SUMMARIES"), &cfg.HeightSummaries)
+	s.setBoolFromString("frame-type-stats", os.Getenv("WALSHIP_FRAME_TYPE_STATS"), &cfg.FrameTypeStats)
+	s.setIntFromString("sample-every-n", os.Getenv("WALSHIP_SAMPLE_EVERY_N"), &cfg.SampleEveryN)
+	s.setString("sample-types", os.Getenv("WALSHIP_SAMPLE_TYPES"), &cfg.SampleTypes)
+	s.setIntFromString("sample-height-modulo", os.Getenv("WALSHIP_SAMPLE_HEIGHT_MODULO"), &cfg.SampleHeightModulo)
+	s.setBoolFromString("report-gaps", os.Getenv("WALSHIP_REPORT_GAPS"), &cfg.ReportGaps)
+	s.setString("cs-wal-dir", os.Getenv("WALSHIP_CS_WAL_DIR"), &cfg.CSWALDir)
+	s.setBoolFromString("ledger", os.Getenv("WALSHIP_LEDGER"), &cfg.Ledger)
+	s.setBoolFromString("anonymize", os.Getenv("WALSHIP_ANONYMIZE"), &cfg.Anonymize)
+	s.setBoolFromString("grpc-insecure", os.Getenv("WALSHIP_GRPC_INSECURE"), &cfg.GRPCInsecure)
+	s.setBoolFromString("kafka-tls", os.Getenv("WALSHIP_KAFKA_TLS"), &cfg.KafkaTLS)
+	s.setBoolFromString("noatime", os.Getenv("WALSHIP_NOATIME"), &cfg.NoAtime)
 	s.setBoolFromString("meta", os.Getenv("WALSHIP_META"), &cfg.Meta)
 	s.setBoolFromString("once", os.Getenv("WALSHIP_ONCE"), &cfg.Once)
+	s.setBoolFromString("dry-run", os.Getenv("WALSHIP_DRY_RUN"), &cfg.DryRun)
+	s.setBoolFromString("ship-config", os.Getenv("WALSHIP_SHIP_CONFIG"), &cfg.ShipConfig)
+	s.setBoolFromString("ship-client-config", os.Getenv("WALSHIP_SHIP_CLIENT_CONFIG"), &cfg.ShipClientConfig)
+	s.setBoolFromString("ship-genesis", os.Getenv("WALSHIP_SHIP_GENESIS"), &cfg.ShipGenesis)
+	if v := os.Getenv("WALSHIP_CONFIG_REDACT"); v != "" {
+		s.setStrings("config-redact", strings.Split(v, ","), &cfg.ConfigRedact)
+	}
+	s.setString("wal-writer-file", os.Getenv("WALSHIP_WAL_WRITER_FILE"), &cfg.WALWriterFile)
+	if err := s.setDuration("wal-writer-timeout", os.Getenv("WALSHIP_WAL_WRITER_TIMEOUT"), &cfg.WALWriterTimeout); err != nil {
+		return err
+	}
+	s.setString("pre-send-exec", os.Getenv("WALSHIP_PRE_SEND_EXEC"), &cfg.PreSendExec)
+	s.setString("post-send-exec", os.Getenv("WALSHIP_POST_SEND_EXEC"), &cfg.PostSendExec)
+	if v := os.Getenv("WALSHIP_DISABLE"); v != "" {
+		s.setStrings("disable", strings.Split(v, ","), &cfg.Disable)
+	}
+
+	if v := os.Getenv("WALSHIP_AUTH_KEYS"); v != "" {
+		keys, err := ParseAuthKeys(v)
+		if err != nil {
+			return err
+		}
+		s.setStringMap("auth-keys", keys, &cfg.AuthKeys)
+	}
+
+	if v := os.Getenv("WALSHIP_TLS_PINS"); v != "" {
+		s.setStrings("tls-pins", strings.Split(v, ","), &cfg.TLSPins)
+	}
+	s.setString("tls-client-cert", os.Getenv("WALSHIP_TLS_CLIENT_CERT"), &cfg.ClientCertFile)
+	s.setString("tls-client-key", os.Getenv("WALSHIP_TLS_CLIENT_KEY"), &cfg.ClientKeyFile)
+	s.setString("tls-ca-file", os.Getenv("WALSHIP_TLS_CA_FILE"), &cfg.CAFile)
+	s.setBoolFromString("tls-insecure-skip-verify", os.Getenv("WALSHIP_TLS_INSECURE_SKIP_VERIFY"), &cfg.InsecureSkipVerify)
+	s.setString("proxy-url", os.Getenv("WALSHIP_PROXY_URL"), &cfg.ProxyURL)
+
+	if v := os.Getenv("WALSHIP_WATCH_FILES"); v != "" {
+		wfs, err := parseWatchFiles(v)
+		if err != nil {
+			return err
+		}
+		s.setWatchFiles("watch-file", wfs, &cfg.WatchFiles)
+	}
 
 	return nil
 }
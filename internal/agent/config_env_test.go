@@ -127,6 +127,7 @@ func TestApplyEnvConfig(t *testing.T) {
 				"WALSHIP_VERIFY":           "true",
 				"WALSHIP_META":             "false",
 				"WALSHIP_ONCE":             "1",
+				"WALSHIP_SHIP_CONFIG":      "true",
 			},
 			changed: map[string]bool{},
 			initial: Config{},
@@ -149,6 +150,7 @@ func TestApplyEnvConfig(t *testing.T) {
 				Verify:         true,
 				Meta:           false,
 				Once:           true,
+				ShipConfig:     true,
 			},
 			wantErr: false,
 		},
@@ -222,6 +224,9 @@ func TestApplyEnvConfig(t *testing.T) {
 				if cfg.Once != tt.expected.Once {
 					t.Errorf("Once = %v, want %v", cfg.Once, tt.expected.Once)
 				}
+				if cfg.ShipConfig != tt.expected.ShipConfig {
+					t.Errorf("ShipConfig = %v, want %v", cfg.ShipConfig, tt.expected.ShipConfig)
+				}
 			}
 		})
 	}
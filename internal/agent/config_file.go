@@ -9,24 +9,128 @@ import (
 
 // fileConfig mirrors Config but uses strings for durations to make TOML friendly.
 type fileConfig struct {
-	NodeHome       string  `toml:"node_home"`
-	NodeID         string  `toml:"node_id"`
-	WALDir         string  `toml:"wal_dir"`
-	ServiceURL     string  `toml:"service_url"`
-	AuthKey         string  `toml:"auth_key"`
-	PollInterval   string  `toml:"poll_interval"`
-	SendInterval   string  `toml:"send_interval"`
-	HardInterval   string  `toml:"hard_interval"`
-	HTTPTimeout    string  `toml:"http_timeout"`
-	CPUThreshold   float64 `toml:"cpu_threshold"`
-	NetThreshold   float64 `toml:"net_threshold"`
-	Iface          string  `toml:"iface"`
-	IfaceSpeedMbps int     `toml:"iface_speed_mbps"`
-	MaxBatchBytes  int     `toml:"max_batch_bytes"`
-	StateDir       string  `toml:"state_dir"`
-	Verify         *bool   `toml:"verify"`
-	Meta           *bool   `toml:"meta"`
-	Once           *bool   `toml:"once"`
+	NodeHome                string   `toml:"node_home"`
+	NodeID                  string   `toml:"node_id"`
+	NodeHomes               []string `toml:"node_homes"`
+	WALDir                  string   `toml:"wal_dir"`
+	ServiceURL              string   `toml:"service_url"`
+	IngestPathPrefix        string   `toml:"ingest_path_prefix"`
+	AuthKey                 string   `toml:"auth_key"`
+	PollInterval            string   `toml:"poll_interval"`
+	MaxPollInterval         string   `toml:"max_poll_interval"`
+	CommitMode              string   `toml:"commit_mode"`
+	Preflight               string   `toml:"preflight"`
+	StartFrom               string   `toml:"start_from"`
+	ChainMismatch           string   `toml:"on_chain_mismatch"`
+	WALRelocate             string   `toml:"wal_relocate"`
+	CommitInterval          string   `toml:"commit_interval"`
+	SendInterval            string   `toml:"send_interval"`
+	HardInterval            string   `toml:"hard_interval"`
+	HTTPTimeout             string   `toml:"http_timeout"`
+	SendMaxAttempts         int      `toml:"send_max_attempts"`
+	SendRetryBase           string   `toml:"send_retry_base"`
+	SendRetryMax            string   `toml:"send_retry_max"`
+	CPUThreshold            float64  `toml:"cpu_threshold"`
+	NetThreshold            float64  `toml:"net_threshold"`
+	Iface                   string   `toml:"iface"`
+	NetProbe                string   `toml:"net_probe"`
+	GRPCTarget              string   `toml:"grpc_target"`
+	GRPCInsecure            *bool    `toml:"grpc_insecure"`
+	KafkaBrokers            []string `toml:"kafka_brokers"`
+	KafkaTopic              string   `toml:"kafka_topic"`
+	KafkaTLS                *bool    `toml:"kafka_tls"`
+	ObjectStoreBucket       string   `toml:"object_store_bucket"`
+	ObjectStoreEndpoint     string   `toml:"object_store_endpoint"`
+	ObjectStoreRegion       string   `toml:"object_store_region"`
+	ObjectStorePrefix       string   `toml:"object_store_prefix"`
+	ObjectStoreSSE          string   `toml:"object_store_sse"`
+	ObjectStoreKMSKeyID     string   `toml:"object_store_kms_key_id"`
+	RemoteWriteURL          string   `toml:"remote_write_url"`
+	StatsDAddr              string   `toml:"statsd_addr"`
+	StatsDFlavor            string   `toml:"statsd_flavor"`
+	NodeMetricsInterval     string   `toml:"node_metrics_interval"`
+	NodeMetricsURL          string   `toml:"node_metrics_url"`
+	AckLatencySLO           string   `toml:"ack_latency_slo"`
+	AckLatencyPercentile    int      `toml:"ack_latency_percentile"`
+	LogLevel                string   `toml:"log_level"`
+	MetricsAddr             string   `toml:"metrics_addr"`
+	AdminAddr               string   `toml:"admin_addr"`
+	AdminToken              string   `toml:"admin_token"`
+	IfaceSpeedMbps          int      `toml:"iface_speed_mbps"`
+	MaxBatchBytes           int      `toml:"max_batch_bytes"`
+	CompressionLevel        int      `toml:"compression_level"`
+	FrameEncoding           string   `toml:"frame_encoding"`
+	CanaryPercent           float64  `toml:"canary_percent"`
+	CanaryGRPCTarget        string   `toml:"canary_grpc_target"`
+	CanaryFrameEncoding     string   `toml:"canary_frame_encoding"`
+	ResumableUploadBytes    int      `toml:"resumable_upload_bytes"`
+	StreamUploadBytes       int      `toml:"stream_upload_bytes"`
+	MaxUploadBytesPerSec    int      `toml:"max_upload_bytes_per_sec"`
+	MaxReadBytesPerSec      int      `toml:"max_read_bytes_per_sec"`
+	SpoolMaxBytes           int      `toml:"spool_max_bytes"`
+	SpoolMaxAge             string   `toml:"spool_max_age"`
+	StateBackend            string   `toml:"state_backend"`
+	ArchiveDir              string   `toml:"archive_dir"`
+	ArchiveAfter            string   `toml:"archive_after"`
+	RetentionMaxAge         string   `toml:"retention_max_age"`
+	RetentionMaxBytes       int      `toml:"retention_max_bytes"`
+	RetentionMinFreePercent float64  `toml:"retention_min_free_percent"`
+	ShutdownTimeout         string   `toml:"shutdown_timeout"`
+	ConsensusKinds          string   `toml:"consensus_kinds"`
+	AnonymizeSalt           string   `toml:"anonymize_salt"`
+	StateDir                string   `toml:"state_dir"`
+	Anonymize               *bool    `toml:"anonymize"`
+	DecodeConsensus         *bool    `toml:"decode_consensus"`
+	VoteLatency             *bool    `toml:"vote_latency"`
+	HeightSummaries         *bool    `toml:"height_summaries"`
+	FrameTypeStats          *bool    `toml:"frame_type_stats"`
+	SampleEveryN            int      `toml:"sample_every_n"`
+	SampleTypes             string   `toml:"sample_types"`
+	SampleHeightModulo      int      `toml:"sample_height_modulo"`
+	ReportGaps              *bool    `toml:"report_gaps"`
+	CSWALDir                string   `toml:"cs_wal_dir"`
+	Ledger                  *bool    `toml:"ledger"`
+	Verify                  *bool    `toml:"verify"`
+	NoAtime                 *bool    `toml:"noatime"`
+	Meta                    *bool    `toml:"meta"`
+	Once                    *bool    `toml:"once"`
+	DryRun                  *bool    `toml:"dry_run"`
+	ShipConfig              *bool    `toml:"ship_config"`
+	ShipClientConfig        *bool    `toml:"ship_client_config"`
+	ShipGenesis             *bool    `toml:"ship_genesis"`
+	ConfigRedact            []string `toml:"config_redact"`
+	ConfigChurnLimit        int      `toml:"config_churn_limit"`
+	ConfigChurnWindow       string   `toml:"config_churn_window"`
+	ConfigHistory           int      `toml:"config_history"`
+	WALWriterFile           string   `toml:"wal_writer_file"`
+	WALWriterTimeout        string   `toml:"wal_writer_timeout"`
+	PreSendExec             string   `toml:"pre_send_exec"`
+	PostSendExec            string   `toml:"post_send_exec"`
+	Disable                 []string `toml:"disable"`
+	TLSPins                 []string `toml:"tls_pins"`
+	ClientCertFile          string   `toml:"tls_client_cert"`
+	ClientKeyFile           string   `toml:"tls_client_key"`
+	CAFile                  string   `toml:"tls_ca_file"`
+	InsecureSkipVerify      *bool    `toml:"tls_insecure_skip_verify"`
+	ProxyURL                string   `toml:"proxy_url"`
+
+	AuthKeys   map[string]string `toml:"auth_keys"`
+	WatchFiles []fileWatchFile   `toml:"watch_files"`
+	Tracing    fileTracing       `toml:"tracing"`
+}
+
+// fileTracing is the [tracing] table.
+type fileTracing struct {
+	Exporter    string  `toml:"exporter"`
+	Endpoint    string  `toml:"endpoint"`
+	Insecure    *bool   `toml:"insecure"`
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+// fileWatchFile is a [[watch_files]] entry.
+type fileWatchFile struct {
+	Path   string   `toml:"path"`
+	Redact []string `toml:"redact"`
 }
 
 // loadFileConfig reads and parses a TOML config file.
@@ -58,15 +162,59 @@ func applyFileConfig(cfg *Config, fc fileConfig, changed map[string]bool) error
 
 	s.setString("node-home", fc.NodeHome, &cfg.NodeHome)
 	s.setString("node-id", fc.NodeID, &cfg.NodeID)
+	s.setStrings("node-homes", fc.NodeHomes, &cfg.NodeHomes)
 	s.setString("wal-dir", fc.WALDir, &cfg.WALDir)
 	s.setString("service-url", fc.ServiceURL, &cfg.ServiceURL)
+	s.setString("ingest-path-prefix", fc.IngestPathPrefix, &cfg.IngestPathPrefix)
 	s.setString("auth-key", fc.AuthKey, &cfg.AuthKey)
 	s.setString("iface", fc.Iface, &cfg.Iface)
+	s.setString("net-probe", fc.NetProbe, &cfg.NetProbe)
+	s.setString("grpc-target", fc.GRPCTarget, &cfg.GRPCTarget)
+	s.setStrings("kafka-brokers", fc.KafkaBrokers, &cfg.KafkaBrokers)
+	s.setString("kafka-topic", fc.KafkaTopic, &cfg.KafkaTopic)
+	s.setString("object-store-bucket", fc.ObjectStoreBucket, &cfg.ObjectStoreBucket)
+	s.setString("object-store-endpoint", fc.ObjectStoreEndpoint, &cfg.ObjectStoreEndpoint)
+	s.setString("object-store-region", fc.ObjectStoreRegion, &cfg.ObjectStoreRegion)
+	s.setString("object-store-prefix", fc.ObjectStorePrefix, &cfg.ObjectStorePrefix)
+	s.setString("object-store-sse", fc.ObjectStoreSSE, &cfg.ObjectStoreSSE)
+	s.setString("object-store-kms-key-id", fc.ObjectStoreKMSKeyID, &cfg.ObjectStoreKMSKeyID)
+	s.setString("remote-write-url", fc.RemoteWriteURL, &cfg.RemoteWriteURL)
+	s.setString("statsd-addr", fc.StatsDAddr, &cfg.StatsDAddr)
+	s.setString("statsd-flavor", fc.StatsDFlavor, &cfg.StatsDFlavor)
+	if err := s.setDuration("node-metrics-interval", fc.NodeMetricsInterval, &cfg.NodeMetricsInterval); err != nil {
+		return err
+	}
+	s.setString("node-metrics-url", fc.NodeMetricsURL, &cfg.NodeMetricsURL)
+	if err := s.setDuration("ack-latency-slo", fc.AckLatencySLO, &cfg.AckLatencySLO); err != nil {
+		return err
+	}
+	s.setInt("ack-latency-percentile", fc.AckLatencyPercentile, &cfg.AckLatencyPercentile)
+	s.setString("log-level", fc.LogLevel, &cfg.LogLevel)
+	s.setString("metrics-addr", fc.MetricsAddr, &cfg.MetricsAddr)
+	s.setString("admin-addr", fc.AdminAddr, &cfg.AdminAddr)
+	s.setString("admin-token", fc.AdminToken, &cfg.AdminToken)
+	s.setString("tracing-exporter", fc.Tracing.Exporter, &cfg.Tracing.Exporter)
+	s.setString("tracing-endpoint", fc.Tracing.Endpoint, &cfg.Tracing.Endpoint)
+	s.setBool("tracing-insecure", fc.Tracing.Insecure, &cfg.Tracing.Insecure)
+	s.setFloat("tracing-sample-ratio", fc.Tracing.SampleRatio, &cfg.Tracing.SampleRatio)
+	s.setString("consensus-kinds", fc.ConsensusKinds, &cfg.ConsensusKinds)
+	s.setString("anonymize-salt", fc.AnonymizeSalt, &cfg.AnonymizeSalt)
 	s.setString("state-dir", fc.StateDir, &cfg.StateDir)
 
 	if err := s.setDuration("poll", fc.PollInterval, &cfg.PollInterval); err != nil {
 		return err
 	}
+	if err := s.setDuration("max-poll", fc.MaxPollInterval, &cfg.MaxPollInterval); err != nil {
+		return err
+	}
+	s.setString("preflight", fc.Preflight, &cfg.Preflight)
+	s.setString("start-from", fc.StartFrom, &cfg.StartFrom)
+	s.setString("on-chain-mismatch", fc.ChainMismatch, &cfg.ChainMismatch)
+	s.setString("wal-relocate", fc.WALRelocate, &cfg.WALRelocate)
+	s.setString("commit-mode", fc.CommitMode, &cfg.CommitMode)
+	if err := s.setDuration("commit-interval", fc.CommitInterval, &cfg.CommitInterval); err != nil {
+		return err
+	}
 	if err := s.setDuration("send-interval", fc.SendInterval, &cfg.SendInterval); err != nil {
 		return err
 	}
@@ -76,16 +224,94 @@ func applyFileConfig(cfg *Config, fc fileConfig, changed map[string]bool) error
 	if err := s.setDuration("timeout", fc.HTTPTimeout, &cfg.HTTPTimeout); err != nil {
 		return err
 	}
+	s.setInt("send-max-attempts", fc.SendMaxAttempts, &cfg.SendMaxAttempts)
+	if err := s.setDuration("send-retry-base", fc.SendRetryBase, &cfg.SendRetryBase); err != nil {
+		return err
+	}
+	if err := s.setDuration("send-retry-max", fc.SendRetryMax, &cfg.SendRetryMax); err != nil {
+		return err
+	}
 
 	s.setFloat("cpu-threshold", fc.CPUThreshold, &cfg.CPUThreshold)
 	s.setFloat("net-threshold", fc.NetThreshold, &cfg.NetThreshold)
 
 	s.setInt("iface-speed", fc.IfaceSpeedMbps, &cfg.IfaceSpeedMbps)
 	s.setInt("max-batch-bytes", fc.MaxBatchBytes, &cfg.MaxBatchBytes)
+	s.setInt("compression-level", fc.CompressionLevel, &cfg.CompressionLevel)
+	s.setString("frame-encoding", fc.FrameEncoding, &cfg.FrameEncoding)
+	s.setFloat("canary-percent", fc.CanaryPercent, &cfg.CanaryPercent)
+	s.setString("canary-grpc-target", fc.CanaryGRPCTarget, &cfg.CanaryGRPCTarget)
+	s.setString("canary-frame-encoding", fc.CanaryFrameEncoding, &cfg.CanaryFrameEncoding)
+	s.setInt("config-churn-limit", fc.ConfigChurnLimit, &cfg.ConfigChurnLimit)
+	s.setInt("config-history", fc.ConfigHistory, &cfg.ConfigHistory)
+	if err := s.setDuration("config-churn-window", fc.ConfigChurnWindow, &cfg.ConfigChurnWindow); err != nil {
+		return err
+	}
+	s.setInt("resumable-upload-bytes", fc.ResumableUploadBytes, &cfg.ResumableUploadBytes)
+	s.setInt("stream-upload-bytes", fc.StreamUploadBytes, &cfg.StreamUploadBytes)
+	s.setInt("max-upload-bytes-per-sec", fc.MaxUploadBytesPerSec, &cfg.MaxUploadBytesPerSec)
+	s.setInt("max-read-bytes-per-sec", fc.MaxReadBytesPerSec, &cfg.MaxReadBytesPerSec)
+	s.setInt("spool-max-bytes", fc.SpoolMaxBytes, &cfg.SpoolMaxBytes)
+	if err := s.setDuration("spool-max-age", fc.SpoolMaxAge, &cfg.SpoolMaxAge); err != nil {
+		return err
+	}
+	s.setString("state-backend", fc.StateBackend, &cfg.StateBackend)
+	s.setString("archive-dir", fc.ArchiveDir, &cfg.ArchiveDir)
+	if err := s.setDuration("archive-after", fc.ArchiveAfter, &cfg.ArchiveAfter); err != nil {
+		return err
+	}
+	if err := s.setDuration("retention-max-age", fc.RetentionMaxAge, &cfg.RetentionMaxAge); err != nil {
+		return err
+	}
+	s.setInt("retention-max-bytes", fc.RetentionMaxBytes, &cfg.RetentionMaxBytes)
+	s.setFloat("retention-min-free-percent", fc.RetentionMinFreePercent, &cfg.RetentionMinFreePercent)
+	if err := s.setDuration("shutdown-timeout", fc.ShutdownTimeout, &cfg.ShutdownTimeout); err != nil {
+		return err
+	}
 
 	s.setBool("verify", fc.Verify, &cfg.Verify)
+	s.setBool("decode-consensus", fc.DecodeConsensus, &cfg.DecodeConsensus)
+	s.setBool("vote-latency", fc.VoteLatency, &cfg.VoteLatency)
+	s.setBool("height-summaries", fc.HeightSummaries, &cfg.HeightSummaries)
+	s.setBool("frame-type-stats", fc.FrameTypeStats, &cfg.FrameTypeStats)
+	s.setInt("sample-every-n", fc.SampleEveryN, &cfg.SampleEveryN)
+	s.setString("sample-types", fc.SampleTypes, &cfg.SampleTypes)
+	s.setInt("sample-height-modulo", fc.SampleHeightModulo, &cfg.SampleHeightModulo)
+	s.setBool("report-gaps", fc.ReportGaps, &cfg.ReportGaps)
+	s.setString("cs-wal-dir", fc.CSWALDir, &cfg.CSWALDir)
+	s.setBool("ledger", fc.Ledger, &cfg.Ledger)
+	s.setBool("anonymize", fc.Anonymize, &cfg.Anonymize)
+	s.setBool("grpc-insecure", fc.GRPCInsecure, &cfg.GRPCInsecure)
+	s.setBool("kafka-tls", fc.KafkaTLS, &cfg.KafkaTLS)
+	s.setBool("noatime", fc.NoAtime, &cfg.NoAtime)
 	s.setBool("meta", fc.Meta, &cfg.Meta)
 	s.setBool("once", fc.Once, &cfg.Once)
+	s.setBool("dry-run", fc.DryRun, &cfg.DryRun)
+	s.setBool("ship-config", fc.ShipConfig, &cfg.ShipConfig)
+	s.setBool("ship-client-config", fc.ShipClientConfig, &cfg.ShipClientConfig)
+	s.setBool("ship-genesis", fc.ShipGenesis, &cfg.ShipGenesis)
+	s.setStrings("config-redact", fc.ConfigRedact, &cfg.ConfigRedact)
+	s.setString("wal-writer-file", fc.WALWriterFile, &cfg.WALWriterFile)
+	if err := s.setDuration("wal-writer-timeout", fc.WALWriterTimeout, &cfg.WALWriterTimeout); err != nil {
+		return err
+	}
+	s.setString("pre-send-exec", fc.PreSendExec, &cfg.PreSendExec)
+	s.setString("post-send-exec", fc.PostSendExec, &cfg.PostSendExec)
+	s.setStrings("disable", fc.Disable, &cfg.Disable)
+
+	s.setStringMap("auth-keys", fc.AuthKeys, &cfg.AuthKeys)
+	s.setStrings("tls-pins", fc.TLSPins, &cfg.TLSPins)
+	s.setString("tls-client-cert", fc.ClientCertFile, &cfg.ClientCertFile)
+	s.setString("tls-client-key", fc.ClientKeyFile, &cfg.ClientKeyFile)
+	s.setString("tls-ca-file", fc.CAFile, &cfg.CAFile)
+	s.setBool("tls-insecure-skip-verify", fc.InsecureSkipVerify, &cfg.InsecureSkipVerify)
+	s.setString("proxy-url", fc.ProxyURL, &cfg.ProxyURL)
+
+	var wfs []WatchFile
+	for _, wf := range fc.WatchFiles {
+		wfs = append(wfs, WatchFile{Path: wf.Path, Redact: wf.Redact})
+	}
+	s.setWatchFiles("watch-file", wfs, &cfg.WatchFiles)
 
 	return nil
 }
@@ -275,3 +275,24 @@ func containsSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+func TestLoadFileConfig_TracingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := "[tracing]\nexporter = \"otlp-grpc\"\nendpoint = \"collector:4317\"\ninsecure = true\nsample_ratio = 0.25\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fc, err := loadFileConfig(path)
+	if err != nil {
+		t.Fatalf("loadFileConfig() error = %v", err)
+	}
+
+	cfg := Config{Tracing: TracingConfig{SampleRatio: 1}}
+	if err := applyFileConfig(&cfg, fc, map[string]bool{"tracing-endpoint": true}); err != nil {
+		t.Fatalf("applyFileConfig() error = %v", err)
+	}
+	want := TracingConfig{Exporter: TracingExporterOTLPGRPC, Insecure: true, SampleRatio: 0.25}
+	if cfg.Tracing != want {
+		t.Errorf("Tracing = %+v, want %+v", cfg.Tracing, want)
+	}
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// configDiffMaxCells bounds the line diff of one file; larger rewrites are
+// summarized instead.
+const configDiffMaxCells = 1 << 22
+
+// ConfigHistoryEntry is one shipped configuration snapshot.
+type ConfigHistoryEntry struct {
+	Hash      string    `json:"hash"`
+	ShippedAt time.Time `json:"shipped_at"`
+	// Changed lists the files that differ from the previous snapshot.
+	Changed []string `json:"changed,omitempty"`
+	// Diff is a line diff of the changed files against the previous
+	// snapshot, empty for the first one.
+	Diff string `json:"diff,omitempty"`
+}
+
+// configHistory is kept in the state dir: the files of the last shipped
+// snapshot, to diff the next one against, and the newest entries last.
+type configHistory struct {
+	Files   map[string]string    `json:"files"`
+	Entries []ConfigHistoryEntry `json:"entries"`
+}
+
+func configHistoryFile(dir string) string {
+	return filepath.Join(dir, "config_history.json")
+}
+
+// recordConfigHistory appends the snapshot of files shipped at at to the
+// history in dir, keeping the newest keep entries.
+func recordConfigHistory(dir string, keep int, hash string, files map[string]string, at time.Time) error {
+	var h configHistory
+	_ = readJSON(configHistoryFile(dir), &h) // a missing or corrupt history starts over
+	e := ConfigHistoryEntry{Hash: hash, ShippedAt: at.UTC()}
+	if len(h.Entries) > 0 {
+		e.Changed, e.Diff = diffConfigFiles(h.Files, files)
+	}
+	h.Files = files
+	h.Entries = append(h.Entries, e)
+	if len(h.Entries) > keep {
+		h.Entries = h.Entries[len(h.Entries)-keep:]
+	}
+	return writeJSONAtomic(dir, configHistoryFile(dir), h)
+}
+
+// ReadConfigHistory returns the configuration snapshots shipped from
+// cfg.StateDir, newest first.
+func ReadConfigHistory(cfg Config) ([]ConfigHistoryEntry, error) {
+	var h configHistory
+	if err := readJSON(configHistoryFile(cfg.StateDir), &h); err != nil {
+		return nil, err
+	}
+	out := make([]ConfigHistoryEntry, len(h.Entries))
+	for i, e := range h.Entries {
+		out[len(out)-1-i] = e
+	}
+	return out, nil
+}
+
+// diffConfigFiles returns the files that differ between two snapshots, in
+// name order, and their line diffs.
+func diffConfigFiles(prev, cur map[string]string) ([]string, string) {
+	names := map[string]bool{}
+	for n := range prev {
+		names[n] = true
+	}
+	for n := range cur {
+		names[n] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	var changed []string
+	var b strings.Builder
+	for _, n := range sorted {
+		a, aok := prev[n]
+		c, cok := cur[n]
+		if aok == cok && a == c {
+			continue
+		}
+		changed = append(changed, n)
+		from, to := "a/"+n, "b/"+n
+		if !aok {
+			from = "/dev/null"
+		}
+		if !cok {
+			to = "/dev/null"
+		}
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+		writeLineDiff(&b, splitLines(a), splitLines(c))
+	}
+	return changed, b.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// writeLineDiff writes the changed lines of a and b as hunks without
+// context, each headed by the line ranges it covers.
+func writeLineDiff(b *strings.Builder, a, c []string) {
+	// Config edits are usually a few lines, so the common head and tail
+	// are trimmed before the quadratic part.
+	pre := 0
+	for pre < len(a) && pre < len(c) && a[pre] == c[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(c)-pre && a[len(a)-1-suf] == c[len(c)-1-suf] {
+		suf++
+	}
+	a, c = a[pre:len(a)-suf], c[pre:len(c)-suf]
+	if len(a)*len(c) > configDiffMaxCells {
+		fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@ (too large to diff)\n", pre+1, len(a), pre+1, len(c))
+		return
+	}
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and c[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(c)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(c) - 1; j >= 0; j-- {
+			if a[i] == c[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var del, ins []string
+	ai, ci := 0, 0 // start of the pending hunk
+	flush := func() {
+		if len(del) == 0 && len(ins) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", pre+ai+1, len(del), pre+ci+1, len(ins))
+		for _, l := range del {
+			b.WriteString("-" + l + "\n")
+		}
+		for _, l := range ins {
+			b.WriteString("+" + l + "\n")
+		}
+		del, ins = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(c) {
+		switch {
+		case i < len(a) && j < len(c) && a[i] == c[j]:
+			flush()
+			i++
+			j++
+			ai, ci = i, j
+		case j < len(c) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			ins = append(ins, c[j])
+			j++
+		default:
+			del = append(del, a[i])
+			i++
+		}
+	}
+	flush()
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffConfigFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		prev, cur   map[string]string
+		wantChanged string
+		wantDiff    string
+	}{
+		{
+			name:        "changed line",
+			prev:        map[string]string{"app.toml": "a = 1\nb = 2\nc = 3\n", "config.toml": "x = 1\n"},
+			cur:         map[string]string{"app.toml": "a = 1\nb = 5\nc = 3\n", "config.toml": "x = 1\n"},
+			wantChanged: "app.toml",
+			wantDiff:    "--- a/app.toml\n+++ b/app.toml\n@@ -2,1 +2,1 @@\n-b = 2\n+b = 5\n",
+		},
+		{
+			name:        "insert and delete",
+			prev:        map[string]string{"app.toml": "a\nb\nc\nd\n"},
+			cur:         map[string]string{"app.toml": "a\nx\nb\nd\n"},
+			wantChanged: "app.toml",
+			wantDiff:    "--- a/app.toml\n+++ b/app.toml\n@@ -2,0 +2,1 @@\n+x\n@@ -3,1 +4,0 @@\n-c\n",
+		},
+		{
+			name:        "file appears and disappears",
+			prev:        map[string]string{"client.toml": "k = 1\n"},
+			cur:         map[string]string{"genesis.json": "sha256 ab, 2 bytes"},
+			wantChanged: "client.toml,genesis.json",
+			wantDiff: "--- a/client.toml\n+++ /dev/null\n@@ -1,1 +1,0 @@\n-k = 1\n" +
+				"--- /dev/null\n+++ b/genesis.json\n@@ -1,0 +1,1 @@\n+sha256 ab, 2 bytes\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, diff := diffConfigFiles(tt.prev, tt.cur)
+			if got := strings.Join(changed, ","); got != tt.wantChanged {
+				t.Errorf("changed = %q, want %q", got, tt.wantChanged)
+			}
+			if diff != tt.wantDiff {
+				t.Errorf("diff =\n%s\nwant\n%s", diff, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestRecordConfigHistory_KeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 4; i++ {
+		files := map[string]string{"app.toml": fmt.Sprintf("version = %d\n", i)}
+		if err := recordConfigHistory(dir, 3, fmt.Sprint("h", i), files, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ReadConfigHistory(Config{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, e := range entries {
+		hashes = append(hashes, e.Hash)
+	}
+	if got := strings.Join(hashes, ","); got != "h4,h3,h2" {
+		t.Fatalf("hashes = %s, want h4,h3,h2 (newest first)", got)
+	}
+	if e := entries[0]; !strings.Contains(e.Diff, "-version = 3\n+version = 4\n") || !e.ShippedAt.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("newest entry = %+v", e)
+	}
+}
+
+func TestConfigWatcher_RecordsShippedHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appToml := filepath.Join(configDir, "app.toml")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	stateDir := t.TempDir()
+	w := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL, StateDir: stateDir, ConfigHistory: 5})
+	for _, v := range []string{"pruning = \"default\"\n", "pruning = \"nothing\"\n"} {
+		if err := os.WriteFile(appToml, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w.sendConfigWithRetry(context.Background())
+	}
+	// Unchanged content is not shipped again and adds nothing.
+	w.sendConfigWithRetry(context.Background())
+
+	entries, err := ReadConfigHistory(Config{StateDir: stateDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(entries))
+	}
+	if got := strings.Join(entries[0].Changed, ","); got != "app.toml" {
+		t.Errorf("changed = %q, want app.toml", got)
+	}
+	if !strings.Contains(entries[0].Diff, "-pruning = \"default\"\n+pruning = \"nothing\"\n") {
+		t.Errorf("diff = %q", entries[0].Diff)
+	}
+	if entries[1].Diff != "" {
+		t.Errorf("first snapshot has diff %q", entries[1].Diff)
+	}
+}
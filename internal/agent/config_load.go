@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig layers the config file, WALSHIP_* environment variables and
+// the flags already set on cfg, then fills in node identity and validates.
+// Precedence is flags > environment > file > defaults: flags named in changed
+// are never overwritten.
+//
+// The file is path if given, else $WALSHIP_CONFIG, else
+// DefaultConfigPath(). A file named explicitly must exist; the default one
+// is optional. Files ending in .yaml or .yml are read as YAML with the same
+// keys as TOML.
+func LoadConfig(cfg *Config, path string, changed map[string]bool) error {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv("WALSHIP_CONFIG")
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+
+	if path != "" && (explicit || fileExists(path)) {
+		fc, err := readConfigFile(path)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		if err := applyFileConfig(cfg, fc, changed); err != nil {
+			return err
+		}
+	}
+	if err := ApplyEnvConfig(cfg, changed); err != nil {
+		return err
+	}
+	// Each of several nodes' identity is read when Run starts it.
+	if len(cfg.NodeHomes) == 0 {
+		if err := LoadNodeInfo(cfg); err != nil {
+			return err
+		}
+	}
+	return cfg.Validate()
+}
+
+// ReloadConfig re-reads the config file and environment for a running
+// agent started with cur. Settings whose flags are named in changed keep
+// their values from cur; every other setting is layered afresh on its
+// default, so a key removed from the file reverts. Callbacks and plugin
+// hooks are carried over.
+func ReloadConfig(cur Config, path string, changed map[string]bool) (Config, error) {
+	next := DefaultConfig()
+	nv, cv := reflect.ValueOf(&next).Elem(), reflect.ValueOf(cur)
+	for _, o := range ConfigSchema() {
+		if o.Flag == "" || !changed[o.Flag] {
+			continue
+		}
+		nf, cf := nv, cv
+		for _, name := range strings.Split(o.Field, ".") {
+			nf, cf = nf.FieldByName(name), cf.FieldByName(name)
+		}
+		nf.Set(cf)
+	}
+	next.OnSendSuccess, next.OnSendError, next.OnRetry = cur.OnSendSuccess, cur.OnSendError, cur.OnRetry
+	next.OnGapDetected, next.OnConfigShipped, next.FrameFilter = cur.OnGapDetected, cur.OnConfigShipped, cur.FrameFilter
+	next.PluginHooks = cur.PluginHooks
+	if err := LoadConfig(&next, path, changed); err != nil {
+		return Config{}, err
+	}
+	return next, nil
+}
+
+// readConfigFile parses a TOML or, by extension, YAML config file.
+func readConfigFile(path string) (fileConfig, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return loadYAMLFileConfig(path)
+	}
+	return loadFileConfig(path)
+}
+
+// loadYAMLFileConfig reads a YAML config file. It is re-encoded as TOML so
+// both formats share fileConfig's keys and conversions.
+func loadYAMLFileConfig(path string) (fileConfig, error) {
+	var fc fileConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return fc, err
+	}
+	tb, err := toml.Marshal(m)
+	if err != nil {
+		return fc, fmt.Errorf("convert yaml: %w", err)
+	}
+	if err := toml.Unmarshal(tb, &fc); err != nil {
+		return fc, err
+	}
+	return fc, nil
+}
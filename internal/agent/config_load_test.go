@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	const tomlConfig = `node_home = "/srv/node"
+node_id = "n1"
+poll_interval = "3s"
+cpu_threshold = 1
+
+[[watch_files]]
+path = "config/client.toml"
+`
+	const yamlConfig = `node_home: /srv/node
+node_id: n1
+poll_interval: 3s
+cpu_threshold: 1
+watch_files:
+  - path: config/client.toml
+`
+	tests := []struct {
+		name      string
+		file      string // written to dir/file
+		content   string
+		path      string // LoadConfig path argument, relative to dir
+		envConfig string // WALSHIP_CONFIG, relative to dir
+		env       map[string]string
+		changed   map[string]bool
+		flagPoll  time.Duration
+		wantPoll  time.Duration
+		wantWatch int
+		wantErr   bool
+	}{
+		{name: "toml", file: "walship.toml", content: tomlConfig, path: "walship.toml", wantPoll: 3 * time.Second, wantWatch: 1},
+		{name: "yaml", file: "walship.yaml", content: yamlConfig, path: "walship.yaml", wantPoll: 3 * time.Second, wantWatch: 1},
+		{name: "path from WALSHIP_CONFIG", file: "w.yml", content: yamlConfig, envConfig: "w.yml", wantPoll: 3 * time.Second, wantWatch: 1},
+		{name: "env overrides file", file: "walship.toml", content: tomlConfig, path: "walship.toml",
+			env: map[string]string{"WALSHIP_POLL_INTERVAL": "4s"}, wantPoll: 4 * time.Second, wantWatch: 1},
+		{name: "flag overrides env", file: "walship.toml", content: tomlConfig, path: "walship.toml",
+			env: map[string]string{"WALSHIP_POLL_INTERVAL": "4s"}, changed: map[string]bool{"poll": true}, flagPoll: 5 * time.Second,
+			wantPoll: 5 * time.Second, wantWatch: 1},
+		{name: "explicit file missing", path: "missing.toml", wantErr: true},
+		{name: "WALSHIP_CONFIG file missing", envConfig: "missing.yaml", wantErr: true},
+		{name: "invalid yaml", file: "bad.yaml", content: "node_home: [", path: "bad.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("HOME", dir) // no default config file
+			t.Setenv("WALSHIP_CONFIG", "")
+			t.Setenv("WALSHIP_POLL_INTERVAL", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.file != "" {
+				if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.envConfig != "" {
+				t.Setenv("WALSHIP_CONFIG", filepath.Join(dir, tt.envConfig))
+			}
+			path := ""
+			if tt.path != "" {
+				path = filepath.Join(dir, tt.path)
+			}
+
+			cfg := DefaultConfig()
+			cfg.ChainID = "chain-1"
+			if tt.flagPoll != 0 {
+				cfg.PollInterval = tt.flagPoll
+			}
+			err := LoadConfig(&cfg, path, tt.changed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.NodeHome != "/srv/node" || cfg.NodeID != "n1" || cfg.CPUThreshold != 1 {
+				t.Errorf("file values not applied: %+v", cfg)
+			}
+			if cfg.PollInterval != tt.wantPoll {
+				t.Errorf("PollInterval = %v, want %v", cfg.PollInterval, tt.wantPoll)
+			}
+			if len(cfg.WatchFiles) != tt.wantWatch {
+				t.Errorf("WatchFiles = %v", cfg.WatchFiles)
+			}
+			if cfg.WALDir != filepath.Join("/srv/node", "data", "log.wal", "node-n1") {
+				t.Errorf("WALDir = %q, want derived by Validate", cfg.WALDir)
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("WALSHIP_CONFIG", "")
+	t.Setenv("WALSHIP_POLL_INTERVAL", "")
+	home := filepath.Join(dir, "node")
+	writeNodeHome(t, home, "chain-1")
+	path := filepath.Join(dir, "walship.toml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("node_home = \""+home+"\"\n"+content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("poll_interval = \"3s\"\ncpu_threshold = 1\n")
+	changed := map[string]bool{"send-interval": true}
+	cur := DefaultConfig()
+	cur.SendInterval = 7 * time.Second
+	if err := LoadConfig(&cur, path, changed); err != nil {
+		t.Fatal(err)
+	}
+
+	write("poll_interval = \"4s\"\n")
+	next, err := ReloadConfig(cur, path, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.PollInterval != 4*time.Second {
+		t.Errorf("PollInterval = %v, want 4s from the file", next.PollInterval)
+	}
+	if d := DefaultConfig(); next.CPUThreshold != d.CPUThreshold {
+		t.Errorf("CPUThreshold = %v, want the default once removed from the file", next.CPUThreshold)
+	}
+	if next.SendInterval != 7*time.Second {
+		t.Errorf("SendInterval = %v, want the flag's 7s", next.SendInterval)
+	}
+	if next.ChainID != "chain-1" || next.NodeID != cur.NodeID {
+		t.Errorf("node identity = %s/%s, want %s/%s", next.ChainID, next.NodeID, cur.ChainID, cur.NodeID)
+	}
+
+	write("poll_interval = \"soon\"\n")
+	if _, err := ReloadConfig(cur, path, changed); err == nil {
+		t.Error("invalid file reloaded")
+	}
+}
@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigOption describes one configurable setting and every way to set it.
+type ConfigOption struct {
+	Field       string `json:"field"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Flag        string `json:"flag,omitempty"`
+	Env         string `json:"env,omitempty"`
+	File        string `json:"file,omitempty"`
+	Constraints string `json:"constraints,omitempty"`
+	Description string `json:"description"`
+}
+
+// ConfigSchema returns a machine-readable description of all Config options.
+// Precedence is flag > environment > file > default.
+func ConfigSchema() []ConfigOption {
+	d := DefaultConfig()
+	return []ConfigOption{
+		{Field: "NodeHome", Type: "string", Flag: "node-home", Env: "WALSHIP_NODE_HOME", File: "node_home",
+			Constraints: "required unless node-homes is set", Description: "application home directory"},
+		{Field: "NodeHomes", Type: "[]string", Flag: "node-homes", Env: "WALSHIP_NODE_HOMES", File: "node_homes",
+			Constraints: "paths or glob patterns; excludes node-home, wal-dir, node-id",
+			Description: "ship several nodes from one process, each with its own identity and state dir (<state-dir>/<home name>, else its WAL dir); env entries are ','-separated"},
+		{Field: "NodeID", Type: "string", Default: d.NodeID, Env: "WALSHIP_NODE_ID", File: "node_id",
+			Description: "node ID; read from config/node_key.json when unset or \"default\""},
+		{Field: "ChainID", Type: "string",
+			Description: "chain ID; read from config/genesis.json"},
+		{Field: "WALDir", Type: "string", Flag: "wal-dir", Env: "WALSHIP_WAL_DIR", File: "wal_dir",
+			Description: "WAL directory containing .idx/.gz pairs; defaults to <node-home>/data/log.wal/node-<node-id>"},
+		{Field: "ServiceURL", Type: "string", Default: d.ServiceURL, Flag: "service-url", Env: "WALSHIP_SERVICE_URL", File: "service_url",
+			Description: "base service URL; trailing slash is trimmed"},
+		{Field: "IngestPathPrefix", Type: "string", Default: d.IngestPathPrefix, Flag: "ingest-path-prefix", Env: "WALSHIP_INGEST_PATH_PREFIX", File: "ingest_path_prefix",
+			Constraints: "absolute path", Description: "path of the ingest endpoints under service-url, for services behind path-rewriting gateways; / puts them at the root"},
+		{Field: "AuthKey", Type: "string", Flag: "auth-key", Env: "WALSHIP_AUTH_KEY", File: "auth_key",
+			Description: "API key for authentication"},
+		{Field: "AuthKeys", Type: "map[string]string", Flag: "auth-keys", Env: "WALSHIP_AUTH_KEYS", File: "auth_keys",
+			Constraints: "chain-id=key pairs", Description: "per-chain API keys; uploads for chains without an entry use auth-key"},
+		{Field: "TLSPins", Type: "[]string", Flag: "tls-pins", Env: "WALSHIP_TLS_PINS", File: "tls_pins",
+			Constraints: "sha256/<base64 SPKI hash> or cert-sha256/<hex fingerprint>", Description: "refuse TLS connections to service-url and grpc-target unless a certificate in the chain matches one of these pins"},
+		{Field: "ClientCertFile", Type: "string", Flag: "tls-client-cert", Env: "WALSHIP_TLS_CLIENT_CERT", File: "tls_client_cert",
+			Constraints: "PEM; requires tls-client-key", Description: "client certificate presented to servers that request one (mutual TLS); re-read on every handshake"},
+		{Field: "ClientKeyFile", Type: "string", Flag: "tls-client-key", Env: "WALSHIP_TLS_CLIENT_KEY", File: "tls_client_key",
+			Constraints: "PEM; requires tls-client-cert", Description: "private key of tls-client-cert"},
+		{Field: "CAFile", Type: "string", Flag: "tls-ca-file", Env: "WALSHIP_TLS_CA_FILE", File: "tls_ca_file",
+			Constraints: "PEM", Description: "CA certificates trusted in addition to the system roots, for services with a private CA"},
+		{Field: "InsecureSkipVerify", Type: "bool", Default: fmt.Sprint(d.InsecureSkipVerify), Flag: "tls-insecure-skip-verify", Env: "WALSHIP_TLS_INSECURE_SKIP_VERIFY", File: "tls_insecure_skip_verify",
+			Description: "accept any server certificate (testing only); tls-pins are then checked on every connection"},
+		{Field: "ProxyURL", Type: "string", Flag: "proxy-url", Env: "WALSHIP_PROXY_URL", File: "proxy_url",
+			Constraints: "http://, https://, socks5:// or socks5h:// URL", Description: "proxy of HTTP requests to the service, object store and tracing endpoint, in place of HTTPS_PROXY; NO_PROXY still applies; reloadable"},
+		{Field: "GRPCTarget", Type: "string", Flag: "grpc-target", Env: "WALSHIP_GRPC_TARGET", File: "grpc_target",
+			Constraints: "host:port", Description: "stream frames over gRPC (walship.v1.Ingest/StreamFrames) to this address instead of HTTP; config and other uploads still use service-url"},
+		{Field: "GRPCInsecure", Type: "bool", Default: fmt.Sprint(d.GRPCInsecure), Flag: "grpc-insecure", Env: "WALSHIP_GRPC_INSECURE", File: "grpc_insecure",
+			Description: "disable TLS for grpc-target"},
+		{Field: "KafkaBrokers", Type: "[]string", Flag: "kafka-brokers", Env: "WALSHIP_KAFKA_BROKERS", File: "kafka_brokers",
+			Constraints: "host:port list; not with grpc-target", Description: "experimental: publish frames to this Kafka cluster instead of the service, as an idempotent producer without SASL or compression; config and other uploads still use service-url"},
+		{Field: "KafkaTopic", Type: "string", Flag: "kafka-topic", Env: "WALSHIP_KAFKA_TOPIC", File: "kafka_topic",
+			Constraints: "required with kafka-brokers; must exist", Description: "Kafka topic receiving one walship.v1.Frame record per frame, keyed by chain-id/node-id"},
+		{Field: "KafkaTLS", Type: "bool", Default: fmt.Sprint(d.KafkaTLS), Flag: "kafka-tls", Env: "WALSHIP_KAFKA_TLS", File: "kafka_tls",
+			Description: "use TLS to the Kafka brokers, with the client certificate, CA file, TLS pins and insecure-skip-verify of the service"},
+		{Field: "ObjectStoreBucket", Type: "string", Flag: "object-store-bucket", Env: "WALSHIP_OBJECT_STORE_BUCKET", File: "object_store_bucket",
+			Constraints: "not with grpc-target or kafka-brokers", Description: "write each batch as a gzip object to this S3-compatible bucket instead of the service; credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"},
+		{Field: "ObjectStoreEndpoint", Type: "string", Flag: "object-store-endpoint", Env: "WALSHIP_OBJECT_STORE_ENDPOINT", File: "object_store_endpoint",
+			Constraints: "http(s) URL", Description: "object storage endpoint, e.g. https://storage.googleapis.com or a MinIO server; default the AWS S3 endpoint of object-store-region"},
+		{Field: "ObjectStoreRegion", Type: "string", Flag: "object-store-region", Env: "WALSHIP_OBJECT_STORE_REGION", File: "object_store_region",
+			Description: "region requests are signed for (default us-east-1; auto for GCS)"},
+		{Field: "ObjectStorePrefix", Type: "string", Flag: "object-store-prefix", Env: "WALSHIP_OBJECT_STORE_PREFIX", File: "object_store_prefix",
+			Description: "prefix of the object keys, which continue {chain}/{node}/{date}/{segment}-{first}-{last}.gz"},
+		{Field: "ObjectStoreSSE", Type: "string", Flag: "object-store-sse", Env: "WALSHIP_OBJECT_STORE_SSE", File: "object_store_sse",
+			Constraints: "AES256|aws:kms", Description: "server-side encryption of the objects"},
+		{Field: "ObjectStoreKMSKeyID", Type: "string", Flag: "object-store-kms-key-id", Env: "WALSHIP_OBJECT_STORE_KMS_KEY_ID", File: "object_store_kms_key_id",
+			Constraints: "with object-store-sse aws:kms", Description: "KMS key encrypting the objects; default the bucket's"},
+		{Field: "RemoteWriteURL", Type: "string", Flag: "remote-write-url", Env: "WALSHIP_REMOTE_WRITE_URL", File: "remote_write_url",
+			Description: "Prometheus remote-write URL for agent metrics; credentials may be given as URL userinfo"},
+		{Field: "StatsDAddr", Type: "string", Flag: "statsd-addr", Env: "WALSHIP_STATSD_ADDR", File: "statsd_addr",
+			Description: "host:port of a StatsD/DogStatsD agent for agent metrics (UDP)"},
+		{Field: "StatsDFlavor", Type: "string", Default: d.StatsDFlavor, Flag: "statsd-flavor", Env: "WALSHIP_STATSD_FLAVOR", File: "statsd_flavor",
+			Constraints: "dogstatsd|statsd", Description: "dogstatsd sends chain/node IDs as tags; statsd folds them into metric names"},
+		{Field: "NodeMetricsInterval", Type: "duration", Flag: "node-metrics-interval", Env: "WALSHIP_NODE_METRICS_INTERVAL", File: "node_metrics_interval",
+			Description: "how often to scrape the node's own Prometheus endpoint and ship the snapshot to the service; 0 disables (not when anonymizing)"},
+		{Field: "NodeMetricsURL", Type: "string", Flag: "node-metrics-url", Env: "WALSHIP_NODE_METRICS_URL", File: "node_metrics_url",
+			Description: "the node's Prometheus endpoint; default from prometheus_listen_addr in config.toml when instrumentation is enabled"},
+		{Field: "AckLatencySLO", Type: "duration", Flag: "ack-latency-slo", Env: "WALSHIP_ACK_LATENCY_SLO", File: "ack_latency_slo",
+			Description: "time from WAL write to the service's acknowledgement that recent frames must stay under at ack-latency-percentile; exceeding it records a degraded event; 0 disables; reloadable"},
+		{Field: "AckLatencyPercentile", Type: "int", Default: fmt.Sprint(defaultAckLatencyPercentile), Flag: "ack-latency-percentile", Env: "WALSHIP_ACK_LATENCY_PERCENTILE", File: "ack_latency_percentile",
+			Constraints: "50|95|99", Description: "percentile of ack latency that ack-latency-slo applies to; reloadable"},
+		{Field: "LogLevel", Type: "string", Default: d.LogLevel, Flag: "log-level", Env: "WALSHIP_LOG_LEVEL", File: "log_level",
+			Constraints: "debug, info, warn or error", Description: "drop log events below this level; reloadable"},
+		{Field: "MetricsAddr", Type: "string", Flag: "metrics-addr", Env: "WALSHIP_METRICS_ADDR", File: "metrics_addr",
+			Constraints: "host:port", Description: "serve Prometheus metrics at /metrics and the walship expvar at /debug/vars on this address"},
+		{Field: "AdminAddr", Type: "string", Flag: "admin-addr", Env: "WALSHIP_ADMIN_ADDR", File: "admin_addr",
+			Constraints: "host:port", Description: "serve the admin API (state, WAL position, lag, plugins, POST /flush) on this address; must be loopback unless an admin token is set"},
+		{Field: "AdminToken", Type: "string", Flag: "admin-token", Env: "WALSHIP_ADMIN_TOKEN", File: "admin_token",
+			Description: "require this bearer token on every admin API request"},
+		{Field: "Tracing.Exporter", Type: "string", Flag: "tracing-exporter", Env: "WALSHIP_TRACING_EXPORTER", File: "tracing.exporter",
+			Constraints: "otlp-http|otlp-grpc", Description: "export OpenTelemetry spans of WAL read, batch, compress and send to a collector; empty disables tracing"},
+		{Field: "Tracing.Endpoint", Type: "string", Flag: "tracing-endpoint", Env: "WALSHIP_TRACING_ENDPOINT", File: "tracing.endpoint",
+			Constraints: "required with tracing-exporter; URL for otlp-http, host:port for otlp-grpc", Description: "OTLP collector endpoint; an otlp-http URL without a path gets /v1/traces"},
+		{Field: "Tracing.Insecure", Type: "bool", Default: fmt.Sprint(d.Tracing.Insecure), Flag: "tracing-insecure", Env: "WALSHIP_TRACING_INSECURE", File: "tracing.insecure",
+			Description: "disable TLS to an otlp-grpc collector"},
+		{Field: "Tracing.SampleRatio", Type: "float", Default: fmt.Sprint(d.Tracing.SampleRatio), Flag: "tracing-sample-ratio", Env: "WALSHIP_TRACING_SAMPLE_RATIO", File: "tracing.sample_ratio",
+			Constraints: "0-1", Description: "fraction of batches traced"},
+		{Field: "PollInterval", Type: "duration", Default: d.PollInterval.String(), Flag: "poll", Env: "WALSHIP_POLL_INTERVAL", File: "poll_interval",
+			Constraints: "> 0", Description: "poll interval when idle"},
+		{Field: "MaxPollInterval", Type: "duration", Default: d.MaxPollInterval.String(), Flag: "max-poll", Env: "WALSHIP_MAX_POLL_INTERVAL", File: "max_poll_interval",
+			Constraints: ">= poll", Description: "poll interval cap after the WAL has been idle for a minute"},
+		{Field: "SendInterval", Type: "duration", Default: d.SendInterval.String(), Flag: "send-interval", Env: "WALSHIP_SEND_INTERVAL", File: "send_interval",
+			Constraints: "> 0", Description: "soft send interval"},
+		{Field: "HardInterval", Type: "duration", Default: d.HardInterval.String(), Flag: "hard-interval", Env: "WALSHIP_HARD_INTERVAL", File: "hard_interval",
+			Description: "hard send interval (override gating)"},
+		{Field: "HTTPTimeout", Type: "duration", Default: d.HTTPTimeout.String(), Flag: "timeout", Env: "WALSHIP_HTTP_TIMEOUT", File: "http_timeout",
+			Description: "HTTP timeout"},
+		{Field: "SendMaxAttempts", Type: "int", Default: fmt.Sprint(d.SendMaxAttempts), Flag: "send-max-attempts", Env: "WALSHIP_SEND_MAX_ATTEMPTS", File: "send_max_attempts",
+			Constraints: ">= 0", Description: "attempts per HTTP batch upload before giving up until the next pass; 5xx, 408, 429 and network errors are retried, other 4xx are not"},
+		{Field: "SendRetryBase", Type: "duration", Default: d.SendRetryBase.String(), Flag: "send-retry-base", Env: "WALSHIP_SEND_RETRY_BASE", File: "send_retry_base",
+			Constraints: "> 0", Description: "first retry delay, doubled (with jitter) on each further retry"},
+		{Field: "SendRetryMax", Type: "duration", Default: d.SendRetryMax.String(), Flag: "send-retry-max", Env: "WALSHIP_SEND_RETRY_MAX", File: "send_retry_max",
+			Constraints: ">= send-retry-base", Description: "longest retry delay, also capping the server's Retry-After"},
+		{Field: "CommitMode", Type: "string", Default: d.CommitMode, Flag: "commit-mode", Env: "WALSHIP_COMMIT_MODE", File: "commit_mode",
+			Constraints: "ack|periodic", Description: "ack persists the position only after the service accepts frames (may resend on crash); periodic also persists the read position every commit-interval (may skip unsent frames on crash)"},
+		{Field: "CommitInterval", Type: "duration", Default: d.CommitInterval.String(), Flag: "commit-interval", Env: "WALSHIP_COMMIT_INTERVAL", File: "commit_interval",
+			Constraints: "> 0 with periodic", Description: "how often the read position is persisted in periodic commit mode"},
+		{Field: "Preflight", Type: "string", Default: d.Preflight, Flag: "preflight", Env: "WALSHIP_PREFLIGHT", File: "preflight",
+			Constraints: "off|warn|strict", Description: "startup checks (WAL readable, state writable, DNS, auth, clock): warn logs failures and starts anyway, strict refuses to start"},
+		{Field: "StartFrom", Type: "string", Default: d.StartFrom, Flag: "start-from", Env: "WALSHIP_START_FROM", File: "start_from",
+			Constraints: "oldest|latest|time:<RFC3339>|height:<n>", Description: "where an agent without saved state starts shipping; latest skips the existing backlog"},
+		{Field: "ChainMismatch", Type: "string", Default: d.ChainMismatch, Flag: "on-chain-mismatch", Env: "WALSHIP_ON_CHAIN_MISMATCH", File: "on_chain_mismatch",
+			Constraints: "refuse|reset", Description: "what to do when the state directory was written for another chain (different chain-id or genesis.json)"},
+		{Field: "WALRelocate", Type: "string", Default: d.WALRelocate, Flag: "wal-relocate", Env: "WALSHIP_WAL_RELOCATE", File: "wal_relocate",
+			Constraints: "off|warn|follow", Description: "when wal-dir loses its WAL but another node-<id> dir under the same log.wal has one (node ID change, data move): ignore it, log it for the operator, or follow it when it is node-<node-id> (another node's WAL is only logged)"},
+		{Field: "CPUThreshold", Type: "float", Default: fmt.Sprint(d.CPUThreshold), Flag: "cpu-threshold", Env: "WALSHIP_CPU_THRESHOLD", File: "cpu_threshold",
+			Description: "max host CPU usage fraction, averaged over 10s, before delaying send; 0 disables"},
+		{Field: "NetThreshold", Type: "float", Default: fmt.Sprint(d.NetThreshold), Flag: "net-threshold", Env: "WALSHIP_NET_THRESHOLD", File: "net_threshold",
+			Description: "max NIC usage fraction of iface-speed, averaged over 10s, before delaying send; 0 disables"},
+		{Field: "Iface", Type: "string", Flag: "iface", Env: "WALSHIP_IFACE", File: "iface",
+			Description: "network interface to monitor; if empty, the one carrying the default route (re-detected when routes change), or all but loopback if there is none"},
+		{Field: "NetProbe", Type: "string", Default: d.NetProbe, Flag: "net-probe", Env: "WALSHIP_NET_PROBE", File: "net_probe",
+			Constraints: "host|process", Description: "whose traffic net-threshold applies to: every interface of the host, or the node process's network namespace (found via wal-writer-file or its open WAL files), which falls back to the host's if the node shares the agent's namespace"},
+		{Field: "IfaceSpeedMbps", Type: "int", Default: fmt.Sprint(d.IfaceSpeedMbps), Flag: "iface-speed", Env: "WALSHIP_IFACE_SPEED_MBPS", File: "iface_speed_mbps",
+			Description: "interface speed in Mbps (used for utilization); 0 reads it from /sys/class/net, falling back to 1000"},
+		{Field: "MaxBatchBytes", Type: "int", Default: fmt.Sprint(d.MaxBatchBytes), Flag: "max-batch-bytes", Env: "WALSHIP_MAX_BATCH_BYTES", File: "max_batch_bytes",
+			Description: "maximum compressed bytes per batch"},
+		{Field: "CompressionLevel", Type: "int", Default: fmt.Sprint(d.CompressionLevel), Flag: "compression-level", Env: "WALSHIP_COMPRESSION_LEVEL", File: "compression_level",
+			Constraints: "1-9", Description: "gzip level for upload bodies the agent compresses itself"},
+		{Field: "FrameEncoding", Type: "string", Default: d.FrameEncoding, Flag: "frame-encoding", Env: "WALSHIP_FRAME_ENCODING", File: "frame_encoding",
+			Constraints: "gzip|zstd", Description: "encoding of uploaded frames; zstd re-encodes them with a shared dictionary trained on recent WAL content (HTTP multipart uploads only)"},
+		{Field: "CanaryPercent", Type: "float", Default: fmt.Sprint(d.CanaryPercent), Flag: "canary-percent", Env: "WALSHIP_CANARY_PERCENT", File: "canary_percent",
+			Constraints: "0-100; needs canary-grpc-target or canary-frame-encoding and the HTTP transport", Description: "percentage of batches, chosen at random, sent over the canary transport or codec instead of the stable path; reloadable"},
+		{Field: "CanaryGRPCTarget", Type: "string", Flag: "canary-grpc-target", Env: "WALSHIP_CANARY_GRPC_TARGET", File: "canary_grpc_target",
+			Constraints: "host:port; not with canary-frame-encoding", Description: "stream canary batches over gRPC to this address; TLS follows grpc-insecure"},
+		{Field: "CanaryFrameEncoding", Type: "string", Flag: "canary-frame-encoding", Env: "WALSHIP_CANARY_FRAME_ENCODING", File: "canary_frame_encoding",
+			Constraints: "gzip|zstd", Description: "frame encoding of canary batches, sent over HTTP; reloadable"},
+		{Field: "ResumableUploadBytes", Type: "int", Default: fmt.Sprint(d.ResumableUploadBytes), Flag: "resumable-upload-bytes", Env: "WALSHIP_RESUMABLE_UPLOAD_BYTES", File: "resumable_upload_bytes",
+			Constraints: ">= 0", Description: "send batches of at least this many bytes as resumable upload sessions; 0 disables"},
+		{Field: "StreamUploadBytes", Type: "int", Default: fmt.Sprint(d.StreamUploadBytes), Flag: "stream-upload-bytes", Env: "WALSHIP_STREAM_UPLOAD_BYTES", File: "stream_upload_bytes",
+			Constraints: "0 or > max-batch-bytes; HTTP transport, gzip frames, not with anonymize", Description: "while catching up, stream batches into one chunked request of up to this many bytes, flushed every max-batch-bytes; 0 disables; reloadable"},
+		{Field: "MaxUploadBytesPerSec", Type: "int", Default: fmt.Sprint(d.MaxUploadBytesPerSec), Flag: "max-upload-bytes-per-sec", Env: "WALSHIP_MAX_UPLOAD_BYTES_PER_SEC", File: "max_upload_bytes_per_sec",
+			Constraints: ">= 0", Description: "cap HTTP frame uploads of all nodes at this many bytes per second, so a catch-up cannot saturate the NIC; 0 disables"},
+		{Field: "MaxReadBytesPerSec", Type: "int", Default: fmt.Sprint(d.MaxReadBytesPerSec), Flag: "max-read-bytes-per-sec", Env: "WALSHIP_MAX_READ_BYTES_PER_SEC", File: "max_read_bytes_per_sec",
+			Constraints: ">= 0", Description: "cap WAL frame reads of all nodes and backfill at this many bytes per second, so a catch-up cannot starve the node's disk I/O; 0 disables"},
+		{Field: "SpoolMaxBytes", Type: "int", Default: fmt.Sprint(d.SpoolMaxBytes), Flag: "spool-max-bytes", Env: "WALSHIP_SPOOL_MAX_BYTES", File: "spool_max_bytes",
+			Constraints: ">= 0", Description: "spool batches the service cannot take to state-dir/spool, up to this many bytes (oldest evicted first), and drain them once it recovers; 0 disables"},
+		{Field: "SpoolMaxAge", Type: "duration", Default: d.SpoolMaxAge.String(), Flag: "spool-max-age", Env: "WALSHIP_SPOOL_MAX_AGE", File: "spool_max_age",
+			Constraints: ">= 0", Description: "evict spooled batches older than this; 0 keeps them until spool-max-bytes is reached"},
+		{Field: "ArchiveDir", Type: "string", Flag: "archive-dir", Env: "WALSHIP_ARCHIVE_DIR", File: "archive_dir",
+			Description: "copy WAL segments here (e.g. an NFS or S3 bucket mount) and verify their SHA-256 before cleanup deletes them; a segment that fails to archive is kept"},
+		{Field: "ArchiveAfter", Type: "duration", Default: d.ArchiveAfter.String(), Flag: "archive-after", Env: "WALSHIP_ARCHIVE_AFTER", File: "archive_after",
+			Constraints: ">= 0; requires archive-dir", Description: "archive and remove WAL segments last written longer ago than this, regardless of WAL dir size; 0 disables"},
+		{Field: "RetentionMaxAge", Type: "duration", Default: d.RetentionMaxAge.String(), Flag: "retention-max-age", Env: "WALSHIP_RETENTION_MAX_AGE", File: "retention_max_age",
+			Constraints: ">= 0", Description: "remove acknowledged WAL segments last written longer ago than this; any retention rule replaces the size watermarks of cleanup; 0 disables"},
+		{Field: "RetentionMaxBytes", Type: "int", Default: fmt.Sprint(d.RetentionMaxBytes), Flag: "retention-max-bytes", Env: "WALSHIP_RETENTION_MAX_BYTES", File: "retention_max_bytes",
+			Constraints: ">= 0", Description: "remove acknowledged WAL segments, oldest first, while the WAL dir is larger than this; 0 disables"},
+		{Field: "RetentionMinFreePercent", Type: "float", Default: fmt.Sprint(d.RetentionMinFreePercent), Flag: "retention-min-free-percent", Env: "WALSHIP_RETENTION_MIN_FREE_PERCENT", File: "retention_min_free_percent",
+			Constraints: "0-100; Linux only", Description: "remove acknowledged WAL segments, oldest first, while the WAL disk has less than this percentage free; 0 disables"},
+		{Field: "ShutdownTimeout", Type: "duration", Default: d.ShutdownTimeout.String(), Flag: "shutdown-timeout", Env: "WALSHIP_SHUTDOWN_TIMEOUT", File: "shutdown_timeout",
+			Constraints: ">= 0", Description: "bound on each shutdown stage (stop readers, flush batch, drain sender, commit state, stop scrapers, stop plugins); a stage that overruns is abandoned"},
+		{Field: "StateDir", Type: "string", Flag: "state-dir", Env: "WALSHIP_STATE_DIR", File: "state_dir",
+			Description: "state directory for status.json; defaults to wal-dir"},
+		{Field: "StateBackend", Type: "string", Default: d.StateBackend, Flag: "state-backend", Env: "WALSHIP_STATE_BACKEND", File: "state_backend",
+			Constraints: "json|bolt|sqlite", Description: "where the shipping position is kept in state-dir: status.json, a bbolt state.bolt, or a single-row SQLite state.db (needs a cgo build); switching migrates the position"},
+		{Field: "Verify", Type: "bool", Default: fmt.Sprint(d.Verify), Flag: "verify", Env: "WALSHIP_VERIFY", File: "verify",
+			Description: "verify CRC/line counts while reading (debug)"},
+		{Field: "DecodeConsensus", Type: "bool", Default: fmt.Sprint(d.DecodeConsensus), Flag: "decode-consensus", Env: "WALSHIP_DECODE_CONSENSUS", File: "decode_consensus",
+			Description: "also decode proposals, votes and block parts from shipped frames and send them as structured events"},
+		{Field: "ConsensusKinds", Type: "string", Flag: "consensus-kinds", Env: "WALSHIP_CONSENSUS_KINDS", File: "consensus_kinds",
+			Constraints: "comma-separated proposal|prevote|precommit|block_part", Description: "consensus event kinds to send with decode-consensus; empty sends all"},
+		{Field: "VoteLatency", Type: "bool", Default: fmt.Sprint(d.VoteLatency), Flag: "vote-latency", Env: "WALSHIP_VOTE_LATENCY", File: "vote_latency",
+			Description: "derive per peer and validator vote latencies (receive time less vote timestamp) from shipped frames and send them"},
+		{Field: "HeightSummaries", Type: "bool", Default: fmt.Sprint(d.HeightSummaries), Flag: "height-summaries", Env: "WALSHIP_HEIGHT_SUMMARIES", File: "height_summaries",
+			Description: "derive each height's round count and step durations from shipped frames and send them as a summary stream"},
+		{Field: "FrameTypeStats", Type: "bool", Default: fmt.Sprint(d.FrameTypeStats), Flag: "frame-type-stats", Env: "WALSHIP_FRAME_TYPE_STATS", File: "frame_type_stats",
+			Description: "count records by message type (vote, proposal, block_part, timeout, other) and send the counts with each batch"},
+		{Field: "SampleEveryN", Type: "int", Default: fmt.Sprint(d.SampleEveryN), Flag: "sample-every-n", Env: "WALSHIP_SAMPLE_EVERY_N", File: "sample_every_n",
+			Constraints: ">= 0", Description: "ship only frames whose number is a multiple of N; 0 ships every frame"},
+		{Field: "SampleTypes", Type: "string", Flag: "sample-types", Env: "WALSHIP_SAMPLE_TYPES", File: "sample_types",
+			Constraints: "comma-separated vote|proposal|block_part|timeout|other", Description: "ship only frames holding a record of one of these message types; empty ships every frame"},
+		{Field: "SampleHeightModulo", Type: "int", Default: fmt.Sprint(d.SampleHeightModulo), Flag: "sample-height-modulo", Env: "WALSHIP_SAMPLE_HEIGHT_MODULO", File: "sample_height_modulo",
+			Constraints: ">= 0", Description: "ship only frames with a proposal, vote or block part at a height that is a multiple of N; 0 ships every frame"},
+		{Field: "ReportGaps", Type: "bool", Default: fmt.Sprint(d.ReportGaps), Flag: "report-gaps", Env: "WALSHIP_REPORT_GAPS", File: "report_gaps",
+			Description: "send detected WAL gaps (missing frame numbers or deleted segments) to the service's gap report endpoint"},
+		{Field: "CSWALDir", Type: "string", Flag: "cs-wal-dir", Env: "WALSHIP_CS_WAL_DIR", File: "cs_wal_dir",
+			Constraints: "relative to node-home unless absolute", Description: "CometBFT consensus WAL dir (data/cs.wal) whose proposals, votes and block parts are shipped as consensus events, for nodes without the memlogger WAL"},
+		{Field: "Ledger", Type: "bool", Default: fmt.Sprint(d.Ledger), Flag: "ledger", Env: "WALSHIP_LEDGER", File: "ledger",
+			Description: "record each delivered batch with its heights in state-dir/ledger.bolt; query with walship ledger query"},
+		{Field: "Anonymize", Type: "bool", Default: fmt.Sprint(d.Anonymize), Flag: "anonymize", Env: "WALSHIP_ANONYMIZE", File: "anonymize",
+			Constraints: "requires anonymize-salt", Description: "hash the node ID and peer IDs before upload, withhold the hostname and skip config shipping, for contributing to public datasets"},
+		{Field: "AnonymizeSalt", Type: "string", Flag: "anonymize-salt", Env: "WALSHIP_ANONYMIZE_SALT", File: "anonymize_salt",
+			Description: "per-operator secret keying the anonymized IDs; keep it stable so IDs stay linkable across restarts"},
+		{Field: "NoAtime", Type: "bool", Default: fmt.Sprint(d.NoAtime), Flag: "noatime", Env: "WALSHIP_NOATIME", File: "noatime",
+			Description: "open WAL and node config files with O_NOATIME (Linux; ignored elsewhere)"},
+		{Field: "Meta", Type: "bool", Default: fmt.Sprint(d.Meta), Flag: "meta", Env: "WALSHIP_META", File: "meta",
+			Description: "print frame metadata to stderr (debug)"},
+		{Field: "Once", Type: "bool", Default: fmt.Sprint(d.Once), Flag: "once", Env: "WALSHIP_ONCE", File: "once",
+			Description: "process available frames and exit"},
+		{Field: "DryRun", Type: "bool", Default: fmt.Sprint(d.DryRun), Flag: "dry-run", Env: "WALSHIP_DRY_RUN", File: "dry_run",
+			Description: "run the pipeline but print each batch (frames, bytes, target) instead of sending it; other requests are not sent and the saved position is not moved"},
+		{Field: "ShipConfig", Type: "bool", Default: fmt.Sprint(d.ShipConfig), Flag: "ship-config", Env: "WALSHIP_SHIP_CONFIG", File: "ship_config",
+			Description: "watch and ship app.toml/config.toml"},
+		{Field: "ShipClientConfig", Type: "bool", Default: fmt.Sprint(d.ShipClientConfig), Flag: "ship-client-config", Env: "WALSHIP_SHIP_CLIENT_CONFIG", File: "ship_client_config",
+			Description: "also ship client.toml with the config files"},
+		{Field: "ShipGenesis", Type: "bool", Default: fmt.Sprint(d.ShipGenesis), Flag: "ship-genesis", Env: "WALSHIP_SHIP_GENESIS", File: "ship_genesis",
+			Description: "also ship genesis.json with the config files: its SHA-256, size and first 64KiB"},
+		{Field: "ConfigRedact", Type: "[]string", Default: strings.Join(d.ConfigRedact, ","), Flag: "config-redact", Env: "WALSHIP_CONFIG_REDACT", File: "config_redact",
+			Constraints: "key patterns; * matches any key characters",
+			Description: "keys whose values are replaced with ***REDACTED*** in app.toml/config.toml/client.toml before shipping; replaces the defaults, an empty list disables"},
+		{Field: "ConfigChurnLimit", Type: "int", Default: fmt.Sprint(d.ConfigChurnLimit), Flag: "config-churn-limit", Env: "WALSHIP_CONFIG_CHURN_LIMIT", File: "config_churn_limit",
+			Constraints: ">= 0", Description: "warn and flag config uploads when watched files change more than this many times within config-churn-window; 0 disables"},
+		{Field: "ConfigChurnWindow", Type: "duration", Default: d.ConfigChurnWindow.String(), Flag: "config-churn-window", Env: "WALSHIP_CONFIG_CHURN_WINDOW", File: "config_churn_window",
+			Constraints: "> 0 with config-churn-limit", Description: "window over which config changes are counted"},
+		{Field: "ConfigHistory", Type: "int", Default: fmt.Sprint(d.ConfigHistory), Flag: "config-history", Env: "WALSHIP_CONFIG_HISTORY", File: "config_history",
+			Constraints: ">= 0", Description: "shipped config snapshots (hash, time, diff) kept in the state dir for `walship config history`; 0 keeps none"},
+		{Field: "WALWriterFile", Type: "string", Flag: "wal-writer-file", Env: "WALSHIP_WAL_WRITER_FILE", File: "wal_writer_file",
+			Constraints: "relative to wal-dir", Description: "lock or heartbeat file kept fresh by the node's WAL writer (optionally holding its PID); reports whether the writer is alive, idle or stalled"},
+		{Field: "WALWriterTimeout", Type: "duration", Default: d.WALWriterTimeout.String(), Flag: "wal-writer-timeout", Env: "WALSHIP_WAL_WRITER_TIMEOUT", File: "wal_writer_timeout",
+			Constraints: "> 0", Description: "heartbeat age after which the WAL writer counts as stalled, and WAL quiet time after which the chain counts as idle"},
+		{Field: "PreSendExec", Type: "string", Flag: "pre-send-exec", Env: "WALSHIP_PRE_SEND_EXEC", File: "pre_send_exec",
+			Description: "shell command run before the first send; sends wait until it exits 0 (retried every 10s)"},
+		{Field: "PostSendExec", Type: "string", Flag: "post-send-exec", Env: "WALSHIP_POST_SEND_EXEC", File: "post_send_exec",
+			Description: "shell command run after each batch upload with WALSHIP_BATCH_SEGMENT/FRAMES/BYTES/ERROR set; failures are logged"},
+		{Field: "Disable", Type: "[]string", Flag: "disable", Env: "WALSHIP_DISABLE", File: "disable",
+			Constraints: strings.Join(Subsystems, "|"),
+			Description: "built-in subsystems to turn off: config shipping, the lag scraper, heartbeat logs, resource gating, the startup banner, key watching; WAL shipping always runs"},
+		{Field: "WatchFiles", Type: "[]watch_file", Flag: "watch-file", Env: "WALSHIP_WATCH_FILES", File: "watch_files",
+			Constraints: "relative to node-home; key files refused",
+			Description: "extra files to ship, as path[:redact_key,...]; env entries are ';'-separated"},
+	}
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestConfigSchema_CoversConfig guards against adding a Config field without
+// describing it in the schema.
+func TestConfigSchema_CoversConfig(t *testing.T) {
+	described := map[string]bool{}
+	for _, opt := range ConfigSchema() {
+		if described[opt.Field] {
+			t.Errorf("field %s described twice", opt.Field)
+		}
+		described[opt.Field] = true
+		if opt.Type == "" || opt.Description == "" {
+			t.Errorf("field %s missing type or description", opt.Field)
+		}
+	}
+
+	var check func(prefix string, typ reflect.Type)
+	check = func(prefix string, typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Type.Kind() == reflect.Func || f.Type == reflect.TypeOf([]PluginHook(nil)) {
+				continue // programmatic hooks are not configurable
+			}
+			name := prefix + f.Name
+			if f.Type.Kind() == reflect.Struct {
+				check(name+".", f.Type) // tables describe each of their fields
+				continue
+			}
+			if !described[name] {
+				t.Errorf("Config.%s is not described by ConfigSchema", name)
+			}
+			delete(described, name)
+		}
+	}
+	check("", reflect.TypeOf(Config{}))
+	for name := range described {
+		t.Errorf("ConfigSchema describes unknown field %s", name)
+	}
+}
+
+func TestConfigSchema_Defaults(t *testing.T) {
+	for _, opt := range ConfigSchema() {
+		if opt.Field == "PollInterval" && opt.Default != "500ms" {
+			t.Errorf("PollInterval default = %q, want 500ms", opt.Default)
+		}
+		if opt.Field == "ShipConfig" && opt.Default != "true" {
+			t.Errorf("ShipConfig default = %q, want true", opt.Default)
+		}
+	}
+}
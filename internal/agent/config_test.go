@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -20,6 +21,12 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.MaxBatchBytes != 4<<20 {
 		t.Errorf("MaxBatchBytes = %v, want 4MB", cfg.MaxBatchBytes)
 	}
+	if !cfg.ShipConfig {
+		t.Error("ShipConfig = false, want true")
+	}
+	if cfg.FrameTypeStats {
+		t.Error("FrameTypeStats = true, want off by default")
+	}
 }
 
 func TestConfig_Validate(t *testing.T) {
@@ -103,6 +110,140 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "compression level out of range",
+			config: Config{
+				NodeHome:         "/tmp/root",
+				WALDir:           "/tmp/wal",
+				PollInterval:     time.Second,
+				SendInterval:     time.Second,
+				CompressionLevel: 10,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown commit mode",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				CommitMode:   "sometimes",
+			},
+			wantErr: true,
+		},
+		{
+			name: "periodic commit needs an interval",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				CommitMode:   CommitModePeriodic,
+			},
+			wantErr: true,
+		},
+		{
+			name: "grpc target without port",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				GRPCTarget:   "ingest.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "kafka brokers without topic",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				KafkaBrokers: []string{"kafka-1:9092"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "kafka and grpc together",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				KafkaBrokers: []string{"kafka-1:9092"},
+				KafkaTopic:   "walship",
+				GRPCTarget:   "ingest.example.com:443",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown preflight policy",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				Preflight:    "fail",
+			},
+			wantErr: true,
+		},
+		{
+			name: "anonymize needs a salt",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				Anonymize:    true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown consensus kind",
+			config: Config{
+				NodeHome:       "/tmp/root",
+				WALDir:         "/tmp/wal",
+				PollInterval:   time.Second,
+				SendInterval:   time.Second,
+				ConsensusKinds: "prevote,commit",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing without endpoint",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				ServiceURL:   "http://localhost:8080",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				Tracing:      TracingConfig{Exporter: TracingExporterOTLPHTTP, SampleRatio: 1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing grpc endpoint not host:port",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				ServiceURL:   "http://localhost:8080",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				Tracing:      TracingConfig{Exporter: TracingExporterOTLPGRPC, Endpoint: "http://collector:4317/", SampleRatio: 1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing sample ratio above 1",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				ServiceURL:   "http://localhost:8080",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+				Tracing:      TracingConfig{Exporter: TracingExporterOTLPGRPC, Endpoint: "collector:4317", SampleRatio: 2},
+			},
+			wantErr: true,
+		},
 		{
 			name: "missing node-home is always error",
 			config: Config{
@@ -115,6 +256,169 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "relative ingest path prefix",
+			config: Config{
+				NodeHome:         "/tmp/root",
+				WALDir:           "/tmp/wal",
+				ServiceURL:       "http://localhost:8080",
+				IngestPathPrefix: "api/ingest",
+				PollInterval:     time.Second,
+				SendInterval:     time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "retention min free percent over 100",
+			config: Config{
+				NodeHome:                "/tmp/root",
+				WALDir:                  "/tmp/wal",
+				ServiceURL:              "http://localhost:8080",
+				RetentionMinFreePercent: 150,
+				PollInterval:            time.Second,
+				SendInterval:            time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown sample type",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				SampleTypes:  "vote,precommit",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "node metrics url not http",
+			config: Config{
+				NodeHome:       "/tmp/root",
+				WALDir:         "/tmp/wal",
+				ServiceURL:     "http://localhost:8080",
+				NodeMetricsURL: "localhost:26660/metrics",
+				PollInterval:   time.Second,
+				SendInterval:   time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "ack latency percentile not exposed",
+			config: Config{
+				NodeHome:             "/tmp/root",
+				WALDir:               "/tmp/wal",
+				ServiceURL:           "http://localhost:8080",
+				AckLatencySLO:        30 * time.Second,
+				AckLatencyPercentile: 90,
+				PollInterval:         time.Second,
+				SendInterval:         time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown subsystem",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				Disable:      []string{"wal"},
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "stream upload not above max batch bytes",
+			config: Config{
+				NodeHome:          "/tmp/root",
+				WALDir:            "/tmp/wal",
+				ServiceURL:        "http://localhost:8080",
+				MaxBatchBytes:     4 << 20,
+				StreamUploadBytes: 1 << 20,
+				PollInterval:      time.Second,
+				SendInterval:      time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "stream upload with zstd frames",
+			config: Config{
+				NodeHome:          "/tmp/root",
+				WALDir:            "/tmp/wal",
+				ServiceURL:        "http://localhost:8080",
+				MaxBatchBytes:     4 << 20,
+				StreamUploadBytes: 64 << 20,
+				FrameEncoding:     FrameEncodingZstd,
+				PollInterval:      time.Second,
+				SendInterval:      time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "object store kms key without kms encryption",
+			config: Config{
+				NodeHome:            "/tmp/root",
+				WALDir:              "/tmp/wal",
+				ServiceURL:          "http://localhost:8080",
+				ObjectStoreBucket:   "raw",
+				ObjectStoreSSE:      "AES256",
+				ObjectStoreKMSKeyID: "key-1",
+				PollInterval:        time.Second,
+				SendInterval:        time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "sqlite state backend",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				StateBackend: StateBackendSQLite,
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: !sqliteStateAvailable,
+		},
+		{
+			name: "admin addr on loopback",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				AdminAddr:    "127.0.0.1:9465",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "admin addr on every interface without a token",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				AdminAddr:    ":9465",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin addr on every interface with a token",
+			config: Config{
+				NodeHome:     "/tmp/root",
+				WALDir:       "/tmp/wal",
+				ServiceURL:   "http://localhost:8080",
+				AdminAddr:    "0.0.0.0:9465",
+				AdminToken:   "secret",
+				PollInterval: time.Second,
+				SendInterval: time.Second,
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -142,7 +446,7 @@ func TestConfig_Validate_Derivations(t *testing.T) {
 	if err := c1.Validate(); err != nil {
 		t.Fatalf("Validate failed: %v", err)
 	}
-	expectedWAL := "/app/data/log.wal/node-node1"
+	expectedWAL := filepath.Join("/app", "data", "log.wal", "node-node1")
 	if c1.WALDir != expectedWAL {
 		t.Errorf("WALDir = %v, want %v", c1.WALDir, expectedWAL)
 	}
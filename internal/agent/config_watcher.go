@@ -3,17 +3,24 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+
+	"github.com/bft-labs/walship/pkg/batch"
 )
 
 const (
@@ -22,58 +29,168 @@ const (
 	ErrCodeReadError        = "READ_ERROR"
 )
 
-// ConfigWatcher monitors app.toml and config.toml changes via fsnotify.
+// genesisExcerptBytes bounds the copy of genesis.json shipped with
+// ShipGenesis; the file can run to hundreds of megabytes, so the service gets
+// its hash and size plus this much of its head.
+const genesisExcerptBytes = 64 << 10
+
+var (
+	configRetryBase = time.Second
+	configRetryMax  = time.Minute
+
+	configWatchRetryBase = 5 * time.Second
+	configWatchRetryMax  = 5 * time.Minute
+	// configWatchPollInterval is how often the files are re-read while
+	// fsnotify is unavailable, e.g. on network shares or platforms where
+	// it does not work.
+	configWatchPollInterval = 30 * time.Second
+)
+
+// ConfigWatcher monitors app.toml and config.toml (and optionally client.toml
+// and genesis.json) changes via fsnotify.
 type ConfigWatcher struct {
 	cfg        *Config
 	httpClient *http.Client
 
 	mu       sync.Mutex
 	debounce *time.Timer
+	pending  chan configSnapshot
+	lastHash string
+	lastSums map[string]string // of the files in the last accepted upload
+
+	// Change history for churn detection, guarded by mu.
+	seenHash string
+	changes  []time.Time
+	churning bool
+
+	noCompression atomic.Bool
 }
 
+// configSnapshot is a fully built upload captured at change time, with the
+// files it carries by name for the local history and the SHA-256 of each
+// file's content.
+type configSnapshot struct {
+	body        []byte
+	contentType string
+	hash        string
+	files       map[string]string
+	sums        map[string]string
+}
+
+// NewConfigWatcher returns a watcher with its own client, built like the
+// agent's and honoring cfg.HTTPTimeout.
 func NewConfigWatcher(cfg *Config) *ConfigWatcher {
-	return &ConfigWatcher{
-		cfg: cfg,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+	return newConfigWatcher(cfg, newHTTPClient(*cfg))
+}
+
+// newConfigWatcher creates a watcher that uploads through the given client so
+// config shipping shares connection pooling and timeouts with the frame sender.
+func newConfigWatcher(cfg *Config, httpClient *http.Client) *ConfigWatcher {
+	w := &ConfigWatcher{
+		cfg:        cfg,
+		httpClient: httpClient,
+		pending:    make(chan configSnapshot, 1),
 	}
+	if cfg.StateDir != "" {
+		if cs, err := loadConfigState(cfg.StateDir); err == nil {
+			w.lastHash, w.lastSums = cs.Hash, cs.Files
+		}
+	}
+	w.seenHash = w.lastHash
+	return w
 }
 
 // Run watches $NODE_HOME/config and sends updates to {ServiceURL}/config.
+// If the filesystem watch cannot be established or breaks (e.g. the inotify
+// limit is reached), it is re-attempted with backoff while the watcher reports
+// itself as degraded and polls the files instead.
 func (w *ConfigWatcher) Run(ctx context.Context) {
 	if w.cfg.NodeHome == "" || w.cfg.ServiceURL == "" {
 		return
 	}
 
+	go w.deliverLoop(ctx)
+
+	back := newBackoff(configWatchRetryBase, configWatchRetryMax)
+	for {
+		err := w.watch(ctx, back.Reset)
+		if ctx.Err() != nil {
+			return
+		}
+		setConfigWatchHealth(w.cfg.StateDir, err)
+		logger.Error().Err(err).Msg("config watcher: watch unavailable, retrying")
+
+		// Changes made while unwatched would otherwise be missed; unchanged
+		// content is dropped by the hash check in deliver.
+		w.enqueue(w.snapshot())
+
+		if w.poll(ctx, back.next()) != nil {
+			return
+		}
+	}
+}
+
+// poll re-reads the watched files every configWatchPollInterval for d, so
+// changes still ship while the watch cannot be set up. It returns the
+// context error if ctx is done first.
+func (w *ConfigWatcher) poll(ctx context.Context, d time.Duration) error {
+	retry := time.NewTimer(d)
+	defer retry.Stop()
+	tick := time.NewTicker(configWatchPollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retry.C:
+			return nil
+		case <-tick.C:
+			w.enqueue(w.snapshot())
+		}
+	}
+}
+
+// watch establishes the fsnotify watch and processes events until ctx is done
+// (returning nil) or the watch fails. ready is called once watching is active.
+func (w *ConfigWatcher) watch(ctx context.Context, ready func()) error {
 	configDir := w.configDir()
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		logger.Error().Err(err).Msg("config watcher: failed to create watcher")
-		return
+		return fmt.Errorf("create watcher: %w", err)
 	}
 	defer watcher.Close()
 
 	if err := watcher.Add(configDir); err != nil {
-		logger.Error().Err(err).Str("dir", configDir).Msg("config watcher: failed to watch")
-		w.sendConfigWithRetry(ctx)
-		return
+		return fmt.Errorf("watch %s: %w", configDir, err)
 	}
 
-	w.sendConfigWithRetry(ctx)
+	// Extra files may live outside the config dir; a missing dir only
+	// disables change detection for that file, not the whole watcher.
+	watched := w.watchedPaths()
+	for dir := range watchedDirs(watched) {
+		if dir == configDir {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			logger.Error().Err(err).Str("dir", dir).Msg("config watcher: failed to watch extra dir")
+		}
+	}
+
+	ready()
+	setConfigWatchHealth(w.cfg.StateDir, nil)
+	w.enqueue(w.snapshot())
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 
 		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return errors.New("watcher event channel closed")
 			}
-			filename := filepath.Base(event.Name)
-			if filename != "app.toml" && filename != "config.toml" {
+			if !watched[filepath.Clean(event.Name)] {
 				continue
 			}
 			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
@@ -83,9 +200,13 @@ func (w *ConfigWatcher) Run(ctx context.Context) {
 
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				return
+				return errors.New("watcher error channel closed")
 			}
 			logger.Error().Err(err).Msg("config watcher: watcher error")
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Events were dropped; resync from disk.
+				w.debounceSend(ctx, 100*time.Millisecond)
+			}
 		}
 	}
 }
@@ -99,47 +220,253 @@ func (w *ConfigWatcher) debounceSend(ctx context.Context, delay time.Duration) {
 	}
 
 	w.debounce = time.AfterFunc(delay, func() {
-		w.sendConfigWithRetry(ctx)
+		w.enqueue(w.snapshot())
 	})
 }
 
-func (w *ConfigWatcher) configDir() string       { return filepath.Join(w.cfg.NodeHome, "config") }
-func (w *ConfigWatcher) appConfigPath() string   { return filepath.Join(w.configDir(), "app.toml") }
-func (w *ConfigWatcher) cometConfigPath() string { return filepath.Join(w.configDir(), "config.toml") }
-func (w *ConfigWatcher) configURL() string       { return w.cfg.ServiceURL + configEndpoint }
+// enqueue schedules a snapshot for delivery. Only the latest pending snapshot
+// is kept; the one currently being retried is always delivered first.
+func (w *ConfigWatcher) enqueue(s configSnapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.pending:
+	default:
+	}
+	w.pending <- s
+}
+
+// deliverLoop sends queued snapshots one at a time until ctx is done.
+func (w *ConfigWatcher) deliverLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-w.pending:
+			w.deliver(ctx, s)
+		}
+	}
+}
+
+func (w *ConfigWatcher) configDir() string        { return filepath.Join(w.cfg.NodeHome, "config") }
+func (w *ConfigWatcher) appConfigPath() string    { return filepath.Join(w.configDir(), "app.toml") }
+func (w *ConfigWatcher) cometConfigPath() string  { return filepath.Join(w.configDir(), "config.toml") }
+func (w *ConfigWatcher) clientConfigPath() string { return filepath.Join(w.configDir(), "client.toml") }
+func (w *ConfigWatcher) genesisPath() string {
+	return filepath.Join(w.configDir(), DefaultGenesisJSONName)
+}
+func (w *ConfigWatcher) configURL() string { return ingestURL(*w.cfg, configEndpoint) }
+
+func (w *ConfigWatcher) extraPath(wf WatchFile) string {
+	return filepath.Join(w.cfg.NodeHome, filepath.Clean(wf.Path))
+}
+
+// watchedPaths returns the set of absolute file paths that trigger an upload.
+func (w *ConfigWatcher) watchedPaths() map[string]bool {
+	paths := map[string]bool{
+		w.appConfigPath():   true,
+		w.cometConfigPath(): true,
+	}
+	if w.cfg.ShipClientConfig {
+		paths[w.clientConfigPath()] = true
+	}
+	if w.cfg.ShipGenesis {
+		paths[w.genesisPath()] = true
+	}
+	for _, wf := range w.cfg.WatchFiles {
+		paths[w.extraPath(wf)] = true
+	}
+	return paths
+}
+
+func watchedDirs(paths map[string]bool) map[string]bool {
+	dirs := map[string]bool{}
+	for p := range paths {
+		dirs[filepath.Dir(p)] = true
+	}
+	return dirs
+}
 
 // buildMultipartPayload builds multipart form-data with config files and captured_at timestamp.
-func (w *ConfigWatcher) buildMultipartPayload() (*bytes.Buffer, string) {
+// The snapshot also holds a hash of the file contents (excluding the timestamp) for deduplication,
+// and the shipped files by name, with read errors and genesis as one-line summaries.
+//
+// Each shipped file's SHA-256 is sent as a "file_sha256" field of "name=hex", so
+// the service can store file contents by hash and skip those it already has.
+func (w *ConfigWatcher) buildMultipartPayload() configSnapshot {
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
+	h := sha256.New()
+	files := map[string]string{}
+	sums := map[string]string{}
+	fileSum := func(name, sum string) {
+		writer.WriteField("file_sha256", name+"="+sum)
+		sums[name] = sum
+	}
 
 	writer.WriteField("captured_at", time.Now().UTC().Format(time.RFC3339Nano))
 
-	appContent, appErr := w.readFile(w.appConfigPath())
+	appContent, appErr := w.readConfigFile(w.appConfigPath())
 	if appErr != nil {
 		writer.WriteField("app_error", w.errorToCode(appErr))
+		fmt.Fprintf(h, "app_error:%s\n", w.errorToCode(appErr))
+		files["app.toml"] = "error: " + w.errorToCode(appErr)
 	} else if part, err := writer.CreateFormFile("app_config", "app.toml"); err == nil {
 		part.Write([]byte(appContent))
+		fmt.Fprintf(h, "app_config:%d\n%s", len(appContent), appContent)
+		files["app.toml"] = appContent
+		fileSum("app.toml", sha256Hex(appContent))
 	}
 
-	cometContent, cometErr := w.readFile(w.cometConfigPath())
+	cometContent, cometErr := w.readConfigFile(w.cometConfigPath())
 	if cometErr != nil {
 		writer.WriteField("comet_error", w.errorToCode(cometErr))
+		fmt.Fprintf(h, "comet_error:%s\n", w.errorToCode(cometErr))
+		files["config.toml"] = "error: " + w.errorToCode(cometErr)
 	} else if part, err := writer.CreateFormFile("comet_config", "config.toml"); err == nil {
 		part.Write([]byte(cometContent))
+		fmt.Fprintf(h, "comet_config:%d\n%s", len(cometContent), cometContent)
+		files["config.toml"] = cometContent
+		fileSum("config.toml", sha256Hex(cometContent))
+	}
+
+	if w.cfg.ShipClientConfig {
+		clientContent, clientErr := w.readConfigFile(w.clientConfigPath())
+		if clientErr != nil {
+			writer.WriteField("client_error", w.errorToCode(clientErr))
+			fmt.Fprintf(h, "client_error:%s\n", w.errorToCode(clientErr))
+			files["client.toml"] = "error: " + w.errorToCode(clientErr)
+		} else if part, err := writer.CreateFormFile("client_config", "client.toml"); err == nil {
+			part.Write([]byte(clientContent))
+			fmt.Fprintf(h, "client_config:%d\n%s", len(clientContent), clientContent)
+			files["client.toml"] = clientContent
+			fileSum("client.toml", sha256Hex(clientContent))
+		}
+	}
+
+	// Genesis is shipped as its hash and size plus the head of the file;
+	// the hash alone tells nodes with drifted genesis files apart.
+	if w.cfg.ShipGenesis {
+		sum, size, head, genesisErr := readGenesisExcerpt(w.genesisPath())
+		if genesisErr != nil {
+			writer.WriteField("genesis_error", w.errorToCode(genesisErr))
+			fmt.Fprintf(h, "genesis_error:%s\n", w.errorToCode(genesisErr))
+			files[DefaultGenesisJSONName] = "error: " + w.errorToCode(genesisErr)
+		} else if part, err := writer.CreateFormFile("genesis", DefaultGenesisJSONName); err == nil {
+			part.Write(head)
+			writer.WriteField("genesis_sha256", sum)
+			writer.WriteField("genesis_size", fmt.Sprint(size))
+			if int64(len(head)) < size {
+				writer.WriteField("genesis_truncated", "true")
+			}
+			fmt.Fprintf(h, "genesis:%s\n", sum)
+			files[DefaultGenesisJSONName] = fmt.Sprintf("sha256 %s, %d bytes", sum, size)
+			fileSum(DefaultGenesisJSONName, sum)
+		}
+	}
+
+	// Operator-selected extra files: one "extra_file:<path>" part per file, keyed
+	// by its node-home relative path, or an "extra_error" field of "path=CODE".
+	for _, wf := range w.cfg.WatchFiles {
+		name := filepath.ToSlash(filepath.Clean(wf.Path))
+		path, err := resolveWatchFile(w.cfg.NodeHome, wf)
+		if errors.Is(err, errWatchFileRefused) {
+			logger.Warn().Err(err).Msg("not shipping watch file")
+			continue
+		}
+		var content string
+		if err == nil {
+			content, err = w.readFile(path)
+		}
+		if err != nil {
+			writer.WriteField("extra_error", name+"="+w.errorToCode(err))
+			fmt.Fprintf(h, "extra_error:%s=%s\n", name, w.errorToCode(err))
+			files[name] = "error: " + w.errorToCode(err)
+			continue
+		}
+		content = redactKeys(content, wf.Redact)
+		if part, err := writer.CreateFormFile("extra_file:"+name, filepath.Base(name)); err == nil {
+			part.Write([]byte(content))
+			fmt.Fprintf(h, "extra_file:%s:%d\n%s", name, len(content), content)
+			files[name] = content
+			fileSum(name, sha256Hex(content))
+		}
+	}
+
+	// Churn markers are left out of the hash so they never cause an upload
+	// on their own.
+	hash := hex.EncodeToString(h.Sum(nil))
+	if n := w.noteChange(hash, time.Now()); n > 0 {
+		writer.WriteField("config_churn", fmt.Sprint(n))
+		writer.WriteField("config_churn_window", w.cfg.ConfigChurnWindow.String())
 	}
 
 	contentType := writer.FormDataContentType()
 	writer.Close()
 
-	return &buf, contentType
+	return configSnapshot{body: buf.Bytes(), contentType: contentType, hash: hash, files: files, sums: sums}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// changedFiles returns the names of the files in sums whose content differs
+// from the last accepted upload, sorted.
+func (w *ConfigWatcher) changedFiles(sums map[string]string) []string {
+	var changed []string
+	for name, sum := range sums {
+		if w.lastSums[name] != sum {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// noteChange records hash as the current file contents. When the contents
+// have changed more than ConfigChurnLimit times within ConfigChurnWindow it
+// returns the number of changes in the window, warning once per burst, and
+// 0 otherwise.
+func (w *ConfigWatcher) noteChange(hash string, now time.Time) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cfg.ConfigChurnLimit <= 0 {
+		return 0
+	}
+	if hash != w.seenHash {
+		w.seenHash = hash
+		w.changes = append(w.changes, now)
+	}
+	cutoff := now.Add(-w.cfg.ConfigChurnWindow)
+	i := 0
+	for i < len(w.changes) && !w.changes[i].After(cutoff) {
+		i++
+	}
+	w.changes = w.changes[i:]
+
+	if len(w.changes) <= w.cfg.ConfigChurnLimit {
+		w.churning = false
+		return 0
+	}
+	if !w.churning {
+		w.churning = true
+		logger.Warn().
+			Int("changes", len(w.changes)).
+			Dur("window", w.cfg.ConfigChurnWindow).
+			Msg("config watcher: configuration is changing unusually often")
+	}
+	return len(w.changes)
 }
 
 func (w *ConfigWatcher) sendConfig(ctx context.Context) {
-	buf, contentType := w.buildMultipartPayload()
+	s := w.buildMultipartPayload()
 
-	if err := w.send(ctx, buf, contentType); err != nil {
-		logger.Error().Err(err).Msg("config watcher: send error")
+	if err := w.send(ctx, s.body, s.contentType); err != nil {
+		logServerError(logger.Error().Err(err), err).Msg("config watcher: send error")
 		return
 	}
 
@@ -149,46 +476,145 @@ func (w *ConfigWatcher) sendConfig(ctx context.Context) {
 // sendConfigWithRetry retries until success or context cancellation.
 // Snapshot is captured once at start to preserve history.
 func (w *ConfigWatcher) sendConfigWithRetry(ctx context.Context) {
-	const retryInterval = 5 * time.Second
-	retryCount := 0
+	w.deliver(ctx, w.snapshot())
+}
 
-	snapshot, contentType := w.buildMultipartPayload()
-	snapshotBytes := snapshot.Bytes()
+func (w *ConfigWatcher) snapshot() configSnapshot {
+	return w.buildMultipartPayload()
+}
 
+// deliver sends s through the sender's retry path until it is accepted or
+// ctx is done. Once a round of SendMaxAttempts fails, uploads stop for a
+// backoff between configRetryBase and configRetryMax, so an unreachable
+// service is not hammered; a snapshot queued meanwhile replaces s, as only
+// the latest contents matter. Snapshots whose content matches the last
+// delivered one are skipped.
+func (w *ConfigWatcher) deliver(ctx context.Context, s configSnapshot) {
+	back := newBackoff(configRetryBase, configRetryMax)
+	total := 0
 	for {
-		reader := bytes.NewReader(snapshotBytes)
-
-		if err := w.send(ctx, reader, contentType); err == nil {
-			if retryCount > 0 {
-				logger.Info().Int("retries", retryCount).Msg("config watcher: sent configuration update after retries")
+		if s.hash != "" && s.hash == w.lastHash {
+			logger.Debug().Msg("config watcher: configuration unchanged, skipping upload")
+			return
+		}
+		attempts, err := retryPost(ctx, *w.cfg, "config", "", func(ctx context.Context) error {
+			return w.send(ctx, s.body, s.contentType)
+		}, nil)
+		total += attempts
+		if err == nil {
+			changed := w.changedFiles(s.sums)
+			w.markDelivered(s)
+			if total > 1 {
+				logger.Info().Strs("changed", changed).Int("attempts", total).Msg("config watcher: sent configuration update after retries")
 			} else {
-				logger.Info().Msg("config watcher: sent configuration update")
+				logger.Info().Strs("changed", changed).Msg("config watcher: sent configuration update")
+			}
+			if w.cfg.OnConfigShipped != nil {
+				w.cfg.OnConfigShipped(ConfigShippedEvent{Changed: changed, Hash: s.hash, Attempts: total, SentAt: time.Now().UTC()})
 			}
 			return
 		}
+		if ctx.Err() != nil {
+			logger.Info().Msg("config watcher: stopping retry due to context cancellation")
+			return
+		}
 
-		// Failure - log and retry
-		retryCount++
-		logger.Error().Int("retry", retryCount).Dur("retry_in", retryInterval).Msg("config watcher: send failed")
+		delay := back.next()
+		logServerError(logger.Error().Err(err), err).Int("attempts", total).Dur("delay", delay).Msg("config watcher: send failed, holding off")
+		if !w.holdOff(ctx, delay, &s) {
+			logger.Info().Msg("config watcher: stopping retry due to context cancellation")
+			return
+		}
+	}
+}
 
+// holdOff waits d, taking a snapshot queued meanwhile into s. It returns
+// false if ctx is done first.
+func (w *ConfigWatcher) holdOff(ctx context.Context, d time.Duration, s *configSnapshot) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for {
 		select {
 		case <-ctx.Done():
-			logger.Info().Msg("config watcher: stopping retry due to context cancellation")
-			return
-		case <-time.After(retryInterval):
-			// Continue to next retry
+			return false
+		case next := <-w.pending:
+			*s = next
+		case <-t.C:
+			return true
+		}
+	}
+}
+
+// markDelivered remembers s as the last accepted upload and persists its hash
+// so restarts do not resend unchanged files, and adds s to the local history.
+func (w *ConfigWatcher) markDelivered(s configSnapshot) {
+	if s.hash == "" {
+		return
+	}
+	w.lastHash, w.lastSums = s.hash, s.sums
+	if w.cfg.StateDir == "" {
+		return
+	}
+	now := time.Now()
+	if err := saveConfigState(w.cfg.StateDir, configState{Hash: s.hash, SentAt: now.UTC(), Files: s.sums}); err != nil {
+		logger.Error().Err(err).Msg("config watcher: save config state")
+	}
+	if w.cfg.ConfigHistory > 0 {
+		if err := recordConfigHistory(w.cfg.StateDir, w.cfg.ConfigHistory, s.hash, s.files, now); err != nil {
+			logger.Error().Err(err).Msg("config watcher: save config history")
 		}
 	}
 }
 
 func (w *ConfigWatcher) readFile(path string) (string, error) {
-	data, err := os.ReadFile(path)
+	data, err := readFileReadOnly(path)
 	if err != nil {
 		return "", err
 	}
 	return string(data), nil
 }
 
+// readConfigFile reads a node config file with the ConfigRedact keys
+// redacted. The upload hash is taken over the redacted content, so changing
+// only a secret does not trigger an upload either.
+func (w *ConfigWatcher) readConfigFile(path string) (string, error) {
+	content, err := w.readFile(path)
+	if err != nil {
+		return "", err
+	}
+	return redactKeys(content, w.cfg.ConfigRedact), nil
+}
+
+// readGenesisExcerpt returns the hex SHA-256 and size of the genesis file at
+// path and at most genesisExcerptBytes of its head, reading it once.
+func readGenesisExcerpt(path string) (sum string, size int64, head []byte, err error) {
+	f, err := openReadOnly(path)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	var buf bytes.Buffer
+	size, err = io.Copy(io.MultiWriter(h, &limitedBuffer{buf: &buf, n: genesisExcerptBytes}), f)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, buf.Bytes(), nil
+}
+
+// limitedBuffer keeps the first n bytes written to it and discards the rest.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	n   int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.n - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
 func (w *ConfigWatcher) errorToCode(err error) string {
 	if os.IsNotExist(err) {
 		return ErrCodeFileNotFound
@@ -202,28 +628,47 @@ func (w *ConfigWatcher) errorToCode(err error) string {
 	return ErrCodeReadError
 }
 
-func (w *ConfigWatcher) send(ctx context.Context, body io.Reader, contentType string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.configURL(), body)
+// send posts body to the config endpoint. Large bodies are gzip-encoded unless
+// the server has rejected that encoding before (415), in which case the upload
+// is repeated uncompressed and compression stays off for this watcher.
+func (w *ConfigWatcher) send(ctx context.Context, body []byte, contentType string) error {
+	encoding := ""
+	payload := body
+	if len(body) >= minCompressSize && !w.noCompression.Load() {
+		if gz, err := batch.Gzip(body, w.cfg.CompressionLevel); err == nil {
+			payload, encoding = gz, "gzip"
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.configURL(), bytes.NewReader(payload))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 	req.Header.Set("X-Cosmos-Analyzer-Chain-Id", w.cfg.ChainID)
 	req.Header.Set("X-Cosmos-Analyzer-Node-Id", w.cfg.NodeID)
-	if w.cfg.AuthKey != "" {
-		req.Header.Set("Authorization", "Bearer "+w.cfg.AuthKey)
+	if key := authKeyFor(*w.cfg, w.cfg.ChainID); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
 	}
 
 	resp, err := w.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("http request: %w", err)
+		return &requestError{fmt.Errorf("http request: %w", err)}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnsupportedMediaType && encoding != "" {
+		logger.Info().Msg("config watcher: server does not accept compressed uploads, disabling")
+		w.noCompression.Store(true)
+		return w.send(ctx, body, contentType)
+	}
+
 	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(respBody))
+		return newStatusError(resp)
 	}
 
 	return nil
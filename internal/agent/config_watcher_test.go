@@ -1,7 +1,11 @@
 package agent
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +13,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -63,6 +68,7 @@ seeds = ""
 		}
 
 		// Parse multipart form
+		gunzipRequest(t, r)
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
 			t.Errorf("Failed to parse multipart form: %v", err)
 		}
@@ -144,6 +150,7 @@ func TestConfigWatcher_MissingFiles(t *testing.T) {
 	var receivedCometError string
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
 			t.Errorf("Failed to parse multipart form: %v", err)
 		}
@@ -464,6 +471,7 @@ func TestConfigWatcher_RetryPreservesSnapshot(t *testing.T) {
 		mu.Unlock()
 
 		// Read the app config content
+		gunzipRequest(t, r)
 		if err := r.ParseMultipartForm(10 << 20); err == nil {
 			if file, _, err := r.FormFile("app_config"); err == nil {
 				data, _ := io.ReadAll(file)
@@ -528,6 +536,103 @@ func TestConfigWatcher_RetryPreservesSnapshot(t *testing.T) {
 	}
 }
 
+// writeConfigFiles writes app.toml and config.toml under home/config.
+func writeConfigFiles(t *testing.T, home, app string) {
+	t.Helper()
+	configDir := filepath.Join(home, "config")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"app.toml": app, "config.toml": "test = true"} {
+		if err := os.WriteFile(filepath.Join(configDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfigWatcher_DeliverRetriesThroughSender(t *testing.T) {
+	home := t.TempDir()
+	writeConfigFiles(t, home, "version = 1")
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if posts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var retries []RetryEvent
+	var shipped []ConfigShippedEvent
+	cfg := &Config{NodeHome: home, ServiceURL: ts.URL, ChainID: "test-chain", NodeID: "test-node",
+		SendMaxAttempts: 3, SendRetryBase: time.Millisecond, SendRetryMax: time.Millisecond,
+		OnRetry:         func(ev RetryEvent) { retries = append(retries, ev) },
+		OnConfigShipped: func(ev ConfigShippedEvent) { shipped = append(shipped, ev) }}
+	watcher := NewConfigWatcher(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	watcher.sendConfigWithRetry(ctx)
+
+	if n := posts.Load(); n != 3 {
+		t.Errorf("posts = %d, want 3", n)
+	}
+	if len(retries) != 2 || retries[1].Attempt != 2 || retries[1].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("retries = %+v, want attempts 1 and 2 reported", retries)
+	}
+	if len(shipped) != 1 || shipped[0].Attempts != 3 {
+		t.Errorf("shipped = %+v, want one snapshot after 3 attempts", shipped)
+	}
+}
+
+func TestConfigWatcher_DeliverTakesNewerSnapshot(t *testing.T) {
+	oldBase, oldMax := configRetryBase, configRetryMax
+	configRetryBase, configRetryMax = 10*time.Millisecond, 10*time.Millisecond
+	defer func() { configRetryBase, configRetryMax = oldBase, oldMax }()
+
+	home := t.TempDir()
+	writeConfigFiles(t, home, "version = 1")
+	cfg := &Config{NodeHome: home, ChainID: "test-chain", NodeID: "test-node"}
+	watcher := NewConfigWatcher(cfg)
+
+	var mu sync.Mutex
+	var accepted []string
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
+		if posts.Add(1) == 1 {
+			// The files change while the first upload fails; the watcher
+			// queues the new snapshot before deliver holds off.
+			writeConfigFiles(t, home, "version = 2")
+			watcher.enqueue(watcher.snapshot())
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		file, _, err := r.FormFile("app_config")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		mu.Lock()
+		accepted = append(accepted, string(data))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	cfg.ServiceURL = ts.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	watcher.sendConfigWithRetry(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(accepted) != 1 || !strings.Contains(accepted[0], "version = 2") {
+		t.Errorf("accepted uploads = %q, want only the newer snapshot", accepted)
+	}
+}
+
 // TestConfigWatcher_NoRetryOnSuccess verifies that successful send doesn't retry.
 func TestConfigWatcher_NoRetryOnSuccess(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -563,6 +668,9 @@ func TestConfigWatcher_NoRetryOnSuccess(t *testing.T) {
 		NodeID:     "test-node",
 	}
 
+	var shipped []ConfigShippedEvent
+	cfg.OnConfigShipped = func(ev ConfigShippedEvent) { shipped = append(shipped, ev) }
+
 	watcher := NewConfigWatcher(cfg)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -578,6 +686,9 @@ func TestConfigWatcher_NoRetryOnSuccess(t *testing.T) {
 	if finalCount != 1 {
 		t.Errorf("attemptCount = %d, want 1", finalCount)
 	}
+	if len(shipped) != 1 || shipped[0].Attempts != 1 || len(shipped[0].Changed) != 2 || shipped[0].Hash == "" {
+		t.Errorf("OnConfigShipped got %+v, want one first upload of both files", shipped)
+	}
 }
 
 // TestConfigWatcher_SendsCapturedAtTimestamp verifies that captured_at timestamp is included.
@@ -600,6 +711,7 @@ func TestConfigWatcher_SendsCapturedAtTimestamp(t *testing.T) {
 	beforeSend := time.Now().UTC()
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
 		if err := r.ParseMultipartForm(10 << 20); err == nil {
 			capturedAt = r.FormValue("captured_at")
 		}
@@ -636,3 +748,530 @@ func TestConfigWatcher_SendsCapturedAtTimestamp(t *testing.T) {
 	}
 }
 
+// TestConfigWatcher_SkipsUnchangedContent verifies that identical config content is
+// uploaded only once, including across restarts via the persisted hash.
+func TestConfigWatcher_SkipsUnchangedContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	appTomlPath := filepath.Join(configDir, "app.toml")
+	if err := os.WriteFile(appTomlPath, []byte(`version = 1`), 0644); err != nil {
+		t.Fatalf("Failed to create app.toml: %v", err)
+	}
+
+	var mu sync.Mutex
+	sendCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sendCount++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		NodeHome:   tmpDir,
+		ServiceURL: ts.URL,
+		StateDir:   filepath.Join(tmpDir, ".walship"),
+	}
+	ctx := context.Background()
+
+	watcher := NewConfigWatcher(cfg)
+	watcher.sendConfigWithRetry(ctx)
+	watcher.sendConfigWithRetry(ctx)
+
+	// A fresh watcher picks up the persisted hash.
+	NewConfigWatcher(cfg).sendConfigWithRetry(ctx)
+
+	mu.Lock()
+	if sendCount != 1 {
+		t.Errorf("sendCount = %d, want 1", sendCount)
+	}
+	mu.Unlock()
+
+	if err := os.WriteFile(appTomlPath, []byte(`version = 2`), 0644); err != nil {
+		t.Fatalf("Failed to modify app.toml: %v", err)
+	}
+	watcher.sendConfigWithRetry(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sendCount != 2 {
+		t.Errorf("sendCount after change = %d, want 2", sendCount)
+	}
+}
+
+// TestConfigWatcher_ShipsExtraFiles verifies that operator-selected files are uploaded
+// with their redaction rules applied, and missing ones are reported by code.
+func TestConfigWatcher_ShipsExtraFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	relayer := "chain = \"osmosis-1\"\nmnemonic = \"abandon abandon about\"\n"
+	if err := os.WriteFile(filepath.Join(configDir, "relayer.toml"), []byte(relayer), 0644); err != nil {
+		t.Fatalf("Failed to create relayer.toml: %v", err)
+	}
+
+	var extraFile string
+	var extraErrors []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("Failed to parse multipart form: %v", err)
+		}
+		if file, _, err := r.FormFile("extra_file:config/relayer.toml"); err == nil {
+			data, _ := io.ReadAll(file)
+			extraFile = string(data)
+			file.Close()
+		}
+		extraErrors = r.MultipartForm.Value["extra_error"]
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		NodeHome:   tmpDir,
+		ServiceURL: ts.URL,
+		WatchFiles: []WatchFile{
+			{Path: "config/relayer.toml", Redact: []string{"mnemonic"}},
+			{Path: "config/missing.toml"},
+		},
+	}
+	NewConfigWatcher(cfg).sendConfig(context.Background())
+
+	if strings.Contains(extraFile, "abandon") {
+		t.Errorf("extra file was not redacted: %q", extraFile)
+	}
+	if !strings.Contains(extraFile, `chain = "osmosis-1"`) {
+		t.Errorf("extra file lost unredacted content: %q", extraFile)
+	}
+	if len(extraErrors) != 1 || extraErrors[0] != "config/missing.toml="+ErrCodeFileNotFound {
+		t.Errorf("extra_error = %v, want [config/missing.toml=%s]", extraErrors, ErrCodeFileNotFound)
+	}
+}
+
+// TestConfigWatcher_RecoversFromWatchFailure verifies that a failed fsnotify setup
+// is reported as degraded and retried until watching succeeds.
+func TestConfigWatcher_RecoversFromWatchFailure(t *testing.T) {
+	oldBase, oldMax := configWatchRetryBase, configWatchRetryMax
+	configWatchRetryBase, configWatchRetryMax = 20*time.Millisecond, 50*time.Millisecond
+	defer func() { configWatchRetryBase, configWatchRetryMax = oldBase, oldMax }()
+
+	tmpDir := t.TempDir() // no config dir yet: watcher.Add fails
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &Config{NodeHome: tmpDir, ServiceURL: ts.URL}
+	watcher := NewConfigWatcher(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	waitFor := func(cond func(Stats) bool, what string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for !cond(CurrentStats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s (stats=%+v)", what, CurrentStats())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(func(s Stats) bool { return s.ConfigWatchDegraded }, "degraded state")
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "config"), 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	waitFor(func(s Stats) bool { return !s.ConfigWatchDegraded && s.ConfigWatchError == "" }, "recovery")
+}
+
+// TestConfigWatcher_CompressesLargeUploads verifies gzip Content-Encoding for large
+// payloads and the uncompressed fallback when the server answers 415.
+func TestConfigWatcher_CompressesLargeUploads(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	appToml := strings.Repeat("# padding line to make the payload large\n", 200)
+	if err := os.WriteFile(filepath.Join(configDir, "app.toml"), []byte(appToml), 0644); err != nil {
+		t.Fatalf("Failed to create app.toml: %v", err)
+	}
+
+	for _, acceptGzip := range []bool{true, false} {
+		var mu sync.Mutex
+		var encodings []string
+		var received string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enc := r.Header.Get("Content-Encoding")
+			mu.Lock()
+			encodings = append(encodings, enc)
+			mu.Unlock()
+			if enc == "gzip" {
+				if !acceptGzip {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					return
+				}
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					t.Errorf("gzip reader: %v", err)
+					return
+				}
+				r.Body = io.NopCloser(zr)
+			}
+			if err := r.ParseMultipartForm(10 << 20); err != nil {
+				t.Errorf("Failed to parse multipart form: %v", err)
+			}
+			if file, _, err := r.FormFile("app_config"); err == nil {
+				data, _ := io.ReadAll(file)
+				mu.Lock()
+				received = string(data)
+				mu.Unlock()
+				file.Close()
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		watcher := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL})
+		watcher.sendConfig(context.Background())
+		watcher.sendConfig(context.Background())
+		ts.Close()
+
+		want := []string{"gzip", "gzip"}
+		if !acceptGzip {
+			want = []string{"gzip", "", ""}
+		}
+		mu.Lock()
+		if strings.Join(encodings, ",") != strings.Join(want, ",") {
+			t.Errorf("acceptGzip=%v: encodings = %q, want %q", acceptGzip, encodings, want)
+		}
+		if received != appToml {
+			t.Errorf("acceptGzip=%v: app_config not received intact", acceptGzip)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestConfigWatcher_NoteChange(t *testing.T) {
+	w := NewConfigWatcher(&Config{ConfigChurnLimit: 2, ConfigChurnWindow: time.Minute})
+	start := time.Now()
+
+	steps := []struct {
+		hash string
+		at   time.Duration
+		want int
+	}{
+		{"a", 0, 0},
+		{"a", time.Second, 0}, // unchanged content is not a change
+		{"b", 2 * time.Second, 0},
+		{"c", 3 * time.Second, 3},
+		{"c", 30 * time.Second, 3},
+		{"c", 62 * time.Second, 0}, // older changes left the window
+		{"d", 63 * time.Second, 0},
+	}
+	for i, s := range steps {
+		if got := w.noteChange(s.hash, start.Add(s.at)); got != s.want {
+			t.Errorf("step %d: noteChange = %d, want %d", i, got, s.want)
+		}
+	}
+}
+
+func TestConfigWatcher_FlagsChurnInUpload(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appTomlPath := filepath.Join(configDir, "app.toml")
+
+	var mu sync.Mutex
+	var churn []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
+		if err := r.ParseMultipartForm(10 << 20); err == nil {
+			mu.Lock()
+			churn = append(churn, r.FormValue("config_churn"))
+			mu.Unlock()
+		}
+	}))
+	defer ts.Close()
+
+	watcher := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL, ConfigChurnLimit: 1, ConfigChurnWindow: time.Hour})
+	for i := 1; i <= 3; i++ {
+		if err := os.WriteFile(appTomlPath, []byte(fmt.Sprintf("version = %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		watcher.sendConfigWithRetry(context.Background())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"", "2", "3"}
+	if strings.Join(churn, ",") != strings.Join(want, ",") {
+		t.Errorf("config_churn fields = %q, want %q", churn, want)
+	}
+}
+
+func TestConfigWatcher_ShipsClientConfigAndGenesis(t *testing.T) {
+	tests := []struct {
+		name          string
+		write         bool
+		wantClient    string
+		wantErrors    map[string]string
+		wantTruncated string
+	}{
+		{name: "present", write: true, wantClient: "chain-id = \"osmosis-1\"\n", wantTruncated: "true"},
+		{name: "missing", wantErrors: map[string]string{"client_error": ErrCodeFileNotFound, "genesis_error": ErrCodeFileNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			configDir := filepath.Join(tmpDir, "config")
+			if err := os.MkdirAll(configDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			genesis := `{"chain_id":"osmosis-1","app_state":"` + strings.Repeat("x", genesisExcerptBytes) + `"}`
+			if tt.write {
+				if err := os.WriteFile(filepath.Join(configDir, "client.toml"), []byte(tt.wantClient), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(configDir, "genesis.json"), []byte(genesis), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var client, genesisHead string
+			var values map[string][]string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					zr, err := gzip.NewReader(r.Body)
+					if err != nil {
+						t.Errorf("gzip body: %v", err)
+						return
+					}
+					r.Body = zr
+				}
+				if err := r.ParseMultipartForm(10 << 20); err != nil {
+					t.Errorf("parse multipart form: %v", err)
+					return
+				}
+				values = r.MultipartForm.Value
+				for field, dst := range map[string]*string{"client_config": &client, "genesis": &genesisHead} {
+					if f, _, err := r.FormFile(field); err == nil {
+						data, _ := io.ReadAll(f)
+						*dst = string(data)
+						f.Close()
+					}
+				}
+			}))
+			defer ts.Close()
+
+			cfg := &Config{NodeHome: tmpDir, ServiceURL: ts.URL, ShipClientConfig: true, ShipGenesis: true}
+			NewConfigWatcher(cfg).sendConfig(context.Background())
+
+			if client != tt.wantClient {
+				t.Errorf("client_config = %q, want %q", client, tt.wantClient)
+			}
+			for field, code := range tt.wantErrors {
+				if got := values[field]; len(got) != 1 || got[0] != code {
+					t.Errorf("%s = %v, want %s", field, got, code)
+				}
+			}
+			if !tt.write {
+				return
+			}
+			wantHash, err := genesisHash(tmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if genesisHead != genesis[:genesisExcerptBytes] {
+				t.Errorf("genesis part is %d bytes, want the first %d", len(genesisHead), genesisExcerptBytes)
+			}
+			if got := values["genesis_sha256"]; len(got) != 1 || got[0] != wantHash {
+				t.Errorf("genesis_sha256 = %v, want %s", got, wantHash)
+			}
+			if got := values["genesis_size"]; len(got) != 1 || got[0] != fmt.Sprint(len(genesis)) {
+				t.Errorf("genesis_size = %v, want %d", got, len(genesis))
+			}
+			if got := values["genesis_truncated"]; len(got) != 1 || got[0] != tt.wantTruncated {
+				t.Errorf("genesis_truncated = %v, want %s", got, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestConfigWatcher_RedactsSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	app := "minimum-gas-prices = \"0uosmo\"\n[rpc]\nrpc_password = \"hunter2\"\n[oracle]\napi_key = \"abc123\"\n"
+	if err := os.WriteFile(filepath.Join(configDir, "app.toml"), []byte(app), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.toml"), []byte("moniker = \"val\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			t.Errorf("parse multipart form: %v", err)
+			return
+		}
+		if f, _, err := r.FormFile("app_config"); err == nil {
+			data, _ := io.ReadAll(f)
+			got = string(data)
+			f.Close()
+		}
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.NodeHome, cfg.ServiceURL = tmpDir, ts.URL
+	NewConfigWatcher(&cfg).sendConfig(context.Background())
+
+	for _, secret := range []string{"hunter2", "abc123"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("app_config leaks %q:\n%s", secret, got)
+		}
+	}
+	if !strings.Contains(got, redactedValue) || !strings.Contains(got, `minimum-gas-prices = "0uosmo"`) {
+		t.Errorf("app_config = %q, want secrets redacted and other keys kept", got)
+	}
+}
+
+// TestConfigWatcher_PollsWithoutWatch verifies that changes still ship while
+// the fsnotify watch is down.
+func TestConfigWatcher_PollsWithoutWatch(t *testing.T) {
+	oldPoll := configWatchPollInterval
+	configWatchPollInterval = 10 * time.Millisecond
+	defer func() { configWatchPollInterval = oldPoll }()
+
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appPath := filepath.Join(configDir, "app.toml")
+	if err := os.WriteFile(appPath, []byte("minimum-gas-prices = \"0stake\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	watcher := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.deliverLoop(ctx)
+	go watcher.poll(ctx, time.Hour)
+
+	waitPosts := func(n int32) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for posts.Load() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d posts, want %d", posts.Load(), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitPosts(1)
+	time.Sleep(50 * time.Millisecond)
+	if got := posts.Load(); got != 1 {
+		t.Fatalf("unchanged files posted %d times", got)
+	}
+	if err := os.WriteFile(appPath, []byte("minimum-gas-prices = \"1stake\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitPosts(2)
+}
+
+// TestConfigWatcher_FileHashes verifies that each file's SHA-256 is sent and
+// persisted, and that rewriting unchanged content uploads nothing.
+func TestConfigWatcher_FileHashes(t *testing.T) {
+	tmpDir := t.TempDir()
+	configDir := filepath.Join(tmpDir, "config")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const app = "minimum-gas-prices = \"0stake\"\n"
+	appPath := filepath.Join(configDir, "app.toml")
+	if err := os.WriteFile(appPath, []byte(app), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var posts int
+	var sums []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gunzipRequest(t, r)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		mu.Lock()
+		posts++
+		sums = r.MultipartForm.Value["file_sha256"]
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	stateDir := t.TempDir()
+	watcher := NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL, StateDir: stateDir})
+	watcher.deliver(context.Background(), watcher.snapshot())
+
+	appSum := sha256.Sum256([]byte(app))
+	want := "app.toml=" + hex.EncodeToString(appSum[:])
+	if posts != 1 || len(sums) != 1 || sums[0] != want {
+		t.Fatalf("posts %d with file_sha256 %v, want one with [%s]", posts, sums, want)
+	}
+	cs, err := loadConfigState(stateDir)
+	if err != nil || cs.Files["app.toml"] != hex.EncodeToString(appSum[:]) {
+		t.Errorf("config state = %+v (%v), want app.toml's hash", cs, err)
+	}
+
+	// Only the mtime changes: nothing to upload, also after a restart.
+	if err := os.WriteFile(appPath, []byte(app), 0644); err != nil {
+		t.Fatal(err)
+	}
+	watcher = NewConfigWatcher(&Config{NodeHome: tmpDir, ServiceURL: ts.URL, StateDir: stateDir})
+	watcher.deliver(context.Background(), watcher.snapshot())
+	if posts != 1 {
+		t.Errorf("unchanged content uploaded again (%d posts)", posts)
+	}
+	if got := watcher.changedFiles(map[string]string{"app.toml": hex.EncodeToString(appSum[:]), "config.toml": "x"}); len(got) != 1 || got[0] != "config.toml" {
+		t.Errorf("changedFiles = %v, want [config.toml]", got)
+	}
+}
+
+// gunzipRequest undoes the Content-Encoding of config uploads large enough
+// to be compressed.
+func gunzipRequest(t *testing.T, r *http.Request) {
+	t.Helper()
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		t.Errorf("gzip body: %v", err)
+		return
+	}
+	r.Body = io.NopCloser(zr)
+	r.Header.Del("Content-Encoding")
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// consensusBatch is the body posted to consensusEndpoint.
+type consensusBatch struct {
+	Segment string            `json:"segment"`
+	Events  []consensus.Event `json:"events"`
+	// Replay marks events re-sent by Replay rather than shipped live.
+	Replay bool `json:"replay,omitempty"`
+}
+
+// parseConsensusKinds parses a comma-separated list of consensus event kinds.
+// An empty list keeps every kind.
+func parseConsensusKinds(s string) (map[consensus.Kind]bool, error) {
+	keep := make(map[consensus.Kind]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		k, err := consensus.ParseKind(name)
+		if err != nil {
+			return nil, err
+		}
+		keep[k] = true
+	}
+	return keep, nil
+}
+
+// voteLatencyBatch is the body posted to voteLatencyEndpoint.
+type voteLatencyBatch struct {
+	Segment   string                  `json:"segment"`
+	Latencies []consensus.VoteLatency `json:"latencies"`
+}
+
+// heightSummaryBatch is the body posted to heightSummaryEndpoint.
+type heightSummaryBatch struct {
+	Segment string                    `json:"segment"`
+	Heights []consensus.HeightSummary `json:"heights"`
+}
+
+// shipDecoded sends what is derived from decoding frames, which the service
+// has already accepted as raw frames: their consensus events with
+// DecodeConsensus, their vote latencies with VoteLatency and the heights
+// they complete with HeightSummaries. Each frame is decompressed once for
+// all of them, and the posts are queued to the pipeline's derivedQueue. It
+// is best effort: the raw frames remain the source of truth, so failures
+// are only logged.
+func shipDecoded(cfg Config, httpClient *http.Client, frames []batchFrame, curIdxBase string) {
+	steps := activePipeline(cfg).activeSteps()
+	decode := cfg.DecodeConsensus || cfg.VoteLatency
+	if !decode && steps == nil {
+		return
+	}
+	keep, _ := parseConsensusKinds(cfg.ConsensusKinds) // checked by Validate
+	decodeKeep := keep
+	switch {
+	case cfg.VoteLatency && !cfg.DecodeConsensus:
+		decodeKeep = map[consensus.Kind]bool{consensus.KindPrevote: true, consensus.KindPrecommit: true}
+	case cfg.VoteLatency && len(keep) > 0:
+		decodeKeep = maps.Clone(keep)
+		decodeKeep[consensus.KindPrevote], decodeKeep[consensus.KindPrecommit] = true, true
+	}
+
+	var decoded []consensus.Event
+	var heights []consensus.HeightSummary
+	var skipped, invalid int
+	for _, fr := range frames {
+		raw, err := wal.Decompress(fr.Compressed)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if decode {
+			res := consensus.DecodeFrame(raw, decodeKeep)
+			decoded = append(decoded, res.Events...)
+			skipped += res.Skipped
+			invalid += res.Invalid
+		}
+		if steps != nil {
+			heights = append(heights, steps.AddFrame(raw)...)
+		}
+	}
+	if invalid > 0 {
+		logger.Debug().Int("invalid", invalid).Int("skipped", skipped).Msg("consensus decode")
+	}
+
+	if len(heights) > 0 {
+		queueDerived(cfg, httpClient, heightSummaryEndpoint, heightSummaryBatch{Segment: curIdxBase, Heights: heights}, "send height summaries", len(heights))
+	}
+	if cfg.VoteLatency {
+		if lat := consensus.VoteLatencies(decoded); len(lat) > 0 {
+			queueDerived(cfg, httpClient, voteLatencyEndpoint, voteLatencyBatch{Segment: curIdxBase, Latencies: lat}, "send vote latencies", len(lat))
+		}
+	}
+	if !cfg.DecodeConsensus {
+		return
+	}
+	events := decoded
+	if len(decodeKeep) > len(keep) {
+		// Votes were decoded for the latencies only.
+		events = nil
+		for _, ev := range decoded {
+			if keep[ev.Kind] {
+				events = append(events, ev)
+			}
+		}
+	}
+	if len(events) == 0 {
+		return
+	}
+	queueDerived(cfg, httpClient, consensusEndpoint, consensusBatch{Segment: curIdxBase, Events: events}, "send consensus events", len(events))
+}
+
+func postConsensusEvents(cfg Config, httpClient *http.Client, batch consensusBatch) error {
+	return postDerived(cfg, httpClient, consensusEndpoint, batch)
+}
+
+// postDerived posts v, data derived from the WAL, to endpoint as JSON.
+func postDerived(cfg Config, httpClient *http.Client, endpoint string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s body: %w", endpoint, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ingestURL(cfg, endpoint), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+)
+
+func TestParseConsensusKinds(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"prevote", 1, false},
+		{"prevote, precommit,", 2, false},
+		{"vote", 0, true},
+	}
+	for _, tt := range tests {
+		keep, err := parseConsensusKinds(tt.in)
+		if (err != nil) != tt.wantErr || len(keep) != tt.want {
+			t.Errorf("parseConsensusKinds(%q) = %v, %v; want %d kinds, wantErr %v", tt.in, keep, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func gzipFrame(t *testing.T, records ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, r := range records {
+		zw.Write([]byte(r + "\n"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRun_ShipsFilteredConsensusEvents(t *testing.T) {
+	vote := func(typ int) string {
+		return `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":` +
+			string(rune('0'+typ)) + `,"height":"5","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`
+	}
+	frame := gzipFrame(t, vote(1), vote(2), vote(1))
+
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), frame, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(frame))},
+	})
+
+	var mu sync.Mutex
+	var got consensusBatch
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != consensusEndpoint {
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer k" {
+			t.Error("missing auth header")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond,
+		AuthKey: "k", DecodeConsensus: true, ConsensusKinds: "precommit"}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got.Segment != "seg-000001.wal.idx" || len(got.Events) != 1 || got.Events[0].Kind != consensus.KindPrecommit {
+		t.Errorf("consensus batch = %+v, want one precommit", got)
+	}
+}
+
+func TestShipDecoded(t *testing.T) {
+	vote := `{"time":"2024-01-01T00:00:00.250Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,` +
+		`"height":"5","round":0,"block_id":{"hash":"AB"},"timestamp":"2024-01-01T00:00:00Z","validator_address":"V"}}},"peer_key":"p"}}}`
+	proposal := `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/ProposalMessage","value":{"proposal":{"type":32,` +
+		`"height":"5","round":0,"pol_round":-1,"block_id":{"hash":"AB"},"timestamp":"2024-01-01T00:00:00Z"}}},"peer_key":""}}}`
+	frames := []batchFrame{{Compressed: gzipFrame(t, proposal, vote)}}
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantEvents []consensus.Kind
+		wantLat    bool
+	}{
+		{"events only", Config{DecodeConsensus: true}, []consensus.Kind{consensus.KindProposal, consensus.KindPrevote}, false},
+		{"latency only", Config{VoteLatency: true}, nil, true},
+		{"latency with other kinds", Config{DecodeConsensus: true, ConsensusKinds: "proposal", VoteLatency: true}, []consensus.Kind{consensus.KindProposal}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events consensusBatch
+			var lat voteLatencyBatch
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case consensusEndpoint:
+					_ = json.NewDecoder(r.Body).Decode(&events)
+				case voteLatencyEndpoint:
+					_ = json.NewDecoder(r.Body).Decode(&lat)
+				}
+			}))
+			defer ts.Close()
+			cfg := tt.cfg
+			cfg.ServiceURL = ts.URL
+			shipDecoded(cfg, ts.Client(), frames, "seg-000001.wal.idx")
+
+			var kinds []consensus.Kind
+			for _, ev := range events.Events {
+				kinds = append(kinds, ev.Kind)
+			}
+			if fmt.Sprint(kinds) != fmt.Sprint(tt.wantEvents) {
+				t.Errorf("events = %v, want %v", kinds, tt.wantEvents)
+			}
+			if !tt.wantLat {
+				if len(lat.Latencies) != 0 {
+					t.Errorf("unexpected latencies %+v", lat)
+				}
+				return
+			}
+			want := consensus.VoteLatency{PeerID: "p", ValidatorAddress: "V", FromHeight: 5, ToHeight: 5, Votes: 1, MinMs: 250, MedianMs: 250, P90Ms: 250, MaxMs: 250}
+			if lat.Segment != "seg-000001.wal.idx" || len(lat.Latencies) != 1 || lat.Latencies[0] != want {
+				t.Errorf("latencies = %+v, want %+v", lat, want)
+			}
+		})
+	}
+}
+
+func TestShipDecoded_SlowEndpointDoesNotBlock(t *testing.T) {
+	proposal := `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/ProposalMessage","value":{"proposal":{"type":32,` +
+		`"height":"5","round":0,"pol_round":-1,"block_id":{"hash":"AB"},"timestamp":"2024-01-01T00:00:00Z"}}},"peer_key":""}}}`
+	frames := []batchFrame{{Compressed: gzipFrame(t, proposal)}}
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	posts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mu.Lock()
+		posts++
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), DecodeConsensus: true}
+	p := &pipeline{stateDir: cfg.StateDir, derived: newDerivedQueue()}
+	registerPipeline(p)
+	defer unregisterPipeline(p)
+
+	start := time.Now()
+	for i := 0; i < derivedQueueLen+10; i++ {
+		shipDecoded(cfg, ts.Client(), frames, "seg-000001.wal.idx")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("shipDecoded blocked for %v on a slow endpoint", d)
+	}
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.derived.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// One post may already have left the queue before it filled up.
+	if posts < derivedQueueLen || posts > derivedQueueLen+1 {
+		t.Errorf("posts = %d, want the %d queued", posts, derivedQueueLen)
+	}
+}
+
+func TestRun_ShipsHeightSummaries(t *testing.T) {
+	roundState := func(sec, height int, step string) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:%02dZ","msg":{"type":"tendermint/event/RoundState","value":{"height":"%d","round":0,"step":"RoundStep%s"}}}`,
+			sec, height, step)
+	}
+	frames := [][]byte{
+		gzipFrame(t, roundState(0, 5, "NewHeight"), roundState(1, 5, "Propose")),
+		gzipFrame(t, roundState(3, 5, "Commit"),
+			`{"time":"2024-01-01T00:00:04Z","msg":{"type":"tendermint/wal/EndHeightMessage","value":{"height":"5"}}}`,
+			roundState(4, 6, "NewHeight")),
+	}
+	walDir := t.TempDir()
+	var seg []byte
+	var metas []FrameMeta
+	for i, f := range frames {
+		metas = append(metas, FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1), Off: uint64(len(seg)), Len: uint64(len(f))})
+		seg = append(seg, f...)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), seg, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), metas)
+
+	var mu sync.Mutex
+	var got []consensus.HeightSummary
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != heightSummaryEndpoint {
+			return
+		}
+		var b heightSummaryBatch
+		_ = json.NewDecoder(r.Body).Decode(&b)
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, b.Heights...)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond, HeightSummaries: true}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]float64{"NewHeight": 1000, "Propose": 2000, "Commit": 1000}
+	if len(got) != 1 || got[0].Height != 5 || got[0].Rounds != 1 || got[0].Partial || fmt.Sprint(got[0].StepsMs) != fmt.Sprint(want) {
+		t.Errorf("summaries = %+v, want height 5 with steps %v", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// counters are cumulative shipping totals since they were last reset.
+type counters struct {
+	Frames uint64    `json:"frames"`
+	Bytes  uint64    `json:"bytes"`
+	Since  time.Time `json:"since"`
+}
+
+// shippedTotals are the frames and bytes ever committed from a state dir.
+// They are saved in the state with the position they advanced, so a crash
+// between the two cannot count a batch twice.
+type shippedTotals struct {
+	Frames uint64    `json:"frames"`
+	Bytes  uint64    `json:"bytes"`
+	Since  time.Time `json:"since"` // first commit
+}
+
+func (t *shippedTotals) add(frames, bytes int) {
+	if t.Since.IsZero() {
+		t.Since = time.Now().UTC()
+	}
+	t.Frames += uint64(frames)
+	t.Bytes += uint64(bytes)
+}
+
+// counterReset marks the shippedTotals at the last ResetCounters. It lives
+// apart from the state so a reset from another process is not overwritten
+// by the tailer's next commit.
+type counterReset struct {
+	Frames uint64    `json:"frames"`
+	Bytes  uint64    `json:"bytes"`
+	At     time.Time `json:"at"`
+}
+
+// countersMu serializes resets within the process.
+var countersMu sync.Mutex
+
+func counterResetFile(dir string) string {
+	return filepath.Join(dir, "counters_reset.json")
+}
+
+// legacyCountersFile holds the totals kept by earlier versions, updated
+// apart from the state. Run moves them into the state.
+func legacyCountersFile(dir string) string {
+	return filepath.Join(dir, "counters.json")
+}
+
+// loadCounters reads the totals in dir. A dir without a state yields zero
+// totals.
+func loadCounters(dir string) (counters, error) {
+	st, err := loadState(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return counters{}, err
+	}
+	return countersFrom(dir, st.Shipped)
+}
+
+// countersFrom returns t less the totals at the last reset in dir.
+func countersFrom(dir string, t shippedTotals) (counters, error) {
+	if t.Since.IsZero() {
+		var c counters
+		err := readJSON(legacyCountersFile(dir), &c)
+		if errors.Is(err, fs.ErrNotExist) {
+			return counters{}, nil
+		}
+		return c, err
+	}
+	var r counterReset
+	if err := readJSON(counterResetFile(dir), &r); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return counters{}, err
+	}
+	c := counters{Frames: t.Frames, Bytes: t.Bytes, Since: t.Since}
+	// A reset from before the totals began, or above them, predates the
+	// state, e.g. one deleted to ship the WAL again, and no longer applies.
+	if !r.At.IsZero() && !r.At.Before(t.Since) && r.Frames <= t.Frames && r.Bytes <= t.Bytes {
+		c = counters{Frames: t.Frames - r.Frames, Bytes: t.Bytes - r.Bytes, Since: r.At}
+	}
+	return c, nil
+}
+
+// migrateCounters moves the totals of counters.json into st, if it has none
+// yet, and removes the file once st is saved.
+func migrateCounters(dir string, st *state) error {
+	if !st.Shipped.Since.IsZero() {
+		return nil
+	}
+	var c counters
+	if err := readJSON(legacyCountersFile(dir), &c); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	st.Shipped = shippedTotals{Frames: c.Frames, Bytes: c.Bytes, Since: c.Since}
+	if st.Shipped.Since.IsZero() {
+		st.Shipped.Since = time.Now().UTC()
+	}
+	if err := saveState(dir, *st); err != nil {
+		return err
+	}
+	return os.Remove(legacyCountersFile(dir))
+}
+
+// noteShipped publishes the totals of dir after t has been saved.
+func noteShipped(dir string, t shippedTotals) {
+	c, err := countersFrom(dir, t)
+	if err != nil {
+		logger.Error().Err(err).Msg("load counters")
+		return
+	}
+	setShippedTotals(dir, c)
+}
+
+// ResetCounters zeroes the cumulative totals in cfg.StateDir. It is safe to
+// call while the agent is running.
+func ResetCounters(cfg Config) error {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	st, err := loadState(cfg.StateDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	r := counterReset{Frames: st.Shipped.Frames, Bytes: st.Shipped.Bytes, At: time.Now().UTC()}
+	if err := writeJSONAtomic(cfg.StateDir, counterResetFile(cfg.StateDir), r); err != nil {
+		return err
+	}
+	if err := os.Remove(legacyCountersFile(cfg.StateDir)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	setShippedTotals(cfg.StateDir, counters{Since: r.At})
+	return nil
+}
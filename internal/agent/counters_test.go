@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// commitShipped adds a batch to the totals the way commitBatch does.
+func commitShipped(t *testing.T, dir string, frames, bytes int) {
+	t.Helper()
+	st, err := loadState(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	st.Shipped.add(frames, bytes)
+	if err := saveState(dir, st); err != nil {
+		t.Fatal(err)
+	}
+	noteShipped(dir, st.Shipped)
+}
+
+func TestCounters_AccumulateAndReset(t *testing.T) {
+	dir := t.TempDir()
+	if c, err := loadCounters(dir); err != nil || c.Frames != 0 || !c.Since.IsZero() {
+		t.Fatalf("fresh counters = %+v, %v", c, err)
+	}
+
+	commitShipped(t, dir, 2, 100)
+	commitShipped(t, dir, 3, 50)
+	c, err := loadCounters(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Frames != 5 || c.Bytes != 150 || c.Since.IsZero() {
+		t.Errorf("counters = %+v, want 5 frames / 150 bytes", c)
+	}
+	if s := CurrentStats(); s.ShippedFrames != 5 || s.ShippedBytes != 150 {
+		t.Errorf("stats = %d frames / %d bytes", s.ShippedFrames, s.ShippedBytes)
+	}
+
+	if err := ResetCounters(Config{StateDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	commitShipped(t, dir, 1, 10)
+	if c, _ := loadCounters(dir); c.Frames != 1 || c.Bytes != 10 {
+		t.Errorf("after reset counters = %+v, want 1 frame / 10 bytes", c)
+	}
+	if st, _ := loadState(dir); st.Shipped.Frames != 6 {
+		t.Errorf("state totals = %+v, want all 6 frames", st.Shipped)
+	}
+}
+
+func TestCounters_StaleResetIgnored(t *testing.T) {
+	dir := t.TempDir()
+	// A reset left behind when the state was wiped: the new totals exceed
+	// it, but began after it.
+	r := counterReset{Frames: 2, Bytes: 20, At: time.Now().UTC().Add(-time.Hour)}
+	if err := writeJSONAtomic(dir, counterResetFile(dir), r); err != nil {
+		t.Fatal(err)
+	}
+	commitShipped(t, dir, 3, 30)
+	if c, _ := loadCounters(dir); c.Frames != 3 || c.Bytes != 30 {
+		t.Errorf("counters = %+v, want 3 frames / 30 bytes", c)
+	}
+}
+
+func TestMigrateCounters(t *testing.T) {
+	dir := t.TempDir()
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := writeJSONAtomic(dir, legacyCountersFile(dir), counters{Frames: 4, Bytes: 40, Since: since}); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := loadCounters(dir); c.Frames != 4 {
+		t.Errorf("counters before migration = %+v, want the legacy totals", c)
+	}
+	var st state
+	if err := migrateCounters(dir, &st); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(legacyCountersFile(dir)) {
+		t.Error("counters.json kept after migration")
+	}
+	if c, _ := loadCounters(dir); c.Frames != 4 || c.Bytes != 40 || !c.Since.Equal(since) {
+		t.Errorf("counters after migration = %+v", c)
+	}
+}
+
+func TestRun_CountersSurviveRestart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	commitShipped(t, stateDir, 10, 1000) // shipped by an earlier run
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	st, err := ReadStatus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ShippedFrames != 12 || st.ShippedBytes != 1008 {
+		t.Errorf("status totals = %d frames / %d bytes, want 12 / 1008", st.ShippedFrames, st.ShippedBytes)
+	}
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+var csWALInterval = 5 * time.Second
+
+// csWALMaxRecords bounds the cs.wal records read per scrape.
+const csWALMaxRecords = 10000
+
+// csWALPosition is the saved read position in the consensus WAL. Index
+// numbers the file as CometBFT's autofile group does: wal.NNN is NNN and the
+// head follows the newest rotated file, so the position survives the head
+// being rotated while the agent is stopped.
+type csWALPosition struct {
+	Index  int   `json:"index"`
+	Offset int64 `json:"offset"`
+}
+
+func csWALPositionFile(dir string) string {
+	return filepath.Join(dir, "cs_wal.json")
+}
+
+// csWALDir returns cfg.CSWALDir, taking a relative path from the node home.
+func csWALDir(cfg Config) string {
+	if cfg.CSWALDir == "" || filepath.IsAbs(cfg.CSWALDir) || cfg.NodeHome == "" {
+		return cfg.CSWALDir
+	}
+	return filepath.Join(cfg.NodeHome, cfg.CSWALDir)
+}
+
+// csWALIndex returns the group index of path among files, as listed by
+// wal.CSWALFiles.
+func csWALIndex(files []string, path string) int {
+	next := 0
+	for _, f := range files {
+		n, ok := strings.CutPrefix(filepath.Base(f), "wal.")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			continue
+		}
+		if f == path {
+			return i
+		}
+		next = i + 1
+	}
+	return next
+}
+
+// csWALScraper ships the proposals, votes and block parts of CometBFT's own
+// consensus WAL (Config.CSWALDir) as consensus events, for nodes without the
+// memlogger WAL. Each scrape reads on from the position saved by the last
+// accepted one.
+type csWALScraper struct {
+	cfg        Config
+	httpClient *http.Client
+	state      *csWALState
+}
+
+// csWALState keeps a batch the service has not accepted yet across scrapes.
+type csWALState struct {
+	pending *csWALBatch
+}
+
+type csWALBatch struct {
+	file   string
+	events []consensus.Event
+	gaps   []GapEvent // files skipped after a corrupt record length
+	pos    csWALPosition
+}
+
+func newCSWALScraper(cfg Config, httpClient *http.Client) csWALScraper {
+	return csWALScraper{cfg: cfg, httpClient: httpClient, state: &csWALState{}}
+}
+
+func (csWALScraper) Name() string            { return "cs-wal" }
+func (csWALScraper) Interval() time.Duration { return csWALInterval }
+
+func (s csWALScraper) Collect(ctx context.Context) (any, error) {
+	if s.state.pending != nil {
+		return s.state.pending, nil
+	}
+	r, err := openCSWAL(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	keep, _ := parseConsensusKinds(s.cfg.ConsensusKinds) // checked by Validate
+	var events []consensus.Event
+	var gaps []GapEvent
+	var skipped, invalid int
+	for i := 0; i < csWALMaxRecords && ctx.Err() == nil; i++ {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var skip *wal.CSWALSkipError
+		if errors.As(err, &skip) {
+			// The rest of the file is lost; reading goes on in the next.
+			g := noteGap(s.cfg, GapEvent{After: filepath.Base(skip.File), Before: filepath.Base(skip.Next), Reason: GapCSWALCorrupt})
+			if s.cfg.OnGapDetected != nil {
+				s.cfg.OnGapDetected(g)
+			}
+			gaps = append(gaps, g)
+			continue
+		}
+		if errors.Is(err, wal.ErrCSWALCorrupt) {
+			// The next scrape continues past the record, if it can be
+			// stepped over.
+			invalid++
+			logger.Warn().Err(err).Msg("cs.wal: corrupt record")
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ev, err := consensus.DecodeWALMessage(rec.Time, rec.Msg)
+		switch {
+		case errors.Is(err, consensus.ErrUnsupported):
+			skipped++
+		case err != nil:
+			invalid++
+		case len(keep) == 0 || keep[ev.Kind]:
+			if s.cfg.Anonymize && ev.PeerID != "" {
+				ev.PeerID = anonymizeID(s.cfg.AnonymizeSalt, ev.PeerID)
+			}
+			events = append(events, ev)
+		}
+	}
+	if invalid > 0 {
+		logger.Debug().Int("invalid", invalid).Int("skipped", skipped).Msg("cs.wal decode")
+	}
+	path, off := r.Position()
+	files, _ := wal.CSWALFiles(filepath.Dir(path))
+	b := &csWALBatch{file: filepath.Base(path), events: events, gaps: gaps, pos: csWALPosition{Index: csWALIndex(files, path), Offset: off}}
+	s.state.pending = b
+	return b, nil
+}
+
+func (s csWALScraper) Ship(ctx context.Context, data any) error {
+	b := data.(*csWALBatch)
+	if len(b.gaps) > 0 && s.cfg.ReportGaps {
+		if err := postGaps(s.cfg, s.httpClient, b.gaps); err != nil {
+			return err
+		}
+		b.gaps = nil
+	}
+	if len(b.events) > 0 {
+		if err := postConsensusEvents(s.cfg, s.httpClient, consensusBatch{Segment: b.file, Events: b.events}); err != nil {
+			return err
+		}
+		logger.Debug().Int("events", len(b.events)).Str("file", b.file).Msg("sent cs.wal consensus events")
+	}
+	s.state.pending = nil
+	return writeJSONAtomic(s.cfg.StateDir, csWALPositionFile(s.cfg.StateDir), b.pos)
+}
+
+// openCSWAL opens the consensus WAL at the saved position, or at its oldest
+// file.
+func openCSWAL(cfg Config) (*wal.CSWALReader, error) {
+	dir := csWALDir(cfg)
+	var pos csWALPosition
+	if err := readJSON(csWALPositionFile(cfg.StateDir), &pos); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read cs.wal position: %w", err)
+		}
+		return wal.OpenCSWAL(dir)
+	}
+	files, err := wal.CSWALFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if csWALIndex(files, f) == pos.Index {
+			return wal.OpenCSWALAt(f, pos.Offset)
+		}
+	}
+	logger.Warn().Int("index", pos.Index).Str("dir", dir).Msg("cs.wal: saved position is gone, starting from the oldest file")
+	return wal.OpenCSWAL(dir)
+}
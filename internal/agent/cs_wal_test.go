@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// csWALVote encodes a cs.wal record holding a prevote at height.
+func csWALVote(height int64) []byte {
+	field := func(num protowire.Number, v []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), v)
+	}
+	varint := func(num protowire.Number, v int64) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), uint64(v))
+	}
+	var vote []byte
+	vote = append(vote, varint(1, 1)...)
+	vote = append(vote, varint(2, height)...)
+	vote = append(vote, field(6, []byte{0xab})...)
+	msg := field(2, field(1, field(6, field(1, vote))))
+	data := append(field(1, varint(1, 1704067200)), field(2, msg)...)
+
+	rec := binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(data)))
+	return append(rec, data...)
+}
+
+func TestCSWALIndex(t *testing.T) {
+	files := []string{"d/wal.003", "d/wal.004", "d/wal"}
+	for path, want := range map[string]int{"d/wal.003": 3, "d/wal.004": 4, "d/wal": 5} {
+		if got := csWALIndex(files, path); got != want {
+			t.Errorf("csWALIndex(%s) = %d, want %d", path, got, want)
+		}
+	}
+	if got := csWALIndex([]string{"d/wal"}, "d/wal"); got != 0 {
+		t.Errorf("csWALIndex of a lone head = %d, want 0", got)
+	}
+}
+
+func TestRun_CSWALOnly(t *testing.T) {
+	csDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(csDir, "wal.000"), append(csWALVote(10), csWALVote(11)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(csDir, "wal"), csWALVote(12), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var heights []int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != consensusEndpoint {
+			return
+		}
+		var batch consensusBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ev := range batch.Events {
+			if ev.Kind != consensus.KindPrevote || ev.Vote.ValidatorAddress != "AB" {
+				t.Errorf("event = %+v", ev)
+			}
+			heights = append(heights, ev.Height())
+		}
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: t.TempDir(), StateDir: t.TempDir(), CSWALDir: csDir, Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(heights) != 3 || heights[0] != 10 || heights[2] != 12 {
+		t.Fatalf("heights = %v, want [10 11 12]", heights)
+	}
+	var pos csWALPosition
+	if err := readJSON(csWALPositionFile(cfg.StateDir), &pos); err != nil {
+		t.Fatal(err)
+	}
+	if pos.Index != 1 || pos.Offset != int64(len(csWALVote(12))) {
+		t.Errorf("position = %+v", pos)
+	}
+}
+
+func TestRun_CSWALSkipsCorruptLength(t *testing.T) {
+	csDir := t.TempDir()
+	bad := csWALVote(11)
+	binary.BigEndian.PutUint32(bad[4:], 1<<30)
+	if err := os.WriteFile(filepath.Join(csDir, "wal.000"), append(csWALVote(10), bad...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(csDir, "wal"), csWALVote(12), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var heights []int64
+	var gaps []GapEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case consensusEndpoint:
+			var batch consensusBatch
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Error(err)
+			}
+			for _, ev := range batch.Events {
+				heights = append(heights, ev.Height())
+			}
+		case gapsEndpoint:
+			var report struct{ Gaps []GapEvent }
+			if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+				t.Error(err)
+			}
+			gaps = append(gaps, report.Gaps...)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: t.TempDir(), StateDir: t.TempDir(), CSWALDir: csDir, ReportGaps: true,
+		Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(heights) != 2 || heights[0] != 10 || heights[1] != 12 {
+		t.Errorf("heights = %v, want [10 12]", heights)
+	}
+	if len(gaps) != 1 || gaps[0].Reason != GapCSWALCorrupt || gaps[0].After != "wal.000" || gaps[0].Before != "wal" {
+		t.Errorf("gaps = %+v, want wal.000 to wal", gaps)
+	}
+}
@@ -0,0 +1,194 @@
+package agent
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dedupCacheFrames bounds how many recently shipped frame hashes are kept.
+var dedupCacheFrames = 8192
+
+// shippedFramesFile keeps the frame cache of a state dir, oldest hash first,
+// so a restarted pipeline still skips what it shipped and a backfill skips
+// what the running agent shipped.
+func shippedFramesFile(dir string) string { return filepath.Join(dir, "shipped_frames") }
+
+type frameHash [sha256.Size]byte
+
+func hashFrame(compressed []byte) frameHash {
+	return sha256.Sum256(compressed)
+}
+
+// frameCache is a bounded LRU set of frame hashes. Each pipeline keeps one of
+// the frames the service has accepted, so a frame that reaches it a second
+// time (e.g. after a WAL relocation or from the spool) is not uploaded
+// again. It is safe for concurrent use; a nil cache remembers nothing.
+type frameCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // front = most recently used
+	items map[frameHash]*list.Element
+	// log, if set, is shippedFramesFile, appended to by Add; logged counts
+	// the hashes in it.
+	log    *os.File
+	path   string
+	logged int
+}
+
+func newFrameCache(max int) *frameCache {
+	return &frameCache{max: max, order: list.New(), items: make(map[frameHash]*list.Element)}
+}
+
+// loadFrameCache returns a cache of the last max hashes persisted in dir,
+// without persisting it further.
+func loadFrameCache(dir string, max int) (*frameCache, error) {
+	c := newFrameCache(max)
+	if dir == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(shippedFramesFile(dir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// A hash cut short by a crash is dropped.
+	for ; len(b) >= sha256.Size; b = b[sha256.Size:] {
+		c.add(frameHash(b[:sha256.Size]))
+	}
+	return c, nil
+}
+
+// openFrameCache is loadFrameCache, after which Add also persists the
+// hashes in dir. Close the cache when done.
+func openFrameCache(dir string, max int) (*frameCache, error) {
+	c, err := loadFrameCache(dir, max)
+	if err != nil || max <= 0 {
+		return c, err
+	}
+	c.path = shippedFramesFile(dir)
+	return c, c.compact()
+}
+
+// compact rewrites the log with the hashes held, oldest first, and keeps
+// appending to it. c.mu is held or c not yet shared.
+func (c *frameCache) compact() error {
+	if c.log != nil {
+		c.log.Close()
+		c.log = nil
+	}
+	b := make([]byte, 0, c.order.Len()*sha256.Size)
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		h := el.Value.(frameHash)
+		b = append(b, h[:]...)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	c.log, c.logged = f, c.order.Len()
+	return nil
+}
+
+// Close stops persisting the cache.
+func (c *frameCache) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.log == nil {
+		return nil
+	}
+	err := c.log.Close()
+	c.log = nil
+	return err
+}
+
+// Contains reports whether h was shipped recently and marks it as used.
+func (c *frameCache) Contains(h frameHash) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[h]
+	if ok {
+		c.order.MoveToFront(el)
+	}
+	return ok
+}
+
+// Add records h, evicting the least recently used hash when full, and
+// persists it if the cache was opened with openFrameCache.
+func (c *frameCache) Add(h frameHash) {
+	if c == nil || c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.add(h) || c.log == nil {
+		return
+	}
+	err := c.compactOrAppend(h)
+	if err != nil {
+		// Deduplication still works in memory; only restarts lose it.
+		logger.Warn().Err(err).Str("file", c.path).Msg("persist shipped frames; keeping them in memory only")
+		if c.log != nil {
+			c.log.Close()
+			c.log = nil
+		}
+	}
+}
+
+// compactOrAppend appends h to the log, rewriting it instead once it holds
+// twice the hashes the cache does.
+func (c *frameCache) compactOrAppend(h frameHash) error {
+	if c.logged >= 2*c.max {
+		return c.compact()
+	}
+	if _, err := c.log.Write(h[:]); err != nil {
+		return err
+	}
+	c.logged++
+	return nil
+}
+
+// add records h as Add does, without persisting it, and reports whether it
+// was new. c.mu is held or c not yet shared.
+func (c *frameCache) add(h frameHash) bool {
+	if c.max <= 0 {
+		return false
+	}
+	if el, ok := c.items[h]; ok {
+		c.order.MoveToFront(el)
+		return false
+	}
+	c.items[h] = c.order.PushFront(h)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(frameHash))
+	}
+	return true
+}
+
+// Len returns the number of hashes held.
+func (c *frameCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
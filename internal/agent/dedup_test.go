@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newFrameCache(2)
+	a, b, d := hashFrame([]byte("a")), hashFrame([]byte("b")), hashFrame([]byte("d"))
+
+	c.Add(a)
+	c.Add(b)
+	if !c.Contains(a) { // a is now most recently used
+		t.Fatal("a missing")
+	}
+	c.Add(d)
+
+	if c.Len() != 2 {
+		t.Errorf("Len = %d, want 2", c.Len())
+	}
+	if !c.Contains(a) || !c.Contains(d) {
+		t.Error("a and d should be kept")
+	}
+	if c.Contains(b) {
+		t.Error("b should have been evicted")
+	}
+}
+
+// seedShippedFrames records frames as shipped from stateDir, as a previous
+// run would have.
+func seedShippedFrames(t *testing.T, stateDir string, frames ...[]byte) {
+	t.Helper()
+	c, err := openFrameCache(stateDir, dedupCacheFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range frames {
+		c.Add(hashFrame(b))
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFrameCache_Persisted(t *testing.T) {
+	dir := t.TempDir()
+	a, b, d, e := hashFrame([]byte("a")), hashFrame([]byte("b")), hashFrame([]byte("d")), hashFrame([]byte("e"))
+	c, err := openFrameCache(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough adds to compact the file at least once.
+	for _, h := range []frameHash{a, b, a, d, b, d, e, b} {
+		c.Add(h)
+	}
+	c.Close()
+
+	loaded, err := loadFrameCache(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || !loaded.Contains(b) || !loaded.Contains(e) {
+		t.Errorf("loaded cache holds %d hashes, want b and e", loaded.Len())
+	}
+	if fi, err := os.Stat(shippedFramesFile(dir)); err != nil || fi.Size() != 3*sha256.Size {
+		t.Errorf("file not compacted: %v, %v", fi, err)
+	}
+
+	// A hash cut short by a crash is ignored.
+	f, err := os.OpenFile(shippedFramesFile(dir), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(a[:10])
+	f.Close()
+	if loaded, err := loadFrameCache(dir, 2); err != nil || loaded.Len() != 2 {
+		t.Errorf("after a torn write: %d hashes, %v", loaded.Len(), err)
+	}
+}
+
+func TestRun_SkipsRecentlyShippedFrames(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lens := writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+	stateDir := t.TempDir()
+	seedShippedFrames(t, stateDir, []byte("AAAA"))
+
+	var mu sync.Mutex
+	var shipped []FrameMeta
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "manifest" {
+				var m []FrameMeta
+				_ = json.NewDecoder(part).Decode(&m)
+				mu.Lock()
+				shipped = append(shipped, m...)
+				mu.Unlock()
+			}
+		}
+	}))
+	defer ts.Close()
+
+	before := CurrentStats().DuplicateFrames
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(shipped) != 1 || shipped[0].Frame != 2 {
+		t.Errorf("shipped = %+v, want only frame 2", shipped)
+	}
+	if got := CurrentStats().DuplicateFrames - before; got != 1 {
+		t.Errorf("DuplicateFrames increased by %d, want 1", got)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(lens[0] + lens[1]); st.IdxOffset != want {
+		t.Errorf("IdxOffset = %d, want %d (past the skipped frame)", st.IdxOffset, want)
+	}
+}
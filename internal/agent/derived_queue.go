@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// derivedQueueLen bounds the posts of derived data waiting to be sent.
+const derivedQueueLen = 64
+
+// derivedPost is one post of data derived from delivered frames.
+type derivedPost struct {
+	cfg      Config
+	client   *http.Client
+	endpoint string
+	body     any
+	what     string // what failed, for the log
+	n        int    // items in body, for the log
+}
+
+// derivedQueue posts derived data from its own goroutine, so the derived
+// data endpoints never slow down or fail WAL shipping. Posts are dropped
+// while the queue is full.
+type derivedQueue struct {
+	posts     chan derivedPost
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newDerivedQueue() *derivedQueue {
+	q := &derivedQueue{posts: make(chan derivedPost, derivedQueueLen), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *derivedQueue) run() {
+	defer close(q.done)
+	for d := range q.posts {
+		d.send()
+	}
+}
+
+func (d derivedPost) send() {
+	if err := postDerived(d.cfg, d.client, d.endpoint, d.body); err != nil {
+		logger.Warn().Err(err).Int("items", d.n).Msg(d.what)
+	}
+}
+
+func (q *derivedQueue) enqueue(d derivedPost) {
+	select {
+	case q.posts <- d:
+	default:
+		metricDerivedDropped.Inc()
+		logger.Warn().Int("items", d.n).Msg(d.what + ": queue full, dropped")
+	}
+}
+
+// drain stops taking posts and waits until the queued ones are sent or ctx
+// is done.
+func (q *derivedQueue) drain(ctx context.Context) error {
+	q.closeOnce.Do(func() { close(q.posts) })
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queueDerived posts derived data through the queue of cfg's pipeline, or
+// right away outside Run.
+func queueDerived(cfg Config, client *http.Client, endpoint string, body any, what string, n int) {
+	d := derivedPost{cfg: cfg, client: client, endpoint: endpoint, body: body, what: what, n: n}
+	if p := activePipeline(cfg); p != nil && p.derived != nil {
+		p.derived.enqueue(d)
+		return
+	}
+	d.send()
+}
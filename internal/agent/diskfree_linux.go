@@ -0,0 +1,21 @@
+//go:build linux
+
+package agent
+
+import "syscall"
+
+// diskFreeSupported reports whether diskFreePercent works on this platform.
+const diskFreeSupported = true
+
+// diskFreePercent returns the percentage of the filesystem holding path that
+// is available to unprivileged users.
+func diskFreePercent(path string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 100, nil
+	}
+	return float64(st.Bavail) / float64(st.Blocks) * 100, nil
+}
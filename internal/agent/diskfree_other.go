@@ -0,0 +1,12 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+// diskFreeSupported reports whether diskFreePercent works on this platform.
+const diskFreeSupported = false
+
+func diskFreePercent(path string) (float64, error) {
+	return 0, errors.New("disk free space is only available on linux")
+}
@@ -0,0 +1,260 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Doctor check results.
+const (
+	DoctorPass = "pass"
+	DoctorWarn = "warn"
+	DoctorFail = "fail"
+	// DoctorSkip marks a check that could not run because an earlier one
+	// failed, or that does not apply to the configuration.
+	DoctorSkip = "skip"
+)
+
+// DoctorCheck is the outcome of one doctor check.
+type DoctorCheck struct {
+	// Node is the home of the node checked, set when checking NodeHomes.
+	Node   string `json:"node,omitempty"`
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DoctorReport lists the doctor checks in the order they ran. OK is false
+// when any check failed; warnings do not count.
+type DoctorReport struct {
+	OK     bool          `json:"ok"`
+	Checks []DoctorCheck `json:"checks"`
+}
+
+// Doctor diagnoses the setup cfg describes without shipping anything: the
+// WAL and state dir permissions, the state file, the proxy, the service's
+// reachability and acceptance of the auth key, the clock, and fsnotify support for the
+// config watcher. With NodeHomes every node is checked.
+func Doctor(ctx context.Context, cfg Config) DoctorReport {
+	r := DoctorReport{OK: true}
+	nodes := []Config{cfg}
+	if len(cfg.NodeHomes) > 0 {
+		var err error
+		if nodes, err = nodeConfigs(cfg); err != nil {
+			return DoctorReport{Checks: []DoctorCheck{{Name: "config", Result: DoctorFail, Detail: err.Error()}}}
+		}
+	}
+	for _, n := range nodes {
+		checks := doctorNode(ctx, n, newHTTPClient(n))
+		for _, c := range checks {
+			if len(cfg.NodeHomes) > 0 {
+				c.Node = n.NodeHome
+			}
+			if c.Result == DoctorFail {
+				r.OK = false
+			}
+			r.Checks = append(r.Checks, c)
+		}
+	}
+	return r
+}
+
+func doctorNode(ctx context.Context, cfg Config, httpClient *http.Client) []DoctorCheck {
+	checks := []DoctorCheck{
+		doctorWALDir(cfg),
+		doctorStateDir(cfg.StateDir),
+		doctorStateFile(cfg),
+		doctorProxy(ctx, cfg),
+	}
+	checks = append(checks, doctorService(ctx, cfg, httpClient)...)
+	return append(checks, doctorFSNotify(cfg))
+}
+
+func checkPass(name, format string, args ...any) DoctorCheck {
+	return DoctorCheck{Name: name, Result: DoctorPass, Detail: fmt.Sprintf(format, args...)}
+}
+
+func checkWarn(name, format string, args ...any) DoctorCheck {
+	return DoctorCheck{Name: name, Result: DoctorWarn, Detail: fmt.Sprintf(format, args...)}
+}
+
+func checkFail(name, format string, args ...any) DoctorCheck {
+	return DoctorCheck{Name: name, Result: DoctorFail, Detail: fmt.Sprintf(format, args...)}
+}
+
+func checkSkip(name, format string, args ...any) DoctorCheck {
+	return DoctorCheck{Name: name, Result: DoctorSkip, Detail: fmt.Sprintf(format, args...)}
+}
+
+// doctorWALDir checks that the WAL dir can be listed and the index the
+// agent would resume from opened.
+func doctorWALDir(cfg Config) DoctorCheck {
+	const name = "wal_dir"
+	fi, err := os.Stat(cfg.WALDir)
+	if err != nil {
+		return checkFail(name, "%v", err)
+	}
+	if !fi.IsDir() {
+		return checkFail(name, "%s is not a directory", cfg.WALDir)
+	}
+	if _, err := os.ReadDir(cfg.WALDir); err != nil {
+		return checkFail(name, "cannot list %s (mode %s): %v", cfg.WALDir, fi.Mode().Perm(), err)
+	}
+	if err := checkWALReadable(cfg); err != nil {
+		return checkFail(name, "%v", err)
+	}
+	return checkPass(name, "%s is readable", cfg.WALDir)
+}
+
+// doctorStateDir checks that the state dir, or the nearest existing parent
+// it would be created in, is writable.
+func doctorStateDir(dir string) DoctorCheck {
+	const name = "state_dir"
+	if _, err := os.Stat(dir); err == nil {
+		if err := checkStateWritable(dir); err != nil {
+			return checkFail(name, "%v", err)
+		}
+		return checkPass(name, "%s is writable", dir)
+	}
+	parent := filepath.Dir(filepath.Clean(dir))
+	for {
+		if _, err := os.Stat(parent); err == nil {
+			break
+		}
+		next := filepath.Dir(parent)
+		if next == parent {
+			break
+		}
+		parent = next
+	}
+	if err := checkStateWritable(parent); err != nil {
+		return checkFail(name, "%s does not exist and cannot be created: %v", dir, err)
+	}
+	return checkPass(name, "%s will be created on first run", dir)
+}
+
+// doctorStateFile checks that the saved position can be loaded, points into
+// an index that still exists and belongs to the configured chain.
+func doctorStateFile(cfg Config) DoctorCheck {
+	const name = "state_file"
+	st, err := loadState(cfg.StateDir)
+	if os.IsNotExist(err) {
+		return checkPass(name, "no saved position yet")
+	}
+	if err != nil {
+		return checkFail(name, "unreadable, the agent would start over: %v", err)
+	}
+
+	var hash string
+	if cfg.NodeHome != "" {
+		hash, _ = genesisHash(cfg.NodeHome)
+	}
+	if reason := chainMismatch(st, cfg.ChainID, hash); reason != "" {
+		if cfg.ChainMismatch == ChainMismatchReset {
+			return checkWarn(name, "belongs to another chain (%s); the agent will start over", reason)
+		}
+		return checkFail(name, "belongs to another chain (%s)", reason)
+	}
+	if st.IdxPath == "" {
+		return checkPass(name, "no position saved yet")
+	}
+	fi, err := os.Stat(st.IdxPath)
+	if err != nil {
+		return checkWarn(name, "saved index is gone, the agent will skip ahead: %v", err)
+	}
+	if st.IdxOffset > fi.Size() {
+		return checkFail(name, "offset %d is past the end of %s (%d bytes)", st.IdxOffset, st.IdxPath, fi.Size())
+	}
+	return checkPass(name, "at %s offset %d", st.IdxPath, st.IdxOffset)
+}
+
+// doctorProxy checks that the proxy requests to the service go through, if
+// any, accepts connections.
+func doctorProxy(ctx context.Context, cfg Config) DoctorCheck {
+	const name = "proxy"
+	proxy, err := serviceProxy(cfg)
+	switch {
+	case err != nil:
+		return checkFail(name, "%v", err)
+	case proxy == nil && cfg.ProxyURL != "":
+		return checkSkip(name, "NO_PROXY exempts %s", cfg.ServiceURL)
+	case proxy == nil:
+		return checkSkip(name, "no proxy configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if err := checkProxy(ctx, proxy); err != nil {
+		return checkFail(name, "%v", err)
+	}
+	return checkPass(name, "%s accepts connections", proxy.Redacted())
+}
+
+// doctorService checks the service's reachability, the auth key and the
+// clock skew against the service's Date header.
+func doctorService(ctx context.Context, cfg Config, httpClient *http.Client) []DoctorCheck {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	var service, auth DoctorCheck
+	var serverTime time.Time
+	if err := checkDNS(ctx, cfg); err != nil {
+		service = checkFail("service", "%v", err)
+		auth = checkSkip("auth", "service unreachable")
+	} else {
+		var err error
+		serverTime, err = pingService(ctx, cfg, httpClient)
+		var se *statusError
+		switch {
+		case errors.Is(err, errAuthRejected):
+			service = checkPass("service", "%s answered", cfg.ServiceURL)
+			auth = checkFail("auth", "the service rejected the auth key")
+		case errors.As(err, &se):
+			service = checkFail("service", "%s: %v", cfg.ServiceURL, se)
+			auth = checkSkip("auth", "service unhealthy")
+		case err != nil:
+			service = checkFail("service", "%v", err)
+			auth = checkSkip("auth", "service unreachable")
+		default:
+			service = checkPass("service", "%s answered", cfg.ServiceURL)
+			auth = checkPass("auth", "the service accepted the auth key")
+		}
+	}
+
+	now := time.Now()
+	var clock DoctorCheck
+	switch err := checkClock(now, serverTime); {
+	case err != nil:
+		clock = checkFail("clock", "%v", err)
+	case serverTime.IsZero():
+		clock = checkWarn("clock", "skew unknown: the service sent no time")
+	default:
+		clock = checkPass("clock", "within %s of the service", now.Sub(serverTime).Abs().Round(time.Second))
+	}
+	return []DoctorCheck{service, auth, clock}
+}
+
+// doctorFSNotify checks that the config watcher can watch the node's
+// config dir.
+func doctorFSNotify(cfg Config) DoctorCheck {
+	const name = "fsnotify"
+	if !cfg.ShipConfig || cfg.Anonymize || cfg.NodeHome == "" {
+		return checkSkip(name, "config watcher off")
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return checkWarn(name, "unavailable, config changes will not be shipped: %v", err)
+	}
+	defer w.Close()
+	dir := filepath.Join(cfg.NodeHome, "config")
+	if err := w.Add(dir); err != nil {
+		return checkWarn(name, "cannot watch %s, config changes will not be shipped: %v", dir, err)
+	}
+	return checkPass(name, "watching %s", dir)
+}
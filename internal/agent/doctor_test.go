@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer okServer.Close()
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer rejecting.Close()
+
+	healthy := func(t *testing.T) Config {
+		home := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(home, "config"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		walDir := t.TempDir()
+		writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{{File: "seg-000001.wal.gz", Frame: 1}})
+		return Config{ServiceURL: okServer.URL, NodeHome: home, WALDir: walDir, StateDir: t.TempDir(), ShipConfig: true}
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, cfg *Config)
+		want   map[string]string // check name -> result
+		wantOK bool
+	}{
+		{
+			name:  "healthy",
+			setup: func(t *testing.T, cfg *Config) {},
+			want: map[string]string{"wal_dir": DoctorPass, "state_dir": DoctorPass, "state_file": DoctorPass,
+				"proxy": DoctorSkip, "service": DoctorPass, "auth": DoctorPass, "clock": DoctorPass, "fsnotify": DoctorPass},
+			wantOK: true,
+		},
+		{
+			name: "through a proxy",
+			setup: func(t *testing.T, cfg *Config) {
+				clearProxyEnv(t)
+				// The proxy answers for a service only it can resolve.
+				cfg.ServiceURL = "http://walship-service.test"
+				cfg.ProxyURL = newTestProxy(t, nil)
+			},
+			want:   map[string]string{"proxy": DoctorPass, "service": DoctorPass, "auth": DoctorPass},
+			wantOK: true,
+		},
+		{
+			name: "proxy unreachable",
+			setup: func(t *testing.T, cfg *Config) {
+				clearProxyEnv(t)
+				ts := httptest.NewServer(http.NotFoundHandler())
+				ts.Close()
+				cfg.ServiceURL = "http://walship-service.test"
+				cfg.ProxyURL = ts.URL
+			},
+			want: map[string]string{"proxy": DoctorFail, "service": DoctorFail, "auth": DoctorSkip},
+		},
+		{
+			name: "state dir created on first run, config watcher off",
+			setup: func(t *testing.T, cfg *Config) {
+				cfg.StateDir = filepath.Join(t.TempDir(), "a", "b")
+				cfg.ShipConfig = false
+			},
+			want:   map[string]string{"state_dir": DoctorPass, "state_file": DoctorPass, "fsnotify": DoctorSkip},
+			wantOK: true,
+		},
+		{
+			name: "auth key rejected",
+			setup: func(t *testing.T, cfg *Config) {
+				cfg.ServiceURL = rejecting.URL
+			},
+			want: map[string]string{"service": DoctorPass, "auth": DoctorFail, "clock": DoctorPass},
+		},
+		{
+			name: "service unreachable",
+			setup: func(t *testing.T, cfg *Config) {
+				ts := httptest.NewServer(http.NotFoundHandler())
+				ts.Close()
+				cfg.ServiceURL = ts.URL
+			},
+			want: map[string]string{"service": DoctorFail, "auth": DoctorSkip, "clock": DoctorWarn},
+		},
+		{
+			name: "missing WAL and corrupt state",
+			setup: func(t *testing.T, cfg *Config) {
+				cfg.WALDir = filepath.Join(t.TempDir(), "missing")
+				if err := os.WriteFile(stateFile(cfg.StateDir), []byte("{"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"wal_dir": DoctorFail, "state_file": DoctorFail},
+		},
+		{
+			name: "state of another chain",
+			setup: func(t *testing.T, cfg *Config) {
+				cfg.ChainID = "new-chain"
+				if err := saveState(cfg.StateDir, state{ChainID: "old-chain"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"state_file": DoctorFail},
+		},
+		{
+			name: "offset past the end of the index",
+			setup: func(t *testing.T, cfg *Config) {
+				idx := filepath.Join(cfg.WALDir, "seg-000001.wal.idx")
+				if err := saveState(cfg.StateDir, state{IdxPath: idx, IdxOffset: 1 << 20}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string]string{"state_file": DoctorFail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := healthy(t)
+			tt.setup(t, &cfg)
+			r := Doctor(context.Background(), cfg)
+			got := map[string]DoctorCheck{}
+			for _, c := range r.Checks {
+				got[c.Name] = c
+			}
+			for name, want := range tt.want {
+				if c := got[name]; c.Result != want {
+					t.Errorf("%s = %s (%s), want %s", name, c.Result, c.Detail, want)
+				}
+			}
+			if r.OK != tt.wantOK {
+				t.Errorf("OK = %v, want %v; checks = %+v", r.OK, tt.wantOK, r.Checks)
+			}
+		})
+	}
+}
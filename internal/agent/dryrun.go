@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// dryRunOut receives the dry-run batch reports.
+var dryRunOut io.Writer = os.Stdout
+
+// dryRunTransport answers every request with 204 No Content instead of
+// sending it, and logs what would have been sent.
+type dryRunTransport struct{}
+
+func (dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var n int64
+	if req.Body != nil {
+		n, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	logger.Info().Str("method", req.Method).Str("url", req.URL.Redacted()).Int64("bytes", n).Msg("dry run: request not sent")
+	return &http.Response{StatusCode: http.StatusNoContent, Status: "204 No Content", Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+// dryRunTarget describes where cfg would send frame batches.
+func dryRunTarget(cfg Config) string {
+	switch {
+	case cfg.GRPCTarget != "":
+		return "grpc://" + cfg.GRPCTarget
+	case len(cfg.KafkaBrokers) > 0:
+		return "kafka://" + strings.Join(cfg.KafkaBrokers, ",") + "/" + cfg.KafkaTopic
+	case cfg.ObjectStoreBucket != "":
+		return "s3://" + cfg.ObjectStoreBucket + "/" + cfg.ObjectStorePrefix
+	}
+	return ingestURL(cfg, walFramesEndpoint)
+}
+
+// reportDryRun stands in for the sender in dry-run mode: it prints the
+// batch it was given and reports every frame as sent.
+func reportDryRun(cfg Config, frames []batchFrame, curIdxBase string) int {
+	uncompressed := 0
+	for _, fr := range frames {
+		if raw, err := wal.Decompress(fr.Compressed); err == nil {
+			uncompressed += len(raw)
+		}
+	}
+	first, last := frames[0].Meta, frames[len(frames)-1].Meta
+	fmt.Fprintf(dryRunOut, "dry run: %s frames %s:%d..%s:%d: %d frames, %d bytes (%d uncompressed) -> %s\n",
+		curIdxBase, first.File, first.Frame, last.File, last.Frame, len(frames), framesBytes(frames), uncompressed, dryRunTarget(cfg))
+	return len(frames)
+}
+
+// dryRunStateDir copies the files of dir and the plugins' state into a new
+// temp dir, so that a dry run starts from the saved position without moving
+// it.
+func dryRunStateDir(dir string) (string, error) {
+	tmp, err := os.MkdirTemp("", "walship-dry-run-")
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir() && rel != "." && rel != "plugins" && !strings.HasPrefix(rel, "plugins"+string(filepath.Separator)):
+			return filepath.SkipDir
+		case d.IsDir():
+			return os.MkdirAll(filepath.Join(tmp, rel), 0o700)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(tmp, rel), b, 0o600)
+	})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
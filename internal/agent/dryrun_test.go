@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun_DryRun(t *testing.T) {
+	var out bytes.Buffer
+	oldOut := dryRunOut
+	dryRunOut = &out
+	defer func() { dryRunOut = oldOut }()
+
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer ts.Close()
+
+	frame := gzipFrame(t, `{"time":"2024-06-01T00:00:00Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{}}}`)
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), frame, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: uint64(len(frame))},
+	})
+
+	stateDir := t.TempDir()
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, Once: true, PollInterval: time.Millisecond,
+		ShipConfig: true, DryRun: true}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if n := requests.Load(); n != 0 {
+		t.Errorf("dry run sent %d requests", n)
+	}
+	got := out.String()
+	if !strings.Contains(got, "seg-000001.wal.gz:1..seg-000001.wal.gz:1: 1 frames") || !strings.Contains(got, ts.URL+walFramesEndpoint) {
+		t.Errorf("report = %q", got)
+	}
+	if _, err := os.Stat(stateFile(stateDir)); !os.IsNotExist(err) {
+		t.Errorf("dry run saved a position: %v", err)
+	}
+}
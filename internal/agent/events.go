@@ -0,0 +1,102 @@
+package agent
+
+import "time"
+
+// SendSuccessEvent describes a batch accepted by the service and committed to
+// state. Offsets refer to byte positions in the segment's index file, so
+// EndOffset is the new resume point. FirstTS/LastTS are record timestamps in
+// unix nanoseconds.
+//
+// MinHeight and MaxHeight are the lowest and highest block heights of the
+// consensus messages in the batch, or zero if it holds none. They are only
+// filled in for Config.OnSendSuccess, as finding them means decompressing
+// the batch.
+type SendSuccessEvent struct {
+	Segment     string
+	File        string
+	StartOffset int64
+	EndOffset   int64
+	FirstFrame  uint64
+	LastFrame   uint64
+	FirstTS     int64
+	LastTS      int64
+	Frames      int
+	Bytes       int
+	SentAt      time.Time
+
+	MinHeight int64
+	MaxHeight int64
+}
+
+func newSendSuccessEvent(segment string, manifest []FrameMeta, startOffset, endOffset int64, bytes int, sentAt time.Time) SendSuccessEvent {
+	first, last := manifest[0], manifest[len(manifest)-1]
+	return SendSuccessEvent{
+		Segment:     segment,
+		File:        last.File,
+		StartOffset: startOffset,
+		EndOffset:   endOffset,
+		FirstFrame:  first.Frame,
+		LastFrame:   last.Frame,
+		FirstTS:     first.FirstTS,
+		LastTS:      last.LastTS,
+		Frames:      len(manifest),
+		Bytes:       bytes,
+		SentAt:      sentAt.UTC(),
+	}
+}
+
+// SendErrorEvent describes a batch upload that failed after all the attempts
+// it was allowed. Retryable reports whether the failure was transient (5xx,
+// 408, 429 or a network error); the agent keeps the frames and tries again
+// later either way. Server is the structured error the service answered
+// with, if any.
+type SendErrorEvent struct {
+	Segment    string
+	Frames     int
+	Bytes      int
+	Attempts   int
+	StatusCode int
+	Retryable  bool
+	Server     *ServerError
+	Err        error
+}
+
+// RetryEvent describes a failed batch upload that is about to be retried
+// after Delay. Attempt is the number of the attempt that failed, and Server
+// the structured error the service answered with, if any.
+type RetryEvent struct {
+	Segment    string
+	Attempt    int
+	Delay      time.Duration
+	StatusCode int
+	Server     *ServerError
+	Err        error
+}
+
+// ConfigShippedEvent describes a snapshot of the node's config files the
+// service accepted. Changed names the files that differ from the previous
+// snapshot, all of them for the first; Hash identifies the snapshot's
+// contents and Attempts counts the uploads it took.
+type ConfigShippedEvent struct {
+	Changed  []string
+	Hash     string
+	Attempts int
+	SentAt   time.Time
+}
+
+// GapEvent describes WAL data found missing while reading: frame numbers
+// skipped between consecutive index lines, or segments deleted from the WAL
+// dir before they were read. After and Before name the index files, relative
+// to the WAL dir, read on either side of the gap. FirstFrame and LastFrame
+// bound the missing frame numbers when known; LastFrame is 0 when the frames
+// after the gap were not indexed yet. Segments counts the segment files
+// known to be missing.
+type GapEvent struct {
+	After      string    `json:"after"`
+	Before     string    `json:"before"`
+	FirstFrame uint64    `json:"first_frame,omitempty"`
+	LastFrame  uint64    `json:"last_frame,omitempty"`
+	Segments   int       `json:"missing_segments,omitempty"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detected_at"`
+}
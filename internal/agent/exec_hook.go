@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// execHookTimeout bounds each run of an operator command.
+	execHookTimeout = 30 * time.Second
+	// execHookRetry is how long a failed pre-send command waits before it is
+	// run again; sends stay paused meanwhile.
+	execHookRetry = 10 * time.Second
+)
+
+// execHook runs Config.PreSendExec once before the first send and
+// Config.PostSendExec after each batch. Commands run through sh -c, or
+// cmd /C on Windows, and block the send loop while they run.
+type execHook struct {
+	pre, post string
+
+	preDone   bool
+	preFailed time.Time
+}
+
+func newExecHook(cfg Config) *execHook {
+	return &execHook{pre: cfg.PreSendExec, post: cfg.PostSendExec}
+}
+
+// BeforeSend holds sends back until the pre-send command has succeeded once.
+func (h *execHook) BeforeSend(info SendInfo) bool {
+	if h.pre == "" || h.preDone {
+		return true
+	}
+	if !h.preFailed.IsZero() && time.Since(h.preFailed) < execHookRetry {
+		return false
+	}
+	if err := runExecHook(h.pre, batchEnv(info, nil)); err != nil {
+		logger.Error().Err(err).Str("cmd", h.pre).Msg("pre-send command failed; sends paused")
+		recordEvent(EventError, "pre-send command: "+err.Error())
+		h.preFailed = time.Now()
+		return false
+	}
+	h.preDone = true
+	return true
+}
+
+// AfterSend runs the post-send command; its failure is logged only.
+func (h *execHook) AfterSend(info SendInfo, err error) {
+	if h.post == "" {
+		return
+	}
+	if err := runExecHook(h.post, batchEnv(info, err)); err != nil {
+		logger.Warn().Err(err).Str("cmd", h.post).Msg("post-send command failed")
+		recordEvent(EventError, "post-send command: "+err.Error())
+	}
+}
+
+// batchEnv describes a batch to an operator command.
+func batchEnv(info SendInfo, err error) []string {
+	env := []string{
+		"WALSHIP_BATCH_SEGMENT=" + info.Segment,
+		"WALSHIP_BATCH_FRAMES=" + strconv.Itoa(info.Frames),
+		"WALSHIP_BATCH_BYTES=" + strconv.Itoa(info.Bytes),
+	}
+	if err != nil {
+		env = append(env, "WALSHIP_BATCH_ERROR="+err.Error())
+	}
+	return env
+}
+
+func runExecHook(command string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), execHookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			if len(msg) > 512 {
+				msg = msg[:512]
+			}
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
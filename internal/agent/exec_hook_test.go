@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecHook(t *testing.T) {
+	oldRetry := execHookRetry
+	execHookRetry = time.Hour
+	defer func() { execHookRetry = oldRetry }()
+
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ready")
+	out := filepath.Join(dir, "post.log")
+	h := newExecHook(Config{
+		PreSendExec:  "test -e " + marker,
+		PostSendExec: `echo "$WALSHIP_BATCH_SEGMENT $WALSHIP_BATCH_FRAMES $WALSHIP_BATCH_BYTES $WALSHIP_BATCH_ERROR" >> ` + out,
+	})
+	info := SendInfo{Segment: "seg-000001.wal.idx", Frames: 3, Bytes: 120}
+
+	if h.BeforeSend(info) {
+		t.Fatal("BeforeSend allowed the send while the pre-send command fails")
+	}
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if h.BeforeSend(info) {
+		t.Fatal("BeforeSend reran the pre-send command before execHookRetry")
+	}
+	h.preFailed = time.Time{}
+	if !h.BeforeSend(info) {
+		t.Fatal("BeforeSend refused the send after the pre-send command succeeded")
+	}
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	if !h.BeforeSend(info) {
+		t.Fatal("pre-send command ran again after succeeding once")
+	}
+
+	h.AfterSend(info, nil)
+	h.AfterSend(SendInfo{Segment: "seg-000001.wal.idx"}, errors.New("server returned 503"))
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "seg-000001.wal.idx 3 120 \nseg-000001.wal.idx 0 0 server returned 503\n"
+	if string(b) != want {
+		t.Errorf("post-send output = %q, want %q", b, want)
+	}
+}
+
+func TestRunExecHook_ReportsOutput(t *testing.T) {
+	err := runExecHook("echo vpn down >&2; exit 3", nil)
+	if err == nil || !strings.Contains(err.Error(), "vpn down") {
+		t.Fatalf("err = %v, want the command's output", err)
+	}
+}
+
+func TestShellCommand_ExitStatus(t *testing.T) {
+	// exit works the same in sh and cmd.exe.
+	if err := shellCommand(context.Background(), "exit 0").Run(); err != nil {
+		t.Fatalf("exit 0: %v", err)
+	}
+	var exitErr *exec.ExitError
+	if err := shellCommand(context.Background(), "exit 3").Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("exit 3: err = %v, want exit status 3", err)
+	}
+}
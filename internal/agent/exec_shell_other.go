@@ -0,0 +1,13 @@
+//go:build !windows
+
+package agent
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command through sh -c.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
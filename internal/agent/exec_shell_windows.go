@@ -0,0 +1,18 @@
+//go:build windows
+
+package agent
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command through cmd /C. The command line is passed as
+// written, since cmd.exe does not follow the argument quoting exec applies;
+// /S keeps the quotes inside command intact.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd.exe /D /S /C "` + command + `"`}
+	return cmd
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// flushResult is a pipeline's answer to a Flush: Frames were handed to the
+// sender (or the spool) and Pending are still batched. Gated is set if the
+// batch was held back by resource gating.
+type flushResult struct {
+	Frames  int
+	Pending int
+	Gated   bool
+}
+
+// Flush makes every running pipeline send its pending batch now, ignoring
+// SendInterval, and retry its spool if the batch is empty. Resource gating
+// still applies until HardInterval has passed since the last send. It
+// returns the number of frames sent, and an error if a pipeline could not
+// deliver its batch, held it back for resource gating, or did not answer
+// before ctx was done.
+func Flush(ctx context.Context) (int, error) {
+	ps := runningPipelines()
+	if len(ps) == 0 {
+		return 0, fmt.Errorf("agent is not running")
+	}
+	var frames, pending, gated int
+	for _, p := range ps {
+		done := make(chan flushResult, 1)
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+		select {
+		case p.flushes <- done:
+		case <-ctx.Done():
+			return frames, fmt.Errorf("flush %s: %w", p.stateDir, ctx.Err())
+		}
+		select {
+		case r := <-done:
+			frames += r.Frames
+			pending += r.Pending
+			if r.Gated {
+				gated += r.Pending
+			}
+		case <-ctx.Done():
+			return frames, fmt.Errorf("flush %s: %w", p.stateDir, ctx.Err())
+		}
+	}
+	if gated > 0 {
+		return frames, fmt.Errorf("%d frames held back by resource gating", gated)
+	}
+	if pending > 0 {
+		return frames, fmt.Errorf("%d frames not delivered", pending)
+	}
+	recordEvent(EventState, fmt.Sprintf("flushed %d frames", frames))
+	return frames, nil
+}
+
+// pendingFlush returns the reply channel of a waiting Flush, if any.
+func (p *pipeline) pendingFlush() (chan<- flushResult, bool) {
+	select {
+	case done := <-p.flushes:
+		return done, true
+	default:
+		return nil, false
+	}
+}
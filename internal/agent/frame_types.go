@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// frameTypes counts the records of a compressed frame by message type, or
+// returns nil if the frame cannot be decompressed.
+func frameTypes(compressed []byte) map[consensus.MessageType]int {
+	raw, err := wal.Decompress(compressed)
+	if err != nil {
+		return nil
+	}
+	return consensus.CountTypes(raw)
+}
+
+// batchTypes sums the per-frame counts of frames; nil if none were counted.
+func batchTypes(frames []batchFrame) map[consensus.MessageType]int {
+	var out map[consensus.MessageType]int
+	for _, fr := range frames {
+		for t, n := range fr.Types {
+			if out == nil {
+				out = map[consensus.MessageType]int{}
+			}
+			out[t] += n
+		}
+	}
+	return out
+}
+
+// addFrameTypes adds the counts of shipped frames to the agent stats.
+func addFrameTypes(frames []batchFrame) {
+	counts := batchTypes(frames)
+	if counts == nil {
+		return
+	}
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	if agentStats.s.FrameTypes == nil {
+		agentStats.s.FrameTypes = map[consensus.MessageType]uint64{}
+	}
+	for t, n := range counts {
+		agentStats.s.FrameTypes[t] += uint64(n)
+	}
+}
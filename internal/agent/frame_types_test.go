@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+)
+
+func TestRun_ReportsFrameTypes(t *testing.T) {
+	agentStats.mu.Lock()
+	agentStats.s.FrameTypes = nil
+	agentStats.mu.Unlock()
+
+	const (
+		vote    = `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{}},"peer_key":""}}}`
+		timeout = `{"time":"2024-01-01T00:00:01Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{}}}`
+	)
+	frame := gzipFrame(t, vote, vote, timeout)
+
+	var mu sync.Mutex
+	var uploaded []map[consensus.MessageType]int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			return
+		}
+		var m map[consensus.MessageType]int
+		if err := json.Unmarshal([]byte(r.FormValue("frame_types")), &m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		uploaded = append(uploaded, m)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), frame, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(frame))},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond, FrameTypeStats: true}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(uploaded) != 1 || uploaded[0][consensus.MessageVote] != 2 || uploaded[0][consensus.MessageTimeout] != 1 {
+		t.Fatalf("uploaded frame_types = %v, want 2 votes and 1 timeout", uploaded)
+	}
+	st := CurrentStats()
+	if st.FrameTypes[consensus.MessageVote] != 2 || st.FrameTypes[consensus.MessageTimeout] != 1 {
+		t.Errorf("Stats.FrameTypes = %v", st.FrameTypes)
+	}
+}
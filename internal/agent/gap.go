@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const gapsEndpoint = "/v1/ingest/gaps"
+
+// Causes of a WAL gap.
+const (
+	// GapFramesMissing is a run of frame numbers missing between two
+	// consecutive index lines.
+	GapFramesMissing = "frames_missing"
+	// GapSegmentsMissing is one or more segments deleted from the WAL dir
+	// before they were read.
+	GapSegmentsMissing = "segments_missing"
+	// GapCorruptIndex is a run of frame numbers missing after an index line
+	// that did not parse.
+	GapCorruptIndex = "corrupt_index"
+	// GapCSWALCorrupt is the rest of a consensus WAL file, skipped after a
+	// record whose length is corrupt.
+	GapCSWALCorrupt = "cs_wal_corrupt"
+)
+
+// maxGaps bounds the gaps kept in the state file; the oldest are dropped
+// beyond it.
+const maxGaps = 100
+
+// gapRecord is a detected gap as kept in the state file.
+type gapRecord struct {
+	GapEvent
+	// Reported is set once the gap was accepted by the service.
+	Reported bool `json:"reported,omitempty"`
+}
+
+// gapDetector follows the frame numbers read from the WAL and reports those
+// that never appear.
+type gapDetector struct {
+	last    uint64 // last frame number read; 0 before the first
+	lastIdx string // index the last frame was read from
+	corrupt bool   // an index line that did not parse was skipped since
+}
+
+// frame checks fm, read from idxPath, against the previous frame and returns
+// the gap between them, if any. A lower frame number is a restarted node,
+// not a gap.
+func (d *gapDetector) frame(idxPath string, fm FrameMeta) (GapEvent, bool) {
+	prev, prevIdx, corrupt := d.last, d.lastIdx, d.corrupt
+	d.last, d.lastIdx, d.corrupt = fm.Frame, idxPath, false
+	if prev == 0 || fm.Frame <= prev+1 {
+		return GapEvent{}, false
+	}
+	reason := GapFramesMissing
+	if corrupt {
+		reason = GapCorruptIndex
+	}
+	return GapEvent{After: prevIdx, Before: idxPath, FirstFrame: prev + 1, LastFrame: fm.Frame - 1, Reason: reason}, true
+}
+
+// skipCorrupt notes an index line that did not parse.
+func (d *gapDetector) skipCorrupt() {
+	d.corrupt = true
+}
+
+// skipSegments returns the gap left by moving from cur to next over missing
+// segments. The frame range is bounded by the first frame of next when it is
+// indexed already; that frame is then not reported again.
+func (d *gapDetector) skipSegments(cur, next string, missing int) GapEvent {
+	g := GapEvent{After: cur, Before: next, Segments: missing, Reason: GapSegmentsMissing}
+	fm, ok := firstFrame(next)
+	switch {
+	case d.last == 0:
+	case !ok:
+		g.FirstFrame = d.last + 1
+		d.last = 0
+	case fm.Frame > d.last+1:
+		g.FirstFrame, g.LastFrame = d.last+1, fm.Frame-1
+		d.last = fm.Frame - 1
+	}
+	d.lastIdx = next
+	return g
+}
+
+// firstFrame reads the first frame of the index at path.
+func firstFrame(path string) (FrameMeta, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FrameMeta{}, false
+	}
+	defer f.Close()
+	fm, _, err := nextFrame(bufio.NewReader(f))
+	return fm, err == nil
+}
+
+// segmentLabel names an index file relative to the WAL dir, so that the
+// day directory is kept.
+func segmentLabel(walDir, path string) string {
+	if rel, err := filepath.Rel(walDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return filepath.Base(path)
+}
+
+func (g GapEvent) describe() string {
+	var what string
+	switch {
+	case g.FirstFrame > 0 && g.LastFrame == g.FirstFrame:
+		what = fmt.Sprintf("frame %d", g.FirstFrame)
+	case g.FirstFrame > 0 && g.LastFrame > 0:
+		what = fmt.Sprintf("frames %d-%d", g.FirstFrame, g.LastFrame)
+	case g.FirstFrame > 0:
+		what = fmt.Sprintf("frames from %d", g.FirstFrame)
+	default:
+		what = "data"
+	}
+	if g.Segments > 0 {
+		what += fmt.Sprintf(" (%d segments)", g.Segments)
+	}
+	return fmt.Sprintf("%s missing between %s and %s (%s)", what, g.After, g.Before, g.Reason)
+}
+
+// recordGap reports g, records it in st and saves st.
+func recordGap(cfg Config, st *state, g GapEvent) {
+	g = noteGap(cfg, g)
+	st.Gaps = append(st.Gaps, gapRecord{GapEvent: g})
+	if n := len(st.Gaps) - maxGaps; n > 0 {
+		st.Gaps = st.Gaps[n:]
+	}
+	_ = saveState(cfg.StateDir, *st)
+	if cfg.OnGapDetected != nil {
+		cfg.OnGapDetected(g)
+	}
+}
+
+// noteGap labels g's files, stamps it and logs it, returning the result.
+func noteGap(cfg Config, g GapEvent) GapEvent {
+	g.After, g.Before = segmentLabel(cfg.WALDir, g.After), segmentLabel(cfg.WALDir, g.Before)
+	g.DetectedAt = time.Now().UTC()
+	metricWALGaps.Inc()
+	logger.Warn().Str("after", g.After).Str("before", g.Before).Uint64("first_frame", g.FirstFrame).
+		Uint64("last_frame", g.LastFrame).Int("missing_segments", g.Segments).Str("reason", g.Reason).Msg("wal gap detected")
+	recordEvent(EventError, "wal gap: "+g.describe())
+	return g
+}
+
+// flushGaps uploads the gaps in st not reported yet when cfg.ReportGaps is
+// set, at most once per backoff step while the service rejects them.
+func (p *pipeline) flushGaps(cfg Config, httpClient *http.Client, st *state) {
+	if !cfg.ReportGaps || time.Now().Before(p.gapsAt) {
+		return
+	}
+	var gaps []GapEvent
+	for _, g := range st.Gaps {
+		if !g.Reported {
+			gaps = append(gaps, g.GapEvent)
+		}
+	}
+	if len(gaps) == 0 {
+		return
+	}
+	if err := postGaps(cfg, httpClient, gaps); err != nil {
+		logServerError(logger.Warn().Err(err), err).Int("gaps", len(gaps)).Msg("upload gap report")
+		p.gapsAt = time.Now().Add(p.gapsBack.next())
+		return
+	}
+	p.gapsBack.Reset()
+	logger.Info().Int("gaps", len(gaps)).Msg("sent gap report")
+	for i := range st.Gaps {
+		st.Gaps[i].Reported = true
+	}
+	_ = saveState(cfg.StateDir, *st)
+}
+
+func postGaps(cfg Config, httpClient *http.Client, gaps []GapEvent) error {
+	body, err := json.Marshal(struct {
+		Gaps []GapEvent `json:"gaps"`
+	}{gaps})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(activePipeline(cfg).sendContext(), http.MethodPost, ingestURL(cfg, gapsEndpoint), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGapDetector_Frames(t *testing.T) {
+	var d gapDetector
+	frames := []struct {
+		frame   uint64
+		corrupt bool
+		want    string
+	}{
+		{frame: 1},
+		{frame: 2},
+		{frame: 5, want: "frames 3-4 missing between a and a (frames_missing)"},
+		{frame: 7, corrupt: true, want: "frame 6 missing between a and a (corrupt_index)"},
+		// A restarted node counts from 1 again.
+		{frame: 1},
+		{frame: 2},
+	}
+	for _, f := range frames {
+		if f.corrupt {
+			d.skipCorrupt()
+		}
+		g, ok := d.frame("a", FrameMeta{Frame: f.frame})
+		if got := ""; ok {
+			got = g.describe()
+			if got != f.want {
+				t.Errorf("frame %d: gap %q, want %q", f.frame, got, f.want)
+			}
+		} else if f.want != "" {
+			t.Errorf("frame %d: no gap, want %q", f.frame, f.want)
+		}
+	}
+}
+
+func TestRun_DetectsGaps(t *testing.T) {
+	// Frame 3 is missing from seg-000001 and seg-000002, with frames 5-6,
+	// was deleted.
+	walDir := t.TempDir()
+	for seg, frames := range map[string][]uint64{"seg-000001": {1, 2, 4}, "seg-000003": {7}} {
+		if err := os.WriteFile(filepath.Join(walDir, seg+".wal.gz"), []byte("AAAABBBBCCCC"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var fms []FrameMeta
+		for i, f := range frames {
+			fms = append(fms, FrameMeta{File: seg + ".wal.gz", Frame: f, Off: uint64(4 * i), Len: 4})
+		}
+		writeIdx(t, filepath.Join(walDir, seg+".wal.idx"), fms)
+	}
+
+	var mu sync.Mutex
+	var reported, detected []GapEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != gapsEndpoint {
+			return
+		}
+		var body struct{ Gaps []GapEvent }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		reported = append(reported, body.Gaps...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	home := t.TempDir()
+	writeNodeHome(t, home, "chain-a")
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.NodeHome, cfg.WALDir, cfg.StateDir = ts.URL, home, walDir, t.TempDir()
+	cfg.PollInterval, cfg.MaxPollInterval, cfg.SendInterval = time.Millisecond, time.Millisecond, time.Millisecond
+	cfg.ReportGaps = true
+	cfg.OnGapDetected = func(g GapEvent) {
+		mu.Lock()
+		detected = append(detected, g)
+		mu.Unlock()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(reported)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []GapEvent{
+		{After: "seg-000001.wal.idx", Before: "seg-000001.wal.idx", FirstFrame: 3, LastFrame: 3, Reason: GapFramesMissing},
+		{After: "seg-000001.wal.idx", Before: "seg-000003.wal.idx", FirstFrame: 5, LastFrame: 6, Segments: 1, Reason: GapSegmentsMissing},
+	}
+	if len(detected) != len(want) || len(reported) != len(want) {
+		t.Fatalf("detected %+v, reported %+v", detected, reported)
+	}
+	for i, w := range want {
+		for _, got := range []GapEvent{detected[i], reported[i]} {
+			got.DetectedAt = time.Time{}
+			if got != w {
+				t.Errorf("gap %d = %+v, want %+v", i, got, w)
+			}
+		}
+	}
+	st, _ := loadState(cfg.StateDir)
+	if len(st.Gaps) != 2 || !st.Gaps[0].Reported || !st.Gaps[1].Reported {
+		t.Errorf("state gaps = %+v", st.Gaps)
+	}
+}
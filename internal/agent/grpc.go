@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func newGRPCSender(cfg Config) (*sender.GRPCSender, error) {
+	return sender.NewGRPCSender(cfg.GRPCTarget, sender.GRPCOptions{
+		Insecure: cfg.GRPCInsecure,
+		TLS:      serviceTLSConfig(cfg),
+		Metadata: agentHeaders(cfg),
+	})
+}
+
+// sendGRPC streams frames and returns how many leading frames the service
+// acknowledged within HTTPTimeout.
+func sendGRPC(cfg Config, gs *sender.GRPCSender, frames []batchFrame, curIdxBase string) (int, error) {
+	ctx, cancel := context.WithTimeout(activePipeline(cfg).sendContext(), cfg.HTTPTimeout)
+	defer cancel()
+	wf := make([]wal.Frame, len(frames))
+	for i, fr := range frames {
+		wf[i] = wal.Frame{Meta: fr.Meta, Compressed: fr.Compressed}
+	}
+	return gs.Send(ctx, curIdxBase, wf)
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"sort"
+)
+
+// Plugin kinds of a PluginHealth.
+const (
+	PluginKindHook    = "hook"
+	PluginKindScraper = "scraper"
+)
+
+// HealthReporter is implemented by plugin hooks and scrapers that can tell
+// whether they still do their job, e.g. a hook whose backing service is
+// gone. Health returns nil while they do. It is called on every Health
+// query, so it should return quickly.
+type HealthReporter interface {
+	Health() error
+}
+
+// PluginHealth is the health of one plugin hook or scraper of a node.
+// Scrapers run by the agent's scheduler are unhealthy while their last
+// scrape failed, even if they do not implement HealthReporter.
+type PluginHealth struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	StateDir string `json:"state_dir"`
+	Healthy  bool   `json:"healthy"`
+	Error    string `json:"error,omitempty"`
+}
+
+// HealthReport is the agent's health, as returned by Health.
+type HealthReport struct {
+	// Healthy is false if any plugin is unhealthy.
+	Healthy bool `json:"healthy"`
+	// Ready is Stats.Ready: every WAL pipeline is tailing.
+	Ready   bool           `json:"ready"`
+	Plugins []PluginHealth `json:"plugins"`
+}
+
+// Health reports the health of the running agent's plugin hooks and
+// running scrapers, on every node it ships, so a dead scraper or a broken
+// hook shows instead of failing silently.
+func Health() HealthReport {
+	r := HealthReport{Healthy: true, Ready: CurrentStats().Ready, Plugins: []PluginHealth{}}
+	add := func(name, kind, stateDir string, err error) {
+		ph := PluginHealth{Name: name, Kind: kind, StateDir: stateDir, Healthy: err == nil}
+		if err != nil {
+			ph.Error = err.Error()
+			r.Healthy = false
+		}
+		r.Plugins = append(r.Plugins, ph)
+	}
+	for _, p := range runningPipelines() {
+		for _, h := range p.hooks {
+			if hr, ok := h.(HealthReporter); ok {
+				add(hookName(h), PluginKindHook, p.stateDir, hr.Health())
+			}
+		}
+		if p.scrapers == nil {
+			continue
+		}
+		health := p.scrapers.Health()
+		names := make([]string, 0, len(health))
+		for name := range health {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name, PluginKindScraper, p.stateDir, health[name])
+		}
+	}
+	return r
+}
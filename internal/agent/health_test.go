@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type healthHook struct {
+	gateHook
+	err error
+}
+
+func (h *healthHook) Health() error { return h.err }
+
+func TestHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scrapers := newScraperManager(ctx)
+	defer scrapers.StopAll()
+	failing := &fakeScraper{interval: 5 * time.Millisecond, collectErr: errors.New("endpoint gone")}
+	scrapers.RegisterScraper(failing, true)
+	scrapers.Register("config", func(ctx context.Context) { <-ctx.Done() }, true)
+
+	hook := &healthHook{err: errors.New("backend unreachable")}
+	p := &pipeline{stateDir: t.TempDir(), scrapers: scrapers, hooks: []PluginHook{&gateHook{}, hook}}
+	registerPipeline(p)
+	defer unregisterPipeline(p)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for failing.collects.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the scheduler record the outcome
+
+	h := Health()
+	want := []PluginHealth{
+		{Name: "*agent.healthHook", Kind: PluginKindHook, StateDir: p.stateDir, Error: "backend unreachable"},
+		{Name: "fake", Kind: PluginKindScraper, StateDir: p.stateDir, Error: "endpoint gone"},
+	}
+	if h.Healthy || len(h.Plugins) != len(want) {
+		t.Fatalf("health = %+v, want two unhealthy plugins", h)
+	}
+	for i, ph := range h.Plugins {
+		if ph != want[i] {
+			t.Errorf("plugin %d = %+v, want %+v", i, ph, want[i])
+		}
+	}
+
+	ts := httptest.NewServer(adminHandler(""))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body HealthReport
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || body.Healthy {
+		t.Errorf("unhealthy /healthz: %d %+v (%v)", resp.StatusCode, body, err)
+	}
+
+	// Recovered plugins, and disabled scrapers, do not count.
+	hook.err = nil
+	if err := scrapers.SetEnabled("fake", false); err != nil {
+		t.Fatal(err)
+	}
+	if h := Health(); !h.Healthy || len(h.Plugins) != 1 {
+		t.Errorf("after recovery: %+v", h)
+	}
+	resp, err = http.Get(ts.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthy /healthz: %d", resp.StatusCode)
+	}
+}
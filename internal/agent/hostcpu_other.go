@@ -0,0 +1,9 @@
+//go:build !windows
+
+package agent
+
+// hostCPUTimes returns the total and idle CPU time of the host, from
+// procRoot's stat file.
+func hostCPUTimes() (total, idle uint64, err error) {
+	return readCPUTimes()
+}
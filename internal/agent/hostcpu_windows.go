@@ -0,0 +1,28 @@
+//go:build windows
+
+package agent
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemTimes = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemTimes")
+
+// hostCPUTimes returns the total and idle CPU time of the host, in 100ns
+// units, from GetSystemTimes.
+func hostCPUTimes() (total, idle uint64, err error) {
+	var idleFT, kernelFT, userFT syscall.Filetime
+	r, _, callErr := procGetSystemTimes.Call(
+		uintptr(unsafe.Pointer(&idleFT)),
+		uintptr(unsafe.Pointer(&kernelFT)),
+		uintptr(unsafe.Pointer(&userFT)),
+	)
+	if r == 0 {
+		return 0, 0, fmt.Errorf("GetSystemTimes: %w", callErr)
+	}
+	ft := func(f syscall.Filetime) uint64 { return uint64(f.HighDateTime)<<32 | uint64(f.LowDateTime) }
+	// Kernel time includes idle time.
+	return ft(kernelFT) + ft(userFT), ft(idleFT), nil
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+// newHTTPClient returns the client every HTTP subsystem of one agent shares,
+// so frame uploads, config uploads, preflight checks and remote-write reuse
+// connections, honor HTTPTimeout and check TLSPins. Requests go through
+// ProxyURL or the usual HTTPS_PROXY/NO_PROXY environment variables.
+func newHTTPClient(cfg Config) *http.Client {
+	if cfg.DryRun {
+		return &http.Client{Timeout: cfg.HTTPTimeout, Transport: dryRunTransport{}}
+	}
+	tr := newTransport(cfg)
+	tr.TLSClientConfig = serviceTLSConfig(cfg)
+	// All subsystems mostly talk to the one ingestion host.
+	tr.MaxIdleConnsPerHost = 8
+	return &http.Client{
+		Timeout:   cfg.HTTPTimeout,
+		Transport: countingTransport{tr},
+	}
+}
+
+// httpResults are the result labels of walship_http_requests_total.
+var httpResults = [...]string{"2xx", "3xx", "4xx", "5xx", "error"}
+
+var httpRequests [len(httpResults)]atomic.Uint64
+
+func init() {
+	metrics.Register(metrics.CollectorFunc(collectHTTPRequests))
+}
+
+func collectHTTPRequests() []metrics.Sample {
+	out := make([]metrics.Sample, len(httpResults))
+	for i, r := range httpResults {
+		out[i] = metrics.Sample{Name: "walship_http_requests_total", Help: "HTTP requests to the service by result.",
+			Type: metrics.CounterType, Labels: map[string]string{"result": r}, Value: float64(httpRequests[i].Load())}
+	}
+	return out
+}
+
+// countingTransport counts requests by status class for
+// walship_http_requests_total.
+type countingTransport struct {
+	next http.RoundTripper
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	i := len(httpResults) - 1
+	if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 600 {
+		i = resp.StatusCode/100 - 2
+	}
+	httpRequests[i].Add(1)
+	return resp, err
+}
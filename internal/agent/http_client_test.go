@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client := newHTTPClient(Config{HTTPTimeout: 50 * time.Millisecond})
+	before := make([]uint64, len(httpRequests))
+	for i := range httpRequests {
+		before[i] = httpRequests[i].Load()
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+		result  int // index into httpResults
+	}{
+		{path: "/ok", result: 0},
+		{path: "/missing", result: 2},
+		{path: "/slow", wantErr: true, result: 4},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(ts.URL + tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("GET %s: err = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		if got := httpRequests[tt.result].Load() - before[tt.result]; got < 1 {
+			t.Errorf("GET %s: %s count = %d, want at least 1", tt.path, httpResults[tt.result], got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// batchesEndpoint answers GET <id> with 200 if the service has stored the
+// batch uploaded with that X-Cosmos-Analyzer-Batch-Id and 404 if not.
+const batchesEndpoint = "/v1/ingest/batches"
+
+// batchIDHeader carries batchID on every frame upload, so the service can
+// drop a batch it has already stored.
+const batchIDHeader = "X-Cosmos-Analyzer-Batch-Id"
+
+// batchHashHeader carries batchHash on every frame upload, so the service
+// can tell a stored batch from another with the same frames' numbers.
+const batchHashHeader = "X-Cosmos-Analyzer-Batch-Sha256"
+
+// maxJournal bounds the uploads kept in the journal; beyond it the oldest
+// are forgotten and, if never committed, sent again after a restart.
+const maxJournal = 32
+
+// inFlightBatch is a journaled frame upload: one request of a batch, or of
+// a part of one split after a timeout. It is saved before the request goes
+// out and kept until the position is committed past it, so that after a
+// crash the next start can tell from the service whether it arrived instead
+// of guessing.
+type inFlightBatch struct {
+	ID        string `json:"id"`
+	IdxPath   string `json:"idx_path"`
+	Offset    int64  `json:"offset"`
+	EndOffset int64  `json:"end_offset"`
+	Frames    int    `json:"frames,omitempty"`
+	Hash      string `json:"sha256,omitempty"`
+	LastFile  string `json:"last_file"`
+	LastFrame uint64 `json:"last_frame"`
+	// Acked is set once the service accepted the upload.
+	Acked bool `json:"acked,omitempty"`
+}
+
+// batchID identifies frames of segment by cfg's chain and node, their file
+// and frame range and hash, their batchHash, so a batch resent with the same
+// frames, after a retry or a restart, carries the same ID. Segment names
+// repeat in every day directory and across nodes; the hash tells those
+// batches apart.
+func batchID(cfg Config, segment, hash string, frames []batchFrame) string {
+	if len(frames) == 0 {
+		return ""
+	}
+	first, last := frames[0].Meta, frames[len(frames)-1].Meta
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s:%d\x00%s:%d\x00%s",
+		cfg.ChainID, cfg.NodeID, segment, first.File, first.Frame, last.File, last.Frame, hash)))
+	return hex.EncodeToString(h[:16])
+}
+
+// batchHash is the SHA-256 of the frames' bytes as read from the WAL.
+func batchHash(frames []batchFrame) string {
+	h := sha256.New()
+	for _, fr := range frames {
+		h.Write(fr.Compressed)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// batchJournal journals the uploads of one batch, which starts at st's
+// committed offset, in st.Journal. A nil batchJournal journals nothing.
+type batchJournal struct {
+	cfg    Config
+	st     *state
+	offset int64 // where the next upload starts
+}
+
+func newBatchJournal(cfg Config, st *state) *batchJournal {
+	return &batchJournal{cfg: cfg, st: st, offset: st.IdxOffset}
+}
+
+// begin journals frames, the next upload of the batch, and saves the state
+// before it is sent.
+func (j *batchJournal) begin(frames []batchFrame, segment string) {
+	if j == nil {
+		return
+	}
+	end := j.offset
+	for _, fr := range frames {
+		end += int64(fr.IdxLineLen)
+	}
+	last := frames[len(frames)-1].Meta
+	hash := batchHash(frames)
+	b := inFlightBatch{ID: batchID(j.cfg, segment, hash, frames), IdxPath: j.st.IdxPath, Offset: j.offset, EndOffset: end,
+		Frames: len(frames), Hash: hash, LastFile: last.File, LastFrame: last.Frame}
+	journal := slices.DeleteFunc(j.st.Journal, func(e inFlightBatch) bool { return e.ID == b.ID })
+	journal = append(journal, b)
+	if n := len(journal); n > maxJournal {
+		journal = journal[n-maxJournal:]
+	}
+	j.st.Journal = journal
+	_ = saveState(j.cfg.StateDir, *j.st)
+}
+
+// end records the outcome of the upload last begun. Accepted, it is marked
+// so and the next upload starts after it. Rejected by the service, it is
+// dropped, as it cannot have been stored. Otherwise, as when the request
+// timed out, whether it arrived stays open and it is kept.
+func (j *batchJournal) end(err error) {
+	if j == nil || len(j.st.Journal) == 0 {
+		return
+	}
+	n := len(j.st.Journal) - 1
+	var se *statusError
+	switch {
+	case err == nil:
+		j.st.Journal[n].Acked = true
+		j.offset = j.st.Journal[n].EndOffset
+	case errors.As(err, &se) && !isTimeout(err):
+		j.st.Journal = j.st.Journal[:n]
+	}
+}
+
+// pruneJournal drops the uploads that st's committed position has passed or
+// that belong to another segment.
+func pruneJournal(st *state) {
+	st.Journal = slices.DeleteFunc(st.Journal, func(e inFlightBatch) bool {
+		return e.IdxPath != st.IdxPath || e.Offset < st.IdxOffset
+	})
+	if len(st.Journal) == 0 {
+		st.Journal = nil
+	}
+}
+
+// reconcileJournal settles the uploads the previous run journaled but never
+// committed. Starting at the committed position, an upload the service
+// accepted, or one it reports having with the same hash, moves the position
+// past it, and the next one is checked from there. Of several uploads
+// starting at the same position, as when a batch was split, the longest is
+// checked first. The first frames no upload covers are read and sent again;
+// a service that cannot tell gets them again too, and may deduplicate them
+// by batch ID.
+func reconcileJournal(cfg Config, httpClient *http.Client, st *state) {
+	journal := st.Journal
+	if st.InFlight != nil {
+		journal = append(journal, *st.InFlight)
+	}
+	st.Journal, st.InFlight = nil, nil
+	for {
+		b, ok := deliveredUpload(cfg, httpClient, journal, *st)
+		if !ok {
+			break
+		}
+		st.IdxOffset = b.EndOffset
+		st.LastFile, st.LastFrame = b.LastFile, b.LastFrame
+		st.LastCommitAt = time.Now().UTC()
+		logger.Info().Str("batch_id", b.ID).Str("segment", b.IdxPath).Int64("end_offset", b.EndOffset).Msg("service has the batch in flight at shutdown; skipping it")
+		recordEvent(EventState, "batch "+b.ID+" was delivered before shutdown; not resending it")
+	}
+	_ = saveState(cfg.StateDir, *st)
+}
+
+// deliveredUpload returns the journaled upload starting at st's position
+// that the service has, if any.
+func deliveredUpload(cfg Config, httpClient *http.Client, journal []inFlightBatch, st state) (inFlightBatch, bool) {
+	var at []inFlightBatch
+	for _, b := range journal {
+		if b.IdxPath == st.IdxPath && b.Offset == st.IdxOffset && b.EndOffset > b.Offset {
+			at = append(at, b)
+		}
+	}
+	slices.SortStableFunc(at, func(a, b inFlightBatch) int { return cmp.Compare(b.EndOffset, a.EndOffset) })
+	for _, b := range at {
+		if b.Acked {
+			return b, true
+		}
+	}
+	for _, b := range at {
+		delivered, err := batchDelivered(cfg, httpClient, b.ID, b.Hash)
+		switch {
+		case err != nil:
+			logger.Warn().Err(err).Str("batch_id", b.ID).Msg("cannot check the batch in flight at shutdown; resending it")
+		case delivered:
+			return b, true
+		default:
+			logger.Info().Str("batch_id", b.ID).Msg("service lacks the batch in flight at shutdown; resending it")
+		}
+	}
+	return inFlightBatch{}, false
+}
+
+// batchDelivered asks the service whether it has stored batch id, with the
+// bytes hashing to hash if that is set. A service holding other bytes under
+// the ID answers 409 Conflict, an error.
+func batchDelivered(cfg Config, httpClient *http.Client, id, hash string) (bool, error) {
+	u := ingestURL(cfg, batchesEndpoint) + "/" + url.PathEscape(id)
+	if hash != "" {
+		u += "?sha256=" + url.QueryEscape(hash)
+	}
+	req, err := http.NewRequestWithContext(activePipeline(cfg).sendContext(), http.MethodGet, u, nil)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, newStatusError(resp)
+}
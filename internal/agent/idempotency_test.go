@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchID(t *testing.T) {
+	frames := func(from, to uint64) []batchFrame {
+		var out []batchFrame
+		for f := from; f <= to; f++ {
+			out = append(out, batchFrame{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: f}, Compressed: []byte{byte(f)}})
+		}
+		return out
+	}
+	cfg := Config{ChainID: "chain-1", NodeID: "node-1"}
+	id := batchID(cfg, "seg-000001.wal.idx", "h1", frames(1, 3))
+	if len(id) != 32 || id != batchID(cfg, "seg-000001.wal.idx", "h1", frames(1, 3)) {
+		t.Fatalf("batchID not deterministic: %q", id)
+	}
+	for _, other := range []string{
+		batchID(cfg, "seg-000001.wal.idx", "h1", frames(1, 4)),
+		batchID(cfg, "seg-000001.wal.idx", "h1", frames(2, 3)),
+		batchID(cfg, "seg-000002.wal.idx", "h1", frames(1, 3)),
+		// The same segment and frames of another day, node or chain.
+		batchID(cfg, "seg-000001.wal.idx", "h2", frames(1, 3)),
+		batchID(Config{ChainID: "chain-1", NodeID: "node-2"}, "seg-000001.wal.idx", "h1", frames(1, 3)),
+		batchID(Config{ChainID: "chain-2", NodeID: "node-1"}, "seg-000001.wal.idx", "h1", frames(1, 3)),
+	} {
+		if other == id {
+			t.Errorf("different batches share ID %s", id)
+		}
+	}
+}
+
+func TestPostBatch_SendsBatchID(t *testing.T) {
+	var got atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get(batchIDHeader))
+	}))
+	defer ts.Close()
+	frames := []batchFrame{{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 7, Len: 1}, Compressed: []byte{0}}}
+	if err := postBatch(context.Background(), Config{ServiceURL: ts.URL}, ts.Client(), frames, "seg-000001.wal.idx"); err != nil {
+		t.Fatal(err)
+	}
+	if want := batchID(Config{}, "seg-000001.wal.idx", batchHash(frames), frames); got.Load() != want {
+		t.Errorf("%s = %v, want %s", batchIDHeader, got.Load(), want)
+	}
+}
+
+func TestReconcileJournal(t *testing.T) {
+	const seg = "/wal/seg-000001.wal.idx"
+	tests := []struct {
+		name       string
+		journal    []inFlightBatch
+		legacy     bool
+		delivered  map[string]int // status by batch ID; 404 if missing
+		wantOffset int64
+		wantFrame  uint64
+		wantQuery  []string
+	}{
+		{name: "delivered", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300, LastFrame: 9}},
+			delivered: map[string]int{"abc": http.StatusOK}, wantOffset: 300, wantFrame: 9, wantQuery: []string{"abc"}},
+		{name: "not delivered", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300}},
+			wantOffset: 100, wantFrame: 4, wantQuery: []string{"abc"}},
+		{name: "service cannot tell", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300}},
+			delivered: map[string]int{"abc": http.StatusNotImplemented}, wantOffset: 100, wantFrame: 4, wantQuery: []string{"abc"}},
+		{name: "other bytes under the ID", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300}},
+			delivered: map[string]int{"abc": http.StatusConflict}, wantOffset: 100, wantFrame: 4, wantQuery: []string{"abc"}},
+		{name: "already committed", journal: []inFlightBatch{{ID: "abc", Offset: 50, EndOffset: 300}},
+			delivered: map[string]int{"abc": http.StatusOK}, wantOffset: 100, wantFrame: 4},
+		{name: "accepted before the crash", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300, LastFrame: 9, Acked: true}},
+			wantOffset: 300, wantFrame: 9},
+		{name: "split batch, second half delivered", journal: []inFlightBatch{
+			{ID: "whole", Offset: 100, EndOffset: 500, LastFrame: 20},
+			{ID: "first", Offset: 100, EndOffset: 300, LastFrame: 9, Acked: true},
+			{ID: "second", Offset: 300, EndOffset: 500, LastFrame: 20},
+		}, delivered: map[string]int{"second": http.StatusOK}, wantOffset: 500, wantFrame: 20, wantQuery: []string{"second"}},
+		{name: "split batch, whole delivered after all", journal: []inFlightBatch{
+			{ID: "whole", Offset: 100, EndOffset: 500, LastFrame: 20},
+			{ID: "first", Offset: 100, EndOffset: 300, LastFrame: 9},
+		}, delivered: map[string]int{"whole": http.StatusOK}, wantOffset: 500, wantFrame: 20, wantQuery: []string{"whole"}},
+		{name: "in flight from an earlier version", journal: []inFlightBatch{{ID: "abc", Offset: 100, EndOffset: 300, LastFrame: 9}}, legacy: true,
+			delivered: map[string]int{"abc": http.StatusOK}, wantOffset: 300, wantFrame: 9, wantQuery: []string{"abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var queried []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id := strings.TrimPrefix(r.URL.Path, batchesEndpoint+"/")
+				if r.Method != http.MethodGet || r.Header.Get("Authorization") != "Bearer key" || r.URL.Query().Get("sha256") != "h-"+id {
+					t.Errorf("query = %s %s", r.Method, r.URL)
+				}
+				mu.Lock()
+				queried = append(queried, id)
+				mu.Unlock()
+				if code, ok := tt.delivered[id]; ok {
+					w.WriteHeader(code)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer ts.Close()
+
+			cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), AuthKey: "key"}
+			st := state{IdxPath: seg, IdxOffset: 100, LastFrame: 4}
+			for _, b := range tt.journal {
+				b.IdxPath, b.Hash, b.LastFile = seg, "h-"+b.ID, "seg-000001.wal.gz"
+				st.Journal = append(st.Journal, b)
+			}
+			if tt.legacy {
+				st.InFlight, st.Journal = &st.Journal[0], nil
+			}
+			reconcileJournal(cfg, ts.Client(), &st)
+
+			if fmt.Sprint(queried) != fmt.Sprint(tt.wantQuery) {
+				t.Errorf("queried %v, want %v", queried, tt.wantQuery)
+			}
+			saved, err := loadState(cfg.StateDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []state{st, saved} {
+				if s.IdxOffset != tt.wantOffset || s.LastFrame != tt.wantFrame || s.Journal != nil || s.InFlight != nil {
+					t.Errorf("state offset %d, last frame %d, journal %v, in flight %v; want offset %d, frame %d and nothing journaled",
+						s.IdxOffset, s.LastFrame, s.Journal, s.InFlight, tt.wantOffset, tt.wantFrame)
+				}
+			}
+		})
+	}
+}
+
+func TestSendSplitting_Journal(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantJournal int
+		wantAcked   bool
+	}{
+		{"accepted", http.StatusOK, 1, true},
+		{"rejected", http.StatusBadRequest, 0, false},
+		{"gateway timeout", http.StatusGatewayTimeout, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := []batchFrame{
+				{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 1, Len: 1}, Compressed: []byte{1}, IdxLineLen: 40},
+				{Meta: FrameMeta{File: "seg-000001.wal.gz", Frame: 2, Len: 1}, Compressed: []byte{2}, IdxLineLen: 40},
+			}
+			cfg := Config{StateDir: t.TempDir(), SendMaxAttempts: 1}
+			st := state{IdxPath: "/wal/seg-000001.wal.idx", IdxOffset: 100}
+			var journaled []inFlightBatch
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The upload is on disk before it goes out.
+				saved, err := loadState(cfg.StateDir)
+				if err != nil {
+					t.Error(err)
+				}
+				journaled = saved.Journal
+				if r.Header.Get(batchHashHeader) != batchHash(frames) {
+					t.Errorf("%s = %q", batchHashHeader, r.Header.Get(batchHashHeader))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+			cfg.ServiceURL = ts.URL
+
+			sent, _ := sendSplitting(cfg, ts.Client(), frames, "seg-000001.wal.idx", newBatchJournal(cfg, &st))
+			want := inFlightBatch{ID: batchID(cfg, "seg-000001.wal.idx", batchHash(frames), frames), IdxPath: st.IdxPath, Offset: 100, EndOffset: 180,
+				Frames: 2, Hash: batchHash(frames), LastFile: "seg-000001.wal.gz", LastFrame: 2}
+			if len(journaled) != 1 || journaled[0] != want {
+				t.Fatalf("journaled before sending: %+v, want %+v", journaled, want)
+			}
+			if len(st.Journal) != tt.wantJournal || (tt.wantJournal > 0 && st.Journal[0].Acked != tt.wantAcked) {
+				t.Errorf("journal after the upload: %+v", st.Journal)
+			}
+			if sent > 0 {
+				commitBatch(cfg, &st, frames[:sent], "seg-000001.wal.idx")
+				if st.Journal != nil {
+					t.Errorf("journal after commit: %+v", st.Journal)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// idlePollAfter is how long the WAL must stay quiet before polling slows down.
+var idlePollAfter = time.Minute
+
+// idlePoller chooses how long to wait before polling the WAL again. It polls
+// at base while frames keep arriving, doubles the wait each poll once the WAL
+// has been idle for idlePollAfter, caps it at max, and snaps back to base as
+// soon as a frame is read.
+type idlePoller struct {
+	base, max  time.Duration
+	cur        time.Duration
+	lastActive time.Time
+}
+
+func newIdlePoller(base, max time.Duration) *idlePoller {
+	return &idlePoller{base: base, max: max, cur: base, lastActive: time.Now()}
+}
+
+// Active records that a frame was just read.
+func (p *idlePoller) Active() {
+	p.cur = p.base
+	p.lastActive = time.Now()
+}
+
+// Next returns the delay before the next poll.
+func (p *idlePoller) Next() time.Duration {
+	if p.max <= p.base || time.Since(p.lastActive) < idlePollAfter {
+		return p.base
+	}
+	d := p.cur
+	p.cur *= 2
+	if p.cur > p.max {
+		p.cur = p.max
+	}
+	return d
+}
+
+// sleepCtx waits for d, until ctx is done or until wake receives.
+func sleepCtx(ctx context.Context, d time.Duration, wake <-chan struct{}) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	case <-wake:
+	}
+}
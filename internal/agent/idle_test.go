@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdlePoller(t *testing.T) {
+	p := newIdlePoller(100*time.Millisecond, time.Second)
+
+	if d := p.Next(); d != 100*time.Millisecond {
+		t.Errorf("recently active: Next = %v, want base", d)
+	}
+
+	p.lastActive = time.Now().Add(-2 * idlePollAfter)
+	var got []time.Duration
+	for i := 0; i < 6; i++ {
+		got = append(got, p.Next())
+	}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i := range want {
+		if got[i] != want[i]*time.Millisecond {
+			t.Fatalf("idle delays = %v, want %v ms", got, want)
+		}
+	}
+
+	p.Active()
+	if d := p.Next(); d != 100*time.Millisecond {
+		t.Errorf("after activity: Next = %v, want base", d)
+	}
+}
+
+func TestIdlePoller_DisabledWhenMaxNotAboveBase(t *testing.T) {
+	p := newIdlePoller(time.Second, time.Second)
+	p.lastActive = time.Now().Add(-2 * idlePollAfter)
+	for i := 0; i < 3; i++ {
+		if d := p.Next(); d != time.Second {
+			t.Fatalf("Next = %v, want base", d)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func newKafkaSender(cfg Config) (*sender.KafkaSender, error) {
+	opts := sender.KafkaOptions{
+		Brokers:      cfg.KafkaBrokers,
+		Topic:        cfg.KafkaTopic,
+		ClientID:     "walship-" + cfg.NodeID,
+		MaxAttempts:  max(cfg.SendMaxAttempts, 1),
+		RetryBackoff: cfg.SendRetryBase,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			logger.Warn().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("retrying kafka produce")
+			metricSendRetries.Inc()
+			if cfg.OnRetry != nil {
+				cfg.OnRetry(RetryEvent{Attempt: attempt, Delay: delay, Err: err})
+			}
+		},
+	}
+	if cfg.KafkaTLS {
+		opts.TLS = kafkaTLSConfig(cfg)
+	}
+	logger.Warn().Strs("brokers", cfg.KafkaBrokers).Msg("the kafka sender is experimental: it has no SASL and does not compress records")
+	return sender.NewKafkaSender(opts)
+}
+
+// sendKafka publishes frames keyed by chain and node ID and returns how
+// many leading frames the cluster appended within HTTPTimeout. A failure
+// is reported to OnSendError like an HTTP upload's.
+func sendKafka(cfg Config, ks *sender.KafkaSender, frames []batchFrame, curIdxBase string) (int, error) {
+	ctx, cancel := context.WithTimeout(activePipeline(cfg).sendContext(), cfg.HTTPTimeout)
+	defer cancel()
+	wf := make([]wal.Frame, len(frames))
+	for i, fr := range frames {
+		wf[i] = wal.Frame{Meta: fr.Meta, Compressed: fr.Compressed}
+	}
+	sent, err := ks.Send(ctx, []byte(cfg.ChainID+"/"+cfg.NodeID), curIdxBase, wf)
+	if err != nil && cfg.OnSendError != nil {
+		ev := SendErrorEvent{Segment: curIdxBase, Frames: len(frames) - sent, Bytes: framesBytes(frames[sent:]), Attempts: 1, Err: err}
+		var ke *sender.KafkaError
+		if errors.As(err, &ke) {
+			ev.Attempts, ev.Retryable = ke.Attempts, ke.Retriable()
+		}
+		cfg.OnSendError(ev)
+	}
+	return sent, err
+}
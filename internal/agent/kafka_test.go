@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSendKafka_ReportsFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // nothing listens: every attempt fails to dial
+
+	var retries []RetryEvent
+	var failures []SendErrorEvent
+	cfg := Config{ChainID: "chain-1", NodeID: "node-1", KafkaBrokers: []string{addr}, KafkaTopic: "walship",
+		HTTPTimeout: 5 * time.Second, SendMaxAttempts: 2, SendRetryBase: time.Millisecond,
+		OnRetry:     func(ev RetryEvent) { retries = append(retries, ev) },
+		OnSendError: func(ev SendErrorEvent) { failures = append(failures, ev) }}
+	ks, err := newKafkaSender(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ks.Close()
+
+	frames := []batchFrame{{Compressed: []byte("AAAA")}, {Compressed: []byte("BBBB")}}
+	sent, err := sendKafka(cfg, ks, frames, "seg-000001.wal.idx")
+	if sent != 0 || err == nil {
+		t.Fatalf("sendKafka = %d, %v; want failure", sent, err)
+	}
+	if len(retries) != 1 {
+		t.Errorf("retries = %d, want 1", len(retries))
+	}
+	if len(failures) != 1 {
+		t.Fatalf("OnSendError calls = %d, want 1", len(failures))
+	}
+	if ev := failures[0]; ev.Attempts != 2 || !ev.Retryable || ev.Frames != 2 || ev.Bytes != 8 || ev.Segment != "seg-000001.wal.idx" {
+		t.Errorf("OnSendError event = %+v", ev)
+	}
+}
@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	keyRotationEndpoint = "/v1/ingest/key-rotations"
+	identityEndpoint    = "/v1/ingest/identity"
+)
+
+// DefaultPrivValidatorKeyName is the validator key file under the node's
+// config dir, unless config.toml sets priv_validator_key_file.
+const DefaultPrivValidatorKeyName = "priv_validator_key.json"
+
+var keyWatchInterval = time.Minute
+
+// Key kinds of a KeyRotation.
+const (
+	KeyKindNode      = "node_key"
+	KeyKindValidator = "validator_key"
+)
+
+// KeyRotation is a change of the node's identity or validator key, as sent
+// to keyRotationEndpoint.
+type KeyRotation struct {
+	Kind string `json:"kind"`
+	// Old and New are the node IDs, or the validator addresses.
+	Old string `json:"old"`
+	New string `json:"new"`
+	// OldPubKey and NewPubKey are the validator public keys, as in
+	// priv_validator_key.json.
+	OldPubKey  string    `json:"old_pub_key,omitempty"`
+	NewPubKey  string    `json:"new_pub_key,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// NodeIdentity is the node's public identity, as sent to identityEndpoint
+// when the key scraper starts and whenever it changes, e.g. after a key
+// rotation, so the service can join the node's WAL to validator-set data.
+type NodeIdentity struct {
+	NodeID  string `json:"node_id"`
+	Moniker string `json:"moniker,omitempty"`
+	// ValidatorAddress and ValidatorPubKey are unknown when the validator
+	// key file is missing, e.g. for a remote signer. The key is formatted
+	// as in KeyRotation.
+	ValidatorAddress string    `json:"validator_address,omitempty"`
+	ValidatorPubKey  string    `json:"validator_pub_key,omitempty"`
+	ReportedAt       time.Time `json:"reported_at"`
+}
+
+// keyIdentity is the public side of the node's keys, kept in keys.json in
+// the state dir to compare against. Empty fields are unknown: the file was
+// missing, e.g. a validator signing remotely.
+type keyIdentity struct {
+	NodeID           string `json:"node_id,omitempty"`
+	ValidatorAddress string `json:"validator_address,omitempty"`
+	ValidatorPubKey  string `json:"validator_pub_key,omitempty"`
+}
+
+// privValidatorKey holds the public fields of priv_validator_key.json; the
+// private key is never decoded.
+type privValidatorKey struct {
+	Address string `json:"address"`
+	PubKey  struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"pub_key"`
+}
+
+func keysFile(dir string) string {
+	return filepath.Join(dir, "keys.json")
+}
+
+// keyScraper watches node_key.json and priv_validator_key.json and reports
+// rotations, which would otherwise silently split or merge a node's history
+// in per-node analysis, along with the node's identity.
+type keyScraper struct {
+	cfg        Config
+	httpClient *http.Client
+	state      *keyScraperState
+}
+
+// keyScraperState outlives the scraper's value receivers.
+type keyScraperState struct {
+	// sent is the identity last shipped, nil until the first one is.
+	sent *NodeIdentity
+}
+
+func newKeyScraper(cfg Config, httpClient *http.Client) keyScraper {
+	return keyScraper{cfg: cfg, httpClient: httpClient, state: &keyScraperState{}}
+}
+
+type keyReport struct {
+	cur       keyIdentity
+	rotations []KeyRotation
+	identity  NodeIdentity
+}
+
+func (keyScraper) Name() string            { return "keys" }
+func (keyScraper) Interval() time.Duration { return keyWatchInterval }
+
+func (s keyScraper) Collect(ctx context.Context) (any, error) {
+	cc := readCometConfig(s.cfg.NodeHome)
+	cur, err := readKeyIdentity(cc)
+	if err != nil {
+		return nil, err
+	}
+	var prev keyIdentity
+	if err := readJSON(keysFile(s.cfg.StateDir), &prev); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read %s: %w", keysFile(s.cfg.StateDir), err)
+	}
+	now := time.Now().UTC()
+	merged := mergeKeyIdentity(prev, cur)
+	return keyReport{
+		cur:       merged,
+		rotations: keyRotations(prev, cur, now),
+		identity: NodeIdentity{NodeID: merged.NodeID, Moniker: cc.Moniker,
+			ValidatorAddress: merged.ValidatorAddress, ValidatorPubKey: merged.ValidatorPubKey, ReportedAt: now},
+	}, nil
+}
+
+// Ship reports the rotations and, if it changed since it was last shipped,
+// the identity, then records the keys; a failed report is repeated on the
+// next scrape.
+func (s keyScraper) Ship(ctx context.Context, data any) error {
+	r := data.(keyReport)
+	for _, kr := range r.rotations {
+		logger.Warn().Str("kind", kr.Kind).Str("old", kr.Old).Str("new", kr.New).Msg("key rotated")
+		recordEvent(EventState, fmt.Sprintf("%s rotated from %s to %s", kr.Kind, kr.Old, kr.New))
+	}
+	if len(r.rotations) > 0 {
+		if err := postDerived(s.cfg, s.httpClient, keyRotationEndpoint, r.rotations); err != nil {
+			return err
+		}
+	}
+	if prev := s.state.sent; prev == nil || !sameIdentity(*prev, r.identity) {
+		if err := postDerived(s.cfg, s.httpClient, identityEndpoint, r.identity); err != nil {
+			return err
+		}
+		s.state.sent = &r.identity
+	}
+	return writeJSONAtomic(s.cfg.StateDir, keysFile(s.cfg.StateDir), r.cur)
+}
+
+// sameIdentity compares identities but for when they were reported.
+func sameIdentity(a, b NodeIdentity) bool {
+	a.ReportedAt, b.ReportedAt = time.Time{}, time.Time{}
+	return a == b
+}
+
+// keyRotations compares the keys with those recorded; a key missing on
+// either side is not a rotation.
+func keyRotations(prev, cur keyIdentity, now time.Time) []KeyRotation {
+	var out []KeyRotation
+	if prev.NodeID != "" && cur.NodeID != "" && prev.NodeID != cur.NodeID {
+		out = append(out, KeyRotation{Kind: KeyKindNode, Old: prev.NodeID, New: cur.NodeID, DetectedAt: now})
+	}
+	if prev.ValidatorPubKey != "" && cur.ValidatorPubKey != "" && prev.ValidatorPubKey != cur.ValidatorPubKey {
+		out = append(out, KeyRotation{Kind: KeyKindValidator, Old: prev.ValidatorAddress, New: cur.ValidatorAddress,
+			OldPubKey: prev.ValidatorPubKey, NewPubKey: cur.ValidatorPubKey, DetectedAt: now})
+	}
+	return out
+}
+
+// mergeKeyIdentity keeps the recorded keys that are currently unknown.
+func mergeKeyIdentity(prev, cur keyIdentity) keyIdentity {
+	if cur.NodeID == "" {
+		cur.NodeID = prev.NodeID
+	}
+	if cur.ValidatorPubKey == "" {
+		cur.ValidatorAddress, cur.ValidatorPubKey = prev.ValidatorAddress, prev.ValidatorPubKey
+	}
+	return cur
+}
+
+// readKeyIdentity reads the public keys of the node from the key files its
+// config.toml names. Missing key files are left unknown.
+func readKeyIdentity(cc cometConfig) (keyIdentity, error) {
+	var id keyIdentity
+	nodeID, err := readNodeIDFile(cc.NodeKeyFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return keyIdentity{}, fmt.Errorf("read node key: %w", err)
+	}
+	id.NodeID = nodeID
+
+	b, err := readFileReadOnly(cc.PrivValidatorKeyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return id, nil
+	}
+	if err != nil {
+		return keyIdentity{}, fmt.Errorf("read validator key: %w", err)
+	}
+	var pk privValidatorKey
+	if err := json.Unmarshal(b, &pk); err != nil {
+		return keyIdentity{}, fmt.Errorf("parse validator key: %w", err)
+	}
+	id.ValidatorAddress = pk.Address
+	if pk.PubKey.Value != "" {
+		id.ValidatorPubKey = pk.PubKey.Type + "/" + pk.PubKey.Value
+	}
+	return id, nil
+}
+
+// cometConfig holds the fields of the node's config.toml the key scraper
+// reads.
+type cometConfig struct {
+	Moniker              string `toml:"moniker"`
+	NodeKeyFile          string `toml:"node_key_file"`
+	PrivValidatorKeyFile string `toml:"priv_validator_key_file"`
+}
+
+// readCometConfig reads config.toml of the node at nodeHome, with the key
+// file paths defaulted and resolved against nodeHome.
+func readCometConfig(nodeHome string) cometConfig {
+	var cfg cometConfig
+	if b, err := readFileReadOnly(rootify(filepath.Join(DefaultConfigDir, "config.toml"), nodeHome)); err == nil {
+		_ = toml.Unmarshal(b, &cfg)
+	}
+	if cfg.NodeKeyFile == "" {
+		cfg.NodeKeyFile = filepath.Join(DefaultConfigDir, DefaultNodeKeyName)
+	}
+	if cfg.PrivValidatorKeyFile == "" {
+		cfg.PrivValidatorKeyFile = filepath.Join(DefaultConfigDir, DefaultPrivValidatorKeyName)
+	}
+	cfg.NodeKeyFile, cfg.PrivValidatorKeyFile = rootify(cfg.NodeKeyFile, nodeHome), rootify(cfg.PrivValidatorKeyFile, nodeHome)
+	return cfg
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePrivValidatorKey(t *testing.T, path, address, pubKey string) {
+	t.Helper()
+	b := `{"address":"` + address + `","pub_key":{"type":"tendermint/PubKeyEd25519","value":"` + pubKey + `"},"priv_key":{"type":"tendermint/PrivKeyEd25519","value":"SECRET"}}`
+	if err := os.WriteFile(path, []byte(b), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyScraper(t *testing.T) {
+	home := t.TempDir()
+	id1 := writeNodeHome(t, home, "chain")
+	// config.toml moves the validator key out of the config dir.
+	if err := os.WriteFile(filepath.Join(home, "config", "config.toml"), []byte(`moniker = "val-1"`+"\n"+`priv_validator_key_file = "keys/pv.json"`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, "keys"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	pvPath := filepath.Join(home, "keys", "pv.json")
+	writePrivValidatorKey(t, pvPath, "ADDR1", "PUB1")
+
+	var bodies, identities []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case keyRotationEndpoint:
+			bodies = append(bodies, string(b))
+		case identityEndpoint:
+			identities = append(identities, string(b))
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := Config{NodeHome: home, StateDir: t.TempDir(), ServiceURL: ts.URL}
+	s := newKeyScraper(cfg, ts.Client())
+	scrape := func() []KeyRotation {
+		t.Helper()
+		data, err := s.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Ship(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+		return data.(keyReport).rotations
+	}
+
+	if got := scrape(); len(got) != 0 {
+		t.Fatalf("first scrape reported %+v", got)
+	}
+	if got := scrape(); len(got) != 0 {
+		t.Fatalf("unchanged keys reported %+v", got)
+	}
+	if len(identities) != 1 {
+		t.Fatalf("identity shipped %d times for unchanged keys, want once at start", len(identities))
+	}
+	var ident NodeIdentity
+	if err := json.Unmarshal([]byte(identities[0]), &ident); err != nil {
+		t.Fatal(err)
+	}
+	if ident.NodeID != id1 || ident.Moniker != "val-1" || ident.ValidatorAddress != "ADDR1" ||
+		ident.ValidatorPubKey != "tendermint/PubKeyEd25519/PUB1" || ident.ReportedAt.IsZero() {
+		t.Errorf("identity = %+v", ident)
+	}
+
+	// A remote signer leaves no key file: not a rotation.
+	os.Remove(pvPath)
+	if got := scrape(); len(got) != 0 {
+		t.Fatalf("missing validator key reported %+v", got)
+	}
+
+	id2 := writeNodeHome(t, home, "chain")
+	writePrivValidatorKey(t, pvPath, "ADDR2", "PUB2")
+	got := scrape()
+	if len(got) != 2 {
+		t.Fatalf("got %d rotations, want 2: %+v", len(got), got)
+	}
+	if got[0].Kind != KeyKindNode || got[0].Old != id1 || got[0].New != id2 {
+		t.Errorf("node rotation = %+v", got[0])
+	}
+	if got[1].Kind != KeyKindValidator || got[1].Old != "ADDR1" || got[1].New != "ADDR2" ||
+		got[1].OldPubKey != "tendermint/PubKeyEd25519/PUB1" || got[1].NewPubKey != "tendermint/PubKeyEd25519/PUB2" {
+		t.Errorf("validator rotation = %+v", got[1])
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("got %d posts, want 1", len(bodies))
+	}
+	if len(identities) != 2 {
+		t.Fatalf("identity shipped %d times, want again after the rotation", len(identities))
+	}
+	if strings.Contains(bodies[0], "SECRET") || strings.Contains(identities[1], "SECRET") {
+		t.Error("private key shipped")
+	}
+	if err := json.Unmarshal([]byte(identities[1]), &ident); err != nil || ident.NodeID != id2 || ident.ValidatorAddress != "ADDR2" {
+		t.Errorf("identity after rotation = %+v (%v)", ident, err)
+	}
+	var sent []KeyRotation
+	if err := json.Unmarshal([]byte(bodies[0]), &sent); err != nil || len(sent) != 2 {
+		t.Errorf("body %s: %v", bodies[0], err)
+	}
+
+	if got := scrape(); len(got) != 0 {
+		t.Fatalf("rotation reported twice: %+v", got)
+	}
+}
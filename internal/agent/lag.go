@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+var lagReportInterval = 30 * time.Second
+
+// walLag describes how much of the WAL has not been committed yet.
+type walLag struct {
+	Frames int64
+	Bytes  int64
+}
+
+// computeLag counts the frames (and their compressed bytes) indexed after the
+// committed offset in st, including every newer index file that already exists.
+// Incomplete trailing index lines are not counted.
+func computeLag(st state) (walLag, error) {
+	var lag walLag
+	if st.IdxPath == "" {
+		return lag, nil
+	}
+
+	idxPath, off := st.IdxPath, st.IdxOffset
+	for {
+		if err := countPending(idxPath, off, &lag); err != nil {
+			return lag, err
+		}
+		next, ok, err := wal.NextIndexAfter(idxPath)
+		if err != nil || !ok {
+			return lag, nil
+		}
+		idxPath, off = next, 0
+	}
+}
+
+func countPending(idxPath string, off int64, lag *walLag) error {
+	f, r, err := openIdx(idxPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return err
+		}
+		r.Reset(f)
+	}
+	for {
+		fm, line, err := nextFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if line == nil {
+				return err
+			}
+			// Skip malformed lines the same way the tailer does.
+			continue
+		}
+		lag.Frames++
+		lag.Bytes += int64(fm.Len)
+	}
+}
+
+// lagScraper periodically recomputes the lag from the persisted state,
+// records it in the agent stats, and emits a heartbeat log line unless
+// noHeartbeat is set.
+type lagScraper struct {
+	stateDir    string
+	noHeartbeat bool
+}
+
+type lagReport struct {
+	lag     walLag
+	idxPath string
+}
+
+func (lagScraper) Name() string            { return "lag" }
+func (lagScraper) Interval() time.Duration { return lagReportInterval }
+
+func (s lagScraper) Collect(ctx context.Context) (any, error) {
+	st, err := loadState(s.stateDir)
+	if err != nil {
+		return nil, err
+	}
+	lag, err := computeLag(st)
+	if err != nil {
+		return nil, fmt.Errorf("compute lag: %w", err)
+	}
+	return lagReport{lag: lag, idxPath: st.IdxPath}, nil
+}
+
+func (s lagScraper) Ship(ctx context.Context, data any) error {
+	r := data.(lagReport)
+	recordLag(s.stateDir, r.lag)
+	if s.noHeartbeat {
+		return nil
+	}
+	logger.Info().
+		Int64("frames_behind", r.lag.Frames).
+		Int64("bytes_behind", r.lag.Bytes).
+		Str("idx", r.idxPath).
+		Msg("heartbeat")
+	return nil
+}
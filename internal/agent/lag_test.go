@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeIdx(t *testing.T, path string, frames []FrameMeta) []int {
+	t.Helper()
+	var (
+		buf  []byte
+		lens []int
+	)
+	for _, fm := range frames {
+		b, err := json.Marshal(fm)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		b = append(b, '\n')
+		lens = append(lens, len(b))
+		buf = append(buf, b...)
+	}
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return lens
+}
+
+func TestComputeLag(t *testing.T) {
+	dayDir := filepath.Join(t.TempDir(), "2024-01-01")
+	if err := os.MkdirAll(dayDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	seg1 := filepath.Join(dayDir, "seg-000001.wal.idx")
+	lens := writeIdx(t, seg1, []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: 100},
+		{File: "seg-000001.wal.gz", Frame: 2, Len: 200},
+		{File: "seg-000001.wal.gz", Frame: 3, Len: 300},
+	})
+	writeIdx(t, filepath.Join(dayDir, "seg-000002.wal.idx"), []FrameMeta{
+		{File: "seg-000002.wal.gz", Frame: 4, Len: 400},
+	})
+
+	lag, err := computeLag(state{IdxPath: seg1, IdxOffset: int64(lens[0])})
+	if err != nil {
+		t.Fatalf("computeLag: %v", err)
+	}
+	if lag.Frames != 3 {
+		t.Errorf("Frames = %d, want 3", lag.Frames)
+	}
+	if lag.Bytes != 900 {
+		t.Errorf("Bytes = %d, want 900", lag.Bytes)
+	}
+}
+
+func TestComputeLag_IgnoresPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	idx := filepath.Join(dir, "seg-000001.wal.idx")
+	writeIdx(t, idx, []FrameMeta{{File: "seg-000001.wal.gz", Frame: 1, Len: 10}})
+
+	f, err := os.OpenFile(idx, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"file":"seg-000001.wal.gz","frame":2`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	lag, err := computeLag(state{IdxPath: idx})
+	if err != nil {
+		t.Fatalf("computeLag: %v", err)
+	}
+	if lag.Frames != 1 || lag.Bytes != 10 {
+		t.Errorf("lag = %+v, want 1 frame / 10 bytes", lag)
+	}
+}
+
+func TestLagScraper_UpdatesStats(t *testing.T) {
+	dir := t.TempDir()
+	idx := filepath.Join(dir, "seg-000001.wal.idx")
+	writeIdx(t, idx, []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: 5},
+		{File: "seg-000001.wal.gz", Frame: 2, Len: 7},
+	})
+	if err := saveState(dir, state{IdxPath: idx}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scrapeOnce(context.Background(), lagScraper{stateDir: dir}); err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+
+	s := CurrentStats()
+	if s.LagFrames != 2 || s.LagBytes != 12 {
+		t.Errorf("stats lag = %d frames / %d bytes, want 2 / 12", s.LagFrames, s.LagBytes)
+	}
+	if s.LagUpdatedAt.IsZero() {
+		t.Error("LagUpdatedAt should be set")
+	}
+}
@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Buckets of the ledger. batches holds each LedgerEntry as JSON by its
+// big-endian batch number. byHeight and byTime index batches by
+// min_height (first_ts) followed by the batch number, to the batch's
+// max_height (last_ts).
+var (
+	ledgerBatches  = []byte("batches")
+	ledgerByHeight = []byte("by_height")
+	ledgerByTime   = []byte("by_time")
+)
+
+// ledgerLockTimeout bounds the wait for the ledger while another process
+// has it open.
+const ledgerLockTimeout = 5 * time.Second
+
+// LedgerEntry is one delivered batch. Times are record timestamps from the
+// WAL index; heights are those of the consensus messages in the batch and
+// are 0 if it held none. Spooled batches were replayed from the disk spool
+// and have no index offsets.
+type LedgerEntry struct {
+	Batch       int64     `json:"batch"`
+	SentAt      time.Time `json:"sent_at"`
+	Segment     string    `json:"segment"`
+	File        string    `json:"file"`
+	FirstFrame  uint64    `json:"first_frame"`
+	LastFrame   uint64    `json:"last_frame"`
+	StartOffset int64     `json:"start_offset"`
+	EndOffset   int64     `json:"end_offset"`
+	Frames      int       `json:"frames"`
+	Bytes       int       `json:"bytes"`
+	FirstTime   time.Time `json:"first_time"`
+	LastTime    time.Time `json:"last_time"`
+	MinHeight   int64     `json:"min_height,omitempty"`
+	MaxHeight   int64     `json:"max_height,omitempty"`
+	Spooled     bool      `json:"spooled,omitempty"`
+}
+
+// LedgerQuery selects ledger entries: those holding Height, else those
+// whose records span Time, else the most recent. At most Limit entries are
+// returned (100 if 0).
+type LedgerQuery struct {
+	Height int64
+	Time   time.Time
+	Limit  int
+}
+
+// ledger records delivered batches in a bbolt database. bbolt locks the
+// file for as long as it is open, so the database is only opened for each
+// read or write, and `walship ledger query` works while the agent runs.
+type ledger struct {
+	path string
+}
+
+func ledgerFile(dir string) string {
+	return filepath.Join(dir, "ledger.bolt")
+}
+
+// openLedger creates the ledger at path if needed.
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path}
+	err := l.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{ledgerBatches, ledgerByHeight, ledgerByTime} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open ledger: %w", err)
+	}
+	return l, nil
+}
+
+func (l *ledger) update(fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(l.path, 0o600, &bolt.Options{Timeout: ledgerLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func (l *ledger) view(fn func(*bolt.Tx) error) error {
+	db, err := bolt.Open(l.path, 0o600, &bolt.Options{Timeout: ledgerLockTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// ledgerKey is the big-endian encoding of a followed by b, if given.
+func ledgerKey(a int64, b ...int64) []byte {
+	k := make([]byte, 8*(1+len(b)))
+	binary.BigEndian.PutUint64(k, uint64(a))
+	for i, v := range b {
+		binary.BigEndian.PutUint64(k[8*(i+1):], uint64(v))
+	}
+	return k
+}
+
+// record adds the delivered batch ev describes.
+func (l *ledger) record(ev SendSuccessEvent, spooled bool) error {
+	e := LedgerEntry{
+		SentAt: ev.SentAt.UTC(), Segment: ev.Segment, File: ev.File,
+		FirstFrame: ev.FirstFrame, LastFrame: ev.LastFrame,
+		StartOffset: ev.StartOffset, EndOffset: ev.EndOffset,
+		Frames: ev.Frames, Bytes: ev.Bytes, Spooled: spooled,
+	}
+	if ev.FirstTS != 0 {
+		e.FirstTime = time.Unix(0, ev.FirstTS).UTC()
+	}
+	if ev.LastTS != 0 {
+		e.LastTime = time.Unix(0, ev.LastTS).UTC()
+	}
+	if ev.MaxHeight > 0 {
+		e.MinHeight, e.MaxHeight = ev.MinHeight, ev.MaxHeight
+	}
+	return l.update(func(tx *bolt.Tx) error {
+		batches := tx.Bucket(ledgerBatches)
+		seq, err := batches.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.Batch = int64(seq)
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := batches.Put(ledgerKey(e.Batch), b); err != nil {
+			return err
+		}
+		if e.MaxHeight > 0 {
+			if err := tx.Bucket(ledgerByHeight).Put(ledgerKey(e.MinHeight, e.Batch), ledgerKey(e.MaxHeight)); err != nil {
+				return err
+			}
+		}
+		if ev.FirstTS != 0 {
+			return tx.Bucket(ledgerByTime).Put(ledgerKey(ev.FirstTS, e.Batch), ledgerKey(ev.LastTS))
+		}
+		return nil
+	})
+}
+
+func (l *ledger) query(q LedgerQuery) ([]LedgerEntry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	var out []LedgerEntry
+	err := l.view(func(tx *bolt.Tx) error {
+		batches := tx.Bucket(ledgerBatches)
+		if batches == nil {
+			return nil
+		}
+		var ids [][]byte
+		switch {
+		case q.Height > 0:
+			ids = spanning(tx.Bucket(ledgerByHeight), q.Height)
+		case !q.Time.IsZero():
+			ids = spanning(tx.Bucket(ledgerByTime), q.Time.UnixNano())
+		default:
+			c := batches.Cursor()
+			for k, _ := c.Last(); k != nil && len(ids) < limit; k, _ = c.Prev() {
+				ids = append(ids, k)
+			}
+		}
+		if len(ids) > limit {
+			ids = ids[:limit]
+		}
+		for _, id := range ids {
+			var e LedgerEntry
+			if err := json.Unmarshal(batches.Get(id), &e); err != nil {
+				return fmt.Errorf("batch %d: %w", binary.BigEndian.Uint64(id), err)
+			}
+			out = append(out, e)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// spanning returns, in batch order, the batches an index bucket has as
+// spanning v: those keyed at or below v whose value is at or above it.
+func spanning(idx *bolt.Bucket, v int64) [][]byte {
+	if idx == nil {
+		return nil
+	}
+	var ids [][]byte
+	upper := ledgerKey(v + 1)
+	c := idx.Cursor()
+	for k, end := c.First(); k != nil && bytes.Compare(k, upper) < 0; k, end = c.Next() {
+		if int64(binary.BigEndian.Uint64(end)) >= v {
+			ids = append(ids, append([]byte(nil), k[8:]...))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i], ids[j]) < 0 })
+	return ids
+}
+
+// QueryLedger reads the delivery ledger kept in cfg.StateDir. The agent does
+// not need to be running.
+func QueryLedger(cfg Config, q LedgerQuery) ([]LedgerEntry, error) {
+	path := ledgerFile(cfg.StateDir)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no ledger at %s; run the agent with --ledger", path)
+	}
+	return (&ledger{path: path}).query(q)
+}
+
+// recordDelivery adds a batch to the ledger of cfg's pipeline, if any,
+// finding its heights in frames unless ev has them. Failures are logged and
+// never hold up shipping.
+func recordDelivery(cfg Config, ev SendSuccessEvent, frames []batchFrame, spooled bool) {
+	l := activePipeline(cfg).activeLedger()
+	if l == nil {
+		return
+	}
+	if ev.MaxHeight == 0 {
+		ev.MinHeight, ev.MaxHeight = batchHeights(frames)
+	}
+	if err := l.record(ev, spooled); err != nil {
+		logger.Error().Err(err).Str("segment", ev.Segment).Msg("ledger")
+	}
+}
+
+// batchHeights returns the lowest and highest consensus heights in frames,
+// or zeros if none hold a consensus message.
+func batchHeights(frames []batchFrame) (minH, maxH int64) {
+	for _, fr := range frames {
+		raw, err := wal.Decompress(fr.Compressed)
+		if err != nil {
+			continue
+		}
+		for _, ev := range consensus.DecodeFrame(raw, nil).Events {
+			h := ev.Height()
+			if h <= 0 {
+				continue
+			}
+			if minH == 0 || h < minH {
+				minH = h
+			}
+			if h > maxH {
+				maxH = h
+			}
+		}
+	}
+	return minH, maxH
+}
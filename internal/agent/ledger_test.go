@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRun_RecordsLedger(t *testing.T) {
+	proposal := func(h int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/ProposalMessage","value":{"proposal":{"type":32,"height":"%d","round":0,"pol_round":-1}}},"peer_key":""}}}`, h)
+	}
+	f1 := gzipFrame(t, proposal(5), proposal(6))
+	f2 := gzipFrame(t, proposal(9))
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), append(append([]byte(nil), f1...), f2...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ts0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(f1)), FirstTS: ts0, LastTS: ts0 + 10},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: uint64(len(f1)), Len: uint64(len(f2)), FirstTS: ts0 + 20, LastTS: ts0 + 30},
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := Config{ServiceURL: srv.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond, Ledger: true}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// The first frame goes out on its own as soon as it is read, the second
+	// at the end of the WAL, so each frame is one batch.
+	tests := []struct {
+		name       string
+		q          LedgerQuery
+		wantFrames []uint64 // first frame of each matching batch
+	}{
+		{name: "height in first batch", q: LedgerQuery{Height: 6}, wantFrames: []uint64{1}},
+		{name: "height in second batch", q: LedgerQuery{Height: 9}, wantFrames: []uint64{2}},
+		{name: "height not delivered", q: LedgerQuery{Height: 12}},
+		{name: "time", q: LedgerQuery{Time: time.Unix(0, ts0+25)}, wantFrames: []uint64{2}},
+		{name: "latest first", q: LedgerQuery{}, wantFrames: []uint64{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := QueryLedger(cfg, tt.q)
+			if err != nil {
+				t.Fatalf("QueryLedger: %v", err)
+			}
+			var frames []uint64
+			for _, e := range got {
+				frames = append(frames, e.FirstFrame)
+				if e.Segment != "seg-000001.wal.idx" || e.Frames == 0 || e.SentAt.IsZero() {
+					t.Errorf("entry = %+v", e)
+				}
+			}
+			if fmt.Sprint(frames) != fmt.Sprint(tt.wantFrames) {
+				t.Errorf("batches starting at frames %v, want %v", frames, tt.wantFrames)
+			}
+		})
+	}
+}
+
+func TestQueryLedger_Missing(t *testing.T) {
+	if _, err := QueryLedger(Config{StateDir: t.TempDir()}, LedgerQuery{Height: 1}); err == nil {
+		t.Fatal("QueryLedger without a ledger succeeded")
+	}
+}
+
+func TestLedger_QueryWhileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.bolt")
+	l, err := openLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		ev := SendSuccessEvent{Segment: "seg-000001.wal.idx", FirstFrame: uint64(i), LastFrame: uint64(i), Frames: 1,
+			SentAt: time.Now(), MinHeight: int64(10 * i), MaxHeight: int64(10*i + 5)}
+		if err := l.record(ev, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A second handle, as `walship ledger query` opens beside the agent.
+	got, err := (&ledger{path: path}).query(LedgerQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Batch != 3 || got[1].Batch != 2 {
+		t.Errorf("latest two = %+v", got)
+	}
+	if got, _ := l.query(LedgerQuery{Height: 25}); len(got) != 1 || got[0].FirstFrame != 2 {
+		t.Errorf("height 25 = %+v, want batch of frame 2", got)
+	}
+}
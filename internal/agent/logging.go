@@ -1,19 +1,71 @@
 package agent
 
 import (
+	"fmt"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/rs/zerolog"
+
+	wlog "github.com/bft-labs/walship/pkg/log"
 )
 
 var logger zerolog.Logger
 
+// logLevel is the level of the slog handler every event goes through.
+var logLevel = new(slog.LevelVar)
+
 func init() {
-	logger = logger.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	// pkg/log parses the event times back; keep their sub-second part.
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	_ = SetLogFormat(wlog.FormatText)
 }
 
 // Logger returns the package logger.
 func Logger() zerolog.Logger {
 	return logger
 }
+
+// SetLogFormat switches the package logger between human-readable console
+// output ("text") and one JSON object per line ("json"). Events are written
+// to stderr by a pkg/log handler.
+func SetLogFormat(format string) error {
+	h, err := wlog.NewHandler(os.Stderr, format, logLevel)
+	if err != nil {
+		return fmt.Errorf("unknown output format %q (want text or json)", format)
+	}
+	logger = zerolog.New(wlog.NewWriter(h)).Sample(levelSampler{}).With().Timestamp().Logger()
+	return nil
+}
+
+// SetLogLevel drops events below level ("debug", "info", "warn" or
+// "error"). Events below it are not even formatted. Only the package logger
+// is affected, not zerolog's global level.
+func SetLogLevel(level string) error {
+	l, err := wlog.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	return nil
+}
+
+// levelSampler lets zerolog drop events below logLevel before they are
+// formatted. A sampler is consulted on every event, so a reload takes
+// effect without replacing the logger.
+type levelSampler struct{}
+
+func (levelSampler) Sample(l zerolog.Level) bool {
+	switch l {
+	case zerolog.TraceLevel:
+		return logLevel.Level() <= wlog.LevelTrace
+	case zerolog.DebugLevel:
+		return logLevel.Level() <= slog.LevelDebug
+	case zerolog.InfoLevel:
+		return logLevel.Level() <= slog.LevelInfo
+	case zerolog.WarnLevel:
+		return logLevel.Level() <= slog.LevelWarn
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSetLogLevel_ScopedToLogger(t *testing.T) {
+	defer SetLogLevel("info")
+	global := zerolog.GlobalLevel()
+
+	if err := SetLogLevel("error"); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Warn().Enabled() || !logger.Error().Enabled() {
+		t.Error("error level let warnings through or dropped errors")
+	}
+	if err := SetLogLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.Debug().Enabled() || logger.Trace().Enabled() {
+		t.Error("debug level dropped debug events or let trace through")
+	}
+	if zerolog.GlobalLevel() != global {
+		t.Errorf("zerolog global level changed to %v", zerolog.GlobalLevel())
+	}
+	if err := SetLogLevel("verbose"); err == nil {
+		t.Error("SetLogLevel accepted an unknown level")
+	}
+}
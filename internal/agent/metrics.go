@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+// Lifecycle states reported as walship_state and by the admin API.
+// StateCrashed is only entered under a Supervisor, between a failed run and
+// its restart.
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateStopped  = "stopped"
+	StateCrashed  = "crashed"
+)
+
+var lifecycleStates = []string{StateStarting, StateRunning, StateStopping, StateStopped, StateCrashed}
+
+var (
+	metricFramesRead = metrics.NewCounter("walship_frames_read_total",
+		"WAL frames read from disk.")
+	metricBatchesSent = metrics.NewCounter("walship_batches_sent_total",
+		"Batches accepted by the service.")
+	metricBytesCompressed = metrics.NewCounter("walship_bytes_compressed_total",
+		"Compressed frame bytes accepted by the service.")
+	metricBytesUncompressed = metrics.NewCounter("walship_bytes_uncompressed_total",
+		"Uncompressed size of the frames accepted by the service.")
+	metricSendDuration = metrics.NewHistogram("walship_send_duration_seconds",
+		"Duration of batch uploads, successful or not.")
+	metricSendRetries = metrics.NewCounter("walship_send_retries_total",
+		"Failed batch and config uploads that will be retried.")
+	metricFramesSkipped = metrics.NewCounter("walship_frames_skipped_total",
+		"WAL frames deliberately not shipped and reported as tombstones.")
+	metricFramesSampledOut = metrics.NewCounter("walship_frames_sampled_out_total",
+		"WAL frames not shipped because a frame filter or sampler rejected them.")
+	metricWALGaps = metrics.NewCounter("walship_wal_gaps_total",
+		"Gaps detected in the WAL: missing frame numbers or deleted segments.")
+	metricUploadThrottled = metrics.NewGauge("walship_upload_throttled",
+		"1 while uploads are held back by max-upload-bytes-per-sec.")
+	metricUploadThrottleSeconds = metrics.NewCounter("walship_upload_throttle_seconds_total",
+		"Time uploads spent waiting for the upload rate limit.")
+	metricReadThrottleSeconds = metrics.NewCounter("walship_read_throttle_seconds_total",
+		"Time WAL reads spent waiting for the read rate limit.")
+	metricDerivedDropped = metrics.NewCounter("walship_derived_posts_dropped_total",
+		"Posts of decoded consensus data dropped because too many were waiting to be sent.")
+
+	lifecycle atomic.Value // string
+)
+
+func init() {
+	lifecycle.Store(StateStopped)
+	for _, c := range []metrics.Collector{
+		metricFramesRead, metricBatchesSent, metricBytesCompressed,
+		metricBytesUncompressed, metricSendDuration, metricSendRetries, metricFramesSkipped,
+		metricFramesSampledOut, metricWALGaps, metricUploadThrottled, metricUploadThrottleSeconds,
+		metricReadThrottleSeconds, metricDerivedDropped,
+	} {
+		metrics.Register(c)
+	}
+	metrics.Register(metrics.CollectorFunc(collectLifecycle))
+	metrics.Register(metrics.CollectorFunc(collectStats))
+	// The same registry backs /debug/vars for tooling that reads expvar
+	// rather than Prometheus.
+	metrics.PublishExpvar("walship", metrics.DefaultRegistry)
+}
+
+func setLifecycle(state string) { lifecycle.Store(state) }
+
+func collectLifecycle() []metrics.Sample {
+	cur := lifecycle.Load().(string)
+	out := make([]metrics.Sample, len(lifecycleStates))
+	for i, s := range lifecycleStates {
+		v := 0.0
+		if s == cur {
+			v = 1
+		}
+		out[i] = metrics.Sample{Name: "walship_state", Help: "Agent lifecycle state; 1 for the current one.",
+			Type: metrics.GaugeType, Labels: map[string]string{"state": s}, Value: v}
+	}
+	return out
+}
+
+// collectStats exposes the samples also pushed via remote-write and StatsD.
+// The scraper attaches target labels, so node identity is left off.
+func collectStats() []metrics.Sample {
+	samples := statsSamples(Config{}, CurrentStats(), time.Now())
+	out := make([]metrics.Sample, len(samples))
+	for i, s := range samples {
+		typ := metrics.GaugeType
+		if strings.HasSuffix(s.Name, "_total") {
+			typ = metrics.CounterType
+		}
+		out[i] = metrics.Sample{Name: s.Name, Type: typ, Value: s.Value}
+	}
+	return out
+}
+
+// registrySamples returns everything in metrics.DefaultRegistry, labelled
+// with the node's identity, for the push sinks. Histogram buckets are only
+// included if buckets is set.
+func registrySamples(cfg Config, now time.Time, buckets bool) []promSample {
+	return promSamples(cfg, metrics.Flatten(metrics.DefaultRegistry.Gather(), buckets), now)
+}
+
+// promSamples labels single-valued samples with the node identity. A label
+// the sample sets itself is kept.
+func promSamples(cfg Config, samples []metrics.Sample, now time.Time) []promSample {
+	node := nodeLabels(cfg)
+	out := make([]promSample, len(samples))
+	for i, s := range samples {
+		labels := make(map[string]string, len(node)+len(s.Labels))
+		for k, v := range node {
+			labels[k] = v
+		}
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		out[i] = promSample{Name: s.Name, Labels: labels, Value: s.Value, Time: now}
+	}
+	return out
+}
+
+// nodeLabels returns the labels identifying the node on pushed samples.
+// Under Anonymize the node ID is already hashed and the instance label,
+// the hostname, is left out.
+func nodeLabels(cfg Config) map[string]string {
+	labels := map[string]string{
+		"chain_id": cfg.ChainID,
+		"node_id":  cfg.NodeID,
+	}
+	if !cfg.Anonymize {
+		labels["instance"] = hostname()
+	}
+	return labels
+}
+
+// varsHandler serves the walship expvar in expvar's format. expvar.Handler
+// would also serve cmdline, which holds the flags and with them the auth
+// key and anonymize salt.
+func varsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n%q: %s\n}\n", "walship", expvar.Get("walship").String())
+}
+
+// observeSent records frames accepted by the service as one batch.
+func observeSent(frames []batchFrame) {
+	var compressed, uncompressed int
+	for _, fr := range frames {
+		compressed += len(fr.Compressed)
+		uncompressed += gzipISize(fr.Compressed)
+	}
+	metricBatchesSent.Inc()
+	metricBytesCompressed.Add(float64(compressed))
+	metricBytesUncompressed.Add(float64(uncompressed))
+}
+
+// gzipISize returns the uncompressed size recorded in the trailer of a
+// single-member gzip frame, or 0 if b is too short to hold one.
+func gzipISize(b []byte) int {
+	if len(b) < 18 {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b[len(b)-4:]))
+}
+
+// serveMetrics serves metrics.DefaultRegistry at /metrics, and as the
+// walship expvar at /debug/vars, on addr until ctx is done. CurrentStats is
+// served at /stats for Grafana's JSON datasources.
+func serveMetrics(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+	mux.HandleFunc("/debug/vars", varsHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("metrics listener")
+		}
+	}()
+	logger.Info().Str("addr", ln.Addr().String()).Msg("serving metrics")
+	return nil
+}
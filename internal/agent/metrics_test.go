@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+)
+
+func TestGzipISize(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want int
+	}{
+		{name: "frame", b: gzipFrame(t, "hello", "world"), want: len("hello\nworld\n")},
+		{name: "too short", b: []byte("AAAA"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gzipISize(tt.b); got != tt.want {
+				t.Errorf("gzipISize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_ServesMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	framesBefore, bytesBefore := metricFramesRead.Value(), metricBytesCompressed.Value()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, PollInterval: time.Millisecond, MetricsAddr: addr}
+	go func() { done <- Run(ctx, cfg) }()
+
+	var body string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if metricBytesCompressed.Value()-bytesBefore == 8 {
+			if resp, err := http.Get("http://" + addr + "/metrics"); err == nil {
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				body = string(b)
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := metricFramesRead.Value() - framesBefore; got != 2 {
+		t.Errorf("frames read = %v, want 2", got)
+	}
+	for _, want := range []string{
+		"# TYPE walship_frames_read_total counter",
+		"# TYPE walship_send_duration_seconds histogram",
+		`walship_state{state="running"} 1`,
+		"walship_spool_batches 0",
+		"walship_ready 1",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if lifecycle.Load() != StateStopped {
+		t.Errorf("lifecycle after Run = %v, want stopped", lifecycle.Load())
+	}
+}
+
+func TestServeMetrics_DebugVars(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := serveMetrics(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+	metricFramesRead.Add(1)
+
+	resp, err := http.Get("http://" + addr + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var all map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all["cmdline"]; ok || len(all) != 1 {
+		t.Fatalf("/debug/vars serves %d vars, want only walship", len(all))
+	}
+	var vars struct {
+		Walship map[string]any `json:"walship"`
+	}
+	if err := json.Unmarshal(all["walship"], &vars.Walship); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := vars.Walship["walship_frames_read_total"].(float64); got < 1 {
+		t.Errorf("walship_frames_read_total = %v, want >= 1", vars.Walship["walship_frames_read_total"])
+	}
+	if _, ok := vars.Walship["walship_state"].(map[string]any)["state=stopped"]; !ok {
+		t.Errorf("walship_state = %v, want a state=stopped series", vars.Walship["walship_state"])
+	}
+}
+
+func TestRegistrySamples(t *testing.T) {
+	cfg := Config{ChainID: "test-chain", NodeID: "test-node"}
+	metricSendDuration.Observe(0.1)
+	byName := map[string]promSample{}
+	for _, s := range registrySamples(cfg, time.Now(), false) {
+		byName[s.Name] = s
+	}
+	for _, name := range []string{"walship_frames_read_total", "walship_send_duration_seconds_count", "walship_lag_frames"} {
+		s, ok := byName[name]
+		if !ok {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if s.Labels["chain_id"] != "test-chain" || s.Labels["node_id"] != "test-node" {
+			t.Errorf("%s labels = %v, want the node identity", name, s.Labels)
+		}
+	}
+	if _, ok := byName["walship_send_duration_seconds_bucket"]; ok {
+		t.Error("buckets included without being asked for")
+	}
+	if s := byName["walship_state"]; s.Labels["state"] == "" {
+		t.Errorf("walship_state lost its state label: %v", s.Labels)
+	}
+}
+
+func TestRegistrySamples_AnonymizeWithholdsInstance(t *testing.T) {
+	for _, anonymize := range []bool{false, true} {
+		cfg := Config{ChainID: "test-chain", NodeID: "anon-1", Anonymize: anonymize}
+		for _, s := range append(registrySamples(cfg, time.Now(), false), statsSamples(cfg, Stats{}, time.Now())...) {
+			if _, ok := s.Labels["instance"]; ok == anonymize {
+				t.Fatalf("anonymize=%v: %s labels = %v", anonymize, s.Name, s.Labels)
+			}
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	agentStats.mu.Lock()
+	old := agentStats.s.FrameTypes
+	agentStats.s.FrameTypes = map[consensus.MessageType]uint64{"Vote": 2, "BlockPart": 3}
+	agentStats.mu.Unlock()
+	defer func() {
+		agentStats.mu.Lock()
+		agentStats.s.FrameTypes = old
+		agentStats.mu.Unlock()
+	}()
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET /stats = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var doc struct {
+		Time       time.Time `json:"time"`
+		LagFrames  *int64    `json:"lag_frames"`
+		FrameTypes []struct {
+			Type   string `json:"type"`
+			Frames uint64 `json:"frames"`
+		} `json:"frame_types"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Time.IsZero() || doc.LagFrames == nil {
+		t.Errorf("stats missing time or lag_frames: %s", rec.Body)
+	}
+	var types []string
+	for _, ft := range doc.FrameTypes {
+		types = append(types, ft.Type)
+	}
+	if !sort.StringsAreSorted(types) || len(types) < 2 {
+		t.Errorf("frame_types = %+v, want sorted rows", doc.FrameTypes)
+	}
+
+	rec = httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /stats = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// nodeConfigs expands cfg.NodeHomes into one validated Config per node. Each
+// node reads its chain and node IDs from its own home, ships the default WAL
+// dir under it and keeps its state in cfg.StateDir/<home base name>, or in
+// its WAL dir if cfg.StateDir is empty. ArchiveDir is split the same way.
+func nodeConfigs(cfg Config) ([]Config, error) {
+	homes, err := expandNodeHomes(cfg.NodeHomes)
+	if err != nil {
+		return nil, err
+	}
+	if len(homes) == 0 {
+		return nil, fmt.Errorf("node-homes %s matched no directories", strings.Join(cfg.NodeHomes, ","))
+	}
+
+	owner := map[string]string{} // state dir -> node home
+	out := make([]Config, 0, len(homes))
+	for _, home := range homes {
+		n := cfg
+		n.NodeHomes = nil
+		n.NodeHome = home
+		if cfg.StateDir != "" {
+			n.StateDir = filepath.Join(cfg.StateDir, filepath.Base(home))
+		}
+		if cfg.ArchiveDir != "" {
+			n.ArchiveDir = filepath.Join(cfg.ArchiveDir, filepath.Base(home))
+		}
+		if err := LoadNodeInfo(&n); err != nil {
+			return nil, fmt.Errorf("node %s: %w", home, err)
+		}
+		if err := n.Validate(); err != nil {
+			return nil, fmt.Errorf("node %s: %w", home, err)
+		}
+		if prev, ok := owner[n.StateDir]; ok {
+			return nil, fmt.Errorf("nodes %s and %s would share state dir %s", prev, home, n.StateDir)
+		}
+		owner[n.StateDir] = home
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// expandNodeHomes resolves glob patterns to the directories they match, in
+// order and without duplicates. Plain paths are kept even if missing, so
+// the error names them when their node info cannot be read.
+func expandNodeHomes(patterns []string) ([]string, error) {
+	var homes []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		p = filepath.Clean(p)
+		if !seen[p] {
+			seen[p] = true
+			homes = append(homes, p)
+		}
+	}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			add(pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("node-homes %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+				add(m)
+			}
+		}
+	}
+	return homes, nil
+}
+
+// runNodes runs one pipeline per node until ctx is done or, with Once, all
+// are drained. A node whose pipeline fails is logged and stays stopped while
+// the others keep shipping.
+func runNodes(ctx context.Context, nodes []Config) error {
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runPipeline(ctx, n)
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			logger.Error().Err(err).Str("node_home", n.NodeHome).Msg("node pipeline stopped")
+			recordEvent(EventError, fmt.Sprintf("node %s stopped: %v", n.NodeHome, err))
+			errs[i] = fmt.Errorf("node %s: %w", n.NodeHome, err)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeNodeHome creates a node home with genesis.json for chainID and a fresh
+// node key, and returns the node ID.
+func writeNodeHome(t *testing.T, home, chainID string) string {
+	t.Helper()
+	configDir := filepath.Join(home, "config")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	genesis, _ := json.Marshal(genesisDoc{ChainID: chainID})
+	if err := os.WriteFile(filepath.Join(configDir, "genesis.json"), genesis, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	var key struct {
+		PrivKey struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"priv_key"`
+	}
+	key.PrivKey.Type = "tendermint/PrivKeyEd25519"
+	key.PrivKey.Value = base64.StdEncoding.EncodeToString(priv)
+	b, _ := json.Marshal(key)
+	if err := os.WriteFile(filepath.Join(configDir, "node_key.json"), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:20])
+}
+
+func TestNodeConfigs(t *testing.T) {
+	root := t.TempDir()
+	idA := writeNodeHome(t, filepath.Join(root, "val-a"), "chain-a")
+	idB := writeNodeHome(t, filepath.Join(root, "val-b"), "chain-b")
+	if err := os.WriteFile(filepath.Join(root, "val-notes"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	other := t.TempDir()
+	writeNodeHome(t, filepath.Join(other, "val-a"), "chain-c")
+	base := DefaultConfig()
+	base.NodeHomes = []string{filepath.Join(root, "val-*")}
+
+	type node struct{ home, chain, id, stateDir string }
+	homeA, homeB := filepath.Join(root, "val-a"), filepath.Join(root, "val-b")
+	walA := filepath.Join(homeA, "data", "log.wal", "node-"+idA)
+	walB := filepath.Join(homeB, "data", "log.wal", "node-"+idB)
+	tests := []struct {
+		name     string
+		homes    []string
+		stateDir string
+		want     []node
+		wantErr  string
+	}{
+		{name: "glob with shared state dir", homes: base.NodeHomes, stateDir: "/var/lib/walship",
+			want: []node{
+				{homeA, "chain-a", idA, "/var/lib/walship/val-a"},
+				{homeB, "chain-b", idB, "/var/lib/walship/val-b"},
+			}},
+		{name: "state in WAL dirs", homes: []string{homeB, homeA, homeB + "/"},
+			want: []node{
+				{homeB, "chain-b", idB, walB},
+				{homeA, "chain-a", idA, walA},
+			}},
+		{name: "no match", homes: []string{filepath.Join(root, "nope-*")}, wantErr: "matched no directories"},
+		{name: "missing home", homes: []string{filepath.Join(root, "nope")}, wantErr: "read chain id"},
+		{name: "state dir collision", homes: []string{homeA, filepath.Join(other, "val-a")}, stateDir: "/var/lib/walship",
+			wantErr: "would share state dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.NodeHomes, cfg.StateDir = tt.homes, tt.stateDir
+			got, err := nodeConfigs(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d nodes, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				g := got[i]
+				if g.NodeHome != w.home || g.ChainID != w.chain || g.NodeID != w.id || g.StateDir != w.stateDir || len(g.NodeHomes) != 0 {
+					t.Errorf("node %d = home %s chain %s id %s state %s, want %+v", i, g.NodeHome, g.ChainID, g.NodeID, g.StateDir, w)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate_NodeHomesExclusive(t *testing.T) {
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.NodeHome = "/home/val" },
+		func(c *Config) { c.WALDir = "/wal" },
+		func(c *Config) { c.NodeID = "abc" },
+		func(c *Config) { c.StatsDAddr = "127.0.0.1:8125" },
+	} {
+		cfg := DefaultConfig()
+		cfg.NodeHomes = []string{"/home/*"}
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "node-homes cannot be combined") {
+			t.Errorf("Validate = %v, want a node-homes conflict", err)
+		}
+	}
+	cfg := DefaultConfig()
+	cfg.NodeHomes = []string{"/home/*"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+}
+
+func TestRun_MultiNode(t *testing.T) {
+	var mu sync.Mutex
+	nodesByChain := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			mu.Lock()
+			nodesByChain[r.Header.Get("X-Cosmos-Analyzer-Chain-Id")] = r.Header.Get("X-Cosmos-Analyzer-Node-Id")
+			mu.Unlock()
+		}
+	}))
+	defer ts.Close()
+
+	root, stateDir := t.TempDir(), t.TempDir()
+	ids := map[string]string{}
+	for i, chain := range []string{"chain-a", "chain-b"} {
+		home := filepath.Join(root, fmt.Sprintf("node%d", i))
+		id := writeNodeHome(t, home, chain)
+		ids[chain] = id
+		walDir := filepath.Join(home, "data", "log.wal", "node-"+id)
+		if err := os.MkdirAll(walDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// Distinct frame bytes, so the shared duplicate cache keeps both.
+		if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte(chain), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+			{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(chain))},
+		})
+	}
+
+	cfg := DefaultConfig()
+	cfg.NodeHomes = []string{filepath.Join(root, "node*")}
+	cfg.ServiceURL, cfg.StateDir = ts.URL, stateDir
+	cfg.PollInterval, cfg.Once, cfg.ShipConfig, cfg.Preflight = time.Millisecond, true, false, PreflightOff
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Run(ctx, cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(nodesByChain) != 2 || nodesByChain["chain-a"] != ids["chain-a"] || nodesByChain["chain-b"] != ids["chain-b"] {
+		t.Errorf("uploads tagged %v, want %v", nodesByChain, ids)
+	}
+	for _, name := range []string{"node0", "node1"} {
+		st, err := loadState(filepath.Join(stateDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.LastFrame != 1 {
+			t.Errorf("%s state = %+v, want frame 1 committed", name, st)
+		}
+	}
+	if ps := runningPipelines(); len(ps) != 0 {
+		t.Errorf("%d pipelines still registered after Run", len(ps))
+	}
+}
+
+func TestCurrentStats_NodeHealthPerNode(t *testing.T) {
+	a, b := &pipeline{stateDir: t.TempDir()}, &pipeline{stateDir: t.TempDir()}
+	registerPipeline(a)
+	defer unregisterPipeline(a)
+	registerPipeline(b)
+	defer unregisterPipeline(b)
+
+	// One node's watch recovering or writer being alive must not hide the
+	// other's trouble.
+	setConfigWatchHealth(a.stateDir, fmt.Errorf("inotify limit"))
+	setConfigWatchHealth(b.stateDir, nil)
+	recordWALWriter(a.stateDir, WALWriterStalled)
+	recordWALWriter(b.stateDir, WALWriterAlive)
+	s := CurrentStats()
+	if !s.ConfigWatchDegraded || s.ConfigWatchError != "inotify limit" {
+		t.Errorf("config watch = %v %q, want degraded", s.ConfigWatchDegraded, s.ConfigWatchError)
+	}
+	if s.WALWriter != WALWriterStalled {
+		t.Errorf("WAL writer = %q, want %q", s.WALWriter, WALWriterStalled)
+	}
+
+	setConfigWatchHealth(a.stateDir, nil)
+	if s := CurrentStats(); s.ConfigWatchDegraded {
+		t.Error("config watch still degraded after recovery")
+	}
+}
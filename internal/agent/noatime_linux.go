@@ -0,0 +1,7 @@
+//go:build linux
+
+package agent
+
+import "syscall"
+
+const oNoatime = syscall.O_NOATIME
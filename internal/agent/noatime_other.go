@@ -0,0 +1,5 @@
+//go:build !linux
+
+package agent
+
+const oNoatime = 0
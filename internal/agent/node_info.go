@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 )
 
@@ -50,7 +49,7 @@ func LoadNodeInfo(cfg *Config) error {
 
 func readChainID(nodeHome string) (string, error) {
 	path := rootify(filepath.Join(DefaultConfigDir, DefaultGenesisJSONName), nodeHome)
-	b, err := os.ReadFile(path)
+	b, err := readFileReadOnly(path)
 	if err != nil {
 		return "", err
 	}
@@ -62,8 +61,12 @@ func readChainID(nodeHome string) (string, error) {
 }
 
 func readNodeID(nodeHome string) (string, error) {
-	path := rootify(filepath.Join(DefaultConfigDir, DefaultNodeKeyName), nodeHome)
-	b, err := os.ReadFile(path)
+	return readNodeIDFile(rootify(filepath.Join(DefaultConfigDir, DefaultNodeKeyName), nodeHome))
+}
+
+// readNodeIDFile derives the node ID from the node key at path.
+func readNodeIDFile(path string) (string, error) {
+	b, err := readFileReadOnly(path)
 	if err != nil {
 		return "", err
 	}
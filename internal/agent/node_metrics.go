@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/bft-labs/walship/pkg/batch"
+	"github.com/bft-labs/walship/pkg/metrics"
+)
+
+const nodeMetricsEndpoint = "/v1/ingest/metrics"
+
+// maxNodeMetricsBytes bounds a scraped exposition; a node with thousands of
+// peers or a misconfigured endpoint must not exhaust the agent's memory.
+const maxNodeMetricsBytes = 32 << 20
+
+// nodeMetricsSnapshot is one scrape of the node's metrics endpoint, gzipped.
+type nodeMetricsSnapshot struct {
+	body        []byte
+	contentType string
+	scrapedAt   time.Time
+}
+
+// nodeSamples holds the samples of the node's latest scrape until the
+// remote-write scraper takes them, so each scrape is pushed once.
+type nodeSamples struct {
+	mu      sync.Mutex
+	samples []promSample
+}
+
+func (n *nodeSamples) set(samples []promSample) {
+	n.mu.Lock()
+	n.samples = samples
+	n.mu.Unlock()
+}
+
+func (n *nodeSamples) take() []promSample {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	samples := n.samples
+	n.samples = nil
+	return samples
+}
+
+// nodeMetricsScraper pulls the node's own Prometheus metrics (mempool size,
+// peers, consensus timings, ...) and ships them beside the WAL, so the
+// service can correlate the two. With latest set, each scrape is also
+// parsed there for remote-write.
+type nodeMetricsScraper struct {
+	cfg        Config
+	httpClient *http.Client
+	latest     *nodeSamples
+}
+
+func newNodeMetricsScraper(cfg Config, httpClient *http.Client, latest *nodeSamples) nodeMetricsScraper {
+	return nodeMetricsScraper{cfg: cfg, httpClient: httpClient, latest: latest}
+}
+
+func (nodeMetricsScraper) Name() string              { return "node-metrics" }
+func (s nodeMetricsScraper) Interval() time.Duration { return s.cfg.NodeMetricsInterval }
+
+// Collect scrapes the endpoint. A node without instrumentation yields no
+// snapshot rather than an error, as it is re-checked on every scrape.
+func (s nodeMetricsScraper) Collect(ctx context.Context) (any, error) {
+	u := s.cfg.NodeMetricsURL
+	if u == "" {
+		var ok bool
+		if u, ok = nodeMetricsURL(s.cfg.NodeHome); !ok {
+			logger.Debug().Msg("node metrics: prometheus instrumentation disabled in config.toml")
+			return nil, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	// The node is local; the service's client would apply its TLS pins and
+	// upload throttling.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scrape %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape %s: %s", u, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxNodeMetricsBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("scrape %s: %w", u, err)
+	}
+	if len(b) > maxNodeMetricsBytes {
+		return nil, fmt.Errorf("scrape %s: more than %d bytes", u, maxNodeMetricsBytes)
+	}
+	now := time.Now().UTC()
+	if s.latest != nil {
+		// Remote-write is best effort; the raw exposition still ships.
+		if parsed, err := metrics.ParseText(bytes.NewReader(b)); err != nil {
+			logger.Debug().Err(err).Msg("node metrics: not pushed to remote-write")
+		} else {
+			s.latest.set(promSamples(s.cfg, parsed, now))
+		}
+	}
+	gz, err := batch.Gzip(b, s.cfg.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = "text/plain; version=0.0.4"
+	}
+	return &nodeMetricsSnapshot{body: gz, contentType: ct, scrapedAt: now}, nil
+}
+
+func (s nodeMetricsScraper) Ship(ctx context.Context, data any) error {
+	snap, _ := data.(*nodeMetricsSnapshot)
+	if snap == nil {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ingestURL(s.cfg, nodeMetricsEndpoint), bytes.NewReader(snap.body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, s.cfg)
+	req.Header.Set("Content-Type", snap.contentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Cosmos-Analyzer-Scraped-At", snap.scrapedAt.Format(time.RFC3339Nano))
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
+
+// nodeMetricsURL returns the metrics URL of the node at nodeHome from the
+// instrumentation section of its config.toml, and false if Prometheus
+// metrics are disabled there. A listen address without a host, or with an
+// unspecified one, is scraped on loopback.
+func nodeMetricsURL(nodeHome string) (string, bool) {
+	var cfg struct {
+		Instrumentation struct {
+			Prometheus           bool   `toml:"prometheus"`
+			PrometheusListenAddr string `toml:"prometheus_listen_addr"`
+		} `toml:"instrumentation"`
+	}
+	b, err := readFileReadOnly(rootify(filepath.Join(DefaultConfigDir, "config.toml"), nodeHome))
+	if err != nil || toml.Unmarshal(b, &cfg) != nil || !cfg.Instrumentation.Prometheus {
+		return "", false
+	}
+	addr := strings.TrimPrefix(cfg.Instrumentation.PrometheusListenAddr, "tcp://")
+	if addr == "" {
+		addr = ":26660"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/metrics", true
+}
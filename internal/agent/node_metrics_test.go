@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeMetricsURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+		wantOK bool
+	}{
+		{"disabled", "[instrumentation]\nprometheus = false\nprometheus_listen_addr = \":26660\"\n", "", false},
+		{"no section", "moniker = \"n\"\n", "", false},
+		{"default port", "[instrumentation]\nprometheus = true\nprometheus_listen_addr = \":26660\"\n", "http://127.0.0.1:26660/metrics", true},
+		{"unspecified host", "[instrumentation]\nprometheus = true\nprometheus_listen_addr = \"0.0.0.0:9090\"\n", "http://127.0.0.1:9090/metrics", true},
+		{"specific host", "[instrumentation]\nprometheus = true\nprometheus_listen_addr = \"10.0.0.5:26660\"\n", "http://10.0.0.5:26660/metrics", true},
+		{"no addr", "[instrumentation]\nprometheus = true\n", "http://127.0.0.1:26660/metrics", true},
+		{"bad addr", "[instrumentation]\nprometheus = true\nprometheus_listen_addr = \"26660\"\n", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(home, "config"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(home, "config", "config.toml"), []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := nodeMetricsURL(home)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("nodeMetricsURL = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNodeMetricsScraper(t *testing.T) {
+	const exposition = "# TYPE cometbft_mempool_size gauge\ncometbft_mempool_size{chain_id=\"c\"} 42\n"
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		io.WriteString(w, exposition)
+	}))
+	defer node.Close()
+
+	var got, contentType, scrapedAt string
+	svc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != nodeMetricsEndpoint || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("got %s with encoding %q", r.URL.Path, r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(zr)
+		got, contentType, scrapedAt = string(b), r.Header.Get("Content-Type"), r.Header.Get("X-Cosmos-Analyzer-Scraped-At")
+	}))
+	defer svc.Close()
+
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.TrimPrefix(node.URL, "http://")
+	config := "[instrumentation]\nprometheus = true\nprometheus_listen_addr = \"" + addr + "\"\n"
+	if err := os.WriteFile(filepath.Join(home, "config", "config.toml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := &nodeSamples{}
+	s := newNodeMetricsScraper(Config{NodeHome: home, ServiceURL: svc.URL, NodeMetricsInterval: time.Minute, ChainID: "c", NodeID: "n"}, svc.Client(), latest)
+	if err := scrapeOnce(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if got != exposition || !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("service got %q as %q, want the node's exposition", got, contentType)
+	}
+	// The same scrape is kept for remote-write, labelled with the node.
+	if ps := latest.take(); len(ps) != 1 || ps[0].Name != "cometbft_mempool_size" || ps[0].Value != 42 || ps[0].Labels["node_id"] != "n" {
+		t.Errorf("remote-write samples = %+v", ps)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, scrapedAt); err != nil {
+		t.Errorf("scraped-at header %q: %v", scrapedAt, err)
+	}
+
+	// Without instrumentation there is nothing to ship.
+	got = ""
+	if err := os.WriteFile(filepath.Join(home, "config", "config.toml"), []byte("[instrumentation]\nprometheus = false\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scrapeOnce(context.Background(), s); err != nil || got != "" {
+		t.Errorf("disabled instrumentation: err %v, service got %q", err, got)
+	}
+}
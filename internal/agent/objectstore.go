@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func newObjectStoreSender(cfg Config) (*sender.ObjectStoreSender, error) {
+	region := cfg.ObjectStoreRegion
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := cfg.ObjectStoreEndpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return sender.NewObjectStoreSender(sender.ObjectStoreOptions{
+		Endpoint:             endpoint,
+		Bucket:               cfg.ObjectStoreBucket,
+		Region:               region,
+		Prefix:               cfg.ObjectStorePrefix,
+		AccessKeyID:          os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey:      os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:         os.Getenv("AWS_SESSION_TOKEN"),
+		ServerSideEncryption: cfg.ObjectStoreSSE,
+		KMSKeyID:             cfg.ObjectStoreKMSKeyID,
+		MaxAttempts:          max(cfg.SendMaxAttempts, 1),
+		RetryBackoff:         cfg.SendRetryBase,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			logger.Warn().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("retrying object store put")
+			metricSendRetries.Inc()
+			if cfg.OnRetry != nil {
+				cfg.OnRetry(RetryEvent{Attempt: attempt, Delay: delay, Err: err})
+			}
+		},
+		HTTPClient: &http.Client{Timeout: cfg.HTTPTimeout, Transport: newTransport(cfg)},
+	})
+}
+
+// sendObjectStore writes frames as one object keyed by chain and node ID
+// and returns how many were stored, all or none, within HTTPTimeout. A
+// failure is reported to OnSendError like an HTTP upload's.
+func sendObjectStore(cfg Config, s *sender.ObjectStoreSender, frames []batchFrame, curIdxBase string) (int, error) {
+	ctx, cancel := context.WithTimeout(activePipeline(cfg).sendContext(), cfg.HTTPTimeout)
+	defer cancel()
+	wf := make([]wal.Frame, len(frames))
+	for i, fr := range frames {
+		wf[i] = wal.Frame{Meta: fr.Meta, Compressed: fr.Compressed}
+	}
+	key, err := s.Send(ctx, cfg.ChainID, cfg.NodeID, curIdxBase, wf)
+	if err != nil {
+		if cfg.OnSendError != nil {
+			ev := SendErrorEvent{Segment: curIdxBase, Frames: len(frames), Bytes: framesBytes(frames), Attempts: 1, Err: err}
+			var oe *sender.ObjectStoreError
+			if errors.As(err, &oe) {
+				ev.Attempts, ev.StatusCode, ev.Retryable = oe.Attempts, oe.StatusCode, oe.Retriable()
+			}
+			cfg.OnSendError(ev)
+		}
+		return 0, err
+	}
+	logger.Debug().Str("key", key).Int("frames", len(frames)).Msg("wrote batch object")
+	return len(frames), nil
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun_WritesObjects(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AK")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SK")
+
+	var mu sync.Mutex
+	var puts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			return // config and agent info still go to the service
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Amz-Server-Side-Encryption") != "aws:kms" || r.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "key-1" {
+			t.Errorf("sse headers = %v", r.Header)
+		}
+		puts = append(puts, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	frame := gzipFrame(t, `{"time":"2024-06-01T00:00:00Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{}}}`)
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), frame, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: uint64(len(frame)), FirstTS: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).UnixNano()},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, ChainID: "chain-1", NodeID: "node-1", WALDir: walDir, StateDir: t.TempDir(), Once: true,
+		PollInterval: time.Millisecond, HTTPTimeout: 5 * time.Second, SendMaxAttempts: 1,
+		ObjectStoreBucket: "raw", ObjectStoreEndpoint: ts.URL, ObjectStorePrefix: "wal", ObjectStoreSSE: "aws:kms", ObjectStoreKMSKeyID: "key-1"}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := "/raw/wal/chain-1/node-1/2024-06-01/seg-000001-1-1.gz"; len(puts) != 1 || puts[0] != want {
+		t.Fatalf("puts = %v, want [%s]", puts, want)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil || st.LastFrame != 1 || !strings.HasSuffix(st.IdxPath, "seg-000001.wal.idx") {
+		t.Errorf("state = %+v, %v; want frame 1 committed", st, err)
+	}
+}
+
+func TestRun_DrainsSpoolToObjectStore(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AK")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SK")
+
+	var up atomic.Bool
+	var puts, posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && !up.Load():
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		case r.Method == http.MethodPut:
+			puts.Add(1)
+		case r.URL.Path == walFramesEndpoint:
+			posts.Add(1)
+		}
+	}))
+	defer ts.Close()
+
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAA"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: 4},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, ChainID: "chain-1", NodeID: "node-1", WALDir: walDir, StateDir: t.TempDir(), Once: true,
+		PollInterval: time.Millisecond, HTTPTimeout: 5 * time.Second, SendMaxAttempts: 1, SpoolMaxBytes: 1 << 20,
+		ObjectStoreBucket: "raw", ObjectStoreEndpoint: ts.URL}
+	for _, u := range []bool{false, true} {
+		up.Store(u)
+		if err := Run(context.Background(), cfg); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+
+	spooled, _ := filepath.Glob(filepath.Join(cfg.StateDir, "spool", "*.batch"))
+	if puts.Load() != 1 || posts.Load() != 0 || len(spooled) != 0 {
+		t.Errorf("puts = %d, frame posts = %d, spooled = %v; want the spooled batch written to the bucket", puts.Load(), posts.Load(), spooled)
+	}
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/sender"
+)
+
+// pipeline holds the resources of one node's running WAL pipeline. A
+// multi-node agent runs one per node home, each with its own state dir.
+type pipeline struct {
+	stateDir string
+	spool    *sender.Spool             // nil unless SpoolMaxBytes is set
+	grpc     *sender.GRPCSender        // nil unless GRPCTarget is set
+	canary   *sender.GRPCSender        // nil unless CanaryGRPCTarget is set
+	kafka    *sender.KafkaSender       // nil unless KafkaBrokers is set
+	objstore *sender.ObjectStoreSender // nil unless ObjectStoreBucket is set
+	ledger   *ledger                   // nil unless Ledger is set
+	shipped  *frameCache               // frames the service accepted
+	scrapers *scraperManager
+	hooks    []PluginHook
+	ready    atomic.Bool
+	trace    batchTrace             // only used by the pipeline's goroutine
+	steps    *consensus.StepTracker // nil unless HeightSummaries is set
+	reloads  chan Config            // configs queued by Reload
+	// flushes carries Flush requests; wake cuts an idle poll short for one.
+	flushes chan chan flushResult
+	wake    chan struct{}
+
+	// tombstonesAt is when flushTombstones may next try, after a failure.
+	tombstonesAt   time.Time
+	tombstonesBack *backoff
+	// gapsAt is when flushGaps may next try, after a failure.
+	gapsAt   time.Time
+	gapsBack *backoff
+
+	// stream is the open frame stream and failedStream one whose frames
+	// must be streamed again; only used by the pipeline's goroutine.
+	stream       *frameStream
+	failedStream *frameStream
+
+	derived *derivedQueue // posts of data derived from delivered frames
+
+	// sendCtx ends retries and uploads still running once Run is done with
+	// them. It outlives Run's ctx so the shutdown flush can still send.
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+}
+
+// pipelines are the running pipelines by state dir.
+var pipelines struct {
+	mu sync.Mutex
+	m  map[string]*pipeline
+}
+
+func registerPipeline(p *pipeline) {
+	pipelines.mu.Lock()
+	defer pipelines.mu.Unlock()
+	if pipelines.m == nil {
+		pipelines.m = map[string]*pipeline{}
+	}
+	pipelines.m[p.stateDir] = p
+}
+
+// unregisterPipeline removes p, unless another pipeline replaced it, and
+// drops its share of the agent statistics.
+func unregisterPipeline(p *pipeline) {
+	pipelines.mu.Lock()
+	if pipelines.m[p.stateDir] == p {
+		delete(pipelines.m, p.stateDir)
+	}
+	pipelines.mu.Unlock()
+	forgetNodeStats(p.stateDir)
+	setReady(pipelinesReady())
+}
+
+// activePipeline returns the running pipeline writing to cfg.StateDir, or nil.
+func activePipeline(cfg Config) *pipeline {
+	pipelines.mu.Lock()
+	defer pipelines.mu.Unlock()
+	return pipelines.m[cfg.StateDir]
+}
+
+// runningPipelines returns the running pipelines ordered by state dir.
+func runningPipelines() []*pipeline {
+	pipelines.mu.Lock()
+	out := make([]*pipeline, 0, len(pipelines.m))
+	for _, p := range pipelines.m {
+		out = append(out, p)
+	}
+	pipelines.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].stateDir < out[j].stateDir })
+	return out
+}
+
+// setReady records whether p is tailing its WAL; the agent is ready once
+// every pipeline is.
+func (p *pipeline) setReady(ready bool) {
+	p.ready.Store(ready)
+	setReady(pipelinesReady())
+}
+
+func pipelinesReady() bool {
+	ps := runningPipelines()
+	for _, p := range ps {
+		if !p.ready.Load() {
+			return false
+		}
+	}
+	return len(ps) > 0
+}
+
+// Spool, gRPC and ledger accessors tolerate a nil pipeline, i.e. code
+// running outside Run.
+
+func (p *pipeline) activeSpool() *sender.Spool {
+	if p == nil {
+		return nil
+	}
+	return p.spool
+}
+
+func (p *pipeline) activeGRPC() *sender.GRPCSender {
+	if p == nil {
+		return nil
+	}
+	return p.grpc
+}
+
+func (p *pipeline) activeCanaryGRPC() *sender.GRPCSender {
+	if p == nil {
+		return nil
+	}
+	return p.canary
+}
+
+func (p *pipeline) activeKafka() *sender.KafkaSender {
+	if p == nil {
+		return nil
+	}
+	return p.kafka
+}
+
+func (p *pipeline) activeObjectStore() *sender.ObjectStoreSender {
+	if p == nil {
+		return nil
+	}
+	return p.objstore
+}
+
+func (p *pipeline) activeSteps() *consensus.StepTracker {
+	if p == nil {
+		return nil
+	}
+	return p.steps
+}
+
+// sendContext returns the context uploads and their retries run under.
+func (p *pipeline) sendContext() context.Context {
+	if p == nil || p.sendCtx == nil {
+		return context.Background()
+	}
+	return p.sendCtx
+}
+
+// shippedFrames returns the pipeline's cache of accepted frames, or nil.
+func (p *pipeline) shippedFrames() *frameCache {
+	if p == nil {
+		return nil
+	}
+	return p.shipped
+}
+
+func (p *pipeline) activeLedger() *ledger {
+	if p == nil {
+		return nil
+	}
+	return p.ledger
+}
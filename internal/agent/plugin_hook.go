@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"reflect"
+	"time"
+)
+
+// SendInfo describes a batch about to be sent to the service or, in
+// AfterSend, the part of it the service accepted.
+type SendInfo struct {
+	Segment string
+	Frames  int
+	Bytes   int
+}
+
+// PluginHook lets code embedding the agent observe and veto batch uploads.
+// Hooks are called from the send loop, one at a time and in the order they
+// appear in Config.PluginHooks, so they should return quickly.
+type PluginHook interface {
+	// BeforeSend is called before each send attempt. If any hook returns
+	// false the attempt is skipped and the batch is offered again on the
+	// next pass; every hook is still called.
+	BeforeSend(SendInfo) bool
+	// AfterSend is called after each attempt that BeforeSend allowed, once
+	// the accepted frames are committed. err is the send error, if any.
+	AfterSend(info SendInfo, err error)
+}
+
+// sendInfo summarizes frames from segment.
+func sendInfo(segment string, frames []batchFrame) SendInfo {
+	info := SendInfo{Segment: segment, Frames: len(frames)}
+	for _, fr := range frames {
+		info.Bytes += len(fr.Compressed)
+	}
+	return info
+}
+
+// runBeforeSend calls BeforeSend on every hook and reports whether all of
+// them allow the send.
+func runBeforeSend(hooks []PluginHook, info SendInfo) bool {
+	ok := true
+	for _, h := range hooks {
+		start := time.Now()
+		allow := h.BeforeSend(info)
+		logger.Debug().Str("hook", hookName(h)).Bool("allow", allow).Dur("took", time.Since(start)).Msg("before send hook")
+		ok = ok && allow
+	}
+	return ok
+}
+
+// runAfterSend calls AfterSend on every hook.
+func runAfterSend(hooks []PluginHook, info SendInfo, err error) {
+	for _, h := range hooks {
+		start := time.Now()
+		h.AfterSend(info, err)
+		logger.Debug().Str("hook", hookName(h)).Dur("took", time.Since(start)).Msg("after send hook")
+	}
+}
+
+func hookName(h PluginHook) string {
+	return reflect.TypeOf(h).String()
+}
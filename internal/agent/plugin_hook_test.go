@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type recordingHook struct {
+	name  string
+	allow bool
+	calls *[]string
+	after []SendInfo
+	errs  []error
+}
+
+func (h *recordingHook) BeforeSend(info SendInfo) bool {
+	*h.calls = append(*h.calls, fmt.Sprintf("%s.before(%d)", h.name, info.Frames))
+	return h.allow
+}
+
+func (h *recordingHook) AfterSend(info SendInfo, err error) {
+	*h.calls = append(*h.calls, h.name+".after")
+	h.after = append(h.after, info)
+	h.errs = append(h.errs, err)
+}
+
+func TestTrySend_PluginHooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		allow      [2]bool
+		wantCalls  []string
+		wantPosts  int
+		wantFrames int
+		wantErr    bool
+	}{
+		{name: "allowed", status: http.StatusOK, allow: [2]bool{true, true},
+			wantCalls: []string{"a.before(2)", "b.before(2)", "a.after", "b.after"}, wantPosts: 1, wantFrames: 2},
+		{name: "vetoed", status: http.StatusOK, allow: [2]bool{false, true},
+			wantCalls: []string{"a.before(2)", "b.before(2)"}},
+		{name: "failed", status: http.StatusInternalServerError, allow: [2]bool{true, true},
+			wantCalls: []string{"a.before(2)", "b.before(2)", "a.after", "b.after"}, wantPosts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var posts int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				posts++
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			var calls []string
+			a := &recordingHook{name: "a", allow: tt.allow[0], calls: &calls}
+			b := &recordingHook{name: "b", allow: tt.allow[1], calls: &calls}
+			cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), PluginHooks: []PluginHook{a, b}}
+			batch := []batchFrame{
+				{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("abc"), IdxLineLen: 10},
+				{Meta: FrameMeta{File: "f", Frame: 2}, Compressed: []byte("de"), IdxLineLen: 10},
+			}
+			batchBytes := 5
+			st := state{}
+			back := newBackoff(time.Millisecond, time.Millisecond)
+
+			trySend(cfg, http.DefaultClient, &batch, &batchBytes, &st, "000.idx", nil, time.Now(), back)
+
+			if fmt.Sprint(calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if posts != tt.wantPosts {
+				t.Errorf("posts = %d, want %d", posts, tt.wantPosts)
+			}
+			if len(a.after) == 0 {
+				return
+			}
+			if got := a.after[0]; got.Frames != tt.wantFrames || got.Segment != "000.idx" || (tt.wantFrames == 2 && got.Bytes != 5) {
+				t.Errorf("AfterSend info = %+v", got)
+			}
+			var se *statusError
+			if gotErr := a.errs[0] != nil; gotErr != tt.wantErr || (tt.wantErr && !errors.As(a.errs[0], &se)) {
+				t.Errorf("AfterSend err = %v, wantErr %v", a.errs[0], tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrPluginKeyNotFound is returned by PluginState.Get for a key that was
+// never put, or was deleted.
+var ErrPluginKeyNotFound = errors.New("plugin state: key not found")
+
+// pluginStateSuffix marks value files, so they never clash with temporary
+// files of an interrupted Put.
+const pluginStateSuffix = ".v"
+
+// PluginConfig is handed to plugin hooks that implement PluginIniter when
+// a node's pipeline starts.
+type PluginConfig struct {
+	ChainID string
+	NodeID  string
+	// State keeps the plugin's own data, such as its progress, across
+	// restarts.
+	State *PluginState
+}
+
+// PluginIniter is implemented by plugin hooks that need per-node setup.
+// Init is called before the pipeline's first send; a hook shared by
+// several nodes is initialized once for each. An error stops the pipeline.
+type PluginIniter interface {
+	Init(PluginConfig) error
+}
+
+// PluginNamer is implemented by plugin hooks that choose the namespace of
+// their state; "." and ".." are refused. Other hooks are namespaced by their
+// Go type, so renaming the type loses its state.
+type PluginNamer interface {
+	PluginName() string
+}
+
+// PluginState is a plugin's key-value store, kept as one file per key under
+// plugins/<namespace> in the node's state dir. Put replaces a value
+// atomically: after a crash Get returns either the old value or the new
+// one. It is safe for concurrent use.
+type PluginState struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// newPluginState returns the store of the plugin namespace in stateDir.
+// The directory is created on the first Put. Escaping keeps namespaces to
+// one path element, but "." and ".." would still name plugins/ or the state
+// dir itself, so they are refused.
+func newPluginState(stateDir, namespace string) (*PluginState, error) {
+	if namespace == "" || namespace == "." || namespace == ".." {
+		return nil, fmt.Errorf("plugin state: invalid namespace %q", namespace)
+	}
+	return &PluginState{dir: filepath.Join(stateDir, "plugins", url.PathEscape(namespace))}, nil
+}
+
+// pluginNamespace returns the namespace of h's state.
+func pluginNamespace(h PluginHook) string {
+	if n, ok := h.(PluginNamer); ok && n.PluginName() != "" {
+		return n.PluginName()
+	}
+	return strings.TrimLeft(hookName(h), "*")
+}
+
+func (s *PluginState) path(key string) (string, error) {
+	if key == "" {
+		return "", errors.New("plugin state: empty key")
+	}
+	return filepath.Join(s.dir, url.PathEscape(key)+pluginStateSuffix), nil
+}
+
+// Get returns the value of key, or ErrPluginKeyNotFound.
+func (s *PluginState) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrPluginKeyNotFound
+	}
+	return b, err
+}
+
+// Put sets key to value. The value is synced to disk before it replaces
+// the old one.
+func (s *PluginState) Put(key string, value []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, "put-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("plugin state: put %q: %w", key, err)
+	}
+	if d, err := os.Open(s.dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Delete removes key; deleting a missing key is not an error.
+func (s *PluginState) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// initPlugins calls Init on the hooks that implement PluginIniter, each
+// with its own store in stateDir.
+func initPlugins(cfg Config, hooks []PluginHook) error {
+	for _, h := range hooks {
+		i, ok := h.(PluginIniter)
+		if !ok {
+			continue
+		}
+		state, err := newPluginState(cfg.StateDir, pluginNamespace(h))
+		if err != nil {
+			return fmt.Errorf("init plugin %s: %w", hookName(h), err)
+		}
+		pc := PluginConfig{ChainID: cfg.ChainID, NodeID: cfg.NodeID, State: state}
+		if err := i.Init(pc); err != nil {
+			return fmt.Errorf("init plugin %s: %w", hookName(h), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type statefulHook struct {
+	name string
+	cfg  PluginConfig
+	err  error
+}
+
+func (h *statefulHook) BeforeSend(SendInfo) bool   { return true }
+func (h *statefulHook) AfterSend(SendInfo, error)  {}
+func (h *statefulHook) PluginName() string         { return h.name }
+func (h *statefulHook) Init(pc PluginConfig) error { h.cfg = pc; return h.err }
+
+func TestPluginState(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newPluginState(dir, "cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get("progress"); !errors.Is(err, ErrPluginKeyNotFound) {
+		t.Fatalf("Get on an empty store: %v, want ErrPluginKeyNotFound", err)
+	}
+	for _, v := range []string{"segment-1", "segment-2"} {
+		if err := s.Put("progress", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put("a/../b", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("", []byte("x")); err == nil {
+		t.Error("Put with an empty key succeeded")
+	}
+
+	// A fresh store over the same dir sees the values, and nothing leaks
+	// outside the namespace.
+	s, _ = newPluginState(dir, "cleanup")
+	if got, err := s.Get("progress"); err != nil || string(got) != "segment-2" {
+		t.Errorf("Get = %q, %v; want segment-2", got, err)
+	}
+	if got, err := s.Get("a/../b"); err != nil || string(got) != "x" {
+		t.Errorf("Get(a/../b) = %q, %v; want x", got, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "plugins", "cleanup"))
+	if err != nil || len(entries) != 2 {
+		t.Errorf("namespace dir holds %d entries (%v), want 2 values and no temp files", len(entries), err)
+	}
+	other, _ := newPluginState(dir, "sampling")
+	if _, err := other.Get("progress"); !errors.Is(err, ErrPluginKeyNotFound) {
+		t.Errorf("another namespace sees the key: %v", err)
+	}
+
+	if err := s.Delete("progress"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("progress"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if _, err := s.Get("progress"); !errors.Is(err, ErrPluginKeyNotFound) {
+		t.Errorf("Get after Delete: %v", err)
+	}
+}
+
+func TestInitPlugins(t *testing.T) {
+	dir := t.TempDir()
+	named := &statefulHook{name: "cleanup"}
+	unnamed := &statefulHook{}
+	cfg := Config{ChainID: "chain", NodeID: "node", StateDir: dir}
+	if err := initPlugins(cfg, []PluginHook{named, unnamed, &recordingHook{}}); err != nil {
+		t.Fatal(err)
+	}
+	if named.cfg.ChainID != "chain" || named.cfg.NodeID != "node" || named.cfg.State == nil {
+		t.Fatalf("Init got %+v", named.cfg)
+	}
+	if err := named.cfg.State.Put("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plugins", "cleanup", "k.v")); err != nil {
+		t.Errorf("named plugin state: %v", err)
+	}
+	if got := unnamed.cfg.State.dir; got != filepath.Join(dir, "plugins", "agent.statefulHook") {
+		t.Errorf("unnamed plugin state dir = %s, want namespaced by type", got)
+	}
+
+	for _, name := range []string{".", ".."} {
+		if err := initPlugins(cfg, []PluginHook{&statefulHook{name: name}}); err == nil {
+			t.Errorf("namespace %q accepted", name)
+		}
+	}
+
+	failing := &statefulHook{name: "x", err: errors.New("boom")}
+	if err := initPlugins(cfg, []PluginHook{failing}); err == nil {
+		t.Error("a failing Init did not stop the pipeline")
+	}
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Preflight policies decide what happens when a startup check fails.
+const (
+	// PreflightOff skips the checks.
+	PreflightOff = "off"
+	// PreflightWarn logs failed checks and starts anyway, reporting them in
+	// Stats.PreflightFindings.
+	PreflightWarn = "warn"
+	// PreflightStrict refuses to start when any check fails.
+	PreflightStrict = "strict"
+)
+
+const pingEndpoint = "/v1/ingest/ping"
+
+// errAuthRejected is returned by pingService when the service refuses the
+// auth key.
+var errAuthRejected = errors.New("auth key rejected")
+
+var (
+	// preflightTimeout bounds the network checks.
+	preflightTimeout = 10 * time.Second
+	// preflightMaxClockSkew is how far the local clock may drift from the
+	// service's before it is reported.
+	preflightMaxClockSkew = time.Minute
+)
+
+// preflightFinding is a failed startup check.
+type preflightFinding struct {
+	Check string
+	Err   error
+}
+
+func (f preflightFinding) String() string { return f.Check + ": " + f.Err.Error() }
+
+// runPreflight checks that the WAL is readable, the state dir writable, the
+// service resolvable and accepting our key, and the clock roughly right. It
+// returns the checks that failed.
+func runPreflight(ctx context.Context, cfg Config, httpClient *http.Client) []preflightFinding {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	var findings []preflightFinding
+	check := func(name string, err error) {
+		if err != nil {
+			findings = append(findings, preflightFinding{Check: name, Err: err})
+		}
+	}
+
+	check("wal_readable", checkWALReadable(cfg))
+	check("state_writable", checkStateWritable(cfg.StateDir))
+	if err := checkDNS(ctx, cfg); err != nil {
+		// Without a resolvable host the auth and clock checks cannot run.
+		check("dns", err)
+		return findings
+	}
+	serverTime, err := pingService(ctx, cfg, httpClient)
+	check("auth", err)
+	check("clock", checkClock(time.Now(), serverTime))
+	return findings
+}
+
+// checkWALReadable opens the segment index the agent will resume from.
+func checkWALReadable(cfg Config) error {
+	idxPath := ""
+	if st, err := loadState(cfg.StateDir); err == nil && st.IdxPath != "" {
+		idxPath = st.IdxPath
+	} else {
+		p, err := wal.OldestIndex(cfg.WALDir)
+		if err != nil {
+			return err
+		}
+		idxPath = p
+	}
+	f, err := openReadOnly(idxPath)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checkStateWritable creates and removes a scratch file in dir.
+func checkStateWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// checkDNS resolves the service's host, unless a proxy does.
+func checkDNS(ctx context.Context, cfg Config) error {
+	u, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		return err
+	}
+	if proxy, _ := serviceProxy(cfg); proxy != nil {
+		// The proxy resolves the service's name.
+		return nil
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	return nil
+}
+
+// pingService checks that the service accepts the auth key and returns the
+// time reported in its Date header (zero if absent). Services without a
+// ping endpoint answer 404, which still proves reachability.
+func pingService(ctx context.Context, cfg Config, httpClient *http.Client) (time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ingestURL(cfg, pingEndpoint), nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	serverTime, _ := http.ParseTime(resp.Header.Get("Date"))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return serverTime, errAuthRejected
+	case resp.StatusCode/100 == 2 || resp.StatusCode == http.StatusNotFound:
+		return serverTime, nil
+	default:
+		return serverTime, newStatusError(resp)
+	}
+}
+
+// checkClock compares the local clock with the service's, when known.
+func checkClock(local, server time.Time) error {
+	if local.Year() < 2020 {
+		return fmt.Errorf("local clock reads %s", local.UTC().Format(time.RFC3339))
+	}
+	if server.IsZero() {
+		return nil
+	}
+	skew := local.Sub(server)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > preflightMaxClockSkew {
+		return fmt.Errorf("local clock differs from the service by %s", skew.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckClock(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		local   time.Time
+		server  time.Time
+		wantErr bool
+	}{
+		{"in sync", now, now.Add(2 * time.Second), false},
+		{"no server time", now, time.Time{}, false},
+		{"ahead", now, now.Add(-2 * preflightMaxClockSkew), true},
+		{"behind", now, now.Add(2 * preflightMaxClockSkew), true},
+		{"unset clock", time.Unix(0, 0), time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkClock(tt.local, tt.server); (err != nil) != tt.wantErr {
+				t.Errorf("checkClock = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPingService(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusUnauthorized, true},
+		{http.StatusForbidden, true},
+		{http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != pingEndpoint || r.Header.Get("Authorization") != "Bearer k" {
+				t.Errorf("unexpected ping %s", r.URL.Path)
+			}
+			w.WriteHeader(tt.status)
+		}))
+		serverTime, err := pingService(context.Background(), Config{ServiceURL: ts.URL, AuthKey: "k"}, ts.Client())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("status %d: err = %v, wantErr %v", tt.status, err, tt.wantErr)
+		}
+		if serverTime.IsZero() {
+			t.Errorf("status %d: server time not read from Date header", tt.status)
+		}
+		ts.Close()
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	walDir := t.TempDir()
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{{File: "seg-000001.wal.gz", Frame: 1}})
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir()}
+
+	if findings := runPreflight(context.Background(), cfg, ts.Client()); len(findings) != 0 {
+		t.Errorf("healthy setup: findings = %v", findings)
+	}
+
+	cfg.WALDir = t.TempDir()
+	cfg.StateDir = filepath.Join(t.TempDir(), "missing")
+	var checks []string
+	for _, f := range runPreflight(context.Background(), cfg, ts.Client()) {
+		checks = append(checks, f.Check)
+	}
+	if strings.Join(checks, ",") != "wal_readable,state_writable" {
+		t.Errorf("failed checks = %v, want wal_readable and state_writable", checks)
+	}
+}
+
+func TestRun_PreflightPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	walDir := t.TempDir()
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), nil)
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond}
+
+	cfg.Preflight = PreflightStrict
+	err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "auth: auth key rejected") {
+		t.Fatalf("strict: Run error = %v, want auth failure", err)
+	}
+
+	cfg.Preflight = PreflightWarn
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("warn: Run error = %v", err)
+	}
+	if f := CurrentStats().PreflightFindings; len(f) != 1 || !strings.HasPrefix(f[0], "auth:") {
+		t.Errorf("PreflightFindings = %v, want the auth failure", f)
+	}
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxySchemes are the schemes ProxyURL may use. socks5 and socks5h both
+// leave name resolution to the proxy.
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// proxyDefaultPorts are the ports of proxies whose URL names none.
+var proxyDefaultPorts = map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}
+
+func (c *Config) validateProxy() error {
+	if c.ProxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return fmt.Errorf("proxy url: %w", err)
+	}
+	if !slices.Contains(proxySchemes, u.Scheme) || u.Hostname() == "" {
+		return fmt.Errorf("proxy url must be http://, https://, socks5:// or socks5h:// followed by a host")
+	}
+	return nil
+}
+
+// proxyFunc returns the proxy of each HTTP request: ProxyURL if set, else
+// HTTPS_PROXY or HTTP_PROXY by the request's scheme. Either way, hosts
+// listed in NO_PROXY, and loopback addresses, are reached directly.
+func proxyFunc(cfg Config) func(*http.Request) (*url.URL, error) {
+	pc := httpproxy.FromEnvironment()
+	if cfg.ProxyURL != "" {
+		pc.HTTPProxy, pc.HTTPSProxy = cfg.ProxyURL, cfg.ProxyURL
+	}
+	f := pc.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) { return f(req.URL) }
+}
+
+// newTransport returns a transport configured like http.DefaultTransport
+// that goes through proxyFunc's proxies.
+func newTransport(cfg Config) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = proxyFunc(cfg)
+	return tr
+}
+
+// serviceProxy returns the proxy requests to ServiceURL go through, or nil.
+func serviceProxy(cfg Config) (*url.URL, error) {
+	u, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		return nil, err
+	}
+	return proxyFunc(cfg)(&http.Request{URL: u})
+}
+
+// checkProxy connects to proxy and, for a SOCKS5 proxy, checks that it
+// accepts a client authenticating as the URL says.
+func checkProxy(ctx context.Context, proxy *url.URL) error {
+	addr := proxy.Host
+	if proxy.Port() == "" {
+		addr = net.JoinHostPort(proxy.Hostname(), proxyDefaultPorts[proxy.Scheme])
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to proxy %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if proxy.Scheme != "socks5" && proxy.Scheme != "socks5h" {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// The greeting offers no authentication, or username and password if
+	// the URL carries them (RFC 1928, section 3).
+	method := byte(0x00)
+	if proxy.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return fmt.Errorf("socks5 greeting to %s: %w", addr, err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("socks5 greeting to %s: %w", addr, err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("%s is not a socks5 proxy", addr)
+	}
+	if reply[1] != method {
+		return fmt.Errorf("socks5 proxy %s refused the authentication method offered", addr)
+	}
+	return nil
+}
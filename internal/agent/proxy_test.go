@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// newTestProxy starts a forward HTTP proxy that answers every request it
+// is asked to forward itself, after passing it to seen, and returns its URL.
+func newTestProxy(t *testing.T, seen func(*http.Request)) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if seen != nil {
+			seen(r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// clearProxyEnv unsets the proxy environment variables for the test.
+func clearProxyEnv(t *testing.T) {
+	for _, k := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy"} {
+		t.Setenv(k, "")
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		proxyURL string
+		service  string
+		want     string
+	}{
+		{name: "none", service: "https://api.apphash.io"},
+		{name: "HTTPS_PROXY", env: map[string]string{"HTTPS_PROXY": "http://10.0.0.1:3128"}, service: "https://api.apphash.io", want: "http://10.0.0.1:3128"},
+		{name: "HTTPS_PROXY does not cover http", env: map[string]string{"HTTPS_PROXY": "http://10.0.0.1:3128"}, service: "http://api.apphash.io"},
+		{name: "proxy url over the environment", env: map[string]string{"HTTPS_PROXY": "http://10.0.0.1:3128"}, proxyURL: "socks5h://10.0.0.2:1080",
+			service: "https://api.apphash.io", want: "socks5h://10.0.0.2:1080"},
+		{name: "proxy url covers http", proxyURL: "socks5://10.0.0.2:1080", service: "http://ingest.internal", want: "socks5://10.0.0.2:1080"},
+		{name: "NO_PROXY exempts", env: map[string]string{"NO_PROXY": ".internal"}, proxyURL: "http://10.0.0.1:3128", service: "https://ingest.internal"},
+		{name: "loopback is direct", proxyURL: "http://10.0.0.1:3128", service: "http://127.0.0.1:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			u, err := serviceProxy(Config{ServiceURL: tt.service, ProxyURL: tt.proxyURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if u != nil {
+				got = u.String()
+			}
+			if got != tt.want {
+				t.Errorf("proxy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSOCKS5 listens for SOCKS5 greetings and answers with method.
+func fakeSOCKS5(t *testing.T, method byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			greeting := make([]byte, 3)
+			if _, err := io.ReadFull(conn, greeting); err == nil {
+				conn.Write([]byte{0x05, method})
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestCheckProxy(t *testing.T) {
+	httpProxy := fakeSOCKS5(t, 0x00) // any listener will do for http
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		proxy   string
+		wantErr bool
+	}{
+		{"http proxy up", "http://" + httpProxy, false},
+		{"http proxy down", "http://" + closedAddr, true},
+		{"socks5 without auth", "socks5://" + fakeSOCKS5(t, 0x00), false},
+		{"socks5 with auth", "socks5h://user:pass@" + fakeSOCKS5(t, 0x02), false},
+		{"socks5 refuses auth", "socks5://" + fakeSOCKS5(t, 0xff), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkProxy(context.Background(), u); (err != nil) != tt.wantErr {
+				t.Errorf("checkProxy = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_ProxyURL(t *testing.T) {
+	clearProxyEnv(t)
+	var got *http.Request
+	proxy := newTestProxy(t, func(r *http.Request) { got = r })
+	client := newHTTPClient(Config{ProxyURL: proxy})
+	resp, err := client.Get("http://walship-service.test/v1/ingest/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got == nil || got.URL.String() != "http://walship-service.test/v1/ingest/ping" {
+		t.Errorf("proxy got %v, want the absolute service URL", got)
+	}
+}
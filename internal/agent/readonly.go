@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Node-owned files (WAL segments, indexes, node config) are only ever opened
+// through openReadOnly, so walship cannot modify them even by mistake.
+
+// useNoatime makes openReadOnly ask the kernel not to update access times,
+// saving a metadata write per open on filesystems mounted with atime.
+var useNoatime atomic.Bool
+
+// walPinsOpenFiles is wal.PinsOpenFiles; tests replace it.
+var walPinsOpenFiles = wal.PinsOpenFiles
+
+// openReadOnly opens path with O_RDONLY, adding O_NOATIME where supported
+// and enabled. O_NOATIME is refused for files the process does not own, in
+// which case the plain open is used, which lets the node rename and remove
+// the file while it is open, on Windows too.
+func openReadOnly(path string) (*os.File, error) {
+	if useNoatime.Load() && oNoatime != 0 {
+		f, err := os.OpenFile(path, os.O_RDONLY|oNoatime, 0)
+		if err == nil || !errors.Is(err, os.ErrPermission) {
+			return f, err
+		}
+	}
+	return wal.OpenFile(path)
+}
+
+// readFileReadOnly is os.ReadFile via openReadOnly.
+func readFileReadOnly(path string) ([]byte, error) {
+	f, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// checkStateDir refuses a state directory inside one of the WAL day
+// directories, whose contents are rotated by the node and trimmed by
+// walCleanupLoop.
+func checkStateDir(walDir, stateDir string) error {
+	wal, err := filepath.Abs(walDir)
+	if err != nil {
+		return err
+	}
+	st, err := filepath.Abs(stateDir)
+	if err != nil {
+		return err
+	}
+	if w, err := filepath.EvalSymlinks(wal); err == nil {
+		wal = w
+	}
+	if s, err := filepath.EvalSymlinks(st); err == nil {
+		st = s
+	}
+	rel, err := filepath.Rel(wal, st)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	first := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+	if isDayDir(first) {
+		return fmt.Errorf("state dir %s is inside WAL segment directory %s", stateDir, filepath.Join(walDir, first))
+	}
+	return nil
+}
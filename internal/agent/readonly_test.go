@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenReadOnly_RejectsWrites(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seg-000001.wal.idx")
+	if err := os.WriteFile(path, []byte("{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, noatime := range []bool{false, true} {
+		useNoatime.Store(noatime)
+
+		idx, _, err := openIdx(path)
+		if err != nil {
+			t.Fatalf("noatime=%v: openIdx: %v", noatime, err)
+		}
+		if _, err := idx.Write([]byte("x")); err == nil {
+			t.Errorf("noatime=%v: index handle accepted a write", noatime)
+		}
+		idx.Close()
+
+		gz, err := openGz(path)
+		if err != nil {
+			t.Fatalf("noatime=%v: openGz: %v", noatime, err)
+		}
+		if _, err := gz.Write([]byte("x")); err == nil {
+			t.Errorf("noatime=%v: gz handle accepted a write", noatime)
+		}
+		gz.Close()
+
+		b, err := readFileReadOnly(path)
+		if err != nil || string(b) != "{}\n" {
+			t.Errorf("noatime=%v: readFileReadOnly = %q, %v", noatime, b, err)
+		}
+	}
+	useNoatime.Store(false)
+}
+
+func TestCheckStateDir(t *testing.T) {
+	wal := t.TempDir()
+	tests := []struct {
+		name     string
+		stateDir string
+		wantErr  bool
+	}{
+		{"same as wal dir", wal, false},
+		{"outside wal dir", t.TempDir(), false},
+		{"non-day subdir", filepath.Join(wal, ".walship"), false},
+		{"day dir", filepath.Join(wal, "2024-01-01"), true},
+		{"inside day dir", filepath.Join(wal, "2024-01-01", "state"), true},
+		{"relative into day dir", filepath.Join(wal, "x", "..", "2024-01-02"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStateDir(wal, tt.stateDir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkStateDir(%s) = %v, wantErr %v", tt.stateDir, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRun_RefusesStateDirInsideSegmentDir(t *testing.T) {
+	wal := t.TempDir()
+	stateDir := filepath.Join(wal, "2024-01-01")
+	cfg := Config{ServiceURL: "http://localhost:9999", WALDir: wal, StateDir: stateDir, Once: true}
+
+	err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "inside WAL segment directory") {
+		t.Fatalf("Run error = %v, want state dir refusal", err)
+	}
+	if _, err := os.Stat(stateDir); !os.IsNotExist(err) {
+		t.Error("refused state dir should not be created")
+	}
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recentEventsCap is how many events the ring buffer keeps.
+var recentEventsCap = 100
+
+// Recent event types.
+const (
+	EventSend  = "send"
+	EventError = "error"
+	EventState = "state"
+	// EventDegraded marks the agent falling short of an SLO, such as
+	// AckLatencySLO.
+	EventDegraded = "degraded"
+)
+
+// RecentEvent is one entry in the agent's recent history.
+type RecentEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message"`
+}
+
+// eventRing keeps the last cap events. When dir is set, every change is also
+// written to events.json there so `walship status --events` can read the
+// history of a running agent.
+type eventRing struct {
+	mu     sync.Mutex
+	events []RecentEvent
+	next   int
+	dir    string
+}
+
+var recentEvents = &eventRing{}
+
+func eventsFile(dir string) string {
+	return filepath.Join(dir, "events.json")
+}
+
+// persistTo starts writing the buffer to dir; "" stops it.
+func (r *eventRing) persistTo(dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dir = dir
+}
+
+func (r *eventRing) Add(ev RecentEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) < recentEventsCap {
+		r.events = append(r.events, ev)
+	} else {
+		r.events[r.next] = ev
+		r.next = (r.next + 1) % len(r.events)
+	}
+	if r.dir != "" {
+		if err := writeJSONAtomic(r.dir, eventsFile(r.dir), r.snapshotLocked()); err != nil {
+			logger.Debug().Err(err).Msg("save recent events")
+		}
+	}
+}
+
+// Snapshot returns the buffered events, oldest first.
+func (r *eventRing) Snapshot() []RecentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+func (r *eventRing) snapshotLocked() []RecentEvent {
+	out := make([]RecentEvent, 0, len(r.events))
+	out = append(out, r.events[r.next:]...)
+	return append(out, r.events[:r.next]...)
+}
+
+func recordEvent(typ, msg string) {
+	recentEvents.Add(RecentEvent{Time: time.Now().UTC(), Type: typ, Message: msg})
+}
+
+// ReadEvents returns the recent events last persisted to cfg.StateDir by a
+// running agent, oldest first.
+func ReadEvents(cfg Config) ([]RecentEvent, error) {
+	var events []RecentEvent
+	if err := readJSON(eventsFile(cfg.StateDir), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEventRing_KeepsLatest(t *testing.T) {
+	oldCap := recentEventsCap
+	recentEventsCap = 3
+	defer func() { recentEventsCap = oldCap }()
+
+	r := &eventRing{}
+	if got := r.Snapshot(); len(got) != 0 {
+		t.Fatalf("empty ring snapshot = %v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		r.Add(RecentEvent{Type: EventSend, Message: fmt.Sprint(i)})
+	}
+	got := r.Snapshot()
+	if len(got) != 3 || got[0].Message != "3" || got[1].Message != "4" || got[2].Message != "5" {
+		t.Errorf("snapshot = %+v, want events 3..5 oldest first", got)
+	}
+}
+
+func TestEventRing_Persists(t *testing.T) {
+	dir := t.TempDir()
+	r := &eventRing{}
+	r.Add(RecentEvent{Type: EventState, Message: "before"}) // not persisted
+	r.persistTo(dir)
+	r.Add(RecentEvent{Time: time.Now(), Type: EventError, Message: "boom"})
+
+	events, err := ReadEvents(Config{StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Type != EventError || events[1].Message != "boom" {
+		t.Errorf("persisted events = %+v", events)
+	}
+}
+
+func TestSetReady_RecordsTransitions(t *testing.T) {
+	oldRing := recentEvents
+	recentEvents = &eventRing{}
+	defer func() { recentEvents = oldRing }()
+
+	setReady(false)
+	setReady(true)
+	setReady(true)
+	setReady(false)
+
+	got := CurrentStats().RecentEvents
+	if len(got) != 2 || got[0].Message != "WAL pipeline running" || got[1].Message != "WAL pipeline stopped" {
+		t.Errorf("events = %+v, want one running and one stopped", got)
+	}
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "***REDACTED***"
+
+// DefaultConfigRedact are the key patterns redacted from app.toml,
+// config.toml and client.toml before upload unless ConfigRedact is changed.
+var DefaultConfigRedact = []string{
+	"*password", "*passwd", "*secret", "*token", "*api_key", "*apikey",
+	"*auth_key", "*private_key", "mnemonic",
+}
+
+// redactKeys replaces the values of the given keys in TOML (`key = value`) and
+// JSON (`"key": value`) content. A key may contain * wildcards, e.g.
+// "*password" also matches rpc_password. Matching is line based and
+// case-insensitive, which is sufficient for the flat key/value layout of node
+// config files.
+func redactKeys(content string, keys []string) string {
+	if len(keys) == 0 {
+		return content
+	}
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		parts := strings.Split(k, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		q := strings.Join(parts, `[\w.-]*`)
+		tomlRe := regexp.MustCompile(`(?mi)^(\s*` + q + `\s*=\s*).*$`)
+		content = tomlRe.ReplaceAllString(content, `${1}"`+redactedValue+`"`)
+		jsonRe := regexp.MustCompile(`(?i)("` + q + `"\s*:\s*)("(?:[^"\\]|\\.)*"|[^,}\s]+)`)
+		content = jsonRe.ReplaceAllString(content, `${1}"`+redactedValue+`"`)
+	}
+	return content
+}
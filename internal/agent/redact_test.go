@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		keys    []string
+		want    string
+	}{
+		{
+			name:    "toml value",
+			content: "chain = \"a\"\nmnemonic = \"word word word\"\n",
+			keys:    []string{"mnemonic"},
+			want:    "chain = \"a\"\nmnemonic = \"***REDACTED***\"\n",
+		},
+		{
+			name:    "toml indented and case-insensitive",
+			content: "[keys]\n  Password=hunter2\n",
+			keys:    []string{"password"},
+			want:    "[keys]\n  Password=\"***REDACTED***\"\n",
+		},
+		{
+			name:    "json string and number",
+			content: `{"token": "abc\"def", "pin": 1234, "name": "x"}`,
+			keys:    []string{"token", "pin"},
+			want:    `{"token": "***REDACTED***", "pin": "***REDACTED***", "name": "x"}`,
+		},
+		{
+			name:    "no keys leaves content untouched",
+			content: "secret = 1\n",
+			want:    "secret = 1\n",
+		},
+		{
+			name:    "wildcard pattern",
+			content: "[custom]\nrpc_password = \"hunter2\"\npassword_file = \"/x\"\n",
+			keys:    []string{"*password"},
+			want:    "[custom]\nrpc_password = \"***REDACTED***\"\npassword_file = \"/x\"\n",
+		},
+		{
+			name:    "key is not a prefix match",
+			content: "password_file = \"/x\"\n",
+			keys:    []string{"password"},
+			want:    "password_file = \"/x\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactKeys(tt.content, tt.keys)
+			if got != tt.want {
+				t.Errorf("redactKeys() = %q, want %q", got, tt.want)
+			}
+			if len(tt.keys) > 0 && strings.Contains(got, "hunter2") {
+				t.Error("secret leaked")
+			}
+		})
+	}
+}
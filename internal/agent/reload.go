@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// reloadableFields are the Config fields a running pipeline takes from
+// Reload. Changing any other field needs a restart.
+var reloadableFields = map[string]bool{
+	"ServiceURL":           true,
+	"IngestPathPrefix":     true,
+	"AuthKey":              true,
+	"AuthKeys":             true,
+	"TLSPins":              true,
+	"ClientCertFile":       true,
+	"ClientKeyFile":        true,
+	"CAFile":               true,
+	"InsecureSkipVerify":   true,
+	"ProxyURL":             true,
+	"HTTPTimeout":          true,
+	"PollInterval":         true,
+	"MaxPollInterval":      true,
+	"SendInterval":         true,
+	"HardInterval":         true,
+	"CommitInterval":       true,
+	"SendMaxAttempts":      true,
+	"SendRetryBase":        true,
+	"SendRetryMax":         true,
+	"CPUThreshold":         true,
+	"NetThreshold":         true,
+	"NetProbe":             true,
+	"MaxBatchBytes":        true,
+	"MaxUploadBytesPerSec": true,
+	"MaxReadBytesPerSec":   true,
+	"LogLevel":             true,
+	"SampleEveryN":         true,
+	"SampleTypes":          true,
+	"SampleHeightModulo":   true,
+	"AckLatencySLO":        true,
+	"AckLatencyPercentile": true,
+	"CanaryPercent":        true,
+	"CanaryFrameEncoding":  true,
+	"StreamUploadBytes":    true,
+}
+
+// serviceFields are the reloadable fields the HTTP client and the scrapers
+// talking to the service are built from.
+var serviceFields = []string{"ServiceURL", "IngestPathPrefix", "AuthKey", "AuthKeys", "TLSPins", "ClientCertFile", "ClientKeyFile", "CAFile", "InsecureSkipVerify", "ProxyURL", "HTTPTimeout"}
+
+// reloadsService reports whether changed names any of serviceFields.
+func reloadsService(changed []string) bool {
+	for _, name := range changed {
+		if slices.Contains(serviceFields, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload hands cfg to the running agent. Each pipeline applies its
+// intervals, thresholds, retry settings and service URL and credentials
+// before its next WAL read, keeping its read position and pending batch.
+// Other changed settings are logged as needing a restart and otherwise
+// ignored. cfg is validated first; an invalid cfg changes nothing.
+func Reload(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	nodes := []Config{cfg}
+	if len(cfg.NodeHomes) > 0 {
+		var err error
+		if nodes, err = nodeConfigs(cfg); err != nil {
+			return err
+		}
+	}
+	if len(runningPipelines()) == 0 {
+		return fmt.Errorf("agent is not running")
+	}
+	if err := SetLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if p := activePipeline(n); p != nil {
+			p.reload(n)
+		}
+	}
+	return nil
+}
+
+// reload queues cfg for the pipeline's goroutine, replacing a reload it has
+// not picked up yet.
+func (p *pipeline) reload(cfg Config) {
+	for {
+		select {
+		case p.reloads <- cfg:
+			return
+		default:
+		}
+		select {
+		case <-p.reloads:
+		default:
+		}
+	}
+}
+
+// pendingReload returns the config queued by Reload, if any.
+func (p *pipeline) pendingReload() (Config, bool) {
+	select {
+	case cfg := <-p.reloads:
+		return cfg, true
+	default:
+		return Config{}, false
+	}
+}
+
+// applyReload copies next's reloadable fields onto cfg and base, the config
+// the pipeline was started with, and returns the names of the reloadable
+// fields that changed and of the other fields that differ from base.
+// Callbacks and plugin hooks are not compared.
+func applyReload(cfg, base *Config, next Config) (changed, restart []string) {
+	cv, bv, nv := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(base).Elem(), reflect.ValueOf(next)
+	t := nv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		if reflect.DeepEqual(bv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableFields[f.Name] {
+			restart = append(restart, f.Name)
+			continue
+		}
+		cv.Field(i).Set(nv.Field(i))
+		bv.Field(i).Set(nv.Field(i))
+		changed = append(changed, f.Name)
+	}
+	return changed, restart
+}
+
+// logReload reports an applied reload.
+func logReload(cfg Config, changed, restart []string) {
+	if len(restart) > 0 {
+		logger.Warn().Str("node_id", cfg.NodeID).Strs("fields", restart).Msg("config reload: changes need a restart")
+		recordEvent(EventState, "config reload ignored "+strings.Join(restart, ", ")+" (restart needed)")
+	}
+	if len(changed) > 0 {
+		logger.Info().Str("node_id", cfg.NodeID).Strs("fields", changed).Msg("config reloaded")
+		recordEvent(EventState, "config reloaded: "+strings.Join(changed, ", "))
+	}
+}
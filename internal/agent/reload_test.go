@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyReload(t *testing.T) {
+	hook := func(SendSuccessEvent) {}
+	base := Config{ServiceURL: "http://a", PollInterval: time.Second, StateDir: "/state", NodeID: "node"}
+	cfg := base
+	cfg.NodeID = "anonymized"
+
+	next := base
+	next.ServiceURL = "http://b"
+	next.PollInterval = 2 * time.Second
+	next.StateDir = "/other"
+	next.OnSendSuccess = hook
+
+	changed, restart := applyReload(&cfg, &base, next)
+	if fmt.Sprint(changed) != "[ServiceURL PollInterval]" {
+		t.Errorf("changed = %v", changed)
+	}
+	if fmt.Sprint(restart) != "[StateDir]" {
+		t.Errorf("restart = %v", restart)
+	}
+	if cfg.ServiceURL != "http://b" || cfg.PollInterval != 2*time.Second || cfg.StateDir != "/state" || cfg.NodeID != "anonymized" {
+		t.Errorf("cfg = %+v", cfg)
+	}
+	if cfg.OnSendSuccess != nil {
+		t.Error("callback was reloaded")
+	}
+
+	// Reapplying the same config changes nothing more.
+	if changed, _ := applyReload(&cfg, &base, next); len(changed) != 0 {
+		t.Errorf("second reload changed %v", changed)
+	}
+}
+
+func TestApplyReload_UnvalidatedBase(t *testing.T) {
+	// Run normalizes, but does not validate, the config it starts from;
+	// reloading the same config validated must then change nothing.
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.NodeHome, cfg.WALDir = "http://a/", "/home", "/wal"
+	cfg.AckLatencyPercentile = 0
+	cfg.Disable = []string{" Lag ", ""}
+	base := cfg
+	base.normalize()
+	next := cfg
+	if err := next.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	running := base
+	if changed, restart := applyReload(&running, &base, next); len(changed)+len(restart) != 0 {
+		t.Errorf("changed = %v, restart = %v", changed, restart)
+	}
+}
+
+func TestReload_NotRunning(t *testing.T) {
+	if err := Reload(Config{ServiceURL: "http://localhost", NodeHome: t.TempDir(), WALDir: t.TempDir(), StateDir: t.TempDir()}); err == nil {
+		t.Error("Reload without a running agent succeeded")
+	}
+}
+
+func TestRun_ReloadSwitchesService(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idxPath := filepath.Join(walDir, "seg-000001.wal.idx")
+	writeIdx(t, idxPath, []FrameMeta{{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4}})
+
+	server := func(got chan<- struct{}) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != walFramesEndpoint {
+				return
+			}
+			select {
+			case got <- struct{}{}:
+			default:
+			}
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	gotA, gotB := make(chan struct{}, 1), make(chan struct{}, 1)
+	a, b := server(gotA), server(gotB)
+
+	home := t.TempDir()
+	writeNodeHome(t, home, "chain-a")
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.NodeHome, cfg.WALDir, cfg.StateDir = a.URL, home, walDir, t.TempDir()
+	cfg.PollInterval, cfg.MaxPollInterval, cfg.SendInterval = time.Millisecond, time.Millisecond, time.Millisecond
+	cfg.CPUThreshold, cfg.NetThreshold = 1, 1 // never hold a send back
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	wait := func(ch <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no upload to %s", what)
+		}
+	}
+	wait(gotA, "the first service")
+
+	next := cfg
+	next.ServiceURL = b.URL
+	if err := Reload(next); err != nil {
+		t.Fatal(err)
+	}
+	// Give the pipeline a poll to pick the reload up before the next frame.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(activePipeline(cfg).reloads) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	f, err := os.OpenFile(idxPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(FrameMeta{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4})
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	wait(gotB, "the reloaded service")
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var remoteWriteInterval = 15 * time.Second
+
+// promSample is one metric value to be pushed via Prometheus remote-write.
+type promSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// remoteWriter pushes samples to a Prometheus remote-write (v1) endpoint.
+// Credentials may be given as URL userinfo.
+type remoteWriter struct {
+	url    string
+	client *http.Client
+}
+
+func newRemoteWriter(url string, client *http.Client) *remoteWriter {
+	return &remoteWriter{url: url, client: client}
+}
+
+// Push sends samples as a single snappy-compressed WriteRequest.
+func (w *remoteWriter) Push(ctx context.Context, samples []promSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(samples))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "walship")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, body: string(b), header: resp.Header}
+	}
+	return nil
+}
+
+// remoteWriteScraper periodically pushes the agent's metrics, and the
+// node's from its latest scrape when node is set.
+type remoteWriteScraper struct {
+	cfg  Config
+	w    *remoteWriter
+	node *nodeSamples
+}
+
+func (remoteWriteScraper) Name() string            { return "remote_write" }
+func (remoteWriteScraper) Interval() time.Duration { return remoteWriteInterval }
+
+func (s remoteWriteScraper) Collect(ctx context.Context) (any, error) {
+	return append(registrySamples(s.cfg, time.Now(), true), s.node.take()...), nil
+}
+
+func (s remoteWriteScraper) Ship(ctx context.Context, data any) error {
+	return s.w.Push(ctx, data.([]promSample))
+}
+
+// statsSamples converts a Stats snapshot into samples labelled with the node
+// identity.
+func statsSamples(cfg Config, s Stats, now time.Time) []promSample {
+	labels := nodeLabels(cfg)
+	ready := 0.0
+	if s.Ready {
+		ready = 1
+	}
+	return []promSample{
+		{Name: "walship_ready", Labels: labels, Value: ready, Time: now},
+		{Name: "walship_lag_frames", Labels: labels, Value: float64(s.LagFrames), Time: now},
+		{Name: "walship_lag_bytes", Labels: labels, Value: float64(s.LagBytes), Time: now},
+		{Name: "walship_duplicate_frames_total", Labels: labels, Value: float64(s.DuplicateFrames), Time: now},
+		{Name: "walship_shipped_frames_total", Labels: labels, Value: float64(s.ShippedFrames), Time: now},
+		{Name: "walship_shipped_bytes_total", Labels: labels, Value: float64(s.ShippedBytes), Time: now},
+		{Name: "walship_spool_batches", Labels: labels, Value: float64(s.SpooledBatches), Time: now},
+		{Name: "walship_spool_bytes", Labels: labels, Value: float64(s.SpooledBytes), Time: now},
+		{Name: "walship_spool_evicted_total", Labels: labels, Value: float64(s.SpoolEvicted), Time: now},
+		{Name: "walship_ack_latency_p50_seconds", Labels: labels, Value: s.AckLatency.P50, Time: now},
+		{Name: "walship_ack_latency_p95_seconds", Labels: labels, Value: s.AckLatency.P95, Time: now},
+		{Name: "walship_ack_latency_p99_seconds", Labels: labels, Value: s.AckLatency.P99, Time: now},
+	}
+}
+
+// encodeWriteRequest marshals samples as a prometheus.WriteRequest protobuf,
+// one TimeSeries per sample:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []promSample) []byte {
+	var out []byte
+	for _, s := range samples {
+		names := make([]string, 0, len(s.Labels)+1)
+		values := map[string]string{"__name__": s.Name}
+		names = append(names, "__name__")
+		for k, v := range s.Labels {
+			if k == "__name__" || v == "" {
+				continue
+			}
+			names = append(names, k)
+			values[k] = v
+		}
+		sort.Strings(names) // remote-write requires sorted label names
+
+		var ts []byte
+		for _, n := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, n)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, values[n])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.Time.UnixMilli()))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestEncodeWriteRequest(t *testing.T) {
+	got := encodeWriteRequest([]promSample{{Name: "m", Value: 1, Time: time.UnixMilli(5)}})
+	want, _ := hex.DecodeString(
+		"0a1c" + // timeseries, 28 bytes
+			"0a0d" + "0a085f5f6e616d655f5f" + "12016d" + // label __name__="m"
+			"120b" + "09000000000000f03f" + "1005") // sample value=1 timestamp=5
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoded = %x, want %x", got, want)
+	}
+}
+
+func TestEncodeWriteRequest_SortsLabelsAndDropsEmpty(t *testing.T) {
+	got := encodeWriteRequest([]promSample{{Name: "m", Labels: map[string]string{"z": "1", "a": "2", "e": ""}}})
+	ia, iname, iz := bytes.Index(got, []byte("\x01a")), bytes.Index(got, []byte("__name__")), bytes.Index(got, []byte("\x01z"))
+	if ia < 0 || iname < 0 || iz < 0 || !(iname < ia && ia < iz) {
+		t.Errorf("labels not sorted: __name__=%d a=%d z=%d", iname, ia, iz)
+	}
+	if bytes.Contains(got, []byte("\x01e")) {
+		t.Error("empty label should be dropped")
+	}
+}
+
+func TestRemoteWriter_Push(t *testing.T) {
+	var body []byte
+	var hdr http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	cfg := Config{ChainID: "test-chain", NodeID: "test-node"}
+	samples := statsSamples(cfg, Stats{Ready: true, LagFrames: 3}, time.Now())
+	err := newRemoteWriter(ts.URL, ts.Client()).Push(context.Background(), samples)
+	ts.Close()
+	if err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if hdr.Get("Content-Encoding") != "snappy" || hdr.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("headers = %v", hdr)
+	}
+	if hdr.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("remote write version = %q", hdr.Get("X-Prometheus-Remote-Write-Version"))
+	}
+	got, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatalf("snappy: %v", err)
+	}
+	if !bytes.Equal(got, encodeWriteRequest(samples)) {
+		t.Error("body does not match the encoded samples")
+	}
+}
+
+func TestRemoteWriter_PushError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	err := newRemoteWriter(ts.URL, ts.Client()).Push(context.Background(), []promSample{{Name: "m"}})
+	if statusCode(err) != http.StatusBadRequest {
+		t.Errorf("err = %v, want status 400", err)
+	}
+}
+
+func TestRemoteWriteScraper_IncludesNodeSamples(t *testing.T) {
+	cfg := Config{ChainID: "test-chain", NodeID: "test-node"}
+	node := &nodeSamples{}
+	node.set([]promSample{{Name: "cometbft_consensus_height", Value: 7}})
+	s := remoteWriteScraper{cfg: cfg, node: node}
+
+	count := func() int {
+		data, err := s.Collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, p := range data.([]promSample) {
+			if p.Name == "cometbft_consensus_height" {
+				n++
+			}
+		}
+		return n
+	}
+	if n := count(); n != 1 {
+		t.Errorf("first push has %d node samples, want 1", n)
+	}
+	if n := count(); n != 0 {
+		t.Errorf("second push has %d node samples, want each scrape pushed once", n)
+	}
+}
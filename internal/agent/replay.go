@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// replayBatchEvents caps the events posted per replay request.
+const replayBatchEvents = 1000
+
+// replayHeader marks consensus event posts made by Replay with the height
+// range replayed, so the service can tell them from live data; their
+// bodies also carry "replay": true.
+const replayHeader = "X-Cosmos-Analyzer-Replay"
+
+// ReplayQuery selects the consensus events Replay re-ships: those at heights
+// FromHeight through ToHeight, of the comma-separated Kinds (all if empty).
+type ReplayQuery struct {
+	FromHeight int64
+	ToHeight   int64
+	Kinds      string
+}
+
+// Replay decodes cfg's WAL from q.FromHeight and posts the matching
+// consensus events to the consensus endpoint, leaving the shipping position
+// untouched. It stops after the first frame past q.ToHeight, or at the end
+// of the WAL, and returns the number of events posted.
+func Replay(ctx context.Context, cfg Config, q ReplayQuery) (int, error) {
+	if err := checkHeightRange(q.FromHeight, q.ToHeight); err != nil {
+		return 0, err
+	}
+	keep, err := parseConsensusKinds(q.Kinds)
+	if err != nil {
+		return 0, err
+	}
+
+	httpClient := newHTTPClient(cfg)
+	httpClient.Transport = headerTransport{next: httpClient.Transport, key: replayHeader,
+		value: fmt.Sprintf("%d-%d", q.FromHeight, q.ToHeight)}
+	var (
+		sent    int
+		pending consensusBatch
+	)
+	flush := func() error {
+		if len(pending.Events) == 0 {
+			return nil
+		}
+		if err := postConsensusEvents(cfg, httpClient, pending); err != nil {
+			return fmt.Errorf("replay %s: %w", pending.Segment, err)
+		}
+		sent += len(pending.Events)
+		pending = consensusBatch{}
+		return nil
+	}
+	err = walkHeights(ctx, cfg.WALDir, q.FromHeight, q.ToHeight, func(fr wal.Frame, events []consensus.Event) error {
+		segment := filepath.Base(fr.Index)
+		if pending.Segment != segment || len(pending.Events) >= replayBatchEvents {
+			if err := flush(); err != nil {
+				return err
+			}
+			pending.Segment, pending.Replay = segment, true
+		}
+		for _, ev := range events {
+			if h := ev.Height(); h >= q.FromHeight && h <= q.ToHeight && (len(keep) == 0 || keep[ev.Kind]) {
+				pending.Events = append(pending.Events, ev)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return sent, err
+	}
+	return sent, flush()
+}
+
+func checkHeightRange(from, to int64) error {
+	if from <= 0 || to < from {
+		return fmt.Errorf("heights must satisfy 0 < from <= to, got %d-%d", from, to)
+	}
+	return nil
+}
+
+// walkHeights calls fn with each frame of the WAL in dir, and its decoded
+// consensus events, from the first frame at height from or later. It stops
+// before the first frame whose events are all past to, or at the end of the
+// WAL. Frames that cannot be decompressed are skipped.
+func walkHeights(ctx context.Context, dir string, from, to int64, fn func(wal.Frame, []consensus.Event) error) error {
+	idx, off, err := startPosition(dir, startFrom{height: from})
+	if err != nil {
+		return err
+	}
+	r, err := wal.OpenAt(idx, off)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fr, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		raw, err := fr.Decompress()
+		if err != nil {
+			continue // verified shipping reports corrupt frames; replay skips them
+		}
+		// Heights are checked across all kinds, so a frame of only kinds
+		// the caller ignores still ends the walk once it is past to.
+		events := consensus.DecodeFrame(raw, nil).Events
+		past := len(events) > 0
+		for _, ev := range events {
+			if ev.Height() <= to {
+				past = false
+			}
+		}
+		if past {
+			return nil
+		}
+		if err := fn(fr, events); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	vote := func(height, typ int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":%d,"height":"%d","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`, typ, height)
+	}
+	part := func(height int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/BlockPartMessage","value":{"height":"%d","round":0,"part":{"index":0,"bytes":"AQ=="}}},"peer_key":""}}}`, height)
+	}
+	timeout := `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{"height":"3","round":0,"step":1}}}`
+
+	// One frame per height 1-6, plus a timeout-only frame inside the range.
+	walDir := t.TempDir()
+	var gz []byte
+	var metas []FrameMeta
+	add := func(records ...string) {
+		f := gzipFrame(t, records...)
+		metas = append(metas, FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(len(metas) + 1), Off: uint64(len(gz)), Len: uint64(len(f))})
+		gz = append(gz, f...)
+	}
+	for h := 1; h <= 6; h++ {
+		add(part(h), vote(h, 1), vote(h, 2))
+		if h == 3 {
+			add(timeout)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), gz, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), metas)
+
+	tests := []struct {
+		name    string
+		q       ReplayQuery
+		want    []string // kind@height
+		wantErr string
+	}{
+		{name: "votes only", q: ReplayQuery{FromHeight: 2, ToHeight: 4, Kinds: "prevote,precommit"},
+			want: []string{"prevote@2", "precommit@2", "prevote@3", "precommit@3", "prevote@4", "precommit@4"}},
+		{name: "all kinds", q: ReplayQuery{FromHeight: 6, ToHeight: 6},
+			want: []string{"block_part@6", "prevote@6", "precommit@6"}},
+		{name: "past the end", q: ReplayQuery{FromHeight: 9, ToHeight: 10}},
+		{name: "bad range", q: ReplayQuery{FromHeight: 4, ToHeight: 2}, wantErr: "0 < from <= to"},
+		{name: "bad kind", q: ReplayQuery{FromHeight: 1, ToHeight: 2, Kinds: "vote"}, wantErr: "vote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var got []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != consensusEndpoint {
+					t.Errorf("unexpected request to %s", r.URL.Path)
+					return
+				}
+				var b consensusBatch
+				_ = json.NewDecoder(r.Body).Decode(&b)
+				if want := fmt.Sprintf("%d-%d", tt.q.FromHeight, tt.q.ToHeight); r.Header.Get(replayHeader) != want || !b.Replay {
+					t.Errorf("replay marker = %q, %v; want %q, true", r.Header.Get(replayHeader), b.Replay, want)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, ev := range b.Events {
+					got = append(got, fmt.Sprintf("%s@%d", ev.Kind, ev.Height()))
+				}
+			}))
+			defer ts.Close()
+
+			cfg := Config{ServiceURL: ts.URL, WALDir: walDir}
+			n, err := Replay(context.Background(), cfg, tt.q)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if n != len(tt.want) || strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("replayed %d %v, want %v", n, got, tt.want)
+			}
+		})
+	}
+}
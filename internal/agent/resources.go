@@ -1,11 +1,458 @@
 package agent
 
-import "runtime"
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
-// resourcesOK is a placeholder soft gate; actual implementation lives elsewhere.
+var (
+	// procRoot is where /proc is read from; tests point it elsewhere.
+	procRoot = "/proc"
+	// sysRoot is where /sys is read from; tests point it elsewhere.
+	sysRoot = "/sys"
+	// resourceSampleEvery is the shortest interval utilization is measured
+	// over; resourceWindow is how long samples are averaged, so a single
+	// spike delays sends for at most that long.
+	resourceSampleEvery = time.Second
+	resourceWindow      = 10 * time.Second
+)
+
+// defaultIfaceSpeedMbps is assumed when IfaceSpeedMbps is unset and the
+// interface's speed cannot be read, e.g. for virtual interfaces.
+const defaultIfaceSpeedMbps = 1000
+
+// Network probes for Config.NetProbe.
+const (
+	// NetProbeHost measures the traffic of every interface the agent sees.
+	NetProbeHost = "host"
+	// NetProbeProcess measures the traffic of the node process's network
+	// namespace, found through the process that writes the WAL. A node in
+	// the agent's own namespace is measured as the host.
+	NetProbeProcess = "process"
+)
+
+// resources gates sends of every agent in the process that measures host
+// traffic; CPU and NIC counters are host-wide. With NetProbeProcess each WAL
+// dir, i.e. node, has a monitor in nodeResources instead.
+var (
+	resources       = &resourceMonitor{}
+	nodeResourcesMu sync.Mutex
+	nodeResources   = map[string]*resourceMonitor{}
+)
+
+// resourcesOK reports whether host CPU and network utilization, averaged
+// over resourceWindow, are below CPUThreshold and NetThreshold. A threshold
+// of 0 disables that check, and counters that cannot be read never gate,
+// nor does anything with SubsystemResourceGating disabled. CPU is measured
+// on Linux and Windows, network traffic only on Linux.
 func resourcesOK(cfg Config) bool {
-	// Very simple heuristic as in original: if many goroutines or other signals, you could gate.
-	// Keep always true to avoid changing behavior.
-	_ = runtime.NumGoroutine()
-	return true
+	if !cfg.enabled(SubsystemResourceGating) {
+		return true
+	}
+	if cfg.NetProbe != NetProbeProcess {
+		return resources.ok(cfg, time.Now())
+	}
+	nodeResourcesMu.Lock()
+	m := nodeResources[cfg.WALDir]
+	if m == nil {
+		m = &resourceMonitor{}
+		nodeResources[cfg.WALDir] = m
+	}
+	nodeResourcesMu.Unlock()
+	return m.ok(cfg, time.Now())
+}
+
+type resourceSample struct {
+	at       time.Time
+	cpu, net float64 // utilization since the previous reading, 0..1
+}
+
+type resourceMonitor struct {
+	mu sync.Mutex
+
+	// Previous raw counters, read at last.
+	last              time.Time
+	cpuTotal, cpuIdle uint64
+	netBytes          uint64
+	haveCPU, haveNet  bool
+	iface             string // the interface netBytes counts; "" for all
+	speedMbps         int
+	routes            string // the route tables iface was detected from
+	netDev            string // the net/dev file netBytes was read from
+	node              nodeProcess
+
+	samples        []resourceSample
+	cpuAvg, netAvg float64
+	gated          bool
+	warned         bool // counters unavailable was logged
+}
+
+func (m *resourceMonitor) ok(cfg Config, now time.Time) bool {
+	if cfg.CPUThreshold <= 0 && cfg.NetThreshold <= 0 {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.last) >= resourceSampleEvery {
+		m.sample(cfg, now)
+	}
+
+	n := 0
+	for _, s := range m.samples {
+		if now.Sub(s.at) <= resourceWindow {
+			m.samples[n] = s
+			n++
+		}
+	}
+	m.samples = m.samples[:n]
+	if n == 0 {
+		return !m.setGated(false, cfg)
+	}
+	var cpu, net float64
+	for _, s := range m.samples {
+		cpu += s.cpu
+		net += s.net
+	}
+	m.cpuAvg, m.netAvg = cpu/float64(n), net/float64(n)
+	gated := (cfg.CPUThreshold > 0 && m.cpuAvg > cfg.CPUThreshold) ||
+		(cfg.NetThreshold > 0 && m.netAvg > cfg.NetThreshold)
+	return !m.setGated(gated, cfg)
+}
+
+// sample reads the counters and records utilization since the last reading.
+func (m *resourceMonitor) sample(cfg Config, now time.Time) {
+	s := resourceSample{at: now}
+	valid := false
+	if total, idle, err := hostCPUTimes(); err == nil {
+		if m.haveCPU && total > m.cpuTotal {
+			s.cpu = 1 - float64(idle-m.cpuIdle)/float64(total-m.cpuTotal)
+			valid = true
+		}
+		m.cpuTotal, m.cpuIdle, m.haveCPU = total, idle, true
+	}
+	dev := filepath.Join(procRoot, "net", "dev")
+	if cfg.NetProbe == NetProbeProcess {
+		if pid := m.node.pid(cfg, now); pid > 0 && m.node.ownNetNamespace(pid) {
+			dev = filepath.Join(procRoot, strconv.Itoa(pid), "net", "dev")
+		}
+	}
+	if dev != m.netDev {
+		m.netDev, m.haveNet = dev, false
+	}
+	m.detectIface(cfg, filepath.Dir(dev))
+	if b, err := readNetBytes(dev, m.iface); err == nil {
+		if m.haveNet && b >= m.netBytes {
+			bps := float64(b-m.netBytes) * 8 / now.Sub(m.last).Seconds()
+			s.net = bps / (float64(m.speedMbps) * 1e6)
+			valid = true
+		}
+		m.netBytes, m.haveNet = b, true
+	}
+	if !m.haveCPU && !m.haveNet && !m.warned {
+		m.warned = true
+		logger.Warn().Str("os", runtime.GOOS).Msg("host CPU and network counters unavailable; sends are not delayed by load")
+	}
+	m.last = now
+	if valid {
+		m.samples = append(m.samples, s)
+	}
+}
+
+// detectIface sets the interface to measure and its speed: cfg.Iface and
+// cfg.IfaceSpeedMbps when set, else the interface of the default route in
+// netDir's route tables and its speed from sysfs. Detection is repeated
+// whenever the route tables change; a changed interface restarts the
+// traffic counters and drops the samples taken on the old one.
+func (m *resourceMonitor) detectIface(cfg Config, netDir string) {
+	iface, routes := cfg.Iface, ""
+	if iface == "" {
+		v4, _ := os.ReadFile(filepath.Join(netDir, "route"))
+		v6, _ := os.ReadFile(filepath.Join(netDir, "ipv6_route"))
+		routes = string(v4) + string(v6)
+		if routes == m.routes && m.speedMbps > 0 {
+			iface = m.iface
+		} else {
+			iface = defaultRouteIface(string(v4), string(v6))
+		}
+	}
+	speed := cfg.IfaceSpeedMbps
+	if speed <= 0 {
+		speed = m.speedMbps
+		if iface != m.iface || routes != m.routes || speed <= 0 {
+			speed = readIfaceSpeed(iface)
+		}
+	}
+	if iface != m.iface {
+		m.haveNet, m.samples = false, nil
+	}
+	if (cfg.Iface == "" || cfg.IfaceSpeedMbps <= 0) && (iface != m.iface || speed != m.speedMbps) {
+		logger.Info().Str("iface", iface).Int("speed_mbps", speed).Msg("detected network interface for resource gating")
+	}
+	m.iface, m.speedMbps, m.routes = iface, speed, routes
+}
+
+// defaultRouteIface returns the interface of the default route with the
+// lowest metric in the IPv4 table v4, a /proc net/route file, or failing
+// that the IPv6 table v6, a /proc net/ipv6_route file. It returns "" if
+// there is none.
+func defaultRouteIface(v4, v6 string) string {
+	const rtfUp = 0x1
+	best, bestMetric := "", uint64(0)
+	consider := func(iface, flags, metric string) {
+		f, err1 := strconv.ParseUint(flags, 16, 32)
+		m, err2 := strconv.ParseUint(metric, 10, 64)
+		if err1 != nil || err2 != nil || f&rtfUp == 0 || iface == "lo" {
+			return
+		}
+		if best == "" || m < bestMetric {
+			best, bestMetric = iface, m
+		}
+	}
+	// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+	for _, line := range strings.Split(v4, "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 8 && f[1] == "00000000" && f[7] == "00000000" {
+			consider(f[0], f[3], f[6])
+		}
+	}
+	if best != "" {
+		return best
+	}
+	// Destination PrefixLen Source SrcPrefixLen NextHop Metric RefCnt Use
+	// Flags Iface, with hexadecimal metric.
+	for _, line := range strings.Split(v6, "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 10 && f[1] == "00" && strings.Trim(f[0], "0") == "" {
+			if metric, err := strconv.ParseUint(f[5], 16, 32); err == nil {
+				consider(f[9], f[8], strconv.FormatUint(metric, 10))
+			}
+		}
+	}
+	return best
+}
+
+// readIfaceSpeed returns the link speed sysfs reports for iface, or
+// defaultIfaceSpeedMbps if iface is "" or its speed is unknown.
+func readIfaceSpeed(iface string) int {
+	if iface == "" {
+		return defaultIfaceSpeedMbps
+	}
+	b, err := os.ReadFile(filepath.Join(sysRoot, "class", "net", iface, "speed"))
+	if err != nil {
+		return defaultIfaceSpeedMbps
+	}
+	speed, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || speed <= 0 {
+		return defaultIfaceSpeedMbps
+	}
+	return speed
+}
+
+// setGated records the gate state, logging transitions, and returns it.
+func (m *resourceMonitor) setGated(gated bool, cfg Config) bool {
+	if gated == m.gated {
+		return gated
+	}
+	m.gated = gated
+	if gated {
+		logger.Warn().Float64("cpu", m.cpuAvg).Float64("net", m.netAvg).
+			Float64("cpu_threshold", cfg.CPUThreshold).Float64("net_threshold", cfg.NetThreshold).
+			Msg("host busy; delaying sends")
+		recordEvent(EventState, fmt.Sprintf("sends delayed: cpu %.0f%%, net %.0f%%", m.cpuAvg*100, m.netAvg*100))
+	} else {
+		logger.Info().Float64("cpu", m.cpuAvg).Float64("net", m.netAvg).Msg("host load back to normal; resuming sends")
+		recordEvent(EventState, "sends resumed")
+	}
+	return gated
+}
+
+// readCPUTimes returns the total and idle (including iowait) jiffies from
+// the aggregate line of /proc/stat.
+func readCPUTimes() (total, idle uint64, err error) {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal; guest time is
+		// already counted in user.
+		for i, field := range fields[1:] {
+			if i == 8 {
+				break
+			}
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("parse /proc/stat: %w", err)
+			}
+			total += v
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+		return total, idle, nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("no cpu line in /proc/stat")
+}
+
+// readNetBytes returns the received plus transmitted bytes of iface, or of
+// every interface but loopback if iface is empty, from dev, a /proc net/dev
+// file.
+func readNetBytes(dev, iface string) (uint64, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var sum uint64
+	found := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		name, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue // header lines
+		}
+		name = strings.TrimSpace(name)
+		if (iface != "" && name != iface) || (iface == "" && name == "lo") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseUint(fields[0], 10, 64)
+		tx, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("parse /proc/net/dev line for %s", name)
+		}
+		sum += rx + tx
+		found = true
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("interface %q not in /proc/net/dev", iface)
+	}
+	return sum, nil
+}
+
+// nodeProcessRetry is how long a failed search for the node process is
+// trusted before the next one.
+var nodeProcessRetry = time.Minute
+
+// nodeProcess finds and remembers the node process of a WAL dir: the PID in
+// WALWriterFile if any, else a process other than the agent that holds a
+// file of the WAL dir open. Finding the latter needs read access to the
+// node's /proc/<pid>/fd, i.e. the same user or root.
+type nodeProcess struct {
+	cur        int
+	lastSearch time.Time
+	warned     bool
+	// nsPID is the process nsShared was determined for.
+	nsPID    int
+	nsShared bool
+}
+
+// pid returns the node's PID, or 0 while it cannot be found.
+func (n *nodeProcess) pid(cfg Config, now time.Time) int {
+	if n.cur > 0 && processAlive(n.cur) {
+		return n.cur
+	}
+	if n.cur == 0 && now.Sub(n.lastSearch) < nodeProcessRetry {
+		return 0
+	}
+	n.lastSearch = now
+	prev := n.cur
+	n.cur = 0
+	if cfg.WALWriterFile != "" {
+		n.cur = checkWALWriter(cfg, now).NodePID
+	}
+	if n.cur == 0 {
+		n.cur = findWALProcess(cfg.WALDir)
+	}
+	switch {
+	case n.cur > 0 && n.cur != prev:
+		logger.Info().Int("pid", n.cur).Msg("measuring the node process's network usage")
+	case n.cur == 0 && (prev > 0 || !n.warned):
+		n.warned = true
+		logger.Warn().Str("wal_dir", cfg.WALDir).Msg("node process not found; measuring host network usage")
+	}
+	return n.cur
+}
+
+// ownNetNamespace reports whether the node process pid has a network
+// namespace of its own, so that its traffic can be told from the host's. A
+// node sharing the agent's namespace, e.g. both on the host network, would
+// count every workload's traffic; the host probe is used instead, with a
+// warning. If the namespaces cannot be compared, which needs the access
+// finding the process needs, the node's /proc/<pid>/net is trusted, also
+// with a warning.
+func (n *nodeProcess) ownNetNamespace(pid int) bool {
+	if n.nsPID == pid {
+		return !n.nsShared
+	}
+	n.nsPID = pid
+	self, err := os.Readlink(filepath.Join(procRoot, "self", "ns", "net"))
+	if err == nil {
+		var node string
+		if node, err = os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "ns", "net")); err == nil {
+			n.nsShared = self == node
+		}
+	}
+	switch {
+	case err != nil:
+		n.nsShared = false
+		logger.Warn().Err(err).Int("pid", pid).Msg("cannot compare network namespaces; measuring the node process's namespace, which may be the host's")
+	case n.nsShared:
+		logger.Warn().Int("pid", pid).Msg("node process shares the agent's network namespace; measuring host network usage")
+	}
+	return !n.nsShared
+}
+
+// findWALProcess returns a process other than this one with a file under
+// walDir open, or 0.
+func findWALProcess(walDir string) int {
+	dir, err := filepath.Abs(walDir)
+	if err != nil {
+		return 0
+	}
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return 0
+	}
+	self := os.Getpid()
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, e.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && strings.HasPrefix(target, dir+string(filepath.Separator)) {
+				return pid
+			}
+		}
+	}
+	return 0
 }
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResourceMonitor(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	oldRoot := procRoot
+	procRoot = root
+	defer func() { procRoot = oldRoot }()
+
+	// write sets the cumulative busy and idle jiffies and eth0 bytes.
+	write := func(busy, idle, bytes uint64) {
+		t.Helper()
+		stat := fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 1 0 0 1 0 0 0 0 0 0\n", busy, idle)
+		dev := "Inter-|   Receive                                                |  Transmit\n" +
+			" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+			"    lo: 999999 1 0 0 0 0 0 0 999999 1 0 0 0 0 0 0\n" +
+			fmt.Sprintf("  eth0: %d 1 0 0 0 0 0 0 0 1 0 0 0 0 0 0\n", bytes)
+		if err := os.WriteFile(filepath.Join(root, "stat"), []byte(stat), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "net", "dev"), []byte(dev), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := Config{CPUThreshold: 0.75, NetThreshold: 0.5, IfaceSpeedMbps: 8} // 8 Mbps = 1 MB/s
+	m := &resourceMonitor{}
+	t0 := time.Now()
+	var busy, idle, bytes uint64
+
+	// step advances the fake counters by one second of the given load and
+	// checks the gate.
+	step := func(sec int, cpu float64, bytesPerSec uint64, wantOK bool) {
+		t.Helper()
+		busy += uint64(cpu * 100)
+		idle += uint64((1 - cpu) * 100)
+		bytes += bytesPerSec
+		write(busy, idle, bytes)
+		if got := m.ok(cfg, t0.Add(time.Duration(sec)*time.Second)); got != wantOK {
+			t.Fatalf("t=%ds: ok = %v, want %v (cpu avg %.2f, net avg %.2f)", sec, got, wantOK, m.cpuAvg, m.netAvg)
+		}
+	}
+
+	step(0, 0, 0, true) // first reading only
+	step(1, 0.2, 100_000, true)
+	step(2, 1.0, 100_000, true) // one spike is averaged out
+	step(3, 1.0, 100_000, true)
+	step(4, 1.0, 100_000, false) // sustained CPU load gates
+	step(15, 0.1, 100_000, true) // busy samples left the window
+	step(16, 0.1, 900_000, true)
+	step(17, 0.1, 900_000, false) // NIC above half its speed gates
+	if !m.gated {
+		t.Error("gate not recorded")
+	}
+
+	if !m.ok(Config{}, t0.Add(18*time.Second)) {
+		t.Error("zero thresholds should never gate")
+	}
+}
+
+func TestResourcesOK_WithoutProc(t *testing.T) {
+	oldRoot := procRoot
+	procRoot = filepath.Join(t.TempDir(), "missing")
+	defer func() { procRoot = oldRoot }()
+
+	m := &resourceMonitor{}
+	cfg := Config{CPUThreshold: 0.01, NetThreshold: 0.01, IfaceSpeedMbps: 1}
+	for i := 0; i < 3; i++ {
+		if !m.ok(cfg, time.Now().Add(time.Duration(i)*time.Second)) {
+			t.Fatal("unreadable counters should not gate")
+		}
+	}
+}
+
+func TestResourceMonitor_ProcessProbe(t *testing.T) {
+	root := t.TempDir()
+	oldRoot := procRoot
+	procRoot = root
+	defer func() { procRoot = oldRoot }()
+
+	walDir := t.TempDir()
+	for _, dir := range []string{"net", "4242/net", "4242/fd", "self/ns", "4242/ns"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(walDir, "seg-000001.wal.gz"), filepath.Join(root, "4242", "fd", "7")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("net:[4026531840]", filepath.Join(root, "self", "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("net:[4026532290]", filepath.Join(root, "4242", "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+	if got := findWALProcess(walDir); got != 4242 {
+		t.Fatalf("findWALProcess = %d, want 4242", got)
+	}
+
+	// The host is saturated by other workloads; the node's namespace is not.
+	writeDev := func(path string, bytes uint64) {
+		t.Helper()
+		dev := fmt.Sprintf("Inter-|\n face |\n  eth0: %d 1 0 0 0 0 0 0 0 1 0 0 0 0 0 0\n", bytes)
+		if err := os.WriteFile(path, []byte(dev), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{NetThreshold: 0.5, IfaceSpeedMbps: 8, NetProbe: NetProbeProcess, WALDir: walDir}
+	m := &resourceMonitor{}
+	t0 := time.Now()
+	for sec := 0; sec < 3; sec++ {
+		writeDev(filepath.Join(root, "net", "dev"), uint64(sec)*900_000)
+		writeDev(filepath.Join(root, "4242", "net", "dev"), uint64(sec)*100_000)
+		if !m.ok(cfg, t0.Add(time.Duration(sec)*time.Second)) {
+			t.Fatalf("t=%ds: gated on host traffic (net avg %.2f)", sec, m.netAvg)
+		}
+	}
+	if m.node.cur != 4242 {
+		t.Errorf("node pid = %d, want 4242", m.node.cur)
+	}
+	cfg.NetProbe = NetProbeHost
+	m.ok(cfg, t0.Add(3*time.Second))
+	writeDev(filepath.Join(root, "net", "dev"), 4*900_000)
+	if m.ok(cfg, t0.Add(4*time.Second)) {
+		t.Error("host probe did not gate on host traffic")
+	}
+
+	// A node in the agent's namespace cannot be told from the host.
+	if err := os.Remove(filepath.Join(root, "4242", "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("net:[4026531840]", filepath.Join(root, "4242", "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.NetProbe = NetProbeProcess
+	m = &resourceMonitor{}
+	for sec := 0; sec < 3; sec++ {
+		writeDev(filepath.Join(root, "net", "dev"), uint64(sec)*900_000)
+		writeDev(filepath.Join(root, "4242", "net", "dev"), uint64(sec)*100_000)
+		m.ok(cfg, t0.Add(time.Duration(sec)*time.Second))
+	}
+	if m.ok(cfg, t0.Add(3*time.Second)) {
+		t.Errorf("process probe in the agent's namespace did not gate on host traffic (net avg %.2f)", m.netAvg)
+	}
+}
+
+func TestDefaultRouteIface(t *testing.T) {
+	const v4Header = "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"
+	tests := []struct {
+		name   string
+		v4, v6 string
+		want   string
+	}{
+		{"none", v4Header, "", ""},
+		{"ipv4 default", v4Header +
+			"docker0\tAC110000\t00000000\t0001\t0\t0\t0\tFFFF0000\t0\t0\t0\n" +
+			"eth0\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n", "", "eth0"},
+		{"lowest metric", v4Header +
+			"wlan0\t00000000\t0101A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+			"eth1\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n", "", "eth1"},
+		{"route down", v4Header +
+			"eth0\t00000000\t0101A8C0\t0002\t0\t0\t100\t00000000\t0\t0\t0\n", "", ""},
+		{"ipv6 only", v4Header, "" +
+			"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     ens5\n" +
+			"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n", "ens5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultRouteIface(tt.v4, tt.v6); got != tt.want {
+				t.Errorf("defaultRouteIface = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceMonitor_DetectIface(t *testing.T) {
+	root, sys := t.TempDir(), t.TempDir()
+	oldRoot, oldSys := procRoot, sysRoot
+	procRoot, sysRoot = root, sys
+	defer func() { procRoot, sysRoot = oldRoot, oldSys }()
+
+	for _, dir := range []string{filepath.Join(root, "net"), filepath.Join(sys, "class", "net", "eth0"), filepath.Join(sys, "class", "net", "eth1")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	route := func(iface string) {
+		write(filepath.Join(root, "net", "route"), "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"+
+			iface+"\t00000000\t0101A8C0\t0003\t0\t0\t100\t00000000\t0\t0\t0\n")
+	}
+	write(filepath.Join(sys, "class", "net", "eth0", "speed"), "8\n")
+	write(filepath.Join(sys, "class", "net", "eth1", "speed"), "-1\n")
+
+	// eth0 carries the default route and is saturated; eth1 is idle.
+	writeDev := func(eth0, eth1 uint64) {
+		write(filepath.Join(root, "net", "dev"), fmt.Sprintf("Inter-|\n face |\n  eth0: %d 1 0 0 0 0 0 0 0 1 0 0 0 0 0 0\n  eth1: %d 1 0 0 0 0 0 0 0 1 0 0 0 0 0 0\n", eth0, eth1))
+	}
+	route("eth0")
+	cfg := Config{NetThreshold: 0.5}
+	m := &resourceMonitor{}
+	t0 := time.Now()
+	for sec := 0; sec < 3; sec++ {
+		writeDev(uint64(sec)*900_000, 0)
+		m.ok(cfg, t0.Add(time.Duration(sec)*time.Second))
+	}
+	if m.iface != "eth0" || m.speedMbps != 8 || !m.gated {
+		t.Fatalf("iface %q at %d Mbps, gated %v; want eth0 at 8 Mbps, gated", m.iface, m.speedMbps, m.gated)
+	}
+
+	// The default route moves to eth1, whose speed sysfs does not know.
+	route("eth1")
+	m.ok(cfg, t0.Add(3*time.Second))
+	if m.iface != "eth1" || m.speedMbps != defaultIfaceSpeedMbps || len(m.samples) != 0 {
+		t.Fatalf("after route change: iface %q at %d Mbps with %d samples; want eth1 at %d Mbps, none", m.iface, m.speedMbps, len(m.samples), defaultIfaceSpeedMbps)
+	}
+	writeDev(4*900_000, 0)
+	if !m.ok(cfg, t0.Add(4*time.Second)) {
+		t.Error("gated on eth0 traffic after the default route moved to eth1")
+	}
+
+	// An explicit interface and speed are used as given.
+	m = &resourceMonitor{}
+	cfg = Config{NetThreshold: 0.5, Iface: "eth1", IfaceSpeedMbps: 100}
+	m.ok(cfg, t0)
+	if m.iface != "eth1" || m.speedMbps != 100 {
+		t.Errorf("explicit: iface %q at %d Mbps, want eth1 at 100 Mbps", m.iface, m.speedMbps)
+	}
+}
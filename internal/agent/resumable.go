@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Resumable uploads let a batch too large to send reliably in one request be
+// transferred in chunks against a server-side session:
+//
+//	POST {base}/v1/ingest/wal-frames/sessions          begin, JSON manifest -> {"session_id"}
+//	HEAD {base}/v1/ingest/wal-frames/sessions/{id}     Upload-Offset: confirmed bytes
+//	PUT  {base}/v1/ingest/wal-frames/sessions/{id}     Upload-Offset: n, body = payload[n:n+chunk]
+//	POST {base}/v1/ingest/wal-frames/sessions/{id}/commit
+//
+// The session is recorded in state, so an interrupted transfer resumes from the
+// last byte the server confirmed, even across restarts.
+const walSessionsEndpoint = walFramesEndpoint + "/sessions"
+
+var resumableChunkBytes = 1 << 20
+
+// uploadSession identifies an in-progress resumable upload and the exact batch
+// it belongs to.
+type uploadSession struct {
+	ID          string `json:"id"`
+	Segment     string `json:"segment"`
+	StartOffset int64  `json:"start_offset"`
+	Frames      int    `json:"frames"`
+	Hash        string `json:"hash"`
+}
+
+type sessionBegin struct {
+	Segment    string      `json:"segment"`
+	Manifest   []FrameMeta `json:"manifest"`
+	TotalBytes int         `json:"total_bytes"`
+	Hash       string      `json:"hash"`
+}
+
+// sendResumable uploads frames through an upload session, reusing the one in
+// st when it matches this batch. The session is cleared from st once the
+// server commits it or reports it unknown.
+func sendResumable(cfg Config, httpClient *http.Client, frames []batchFrame, curIdxBase string, st *state) error {
+	payload := make([]byte, 0, framesBytes(frames))
+	manifest := make([]FrameMeta, 0, len(frames))
+	for _, fr := range frames {
+		payload = append(payload, fr.Compressed...)
+		manifest = append(manifest, fr.Meta)
+	}
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+
+	sess := st.Upload
+	if sess != nil && (sess.Segment != curIdxBase || sess.StartOffset != st.IdxOffset || sess.Frames != len(frames) || sess.Hash != hash) {
+		// The batch differs from the interrupted one; start over.
+		sess = nil
+	}
+
+	offset := 0
+	if sess != nil {
+		off, err := sessionOffset(cfg, httpClient, sess.ID)
+		switch {
+		case statusCode(err) == http.StatusNotFound:
+			sess = nil // expired server-side
+		case err != nil:
+			return err
+		default:
+			offset = off
+		}
+		if sess != nil {
+			logger.Info().Str("session", sess.ID).Int("offset", offset).Int("total", len(payload)).Msg("resuming upload session")
+		}
+	}
+
+	if sess == nil {
+		id, err := beginSession(cfg, httpClient, sessionBegin{Segment: curIdxBase, Manifest: manifest, TotalBytes: len(payload), Hash: hash})
+		if err != nil {
+			return err
+		}
+		sess = &uploadSession{ID: id, Segment: curIdxBase, StartOffset: st.IdxOffset, Frames: len(frames), Hash: hash}
+		st.Upload = sess
+		_ = saveState(cfg.StateDir, *st)
+		offset = 0
+	}
+
+	for offset < len(payload) {
+		end := offset + resumableChunkBytes
+		if end > len(payload) {
+			end = len(payload)
+		}
+		next, err := appendSession(cfg, httpClient, sess.ID, offset, payload[offset:end])
+		if err != nil {
+			return err
+		}
+		if next <= offset || next > len(payload) {
+			return fmt.Errorf("upload session %s: server confirmed offset %d after %d", sess.ID, next, offset)
+		}
+		offset = next
+	}
+
+	if err := sessionRequest(cfg, httpClient, http.MethodPost, walSessionsEndpoint+"/"+sess.ID+"/commit", nil, nil, nil); err != nil {
+		if statusCode(err) == http.StatusNotFound {
+			// Expired before the commit; a restart must not resume it.
+			st.Upload = nil
+			_ = saveState(cfg.StateDir, *st)
+		}
+		return err
+	}
+	st.Upload = nil
+	return nil
+}
+
+func beginSession(cfg Config, httpClient *http.Client, begin sessionBegin) (string, error) {
+	body, err := json.Marshal(begin)
+	if err != nil {
+		return "", fmt.Errorf("marshal session: %w", err)
+	}
+	var out struct {
+		SessionID string `json:"session_id"`
+	}
+	hdr := http.Header{"Content-Type": []string{"application/json"}}
+	err = sessionRequest(cfg, httpClient, http.MethodPost, walSessionsEndpoint, hdr, body, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&out)
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SessionID == "" {
+		return "", fmt.Errorf("begin upload session: empty session id")
+	}
+	return out.SessionID, nil
+}
+
+func sessionOffset(cfg Config, httpClient *http.Client, id string) (int, error) {
+	var off int
+	err := sessionRequest(cfg, httpClient, http.MethodHead, walSessionsEndpoint+"/"+id, nil, nil, func(resp *http.Response) error {
+		var err error
+		off, err = uploadOffset(resp.Header)
+		return err
+	})
+	return off, err
+}
+
+// appendSession sends chunk at offset and returns the offset the server now
+// holds. A 409 carries the server's actual offset, which is returned so the
+// caller continues from there.
+func appendSession(cfg Config, httpClient *http.Client, id string, offset int, chunk []byte) (int, error) {
+	hdr := http.Header{
+		"Content-Type":  []string{"application/octet-stream"},
+		"Upload-Offset": []string{strconv.Itoa(offset)},
+	}
+	var next int
+	err := sessionRequest(cfg, httpClient, http.MethodPut, walSessionsEndpoint+"/"+id, hdr, chunk, func(resp *http.Response) error {
+		var err error
+		next, err = uploadOffset(resp.Header)
+		return err
+	})
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusConflict {
+		if off, oerr := uploadOffset(se.header); oerr == nil {
+			return off, nil
+		}
+	}
+	return next, err
+}
+
+func sessionRequest(cfg Config, httpClient *http.Client, method, path string, hdr http.Header, body []byte, onOK func(*http.Response) error) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(activePipeline(cfg).sendContext(), method, ingestURL(cfg, path), rd)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	setAgentHeaders(req, cfg)
+	throttleBody(req, cfg)
+
+	resp, err := pacedClient(httpClient, req, cfg).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	if onOK != nil {
+		return onOK(resp)
+	}
+	return nil
+}
+
+func uploadOffset(h http.Header) (int, error) {
+	v := h.Get("Upload-Offset")
+	if v == "" {
+		return 0, fmt.Errorf("missing Upload-Offset header")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid Upload-Offset %q", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionServer is a minimal in-memory implementation of the upload session
+// protocol. failAt makes the first PUT reaching that offset fail once, and
+// expire makes sessions expire before their commit.
+type sessionServer struct {
+	mu        sync.Mutex
+	data      map[string][]byte
+	committed map[string][]byte
+	begins    int
+	failAt    int
+	failed    bool
+	expire    bool
+}
+
+func newSessionServer() *sessionServer {
+	return &sessionServer{data: map[string][]byte{}, committed: map[string][]byte{}, failAt: -1}
+}
+
+func (s *sessionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rest := strings.TrimPrefix(r.URL.Path, walSessionsEndpoint)
+	switch {
+	case r.Method == http.MethodPost && rest == "":
+		var b sessionBegin
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.begins++
+		id := "s" + strconv.Itoa(s.begins)
+		s.data[id] = []byte{}
+		_ = json.NewEncoder(w).Encode(map[string]string{"session_id": id})
+	case r.Method == http.MethodHead:
+		buf, ok := s.data[strings.TrimPrefix(rest, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(buf)))
+	case r.Method == http.MethodPut:
+		id := strings.TrimPrefix(rest, "/")
+		buf, ok := s.data[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		off, _ := strconv.Atoi(r.Header.Get("Upload-Offset"))
+		if off != len(buf) {
+			w.Header().Set("Upload-Offset", strconv.Itoa(len(buf)))
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if off == s.failAt && !s.failed {
+			s.failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		chunk, _ := io.ReadAll(r.Body)
+		s.data[id] = append(buf, chunk...)
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data[id])))
+	case r.Method == http.MethodPost && strings.HasSuffix(rest, "/commit"):
+		id := strings.TrimSuffix(strings.TrimPrefix(rest, "/"), "/commit")
+		buf, ok := s.data[id]
+		if !ok || s.expire {
+			delete(s.data, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.committed[id] = buf
+		delete(s.data, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestTrySend_ResumableResumesAfterFailure(t *testing.T) {
+	oldChunk := resumableChunkBytes
+	resumableChunkBytes = 1000
+	defer func() { resumableChunkBytes = oldChunk }()
+
+	srv := newSessionServer()
+	srv.failAt = 2000
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), ResumableUploadBytes: 2000}
+	client := &http.Client{Timeout: time.Second}
+	batch := splitTestBatch()
+	batchBytes := 4000
+	st := state{}
+	back := newBackoff(time.Millisecond, time.Millisecond)
+
+	trySend(cfg, client, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), back)
+	if len(batch) != 4 || st.Upload == nil {
+		t.Fatalf("after failure: batch = %d frames, upload = %+v; want batch kept and session recorded", len(batch), st.Upload)
+	}
+	saved, err := loadState(cfg.StateDir)
+	if err != nil || saved.Upload == nil || saved.Upload.ID != st.Upload.ID {
+		t.Fatalf("persisted upload = %+v (err %v), want session %s", saved.Upload, err, st.Upload.ID)
+	}
+
+	trySend(cfg, client, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), back)
+	if len(batch) != 0 || batchBytes != 0 {
+		t.Errorf("batch = %d frames / %d bytes, want empty", len(batch), batchBytes)
+	}
+	if st.Upload != nil || st.IdxOffset != 40 || st.LastFrame != 4 {
+		t.Errorf("state = upload %+v offset %d frame %d, want nil / 40 / 4", st.Upload, st.IdxOffset, st.LastFrame)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.begins != 1 {
+		t.Errorf("sessions begun = %d, want 1 (second attempt resumes)", srv.begins)
+	}
+	if got := len(srv.committed["s1"]); got != 4000 {
+		t.Errorf("committed bytes = %d, want 4000", got)
+	}
+}
+
+func TestSendResumable_RestartsChangedBatch(t *testing.T) {
+	srv := newSessionServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir()}
+	client := &http.Client{Timeout: time.Second}
+	st := state{Upload: &uploadSession{ID: "stale", Segment: "seg-000001.wal.idx", Frames: 1, Hash: "x"}}
+
+	if err := sendResumable(cfg, client, splitTestBatch(), "seg-000001.wal.idx", &st); err != nil {
+		t.Fatalf("sendResumable: %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.begins != 1 || len(srv.committed["s1"]) != 4000 {
+		t.Errorf("begins = %d, committed = %d bytes; want a fresh session with 4000 bytes", srv.begins, len(srv.committed["s1"]))
+	}
+}
+
+func TestSendResumable_ForgetsSessionExpiredAtCommit(t *testing.T) {
+	srv := newSessionServer()
+	srv.expire = true
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir()}
+	client := &http.Client{Timeout: time.Second}
+	st := state{}
+
+	if err := sendResumable(cfg, client, splitTestBatch(), "seg-000001.wal.idx", &st); statusCode(err) != http.StatusNotFound {
+		t.Fatalf("sendResumable: %v, want a 404", err)
+	}
+	saved, err := loadState(cfg.StateDir)
+	if err != nil || st.Upload != nil || saved.Upload != nil {
+		t.Errorf("upload = %+v, persisted %+v (err %v); want both cleared", st.Upload, saved.Upload, err)
+	}
+}
+
+func TestTrySend_SmallBatchSkipsSession(t *testing.T) {
+	var paths []string
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), ResumableUploadBytes: 1 << 20}
+	batch := splitTestBatch()
+	batchBytes := 4000
+	st := state{}
+	trySend(cfg, &http.Client{Timeout: time.Second}, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), newBackoff(time.Millisecond, time.Second))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != walFramesEndpoint {
+		t.Errorf("requests = %v, want a single %s", paths, walFramesEndpoint)
+	}
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// walRetentionCheckInterval is how often a retention policy is enforced; it
+// is much shorter than the watermark check as free disk can drop quickly.
+var walRetentionCheckInterval = 10 * time.Minute
+
+// walRetention is the retention policy that replaces the size watermarks of
+// WAL cleanup. A nil *walRetention leaves cleanup to the watermarks.
+type walRetention struct {
+	maxAge         time.Duration
+	maxBytes       int64
+	minFreePercent float64
+}
+
+// newWALRetention returns the retention policy configured by cfg, or nil if
+// no retention rule is set.
+func newWALRetention(cfg Config) *walRetention {
+	if cfg.RetentionMaxAge <= 0 && cfg.RetentionMaxBytes <= 0 && cfg.RetentionMinFreePercent <= 0 {
+		return nil
+	}
+	return &walRetention{
+		maxAge:         cfg.RetentionMaxAge,
+		maxBytes:       int64(cfg.RetentionMaxBytes),
+		minFreePercent: cfg.RetentionMinFreePercent,
+	}
+}
+
+// violated returns the first rule seg breaks given the WAL dir's current
+// size, or "" if it may be kept.
+func (r *walRetention) violated(seg walSegment, walDir string, size int64, now time.Time) string {
+	if r.maxAge > 0 && now.Sub(seg.modTime) > r.maxAge {
+		return "max age"
+	}
+	if r.maxBytes > 0 && size > r.maxBytes {
+		return "max bytes"
+	}
+	if r.minFreePercent > 0 {
+		free, err := diskFreePercent(walDir)
+		if err != nil {
+			logger.Error().Err(err).Msg("wal retention: free disk check failed")
+			return ""
+		}
+		if free < r.minFreePercent {
+			return "min free disk"
+		}
+	}
+	return ""
+}
+
+// walRetentionOnce removes acknowledged segments, oldest first, until none
+// breaks a rule of ret. Segments are archived first if arch is set.
+func walRetentionOnce(ctx context.Context, walDir, stateDir string, arch *walArchive, ret *walRetention) {
+	segs, err := orderedSegments(walDir, "")
+	if err != nil {
+		logger.Error().Err(err).Msg("wal retention: list segments failed")
+		return
+	}
+	st, err := loadState(stateDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return // nothing shipped yet
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("wal retention: load state failed, keeping all segments")
+		return
+	}
+	acked := acknowledgedSegments(segs, st)
+	if len(acked) == 0 {
+		return
+	}
+	size, err := walDirSize(walDir)
+	if err != nil {
+		logger.Error().Err(err).Msg("wal retention: size check failed")
+		return
+	}
+
+	now := time.Now()
+	removed := int64(0)
+	for _, seg := range acked {
+		if ctx.Err() != nil {
+			return
+		}
+		rule := ret.violated(seg, walDir, size, now)
+		if rule == "" {
+			// Older segments go first, so a newer one breaks no rule either.
+			break
+		}
+		if err := arch.archive(seg); err != nil {
+			logger.Error().Err(err).Str("segment", seg.gzPath).Msg("wal retention: archive failed, keeping segment")
+			continue
+		}
+		freed, err := removeSegment(seg)
+		if err != nil {
+			logger.Error().Err(err).Str("segment", seg.gzPath).Msg("wal retention: remove failed")
+			continue
+		}
+		logger.Debug().Str("segment", seg.gzPath).Str("rule", rule).Msg("wal retention: removed segment")
+		size -= freed
+		removed += freed
+	}
+
+	if removed > 0 {
+		logger.Info().
+			Str("freed", formatBytes(removed)).
+			Str("remaining", formatBytes(size)).
+			Msg("wal retention completed")
+	}
+}
+
+// acknowledgedSegments returns the leading segments of segs whose frames
+// the service has all acknowledged, going by the committed position in st.
+// The segment before the committed one is held back until a frame of the
+// committed one is acknowledged, as its last frames may still be in flight.
+// Nothing is acknowledged if the committed segment is not among segs.
+func acknowledgedSegments(segs []walSegment, st state) []walSegment {
+	if st.IdxPath == "" {
+		return nil
+	}
+	cur := filepath.Clean(st.IdxPath)
+	for i, seg := range segs {
+		if filepath.Clean(seg.idxPath) != cur {
+			continue
+		}
+		if st.IdxOffset == 0 && i > 0 {
+			i--
+		}
+		return segs[:i]
+	}
+	return nil
+}
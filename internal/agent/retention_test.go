@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWalRetention(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	tests := []struct {
+		name      string
+		ret       walRetention
+		committed int   // segment of the committed position
+		offset    int64 // committed offset in it
+		want      []bool
+	}{
+		{"max age", walRetention{maxAge: 24 * time.Hour}, 3, 10, []bool{false, false, false, true}},
+		{"max bytes", walRetention{maxBytes: 250}, 3, 10, []bool{false, false, true, true}},
+		{"never unacknowledged", walRetention{maxBytes: 1}, 2, 10, []bool{false, false, true, true}},
+		{"previous held until committed segment starts", walRetention{maxBytes: 1}, 2, 0, []bool{false, true, true, true}},
+		{"no rule broken", walRetention{maxAge: 72 * time.Hour, maxBytes: 1000}, 3, 10, []bool{true, true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walDir, stateDir := t.TempDir(), t.TempDir()
+			day := filepath.Join(walDir, "2025-12-05")
+			var gz []string
+			for i := 0; i < 4; i++ {
+				base := fmt.Sprintf("seg-%06d", i+1)
+				createSegment(t, day, base, 90, 10)
+				gz = append(gz, filepath.Join(day, base+".wal.gz"))
+				mtime := time.Now()
+				if i < 3 {
+					mtime = old
+				}
+				if err := os.Chtimes(gz[i], mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st := state{IdxPath: filepath.Join(day, fmt.Sprintf("seg-%06d.wal.idx", tt.committed+1)), IdxOffset: tt.offset}
+			if err := saveState(stateDir, st); err != nil {
+				t.Fatal(err)
+			}
+
+			walRetentionOnce(context.Background(), walDir, stateDir, nil, &tt.ret)
+
+			for i, want := range tt.want {
+				if got := pathExists(gz[i]); got != want {
+					t.Errorf("segment %d kept = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWalRetention_NoState(t *testing.T) {
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	createSegment(t, walDir, "seg-000001", 100, 10)
+	createSegment(t, walDir, "seg-000002", 100, 10)
+
+	walRetentionOnce(context.Background(), walDir, stateDir, nil, &walRetention{maxBytes: 1})
+
+	if !pathExists(filepath.Join(walDir, "seg-000001.wal.gz")) {
+		t.Fatal("segment removed before anything was shipped")
+	}
+}
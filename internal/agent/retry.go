@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryableError reports whether a failed upload may succeed if repeated:
+// network errors, timeouts, 408, 429 and 5xx responses. Other 4xx responses
+// and local errors are not retried.
+func retryableError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests
+	}
+	var re *requestError
+	return errors.As(err, &re)
+}
+
+// retryAfter returns the delay a response's Retry-After header asks for, in
+// seconds or as an HTTP date, else the retry_after of its structured error
+// body, or 0.
+func retryAfter(err error, now time.Time) time.Duration {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return 0
+	}
+	v := se.header.Get("Retry-After")
+	if v == "" {
+		if se.detail != nil {
+			return se.detail.RetryAfter
+		}
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// requestError wraps a failure to get any response from the service.
+type requestError struct{ err error }
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+// postWithRetry posts frames, retrying as retryPost does. With
+// splitOnTimeout a timeout is returned at once so the caller can split the
+// batch instead. Retries stop, returning the last error, once the
+// pipeline's send context is done.
+func postWithRetry(cfg Config, httpClient *http.Client, frames []batchFrame, curIdxBase string, splitOnTimeout bool) error {
+	ctx := activePipeline(cfg).sendContext()
+	attempts, err := retryPost(ctx, cfg, "batch", curIdxBase, func(ctx context.Context) error {
+		return postBatch(ctx, cfg, httpClient, frames, curIdxBase)
+	}, func(err error) bool { return splitOnTimeout && isTimeout(err) })
+	if err != nil && cfg.OnSendError != nil {
+		cfg.OnSendError(SendErrorEvent{Segment: curIdxBase, Frames: len(frames), Bytes: framesBytes(frames),
+			Attempts: attempts, StatusCode: statusCode(err), Retryable: retryableError(err), Server: asServerError(err), Err: err})
+	}
+	return err
+}
+
+// retryPost calls post, retrying transient failures up to SendMaxAttempts
+// in all with jittered exponential backoff between SendRetryBase and
+// SendRetryMax, or the server's Retry-After if longer (but still capped).
+// Errors stop accepts are returned at once. Each retry of the what upload
+// is logged, counted and passed to OnRetry with segment. It returns the
+// number of attempts made and the last error; retries stop once ctx is
+// done.
+func retryPost(ctx context.Context, cfg Config, what, segment string, post func(context.Context) error, stop func(error) bool) (int, error) {
+	back := newBackoff(cfg.SendRetryBase, cfg.SendRetryMax)
+	for attempt := 1; ; attempt++ {
+		err := post(ctx)
+		if err == nil {
+			return attempt, nil
+		}
+		if !retryableError(err) || attempt >= cfg.SendMaxAttempts || (stop != nil && stop(err)) {
+			return attempt, err
+		}
+
+		delay := back.next()
+		if ra := retryAfter(err, time.Now()); ra > delay {
+			delay = ra
+		}
+		if cfg.SendRetryMax > 0 && delay > cfg.SendRetryMax {
+			delay = cfg.SendRetryMax
+		}
+		logServerError(logger.Warn().Err(err), err).Int("attempt", attempt).Dur("delay", delay).Str("segment", segment).Msg("retrying " + what + " upload")
+		metricSendRetries.Inc()
+		if cfg.OnRetry != nil {
+			cfg.OnRetry(RetryEvent{Segment: segment, Attempt: attempt, Delay: delay, StatusCode: statusCode(err), Server: asServerError(err), Err: err})
+		}
+		if sleepCtx(ctx, delay, nil); ctx.Err() != nil {
+			return attempt, err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // per attempt; the last repeats
+		retryAfter   string
+		body         string
+		wantErr      bool
+		wantAttempts int
+		wantRetries  []time.Duration // minimum delay of each retry
+		wantFinal    *SendErrorEvent
+	}{
+		{name: "transient 503", statuses: []int{503, 200}, wantAttempts: 2, wantRetries: []time.Duration{0}},
+		{name: "client error not retried", statuses: []int{400}, wantErr: true, wantAttempts: 1,
+			wantFinal: &SendErrorEvent{Attempts: 1, StatusCode: 400, Retryable: false}},
+		{name: "gives up after max attempts", statuses: []int{500}, wantErr: true, wantAttempts: 3, wantRetries: []time.Duration{0, 0},
+			wantFinal: &SendErrorEvent{Attempts: 3, StatusCode: 500, Retryable: true}},
+		{name: "retry-after capped by max", statuses: []int{429, 200}, retryAfter: "60", wantAttempts: 2,
+			wantRetries: []time.Duration{20 * time.Millisecond}},
+		{name: "retry_after from structured body", statuses: []int{429, 200}, wantAttempts: 2,
+			body:        `{"error": {"code": "rate_limited", "message": "slow down", "retry_after": "15ms"}}`,
+			wantRetries: []time.Duration{15 * time.Millisecond}},
+		{name: "structured client error", statuses: []int{422}, body: `{"code": "bad_frame", "message": "frame 1 is corrupt"}`,
+			wantErr: true, wantAttempts: 1, wantFinal: &SendErrorEvent{Attempts: 1, StatusCode: 422, Retryable: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				code := tt.statuses[min(n, len(tt.statuses))-1]
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(code)
+				if code != 200 {
+					w.Write([]byte(tt.body))
+				}
+			}))
+			defer ts.Close()
+
+			var retries []RetryEvent
+			var final []SendErrorEvent
+			cfg := Config{ServiceURL: ts.URL, SendMaxAttempts: 3, SendRetryBase: time.Millisecond, SendRetryMax: 20 * time.Millisecond,
+				OnRetry:     func(ev RetryEvent) { retries = append(retries, ev) },
+				OnSendError: func(ev SendErrorEvent) { final = append(final, ev) }}
+			frames := []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("abc")}}
+
+			err := postWithRetry(cfg, http.DefaultClient, frames, "000.idx", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := int(attempts.Load()); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if len(retries) != len(tt.wantRetries) {
+				t.Fatalf("retries = %+v, want %d", retries, len(tt.wantRetries))
+			}
+			for i, ev := range retries {
+				if ev.Attempt != i+1 || ev.Delay < tt.wantRetries[i] || ev.Delay > cfg.SendRetryMax || ev.Err == nil {
+					t.Errorf("retry %d = %+v", i, ev)
+				}
+				if (ev.Server != nil) != (tt.body != "") {
+					t.Errorf("retry %d Server = %+v", i, ev.Server)
+				}
+			}
+			if tt.wantFinal == nil {
+				if len(final) != 0 {
+					t.Errorf("OnSendError called: %+v", final)
+				}
+				return
+			}
+			if len(final) != 1 {
+				t.Fatalf("OnSendError calls = %d, want 1", len(final))
+			}
+			got := final[0]
+			if got.Attempts != tt.wantFinal.Attempts || got.StatusCode != tt.wantFinal.StatusCode || got.Retryable != tt.wantFinal.Retryable ||
+				got.Segment != "000.idx" || got.Frames != 1 || got.Bytes != 3 {
+				t.Errorf("SendErrorEvent = %+v, want %+v", got, *tt.wantFinal)
+			}
+			if (got.Server != nil) != (tt.body != "") {
+				t.Errorf("SendErrorEvent.Server = %+v", got.Server)
+			}
+		})
+	}
+}
+
+func TestRetryableError_NetworkError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := ts.URL
+	ts.Close()
+
+	err := postBatch(context.Background(), Config{ServiceURL: url}, http.DefaultClient, []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}}}, "000.idx")
+	if err == nil || !retryableError(err) {
+		t.Fatalf("connection failure %v should be retryable", err)
+	}
+}
+
+func TestPostWithRetry_StopsWhenSendContextDone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir(), SendMaxAttempts: 5, SendRetryBase: time.Hour, SendRetryMax: time.Hour}
+	p := &pipeline{stateDir: cfg.StateDir}
+	p.sendCtx, p.cancelSend = context.WithCancel(context.Background())
+	registerPipeline(p)
+	defer unregisterPipeline(p)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- postWithRetry(cfg, ts.Client(), []batchFrame{{Meta: FrameMeta{File: "f", Frame: 1}, Compressed: []byte("abc")}}, "000.idx", false)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.cancelSend()
+	select {
+	case err := <-done:
+		if statusCode(err) != http.StatusServiceUnavailable {
+			t.Errorf("err = %v, want the last send error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("postWithRetry kept sleeping after the send context was done")
+	}
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// FrameFilter decides from its index line whether a frame is shipped.
+// Frames it rejects are skipped before they are read.
+type FrameFilter func(FrameMeta) bool
+
+// EveryNthFrame is a FrameFilter keeping the frames whose number is a
+// multiple of n.
+func EveryNthFrame(n uint64) FrameFilter {
+	return func(fm FrameMeta) bool { return n <= 1 || fm.Frame%n == 0 }
+}
+
+// frameSampler applies Config.FrameFilter and the built-in samplers. A frame
+// is shipped only if every one of them keeps it.
+type frameSampler struct {
+	filter    FrameFilter
+	everyN    uint64
+	types     map[consensus.MessageType]bool
+	heightMod int64
+	// detail names the samplers set, for the tombstones of the frames
+	// they reject.
+	detail string
+}
+
+// newFrameSampler returns the sampler cfg asks for, or nil if every frame
+// is shipped.
+func newFrameSampler(cfg Config) *frameSampler {
+	types, _ := parseMessageTypes(cfg.SampleTypes)
+	if cfg.FrameFilter == nil && cfg.SampleEveryN <= 1 && len(types) == 0 && cfg.SampleHeightModulo <= 1 {
+		return nil
+	}
+	s := &frameSampler{filter: cfg.FrameFilter, types: types}
+	if cfg.SampleEveryN > 1 {
+		s.everyN = uint64(cfg.SampleEveryN)
+	}
+	if cfg.SampleHeightModulo > 1 {
+		s.heightMod = int64(cfg.SampleHeightModulo)
+	}
+	var parts []string
+	if s.filter != nil {
+		parts = append(parts, "frame filter")
+	}
+	if s.everyN > 0 {
+		parts = append(parts, fmt.Sprintf("one frame in %d", s.everyN))
+	}
+	if len(types) > 0 {
+		var names []string
+		for _, t := range consensus.MessageTypes {
+			if types[t] {
+				names = append(names, string(t))
+			}
+		}
+		parts = append(parts, "types "+strings.Join(names, ","))
+	}
+	if s.heightMod > 0 {
+		parts = append(parts, fmt.Sprintf("height modulo %d", s.heightMod))
+	}
+	s.detail = strings.Join(parts, ", ")
+	return s
+}
+
+// keepMeta applies the samplers that need only the index line.
+func (s *frameSampler) keepMeta(fm FrameMeta) bool {
+	if s == nil {
+		return true
+	}
+	if s.everyN > 0 && fm.Frame%s.everyN != 0 {
+		return false
+	}
+	return s.filter == nil || s.filter(fm)
+}
+
+// needsContent reports whether keepContent has anything to check.
+func (s *frameSampler) needsContent() bool {
+	return s != nil && (len(s.types) > 0 || s.heightMod > 0)
+}
+
+// keepContent applies the samplers that look at the frame's records: types
+// are the frame's record counts by message type. A frame that cannot be
+// decompressed is kept.
+func (s *frameSampler) keepContent(compressed []byte, types map[consensus.MessageType]int) bool {
+	if !s.needsContent() {
+		return true
+	}
+	var raw []byte
+	if types == nil || s.heightMod > 0 {
+		var err error
+		if raw, err = wal.Decompress(compressed); err != nil {
+			return true
+		}
+	}
+	if len(s.types) > 0 {
+		if types == nil {
+			types = consensus.CountTypes(raw)
+		}
+		if !slices.ContainsFunc(consensus.MessageTypes, func(t consensus.MessageType) bool { return s.types[t] && types[t] > 0 }) {
+			return false
+		}
+	}
+	return s.heightMod == 0 || keepHeight(raw, s.heightMod)
+}
+
+// sampledRun gathers consecutive frames the sampler rejected into one
+// tombstone, so that a sampler rejecting most frames queues a tombstone per
+// run of them instead of rewriting the queue for every frame.
+type sampledRun struct {
+	t    Tombstone
+	open bool
+}
+
+// add records fm, a frame of the index file idxPath, as rejected by s.
+func (r *sampledRun) add(cfg Config, s *frameSampler, idxPath string, fm FrameMeta) {
+	t := Tombstone{Segment: filepath.Base(idxPath), File: fm.File, FirstFrame: fm.Frame, LastFrame: fm.Frame,
+		Frames: 1, Bytes: int64(fm.Len), Reason: TombstoneSampled, Detail: s.detail}
+	if r.open && t.extends(r.t) {
+		r.t.LastFrame = t.LastFrame
+		r.t.Frames++
+		r.t.Bytes += t.Bytes
+		return
+	}
+	r.flush(cfg)
+	t.At = time.Now().UTC()
+	r.t, r.open = t, true
+}
+
+// flush queues the gathered tombstone, if any.
+func (r *sampledRun) flush(cfg Config) {
+	if r.open {
+		addTombstone(cfg, r.t)
+		r.open = false
+	}
+}
+
+// keepHeight reports whether any proposal, vote or block part in records
+// belongs to a height that is a multiple of mod. Frames without any are
+// kept, as they carry no height to sample by.
+func keepHeight(records []byte, mod int64) bool {
+	found := false
+	for len(records) > 0 {
+		line := records
+		if i := bytes.IndexByte(records, '\n'); i >= 0 {
+			line, records = records[:i], records[i+1:]
+		} else {
+			records = nil
+		}
+		ev, err := consensus.Decode(bytes.TrimSpace(line))
+		if err != nil || ev.Height() <= 0 {
+			continue
+		}
+		if ev.Height()%mod == 0 {
+			return true
+		}
+		found = true
+	}
+	return !found
+}
+
+// parseMessageTypes parses a comma-separated list of message types.
+func parseMessageTypes(s string) (map[consensus.MessageType]bool, error) {
+	types := make(map[consensus.MessageType]bool)
+	for _, name := range strings.Split(s, ",") {
+		t := consensus.MessageType(strings.TrimSpace(name))
+		if t == "" {
+			continue
+		}
+		if !slices.Contains(consensus.MessageTypes, t) {
+			return nil, fmt.Errorf("unknown message type %q", t)
+		}
+		types[t] = true
+	}
+	return types, nil
+}
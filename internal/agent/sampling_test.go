@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameSampler(t *testing.T) {
+	vote := func(height int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,"height":"%d","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`, height)
+	}
+	const timeout = `{"time":"2024-01-01T00:00:01Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{}}}`
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		frame   uint64
+		records []string
+		want    bool
+	}{
+		{"no sampling", Config{}, 3, []string{vote(3)}, true},
+		{"every nth kept", Config{SampleEveryN: 5}, 10, []string{vote(3)}, true},
+		{"every nth dropped", Config{SampleEveryN: 5}, 11, []string{vote(3)}, false},
+		{"filter", Config{FrameFilter: EveryNthFrame(2)}, 3, []string{vote(3)}, false},
+		{"type kept", Config{SampleTypes: "proposal, vote"}, 1, []string{timeout, vote(3)}, true},
+		{"type dropped", Config{SampleTypes: "vote"}, 1, []string{timeout}, false},
+		{"height kept", Config{SampleHeightModulo: 10}, 1, []string{vote(19), vote(20)}, true},
+		{"height dropped", Config{SampleHeightModulo: 10}, 1, []string{vote(19), vote(21)}, false},
+		{"no height kept", Config{SampleHeightModulo: 10}, 1, []string{timeout}, true},
+		{"all must keep", Config{SampleEveryN: 1, SampleTypes: "timeout", SampleHeightModulo: 10}, 1, []string{timeout, vote(21)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFrameSampler(tt.cfg)
+			got := s.keepMeta(FrameMeta{Frame: tt.frame}) && s.keepContent(gzipFrame(t, tt.records...), nil)
+			if got != tt.want {
+				t.Errorf("kept = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if newFrameSampler(Config{SampleEveryN: 1}) != nil {
+		t.Error("sampling every frame built a sampler")
+	}
+}
+
+func TestRun_TombstonesSampledFrames(t *testing.T) {
+	const (
+		timeout = `{"time":"2024-01-01T00:00:01Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{}}}`
+		vote    = `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,"height":"3","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`
+	)
+	walDir := t.TempDir()
+	var data []byte
+	var metas []FrameMeta
+	for i, rec := range []string{timeout, timeout, vote, vote} {
+		b := gzipFrame(t, rec)
+		metas = append(metas, FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1), Off: uint64(len(data)), Len: uint64(len(b))})
+		data = append(data, b...)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), metas)
+
+	var mu sync.Mutex
+	var tombstones []Tombstone
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tombstonesEndpoint {
+			return
+		}
+		var body struct{ Tombstones []Tombstone }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		tombstones = append(tombstones, body.Tombstones...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	// Frames 1-2 fail the type sampler, which reads them; frame 4 fails
+	// the filter, which does not.
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond,
+		SampleTypes: "vote", FrameFilter: func(fm FrameMeta) bool { return fm.Frame != 4 }}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"frames 1-2 of seg-000001.wal.idx (sampled: frame filter, types vote)",
+		"frame 4 of seg-000001.wal.idx (sampled: frame filter, types vote)",
+	}
+	if len(tombstones) != len(want) {
+		t.Fatalf("tombstones = %+v", tombstones)
+	}
+	for i, w := range want {
+		if got := tombstones[i].describe(); got != w {
+			t.Errorf("tombstone %d = %q, want %q", i, got, w)
+		}
+	}
+	if b := tombstones[0].Bytes; b != int64(metas[0].Len+metas[1].Len) {
+		t.Errorf("bytes = %d, want both frames'", b)
+	}
+}
@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Scraper collects one kind of data on a fixed interval and ships it.
+// Collect and Ship must honor ctx, which carries the per-scrape deadline.
+type Scraper interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) (any, error)
+	Ship(ctx context.Context, data any) error
+}
+
+var (
+	// scrapeJitter spreads each scrape by up to this fraction of the interval
+	// so scrapers registered together do not fire in lockstep.
+	scrapeJitter    = 0.1
+	scrapeRetryBase = time.Second
+	scrapeRetryMax  = 30 * time.Second
+)
+
+// scraperFunc runs a scraper until ctx is cancelled.
+type scraperFunc func(ctx context.Context)
+
+// scheduleScraper returns a scraperFunc running s on its interval. The first
+// scrape is delayed by a random fraction of the interval to stagger scrapers.
+// The outcome of each scrape is recorded in h, if not nil.
+func scheduleScraper(s Scraper, h *scraperHealth) scraperFunc {
+	return func(ctx context.Context) {
+		interval := s.Interval()
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(interval) + 1)))
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			err := scrapeOnce(ctx, s)
+			if ctx.Err() == nil {
+				h.set(err)
+			}
+			t.Reset(jitter(interval, scrapeJitter))
+		}
+	}
+}
+
+// scrapeOnce runs one Collect/Ship cycle, bounded by the scraper's interval.
+// A failed Ship is retried with backoff until it succeeds or time runs out.
+func scrapeOnce(ctx context.Context, s Scraper) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Interval())
+	defer cancel()
+
+	data, err := s.Collect(ctx)
+	if err != nil {
+		logger.Warn().Err(err).Str("scraper", s.Name()).Msg("scrape failed")
+		return err
+	}
+	back := newBackoff(scrapeRetryBase, scrapeRetryMax)
+	for {
+		err = s.Ship(ctx, data)
+		if err == nil {
+			return nil
+		}
+		logger.Warn().Err(err).Str("scraper", s.Name()).Msg("scraper ship failed")
+		if back.Wait(ctx) != nil {
+			return err
+		}
+	}
+}
+
+// scraperHealth is the health of a scheduled scraper: unhealthy while its
+// last scrape failed, else as the scraper reports it if it is a
+// HealthReporter.
+type scraperHealth struct {
+	s Scraper
+
+	mu  sync.Mutex
+	err error
+}
+
+func (h *scraperHealth) set(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.err = err
+	h.mu.Unlock()
+}
+
+func (h *scraperHealth) Health() error {
+	h.mu.Lock()
+	err := h.err
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if r, ok := h.s.(HealthReporter); ok {
+		return r.Health()
+	}
+	return nil
+}
+
+// jitter returns d adjusted by a random amount of up to +/-frac.
+func jitter(d time.Duration, frac float64) time.Duration {
+	return time.Duration(float64(d) * (1 + frac*(2*rand.Float64()-1)))
+}
+
+// scraperManager owns the auxiliary scrapers that run beside the WAL pipeline
+// and starts or stops them individually while the agent is running.
+type scraperManager struct {
+	parent context.Context
+
+	mu      sync.Mutex
+	entries map[string]*managedScraper
+}
+
+type managedScraper struct {
+	run    scraperFunc
+	health HealthReporter     // nil if the scraper cannot tell
+	cancel context.CancelFunc // nil while stopped
+	done   chan struct{}
+}
+
+func newScraperManager(ctx context.Context) *scraperManager {
+	return &scraperManager{parent: ctx, entries: make(map[string]*managedScraper)}
+}
+
+// Register adds a scraper under name and starts it if enabled.
+func (m *scraperManager) Register(name string, run scraperFunc, enabled bool) {
+	m.register(name, run, nil, enabled)
+}
+
+// RegisterScraper adds s under its name, run by the shared scheduler, which
+// tracks its health.
+func (m *scraperManager) RegisterScraper(s Scraper, enabled bool) {
+	h := &scraperHealth{s: s}
+	m.register(s.Name(), scheduleScraper(s, h), h, enabled)
+}
+
+func (m *scraperManager) register(name string, run scraperFunc, health HealthReporter, enabled bool) {
+	m.mu.Lock()
+	m.entries[name] = &managedScraper{run: run, health: health}
+	m.mu.Unlock()
+	if enabled {
+		_ = m.SetEnabled(name, true)
+	}
+}
+
+// SetEnabled starts or stops the named scraper. Stopping waits for it to exit.
+func (m *scraperManager) SetEnabled(name string, enabled bool) error {
+	m.mu.Lock()
+	e, ok := m.entries[name]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown scraper %q", name)
+	}
+	if enabled == (e.cancel != nil) {
+		m.mu.Unlock()
+		return nil
+	}
+	if enabled {
+		ctx, cancel := context.WithCancel(m.parent)
+		done := make(chan struct{})
+		e.cancel, e.done = cancel, done
+		m.mu.Unlock()
+		go func() {
+			defer close(done)
+			e.run(ctx)
+		}()
+		logger.Info().Str("scraper", name).Msg("scraper enabled")
+		return nil
+	}
+	cancel, done := e.cancel, e.done
+	e.cancel, e.done = nil, nil
+	m.mu.Unlock()
+	cancel()
+	<-done
+	logger.Info().Str("scraper", name).Msg("scraper disabled")
+	return nil
+}
+
+// Replace swaps the named scraper's function, restarting the scraper if it
+// is running.
+func (m *scraperManager) Replace(name string, run scraperFunc) {
+	enabled := m.States()[name]
+	_ = m.SetEnabled(name, false)
+	m.Register(name, run, enabled)
+}
+
+// ReplaceScraper swaps the scraper registered under s's name for s,
+// restarting it if it is running.
+func (m *scraperManager) ReplaceScraper(s Scraper) {
+	enabled := m.States()[s.Name()]
+	_ = m.SetEnabled(s.Name(), false)
+	m.RegisterScraper(s, enabled)
+}
+
+// StopAll stops every running scraper and waits for them to exit.
+func (m *scraperManager) StopAll() {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.entries))
+	for name := range m.entries {
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	for _, name := range names {
+		_ = m.SetEnabled(name, false)
+	}
+}
+
+// States reports whether each registered scraper is running.
+func (m *scraperManager) States() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]bool, len(m.entries))
+	for name, e := range m.entries {
+		out[name] = e.cancel != nil
+	}
+	return out
+}
+
+// Health returns the health of each running scraper that can tell; nil
+// values are healthy.
+func (m *scraperManager) Health() map[string]error {
+	m.mu.Lock()
+	reporters := map[string]HealthReporter{}
+	for name, e := range m.entries {
+		if e.cancel != nil && e.health != nil {
+			reporters[name] = e.health
+		}
+	}
+	m.mu.Unlock()
+	out := make(map[string]error, len(reporters))
+	for name, r := range reporters {
+		out[name] = r.Health()
+	}
+	return out
+}
+
+// SetScraperEnabled enables or disables a scraper of the running agent, on
+// every node it ships, without restarting the WAL pipelines.
+func SetScraperEnabled(name string, enabled bool) error {
+	ps := runningPipelines()
+	if len(ps) == 0 {
+		return fmt.Errorf("agent is not running")
+	}
+	for _, p := range ps {
+		if err := p.scrapers.SetEnabled(name, enabled); err != nil {
+			return err
+		}
+	}
+	verb := "disabled"
+	if enabled {
+		verb = "enabled"
+	}
+	recordEvent(EventState, "scraper "+name+" "+verb)
+	return nil
+}
+
+// Scrapers reports the running agent's scrapers and whether each is enabled
+// on any of its nodes.
+func Scrapers() map[string]bool {
+	ps := runningPipelines()
+	if len(ps) == 0 {
+		return nil
+	}
+	out := map[string]bool{}
+	for _, p := range ps {
+		for name, on := range p.scrapers.States() {
+			out[name] = out[name] || on
+		}
+	}
+	return out
+}
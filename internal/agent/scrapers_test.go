@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScraperManager_EnableDisable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newScraperManager(ctx)
+
+	var starts, running atomic.Int32
+	m.Register("test", func(ctx context.Context) {
+		starts.Add(1)
+		running.Add(1)
+		defer running.Add(-1)
+		<-ctx.Done()
+	}, false)
+
+	if m.States()["test"] {
+		t.Fatal("registered disabled scraper is running")
+	}
+	if err := m.SetEnabled("test", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetEnabled("test", true); err != nil { // no-op
+		t.Fatal(err)
+	}
+	if !m.States()["test"] {
+		t.Error("scraper should be enabled")
+	}
+	if err := m.SetEnabled("test", false); err != nil {
+		t.Fatal(err)
+	}
+	if running.Load() != 0 {
+		t.Error("disable should wait for the scraper to exit")
+	}
+	if err := m.SetEnabled("test", true); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.SetEnabled("test", false)
+	if got := starts.Load(); got != 2 {
+		t.Errorf("starts = %d, want 2", got)
+	}
+
+	if err := m.SetEnabled("missing", true); err == nil {
+		t.Error("expected error for unknown scraper")
+	}
+}
+
+func TestSetScraperEnabled_NotRunning(t *testing.T) {
+	if err := SetScraperEnabled("config", true); err == nil {
+		t.Error("expected error when no agent is running")
+	}
+	if Scrapers() != nil {
+		t.Error("Scrapers should be nil when no agent is running")
+	}
+}
+
+type fakeScraper struct {
+	interval   time.Duration
+	collectErr error
+	shipErrs   int // Ship fails this many times before succeeding
+	block      bool
+
+	collects, ships atomic.Int32
+}
+
+func (f *fakeScraper) Name() string            { return "fake" }
+func (f *fakeScraper) Interval() time.Duration { return f.interval }
+
+func (f *fakeScraper) Collect(ctx context.Context) (any, error) {
+	f.collects.Add(1)
+	return "data", f.collectErr
+}
+
+func (f *fakeScraper) Ship(ctx context.Context, data any) error {
+	n := f.ships.Add(1)
+	if f.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if int(n) <= f.shipErrs {
+		return errors.New("ship failed")
+	}
+	return nil
+}
+
+func TestScrapeOnce_RetriesShip(t *testing.T) {
+	oldBase := scrapeRetryBase
+	scrapeRetryBase = time.Millisecond
+	defer func() { scrapeRetryBase = oldBase }()
+
+	f := &fakeScraper{interval: time.Second, shipErrs: 2}
+	if err := scrapeOnce(context.Background(), f); err != nil {
+		t.Fatalf("scrapeOnce: %v", err)
+	}
+	if f.collects.Load() != 1 || f.ships.Load() != 3 {
+		t.Errorf("collects = %d, ships = %d; want 1 and 3", f.collects.Load(), f.ships.Load())
+	}
+}
+
+func TestScrapeOnce_CollectErrorSkipsShip(t *testing.T) {
+	f := &fakeScraper{interval: time.Second, collectErr: errors.New("boom")}
+	if err := scrapeOnce(context.Background(), f); err == nil {
+		t.Fatal("expected collect error")
+	}
+	if f.ships.Load() != 0 {
+		t.Error("Ship should not run after a failed Collect")
+	}
+}
+
+func TestScrapeOnce_TimesOutAfterInterval(t *testing.T) {
+	f := &fakeScraper{interval: 20 * time.Millisecond, block: true}
+	start := time.Now()
+	err := scrapeOnce(context.Background(), f)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("scrape took %v, want it bounded by the interval", elapsed)
+	}
+}
+
+func TestScheduleScraper_RunsRepeatedly(t *testing.T) {
+	f := &fakeScraper{interval: 5 * time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	scheduleScraper(f, nil)(ctx)
+	if n := f.collects.Load(); n < 3 {
+		t.Errorf("collects = %d, want several", n)
+	}
+}
+
+func TestJitter_WithinBounds(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second, 0.1)
+		if d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Fatalf("jitter = %v, outside +/-10%%", d)
+		}
+	}
+}
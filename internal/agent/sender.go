@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/tracing"
+)
+
+// minSplitBytes is the batch size below which a timed-out upload is no longer
+// split further; at that point the link is considered down, not marginal.
+var minSplitBytes = 64 << 10
+
+// statusError is a non-2xx response from the ingestion service. detail is
+// set when the body was a structured error.
+type statusError struct {
+	code   int
+	body   string
+	header http.Header
+	detail *ServerError
+}
+
+func (e *statusError) Error() string {
+	if e.detail != nil {
+		return fmt.Sprintf("server returned %d: %s", e.code, e.detail)
+	}
+	return fmt.Sprintf("server returned %d: %s", e.code, errorText(e.body))
+}
+
+func (e *statusError) Unwrap() error {
+	if e.detail == nil {
+		return nil
+	}
+	return e.detail
+}
+
+// sendSplitting posts frames as one batch. If the upload times out, the batch
+// is halved and each half retried in order, recursively, until pieces reach
+// minSplitBytes or a single frame. It returns how many leading frames were
+// accepted; those must be committed even when err is non-nil. Each upload is
+// journaled in j, if set, before it is sent.
+func sendSplitting(cfg Config, httpClient *http.Client, frames []batchFrame, curIdxBase string, j *batchJournal) (int, error) {
+	canSplit := len(frames) >= 2 && framesBytes(frames) > minSplitBytes
+	j.begin(frames, curIdxBase)
+	err := postWithRetry(cfg, httpClient, frames, curIdxBase, canSplit)
+	j.end(err)
+	if err == nil {
+		return len(frames), nil
+	}
+	if !isTimeout(err) || !canSplit {
+		return 0, err
+	}
+
+	mid := len(frames) / 2
+	logger.Info().
+		Int("frames", len(frames)).
+		Int("bytes", framesBytes(frames)).
+		Msg("upload timed out, splitting batch")
+
+	n, err := sendSplitting(cfg, httpClient, frames[:mid], curIdxBase, j)
+	if err != nil {
+		return n, err
+	}
+	m, err := sendSplitting(cfg, httpClient, frames[mid:], curIdxBase, j)
+	return n + m, err
+}
+
+// postBatch uploads frames as a multipart manifest + concatenated gzip members.
+func postBatch(ctx context.Context, cfg Config, httpClient *http.Client, frames []batchFrame, curIdxBase string) error {
+	hash := batchHash(frames)
+	compress := startSpan("walship.compress", tracing.KindInternal, clientSpan(httpClient), time.Time{},
+		tracing.String("walship.encoding", cfg.FrameEncoding))
+	var dictID uint32
+	if cfg.FrameEncoding == FrameEncodingZstd {
+		var err error
+		if frames, dictID, err = zstdFrames(cfg, httpClient, frames); err != nil {
+			compress.SetError(err)
+			compress.End()
+			return fmt.Errorf("zstd encode frames: %w", err)
+		}
+	}
+	manifest := make([]FrameMeta, 0, len(frames))
+	for _, fr := range frames {
+		manifest = append(manifest, fr.Meta)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	manifestPart, err := writer.CreateFormField("manifest")
+	if err != nil {
+		return fmt.Errorf("create manifest field: %w", err)
+	}
+	if _, err := manifestPart.Write(manifestJSON); err != nil {
+		return fmt.Errorf("write manifest field: %w", err)
+	}
+	if types := batchTypes(frames); types != nil {
+		typesJSON, err := json.Marshal(types)
+		if err != nil {
+			return fmt.Errorf("marshal frame types: %w", err)
+		}
+		if err := writer.WriteField("frame_types", string(typesJSON)); err != nil {
+			return fmt.Errorf("write frame_types field: %w", err)
+		}
+	}
+	if cfg.FrameEncoding == FrameEncodingZstd {
+		if err := writer.WriteField("encoding", FrameEncodingZstd); err != nil {
+			return fmt.Errorf("write encoding field: %w", err)
+		}
+		if dictID != 0 {
+			if err := writer.WriteField("zstd_dict_id", strconv.FormatUint(uint64(dictID), 10)); err != nil {
+				return fmt.Errorf("write zstd_dict_id field: %w", err)
+			}
+		}
+	}
+
+	framesPart, err := writer.CreateFormFile("frames", curIdxBase)
+	if err != nil {
+		return fmt.Errorf("create frames field: %w", err)
+	}
+	for _, fr := range frames {
+		if _, err := framesPart.Write(fr.Compressed); err != nil {
+			return fmt.Errorf("write frames payload: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("finalize multipart payload: %w", err)
+	}
+	compress.SetAttrs(tracing.Int("walship.body_bytes", int64(body.Len())))
+	compress.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ingestURL(cfg, walFramesEndpoint), &body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set(batchIDHeader, batchID(cfg, curIdxBase, hash, frames))
+	req.Header.Set(batchHashHeader, hash)
+	throttleBody(req, cfg)
+
+	resp, err := pacedClient(httpClient, req, cfg).Do(req)
+	if err != nil {
+		return &requestError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
+
+// ingestURL returns the URL of endpoint, one of the ingest endpoint paths,
+// with cfg.IngestPathPrefix in place of DefaultIngestPathPrefix.
+func ingestURL(cfg Config, endpoint string) string {
+	prefix := cfg.IngestPathPrefix
+	if prefix == "" {
+		prefix = DefaultIngestPathPrefix
+	}
+	return cfg.ServiceURL + strings.TrimSuffix(prefix, "/") + strings.TrimPrefix(endpoint, DefaultIngestPathPrefix)
+}
+
+// setAgentHeaders sets the authentication and agent identity headers sent
+// with every ingest request. The credential matches the chain ID sent, and the
+// hostname is withheld when anonymizing.
+func setAgentHeaders(req *http.Request, cfg Config) {
+	for k, v := range agentHeaders(cfg) {
+		req.Header.Set(k, v)
+	}
+}
+
+// agentHeaders returns the headers set by setAgentHeaders; gRPC streams send
+// them as metadata.
+func agentHeaders(cfg Config) map[string]string {
+	h := map[string]string{
+		"Authorization":              "Bearer " + authKeyFor(cfg, cfg.ChainID),
+		"X-Agent-OSArch":             runtime.GOOS + "/" + runtime.GOARCH,
+		"X-Cosmos-Analyzer-Chain-Id": cfg.ChainID,
+		"X-Cosmos-Analyzer-Node-Id":  cfg.NodeID,
+	}
+	if !cfg.Anonymize {
+		h["X-Agent-Hostname"] = hostname()
+	}
+	return h
+}
+
+// isTimeout reports whether err means the upload ran out of time, either on
+// the client side or as reported by a proxy/gateway.
+func isTimeout(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusRequestTimeout || se.code == http.StatusGatewayTimeout
+	}
+	return false
+}
+
+// statusCode returns the HTTP status carried by err, or 0.
+func statusCode(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code
+	}
+	return 0
+}
+
+func framesBytes(frames []batchFrame) int {
+	n := 0
+	for _, fr := range frames {
+		n += len(fr.Compressed)
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func splitTestBatch() []batchFrame {
+	var batch []batchFrame
+	for i := 1; i <= 4; i++ {
+		batch = append(batch, batchFrame{
+			Meta:       FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i)},
+			Compressed: make([]byte, 1000),
+			IdxLineLen: 10,
+		})
+	}
+	return batch
+}
+
+func TestTrySend_SplitsOnTimeout(t *testing.T) {
+	oldFloor := minSplitBytes
+	minSplitBytes = 0
+	defer func() { minSplitBytes = oldFloor }()
+
+	var mu sync.Mutex
+	var sizes []int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sizes = append(sizes, r.ContentLength)
+		mu.Unlock()
+		if r.ContentLength > 3000 { // more than two frames: too slow for the link
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir()}
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	batch := splitTestBatch()
+	batchBytes := 4000
+	st := state{}
+	back := newBackoff(time.Millisecond, time.Second)
+
+	trySend(cfg, client, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), back)
+
+	if len(batch) != 0 || batchBytes != 0 {
+		t.Errorf("batch = %d frames / %d bytes, want empty", len(batch), batchBytes)
+	}
+	if st.IdxOffset != 40 || st.LastFrame != 4 {
+		t.Errorf("state = offset %d frame %d, want 40 / 4", st.IdxOffset, st.LastFrame)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) != 3 {
+		t.Errorf("requests = %d, want 3 (one timeout, two halves)", len(sizes))
+	}
+}
+
+func TestTrySend_SplitCommitsAcceptedPrefix(t *testing.T) {
+	oldFloor := minSplitBytes
+	minSplitBytes = 0
+	defer func() { minSplitBytes = oldFloor }()
+
+	var mu sync.Mutex
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		switch {
+		case r.ContentLength > 3000:
+			time.Sleep(200 * time.Millisecond)
+		case n == 3: // second half is rejected
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, StateDir: t.TempDir()}
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	batch := splitTestBatch()
+	batchBytes := 4000
+	st := state{}
+	back := newBackoff(time.Millisecond, time.Second)
+
+	trySend(cfg, client, &batch, &batchBytes, &st, "seg-000001.wal.idx", nil, time.Now(), back)
+
+	if len(batch) != 2 || batch[0].Meta.Frame != 3 {
+		t.Fatalf("remaining batch = %d frames, want frames 3..4", len(batch))
+	}
+	if batchBytes != 2000 {
+		t.Errorf("batchBytes = %d, want 2000", batchBytes)
+	}
+	if st.IdxOffset != 20 || st.LastFrame != 2 {
+		t.Errorf("state = offset %d frame %d, want 20 / 2", st.IdxOffset, st.LastFrame)
+	}
+}
+
+func TestSendSplitting_NoSplitBelowFloor(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cfg := Config{ServiceURL: ts.URL}
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	n, err := sendSplitting(cfg, client, splitTestBatch(), "seg-000001.wal.idx", nil)
+	if n != 0 || err == nil {
+		t.Errorf("sendSplitting() = %d, %v; want 0 and a timeout", n, err)
+	}
+	ts.Close() // waits for the handler
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1 (batch is below the default split floor)", calls)
+	}
+}
+
+func TestAgentHeaders(t *testing.T) {
+	cfg := Config{AuthKey: "k", ChainID: "c", NodeID: "n"}
+	h := agentHeaders(cfg)
+	if h["Authorization"] != "Bearer k" || h["X-Cosmos-Analyzer-Chain-Id"] != "c" || h["X-Cosmos-Analyzer-Node-Id"] != "n" || h["X-Agent-Hostname"] == "" {
+		t.Errorf("headers = %v", h)
+	}
+	cfg.Anonymize = true
+	if _, ok := agentHeaders(cfg)["X-Agent-Hostname"]; ok {
+		t.Error("hostname should be withheld when anonymizing")
+	}
+}
+
+func TestIngestURL(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "http://svc/v1/ingest/wal-frames/sessions"},
+		{DefaultIngestPathPrefix, "http://svc/v1/ingest/wal-frames/sessions"},
+		{"/analyzer/ingest", "http://svc/analyzer/ingest/wal-frames/sessions"},
+		{"/", "http://svc/wal-frames/sessions"},
+	}
+	for _, tt := range tests {
+		cfg := Config{ServiceURL: "http://svc", IngestPathPrefix: tt.prefix}
+		if got := ingestURL(cfg, walSessionsEndpoint); got != tt.want {
+			t.Errorf("prefix %q: ingestURL = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+}
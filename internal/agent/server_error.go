@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 4 << 10
+
+// maxErrorText bounds how much of an unstructured error body is quoted in
+// an error message.
+const maxErrorText = 256
+
+// ServerError is a structured error the ingestion service sent in the body
+// of a non-2xx response:
+//
+//	{"code": "rate_limited", "message": "...", "retry_after": 30, "hint": "..."}
+//
+// The fields may also be nested under "error". Upload errors passed to
+// OnSendError and OnRetry wrap it, so callers can match it with errors.As.
+type ServerError struct {
+	Status  int    // HTTP status code
+	Code    string // machine-readable error code
+	Message string
+	// RetryAfter is how long the service asks to wait before retrying;
+	// retry_after may be given in seconds or as a duration like "1m30s".
+	RetryAfter time.Duration
+	Hint       string // what the operator may do about it
+}
+
+func (e *ServerError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Code)
+	if e.Message != "" {
+		if b.Len() > 0 {
+			b.WriteString(": ")
+		}
+		b.WriteString(e.Message)
+	}
+	if e.Hint != "" {
+		fmt.Fprintf(&b, " (hint: %s)", e.Hint)
+	}
+	return b.String()
+}
+
+// serverErrorBody is the JSON shape of a ServerError.
+type serverErrorBody struct {
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	RetryAfter json.RawMessage `json:"retry_after"`
+	Hint       string          `json:"hint"`
+}
+
+// parseServerError decodes a structured error body, or returns nil if body
+// is not one.
+func parseServerError(status int, body []byte) *ServerError {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return nil
+	}
+	var v struct {
+		serverErrorBody
+		Error *serverErrorBody `json:"error"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil
+	}
+	b := v.serverErrorBody
+	if v.Error != nil {
+		b = *v.Error
+	}
+	if b.Code == "" && b.Message == "" {
+		return nil
+	}
+	return &ServerError{Status: status, Code: b.Code, Message: b.Message, Hint: b.Hint,
+		RetryAfter: parseRetryAfterValue(b.RetryAfter)}
+}
+
+// parseRetryAfterValue reads retry_after as seconds, as a number or string,
+// or as a Go duration string.
+func parseRetryAfterValue(raw json.RawMessage) time.Duration {
+	if len(raw) == 0 {
+		return 0
+	}
+	var secs float64
+	if err := json.Unmarshal(raw, &secs); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n > 0 {
+		return time.Duration(n * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+// newStatusError reads the error response resp, which the caller still
+// closes, into a statusError.
+func newStatusError(resp *http.Response) *statusError {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &statusError{code: resp.StatusCode, body: string(b), header: resp.Header,
+		detail: parseServerError(resp.StatusCode, b)}
+}
+
+// asServerError returns the structured error the service sent with err, or
+// nil.
+func asServerError(err error) *ServerError {
+	var se *ServerError
+	if errors.As(err, &se) {
+		return se
+	}
+	return nil
+}
+
+// logServerError adds the status and the service's structured error, or an
+// excerpt of its body, in err to ev.
+func logServerError(ev *zerolog.Event, err error) *zerolog.Event {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return ev
+	}
+	ev = ev.Int("status", se.code)
+	if d := se.detail; d != nil {
+		ev = ev.Str("code", d.Code).Str("message", d.Message)
+		if d.Hint != "" {
+			ev = ev.Str("hint", d.Hint)
+		}
+		if d.RetryAfter > 0 {
+			ev = ev.Dur("retry_after", d.RetryAfter)
+		}
+		return ev
+	}
+	return ev.Str("body", errorText(se.body))
+}
+
+// errorText shortens an unstructured error body for messages and logs.
+func errorText(body string) string {
+	body = strings.Join(strings.Fields(body), " ")
+	if len(body) > maxErrorText {
+		body = body[:maxErrorText] + "..."
+	}
+	return body
+}
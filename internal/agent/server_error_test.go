@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServerError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *ServerError
+	}{
+		{name: "flat", body: `{"code": "rate_limited", "message": "too many uploads", "retry_after": 30, "hint": "raise send-interval"}`,
+			want: &ServerError{Status: 429, Code: "rate_limited", Message: "too many uploads", RetryAfter: 30 * time.Second, Hint: "raise send-interval"}},
+		{name: "nested", body: `{"error": {"code": "bad_key", "message": "unknown key"}}`,
+			want: &ServerError{Status: 429, Code: "bad_key", Message: "unknown key"}},
+		{name: "retry_after string seconds", body: `{"code": "busy", "retry_after": "2.5"}`,
+			want: &ServerError{Status: 429, Code: "busy", RetryAfter: 2500 * time.Millisecond}},
+		{name: "retry_after duration", body: `{"message": "busy", "retry_after": "1m"}`,
+			want: &ServerError{Status: 429, Message: "busy", RetryAfter: time.Minute}},
+		{name: "bad retry_after ignored", body: `{"code": "busy", "retry_after": "later"}`,
+			want: &ServerError{Status: 429, Code: "busy"}},
+		{name: "plain text", body: "rate limited"},
+		{name: "other JSON", body: `{"status": "error"}`},
+		{name: "truncated JSON", body: `{"code": "busy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseServerError(429, []byte(tt.body))
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseServerError = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	respond := func(body string) *statusError {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(body))
+		}))
+		defer ts.Close()
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return newStatusError(resp)
+	}
+
+	err := respond(`{"code": "chain_not_allowed", "message": "chain c1 is not enabled", "hint": "enable it in the dashboard"}`)
+	if got, want := err.Error(), "server returned 403: chain_not_allowed: chain c1 is not enabled (hint: enable it in the dashboard)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	var se *ServerError
+	if !errors.As(error(err), &se) || se.Code != "chain_not_allowed" || se.Status != http.StatusForbidden {
+		t.Errorf("errors.As ServerError = %+v", se)
+	}
+
+	err = respond("<html>\n  <body>" + strings.Repeat("x", 1000) + "</body>\n</html>")
+	if msg := err.Error(); !strings.HasPrefix(msg, "server returned 403: <html> <body>xxx") || len(msg) > 300 {
+		t.Errorf("Error() = %q, want a short excerpt", msg)
+	}
+	if errors.As(error(err), &se) {
+		t.Error("unstructured body matched ServerError")
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultShutdownTimeout bounds each shutdown stage when
+// Config.ShutdownTimeout is unset.
+const defaultShutdownTimeout = 5 * time.Second
+
+// Shutdown stages of a WAL pipeline, in the order they run.
+const (
+	ShutdownStopReaders  = "stop_readers"
+	ShutdownFlushBatch   = "flush_batch"
+	ShutdownDrainSender  = "drain_sender"
+	ShutdownCommitState  = "commit_state"
+	ShutdownStopScrapers = "stop_scrapers"
+	ShutdownStopPlugins  = "stop_plugins"
+)
+
+// PluginShutdowner is implemented by plugin hooks that hold resources. The
+// pipeline calls Shutdown last when it stops, after the final batch has
+// been sent and its state committed.
+type PluginShutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// ShutdownReport describes one shutdown stage.
+type ShutdownReport struct {
+	Stage    string
+	Took     time.Duration
+	TimedOut bool
+	Err      error
+}
+
+// shutdownStage is one step of an ordered shutdown.
+type shutdownStage struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runShutdown runs stages in order, each bounded by timeout. A stage that
+// overruns is abandoned and the next one starts, so a hung upload cannot
+// keep the agent from exiting. Every stage is logged and failures are also
+// recorded as events.
+func runShutdown(stages []shutdownStage, timeout time.Duration) []ShutdownReport {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	reports := make([]ShutdownReport, 0, len(stages))
+	for _, s := range stages {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() { done <- s.run(ctx) }()
+		var err error
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		cancel()
+
+		r := ShutdownReport{Stage: s.name, Took: time.Since(start), TimedOut: errors.Is(err, context.DeadlineExceeded), Err: err}
+		reports = append(reports, r)
+		switch {
+		case r.TimedOut:
+			logger.Warn().Str("stage", r.Stage).Dur("timeout", timeout).Msg("shutdown stage timed out")
+			recordEvent(EventError, fmt.Sprintf("shutdown %s timed out after %s", r.Stage, timeout))
+		case err != nil:
+			logger.Warn().Err(err).Str("stage", r.Stage).Dur("took", r.Took).Msg("shutdown stage failed")
+			recordEvent(EventError, fmt.Sprintf("shutdown %s: %v", r.Stage, err))
+		default:
+			logger.Debug().Str("stage", r.Stage).Dur("took", r.Took).Msg("shutdown stage done")
+		}
+	}
+	return reports
+}
+
+// shutdownPlugins calls Shutdown on the hooks that implement
+// PluginShutdowner, in order, and joins their errors.
+func shutdownPlugins(ctx context.Context, hooks []PluginHook) error {
+	var errs []error
+	for _, h := range hooks {
+		if s, ok := h.(PluginShutdowner); ok {
+			if err := s.Shutdown(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", hookName(h), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
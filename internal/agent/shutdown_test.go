@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunShutdown(t *testing.T) {
+	oldEvents := recentEvents
+	recentEvents = &eventRing{}
+	defer func() { recentEvents = oldEvents }()
+
+	var order []string
+	stage := func(name string, err error) shutdownStage {
+		return shutdownStage{name, func(context.Context) error {
+			order = append(order, name)
+			return err
+		}}
+	}
+	release := make(chan struct{})
+	defer close(release)
+	hung := shutdownStage{"hung", func(ctx context.Context) error {
+		<-release
+		return nil
+	}}
+
+	reports := runShutdown([]shutdownStage{
+		stage("a", nil),
+		hung,
+		stage("b", errors.New("boom")),
+		stage("c", nil),
+	}, 20*time.Millisecond)
+
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Errorf("order = %v, want [a b c]", order)
+	}
+	if len(reports) != 4 {
+		t.Fatalf("reports = %+v, want 4", reports)
+	}
+	if r := reports[1]; r.Stage != "hung" || !r.TimedOut {
+		t.Errorf("hung stage = %+v, want timed out", r)
+	}
+	if r := reports[2]; r.TimedOut || r.Err == nil || r.Err.Error() != "boom" {
+		t.Errorf("failing stage = %+v, want err boom", r)
+	}
+	if r := reports[3]; r.Stage != "c" || r.Err != nil {
+		t.Errorf("last stage = %+v, want success", r)
+	}
+	if got := len(recentEvents.Snapshot()); got != 2 {
+		t.Errorf("recorded %d events, want 2 (timeout and failure)", got)
+	}
+}
+
+type shutdownHook struct {
+	allow    atomic.Bool
+	offered  chan struct{}
+	once     sync.Once
+	posts    *atomic.Int32
+	shutdown atomic.Int32 // posts seen when Shutdown was called, plus one
+}
+
+func (h *shutdownHook) BeforeSend(SendInfo) bool {
+	h.once.Do(func() { close(h.offered) })
+	return h.allow.Load()
+}
+
+func (h *shutdownHook) AfterSend(SendInfo, error) {}
+
+func (h *shutdownHook) Shutdown(context.Context) error {
+	h.shutdown.Store(h.posts.Load() + 1)
+	return nil
+}
+
+func TestRun_ShutdownFlushesBatchBeforePlugins(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lens := writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	var posts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != agentInfoEndpoint {
+			posts.Add(1)
+		}
+	}))
+	defer ts.Close()
+
+	// The hook holds the batch back until the pipeline is told to stop, so
+	// only the shutdown flush can deliver it.
+	hook := &shutdownHook{offered: make(chan struct{}), posts: &posts}
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), PollInterval: time.Millisecond,
+		PluginHooks: []PluginHook{hook}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	select {
+	case <-hook.offered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch never offered")
+	}
+	hook.allow.Store(true)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	if got := hook.shutdown.Load(); got != 2 {
+		t.Errorf("plugin shut down after %d posts, want 1 (flush first)", got-1)
+	}
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(lens[0] + lens[1]); st.IdxOffset != want {
+		t.Errorf("IdxOffset = %d, want %d (flushed batch committed)", st.IdxOffset, want)
+	}
+}
+
+func TestRun_ShutdownCancelsOverrunningFlush(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAA"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+	})
+
+	// Uploads hang until the agent gives up on them.
+	aborted := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint {
+			return
+		}
+		// The server notices a closed connection once the body is read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	var posts atomic.Int32
+	hook := &shutdownHook{offered: make(chan struct{}), posts: &posts}
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), PollInterval: time.Millisecond,
+		ShutdownTimeout: 100 * time.Millisecond, PluginHooks: []PluginHook{hook}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+
+	select {
+	case <-hook.offered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch never offered")
+	}
+	hook.allow.Store(true)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("overrunning flush upload not cancelled")
+	}
+
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.IdxOffset != 0 || st.LastFrame != 0 {
+		t.Errorf("state = %+v, want nothing committed by the abandoned flush", st)
+	}
+}
+
+func TestSealableStateStore_RefusesSavesAfterSeal(t *testing.T) {
+	dir := t.TempDir()
+	s := &sealableStateStore{stateStore: jsonStateStore{dir: dir}}
+	if err := s.seal(state{LastFrame: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.save(state{LastFrame: 2}); err == nil {
+		t.Error("save after seal succeeded")
+	}
+	if st, err := s.load(); err != nil || st.LastFrame != 1 {
+		t.Errorf("load = %+v, %v, want the sealed frame 1", st, err)
+	}
+}
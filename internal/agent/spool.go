@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func openSpool(cfg Config) (*sender.Spool, error) {
+	return sender.OpenSpool(filepath.Join(cfg.StateDir, "spool"), sender.SpoolOptions{
+		MaxBytes: int64(cfg.SpoolMaxBytes),
+		MaxAge:   cfg.SpoolMaxAge,
+		OnEvict:  func(b sender.EvictedBatch) { addTombstone(cfg, spoolTombstone(b)) },
+	})
+}
+
+// spoolBatch moves frames to sp and commits the read position past them, so
+// the pipeline keeps reading the WAL while the service is unreachable. The
+// frames count as shipped only once drained.
+func spoolBatch(cfg Config, sp *sender.Spool, st *state, frames []batchFrame, curIdxBase string) error {
+	evicted := sp.Evicted()
+	wf := make([]wal.Frame, len(frames))
+	var bytes int
+	for i, fr := range frames {
+		wf[i] = wal.Frame{Meta: fr.Meta, Compressed: fr.Compressed, LineLen: fr.IdxLineLen}
+		bytes += len(fr.Compressed)
+	}
+	if err := sp.Put(curIdxBase, wf); err != nil {
+		return err
+	}
+	noteSpoolEvictions(sp, evicted)
+
+	for _, fr := range frames {
+		st.IdxOffset += int64(fr.IdxLineLen)
+	}
+	last := frames[len(frames)-1].Meta
+	st.LastFile = last.File
+	st.LastFrame = last.Frame
+	st.LastCommitAt = time.Now().UTC()
+	_ = saveState(cfg.StateDir, *st)
+
+	logger.Warn().
+		Int("frames", len(frames)).
+		Int("bytes", bytes).
+		Str("segment", curIdxBase).
+		Int("spooled_batches", sp.Len()).
+		Msg("spooled batch")
+	recordEvent(EventState, fmt.Sprintf("spooled %d frames (%d bytes) from %s", len(frames), bytes, curIdxBase))
+	return nil
+}
+
+// spoolPending spools the pending batch and empties it. If the spool cannot
+// take it, the batch stays in memory to be retried.
+func spoolPending(cfg Config, sp *sender.Spool, st *state, batch *[]batchFrame, batchBytes *int, curIdxBase string) {
+	if err := spoolBatch(cfg, sp, st, *batch, curIdxBase); err != nil {
+		logger.Error().Err(err).Msg("spool batch")
+		return
+	}
+	*batch, *batchBytes = (*batch)[:0], 0
+}
+
+// drainSpool sends spooled batches, oldest first, over the active transport
+// until the spool is empty or a send fails.
+func drainSpool(cfg Config, httpClient *http.Client, st *state, sp *sender.Spool) error {
+	evicted := sp.Evicted()
+	defer noteSpoolEvictions(sp, evicted)
+
+	_, err := sp.Drain(activePipeline(cfg).sendContext(), func(_ context.Context, segment string, wf []wal.Frame) (int, error) {
+		frames := make([]batchFrame, len(wf))
+		for i, f := range wf {
+			frames[i] = batchFrame{Meta: f.Meta, Compressed: f.Compressed, IdxLineLen: f.LineLen, Hash: hashFrame(f.Compressed)}
+			if cfg.FrameTypeStats {
+				frames[i].Types = frameTypes(f.Compressed)
+			}
+		}
+		var sent int
+		var err error
+		start := time.Now()
+		p := activePipeline(cfg)
+		if gs := p.activeGRPC(); gs != nil {
+			sent, err = sendGRPC(cfg, gs, frames, segment)
+		} else if ks := p.activeKafka(); ks != nil {
+			sent, err = sendKafka(cfg, ks, frames, segment)
+		} else if obj := p.activeObjectStore(); obj != nil {
+			sent, err = sendObjectStore(cfg, obj, frames, segment)
+		} else {
+			sent, err = sendSplitting(cfg, httpClient, frames, segment, nil)
+		}
+		metricSendDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metricSendRetries.Inc()
+		}
+		if sent > 0 {
+			noteSpoolDelivered(cfg, httpClient, st, frames[:sent], segment)
+		}
+		return sent, err
+	})
+	return err
+}
+
+// noteSpoolDelivered records drained frames as shipped. Their read position
+// was committed when they were spooled, so only the totals in st are saved.
+func noteSpoolDelivered(cfg Config, httpClient *http.Client, st *state, frames []batchFrame, segment string) {
+	var bytes int
+	manifest := make([]FrameMeta, 0, len(frames))
+	shipped := activePipeline(cfg).shippedFrames()
+	for _, fr := range frames {
+		bytes += len(fr.Compressed)
+		shipped.Add(fr.Hash)
+		manifest = append(manifest, fr.Meta)
+	}
+	logger.Info().
+		Int("frames", len(frames)).
+		Int("bytes", bytes).
+		Str("segment", segment).
+		Msg("sent spooled batch")
+	st.Shipped.add(len(frames), bytes)
+	_ = saveState(cfg.StateDir, *st)
+	noteShipped(cfg.StateDir, st.Shipped)
+	observeSent(frames)
+	observeAckLatency(cfg, frames, time.Now())
+	addFrameTypes(frames)
+	recordEvent(EventSend, fmt.Sprintf("sent %d spooled frames (%d bytes) from %s", len(frames), bytes, segment))
+	recordDelivery(cfg, newSendSuccessEvent(segment, manifest, 0, 0, bytes, time.Now()), frames, true)
+	shipDecoded(cfg, httpClient, frames, segment)
+}
+
+// retrySpool drains the spool while no new frames are pending.
+func retrySpool(cfg Config, httpClient *http.Client, st *state, back *backoff) {
+	sp := activePipeline(cfg).activeSpool()
+	if sp == nil || sp.Len() == 0 {
+		return
+	}
+	if err := drainSpool(cfg, httpClient, st, sp); err != nil {
+		logger.Error().Err(err).Int("spooled_batches", sp.Len()).Msg("drain spool")
+		recordEvent(EventError, "drain spool: "+err.Error())
+		back.Sleep()
+		return
+	}
+	back.Reset()
+}
+
+func noteSpoolEvictions(sp *sender.Spool, before uint64) {
+	if n := sp.Evicted() - before; n > 0 {
+		logger.Warn().Uint64("batches", n).Msg("evicted spooled batches undelivered")
+		recordEvent(EventError, fmt.Sprintf("evicted %d spooled batches undelivered", n))
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun_SpoolsWhileServiceDown(t *testing.T) {
+	var up atomic.Bool
+	var mu sync.Mutex
+	var received []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		f, _, err := r.FormFile("frames")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(f)
+		mu.Lock()
+		received = append(received, b...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lineLens := writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, Once: true, PollInterval: time.Millisecond, SpoolMaxBytes: 1 << 20}
+
+	// Service down: the batch is spooled and the read position moves on.
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(lineLens[0] + lineLens[1]); st.IdxOffset != want {
+		t.Fatalf("offset after spooling = %d, want %d", st.IdxOffset, want)
+	}
+	spooled, _ := filepath.Glob(filepath.Join(stateDir, "spool", "*.batch"))
+	if len(spooled) == 0 {
+		t.Fatal("nothing spooled")
+	}
+
+	// Service back: the spool drains although no new frames arrive.
+	up.Store(true)
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if string(received) != "AAAABBBB" {
+		t.Fatalf("received %q, want AAAABBBB", received)
+	}
+	spooled, _ = filepath.Glob(filepath.Join(stateDir, "spool", "*.batch"))
+	if len(spooled) != 0 {
+		t.Fatalf("spool not drained: %v", spooled)
+	}
+	if c, _ := loadCounters(stateDir); c.Frames != 2 {
+		t.Fatalf("shipped frames = %d, want 2", c.Frames)
+	}
+}
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Start positions for an agent without saved state. StartFrom may also be
+// "time:<RFC3339>" or "height:<n>".
+const (
+	StartFromOldest = "oldest"
+	StartFromLatest = "latest"
+)
+
+// startFrom is a parsed Config.StartFrom; the zero value means oldest.
+type startFrom struct {
+	latest bool
+	time   time.Time
+	height int64
+}
+
+func parseStartFrom(s string) (startFrom, error) {
+	switch {
+	case s == "" || s == StartFromOldest:
+		return startFrom{}, nil
+	case s == StartFromLatest:
+		return startFrom{latest: true}, nil
+	case strings.HasPrefix(s, "time:"):
+		t, err := time.Parse(time.RFC3339, strings.TrimPrefix(s, "time:"))
+		if err != nil {
+			return startFrom{}, fmt.Errorf("start-from time: %w", err)
+		}
+		return startFrom{time: t}, nil
+	case strings.HasPrefix(s, "height:"):
+		n, err := strconv.ParseInt(strings.TrimPrefix(s, "height:"), 10, 64)
+		if err != nil || n <= 0 {
+			return startFrom{}, fmt.Errorf("start-from height must be a positive integer")
+		}
+		return startFrom{height: n}, nil
+	}
+	return startFrom{}, fmt.Errorf("start-from must be %q, %q, \"time:<RFC3339>\" or \"height:<n>\"", StartFromOldest, StartFromLatest)
+}
+
+// startPosition returns the index file and offset a fresh agent starts
+// shipping from. A time or height beyond the end of the WAL starts at the
+// latest position, i.e. with the next frame written.
+func startPosition(walDir string, sf startFrom) (string, int64, error) {
+	oldest, err := oldestIndex(walDir)
+	if err != nil {
+		return "", 0, err
+	}
+	switch {
+	case sf.latest:
+		latest, err := wal.LatestIndex(walDir)
+		if err != nil {
+			return "", 0, err
+		}
+		_, end, err := readIdxLines(latest)
+		return latest, end, err
+	case !sf.time.IsZero():
+		cutoff := sf.time.UnixNano()
+		return seekIndexes(oldest, func(_ string, lines []idxLine) (int, error) {
+			for i, l := range lines {
+				if l.meta.LastTS >= cutoff {
+					return i, nil
+				}
+			}
+			return -1, nil
+		})
+	case sf.height > 0:
+		return seekIndexes(oldest, func(path string, lines []idxLine) (int, error) {
+			return findHeight(filepath.Dir(path), lines, sf.height)
+		})
+	}
+	return oldest, 0, nil
+}
+
+// idxLine is a complete index line and its byte offset.
+type idxLine struct {
+	meta FrameMeta
+	off  int64
+}
+
+// readIdxLines returns the parseable complete lines of an index file and the
+// offset just past the last complete line.
+func readIdxLines(path string) ([]idxLine, int64, error) {
+	f, err := openReadOnly(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	var lines []idxLine
+	var off int64
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			return lines, off, nil
+		}
+		if fm, err := wal.ParseIndexLine(b[:i]); err == nil {
+			lines = append(lines, idxLine{meta: fm, off: off})
+		}
+		off += int64(i + 1)
+		b = b[i+1:]
+	}
+}
+
+// seekIndexes walks the index files from first onwards and returns the
+// position of the first line match picks. If none does, it returns the end
+// of the last index.
+func seekIndexes(first string, match func(path string, lines []idxLine) (int, error)) (string, int64, error) {
+	path := first
+	for {
+		lines, end, err := readIdxLines(path)
+		if err != nil {
+			return "", 0, err
+		}
+		i, err := match(path, lines)
+		if err != nil {
+			return "", 0, err
+		}
+		if i >= 0 {
+			return path, lines[i].off, nil
+		}
+		next, ok, _ := wal.NextIndexAfter(path)
+		if !ok {
+			return path, end, nil
+		}
+		path = next
+	}
+}
+
+// findHeight returns the first of lines whose frame holds a consensus
+// message at height h or later, or -1. A segment whose last frame is known to
+// be below h is skipped without decompressing the rest.
+func findHeight(dir string, lines []idxLine, h int64) (int, error) {
+	if len(lines) == 0 {
+		return -1, nil
+	}
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	maxHeight := func(fm FrameMeta) (int64, error) {
+		f, ok := files[fm.File]
+		if !ok {
+			var err error
+			if f, err = openGz(filepath.Join(dir, fm.File)); err != nil {
+				return 0, err
+			}
+			files[fm.File] = f
+		}
+		b, err := preadSection(f, int64(fm.Off), int64(fm.Len))
+		if err != nil {
+			return 0, err
+		}
+		raw, err := wal.Decompress(b)
+		if err != nil {
+			return 0, nil // unreadable frames do not decide the position
+		}
+		var max int64
+		for _, ev := range consensus.DecodeFrame(raw, nil).Events {
+			if ev.Height() > max {
+				max = ev.Height()
+			}
+		}
+		return max, nil
+	}
+
+	if last, err := maxHeight(lines[len(lines)-1].meta); err != nil {
+		return -1, err
+	} else if last > 0 && last < h {
+		return -1, nil
+	}
+	for i, l := range lines {
+		max, err := maxHeight(l.meta)
+		if err != nil {
+			return -1, err
+		}
+		if max >= h {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeHeightWAL writes two segments of two frames each, at heights 10-13,
+// with the last frame of frame i at base+(i+1) minutes. The second index ends
+// with a partial line.
+func writeHeightWAL(t *testing.T, base time.Time) (dir string, idx1, idx2 string, lens1, lens2 []int) {
+	t.Helper()
+	dir = t.TempDir()
+	vote := func(h int) string {
+		return fmt.Sprintf(`{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,"height":"%d","round":0,"block_id":{"hash":"AB"},"validator_address":"V"}}},"peer_key":""}}}`, h)
+	}
+	seg := func(n, first int) (string, []int) {
+		var gz []byte
+		var metas []FrameMeta
+		for i := 0; i < 2; i++ {
+			f := gzipFrame(t, vote(10+first+i))
+			ts := base.Add(time.Duration(first+i+1) * time.Minute).UnixNano()
+			metas = append(metas, FrameMeta{File: fmt.Sprintf("seg-%06d.wal.gz", n), Frame: uint64(first + i), Off: uint64(len(gz)), Len: uint64(len(f)), FirstTS: ts - 1, LastTS: ts})
+			gz = append(gz, f...)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("seg-%06d.wal.gz", n)), gz, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		idx := filepath.Join(dir, fmt.Sprintf("seg-%06d.wal.idx", n))
+		return idx, writeIdx(t, idx, metas)
+	}
+	idx1, lens1 = seg(1, 0)
+	idx2, lens2 = seg(2, 2)
+	f, err := os.OpenFile(idx2, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"file":"seg-000002.wal.gz","fra`)
+	f.Close()
+	return dir, idx1, idx2, lens1, lens2
+}
+
+func TestStartPosition(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir, idx1, idx2, lens1, lens2 := writeHeightWAL(t, base)
+	end2 := int64(lens2[0] + lens2[1])
+
+	tests := []struct {
+		from    string
+		wantIdx string
+		wantOff int64
+	}{
+		{from: "", wantIdx: idx1},
+		{from: "oldest", wantIdx: idx1},
+		{from: "latest", wantIdx: idx2, wantOff: end2},
+		{from: "time:2023-12-31T00:00:00Z", wantIdx: idx1},
+		{from: "time:2024-01-01T00:01:30Z", wantIdx: idx1, wantOff: int64(lens1[0])},
+		{from: "time:2024-01-01T00:02:30Z", wantIdx: idx2},
+		{from: "time:2024-01-02T00:00:00Z", wantIdx: idx2, wantOff: end2},
+		{from: "height:1", wantIdx: idx1},
+		{from: "height:11", wantIdx: idx1, wantOff: int64(lens1[0])},
+		{from: "height:13", wantIdx: idx2, wantOff: int64(lens2[0])},
+		{from: "height:99", wantIdx: idx2, wantOff: end2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			sf, err := parseStartFrom(tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			idx, off, err := startPosition(dir, sf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx != tt.wantIdx || off != tt.wantOff {
+				t.Errorf("startPosition = %s@%d, want %s@%d", filepath.Base(idx), off, filepath.Base(tt.wantIdx), tt.wantOff)
+			}
+		})
+	}
+}
+
+func TestParseStartFrom_Invalid(t *testing.T) {
+	for _, s := range []string{"newest", "time:yesterday", "height:0", "height:x"} {
+		if _, err := parseStartFrom(s); err == nil {
+			t.Errorf("parseStartFrom(%q) succeeded", s)
+		}
+	}
+}
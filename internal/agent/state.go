@@ -15,36 +15,117 @@ type state struct {
 	LastFrame    uint64    `json:"last_frame"`
 	LastCommitAt time.Time `json:"last_commit_at"`
 	LastSendAt   time.Time `json:"last_send_at"`
+
+	// Upload is the resumable upload session in progress, if any.
+	Upload *uploadSession `json:"upload,omitempty"`
+
+	// ChainID and GenesisHash identify the chain the position belongs to.
+	ChainID     string `json:"chain_id,omitempty"`
+	GenesisHash string `json:"genesis_hash,omitempty"`
+
+	// Gaps are the most recent WAL gaps detected.
+	Gaps []gapRecord `json:"gaps,omitempty"`
+
+	// Journal holds the frame uploads sent, or about to be, but not yet
+	// committed, in the order they were begun.
+	Journal []inFlightBatch `json:"journal,omitempty"`
+	// InFlight is the one upload earlier versions journaled; it is settled
+	// like Journal.
+	InFlight *inFlightBatch `json:"in_flight,omitempty"`
+
+	// Shipped are the totals committed so far; see counters.go.
+	Shipped shippedTotals `json:"shipped"`
+
+	// Read is the read position CommitModePeriodic persisted ahead of the
+	// acknowledged one, if any.
+	Read *readPosition `json:"read,omitempty"`
+}
+
+// readPosition is a position in the WAL past frames not yet acknowledged.
+type readPosition struct {
+	IdxPath   string `json:"idx_path"`
+	IdxOffset int64  `json:"idx_offset"`
+	LastFile  string `json:"last_file"`
+	LastFrame uint64 `json:"last_frame"`
+}
+
+// resumeRead moves the position to Read, if that is ahead of it in the same
+// index, and clears Read.
+func (st *state) resumeRead() {
+	if r := st.Read; r != nil && r.IdxPath == st.IdxPath && r.IdxOffset > st.IdxOffset {
+		st.IdxOffset, st.LastFile, st.LastFrame = r.IdxOffset, r.LastFile, r.LastFrame
+	}
+	st.Read = nil
+}
+
+// configState records the last config upload accepted by the service. It is
+// kept apart from status.json so the config watcher never races the tailer.
+type configState struct {
+	Hash   string    `json:"hash"`
+	SentAt time.Time `json:"sent_at"`
+	// Files holds the SHA-256 of each file in that upload, by name.
+	Files map[string]string `json:"files,omitempty"`
 }
 
 func stateFile(dir string) string {
 	return filepath.Join(dir, "status.json")
 }
 
+func configStateFile(dir string) string {
+	return filepath.Join(dir, "config_status.json")
+}
+
+// loadState reads the state of dir from its stateStore.
 func loadState(dir string) (state, error) {
-	b, err := os.ReadFile(stateFile(dir))
+	s, release, err := stateStoreFor(dir, true)
 	if err != nil {
 		return state{}, err
 	}
-	var st state
-	if err := json.Unmarshal(b, &st); err != nil {
-		return state{}, err
-	}
-	return st, nil
+	defer release()
+	return s.load()
 }
 
 func saveState(dir string, st state) error {
+	s, release, err := stateStoreFor(dir, false)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.save(st)
+}
+
+func loadConfigState(dir string) (configState, error) {
+	var cs configState
+	if err := readJSON(configStateFile(dir), &cs); err != nil {
+		return configState{}, err
+	}
+	return cs, nil
+}
+
+func saveConfigState(dir string, cs configState) error {
+	return writeJSONAtomic(dir, configStateFile(dir), cs)
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeJSONAtomic writes v to path via a temp file and rename.
+func writeJSONAtomic(dir, path string, v any) error {
 	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return err
 	}
-	path := stateFile(dir)
 	tmp := path + ".tmp"
-	b, err := json.MarshalIndent(st, "", "  ")
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
 	if err := os.WriteFile(tmp, b, 0o600); err != nil {
 		return err
 	}
-	return os.Rename(tmp, stateFile(dir))
+	return os.Rename(tmp, path)
 }
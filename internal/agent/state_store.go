@@ -0,0 +1,309 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// State backends for Config.StateBackend.
+const (
+	StateBackendJSON   = "json"
+	StateBackendSQLite = "sqlite"
+	StateBackendBolt   = "bolt"
+)
+
+// stateStore persists the shipping position of one state dir.
+type stateStore interface {
+	load() (state, error)
+	save(st state) error
+	close() error
+}
+
+// jsonStateStore keeps the state in status.json, replaced by rename on
+// every save.
+type jsonStateStore struct {
+	dir string
+}
+
+func (s jsonStateStore) load() (state, error) {
+	var st state
+	if err := readJSON(stateFile(s.dir), &st); err != nil {
+		return state{}, err
+	}
+	return st, nil
+}
+
+func (s jsonStateStore) save(st state) error {
+	return writeJSONAtomic(s.dir, stateFile(s.dir), st)
+}
+
+func (jsonStateStore) close() error { return nil }
+
+// boltStateStore keeps the state under a single key of state.bolt. A
+// writable store keeps the database open until it is closed, which locks
+// other processes out of it for that long; a read-only one opens it for
+// each load.
+type boltStateStore struct {
+	path string
+	db   *bolt.DB // nil if read-only
+}
+
+func openBoltStateStore(path string, readOnly bool) (stateStore, error) {
+	s := &boltStateStore{path: path}
+	if readOnly {
+		return s, nil
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: ledgerLockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("open state db: %w", err)
+	}
+	s.db = db
+	return s, nil
+}
+
+var (
+	boltStateBucket = []byte("state")
+	boltStateKey    = []byte("current")
+)
+
+func stateBoltFile(dir string) string {
+	return filepath.Join(dir, "state.bolt")
+}
+
+func (s *boltStateStore) load() (state, error) {
+	var body []byte
+	err := s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltStateBucket); b != nil {
+			body = append([]byte(nil), b.Get(boltStateKey)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return state{}, err
+	}
+	if body == nil {
+		return state{}, &fs.PathError{Op: "load", Path: s.path, Err: fs.ErrNotExist}
+	}
+	var st state
+	if err := json.Unmarshal(body, &st); err != nil {
+		return state{}, err
+	}
+	return st, nil
+}
+
+func (s *boltStateStore) save(st state) error {
+	body, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltStateBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(boltStateKey, body)
+	})
+}
+
+func (s *boltStateStore) close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *boltStateStore) update(fn func(*bolt.Tx) error) error {
+	if s.db == nil {
+		return fmt.Errorf("state db %s is open read-only", s.path)
+	}
+	return s.db.Update(fn)
+}
+
+func (s *boltStateStore) view(fn func(*bolt.Tx) error) error {
+	if s.db != nil {
+		return s.db.View(fn)
+	}
+	// A read-only bolt.Open would create the file and then fail to
+	// initialise it.
+	if _, err := os.Stat(s.path); err != nil {
+		return err
+	}
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: ledgerLockTimeout, ReadOnly: true})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("%s is held by a running agent; ask its admin API (GET /status) instead: %w", s.path, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func stateDBFile(dir string) string {
+	return filepath.Join(dir, "state.db")
+}
+
+// stateBackendFile is the file in dir holding backend's state.
+func stateBackendFile(dir, backend string) string {
+	switch backend {
+	case StateBackendSQLite:
+		return stateDBFile(dir)
+	case StateBackendBolt:
+		return stateBoltFile(dir)
+	default:
+		return stateFile(dir)
+	}
+}
+
+// openBackend opens backend's store in dir.
+func openBackend(dir, backend string, readOnly bool) (stateStore, error) {
+	switch backend {
+	case "", StateBackendJSON:
+		return jsonStateStore{dir: dir}, nil
+	case StateBackendSQLite:
+		return openSQLiteStateStore(stateDBFile(dir), readOnly)
+	case StateBackendBolt:
+		return openBoltStateStore(stateBoltFile(dir), readOnly)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", backend)
+	}
+}
+
+// openStateStore opens the backend's store in dir. A store without a state
+// takes over the one kept by another backend, whose file is then renamed
+// with a .migrated suffix, so switching backends keeps the position.
+func openStateStore(dir, backend string) (stateStore, error) {
+	s, err := openBackend(dir, backend, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.load(); !os.IsNotExist(err) {
+		return s, nil
+	}
+	for _, from := range []string{StateBackendJSON, StateBackendBolt, StateBackendSQLite} {
+		otherFile := stateBackendFile(dir, from)
+		if from == backend || !fileExists(otherFile) {
+			continue
+		}
+		if err := migrateState(dir, from, s); err != nil {
+			s.close()
+			return nil, fmt.Errorf("migrate state to %s: %w", backend, err)
+		}
+		logger.Info().Str("state_dir", dir).Str("from", from).Str("backend", backend).Msg("migrated state to new backend")
+		break
+	}
+	return s, nil
+}
+
+// migrateState copies the state kept by backend from into s and renames
+// its file with a .migrated suffix. SQLite's -wal and -shm files are
+// removed once the database is closed, as the renamed copy no longer uses
+// them.
+func migrateState(dir, from string, s stateStore) error {
+	other, err := openBackend(dir, from, false)
+	if err != nil {
+		return err
+	}
+	st, err := other.load()
+	other.close()
+	if err != nil {
+		return nil // nothing usable to take over
+	}
+	if err := s.save(st); err != nil {
+		return err
+	}
+	otherFile := stateBackendFile(dir, from)
+	if err := os.Rename(otherFile, otherFile+".migrated"); err != nil {
+		return err
+	}
+	if from == StateBackendSQLite {
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(otherFile + suffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sealableStateStore is the store of a running pipeline. seal saves the
+// state committed at shutdown and refuses every later save, so work the
+// shutdown abandoned cannot move the committed position.
+type sealableStateStore struct {
+	stateStore
+	mu     sync.Mutex
+	sealed bool
+}
+
+func (s *sealableStateStore) save(st state) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return fmt.Errorf("state is sealed by shutdown")
+	}
+	return s.stateStore.save(st)
+}
+
+// seal saves st as the final state and refuses later saves.
+func (s *sealableStateStore) seal(st state) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return fmt.Errorf("state is sealed by shutdown")
+	}
+	s.sealed = true
+	return s.stateStore.save(st)
+}
+
+// stateStores are the stores opened by running pipelines, by state dir.
+var stateStores struct {
+	mu sync.Mutex
+	m  map[string]stateStore
+}
+
+// useStateStore makes loadState and saveState use s for dir; nil reverts to
+// detecting the backend from the files in dir.
+func useStateStore(dir string, s stateStore) {
+	stateStores.mu.Lock()
+	defer stateStores.mu.Unlock()
+	if s == nil {
+		delete(stateStores.m, dir)
+		return
+	}
+	if stateStores.m == nil {
+		stateStores.m = map[string]stateStore{}
+	}
+	stateStores.m[dir] = s
+}
+
+// stateStoreFor returns the store for dir and a func releasing it. Outside
+// a running pipeline, dir's backend is JSON if status.json exists, else
+// whichever of state.bolt and state.db does.
+func stateStoreFor(dir string, readOnly bool) (stateStore, func(), error) {
+	stateStores.mu.Lock()
+	s, ok := stateStores.m[dir]
+	stateStores.mu.Unlock()
+	if ok {
+		return s, func() {}, nil
+	}
+	backend := StateBackendJSON
+	if !fileExists(stateFile(dir)) {
+		if fileExists(stateBoltFile(dir)) {
+			backend = StateBackendBolt
+		} else if fileExists(stateDBFile(dir)) {
+			backend = StateBackendSQLite
+		}
+	}
+	s, err := openBackend(dir, backend, readOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, func() { s.close() }, nil
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package agent
+
+import "errors"
+
+// sqliteStateAvailable reports whether this build has the sqlite state
+// backend, which needs cgo.
+const sqliteStateAvailable = false
+
+func openSQLiteStateStore(string, bool) (stateStore, error) {
+	return nil, errors.New("the sqlite state backend needs a cgo build; use bolt instead")
+}
@@ -0,0 +1,84 @@
+//go:build cgo
+
+package agent
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStateAvailable reports whether this build has the sqlite state
+// backend, which needs cgo.
+const sqliteStateAvailable = true
+
+const stateStoreSchema = `
+CREATE TABLE IF NOT EXISTS state (
+	id       INTEGER PRIMARY KEY CHECK (id = 1),
+	body     TEXT    NOT NULL,
+	saved_at INTEGER NOT NULL
+);
+`
+
+// sqliteStateStore keeps the state as a single row of state.db, updated in
+// place by a transaction instead of a file rename.
+type sqliteStateStore struct {
+	path string
+	db   *sql.DB
+}
+
+func openSQLiteStateStore(path string, readOnly bool) (stateStore, error) {
+	q := url.Values{"_busy_timeout": {"5000"}}
+	if readOnly {
+		q.Set("mode", "ro")
+	} else {
+		q.Set("_journal_mode", "WAL")
+		q.Set("_synchronous", "NORMAL")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("open state db: %w", err)
+	}
+	if !readOnly {
+		if _, err := db.Exec(stateStoreSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("open state db: %w", err)
+		}
+	}
+	return &sqliteStateStore{path: path, db: db}, nil
+}
+
+func (s *sqliteStateStore) load() (state, error) {
+	var body string
+	err := s.db.QueryRow(`SELECT body FROM state WHERE id = 1`).Scan(&body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return state{}, &fs.PathError{Op: "load", Path: s.path, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return state{}, err
+	}
+	var st state
+	if err := json.Unmarshal([]byte(body), &st); err != nil {
+		return state{}, err
+	}
+	return st, nil
+}
+
+func (s *sqliteStateStore) save(st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO state (id, body, saved_at) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET body = excluded.body, saved_at = excluded.saved_at`,
+		string(b), time.Now().UnixNano())
+	return err
+}
+
+func (s *sqliteStateStore) close() error { return s.db.Close() }
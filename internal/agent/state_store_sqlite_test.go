@@ -0,0 +1,57 @@
+//go:build cgo
+
+package agent
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStateStore_MigratesSQLite(t *testing.T) {
+	dir := t.TempDir()
+	if err := saveState(dir, state{LastFrame: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	sq, err := openStateStore(dir, StateBackendSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err := sq.load(); err != nil || st.LastFrame != 7 {
+		t.Errorf("sqlite load = %+v, %v, want frame 7", st, err)
+	}
+	if err := sq.save(state{LastFrame: 8}); err != nil {
+		t.Fatal(err)
+	}
+	sq.close()
+	if pathExists(stateFile(dir)) || !pathExists(stateFile(dir)+".migrated") {
+		t.Error("status.json not renamed after migrating to sqlite")
+	}
+	// Outside Run, the backend is detected from the files in dir.
+	if st, err := loadState(dir); err != nil || st.LastFrame != 8 {
+		t.Errorf("loadState = %+v, %v, want frame 8 from state.db", st, err)
+	}
+
+	// Sidecars left by a crash are removed along with the migrated db.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.WriteFile(stateDBFile(dir)+suffix, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	js, err := openStateStore(dir, StateBackendJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.close()
+	if st, err := js.load(); err != nil || st.LastFrame != 8 {
+		t.Errorf("json load = %+v, %v, want frame 8", st, err)
+	}
+	if pathExists(stateDBFile(dir)) {
+		t.Error("state.db not renamed after migrating back to json")
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if pathExists(stateDBFile(dir) + suffix) {
+			t.Errorf("state.db%s left after migrating back to json", suffix)
+		}
+	}
+}
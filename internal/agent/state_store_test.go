@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateStore_RoundTrip(t *testing.T) {
+	backends := []string{StateBackendJSON, StateBackendBolt}
+	if sqliteStateAvailable {
+		backends = append(backends, StateBackendSQLite)
+	}
+	for _, backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := openStateStore(dir, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.close()
+			if _, err := s.load(); !os.IsNotExist(err) {
+				t.Fatalf("load of empty store = %v, want not exist", err)
+			}
+			for _, frame := range []uint64{1, 2} {
+				if err := s.save(state{IdxPath: "/wal/seg-000001.wal.idx", LastFrame: frame, ChainID: "chain-a"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st, err := s.load()
+			if err != nil || st.LastFrame != 2 || st.ChainID != "chain-a" {
+				t.Errorf("load = %+v, %v, want frame 2 of chain-a", st, err)
+			}
+		})
+	}
+}
+
+func TestStateStore_BoltStaysOpen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStateStore(dir, StateBackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := s.(*boltStateStore).db
+	if db == nil {
+		t.Fatal("writable bolt store did not open the database")
+	}
+	for _, frame := range []uint64{1, 2, 3} {
+		if err := s.save(state{LastFrame: frame}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.(*boltStateStore).db != db {
+		t.Error("database reopened between saves")
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once closed, readers get the last saved state.
+	r, err := openBackend(dir, StateBackendBolt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	if st, err := r.load(); err != nil || st.LastFrame != 3 {
+		t.Errorf("read-only load = %+v, %v, want frame 3", st, err)
+	}
+	if err := r.save(state{}); err == nil {
+		t.Error("read-only store saved")
+	}
+}
+
+func TestStateStore_MigratesBetweenBackends(t *testing.T) {
+	dir := t.TempDir()
+	if err := saveState(dir, state{LastFrame: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	bo, err := openStateStore(dir, StateBackendBolt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err := bo.load(); err != nil || st.LastFrame != 7 {
+		t.Errorf("bolt load = %+v, %v, want frame 7", st, err)
+	}
+	if err := bo.save(state{LastFrame: 8}); err != nil {
+		t.Fatal(err)
+	}
+	bo.close()
+	if pathExists(stateFile(dir)) || !pathExists(stateFile(dir)+".migrated") {
+		t.Error("status.json not renamed after migrating to bolt")
+	}
+	// Outside Run, the backend is detected from the files in dir.
+	if st, err := loadState(dir); err != nil || st.LastFrame != 8 {
+		t.Errorf("loadState = %+v, %v, want frame 8 from state.bolt", st, err)
+	}
+
+	js, err := openStateStore(dir, StateBackendJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.close()
+	if st, err := js.load(); err != nil || st.LastFrame != 8 {
+		t.Errorf("json load = %+v, %v, want frame 8", st, err)
+	}
+	if pathExists(stateBoltFile(dir)) {
+		t.Error("state.bolt not renamed after migrating back to json")
+	}
+}
+
+func TestRun_BoltStateBackend(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	walDir, stateDir := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("frame"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 5},
+	})
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: stateDir, StateBackend: StateBackendBolt,
+		Once: true, PollInterval: time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Run(ctx, cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if pathExists(stateFile(stateDir)) {
+		t.Error("status.json written with the bolt backend")
+	}
+	st, err := loadState(stateDir)
+	if err != nil || st.LastFrame != 1 {
+		t.Errorf("state = %+v, %v, want frame 1 committed", st, err)
+	}
+}
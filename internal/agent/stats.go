@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/consensus"
+)
+
+// Stats is a point-in-time snapshot of the agent's progress. An agent
+// shipping several nodes reports lag, shipped totals and spool depth summed
+// over them, and the config watch and WAL writer of its least healthy node.
+type Stats struct {
+	// Ready is true once every WAL pipeline has opened its index and is
+	// tailing.
+	// Auxiliary uploads (config, etc.) never gate readiness.
+	Ready bool `json:"ready"`
+	// ConfigWatchDegraded is true while config file changes cannot be watched;
+	// ConfigWatchError holds the last reason.
+	ConfigWatchDegraded bool   `json:"config_watch_degraded"`
+	ConfigWatchError    string `json:"config_watch_error,omitempty"`
+	// LagFrames is the number of indexed frames beyond the last committed offset.
+	LagFrames int64 `json:"lag_frames"`
+	// LagBytes is the compressed size of those frames.
+	LagBytes int64 `json:"lag_bytes"`
+	// LagUpdatedAt is when the lag was last computed.
+	LagUpdatedAt time.Time `json:"lag_updated_at"`
+	// AckLatency is how long recent frames took from the WAL to the
+	// service's acknowledgement.
+	AckLatency AckLatency `json:"ack_latency"`
+	// PreflightFindings lists the startup checks that failed under the warn
+	// policy.
+	PreflightFindings []string `json:"preflight_findings,omitempty"`
+	// DuplicateFrames counts frames skipped because they were shipped recently.
+	DuplicateFrames uint64 `json:"duplicate_frames"`
+	// ShippedFrames and ShippedBytes are the totals shipped since
+	// ShippedSince, persisted across restarts.
+	ShippedFrames uint64    `json:"shipped_frames"`
+	ShippedBytes  uint64    `json:"shipped_bytes"`
+	ShippedSince  time.Time `json:"shipped_since"`
+	// RecentEvents are the latest sends, errors and state changes, oldest
+	// first.
+	RecentEvents []RecentEvent `json:"recent_events,omitempty"`
+	// SpooledBatches and SpooledBytes are waiting in the on-disk spool;
+	// SpoolEvicted counts batches dropped from it undelivered.
+	SpooledBatches int    `json:"spooled_batches"`
+	SpooledBytes   int64  `json:"spooled_bytes"`
+	SpoolEvicted   uint64 `json:"spool_evicted"`
+	// FrameTypes counts the records shipped since start by message type.
+	FrameTypes map[consensus.MessageType]uint64 `json:"frame_types,omitempty"`
+	// WALWriter is the node's WAL writer state (WALWriterAlive, ...) when
+	// WALWriterFile is watched.
+	WALWriter string `json:"wal_writer,omitempty"`
+}
+
+var agentStats struct {
+	mu sync.Mutex
+	s  Stats
+	// Per state dir shipped totals and lag; with several pipelines running,
+	// Stats reports their sums.
+	shipped map[string]counters
+	lag     map[string]walLag
+	// Per state dir config watch errors ("" when healthy) and WAL writer
+	// states.
+	configWatch map[string]string
+	walWriter   map[string]string
+}
+
+// walWriterStates orders the WAL writer states from healthy to down.
+var walWriterStates = []string{"", WALWriterAlive, WALWriterIdle, WALWriterStalled, WALWriterNodeDown}
+
+// CurrentStats returns a snapshot of the agent statistics.
+func CurrentStats() Stats {
+	ps := runningPipelines()
+	agentStats.mu.Lock()
+	s := agentStats.s
+	if s.FrameTypes != nil {
+		s.FrameTypes = make(map[consensus.MessageType]uint64, len(agentStats.s.FrameTypes))
+		for t, n := range agentStats.s.FrameTypes {
+			s.FrameTypes[t] = n
+		}
+	}
+	if len(ps) > 1 {
+		s.ShippedFrames, s.ShippedBytes, s.ShippedSince = 0, 0, time.Time{}
+		s.LagFrames, s.LagBytes = 0, 0
+		for _, p := range ps {
+			c := agentStats.shipped[p.stateDir]
+			s.ShippedFrames += c.Frames
+			s.ShippedBytes += c.Bytes
+			if !c.Since.IsZero() && (s.ShippedSince.IsZero() || c.Since.Before(s.ShippedSince)) {
+				s.ShippedSince = c.Since
+			}
+			l := agentStats.lag[p.stateDir]
+			s.LagFrames += l.Frames
+			s.LagBytes += l.Bytes
+		}
+	}
+	if len(ps) > 1 {
+		s.ConfigWatchDegraded, s.ConfigWatchError, s.WALWriter = false, "", ""
+		for _, p := range ps {
+			if e := agentStats.configWatch[p.stateDir]; e != "" && !s.ConfigWatchDegraded {
+				s.ConfigWatchDegraded, s.ConfigWatchError = true, e
+			}
+			if w := agentStats.walWriter[p.stateDir]; slices.Index(walWriterStates, w) > slices.Index(walWriterStates, s.WALWriter) {
+				s.WALWriter = w
+			}
+		}
+	}
+	agentStats.mu.Unlock()
+	s.RecentEvents = recentEvents.Snapshot()
+	s.AckLatency = ackLatency.snapshot(time.Now())
+	for _, p := range ps {
+		if sp := p.spool; sp != nil {
+			s.SpooledBatches += sp.Len()
+			s.SpooledBytes += sp.Bytes()
+			s.SpoolEvicted += sp.Evicted()
+		}
+	}
+	return s
+}
+
+// StatsDoc is the body of GET /stats: Stats laid out for Grafana's JSON
+// datasources, which chart a JSONPath per field. It adds the time of the
+// snapshot and turns FrameTypes into rows, as map keys cannot be selected
+// as a series.
+type StatsDoc struct {
+	Time time.Time `json:"time"`
+	Stats
+	FrameTypes []FrameTypeCount `json:"frame_types"`
+}
+
+// FrameTypeCount is one row of StatsDoc.FrameTypes.
+type FrameTypeCount struct {
+	Type   consensus.MessageType `json:"type"`
+	Frames uint64                `json:"frames"`
+}
+
+// statsHandler serves CurrentStats as a StatsDoc.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := CurrentStats()
+	doc := StatsDoc{Time: time.Now().UTC(), Stats: s, FrameTypes: []FrameTypeCount{}}
+	for t, n := range s.FrameTypes {
+		doc.FrameTypes = append(doc.FrameTypes, FrameTypeCount{Type: t, Frames: n})
+	}
+	sort.Slice(doc.FrameTypes, func(i, j int) bool { return doc.FrameTypes[i].Type < doc.FrameTypes[j].Type })
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+func setReady(ready bool) {
+	agentStats.mu.Lock()
+	changed := agentStats.s.Ready != ready
+	agentStats.s.Ready = ready
+	agentStats.mu.Unlock()
+	if changed {
+		if ready {
+			recordEvent(EventState, "WAL pipeline running")
+		} else {
+			recordEvent(EventState, "WAL pipeline stopped")
+		}
+	}
+}
+
+// setConfigWatchHealth records whether the config watch of the node in dir
+// works.
+func setConfigWatchHealth(dir string, err error) {
+	agentStats.mu.Lock()
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	changed := (agentStats.configWatch[dir] != "") != (err != nil)
+	agentStats.s.ConfigWatchDegraded = err != nil
+	agentStats.s.ConfigWatchError = msg
+	if agentStats.configWatch == nil {
+		agentStats.configWatch = map[string]string{}
+	}
+	agentStats.configWatch[dir] = msg
+	agentStats.mu.Unlock()
+	switch {
+	case changed && err != nil:
+		recordEvent(EventState, "config watch degraded: "+msg)
+	case changed:
+		recordEvent(EventState, "config watch recovered")
+	}
+}
+
+func setPreflightFindings(findings []preflightFinding) {
+	var s []string
+	for _, f := range findings {
+		s = append(s, f.String())
+	}
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	agentStats.s.PreflightFindings = s
+}
+
+func setShippedTotals(dir string, c counters) {
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	agentStats.s.ShippedFrames = c.Frames
+	agentStats.s.ShippedBytes = c.Bytes
+	agentStats.s.ShippedSince = c.Since
+	if agentStats.shipped == nil {
+		agentStats.shipped = map[string]counters{}
+	}
+	agentStats.shipped[dir] = c
+}
+
+func recordLag(dir string, l walLag) {
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	agentStats.s.LagFrames = l.Frames
+	agentStats.s.LagBytes = l.Bytes
+	agentStats.s.LagUpdatedAt = time.Now().UTC()
+	if agentStats.lag == nil {
+		agentStats.lag = map[string]walLag{}
+	}
+	agentStats.lag[dir] = l
+}
+
+// forgetNodeStats drops the per-node values of a stopped pipeline.
+func forgetNodeStats(dir string) {
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	delete(agentStats.shipped, dir)
+	delete(agentStats.lag, dir)
+	delete(agentStats.configWatch, dir)
+	delete(agentStats.walWriter, dir)
+}
+
+func recordWALWriter(dir, state string) {
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	agentStats.s.WALWriter = state
+	if agentStats.walWriter == nil {
+		agentStats.walWriter = map[string]string{}
+	}
+	agentStats.walWriter[dir] = state
+}
+
+func recordDuplicateFrame() {
+	agentStats.mu.Lock()
+	defer agentStats.mu.Unlock()
+	agentStats.s.DuplicateFrames++
+}
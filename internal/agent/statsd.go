@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var statsdInterval = 10 * time.Second
+
+// statsdMaxPacket keeps datagrams under a typical Ethernet MTU.
+const statsdMaxPacket = 1432
+
+const (
+	StatsDFlavorStatsD    = "statsd"
+	StatsDFlavorDogStatsD = "dogstatsd"
+)
+
+// statsdEmitter sends metrics as StatsD gauges over UDP. With the DogStatsD
+// flavor labels become tags; plain StatsD has no tags, so label values are
+// folded into the metric name instead.
+type statsdEmitter struct {
+	conn net.Conn
+	dog  bool
+}
+
+func newStatsdEmitter(addr, flavor string) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd: %w", err)
+	}
+	return &statsdEmitter{conn: conn, dog: flavor != StatsDFlavorStatsD}, nil
+}
+
+func (e *statsdEmitter) Close() error { return e.conn.Close() }
+
+// Emit writes samples, packing as many lines per datagram as fit.
+func (e *statsdEmitter) Emit(samples []promSample) error {
+	var pkt []byte
+	for _, s := range samples {
+		line := formatStatsd(s, e.dog)
+		if len(pkt) > 0 && len(pkt)+1+len(line) > statsdMaxPacket {
+			if _, err := e.conn.Write(pkt); err != nil {
+				return err
+			}
+			pkt = pkt[:0]
+		}
+		if len(pkt) > 0 {
+			pkt = append(pkt, '\n')
+		}
+		pkt = append(pkt, line...)
+	}
+	if len(pkt) == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(pkt)
+	return err
+}
+
+// statsdScraper periodically emits the agent's metrics; histograms are sent
+// as their _sum and _count.
+type statsdScraper struct {
+	cfg Config
+	e   *statsdEmitter
+}
+
+func (statsdScraper) Name() string            { return "statsd" }
+func (statsdScraper) Interval() time.Duration { return statsdInterval }
+
+func (s statsdScraper) Collect(ctx context.Context) (any, error) {
+	return registrySamples(s.cfg, time.Now(), false), nil
+}
+
+func (s statsdScraper) Ship(ctx context.Context, data any) error {
+	return s.e.Emit(data.([]promSample))
+}
+
+// formatStatsd renders s as a gauge line, e.g. walship.lag_frames:3|g|#chain_id:x
+// (DogStatsD) or walship.x.lag_frames:3|g (StatsD).
+func formatStatsd(s promSample, dog bool) string {
+	name := "walship." + strings.TrimPrefix(s.Name, "walship_")
+	keys := make([]string, 0, len(s.Labels))
+	for k, v := range s.Labels {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	if dog {
+		b.WriteString(name)
+	} else {
+		b.WriteString("walship")
+		for _, k := range keys {
+			b.WriteByte('.')
+			b.WriteString(statsdSanitize(strings.ReplaceAll(s.Labels[k], ".", "_")))
+		}
+		b.WriteString(strings.TrimPrefix(name, "walship"))
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(s.Value, 'f', -1, 64))
+	b.WriteString("|g")
+	if dog && len(keys) > 0 {
+		b.WriteString("|#")
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(k)
+			b.WriteByte(':')
+			b.WriteString(statsdSanitize(s.Labels[k]))
+		}
+	}
+	return b.String()
+}
+
+// statsdSanitize replaces characters that delimit StatsD fields.
+func statsdSanitize(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, v)
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatStatsd(t *testing.T) {
+	s := promSample{
+		Name:   "walship_lag_frames",
+		Labels: map[string]string{"node_id": "abc", "chain_id": "osmo-1", "instance": ""},
+		Value:  3,
+	}
+	tests := []struct {
+		name string
+		dog  bool
+		want string
+	}{
+		{"dogstatsd", true, "walship.lag_frames:3|g|#chain_id:osmo-1,node_id:abc"},
+		{"statsd", false, "walship.osmo-1.abc.lag_frames:3|g"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatStatsd(s, tt.dog); got != tt.want {
+				t.Errorf("formatStatsd = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatStatsd_SanitizesValues(t *testing.T) {
+	s := promSample{Name: "walship_ready", Labels: map[string]string{"chain_id": "a|b:c.d"}, Value: 1}
+	if got, want := formatStatsd(s, true), "walship.ready:1|g|#chain_id:a_b_c.d"; got != want {
+		t.Errorf("dogstatsd = %q, want %q", got, want)
+	}
+	if got, want := formatStatsd(s, false), "walship.a_b_c_d.ready:1|g"; got != want {
+		t.Errorf("statsd = %q, want %q", got, want)
+	}
+}
+
+func TestStatsdEmitter_Emit(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp unavailable: %v", err)
+	}
+	defer pc.Close()
+
+	e, err := newStatsdEmitter(pc.LocalAddr().String(), StatsDFlavorDogStatsD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	cfg := Config{ChainID: "test-chain", NodeID: "test-node"}
+	samples := statsSamples(cfg, Stats{Ready: true, LagFrames: 7}, time.Now())
+	if err := e.Emit(samples); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+
+	buf := make([]byte, statsdMaxPacket)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	lines := strings.Split(string(buf[:n]), "\n")
+	if len(lines) != len(samples) {
+		t.Fatalf("got %d lines in one packet, want %d: %q", len(lines), len(samples), buf[:n])
+	}
+	if !strings.HasPrefix(lines[1], "walship.lag_frames:7|g|#chain_id:test-chain,") {
+		t.Errorf("line = %q", lines[1])
+	}
+}
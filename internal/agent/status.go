@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is the persisted shipping position together with the current lag.
+type Status struct {
+	IdxPath      string    `json:"idx_path"`
+	IdxOffset    int64     `json:"idx_offset"`
+	LastFile     string    `json:"last_file"`
+	LastFrame    uint64    `json:"last_frame"`
+	LastSendAt   time.Time `json:"last_send_at"`
+	LastCommitAt time.Time `json:"last_commit_at"`
+	LagFrames    int64     `json:"lag_frames"`
+	LagBytes     int64     `json:"lag_bytes"`
+	// ShippedFrames and ShippedBytes are the totals since ShippedSince.
+	ShippedFrames uint64    `json:"shipped_frames"`
+	ShippedBytes  uint64    `json:"shipped_bytes"`
+	ShippedSince  time.Time `json:"shipped_since"`
+	// Gaps are the most recent WAL gaps the agent detected.
+	Gaps []GapEvent `json:"gaps,omitempty"`
+	// Events is the recent history persisted by the agent; only filled in
+	// on request.
+	Events []RecentEvent `json:"events,omitempty"`
+}
+
+// ReadStatus loads the shipping state from cfg.StateDir and computes how far
+// it is behind the WAL. The agent does not need to be running.
+func ReadStatus(cfg Config) (Status, error) {
+	st, err := loadState(cfg.StateDir)
+	if err != nil {
+		return Status{}, fmt.Errorf("load state: %w", err)
+	}
+	lag, err := computeLag(st)
+	if err != nil {
+		return Status{}, fmt.Errorf("compute lag: %w", err)
+	}
+	totals, err := loadCounters(cfg.StateDir)
+	if err != nil {
+		return Status{}, fmt.Errorf("load counters: %w", err)
+	}
+	var gaps []GapEvent
+	for _, g := range st.Gaps {
+		gaps = append(gaps, g.GapEvent)
+	}
+	return Status{
+		Gaps:          gaps,
+		IdxPath:       st.IdxPath,
+		IdxOffset:     st.IdxOffset,
+		LastFile:      st.LastFile,
+		LastFrame:     st.LastFrame,
+		LastSendAt:    st.LastSendAt,
+		LastCommitAt:  st.LastCommitAt,
+		LagFrames:     lag.Frames,
+		LagBytes:      lag.Bytes,
+		ShippedFrames: totals.Frames,
+		ShippedBytes:  totals.Bytes,
+		ShippedSince:  totals.Since,
+	}, nil
+}
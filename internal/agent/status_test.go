@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadStatus(t *testing.T) {
+	dir := t.TempDir()
+	idx := filepath.Join(dir, "seg-000001.wal.idx")
+	lens := writeIdx(t, idx, []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Len: 10},
+		{File: "seg-000001.wal.gz", Frame: 2, Len: 20},
+	})
+	if err := saveState(dir, state{IdxPath: idx, IdxOffset: int64(lens[0]), LastFrame: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadStatus(Config{StateDir: dir})
+	if err != nil {
+		t.Fatalf("ReadStatus() error = %v", err)
+	}
+	if s.IdxPath != idx || s.LastFrame != 1 {
+		t.Errorf("status = %+v", s)
+	}
+	if s.LagFrames != 1 || s.LagBytes != 20 {
+		t.Errorf("lag = %d frames / %d bytes, want 1 / 20", s.LagFrames, s.LagBytes)
+	}
+
+	if _, err := ReadStatus(Config{StateDir: t.TempDir()}); err == nil {
+		t.Error("ReadStatus() expected error without state file")
+	}
+}
@@ -0,0 +1,403 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Frame streams carry the batches of a catch-up in one chunked request
+// instead of one request per batch:
+//
+//	POST {base}/v1/ingest/wal-frames/stream
+//	Content-Type: application/x-walship-frame-stream
+//
+// The body is a sequence of chunks, each a JSON header line followed by the
+// gzip frames it lists, concatenated as read from the WAL:
+//
+//	{"segment":"seg-000001.wal.idx","manifest":[...],"bytes":N}\n<N bytes>
+//
+// A chunk is a batch that reached MaxBatchBytes. It is written to the body
+// as soon as it is full and its bytes are released, so a stream never sits
+// in memory. The response acknowledges the whole stream; only then are its
+// frames committed. The frames of a failed stream are read from the WAL again
+// and streamed anew before any newer batch is sent.
+const (
+	walStreamEndpoint      = walFramesEndpoint + "/stream"
+	frameStreamContentType = "application/x-walship-frame-stream"
+)
+
+// streamChunk is the header line of a chunk of a frame stream.
+type streamChunk struct {
+	Segment  string      `json:"segment"`
+	Manifest []FrameMeta `json:"manifest"`
+	Bytes    int         `json:"bytes"`
+}
+
+func (c *Config) validateStreamUpload() error {
+	if c.StreamUploadBytes < 0 {
+		return fmt.Errorf("stream upload bytes must not be negative")
+	}
+	if c.StreamUploadBytes == 0 {
+		return nil
+	}
+	if c.MaxBatchBytes <= 0 || c.StreamUploadBytes <= c.MaxBatchBytes {
+		return fmt.Errorf("stream-upload-bytes must be larger than max-batch-bytes")
+	}
+	if c.GRPCTarget != "" || len(c.KafkaBrokers) > 0 || c.ObjectStoreBucket != "" || c.CanaryPercent > 0 {
+		return fmt.Errorf("stream-upload-bytes needs the HTTP transport, not grpc-target, kafka-brokers, object-store-bucket or canary-percent")
+	}
+	if c.ResumableUploadBytes > 0 {
+		return fmt.Errorf("stream-upload-bytes and resumable-upload-bytes are mutually exclusive")
+	}
+	if c.FrameEncoding == FrameEncodingZstd {
+		return fmt.Errorf("stream-upload-bytes sends frames as written, not with frame-encoding %q", FrameEncodingZstd)
+	}
+	if c.Anonymize {
+		// Failed streams are resent from the WAL, which holds the frames
+		// before anonymization.
+		return fmt.Errorf("stream-upload-bytes cannot be used with anonymize")
+	}
+	return nil
+}
+
+// frameStream is an open streaming upload of one segment's frames.
+type frameStream struct {
+	segment string
+	pw      *io.PipeWriter
+	done    chan error // the outcome of the request
+	cancel  context.CancelFunc
+	// stall aborts the request when a write or the response takes longer
+	// than timeout, plus the time a write takes at the upload rate; it is
+	// only armed while the stream waits on the service.
+	stall   *time.Timer
+	timeout time.Duration
+	rate    int
+	// frames are those written so far, without their bytes.
+	frames []batchFrame
+	bytes  int
+}
+
+// openFrameStream starts the request of a frame stream of segment's frames.
+// The client's timeout applies to each chunk and to the response, not to the
+// whole stream.
+func openFrameStream(cfg Config, httpClient *http.Client, segment string) *frameStream {
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(activePipeline(cfg).sendContext())
+	s := &frameStream{segment: segment, pw: pw, done: make(chan error, 1), cancel: cancel,
+		timeout: httpClient.Timeout, rate: cfg.MaxUploadBytesPerSec}
+	if s.timeout > 0 {
+		s.stall = time.AfterFunc(s.timeout, cancel)
+		s.stall.Stop()
+	}
+	client := *httpClient
+	client.Timeout = 0
+	go func() {
+		err := postFrameStream(ctx, cfg, &client, pr)
+		// Unblocks a write the service will no longer read.
+		pr.CloseWithError(err)
+		s.done <- err
+	}()
+	return s
+}
+
+func postFrameStream(ctx context.Context, cfg Config, httpClient *http.Client, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ingestURL(cfg, walStreamEndpoint), body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", frameStreamContentType)
+	throttleBody(req, cfg)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &requestError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
+
+// write writes frames to the stream as one chunk. The stream takes the
+// frames over, without their bytes, even if the write fails.
+func (s *frameStream) write(frames []batchFrame) error {
+	manifest := make([]FrameMeta, 0, len(frames))
+	for _, fr := range frames {
+		manifest = append(manifest, fr.Meta)
+	}
+	n := framesBytes(frames)
+	defer func() {
+		for _, fr := range frames {
+			fr.Compressed = nil
+			s.frames = append(s.frames, fr)
+		}
+		s.bytes += n
+	}()
+	header, err := json.Marshal(streamChunk{Segment: s.segment, Manifest: manifest, Bytes: n})
+	if err != nil {
+		return fmt.Errorf("marshal chunk header: %w", err)
+	}
+	s.arm(uploadPace(s.rate, int64(len(header)+1+n)))
+	defer s.disarm()
+	if _, err := s.pw.Write(append(header, '\n')); err != nil {
+		return err
+	}
+	for _, fr := range frames {
+		if _, err := s.pw.Write(fr.Compressed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close ends the request body and waits for the service's response.
+func (s *frameStream) close() error {
+	s.arm(0)
+	s.pw.Close()
+	err := <-s.done
+	s.disarm()
+	s.cancel()
+	return err
+}
+
+// abort ends the request without waiting for the service.
+func (s *frameStream) abort(err error) {
+	s.pw.CloseWithError(err)
+	s.cancel()
+	<-s.done
+}
+
+// arm starts the stall timer for timeout plus pace, the time the bytes to
+// be written take at the upload rate.
+func (s *frameStream) arm(pace time.Duration) {
+	if s.stall != nil {
+		s.stall.Reset(s.timeout + pace)
+	}
+}
+
+func (s *frameStream) disarm() {
+	if s.stall != nil {
+		s.stall.Stop()
+	}
+}
+
+// streaming reports whether streamed frames await the service's
+// acknowledgement, in an open stream or a failed one.
+func (p *pipeline) streaming() bool {
+	return p != nil && (p.stream != nil || p.failedStream != nil)
+}
+
+// unackedFrames returns the streamed frames awaiting acknowledgement, without
+// their bytes, followed by batch.
+func (p *pipeline) unackedFrames(batch []batchFrame) []batchFrame {
+	if !p.streaming() {
+		return batch
+	}
+	var out []batchFrame
+	if p.failedStream != nil {
+		out = append(out, p.failedStream.frames...)
+	}
+	if p.stream != nil {
+		out = append(out, p.stream.frames...)
+	}
+	return append(out, batch...)
+}
+
+// streamBatch writes batch, which reached MaxBatchBytes, to the pipeline's
+// frame stream as its next chunk, opening one if needed, and reports whether
+// it took the batch over from trySend. The stream ends once it holds
+// StreamUploadBytes or when trySend next sends a batch.
+func (p *pipeline) streamBatch(cfg Config, httpClient *http.Client, batch *[]batchFrame, batchBytes *int, st *state, curIdxBase string, back *backoff) bool {
+	if p == nil || cfg.StreamUploadBytes <= 0 || cfg.DryRun || len(*batch) == 0 {
+		return false
+	}
+	if sp := p.activeSpool(); sp != nil && sp.Len() > 0 {
+		return false // trySend drains the spool first
+	}
+	if !resourcesOK(cfg) {
+		return false // trySend applies the gating
+	}
+	if (p.stream != nil && p.stream.segment != curIdxBase) || p.failedStream != nil {
+		if !p.endStream(cfg, httpClient, st, back) {
+			return true
+		}
+	}
+	if !runBeforeSend(cfg.PluginHooks, sendInfo(curIdxBase, *batch)) {
+		return true
+	}
+
+	if p.stream == nil {
+		p.stream = openFrameStream(cfg, httpClient, curIdxBase)
+		logger.Info().Str("segment", curIdxBase).Int("max_bytes", cfg.StreamUploadBytes).Msg("streaming batches")
+	}
+	span := p.traceSend(*batch, curIdxBase, "stream")
+	n := len(*batch)
+	err := p.stream.write(*batch)
+	if err != nil {
+		n = 0
+	}
+	endSendSpan(span, n, err)
+	*batch = (*batch)[:0]
+	*batchBytes = 0
+	p.traceSent(0)
+
+	if err != nil {
+		s := p.stream
+		p.stream = nil
+		s.abort(err)
+		p.failStream(s, err)
+		back.Sleep()
+		return true
+	}
+	if p.stream.bytes >= cfg.StreamUploadBytes {
+		p.endStream(cfg, httpClient, st, back)
+	}
+	return true
+}
+
+// endStream waits for the service to accept the open frame stream and
+// commits its frames, then streams again the frames of a failed one. It
+// reports whether every streamed frame has been committed, so that newer
+// batches may follow.
+func (p *pipeline) endStream(cfg Config, httpClient *http.Client, st *state, back *backoff) bool {
+	if !p.streaming() {
+		return true
+	}
+	if s := p.stream; s != nil {
+		p.stream = nil
+		if err := s.close(); err != nil {
+			p.failStream(s, err)
+		} else {
+			p.commitStream(cfg, httpClient, st, s)
+		}
+	}
+	if failed := p.failedStream; failed != nil {
+		p.failedStream = nil
+		if err := p.restream(cfg, httpClient, st, failed); err != nil {
+			back.Sleep()
+			return false
+		}
+	}
+	back.Reset()
+	return true
+}
+
+// restream streams the frames of the failed stream failed again, reading
+// them from the WAL one chunk at a time.
+func (p *pipeline) restream(cfg Config, httpClient *http.Client, st *state, failed *frameStream) error {
+	logger.Info().Str("segment", failed.segment).Int("frames", len(failed.frames)).Msg("streaming failed batches again")
+	dir := filepath.Dir(st.IdxPath)
+	s := openFrameStream(cfg, httpClient, failed.segment)
+	for _, chunk := range streamChunks(failed.frames, cfg.MaxBatchBytes) {
+		err := loadFrames(dir, chunk)
+		if err == nil {
+			err = s.write(chunk)
+		}
+		releaseFrames(chunk)
+		if err != nil {
+			s.abort(err)
+			p.failStream(failed, err)
+			return err
+		}
+	}
+	if err := s.close(); err != nil {
+		p.failStream(s, err)
+		return err
+	}
+	p.commitStream(cfg, httpClient, st, s)
+	return nil
+}
+
+// failStream keeps the frames of s to be streamed again.
+func (p *pipeline) failStream(s *frameStream, err error) {
+	var se *statusError
+	if errors.As(err, &se) {
+		logServerError(logger.Error(), err).Int("frames", len(s.frames)).Msg("server rejected frame stream")
+	} else {
+		logger.Error().Err(err).Int("frames", len(s.frames)).Msg("stream batches")
+	}
+	recordEvent(EventError, "stream batches: "+err.Error())
+	metricSendRetries.Inc()
+	p.failedStream = s
+}
+
+// commitStream commits the frames of s, which the service accepted, a chunk
+// at a time. Their bytes are read from the WAL again for the statistics,
+// the ledger and the decoded uploads; if that fails, those go without.
+func (p *pipeline) commitStream(cfg Config, httpClient *http.Client, st *state, s *frameStream) {
+	dir := filepath.Dir(st.IdxPath)
+	for _, chunk := range streamChunks(s.frames, cfg.MaxBatchBytes) {
+		if err := loadFrames(dir, chunk); err != nil {
+			logger.Warn().Err(err).Str("segment", s.segment).Msg("read streamed frames")
+		}
+		commitBatch(cfg, st, chunk, s.segment)
+		shipDecoded(cfg, httpClient, chunk, s.segment)
+		runAfterSend(cfg.PluginHooks, sendInfo(s.segment, chunk), nil)
+		releaseFrames(chunk)
+	}
+}
+
+// streamChunks splits frames into runs of at most maxBytes, by the frame
+// lengths of their metadata, with at least one frame each.
+func streamChunks(frames []batchFrame, maxBytes int) [][]batchFrame {
+	var out [][]batchFrame
+	start, n := 0, 0
+	for i, fr := range frames {
+		size := int(fr.Meta.Len)
+		if i > start && n+size > maxBytes {
+			out = append(out, frames[start:i])
+			start, n = i, 0
+		}
+		n += size
+	}
+	if start < len(frames) {
+		out = append(out, frames[start:])
+	}
+	return out
+}
+
+// loadFrames reads the bytes of frames from the WAL files in dir, checking
+// that they are the bytes read before.
+func loadFrames(dir string, frames []batchFrame) error {
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i := range frames {
+		fm := frames[i].Meta
+		f, ok := files[fm.File]
+		if !ok {
+			var err error
+			if f, err = openGz(filepath.Join(dir, fm.File)); err != nil {
+				return err
+			}
+			files[fm.File] = f
+		}
+		b, err := preadSection(f, int64(fm.Off), int64(fm.Len))
+		if err != nil {
+			return fmt.Errorf("read frame %d of %s: %w", fm.Frame, fm.File, err)
+		}
+		if hashFrame(b) != frames[i].Hash {
+			return fmt.Errorf("frame %d of %s changed since it was read", fm.Frame, fm.File)
+		}
+		frames[i].Compressed = b
+	}
+	return nil
+}
+
+func releaseFrames(frames []batchFrame) {
+	for i := range frames {
+		frames[i].Compressed = nil
+	}
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeStreamWAL writes a segment of n frames to walDir and returns the
+// frames and the length of its index.
+func writeStreamWAL(t *testing.T, walDir string, n int) ([][]byte, int64) {
+	t.Helper()
+	var data []byte
+	var frames [][]byte
+	var metas []FrameMeta
+	for i := 0; i < n; i++ {
+		b := gzipFrame(t, fmt.Sprintf(`{"height":%d}`, i+1))
+		metas = append(metas, FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1), Off: uint64(len(data)), Len: uint64(len(b))})
+		frames = append(frames, b)
+		data = append(data, b...)
+	}
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, l := range writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), metas) {
+		size += int64(l)
+	}
+	return frames, size
+}
+
+// readFrameStream parses a frame stream body into its chunks and payloads.
+func readFrameStream(t *testing.T, body io.Reader) ([]streamChunk, []byte) {
+	t.Helper()
+	var chunks []streamChunk
+	var payload []byte
+	r := bufio.NewReader(body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return chunks, payload
+		}
+		if err != nil {
+			t.Errorf("read chunk header: %v", err)
+			return chunks, payload
+		}
+		var c streamChunk
+		if err := json.Unmarshal(line, &c); err != nil {
+			t.Errorf("chunk header %q: %v", line, err)
+			return chunks, payload
+		}
+		b := make([]byte, c.Bytes)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Errorf("read chunk payload: %v", err)
+			return chunks, payload
+		}
+		chunks = append(chunks, c)
+		payload = append(payload, b...)
+	}
+}
+
+func TestRun_StreamUploads(t *testing.T) {
+	tests := []struct {
+		name        string
+		failStreams int
+	}{
+		{"one stream", 0},
+		{"failed stream sent again", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			walDir := t.TempDir()
+			frames, idxSize := writeStreamWAL(t, walDir, 10)
+
+			var mu sync.Mutex
+			var streams, failed, posts int
+			var got []byte
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch r.URL.Path {
+				case walStreamEndpoint:
+					if r.Header.Get("Content-Type") != frameStreamContentType || r.ContentLength != -1 {
+						t.Errorf("stream request: content type %q, length %d", r.Header.Get("Content-Type"), r.ContentLength)
+					}
+					chunks, payload := readFrameStream(t, r.Body)
+					for _, c := range chunks {
+						if c.Segment != "seg-000001.wal.idx" || len(c.Manifest) == 0 {
+							t.Errorf("chunk header %+v", c)
+						}
+					}
+					if failed < tt.failStreams {
+						failed++
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+					streams++
+					got = append(got, payload...)
+				case walFramesEndpoint:
+					posts++
+					if err := r.ParseMultipartForm(1 << 20); err != nil {
+						t.Errorf("parse batch: %v", err)
+						return
+					}
+					f, _, err := r.FormFile("frames")
+					if err != nil {
+						t.Errorf("frames part: %v", err)
+						return
+					}
+					b, _ := io.ReadAll(f)
+					got = append(got, b...)
+				}
+			}))
+			defer ts.Close()
+
+			// Batches of two frames, streamed together.
+			cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond,
+				SendInterval: time.Hour, HardInterval: time.Hour, MaxBatchBytes: 2*len(frames[0]) + 1, StreamUploadBytes: 1 << 20}
+			if err := Run(context.Background(), cfg); err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if want := bytes.Join(frames, nil); !bytes.Equal(got, want) {
+				t.Errorf("service got %d bytes of frames, want all %d in order", len(got), len(want))
+			}
+			if streams != 1 || failed != tt.failStreams {
+				t.Errorf("accepted %d streams after %d failed, want 1 after %d", streams, failed, tt.failStreams)
+			}
+			// The first frame goes out on its own, as SendInterval has
+			// passed since the last send, and the last one is the remainder.
+			if posts != 2 {
+				t.Errorf("%d batch posts, want 2", posts)
+			}
+			st, err := loadState(cfg.StateDir)
+			if err != nil || st.IdxOffset != idxSize {
+				t.Errorf("committed offset %d (%v), want %d", st.IdxOffset, err, idxSize)
+			}
+		})
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	frames := func(lens ...uint64) []batchFrame {
+		var out []batchFrame
+		for _, l := range lens {
+			out = append(out, batchFrame{Meta: FrameMeta{Len: l}})
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		in   []batchFrame
+		want []int
+	}{
+		{"empty", nil, nil},
+		{"fits one chunk", frames(3, 3, 4), []int{3}},
+		{"split at the limit", frames(4, 4, 4, 4, 2), []int{2, 3}},
+		{"oversized frame alone", frames(2, 20, 2), []int{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, c := range streamChunks(tt.in, 10) {
+				got = append(got, len(c))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("chunk sizes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Built-in subsystems that Config.Disable can turn off. WAL shipping itself
+// cannot be.
+const (
+	// SubsystemConfig watches and ships the node's config files.
+	SubsystemConfig = "config"
+	// SubsystemLag periodically recomputes the lag for the agent stats.
+	SubsystemLag = "lag"
+	// SubsystemHeartbeat logs the lag as a heartbeat line.
+	SubsystemHeartbeat = "heartbeat"
+	// SubsystemResourceGating delays sends while the host is busy.
+	SubsystemResourceGating = "resource-gating"
+	// SubsystemBanner registers the agent and its config with the service.
+	SubsystemBanner = "banner"
+	// SubsystemKeys watches the node and validator keys for rotations.
+	SubsystemKeys = "keys"
+)
+
+// Subsystems lists the subsystems Config.Disable accepts.
+var Subsystems = []string{SubsystemConfig, SubsystemLag, SubsystemHeartbeat, SubsystemResourceGating, SubsystemBanner, SubsystemKeys}
+
+// enabled reports whether the subsystem is not disabled.
+func (c Config) enabled(subsystem string) bool {
+	return !slices.Contains(c.Disable, subsystem)
+}
+
+// normalizeSubsystems trims and lowercases names and rejects unknown ones.
+func normalizeSubsystems(names []string) ([]string, error) {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" {
+			continue
+		}
+		if !slices.Contains(Subsystems, n) {
+			return nil, fmt.Errorf("unknown subsystem %q (want one of %s)", n, strings.Join(Subsystems, ", "))
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
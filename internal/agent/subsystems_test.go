@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSubsystems(t *testing.T) {
+	got, err := normalizeSubsystems([]string{" Config", "", "resource-gating"})
+	if err != nil || len(got) != 2 || got[0] != SubsystemConfig || got[1] != SubsystemResourceGating {
+		t.Errorf("normalizeSubsystems = %v, %v", got, err)
+	}
+	if _, err := normalizeSubsystems([]string{"wal"}); err == nil {
+		t.Error("WAL shipping accepted as a subsystem to disable")
+	}
+}
+
+func TestResourcesOK_GatingDisabled(t *testing.T) {
+	cfg := Config{CPUThreshold: 0.0001, NetThreshold: 0.0001, Disable: []string{SubsystemResourceGating}}
+	for i := 0; i < 3; i++ {
+		if !resourcesOK(cfg) {
+			t.Fatal("disabled resource gating delayed a send")
+		}
+		time.Sleep(resourceSampleEvery / 10)
+	}
+}
+
+func TestRun_DisableSubsystems(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walFramesEndpoint && r.URL.Path != pingEndpoint {
+			t.Errorf("disabled subsystem posted to %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	home := t.TempDir()
+	writeNodeHome(t, home, "chain")
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAA"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{{File: "seg-000001.wal.gz", Frame: 1, Len: 4}})
+
+	cfg := DefaultConfig()
+	cfg.ServiceURL, cfg.NodeHome, cfg.WALDir, cfg.StateDir = ts.URL, home, walDir, t.TempDir()
+	cfg.PollInterval, cfg.MaxPollInterval = time.Millisecond, time.Hour
+	cfg.Disable = []string{SubsystemConfig, SubsystemLag, SubsystemBanner, SubsystemKeys}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, cfg) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !CurrentStats().Ready && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !CurrentStats().Ready {
+		t.Fatal("pipeline not ready")
+	}
+	for _, name := range []string{"config", "lag", "keys"} {
+		if _, ok := Scrapers()[name]; ok {
+			t.Errorf("disabled scraper %s registered", name)
+		}
+	}
+	if err := SetScraperEnabled("config", true); err == nil {
+		t.Error("disabled config shipping could be enabled at runtime")
+	}
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SupervisorEvent is a lifecycle transition of a Supervisor's agent.
+type SupervisorEvent struct {
+	// State is StateStarting before each run, StateCrashed after a run
+	// failed and StateStopped once the supervisor returns.
+	State string
+	// Attempt numbers the runs, from 1.
+	Attempt int
+	// Err is why the run failed, for StateCrashed, or why the supervisor
+	// gave up, for StateStopped.
+	Err error
+	// Delay is how long the supervisor waits before the next run after a
+	// crash; 0 if there is none.
+	Delay time.Duration
+}
+
+// Supervisor runs the agent and restarts it, with exponential backoff,
+// whenever Run fails or panics before ctx is done. It gives up once
+// MaxRestarts restarts happened within RestartWindow. Panics in goroutines
+// the agent starts are not recovered.
+type Supervisor struct {
+	Config Config
+	// MaxRestarts is the restart budget per RestartWindow; 0 never
+	// restarts.
+	MaxRestarts   int
+	RestartWindow time.Duration
+	// BackoffBase is the delay before the first restart, doubled for each
+	// further one within RestartWindow up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// OnStateChange, if set, is called on every transition, from the
+	// goroutine calling Run.
+	OnStateChange func(SupervisorEvent)
+
+	run func(context.Context, Config) error // Run; tests replace it
+}
+
+// NewSupervisor returns a Supervisor of cfg's agent that allows 5 restarts
+// in 10 minutes, 1s apart at first and at most a minute.
+func NewSupervisor(cfg Config) *Supervisor {
+	return &Supervisor{
+		Config:        cfg,
+		MaxRestarts:   5,
+		RestartWindow: 10 * time.Minute,
+		BackoffBase:   time.Second,
+		BackoffMax:    time.Minute,
+	}
+}
+
+// Run runs the agent until ctx is done, returning nil, or until a run
+// fails with the restart budget spent, returning that run's error.
+func (s *Supervisor) Run(ctx context.Context) error {
+	run := s.run
+	if run == nil {
+		run = Run
+	}
+	back := newBackoff(s.BackoffBase, s.BackoffMax)
+	var restarts []time.Time
+	for attempt := 1; ; attempt++ {
+		s.emit(SupervisorEvent{State: StateStarting, Attempt: attempt})
+		err := runRecovered(ctx, run, s.Config)
+		if ctx.Err() != nil {
+			s.emit(SupervisorEvent{State: StateStopped, Attempt: attempt})
+			return nil
+		}
+		if err == nil {
+			// Only Once runs end on their own.
+			s.emit(SupervisorEvent{State: StateStopped, Attempt: attempt})
+			return nil
+		}
+
+		now := time.Now()
+		n := 0
+		for _, t := range restarts {
+			if now.Sub(t) < s.RestartWindow {
+				restarts[n] = t
+				n++
+			}
+		}
+		restarts = restarts[:n]
+		if n == 0 {
+			back.Reset()
+		}
+		setLifecycle(StateCrashed)
+		if n >= s.MaxRestarts {
+			logger.Error().Err(err).Int("attempt", attempt).Msg("agent crashed; restart budget spent")
+			recordEvent(EventError, fmt.Sprintf("agent crashed, not restarting: %v", err))
+			s.emit(SupervisorEvent{State: StateCrashed, Attempt: attempt, Err: err})
+			err = fmt.Errorf("agent crashed %d times within %s: %w", n+1, s.RestartWindow, err)
+			s.emit(SupervisorEvent{State: StateStopped, Attempt: attempt, Err: err})
+			setLifecycle(StateStopped)
+			return err
+		}
+		delay := back.next()
+		logger.Error().Err(err).Int("attempt", attempt).Dur("delay", delay).Msg("agent crashed; restarting")
+		recordEvent(EventError, fmt.Sprintf("agent crashed, restarting in %s: %v", delay.Round(time.Millisecond), err))
+		s.emit(SupervisorEvent{State: StateCrashed, Attempt: attempt, Err: err, Delay: delay})
+		restarts = append(restarts, now)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			setLifecycle(StateStopped)
+			s.emit(SupervisorEvent{State: StateStopped, Attempt: attempt})
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Supervisor) emit(ev SupervisorEvent) {
+	if s.OnStateChange != nil {
+		s.OnStateChange(ev)
+	}
+}
+
+// runRecovered calls run, turning a panic into an error.
+func runRecovered(ctx context.Context, run func(context.Context, Config) error, cfg Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx, cfg)
+}
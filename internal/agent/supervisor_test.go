@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	tests := []struct {
+		name     string
+		runs     []func(context.Context) error // one per attempt
+		restarts int
+		want     []string // states reported
+		wantErr  bool
+	}{
+		{
+			name:     "restarts after errors and panics",
+			runs:     []func(context.Context) error{failRun, panicRun, okRun},
+			restarts: 5,
+			want:     []string{StateStarting, StateCrashed, StateStarting, StateCrashed, StateStarting, StateStopped},
+		},
+		{
+			name:     "budget spent",
+			runs:     []func(context.Context) error{failRun, failRun, failRun},
+			restarts: 1,
+			want:     []string{StateStarting, StateCrashed, StateStarting, StateCrashed, StateStopped},
+			wantErr:  true,
+		},
+		{
+			name:     "no restarts",
+			runs:     []func(context.Context) error{panicRun},
+			restarts: 0,
+			want:     []string{StateStarting, StateCrashed, StateStopped},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSupervisor(Config{})
+			s.MaxRestarts, s.BackoffBase, s.BackoffMax = tt.restarts, time.Millisecond, 4*time.Millisecond
+			attempt := 0
+			s.run = func(ctx context.Context, _ Config) error {
+				attempt++
+				return tt.runs[attempt-1](ctx)
+			}
+			var got []SupervisorEvent
+			s.OnStateChange = func(ev SupervisorEvent) { got = append(got, ev) }
+
+			err := s.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %+v, want states %v", got, tt.want)
+			}
+			for i, ev := range got {
+				if ev.State != tt.want[i] {
+					t.Errorf("event %d = %+v, want %s", i, ev, tt.want[i])
+				}
+				if ev.State == StateCrashed && ev.Err == nil {
+					t.Errorf("crash event %d without error", i)
+				}
+			}
+			if last := got[len(got)-2]; tt.wantErr && last.Delay != 0 {
+				t.Errorf("final crash announced a restart in %s", last.Delay)
+			}
+		})
+	}
+}
+
+func TestSupervisor_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSupervisor(Config{})
+	s.BackoffBase = time.Hour
+	s.run = func(context.Context, Config) error { return errors.New("boom") }
+	s.OnStateChange = func(ev SupervisorEvent) {
+		if ev.State == StateCrashed {
+			cancel() // while waiting to restart
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v, want nil after cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor kept waiting after cancel")
+	}
+	if state := lifecycle.Load(); state != StateStopped {
+		t.Errorf("lifecycle = %v, want stopped", state)
+	}
+}
+
+func failRun(context.Context) error  { return errors.New("service unreachable") }
+func panicRun(context.Context) error { panic("nil map") }
+func okRun(context.Context) error    { return nil }
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// uploadBucket is shared by the uploads of every pipeline, as they share
+// the node's NIC.
+var uploadBucket = &tokenBucket{}
+
+// readBucket is shared by the WAL reads of every pipeline and of backfill,
+// as they share the node's disks.
+var readBucket = &tokenBucket{}
+
+// uploadThrottled is whether the last upload body had to wait for the
+// bucket; it only changes under uploadBucket.mu.
+var uploadThrottled bool
+
+// tokenBucket holds up to one second's worth of bytes at rate and refills
+// continuously.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// take spends n bytes at rate bytes per second and returns how long the
+// caller must wait before sending them. A changed rate starts a full bucket.
+func (b *tokenBucket) take(rate, n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := float64(rate)
+	if b.rate != r {
+		b.rate, b.tokens, b.last = r, r, now
+	}
+	b.tokens = min(r, b.tokens+now.Sub(b.last).Seconds()*r)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / r * float64(time.Second))
+}
+
+// throttleBody limits the rate at which req's body is read, and so written
+// to the network, to cfg.MaxUploadBytesPerSec. ContentLength is kept, and
+// bodies recreated by GetBody are throttled too. The waits end with req's
+// context.
+func throttleBody(req *http.Request, cfg Config) {
+	rate := cfg.MaxUploadBytesPerSec
+	if rate <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	ctx := req.Context()
+	req.Body = &throttledBody{ctx: ctx, rc: req.Body, rate: rate}
+	if get := req.GetBody; get != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			rc, err := get()
+			if err != nil {
+				return nil, err
+			}
+			return &throttledBody{ctx: ctx, rc: rc, rate: rate}, nil
+		}
+	}
+}
+
+// uploadPace is how long n bytes take to upload at rate bytes per second,
+// or 0 if rate is unlimited.
+func uploadPace(rate int, n int64) time.Duration {
+	if rate <= 0 || n <= 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / float64(rate) * float64(time.Second))
+}
+
+// pacedClient returns httpClient with its timeout extended by the time req's
+// body takes at cfg.MaxUploadBytesPerSec, so that HTTPTimeout bounds the wait
+// on the service rather than the pacing: otherwise a full batch at a low
+// rate would time out, be split and time out again.
+func pacedClient(httpClient *http.Client, req *http.Request, cfg Config) *http.Client {
+	d := uploadPace(cfg.MaxUploadBytesPerSec, req.ContentLength)
+	if d == 0 || httpClient.Timeout <= 0 {
+		return httpClient
+	}
+	c := *httpClient
+	c.Timeout += d
+	return &c
+}
+
+// throttledBody reads at most one second's worth of bytes at a time and
+// waits for uploadBucket after each read, or until ctx is done.
+type throttledBody struct {
+	ctx    context.Context
+	rc     io.ReadCloser
+	rate   int
+	waited bool
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.rc.Read(p)
+	if n > 0 {
+		if d := uploadBucket.take(t.rate, n, time.Now()); d > 0 {
+			if !t.waited {
+				t.waited = true
+				setUploadThrottled(true, t.rate)
+			}
+			metricUploadThrottleSeconds.Add(d.Seconds())
+			sleepCtx(t.ctx, d, nil)
+			if err == nil {
+				err = t.ctx.Err()
+			}
+		}
+	}
+	if err == io.EOF && !t.waited {
+		setUploadThrottled(false, t.rate)
+	}
+	return n, err
+}
+
+func (t *throttledBody) Close() error { return t.rc.Close() }
+
+// setUploadThrottled records a change of the throttle state: throttled
+// once an upload has to wait, released once one goes through unhindered.
+func setUploadThrottled(throttled bool, rate int) {
+	uploadBucket.mu.Lock()
+	changed := uploadThrottled != throttled
+	uploadThrottled = throttled
+	uploadBucket.mu.Unlock()
+	if !changed {
+		return
+	}
+	if throttled {
+		metricUploadThrottled.Set(1)
+		logger.Info().Int("bytes_per_sec", rate).Msg("upload throttled")
+		recordEvent(EventState, fmt.Sprintf("upload throttled to %d bytes/s", rate))
+		return
+	}
+	metricUploadThrottled.Set(0)
+	logger.Info().Msg("upload no longer throttled")
+	recordEvent(EventState, "upload no longer throttled")
+}
+
+// throttleRead waits after n bytes were read from the WAL until reads are
+// back within cfg.MaxReadBytesPerSec, or ctx is done.
+func throttleRead(ctx context.Context, cfg Config, n int) {
+	rate := cfg.MaxReadBytesPerSec
+	if rate <= 0 {
+		return
+	}
+	if d := readBucket.take(rate, n, time.Now()); d > 0 {
+		metricReadThrottleSeconds.Add(d.Seconds())
+		sleepCtx(ctx, d, nil)
+	}
+}
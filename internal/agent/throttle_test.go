@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	tests := []struct {
+		name  string
+		takes []int           // bytes taken at rate 1000/s
+		at    []time.Duration // since start
+		want  []time.Duration
+	}{
+		{"within burst", []int{400, 600}, []time.Duration{0, 0}, []time.Duration{0, 0}},
+		{"over burst", []int{1000, 500}, []time.Duration{0, 0}, []time.Duration{0, 500 * time.Millisecond}},
+		{"refills", []int{1000, 500}, []time.Duration{0, 500 * time.Millisecond}, []time.Duration{0, 0}},
+		{"debt carries over", []int{1000, 1000, 500}, []time.Duration{0, 0, time.Second}, []time.Duration{0, time.Second, 500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b tokenBucket
+			for i, n := range tt.takes {
+				if got := b.take(1000, n, start.Add(tt.at[i])); got != tt.want[i] {
+					t.Errorf("take %d: wait = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestThrottleBody(t *testing.T) {
+	oldBucket, oldThrottled := uploadBucket, uploadThrottled
+	uploadBucket, uploadThrottled = &tokenBucket{}, false
+	defer func() { uploadBucket, uploadThrottled = oldBucket, oldThrottled }()
+
+	var got []byte
+	var gotLen int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		gotLen = r.ContentLength
+	}))
+	defer ts.Close()
+
+	cfg := Config{MaxUploadBytesPerSec: 10000}
+	post := func(n int) time.Duration {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(make([]byte, n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		throttleBody(req, cfg)
+		start := time.Now()
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if len(got) != n || gotLen != int64(n) {
+			t.Fatalf("server got %d bytes, content length %d; want %d", len(got), gotLen, n)
+		}
+		return time.Since(start)
+	}
+
+	// A second's worth goes out at once, the rest at the rate.
+	if d := post(15000); d < 400*time.Millisecond {
+		t.Errorf("15000 bytes at 10000/s took %v", d)
+	}
+	if metricUploadThrottled.Value() != 1 {
+		t.Error("throttle gauge not set")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if d := post(1000); d > 400*time.Millisecond {
+		t.Errorf("1000 bytes after a refill took %v", d)
+	}
+	if metricUploadThrottled.Value() != 0 {
+		t.Error("throttle gauge not cleared")
+	}
+}
+
+func TestThrottleRead(t *testing.T) {
+	oldBucket := readBucket
+	readBucket = &tokenBucket{}
+	defer func() { readBucket = oldBucket }()
+
+	ctx := context.Background()
+	start := time.Now()
+	throttleRead(ctx, Config{}, 1<<20)
+	throttleRead(ctx, Config{MaxReadBytesPerSec: 10000}, 10000)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("reads within the burst waited %v", d)
+	}
+	throttleRead(ctx, Config{MaxReadBytesPerSec: 10000}, 3000)
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("3000 bytes over the burst at 10000/s waited only %v", d)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	throttleRead(cctx, Config{MaxReadBytesPerSec: 10000}, 100000)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("canceled read waited %v", d)
+	}
+}
+
+func TestThrottleBody_HonorsContext(t *testing.T) {
+	oldBucket := uploadBucket
+	uploadBucket = &tokenBucket{}
+	defer func() { uploadBucket = oldBucket }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.invalid", bytes.NewReader(make([]byte, 3000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttleBody(req, Config{MaxUploadBytesPerSec: 1000})
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = io.ReadAll(req.Body)
+	if err != context.Canceled {
+		t.Errorf("read error = %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("read took %v after cancel", d)
+	}
+}
+
+func TestPacedClient(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	req, err := http.NewRequest(http.MethodPost, "http://example.invalid", bytes.NewReader(make([]byte, 5000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pacedClient(client, req, Config{MaxUploadBytesPerSec: 1000}).Timeout; got != 6*time.Second {
+		t.Errorf("timeout = %v, want 6s", got)
+	}
+	if got := pacedClient(client, req, Config{}); got != client {
+		t.Error("unthrottled upload got a new client")
+	}
+	if client.Timeout != time.Second {
+		t.Error("pacedClient changed its argument")
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// checkClientTLS reports whether the client certificate and CA files of cfg
+// can be loaded.
+func checkClientTLS(cfg Config) error {
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return errors.New("tls-client-cert and tls-client-key must be set together")
+	}
+	if cfg.ClientCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile); err != nil {
+			return fmt.Errorf("tls client certificate: %w", err)
+		}
+	}
+	if cfg.CAFile != "" {
+		if _, err := loadCAFile(cfg.CAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCAFile returns the system roots plus the PEM certificates of path.
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tls ca file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls ca file %s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// applyClientTLS adds the client certificate, CA and verification settings
+// of cfg to c. The key pair is read on every handshake that asks for it, so
+// a rotated certificate is picked up without a restart. A CA file that can
+// no longer be read trusts no server rather than falling back to the system
+// roots.
+func applyClientTLS(c *tls.Config, cfg Config) {
+	if cfg.ClientCertFile != "" {
+		certFile, keyFile := cfg.ClientCertFile, cfg.ClientKeyFile
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("tls client certificate: %w", err)
+			}
+			return &cert, nil
+		}
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile(cfg.CAFile)
+		if err != nil {
+			logger.Error().Err(err).Msg("tls: loading ca file failed, no server will be trusted")
+			pool = x509.NewCertPool()
+		}
+		c.RootCAs = pool
+	}
+	c.InsecureSkipVerify = cfg.InsecureSkipVerify
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// tlsPin is a SHA-256 hash of a certificate's public key (SPKI) or of the
+// whole certificate.
+type tlsPin struct {
+	spki bool
+	hash [sha256.Size]byte
+}
+
+// parseTLSPin parses "sha256/<base64>", the SPKI pin format of HPKP and
+// curl's --pinnedpubkey (whose "sha256//" is accepted too), or
+// "cert-sha256/<hex>", a certificate fingerprint as openssl prints it.
+func parseTLSPin(s string) (tlsPin, error) {
+	var p tlsPin
+	var b []byte
+	var err error
+	switch {
+	case strings.HasPrefix(s, "sha256/"):
+		p.spki = true
+		b, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "sha256/"), "/"))
+	case strings.HasPrefix(s, "cert-sha256/"):
+		b, err = hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(s, "cert-sha256/"), ":", ""))
+	default:
+		return p, fmt.Errorf("tls pin %q must start with sha256/ (public key) or cert-sha256/ (certificate)", s)
+	}
+	if err != nil || len(b) != sha256.Size {
+		return p, fmt.Errorf("tls pin %q is not a SHA-256 hash", s)
+	}
+	copy(p.hash[:], b)
+	return p, nil
+}
+
+func parseTLSPins(pins []string) ([]tlsPin, error) {
+	out := make([]tlsPin, 0, len(pins))
+	for _, s := range pins {
+		p, err := parseTLSPin(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (p tlsPin) matches(c *x509.Certificate) bool {
+	if p.spki {
+		h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		return bytes.Equal(h[:], p.hash[:])
+	}
+	h := sha256.Sum256(c.Raw)
+	return bytes.Equal(h[:], p.hash[:])
+}
+
+// spkiPin formats c's public key pin, for telling operators what the
+// server presented.
+func spkiPin(c *x509.Certificate) string {
+	h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// serviceTLSConfig returns the TLS config of the agent's connections. With
+// cfg.TLSPins a connection dialed to the ingestion service, the host of
+// ServiceURL or GRPCTarget, is only accepted, after the usual verification,
+// if a certificate of its chain matches a pin, so a rogue CA or an
+// intercepting proxy trusted by the host cannot read the WAL. The dialed
+// name decides, not the certificate, which the server chooses. A
+// connection dialed by IP sends no server name, so it is pinned if the
+// service is addressed by IP. Other servers, such as a remote-write
+// endpoint, are not pinned, unless InsecureSkipVerify is set: then pins
+// are the only check and apply to every connection. The client certificate
+// and CA of cfg apply to every connection.
+func serviceTLSConfig(cfg Config) *tls.Config {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	applyClientTLS(c, cfg)
+	pins, _ := parseTLSPins(cfg.TLSPins) // validated with the config
+	if len(pins) == 0 {
+		return c
+	}
+	var hosts []string
+	if u, err := url.Parse(cfg.ServiceURL); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	if host, _, err := net.SplitHostPort(cfg.GRPCTarget); err == nil {
+		hosts = append(hosts, host)
+	}
+	pinned := func(serverName string) bool {
+		if cfg.InsecureSkipVerify {
+			return true
+		}
+		if serverName == "" {
+			return slices.ContainsFunc(hosts, func(h string) bool { return net.ParseIP(h) != nil })
+		}
+		return slices.ContainsFunc(hosts, func(h string) bool { return strings.EqualFold(h, serverName) })
+	}
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("tls pin: server sent no certificate")
+		}
+		if !pinned(cs.ServerName) {
+			return nil
+		}
+		return verifyPins(pins, cs)
+	}
+	return c
+}
+
+// kafkaTLSConfig returns the TLS config of the Kafka broker connections:
+// the client certificate and CA of cfg as for the service, and TLSPins
+// checked on every broker, as the brokers take the service's place for the
+// frames.
+func kafkaTLSConfig(cfg Config) *tls.Config {
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	applyClientTLS(c, cfg)
+	if pins, _ := parseTLSPins(cfg.TLSPins); len(pins) > 0 {
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls pin: server sent no certificate")
+			}
+			return verifyPins(pins, cs)
+		}
+	}
+	return c
+}
+
+// verifyPins accepts cs if a certificate of its verified chains, or of the
+// presented chain if it was not verified, matches one of pins.
+func verifyPins(pins []tlsPin, cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			for _, p := range pins {
+				if p.matches(cert) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("tls pin mismatch: server presented %s", spkiPin(cs.PeerCertificates[0]))
+}
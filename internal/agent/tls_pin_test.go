@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTLSPin(t *testing.T) {
+	hash := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		pin     string
+		spki    bool
+		wantErr bool
+	}{
+		{pin: "sha256/" + hash, spki: true},
+		{pin: "sha256//" + hash, spki: true},
+		{pin: "cert-sha256/" + strings.Repeat("AB:", 31) + "AB"},
+		{pin: "cert-sha256/" + strings.Repeat("ab", 32)},
+		{pin: hash, wantErr: true},
+		{pin: "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 20)), wantErr: true},
+		{pin: "cert-sha256/zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := parseTLSPin(tt.pin)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTLSPin(%q) err = %v, wantErr %v", tt.pin, err, tt.wantErr)
+			continue
+		}
+		if err == nil && p.spki != tt.spki {
+			t.Errorf("parseTLSPin(%q).spki = %v", tt.pin, p.spki)
+		}
+	}
+}
+
+func TestNewHTTPClient_TLSPins(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	cert := ts.Certificate()
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	fingerprint := sha256.Sum256(cert.Raw)
+	spkiPin := "sha256/" + base64.StdEncoding.EncodeToString(spki[:])
+	otherPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		name       string
+		serviceURL string
+		pins       []string
+		insecure   bool
+		wantErr    bool
+	}{
+		{name: "no pins", serviceURL: ts.URL},
+		{name: "public key pin", serviceURL: ts.URL, pins: []string{otherPin, spkiPin}},
+		{name: "certificate pin", serviceURL: ts.URL, pins: []string{fmt.Sprintf("cert-sha256/%X", fingerprint)}},
+		{name: "mismatch", serviceURL: ts.URL, pins: []string{otherPin}, wantErr: true},
+		// The test server is not the service, e.g. a remote-write endpoint.
+		{name: "other host", serviceURL: "https://api.apphash.io", pins: []string{otherPin}},
+		// The certificate is valid for example.com, but the connection was
+		// not dialed to it.
+		{name: "certificate valid for the service", serviceURL: "https://example.com", pins: []string{otherPin}},
+		// Without verification pins are the only check, on every host.
+		{name: "insecure other host", serviceURL: "https://api.apphash.io", pins: []string{otherPin}, insecure: true, wantErr: true},
+		{name: "insecure pin", serviceURL: "https://api.apphash.io", pins: []string{spkiPin}, insecure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newHTTPClient(Config{ServiceURL: tt.serviceURL, TLSPins: tt.pins, InsecureSkipVerify: tt.insecure})
+			roots := x509.NewCertPool()
+			roots.AddCert(cert)
+			client.Transport.(countingTransport).next.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+			resp, err := client.Get(ts.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GET err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "tls pin mismatch: server presented "+spkiPin) {
+				t.Errorf("err = %v, want the presented pin", err)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	ts.StartTLS()
+	defer ts.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "walship"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.TLS.ClientCAs.AddCert(clientCert)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	for path, data := range map[string][]byte{
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+	} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "client cert and ca", cfg: Config{ClientCertFile: certFile, ClientKeyFile: keyFile, CAFile: caFile}},
+		{name: "no client cert", cfg: Config{CAFile: caFile}, wantErr: true},
+		{name: "unknown ca", cfg: Config{ClientCertFile: certFile, ClientKeyFile: keyFile}, wantErr: true},
+		{name: "insecure skip verify", cfg: Config{ClientCertFile: certFile, ClientKeyFile: keyFile, InsecureSkipVerify: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkClientTLS(tt.cfg); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := newHTTPClient(tt.cfg).Get(ts.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GET err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := checkClientTLS(Config{ClientCertFile: certFile}); err == nil {
+		t.Error("checkClientTLS accepted a certificate without a key")
+	}
+	if err := checkClientTLS(Config{CAFile: keyFile}); err == nil {
+		t.Error("checkClientTLS accepted a CA file without certificates")
+	}
+}
+
+func TestKafkaTLSConfig_CAAndPins(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	cert := ts.Certificate()
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addr := ts.Listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "ca file", cfg: Config{CAFile: caFile}},
+		{name: "without ca file", wantErr: "certificate"},
+		{name: "pin", cfg: Config{CAFile: caFile, TLSPins: []string{"sha256/" + base64.StdEncoding.EncodeToString(spki[:])}}},
+		// Brokers are pinned whatever name they are dialed by.
+		{name: "pin mismatch", cfg: Config{CAFile: caFile, TLSPins: []string{"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))}}, wantErr: "tls pin mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", addr, kafkaTLSConfig(tt.cfg))
+			if err == nil {
+				conn.Close()
+			}
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("dial err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/sender"
+)
+
+const tombstonesEndpoint = "/v1/ingest/tombstones"
+
+// Reasons for deliberately skipping WAL data.
+const (
+	// TombstoneCorruptIndex is an index line that does not parse; the
+	// frame it described cannot be located.
+	TombstoneCorruptIndex = "corrupt_index"
+	// TombstoneAnonymizeFailed is a frame dropped because it could not be
+	// anonymized.
+	TombstoneAnonymizeFailed = "anonymize_failed"
+	// TombstoneSpoolEvicted is a spooled batch dropped undelivered; Detail
+	// says which spool bound evicted it.
+	TombstoneSpoolEvicted = "spool_evicted"
+	// TombstoneSampled is a run of frames a frame filter or sampler
+	// rejected; Detail lists the samplers set.
+	TombstoneSampled = "sampled"
+)
+
+// maxTombstones bounds the queue of undelivered tombstones; the oldest are
+// dropped beyond it.
+const maxTombstones = 1000
+
+// Tombstone describes a range of WAL data walship chose not to ship, so the
+// service can tell a deliberate gap from an unknown loss. Consecutive frames
+// skipped for the same reason share one tombstone.
+type Tombstone struct {
+	Segment    string `json:"segment,omitempty"` // index file
+	File       string `json:"file,omitempty"`    // gz file of the first frame
+	FirstFrame uint64 `json:"first_frame,omitempty"`
+	LastFrame  uint64 `json:"last_frame,omitempty"`
+	// IdxOffset is the offset of a corrupt index line.
+	IdxOffset int64     `json:"idx_offset,omitempty"`
+	Frames    int       `json:"frames"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// extends reports whether t continues prev, so that they can be merged.
+func (t Tombstone) extends(prev Tombstone) bool {
+	return t.Reason == prev.Reason && t.Detail == prev.Detail && t.Segment == prev.Segment && t.File == prev.File &&
+		t.Frames > 0 && prev.Frames > 0 && t.FirstFrame == prev.LastFrame+1
+}
+
+func tombstoneFile(dir string) string {
+	return filepath.Join(dir, "tombstones.json")
+}
+
+// tombstonesMu serializes access to the tombstone queues, which live in the
+// state dirs so that no tombstone is lost to a restart.
+var tombstonesMu sync.Mutex
+
+func loadTombstones(dir string) ([]Tombstone, error) {
+	var ts []Tombstone
+	err := readJSON(tombstoneFile(dir), &ts)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return ts, err
+}
+
+// addTombstone queues t for the service, merging it into the last queued
+// tombstone when it continues it.
+func addTombstone(cfg Config, t Tombstone) {
+	if t.At.IsZero() {
+		t.At = time.Now().UTC()
+	}
+	metricFramesSkipped.Add(float64(t.Frames))
+	recordEvent(EventState, "skipped "+t.describe())
+
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	ts, err := loadTombstones(cfg.StateDir)
+	if err != nil {
+		logger.Warn().Err(err).Msg("tombstones unreadable, starting a new queue")
+		ts = nil
+	}
+	if n := len(ts); n > 0 && t.extends(ts[n-1]) {
+		last := &ts[n-1]
+		last.LastFrame = t.LastFrame
+		last.Frames += t.Frames
+		last.Bytes += t.Bytes
+	} else {
+		ts = append(ts, t)
+	}
+	if n := len(ts) - maxTombstones; n > 0 {
+		logger.Warn().Int("dropped", n).Msg("tombstone queue full, dropping the oldest")
+		ts = ts[n:]
+	}
+	if err := writeJSONAtomic(cfg.StateDir, tombstoneFile(cfg.StateDir), ts); err != nil {
+		logger.Error().Err(err).Msg("save tombstones")
+	}
+}
+
+func (t Tombstone) describe() string {
+	what := fmt.Sprintf("%d frames", t.Frames)
+	switch {
+	case t.Frames == 1:
+		what = fmt.Sprintf("frame %d", t.FirstFrame)
+	case t.Frames > 1:
+		what = fmt.Sprintf("frames %d-%d", t.FirstFrame, t.LastFrame)
+	case t.Reason == TombstoneCorruptIndex:
+		what = fmt.Sprintf("index line at offset %d", t.IdxOffset)
+	}
+	if t.Segment != "" {
+		what += " of " + t.Segment
+	}
+	reason := t.Reason
+	if t.Detail != "" {
+		reason += ": " + t.Detail
+	}
+	return what + " (" + reason + ")"
+}
+
+// spoolTombstone describes a batch evicted from the spool.
+func spoolTombstone(b sender.EvictedBatch) Tombstone {
+	t := Tombstone{Segment: b.Segment, Frames: len(b.Frames), Bytes: b.Bytes, Reason: TombstoneSpoolEvicted, Detail: b.Reason}
+	if len(b.Frames) > 0 {
+		t.File = b.Frames[0].File
+		t.FirstFrame = b.Frames[0].Frame
+		t.LastFrame = b.Frames[len(b.Frames)-1].Frame
+	}
+	return t
+}
+
+// flushTombstones uploads the queued tombstones, at most once per backoff
+// step while the service rejects them. Tombstones queued meanwhile stay
+// for the next flush.
+func (p *pipeline) flushTombstones(cfg Config, httpClient *http.Client) {
+	if time.Now().Before(p.tombstonesAt) {
+		return
+	}
+	tombstonesMu.Lock()
+	ts, err := loadTombstones(cfg.StateDir)
+	tombstonesMu.Unlock()
+	if err != nil || len(ts) == 0 {
+		return
+	}
+	if err := postTombstones(cfg, httpClient, ts); err != nil {
+		logServerError(logger.Warn().Err(err), err).Int("tombstones", len(ts)).Msg("upload tombstones")
+		p.tombstonesAt = time.Now().Add(p.tombstonesBack.next())
+		return
+	}
+	p.tombstonesBack.Reset()
+	logger.Info().Int("tombstones", len(ts)).Msg("sent tombstones")
+
+	tombstonesMu.Lock()
+	defer tombstonesMu.Unlock()
+	cur, err := loadTombstones(cfg.StateDir)
+	if err != nil {
+		return
+	}
+	// The last tombstone sent may have been extended since; only the
+	// extension is left to send then.
+	n := min(len(ts), len(cur))
+	if n == 0 {
+		return
+	}
+	if last, sent := &cur[n-1], ts[n-1]; *last != sent {
+		last.FirstFrame = sent.LastFrame + 1
+		last.Frames -= sent.Frames
+		last.Bytes -= sent.Bytes
+		n--
+	}
+	if err := writeJSONAtomic(cfg.StateDir, tombstoneFile(cfg.StateDir), cur[n:]); err != nil {
+		logger.Error().Err(err).Msg("save tombstones")
+	}
+}
+
+func postTombstones(cfg Config, httpClient *http.Client, ts []Tombstone) error {
+	body, err := json.Marshal(struct {
+		Tombstones []Tombstone `json:"tombstones"`
+	}{ts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(activePipeline(cfg).sendContext(), http.MethodPost, ingestURL(cfg, tombstonesEndpoint), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/sender"
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func TestAddTombstone_Merges(t *testing.T) {
+	cfg := Config{StateDir: t.TempDir()}
+	drop := func(frame uint64) Tombstone {
+		return Tombstone{Segment: "seg-000001.wal.idx", File: "seg-000001.wal.gz", FirstFrame: frame, LastFrame: frame,
+			Frames: 1, Bytes: 10, Reason: TombstoneAnonymizeFailed}
+	}
+	addTombstone(cfg, drop(3))
+	addTombstone(cfg, drop(4))
+	addTombstone(cfg, drop(6))
+	addTombstone(cfg, spoolTombstone(sender.EvictedBatch{Segment: "seg-000001.wal.idx", Bytes: 20, Reason: sender.EvictMaxAge,
+		Frames: []wal.FrameMeta{{File: "seg-000001.wal.gz", Frame: 7}, {File: "seg-000001.wal.gz", Frame: 8}}}))
+
+	ts, err := loadTombstones(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"frames 3-4 of seg-000001.wal.idx (anonymize_failed)",
+		"frame 6 of seg-000001.wal.idx (anonymize_failed)",
+		"frames 7-8 of seg-000001.wal.idx (spool_evicted: max_age)",
+	}
+	if len(ts) != len(want) {
+		t.Fatalf("tombstones = %+v", ts)
+	}
+	for i, w := range want {
+		if got := ts[i].describe(); got != w {
+			t.Errorf("tombstone %d = %q, want %q", i, got, w)
+		}
+	}
+	if ts[0].Bytes != 20 || ts[0].Frames != 2 {
+		t.Errorf("merged tombstone = %+v", ts[0])
+	}
+}
+
+func TestFlushTombstones(t *testing.T) {
+	cfg := Config{StateDir: t.TempDir()}
+	drop := func(frame uint64) Tombstone {
+		return Tombstone{Segment: "s.idx", FirstFrame: frame, LastFrame: frame, Frames: 1, Reason: TombstoneAnonymizeFailed}
+	}
+
+	var mu sync.Mutex
+	var got [][]Tombstone
+	status := http.StatusServiceUnavailable
+	next := uint64(2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tombstonesEndpoint {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var body struct{ Tombstones []Tombstone }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got = append(got, body.Tombstones)
+		code := status
+		frame := next
+		next++
+		mu.Unlock()
+		// A frame dropped during the upload extends the last tombstone.
+		addTombstone(cfg, drop(frame))
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+	cfg.ServiceURL = ts.URL
+
+	p := &pipeline{tombstonesBack: newBackoff(time.Hour, time.Hour)}
+	addTombstone(cfg, drop(1))
+	p.flushTombstones(cfg, http.DefaultClient)
+	p.flushTombstones(cfg, http.DefaultClient) // backing off
+	if len(got) != 1 {
+		t.Fatalf("uploads = %d, want 1 while backing off", len(got))
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	p.tombstonesAt = time.Time{}
+	p.flushTombstones(cfg, http.DefaultClient)
+	if len(got) != 2 || len(got[1]) != 1 || got[1][0].LastFrame != 2 {
+		t.Fatalf("second upload = %+v", got)
+	}
+	left, _ := loadTombstones(cfg.StateDir)
+	if len(left) != 1 || left[0].FirstFrame != 3 || left[0].LastFrame != 3 || left[0].Frames != 1 {
+		t.Errorf("left after upload = %+v, want only frame 3", left)
+	}
+}
+
+func TestRun_TombstonesCorruptIndexLine(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idxPath := filepath.Join(walDir, "seg-000001.wal.idx")
+	first, _ := json.Marshal(FrameMeta{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4})
+	second, _ := json.Marshal(FrameMeta{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4})
+	idx := string(first) + "\n{\"file\": garbage\n" + string(second) + "\n"
+	if err := os.WriteFile(idxPath, []byte(idx), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var tombstones []Tombstone
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tombstonesEndpoint {
+			return
+		}
+		var body struct{ Tombstones []Tombstone }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		tombstones = append(tombstones, body.Tombstones...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tombstones) != 1 || tombstones[0].Reason != TombstoneCorruptIndex || tombstones[0].IdxOffset != int64(len(first)+1) {
+		t.Fatalf("tombstones = %+v", tombstones)
+	}
+	st, _ := loadState(cfg.StateDir)
+	if st.LastFrame != 2 || st.IdxOffset != int64(len(idx)) {
+		t.Errorf("state = frame %d offset %d, want frame 2 offset %d", st.LastFrame, st.IdxOffset, len(idx))
+	}
+	if left, _ := loadTombstones(cfg.StateDir); len(left) != 0 {
+		t.Errorf("tombstones left queued: %+v", left)
+	}
+}
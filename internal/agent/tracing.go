@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/tracing"
+)
+
+// Tracing exporters for TracingConfig.Exporter.
+const (
+	TracingExporterOTLPHTTP = "otlp-http"
+	TracingExporterOTLPGRPC = "otlp-grpc"
+)
+
+// TracingConfig configures OpenTelemetry tracing of the send pipeline.
+type TracingConfig struct {
+	// Exporter is "" (tracing off), "otlp-http" or "otlp-grpc".
+	Exporter string
+	// Endpoint is the collector: a URL for otlp-http (/v1/traces is added
+	// if it has no path), host:port for otlp-grpc.
+	Endpoint string
+	// Insecure disables TLS for otlp-grpc.
+	Insecure bool
+	// SampleRatio is the fraction of batches traced, from 0 to 1.
+	SampleRatio float64
+}
+
+func validateTracing(tc TracingConfig) error {
+	switch tc.Exporter {
+	case "":
+		return nil
+	case TracingExporterOTLPHTTP, TracingExporterOTLPGRPC:
+	default:
+		return fmt.Errorf("tracing exporter must be %q or %q", TracingExporterOTLPHTTP, TracingExporterOTLPGRPC)
+	}
+	if tc.Endpoint == "" {
+		return fmt.Errorf("tracing exporter requires tracing-endpoint")
+	}
+	if tc.Exporter == TracingExporterOTLPGRPC {
+		if _, _, err := net.SplitHostPort(tc.Endpoint); err != nil {
+			return fmt.Errorf("tracing endpoint must be host:port for otlp-grpc: %w", err)
+		}
+	}
+	if tc.SampleRatio < 0 || tc.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// tracingFlushInterval is how often finished spans are exported.
+var tracingFlushInterval = 5 * time.Second
+
+// sendTracer records the send pipeline's spans; nil while tracing is off.
+var sendTracer atomic.Pointer[tracing.Tracer]
+
+func startSpan(name string, kind tracing.Kind, parent tracing.SpanContext, start time.Time, attrs ...tracing.Attr) *tracing.Span {
+	return sendTracer.Load().Start(name, kind, parent, start, attrs...)
+}
+
+// startTracing sets up the exporter configured by cfg.Tracing and exports
+// spans until the returned func, which flushes the last ones, is called.
+func startTracing(ctx context.Context, cfg Config) (func(), error) {
+	tc := cfg.Tracing
+	var exp tracing.Exporter
+	var err error
+	switch tc.Exporter {
+	case "":
+		return func() {}, nil
+	case TracingExporterOTLPHTTP:
+		exp, err = tracing.NewHTTPExporter(tc.Endpoint, &http.Client{Timeout: cfg.HTTPTimeout, Transport: newTransport(cfg)}, nil)
+	case TracingExporterOTLPGRPC:
+		exp, err = tracing.NewGRPCExporter(tc.Endpoint, tc.Insecure, nil)
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", tc.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := []tracing.Attr{tracing.String("walship.chain_id", cfg.ChainID)}
+	if !cfg.Anonymize {
+		res = append(res, tracing.String("host.name", hostname()))
+	}
+	t := tracing.NewTracer(exp, tracing.Options{ServiceName: "walship", Resource: res, SampleRatio: tc.SampleRatio})
+	sendTracer.Store(t)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t.Run(ctx, tracingFlushInterval, func(err error) {
+			logger.Warn().Err(err).Msg("export spans")
+		})
+	}()
+	logger.Info().Str("exporter", tc.Exporter).Str("endpoint", tc.Endpoint).Float64("sample_ratio", tc.SampleRatio).Msg("tracing enabled")
+
+	return func() {
+		cancel()
+		<-done
+		sendTracer.CompareAndSwap(t, nil)
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		if err := t.Shutdown(sctx); err != nil {
+			logger.Warn().Err(err).Msg("export spans")
+		}
+	}, nil
+}
+
+// batchTrace follows one batch of a pipeline from its first frame to its
+// delivery: a walship.batch span with a walship.read child covering the
+// frames being read, and a walship.send child per delivery attempt.
+type batchTrace struct {
+	span     *tracing.Span
+	lastRead time.Time
+	read     bool // walship.read emitted
+}
+
+// traceFrame notes a frame added to the batch, starting the batch span with
+// the first one.
+func (p *pipeline) traceFrame(cfg Config) {
+	if p == nil || sendTracer.Load() == nil {
+		return
+	}
+	now := time.Now()
+	if p.trace.span == nil {
+		p.trace = batchTrace{span: startSpan("walship.batch", tracing.KindInternal, tracing.SpanContext{}, now,
+			tracing.String("walship.node_id", cfg.NodeID))}
+	}
+	p.trace.lastRead = now
+}
+
+// traceSend starts the span of one attempt to send frames, after the
+// batch's walship.read span if this is the first.
+func (p *pipeline) traceSend(frames []batchFrame, segment, transport string) *tracing.Span {
+	if p == nil || p.trace.span == nil {
+		return nil
+	}
+	parent := p.trace.span.Context()
+	attrs := []tracing.Attr{tracing.Int("walship.frames", int64(len(frames))), tracing.Int("walship.bytes", int64(framesBytes(frames)))}
+	if !p.trace.read {
+		p.trace.read = true
+		startSpan("walship.read", tracing.KindInternal, parent, p.trace.span.StartTime(), attrs...).EndAt(p.trace.lastRead)
+	}
+	return startSpan("walship.send", tracing.KindInternal, parent, time.Time{},
+		append(attrs, tracing.String("walship.segment", segment), tracing.String("walship.transport", transport))...)
+}
+
+// endSendSpan ends a walship.send span with the attempt's outcome.
+func endSendSpan(span *tracing.Span, sent int, err error) {
+	span.SetAttrs(tracing.Int("walship.frames_sent", int64(sent)))
+	span.SetError(err)
+	span.End()
+}
+
+// traceSent ends the batch span once none of its frames are left.
+func (p *pipeline) traceSent(remaining int) {
+	if p == nil || p.trace.span == nil || remaining > 0 {
+		return
+	}
+	p.trace.span.End()
+	p.trace = batchTrace{}
+}
+
+// tracedClient returns c with requests traced as children of parent, which
+// they carry to the service in a W3C traceparent header.
+func tracedClient(c *http.Client, parent tracing.SpanContext) *http.Client {
+	if !parent.IsValid() {
+		return c
+	}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	cp := *c
+	cp.Transport = tracingTransport{next: next, parent: parent}
+	return &cp
+}
+
+// clientSpan returns the span c's requests are traced under, if any.
+func clientSpan(c *http.Client) tracing.SpanContext {
+	if t, ok := c.Transport.(tracingTransport); ok {
+		return t.parent
+	}
+	return tracing.SpanContext{}
+}
+
+// tracingTransport records a client span per request.
+type tracingTransport struct {
+	next   http.RoundTripper
+	parent tracing.SpanContext
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := startSpan("HTTP "+req.Method, tracing.KindClient, t.parent, time.Time{},
+		tracing.String("http.request.method", req.Method), tracing.String("url.path", req.URL.Path))
+	sc := span.Context()
+	if !sc.IsValid() {
+		sc = t.parent // tracing stopped meanwhile
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", sc.Traceparent())
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttrs(tracing.Int("http.response.status_code", int64(resp.StatusCode)))
+		if resp.StatusCode >= 400 {
+			span.SetError(fmt.Errorf("status %d", resp.StatusCode))
+		}
+	}
+	span.End()
+	return resp, err
+}
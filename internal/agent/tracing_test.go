@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRun_TracesSendPipeline(t *testing.T) {
+	walDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(walDir, "seg-000001.wal.gz"), []byte("AAAABBBB"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(walDir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: 4},
+		{File: "seg-000001.wal.gz", Frame: 2, Off: 4, Len: 4},
+	})
+
+	var mu sync.Mutex
+	var traceparents []string
+	var exports [][]byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == agentInfoEndpoint {
+			return
+		}
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		exports = append(exports, b)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: walDir, StateDir: t.TempDir(), Once: true, PollInterval: time.Millisecond,
+		Tracing: TracingConfig{Exporter: TracingExporterOTLPHTTP, Endpoint: collector.URL, SampleRatio: 1}}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if sendTracer.Load() != nil {
+		t.Error("tracer left installed after Run")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	type span struct{ name, trace string }
+	var spans []span
+	batches := map[string]bool{} // trace IDs of walship.batch spans
+	for _, req := range exports {
+		for _, rs := range protoFields(t, req, 1) {
+			for _, ss := range protoFields(t, rs, 2) {
+				for _, sp := range protoFields(t, ss, 2) {
+					s := span{string(protoFields(t, sp, 5)[0]), hex.EncodeToString(protoFields(t, sp, 1)[0])}
+					spans = append(spans, s)
+					if s.name == "walship.batch" {
+						batches[s.trace] = true
+					}
+				}
+			}
+		}
+	}
+	names := map[string]bool{}
+	for _, s := range spans {
+		names[s.name] = true
+		if !batches[s.trace] {
+			t.Errorf("span %s is outside every batch trace", s.name)
+		}
+	}
+	var got []string
+	for n := range names {
+		got = append(got, n)
+	}
+	sort.Strings(got)
+	if want := "HTTP POST,walship.batch,walship.compress,walship.read,walship.send"; strings.Join(got, ",") != want {
+		t.Fatalf("spans = %v, want %s", got, want)
+	}
+	if len(traceparents) == 0 {
+		t.Fatal("no uploads")
+	}
+	for _, tp := range traceparents {
+		if parts := strings.Split(tp, "-"); len(parts) != 4 || !batches[parts[1]] {
+			t.Errorf("traceparent %q is not from a batch trace", tp)
+		}
+	}
+}
+
+// protoFields returns the length-delimited fields numbered num in b.
+func protoFields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+		if typ == protowire.BytesType && n == num {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatal(protowire.ParseError(l))
+			}
+			out = append(out, v)
+			b = b[l:]
+			continue
+		}
+		if l = protowire.ConsumeFieldValue(n, typ, b); l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+	}
+	return out
+}
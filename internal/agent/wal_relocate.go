@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// WAL relocation policies for Config.WALRelocate.
+const (
+	// WALRelocateOff keeps waiting for WALDir.
+	WALRelocateOff = "off"
+	// WALRelocateWarn logs and records the relocated WAL once, so the
+	// operator can confirm it with --wal-dir or --wal-relocate follow.
+	WALRelocateWarn = "warn"
+	// WALRelocateFollow switches to the relocated WAL if it is the node's
+	// own, and otherwise warns like WALRelocateWarn.
+	WALRelocateFollow = "follow"
+)
+
+// walRelocateCheckInterval throttles the directory scans of a waiting
+// pipeline.
+var walRelocateCheckInterval = 30 * time.Second
+
+// walRelocator watches for the WAL of a pipeline moving to another
+// node-<id> dir under the same log.wal dir.
+type walRelocator struct {
+	policy    string
+	nodeHome  string
+	nodeID    string // the frames are shipped under, before anonymizing
+	lastCheck time.Time
+	warned    string // candidate already reported
+}
+
+func newWALRelocator(cfg Config) *walRelocator {
+	return &walRelocator{policy: cfg.WALRelocate, nodeHome: cfg.NodeHome, nodeID: cfg.NodeID}
+}
+
+// check returns the dir walDir's WAL moved to if it should be followed now.
+// It reports nothing while walDir still holds index files, and scans at
+// most every walRelocateCheckInterval unless force is set.
+func (w *walRelocator) check(walDir string, force bool) (string, bool) {
+	if w.policy == WALRelocateOff || w.policy == "" {
+		return "", false
+	}
+	if !force && time.Since(w.lastCheck) < walRelocateCheckInterval {
+		return "", false
+	}
+	w.lastCheck = time.Now()
+	if _, err := wal.OldestIndex(walDir); err == nil {
+		return "", false
+	}
+	dir, err := findRelocatedWAL(walDir, w.nodeHome)
+	if err != nil {
+		if w.warned != err.Error() {
+			w.warned = err.Error()
+			logger.Warn().Err(err).Str("wal_dir", walDir).Msg("wal dir has no WAL")
+		}
+		return "", false
+	}
+	// The frames are shipped under the node ID the pipeline started with,
+	// so another node's WAL is never followed; that takes a restart with
+	// its node ID.
+	if w.policy == WALRelocateFollow && filepath.Base(dir) == "node-"+w.nodeID {
+		return dir, true
+	}
+	if w.policy == WALRelocateFollow && w.warned != dir {
+		w.warned = dir
+		logger.Warn().Str("wal_dir", walDir).Str("candidate", dir).Str("node_id", w.nodeID).
+			Msg("wal dir has no WAL but another node's dir does; not following it under this node id, restart with that node's --node-id and --wal-dir")
+		recordEvent(EventState, fmt.Sprintf("WAL may have moved from %s to %s, which belongs to another node", walDir, dir))
+	}
+	if w.warned != dir {
+		w.warned = dir
+		logger.Warn().Str("wal_dir", walDir).Str("candidate", dir).
+			Msg("wal dir has no WAL but another node dir does; set --wal-dir to it or use --wal-relocate follow")
+		recordEvent(EventState, fmt.Sprintf("WAL may have moved from %s to %s", walDir, dir))
+	}
+	return "", false
+}
+
+// findRelocatedWAL looks beside walDir, a node-<id> dir, for the node-<id>
+// dir now holding the WAL. It prefers the node's current ID from nodeHome
+// and otherwise takes the only candidate.
+func findRelocatedWAL(walDir, nodeHome string) (string, error) {
+	parent := filepath.Dir(filepath.Clean(walDir))
+	ents, err := os.ReadDir(parent)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, e := range ents {
+		dir := filepath.Join(parent, e.Name())
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), "node-") || dir == filepath.Clean(walDir) {
+			continue
+		}
+		if _, err := wal.OldestIndex(dir); err == nil {
+			candidates = append(candidates, dir)
+		}
+	}
+	if nodeHome != "" {
+		if id, err := readNodeID(nodeHome); err == nil {
+			for _, c := range candidates {
+				if filepath.Base(c) == "node-"+id {
+					return c, nil
+				}
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no other node dir under %s has a WAL", parent)
+	case 1:
+		return candidates[0], nil
+	}
+	sort.Strings(candidates)
+	return "", fmt.Errorf("several node dirs under %s have a WAL: %s", parent, strings.Join(candidates, ", "))
+}
+
+// relocatePosition moves st to newDir. If the WAL was moved as a whole, the
+// file st points at exists under newDir and shipping resumes where it
+// stopped; otherwise it starts over as StartFrom says.
+func relocatePosition(cfg Config, newDir string, st *state) error {
+	if rel, err := filepath.Rel(cfg.WALDir, st.IdxPath); err == nil && st.IdxPath != "" && !strings.HasPrefix(rel, "..") {
+		if p := filepath.Join(newDir, rel); fileExists(p) {
+			st.IdxPath = p
+			return nil
+		}
+	}
+	sf, err := parseStartFrom(cfg.StartFrom)
+	if err != nil {
+		return err
+	}
+	idxPath, off, err := startPosition(newDir, sf)
+	if err != nil {
+		return err
+	}
+	st.IdxPath, st.IdxOffset, st.CurGz = idxPath, off, ""
+	return nil
+}
+
+func logWALRelocation(from, to string, st state) {
+	logger.Warn().Str("from", from).Str("to", to).Str("idx", st.IdxPath).Int64("offset", st.IdxOffset).Msg("wal relocated")
+	recordEvent(EventState, fmt.Sprintf("WAL relocated from %s to %s", from, to))
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeWAL writes a one-frame WAL segment holding data to dir.
+func writeWAL(t *testing.T, dir, data string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "seg-000001.wal.gz"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeIdx(t, filepath.Join(dir, "seg-000001.wal.idx"), []FrameMeta{
+		{File: "seg-000001.wal.gz", Frame: 1, Off: 0, Len: uint64(len(data))},
+	})
+}
+
+func TestFindRelocatedWAL(t *testing.T) {
+	home := t.TempDir()
+	id := writeNodeHome(t, home, "chain-a")
+	logWAL := filepath.Join(home, "data", "log.wal")
+
+	tests := []struct {
+		name    string
+		dirs    []string // node dirs holding a WAL
+		home    string
+		want    string
+		wantErr string
+	}{
+		{name: "only candidate", dirs: []string{"node-new"}, want: "node-new"},
+		{name: "current node id wins", dirs: []string{"node-other", "node-" + id}, home: home, want: "node-" + id},
+		{name: "ambiguous", dirs: []string{"node-a", "node-b"}, wantErr: "several node dirs"},
+		{name: "none", wantErr: "no other node dir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.RemoveAll(logWAL); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Join(logWAL, "node-old"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range tt.dirs {
+				writeWAL(t, filepath.Join(logWAL, d), "frame")
+			}
+			got, err := findRelocatedWAL(filepath.Join(logWAL, "node-old"), tt.home)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(logWAL, tt.want) {
+				t.Errorf("findRelocatedWAL = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWALRelocator_Policies(t *testing.T) {
+	logWAL := t.TempDir()
+	oldDir, newDir := filepath.Join(logWAL, "node-old"), filepath.Join(logWAL, "node-new")
+	if err := os.MkdirAll(oldDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeWAL(t, newDir, "frame")
+
+	oldRing := recentEvents
+	defer func() { recentEvents = oldRing }()
+	for _, tt := range []struct {
+		policy     string
+		nodeID     string
+		wantFollow bool
+		wantEvents int
+	}{
+		{policy: WALRelocateOff, nodeID: "new"},
+		{policy: WALRelocateWarn, nodeID: "new", wantEvents: 1},
+		{policy: WALRelocateFollow, nodeID: "new", wantFollow: true},
+		// Another node's WAL would be shipped under the wrong node ID.
+		{policy: WALRelocateFollow, nodeID: "old", wantEvents: 1},
+	} {
+		recentEvents = &eventRing{}
+		w := newWALRelocator(Config{WALRelocate: tt.policy, NodeID: tt.nodeID})
+		for i := 0; i < 2; i++ {
+			dir, ok := w.check(oldDir, true)
+			if ok != tt.wantFollow || (ok && dir != newDir) {
+				t.Errorf("%s: check = %s, %v, want follow %v", tt.policy, dir, ok, tt.wantFollow)
+			}
+		}
+		if n := len(recentEvents.Snapshot()); n != tt.wantEvents {
+			t.Errorf("%s: %d events, want %d", tt.policy, n, tt.wantEvents)
+		}
+	}
+
+	// A WAL dir that still has its WAL is never relocated.
+	w := newWALRelocator(Config{WALRelocate: WALRelocateFollow, NodeID: "new"})
+	if dir, ok := w.check(newDir, true); ok {
+		t.Errorf("check of a live WAL dir = %s, want none", dir)
+	}
+}
+
+func TestRun_FollowsRelocatedWAL(t *testing.T) {
+	var uploads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == walFramesEndpoint {
+			uploads++
+		}
+	}))
+	defer ts.Close()
+
+	logWAL, stateDir := t.TempDir(), t.TempDir()
+	oldDir, newDir := filepath.Join(logWAL, "node-old"), filepath.Join(logWAL, "node-new")
+	writeWAL(t, newDir, "moved")
+	// The saved position points into the old dir, as after a data move.
+	if err := saveState(stateDir, state{IdxPath: filepath.Join(oldDir, "seg-000001.wal.idx")}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{ServiceURL: ts.URL, WALDir: oldDir, StateDir: stateDir, WALRelocate: WALRelocateFollow, NodeID: "new",
+		Once: true, PollInterval: time.Millisecond}
+	if err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	st, err := loadState(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploads != 1 || st.LastFrame != 1 || st.IdxPath != filepath.Join(newDir, "seg-000001.wal.idx") {
+		t.Errorf("uploads = %d, state = %+v, want frame 1 of %s shipped", uploads, st, newDir)
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+const walWriterEndpoint = "/v1/ingest/wal-writer"
+
+// WAL writer states reported in Stats.WALWriter and to the service.
+const (
+	// WALWriterAlive: the heartbeat is fresh and frames are being written.
+	WALWriterAlive = "alive"
+	// WALWriterIdle: the heartbeat is fresh but no frames were written
+	// within WALWriterTimeout, i.e. the chain itself is idle.
+	WALWriterIdle = "idle"
+	// WALWriterStalled: the heartbeat is stale while the node process is
+	// alive (or cannot be checked), i.e. the WAL subsystem is broken.
+	WALWriterStalled = "stalled"
+	// WALWriterNodeDown: the heartbeat is stale and the node process named
+	// in it is gone.
+	WALWriterNodeDown = "node_down"
+)
+
+var walWriterInterval = 30 * time.Second
+
+// walWriterReport is the writer state sent to walWriterEndpoint.
+type walWriterReport struct {
+	State        string    `json:"state"`
+	HeartbeatAt  time.Time `json:"heartbeat_at"`
+	LastWriteAt  time.Time `json:"last_write_at"`
+	NodePID      int       `json:"node_pid,omitempty"`
+	NodeAlive    *bool     `json:"node_alive,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+	TimeoutSecs  float64   `json:"timeout_secs"`
+	HeartbeatErr string    `json:"heartbeat_error,omitempty"`
+}
+
+// walWriterScraper watches Config.WALWriterFile, a lock or heartbeat file the
+// node's WAL writer keeps fresh. Its modification time is the heartbeat; if
+// it holds a process ID, that process is taken to be the node.
+type walWriterScraper struct {
+	cfg        Config
+	httpClient *http.Client
+	last       *string // previous state, to log transitions
+}
+
+func newWALWriterScraper(cfg Config, httpClient *http.Client) walWriterScraper {
+	return walWriterScraper{cfg: cfg, httpClient: httpClient, last: new(string)}
+}
+
+func (walWriterScraper) Name() string            { return "wal-writer" }
+func (walWriterScraper) Interval() time.Duration { return walWriterInterval }
+
+func (s walWriterScraper) Collect(ctx context.Context) (any, error) {
+	return checkWALWriter(s.cfg, time.Now()), nil
+}
+
+func (s walWriterScraper) Ship(ctx context.Context, data any) error {
+	r := data.(walWriterReport)
+	recordWALWriter(s.cfg.StateDir, r.State)
+	if r.State != *s.last {
+		ev := logger.Info()
+		if r.State == WALWriterStalled || r.State == WALWriterNodeDown {
+			ev = logger.Warn()
+		}
+		ev.Str("state", r.State).Time("heartbeat_at", r.HeartbeatAt).Time("last_write_at", r.LastWriteAt).Msg("wal writer")
+		recordEvent(EventState, "wal writer "+r.State)
+		*s.last = r.State
+	}
+	return postWALWriter(ctx, s.cfg, s.httpClient, r)
+}
+
+// checkWALWriter classifies the writer's state at now.
+func checkWALWriter(cfg Config, now time.Time) walWriterReport {
+	r := walWriterReport{CheckedAt: now, TimeoutSecs: cfg.WALWriterTimeout.Seconds()}
+	path := cfg.WALWriterFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfg.WALDir, path)
+	}
+	if idx, err := wal.LatestIndex(cfg.WALDir); err == nil {
+		if fi, err := os.Stat(idx); err == nil {
+			r.LastWriteAt = fi.ModTime()
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		r.HeartbeatErr = err.Error()
+	} else {
+		r.HeartbeatAt = fi.ModTime()
+		if b, err := readFileReadOnly(path); err == nil {
+			if line, _, _ := bytes.Cut(bytes.TrimSpace(b), []byte("\n")); len(line) > 0 {
+				if pid, err := strconv.Atoi(string(bytes.TrimSpace(line))); err == nil && pid > 0 {
+					r.NodePID = pid
+				}
+			}
+		}
+	}
+	if r.NodePID > 0 {
+		alive := processAlive(r.NodePID)
+		r.NodeAlive = &alive
+	}
+
+	switch {
+	case err == nil && now.Sub(r.HeartbeatAt) <= cfg.WALWriterTimeout:
+		if !r.LastWriteAt.IsZero() && now.Sub(r.LastWriteAt) <= cfg.WALWriterTimeout {
+			r.State = WALWriterAlive
+		} else {
+			r.State = WALWriterIdle
+		}
+	case r.NodeAlive != nil && !*r.NodeAlive:
+		r.State = WALWriterNodeDown
+	default:
+		r.State = WALWriterStalled
+	}
+	return r
+}
+
+// processAlive reports whether pid is running, via procRoot. It assumes the
+// process is alive where /proc is unavailable.
+func processAlive(pid int) bool {
+	if _, err := os.Stat(procRoot); err != nil {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid)))
+	return err == nil
+}
+
+func postWALWriter(ctx context.Context, cfg Config, httpClient *http.Client, r walWriterReport) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal wal writer report: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ingestURL(cfg, walWriterEndpoint), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
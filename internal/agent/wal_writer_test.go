@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckWALWriter(t *testing.T) {
+	now := time.Now()
+	fresh, stale := now.Add(-10*time.Second), now.Add(-10*time.Minute)
+
+	tests := []struct {
+		name      string
+		heartbeat *time.Time // nil: no heartbeat file
+		pid       string
+		pidAlive  bool
+		walWrite  time.Time
+		want      string
+	}{
+		{name: "writing", heartbeat: &fresh, walWrite: fresh, want: WALWriterAlive},
+		{name: "chain idle", heartbeat: &fresh, walWrite: stale, want: WALWriterIdle},
+		{name: "stale heartbeat", heartbeat: &stale, walWrite: stale, want: WALWriterStalled},
+		{name: "stale, node alive", heartbeat: &stale, pid: "4242\n", pidAlive: true, walWrite: stale, want: WALWriterStalled},
+		{name: "stale, node gone", heartbeat: &stale, pid: "4242\n", walWrite: stale, want: WALWriterNodeDown},
+		{name: "missing heartbeat", walWrite: fresh, want: WALWriterStalled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := t.TempDir()
+			oldRoot := procRoot
+			procRoot = proc
+			defer func() { procRoot = oldRoot }()
+			if tt.pidAlive {
+				if err := os.Mkdir(filepath.Join(proc, "4242"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			walDir := t.TempDir()
+			idx := filepath.Join(walDir, "seg-000001.wal.idx")
+			writeIdx(t, idx, nil)
+			if err := os.Chtimes(idx, tt.walWrite, tt.walWrite); err != nil {
+				t.Fatal(err)
+			}
+			if tt.heartbeat != nil {
+				hb := filepath.Join(walDir, "writer.lock")
+				if err := os.WriteFile(hb, []byte(tt.pid), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(hb, *tt.heartbeat, *tt.heartbeat); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg := Config{WALDir: walDir, WALWriterFile: "writer.lock", WALWriterTimeout: time.Minute}
+			r := checkWALWriter(cfg, now)
+			if r.State != tt.want {
+				t.Errorf("state = %q, want %q (report %+v)", r.State, tt.want, r)
+			}
+			if tt.pid != "" && r.NodePID != 4242 {
+				t.Errorf("NodePID = %d, want 4242", r.NodePID)
+			}
+		})
+	}
+}
+
+func TestWALWriterScraper_Ship(t *testing.T) {
+	var got walWriterReport
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != walWriterEndpoint {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	s := newWALWriterScraper(Config{ServiceURL: ts.URL}, http.DefaultClient)
+	if err := s.Ship(context.Background(), walWriterReport{State: WALWriterStalled}); err != nil {
+		t.Fatalf("Ship: %v", err)
+	}
+	if got.State != WALWriterStalled {
+		t.Errorf("service got state %q", got.State)
+	}
+	if st := CurrentStats().WALWriter; st != WALWriterStalled {
+		t.Errorf("Stats.WALWriter = %q", st)
+	}
+}
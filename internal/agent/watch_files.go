@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// forbiddenWatchFiles are key material that must never leave the node, no
+// matter what the operator configures.
+var forbiddenWatchFiles = map[string]bool{
+	"priv_validator_key.json": true,
+	"node_key.json":           true,
+}
+
+// WatchFile is an extra file under the node home that is shipped alongside
+// app.toml and config.toml.
+type WatchFile struct {
+	// Path is relative to the node home, e.g. "config/client.toml".
+	Path string
+	// Redact lists keys whose values are masked before shipping.
+	Redact []string
+}
+
+// ParseWatchFile parses "path[:key1,key2]" into a WatchFile.
+func ParseWatchFile(spec string) (WatchFile, error) {
+	spec = strings.TrimSpace(spec)
+	path, keys, _ := strings.Cut(spec, ":")
+	if path == "" {
+		return WatchFile{}, fmt.Errorf("watch file: empty path in %q", spec)
+	}
+	wf := WatchFile{Path: path}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			wf.Redact = append(wf.Redact, k)
+		}
+	}
+	return wf, nil
+}
+
+// parseWatchFiles parses a ';'-separated list of watch file specs.
+func parseWatchFiles(list string) ([]WatchFile, error) {
+	var out []WatchFile
+	for _, spec := range strings.Split(list, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		wf, err := ParseWatchFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, wf)
+	}
+	return out, nil
+}
+
+// errWatchFileRefused marks watch files that are never shipped.
+var errWatchFileRefused = errors.New("watch file refused")
+
+// validateWatchFile ensures the path stays inside the node home and does not
+// point at validator or node key material.
+func validateWatchFile(wf WatchFile) error {
+	if filepath.IsAbs(wf.Path) {
+		return fmt.Errorf("watch file %q: path must be relative to node-home", wf.Path)
+	}
+	clean := filepath.Clean(wf.Path)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("watch file %q: path escapes node-home", wf.Path)
+	}
+	if forbiddenWatchFiles[filepath.Base(clean)] {
+		return fmt.Errorf("watch file %q: shipping key material is not allowed", wf.Path)
+	}
+	return nil
+}
+
+// resolveWatchFile returns the path of wf under home with symlinks resolved.
+// Links to key material or out of home are refused like such paths are, with
+// an error wrapping errWatchFileRefused.
+func resolveWatchFile(home string, wf WatchFile) (string, error) {
+	if err := validateWatchFile(wf); err != nil {
+		return "", fmt.Errorf("%w: %w", errWatchFileRefused, err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(home, filepath.Clean(wf.Path)))
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(home)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: watch file %q: links out of node-home", errWatchFileRefused, wf.Path)
+	}
+	if forbiddenWatchFiles[filepath.Base(path)] {
+		return "", fmt.Errorf("%w: watch file %q: links to key material", errWatchFileRefused, wf.Path)
+	}
+	return path, nil
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseWatchFiles(t *testing.T) {
+	got, err := parseWatchFiles("config/client.toml; config/relayer.toml:mnemonic, password ;")
+	if err != nil {
+		t.Fatalf("parseWatchFiles() error = %v", err)
+	}
+	want := []WatchFile{
+		{Path: "config/client.toml"},
+		{Path: "config/relayer.toml", Redact: []string{"mnemonic", "password"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWatchFiles() = %+v, want %+v", got, want)
+	}
+
+	if _, err := parseWatchFiles(":mnemonic"); err == nil {
+		t.Error("parseWatchFiles() expected error for empty path")
+	}
+}
+
+func TestValidateWatchFile(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"config/client.toml", false},
+		{"config/wasm/wasm.toml", false},
+		{"/etc/passwd", true},
+		{"../other/config.toml", true},
+		{"config/../../x.toml", true},
+		{"config/priv_validator_key.json", true},
+		{"config/node_key.json", true},
+	}
+	for _, tt := range tests {
+		err := validateWatchFile(WatchFile{Path: tt.path})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateWatchFile(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveWatchFile(t *testing.T) {
+	home, outside := t.TempDir(), t.TempDir()
+	config := filepath.Join(home, "config")
+	if err := os.MkdirAll(config, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{filepath.Join(config, "client.toml"), filepath.Join(config, "node_key.json"), filepath.Join(outside, "x.toml")} {
+		if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"alias.toml":  "client.toml",
+		"key.toml":    "node_key.json",
+		"escape.toml": filepath.Join(outside, "x.toml"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(config, name)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		refused bool
+	}{
+		{"config/client.toml", "config/client.toml", false},
+		{"config/alias.toml", "config/client.toml", false},
+		{"config/key.toml", "", true},
+		{"config/escape.toml", "", true},
+		{"config/node_key.json", "", true},
+		{"config/missing.toml", "", false},
+	}
+	root, _ := filepath.EvalSymlinks(home)
+	for _, tt := range tests {
+		got, err := resolveWatchFile(home, WatchFile{Path: tt.path})
+		if errors.Is(err, errWatchFileRefused) != tt.refused {
+			t.Errorf("resolveWatchFile(%q) error = %v, refused %v", tt.path, err, tt.refused)
+			continue
+		}
+		if tt.want != "" && got != filepath.Join(root, filepath.FromSlash(tt.want)) {
+			t.Errorf("resolveWatchFile(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
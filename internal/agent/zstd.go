@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Frame encodings for multipart uploads.
+const (
+	// FrameEncodingGzip uploads frames as the node wrote them.
+	FrameEncodingGzip = "gzip"
+	// FrameEncodingZstd re-encodes frames with zstd and a dictionary trained
+	// on recent WAL content.
+	FrameEncodingZstd = "zstd"
+)
+
+const zstdDictsEndpoint = "/v1/ingest/zstd-dicts"
+
+var (
+	// zstdDictRefresh is how often the dictionary is retrained.
+	zstdDictRefresh = time.Hour
+	zstdDictSize    = 64 << 10
+	// zstdSampleBytes caps the recent frame content kept for training, and
+	// zstdMinSampleBytes is how much is needed before the first training.
+	zstdSampleBytes    = 4 << 20
+	zstdMinSampleBytes = 256 << 10
+)
+
+// zstdDicts trains and versions the dictionary of one node. A dictionary is only
+// used once the service has accepted it, so every frame it encodes can be
+// decoded server-side; until then frames are encoded without one.
+type zstdDicts struct {
+	mu          sync.Mutex
+	samples     [][]byte
+	sampleBytes int
+
+	dict      []byte
+	id        uint32
+	trainedAt time.Time
+	uploaded  bool
+
+	enc   *zstd.Encoder // with dict, once uploaded
+	plain *zstd.Encoder
+}
+
+// dictKey identifies the node whose frames a dictionary is trained on.
+type dictKey struct {
+	chainID, nodeID string
+}
+
+// frameDicts are the dictionaries by node, so nodes sharing the process
+// never train on or encode with each other's frames, and the service
+// resolves a dictionary ID within the node that uploaded it.
+var frameDicts struct {
+	mu sync.Mutex
+	m  map[dictKey]*zstdDicts
+}
+
+// dictsFor returns the dictionaries of cfg's node.
+func dictsFor(cfg Config) *zstdDicts {
+	frameDicts.mu.Lock()
+	defer frameDicts.mu.Unlock()
+	k := dictKey{cfg.ChainID, cfg.NodeID}
+	d, ok := frameDicts.m[k]
+	if !ok {
+		if frameDicts.m == nil {
+			frameDicts.m = map[dictKey]*zstdDicts{}
+		}
+		d = &zstdDicts{}
+		frameDicts.m[k] = d
+	}
+	return d
+}
+
+// observe keeps raw as a training sample, dropping the oldest samples past
+// zstdSampleBytes.
+func (d *zstdDicts) observe(raw []byte) {
+	d.samples = append(d.samples, raw)
+	d.sampleBytes += len(raw)
+	for d.sampleBytes > zstdSampleBytes && len(d.samples) > 1 {
+		d.sampleBytes -= len(d.samples[0])
+		d.samples = d.samples[1:]
+	}
+}
+
+// maybeTrain builds a new dictionary when none exists yet or the current one
+// is older than zstdDictRefresh. A failed training keeps the old dictionary.
+func (d *zstdDicts) maybeTrain(now time.Time) {
+	if d.sampleBytes < zstdMinSampleBytes || (d.dict != nil && now.Sub(d.trainedAt) < zstdDictRefresh) {
+		return
+	}
+	d.trainedAt = now
+	b, err := dict.BuildZstdDict(d.samples, dict.Options{MaxDictSize: zstdDictSize, HashBytes: 6, ZstdLevel: zstd.SpeedDefault})
+	if err != nil {
+		logger.Warn().Err(err).Msg("train zstd dictionary")
+		return
+	}
+	info, err := zstd.InspectDictionary(b)
+	if err != nil {
+		logger.Warn().Err(err).Msg("inspect zstd dictionary")
+		return
+	}
+	d.dict, d.id, d.uploaded = b, info.ID(), false
+	logger.Info().Uint32("dict_id", d.id).Int("bytes", len(b)).Msg("trained zstd dictionary")
+}
+
+// encoder returns the encoder to use and the ID of its dictionary (0 for
+// none), uploading a new dictionary first if needed.
+func (d *zstdDicts) encoder(cfg Config, httpClient *http.Client) (*zstd.Encoder, uint32, error) {
+	if d.dict != nil && !d.uploaded {
+		if err := uploadZstdDict(cfg, httpClient, d.id, d.dict); err != nil {
+			logger.Warn().Err(err).Uint32("dict_id", d.id).Msg("upload zstd dictionary; encoding without it")
+		} else {
+			enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(d.dict))
+			if err != nil {
+				return nil, 0, err
+			}
+			d.enc, d.uploaded = enc, true
+		}
+	}
+	if d.enc != nil {
+		return d.enc, d.id, nil
+	}
+	if d.plain == nil {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		d.plain = enc
+	}
+	return d.plain, 0, nil
+}
+
+// zstdFrames returns copies of frames re-encoded with zstd, and the
+// dictionary ID they need.
+func zstdFrames(cfg Config, httpClient *http.Client, frames []batchFrame) ([]batchFrame, uint32, error) {
+	d := dictsFor(cfg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	raws := make([][]byte, len(frames))
+	for i, fr := range frames {
+		raw, err := wal.Decompress(fr.Compressed)
+		if err != nil {
+			return nil, 0, fmt.Errorf("decompress frame %d: %w", fr.Meta.Frame, err)
+		}
+		raws[i] = raw
+		d.observe(raw)
+	}
+	d.maybeTrain(time.Now())
+	enc, id, err := d.encoder(cfg, httpClient)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]batchFrame, len(frames))
+	for i, fr := range frames {
+		fr.Compressed = enc.EncodeAll(raws[i], nil)
+		fr.Meta.Len = uint64(len(fr.Compressed))
+		out[i] = fr
+	}
+	return out, id, nil
+}
+
+func uploadZstdDict(cfg Config, httpClient *http.Client, id uint32, b []byte) error {
+	req, err := http.NewRequestWithContext(activePipeline(cfg).sendContext(), http.MethodPost, ingestURL(cfg, zstdDictsEndpoint), bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	setAgentHeaders(req, cfg)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Walship-Zstd-Dict-Id", strconv.FormatUint(uint64(id), 10))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return newStatusError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// zstdIngest records what postBatch uploads with zstd frame encoding.
+type zstdIngest struct {
+	failDicts bool
+
+	mu       sync.Mutex
+	dicts    map[uint32][]byte
+	encoding string
+	dictID   string
+	frames   []byte
+}
+
+func (s *zstdIngest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case zstdDictsEndpoint:
+		if s.failDicts {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		id, _ := strconv.ParseUint(r.Header.Get("X-Walship-Zstd-Dict-Id"), 10, 32)
+		b, _ := io.ReadAll(r.Body)
+		s.dicts[uint32(id)] = b
+	case walFramesEndpoint:
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.encoding, s.dictID, s.frames = "", "", nil
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			b, _ := io.ReadAll(p)
+			switch p.FormName() {
+			case "encoding":
+				s.encoding = string(b)
+			case "zstd_dict_id":
+				s.dictID = string(b)
+			case "frames":
+				s.frames = b
+			}
+		}
+	}
+}
+
+// zstdTestFrames returns n gzip frames of WAL-like records and their
+// concatenated raw content.
+func zstdTestFrames(t *testing.T, n int) ([]batchFrame, []byte) {
+	t.Helper()
+	var frames []batchFrame
+	var raw bytes.Buffer
+	for i := 0; i < n; i++ {
+		rec := fmt.Sprintf(`{"time":"2024-01-01T00:00:%02dZ","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":%d,"height":"%d","round":"0"}}},"peer_key":"peer%d"}}}`, i%60, 1+i%2, 1000+i, i%7)
+		raw.WriteString(rec + "\n")
+		gz := gzipFrame(t, rec)
+		frames = append(frames, batchFrame{Meta: FrameMeta{Frame: uint64(i), Len: uint64(len(gz))}, Compressed: gz})
+	}
+	return frames, raw.Bytes()
+}
+
+func TestPostBatch_Zstd(t *testing.T) {
+	oldMin := zstdMinSampleBytes
+	t.Cleanup(func() { zstdMinSampleBytes = oldMin })
+	zstdMinSampleBytes = 16 << 10
+
+	tests := []struct {
+		name      string
+		failDicts bool
+		wantDict  bool
+	}{
+		{name: "trained dictionary", wantDict: true},
+		{name: "dictionary upload fails", failDicts: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ing := &zstdIngest{failDicts: tt.failDicts, dicts: map[uint32][]byte{}}
+			srv := httptest.NewServer(ing)
+			defer srv.Close()
+			cfg := Config{ServiceURL: srv.URL, AuthKey: "k", FrameEncoding: FrameEncodingZstd, NodeID: t.Name()}
+
+			frames, raw := zstdTestFrames(t, 400)
+			orig := frames[0].Compressed
+			if err := postBatch(context.Background(), cfg, srv.Client(), frames, "seg.idx"); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(frames[0].Compressed, orig) {
+				t.Fatal("postBatch modified the caller's frames")
+			}
+			if ing.encoding != FrameEncodingZstd {
+				t.Fatalf("encoding = %q, want zstd", ing.encoding)
+			}
+
+			var opts []zstd.DOption
+			if tt.wantDict {
+				id, err := strconv.ParseUint(ing.dictID, 10, 32)
+				if err != nil {
+					t.Fatalf("zstd_dict_id = %q: %v", ing.dictID, err)
+				}
+				d, ok := ing.dicts[uint32(id)]
+				if !ok {
+					t.Fatalf("dictionary %d was not uploaded before use", id)
+				}
+				opts = append(opts, zstd.WithDecoderDicts(d))
+			} else if ing.dictID != "" {
+				t.Fatalf("zstd_dict_id = %q, want none", ing.dictID)
+			}
+
+			dec, err := zstd.NewReader(nil, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			got, err := dec.DecodeAll(ing.frames, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, raw) {
+				t.Fatal("decoded frames differ from the WAL content")
+			}
+		})
+	}
+}
+
+func TestDictsFor_PerNode(t *testing.T) {
+	a := dictsFor(Config{ChainID: "chain-a", NodeID: "node-1"})
+	if dictsFor(Config{ChainID: "chain-a", NodeID: "node-1"}) != a {
+		t.Error("same node got a new dictionary set")
+	}
+	if dictsFor(Config{ChainID: "chain-b", NodeID: "node-1"}) == a {
+		t.Error("node of another chain shares the dictionary")
+	}
+	if dictsFor(Config{ChainID: "chain-a", NodeID: "node-2"}) == a {
+		t.Error("another node of the chain shares the dictionary")
+	}
+}
+
+func TestZstdDicts_Refresh(t *testing.T) {
+	oldMin := zstdMinSampleBytes
+	t.Cleanup(func() { zstdMinSampleBytes = oldMin })
+	zstdMinSampleBytes = 16 << 10
+
+	frames, _ := zstdTestFrames(t, 400)
+	d := &zstdDicts{}
+	for _, fr := range frames {
+		raw, err := wal.Decompress(fr.Compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.observe(raw)
+	}
+	now := time.Now()
+	d.maybeTrain(now)
+	if d.dict == nil {
+		t.Fatal("no dictionary trained")
+	}
+	first := d.dict
+	d.maybeTrain(now.Add(zstdDictRefresh / 2))
+	if !bytes.Equal(d.dict, first) {
+		t.Fatal("dictionary retrained before refresh interval")
+	}
+	d.uploaded = true
+	d.maybeTrain(now.Add(zstdDictRefresh))
+	if d.uploaded {
+		t.Fatal("retrained dictionary not marked for upload")
+	}
+}
@@ -0,0 +1,71 @@
+// Package batch groups WAL frames into size-bounded batches, the unit walship
+// uploads.
+package batch
+
+import "github.com/bft-labs/walship/pkg/wal"
+
+// Batcher accumulates frames until adding another would exceed MaxBytes of
+// compressed data. A frame larger than MaxBytes forms a batch on its own.
+// The zero MaxBytes means unbounded.
+type Batcher struct {
+	MaxBytes int
+
+	frames []wal.Frame
+	bytes  int
+}
+
+// New returns a Batcher bounded by maxBytes.
+func New(maxBytes int) *Batcher {
+	return &Batcher{MaxBytes: maxBytes}
+}
+
+// Add appends f. If f does not fit in the pending batch, the pending batch is
+// returned as complete and f starts the next one; otherwise Add returns nil.
+func (b *Batcher) Add(f wal.Frame) []wal.Frame {
+	var full []wal.Frame
+	if b.MaxBytes > 0 && len(b.frames) > 0 && b.bytes+len(f.Compressed) > b.MaxBytes {
+		full = b.Flush()
+	}
+	b.frames = append(b.frames, f)
+	b.bytes += len(f.Compressed)
+	return full
+}
+
+// Flush returns the pending frames, if any, and starts a new batch.
+func (b *Batcher) Flush() []wal.Frame {
+	if len(b.frames) == 0 {
+		return nil
+	}
+	out := b.frames
+	b.frames, b.bytes = nil, 0
+	return out
+}
+
+// Len returns the number of pending frames.
+func (b *Batcher) Len() int { return len(b.frames) }
+
+// Bytes returns the compressed size of the pending frames.
+func (b *Batcher) Bytes() int { return b.bytes }
+
+// Manifest returns the metadata of frames, in order.
+func Manifest(frames []wal.Frame) []wal.FrameMeta {
+	out := make([]wal.FrameMeta, len(frames))
+	for i, f := range frames {
+		out[i] = f.Meta
+	}
+	return out
+}
+
+// Payload concatenates the compressed frames. The result is itself a valid
+// multi-member gzip stream.
+func Payload(frames []wal.Frame) []byte {
+	n := 0
+	for _, f := range frames {
+		n += len(f.Compressed)
+	}
+	out := make([]byte, 0, n)
+	for _, f := range frames {
+		out = append(out, f.Compressed...)
+	}
+	return out
+}
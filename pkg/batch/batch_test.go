@@ -0,0 +1,51 @@
+package batch
+
+import (
+	"testing"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func frame(n uint64, size int) wal.Frame {
+	return wal.Frame{Meta: wal.FrameMeta{Frame: n}, Compressed: make([]byte, size)}
+}
+
+func TestBatcher(t *testing.T) {
+	b := New(100)
+
+	if full := b.Add(frame(1, 40)); full != nil {
+		t.Fatalf("first add returned %d frames", len(full))
+	}
+	if full := b.Add(frame(2, 60)); full != nil {
+		t.Fatal("exactly MaxBytes should still fit")
+	}
+	full := b.Add(frame(3, 10))
+	if len(full) != 2 || full[0].Meta.Frame != 1 || full[1].Meta.Frame != 2 {
+		t.Fatalf("full batch = %v, want frames 1 and 2", Manifest(full))
+	}
+	if b.Len() != 1 || b.Bytes() != 10 {
+		t.Errorf("pending = %d frames / %d bytes, want 1 / 10", b.Len(), b.Bytes())
+	}
+
+	// An oversized frame closes the pending batch and then stands alone.
+	if full := b.Add(frame(4, 500)); len(full) != 1 || full[0].Meta.Frame != 3 {
+		t.Fatalf("full batch = %v, want frame 3", Manifest(full))
+	}
+	if full := b.Add(frame(5, 1)); len(full) != 1 || full[0].Meta.Frame != 4 {
+		t.Fatalf("full batch = %v, want frame 4 alone", Manifest(full))
+	}
+
+	if rest := b.Flush(); len(rest) != 1 || rest[0].Meta.Frame != 5 {
+		t.Fatalf("Flush = %v, want frame 5", Manifest(rest))
+	}
+	if b.Flush() != nil {
+		t.Error("Flush on empty batcher should return nil")
+	}
+}
+
+func TestPayload(t *testing.T) {
+	frames := []wal.Frame{{Compressed: []byte("ab")}, {Compressed: []byte("c")}}
+	if got := string(Payload(frames)); got != "abc" {
+		t.Errorf("Payload = %q, want abc", got)
+	}
+}
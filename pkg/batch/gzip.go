@@ -0,0 +1,30 @@
+package batch
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// DefaultGzipLevel favours latency: upload bodies are small text and higher
+// levels buy little while delaying sends on busy hosts.
+const DefaultGzipLevel = gzip.BestSpeed
+
+// Gzip returns b compressed as a single gzip member at the given level
+// (1-9; 0 selects DefaultGzipLevel), as for frames re-encoded before upload.
+func Gzip(b []byte, level int) ([]byte, error) {
+	if level == 0 {
+		level = DefaultGzipLevel
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
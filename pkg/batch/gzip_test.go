@@ -0,0 +1,57 @@
+package batch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestGzip_Levels(t *testing.T) {
+	in := []byte(strings.Repeat("minimum-gas-prices = \"0.0025uosmo\"\n", 100))
+	for _, level := range []int{0, gzip.BestSpeed, 5, gzip.BestCompression} {
+		out, err := Gzip(in, level)
+		if err != nil {
+			t.Fatalf("level %d: Gzip() error = %v", level, err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("level %d: gzip reader: %v", level, err)
+		}
+		got, err := io.ReadAll(zr)
+		if err != nil || !bytes.Equal(got, in) {
+			t.Errorf("level %d: round trip mismatch (err=%v)", level, err)
+		}
+	}
+
+	if _, err := Gzip(in, 42); err == nil {
+		t.Error("Gzip() expected error for invalid level")
+	}
+}
+
+// BenchmarkGzip compares compression levels on a config-sized payload.
+// Run with -benchmem and compare ns/op against the reported ratio.
+func BenchmarkGzip(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "key_%d = \"value-%d\" # comment %d\n", i%97, i, i%13)
+	}
+	in := []byte(sb.String())
+
+	for _, level := range []int{gzip.BestSpeed, 3, 5, gzip.DefaultCompression, gzip.BestCompression} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			var outLen int
+			for i := 0; i < b.N; i++ {
+				out, err := Gzip(in, level)
+				if err != nil {
+					b.Fatal(err)
+				}
+				outLen = len(out)
+			}
+			b.ReportMetric(float64(len(in))/float64(outLen), "ratio")
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MessageType is the coarse type of a WAL record, for statistics.
+type MessageType string
+
+const (
+	MessageVote      MessageType = "vote"
+	MessageProposal  MessageType = "proposal"
+	MessageBlockPart MessageType = "block_part"
+	MessageTimeout   MessageType = "timeout"
+	MessageOther     MessageType = "other"
+)
+
+// MessageTypes lists every MessageType, in a stable order.
+var MessageTypes = []MessageType{MessageVote, MessageProposal, MessageBlockPart, MessageTimeout, MessageOther}
+
+// Classify returns the type of one record from its type tags alone, without
+// validating the message. Unparseable records are MessageOther.
+func Classify(record []byte) MessageType {
+	var tm struct {
+		Msg struct {
+			Type  string `json:"type"`
+			Value struct {
+				Msg struct {
+					Type string `json:"type"`
+				} `json:"msg"`
+			} `json:"value"`
+		} `json:"msg"`
+	}
+	if json.Unmarshal(record, &tm) != nil {
+		return MessageOther
+	}
+	switch tm.Msg.Type {
+	case "tendermint/wal/TimeoutInfo":
+		return MessageTimeout
+	case "tendermint/wal/MsgInfo":
+		switch tm.Msg.Value.Msg.Type {
+		case "tendermint/VoteMessage":
+			return MessageVote
+		case "tendermint/ProposalMessage":
+			return MessageProposal
+		case "tendermint/BlockPartMessage":
+			return MessageBlockPart
+		}
+	}
+	return MessageOther
+}
+
+// CountTypes classifies each newline-delimited record in records.
+func CountTypes(records []byte) map[MessageType]int {
+	counts := map[MessageType]int{}
+	for len(records) > 0 {
+		line := records
+		if i := bytes.IndexByte(records, '\n'); i >= 0 {
+			line, records = records[:i], records[i+1:]
+		} else {
+			records = nil
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			counts[Classify(line)]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,275 @@
+// Package consensus decodes CometBFT consensus messages found in WAL records
+// into typed events.
+//
+// Records are newline-delimited amino-JSON TimedWALMessages, as written by
+// CometBFT's consensus WAL:
+//
+//	{"time":"...","msg":{"type":"tendermint/wal/MsgInfo","value":{
+//	    "msg":{"type":"tendermint/VoteMessage","value":{"vote":{...}}},
+//	    "peer_key":"..."}}}
+//
+// DecodeWALMessage decodes the protobuf messages of CometBFT's binary cs.wal
+// instead. Proposals, votes and block parts are decoded; every other message
+// is skipped with ErrUnsupported.
+//
+// StepTracker follows the round state records instead, summarizing each
+// height's rounds and step durations.
+package consensus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Kind identifies the type of a consensus event.
+type Kind string
+
+const (
+	KindProposal  Kind = "proposal"
+	KindPrevote   Kind = "prevote"
+	KindPrecommit Kind = "precommit"
+	KindBlockPart Kind = "block_part"
+)
+
+// Kinds lists every Kind, in a stable order.
+var Kinds = []Kind{KindProposal, KindPrevote, KindPrecommit, KindBlockPart}
+
+// ErrUnsupported is returned for records that are not proposals, votes or
+// block parts.
+var ErrUnsupported = errors.New("unsupported message")
+
+// Event is one decoded consensus message. Exactly one of Proposal, Vote and
+// BlockPart is set, according to Kind.
+type Event struct {
+	Kind Kind      `json:"kind"`
+	Time time.Time `json:"time"`
+	// PeerID is the node the message came from; empty for the node's own.
+	PeerID string `json:"peer_id,omitempty"`
+
+	Proposal  *Proposal  `json:"proposal,omitempty"`
+	Vote      *Vote      `json:"vote,omitempty"`
+	BlockPart *BlockPart `json:"block_part,omitempty"`
+}
+
+// Height returns the consensus height the event belongs to.
+func (e Event) Height() int64 {
+	switch {
+	case e.Proposal != nil:
+		return e.Proposal.Height
+	case e.Vote != nil:
+		return e.Vote.Height
+	case e.BlockPart != nil:
+		return e.BlockPart.Height
+	}
+	return 0
+}
+
+type Proposal struct {
+	Height    int64     `json:"height"`
+	Round     int32     `json:"round"`
+	POLRound  int32     `json:"pol_round"`
+	BlockHash string    `json:"block_hash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type Vote struct {
+	Height int64 `json:"height"`
+	Round  int32 `json:"round"`
+	// BlockHash is empty for a nil vote.
+	BlockHash        string    `json:"block_hash,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+	ValidatorAddress string    `json:"validator_address"`
+	ValidatorIndex   int32     `json:"validator_index"`
+}
+
+type BlockPart struct {
+	Height int64  `json:"height"`
+	Round  int32  `json:"round"`
+	Index  uint32 `json:"index"`
+	Size   int    `json:"size"`
+}
+
+// Signed message types, as in cmtproto.SignedMsgType.
+const (
+	prevoteType   = 1
+	precommitType = 2
+	proposalType  = 32
+)
+
+// aminoInt64 accepts amino's quoted int64 as well as a plain number.
+type aminoInt64 int64
+
+func (n *aminoInt64) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseInt(string(bytes.Trim(b, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %s", b)
+	}
+	*n = aminoInt64(v)
+	return nil
+}
+
+type typed struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type timedWALMessage struct {
+	Time time.Time `json:"time"`
+	Msg  typed     `json:"msg"`
+}
+
+type msgInfo struct {
+	Msg     typed  `json:"msg"`
+	PeerKey string `json:"peer_key"`
+}
+
+type blockID struct {
+	Hash string `json:"hash"`
+}
+
+type wireProposal struct {
+	Type      int        `json:"type"`
+	Height    aminoInt64 `json:"height"`
+	Round     int32      `json:"round"`
+	POLRound  int32      `json:"pol_round"`
+	BlockID   blockID    `json:"block_id"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+type wireVote struct {
+	Type             int        `json:"type"`
+	Height           aminoInt64 `json:"height"`
+	Round            int32      `json:"round"`
+	BlockID          blockID    `json:"block_id"`
+	Timestamp        time.Time  `json:"timestamp"`
+	ValidatorAddress string     `json:"validator_address"`
+	ValidatorIndex   int32      `json:"validator_index"`
+}
+
+type wireBlockPart struct {
+	Height aminoInt64 `json:"height"`
+	Round  int32      `json:"round"`
+	Part   struct {
+		Index uint32 `json:"index"`
+		Bytes []byte `json:"bytes"`
+	} `json:"part"`
+}
+
+// Decode decodes one record. It returns ErrUnsupported for messages other
+// than proposals, votes and block parts, and a descriptive error for
+// supported messages that violate the schema.
+func Decode(record []byte) (Event, error) {
+	var tm timedWALMessage
+	if err := json.Unmarshal(record, &tm); err != nil {
+		return Event{}, fmt.Errorf("decode wal message: %w", err)
+	}
+	if tm.Msg.Type != "tendermint/wal/MsgInfo" {
+		return Event{}, ErrUnsupported
+	}
+	var mi msgInfo
+	if err := json.Unmarshal(tm.Msg.Value, &mi); err != nil {
+		return Event{}, fmt.Errorf("decode msg info: %w", err)
+	}
+	ev := Event{Time: tm.Time, PeerID: mi.PeerKey}
+
+	switch mi.Msg.Type {
+	case "tendermint/ProposalMessage":
+		var m struct {
+			Proposal *wireProposal `json:"proposal"`
+		}
+		if err := json.Unmarshal(mi.Msg.Value, &m); err != nil {
+			return Event{}, fmt.Errorf("decode proposal: %w", err)
+		}
+		p := m.Proposal
+		if p == nil || p.Type != proposalType || p.Height <= 0 || p.Round < 0 || p.POLRound < -1 {
+			return Event{}, errors.New("invalid proposal")
+		}
+		ev.Kind = KindProposal
+		ev.Proposal = &Proposal{Height: int64(p.Height), Round: p.Round, POLRound: p.POLRound, BlockHash: p.BlockID.Hash, Timestamp: p.Timestamp}
+	case "tendermint/VoteMessage":
+		var m struct {
+			Vote *wireVote `json:"vote"`
+		}
+		if err := json.Unmarshal(mi.Msg.Value, &m); err != nil {
+			return Event{}, fmt.Errorf("decode vote: %w", err)
+		}
+		v := m.Vote
+		if v == nil || v.Height <= 0 || v.Round < 0 || v.ValidatorAddress == "" {
+			return Event{}, errors.New("invalid vote")
+		}
+		switch v.Type {
+		case prevoteType:
+			ev.Kind = KindPrevote
+		case precommitType:
+			ev.Kind = KindPrecommit
+		default:
+			return Event{}, fmt.Errorf("invalid vote type %d", v.Type)
+		}
+		ev.Vote = &Vote{Height: int64(v.Height), Round: v.Round, BlockHash: v.BlockID.Hash, Timestamp: v.Timestamp,
+			ValidatorAddress: v.ValidatorAddress, ValidatorIndex: v.ValidatorIndex}
+	case "tendermint/BlockPartMessage":
+		var m wireBlockPart
+		if err := json.Unmarshal(mi.Msg.Value, &m); err != nil {
+			return Event{}, fmt.Errorf("decode block part: %w", err)
+		}
+		if m.Height <= 0 || m.Round < 0 {
+			return Event{}, errors.New("invalid block part")
+		}
+		ev.Kind = KindBlockPart
+		ev.BlockPart = &BlockPart{Height: int64(m.Height), Round: m.Round, Index: m.Part.Index, Size: len(m.Part.Bytes)}
+	default:
+		return Event{}, ErrUnsupported
+	}
+	return ev, nil
+}
+
+// DecodeResult summarizes decoding a frame.
+type DecodeResult struct {
+	Events []Event
+	// Skipped counts unsupported records; Invalid counts records that failed
+	// to decode or violated the schema.
+	Skipped int
+	Invalid int
+}
+
+// DecodeFrame decodes every record of a decompressed frame, keeping only
+// events whose kind is in keep (all kinds when keep is empty).
+func DecodeFrame(records []byte, keep map[Kind]bool) DecodeResult {
+	var res DecodeResult
+	for len(records) > 0 {
+		line := records
+		if i := bytes.IndexByte(records, '\n'); i >= 0 {
+			line, records = records[:i], records[i+1:]
+		} else {
+			records = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		ev, err := Decode(line)
+		switch {
+		case errors.Is(err, ErrUnsupported):
+			res.Skipped++
+		case err != nil:
+			res.Invalid++
+		case len(keep) == 0 || keep[ev.Kind]:
+			res.Events = append(res.Events, ev)
+		}
+	}
+	return res
+}
+
+// ParseKind validates a kind name.
+func ParseKind(s string) (Kind, error) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown consensus event kind %q", s)
+}
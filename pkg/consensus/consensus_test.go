@@ -0,0 +1,103 @@
+package consensus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	proposalRecord = `{"time":"2024-01-01T00:00:00Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/ProposalMessage","value":{"proposal":{"type":32,"height":"10","round":0,"pol_round":-1,"block_id":{"hash":"AB"},"timestamp":"2024-01-01T00:00:00Z"}}},"peer_key":""}}}`
+	prevoteRecord  = `{"time":"2024-01-01T00:00:01Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":1,"height":"10","round":0,"block_id":{"hash":"AB"},"validator_address":"V1","validator_index":3}}},"peer_key":"peer1"}}}`
+	precommitNil   = `{"time":"2024-01-01T00:00:02Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/VoteMessage","value":{"vote":{"type":2,"height":10,"round":1,"block_id":{"hash":""},"validator_address":"V2","validator_index":4}}},"peer_key":"peer2"}}}`
+	blockPart      = `{"time":"2024-01-01T00:00:03Z","msg":{"type":"tendermint/wal/MsgInfo","value":{"msg":{"type":"tendermint/BlockPartMessage","value":{"height":"10","round":0,"part":{"index":2,"bytes":"AQID"}}},"peer_key":""}}}`
+	timeout        = `{"time":"2024-01-01T00:00:04Z","msg":{"type":"tendermint/wal/TimeoutInfo","value":{"height":"10","round":0,"step":1}}}`
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  string
+		kind    Kind
+		height  int64
+		wantErr bool
+	}{
+		{"proposal", proposalRecord, KindProposal, 10, false},
+		{"prevote", prevoteRecord, KindPrevote, 10, false},
+		{"precommit with numeric height", precommitNil, KindPrecommit, 10, false},
+		{"block part", blockPart, KindBlockPart, 10, false},
+		{"zero height vote", strings.Replace(prevoteRecord, `"height":"10"`, `"height":"0"`, 1), "", 0, true},
+		{"unknown vote type", strings.Replace(prevoteRecord, `"type":1`, `"type":7`, 1), "", 0, true},
+		{"missing validator", strings.Replace(prevoteRecord, `"V1"`, `""`, 1), "", 0, true},
+		{"bad height", strings.Replace(prevoteRecord, `"height":"10"`, `"height":"x"`, 1), "", 0, true},
+		{"wrong proposal type", strings.Replace(proposalRecord, `"type":32`, `"type":1`, 1), "", 0, true},
+		{"not json", `{`, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := Decode([]byte(tt.record))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if ev.Kind != tt.kind || ev.Height() != tt.height {
+				t.Errorf("got kind %s height %d, want %s %d", ev.Kind, ev.Height(), tt.kind, tt.height)
+			}
+		})
+	}
+}
+
+func TestDecode_Fields(t *testing.T) {
+	ev, err := Decode([]byte(precommitNil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.PeerID != "peer2" || ev.Vote.Round != 1 || ev.Vote.BlockHash != "" || ev.Vote.ValidatorIndex != 4 {
+		t.Errorf("unexpected vote %+v from %q", *ev.Vote, ev.PeerID)
+	}
+	ev, err = Decode([]byte(blockPart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.BlockPart.Index != 2 || ev.BlockPart.Size != 3 {
+		t.Errorf("unexpected block part %+v", *ev.BlockPart)
+	}
+}
+
+func TestDecode_Unsupported(t *testing.T) {
+	if _, err := Decode([]byte(timeout)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	frame := strings.Join([]string{proposalRecord, prevoteRecord, "", timeout, precommitNil, `{"bad"`, blockPart}, "\n")
+
+	res := DecodeFrame([]byte(frame), nil)
+	if len(res.Events) != 4 || res.Skipped != 1 || res.Invalid != 1 {
+		t.Errorf("events=%d skipped=%d invalid=%d, want 4, 1, 1", len(res.Events), res.Skipped, res.Invalid)
+	}
+
+	res = DecodeFrame([]byte(frame), map[Kind]bool{KindPrecommit: true})
+	if len(res.Events) != 1 || res.Events[0].Kind != KindPrecommit {
+		t.Errorf("filtered events = %+v, want one precommit", res.Events)
+	}
+}
+
+func TestCountTypes(t *testing.T) {
+	frame := strings.Join([]string{proposalRecord, prevoteRecord, "", timeout, precommitNil, `{"bad"`, blockPart,
+		`{"time":"2024-01-01T00:00:05Z","msg":{"type":"tendermint/wal/EndHeightMessage","value":{"height":"10"}}}`}, "\n")
+
+	got := CountTypes([]byte(frame))
+	want := map[MessageType]int{MessageProposal: 1, MessageVote: 2, MessageTimeout: 1, MessageBlockPart: 1, MessageOther: 2}
+	if len(got) != len(want) {
+		t.Fatalf("CountTypes = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("CountTypes[%s] = %d, want %d", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// VoteLatency summarizes the votes of one validator received from one peer:
+// how long after its timestamp, set by the signing validator's clock, each
+// vote was logged by the node. Clock skew between the validator and the node
+// offsets every sample alike, so the values compare peers and validators
+// with each other rather than measure absolute network delay.
+type VoteLatency struct {
+	// PeerID is the peer the votes came from; empty for the node's own.
+	PeerID           string `json:"peer_id,omitempty"`
+	ValidatorAddress string `json:"validator_address"`
+	FromHeight       int64  `json:"from_height"`
+	ToHeight         int64  `json:"to_height"`
+	Votes            int    `json:"votes"`
+	// Latencies in milliseconds; negative when the node's clock is behind
+	// the validator's.
+	MinMs    float64 `json:"min_ms"`
+	MedianMs float64 `json:"median_ms"`
+	P90Ms    float64 `json:"p90_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// VoteLatencies computes the latencies of the votes among events, one
+// VoteLatency per peer and validator, ordered by peer and then validator.
+// Votes without a timestamp are left out.
+func VoteLatencies(events []Event) []VoteLatency {
+	type key struct{ peer, validator string }
+	samples := map[key][]time.Duration{}
+	heights := map[key][2]int64{}
+	for _, ev := range events {
+		v := ev.Vote
+		if v == nil || v.Timestamp.IsZero() || ev.Time.IsZero() {
+			continue
+		}
+		k := key{ev.PeerID, v.ValidatorAddress}
+		samples[k] = append(samples[k], ev.Time.Sub(v.Timestamp))
+		h, ok := heights[k]
+		if !ok || v.Height < h[0] {
+			h[0] = v.Height
+		}
+		if v.Height > h[1] {
+			h[1] = v.Height
+		}
+		heights[k] = h
+	}
+
+	out := make([]VoteLatency, 0, len(samples))
+	for k, ds := range samples {
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		h := heights[k]
+		out = append(out, VoteLatency{
+			PeerID:           k.peer,
+			ValidatorAddress: k.validator,
+			FromHeight:       h[0],
+			ToHeight:         h[1],
+			Votes:            len(ds),
+			MinMs:            millis(ds[0]),
+			MedianMs:         millis(percentile(ds, 0.5)),
+			P90Ms:            millis(percentile(ds, 0.9)),
+			MaxMs:            millis(ds[len(ds)-1]),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].PeerID != out[j].PeerID {
+			return out[i].PeerID < out[j].PeerID
+		}
+		return out[i].ValidatorAddress < out[j].ValidatorAddress
+	})
+	return out
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted ds.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(ds)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return ds[i]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,38 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVoteLatencies(t *testing.T) {
+	signed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	vote := func(peer, validator string, height int64, after time.Duration) Event {
+		return Event{Kind: KindPrevote, Time: signed.Add(after), PeerID: peer,
+			Vote: &Vote{Height: height, ValidatorAddress: validator, Timestamp: signed}}
+	}
+	events := []Event{
+		vote("peer2", "V1", 10, 40*time.Millisecond),
+		vote("peer1", "V1", 11, 30*time.Millisecond),
+		vote("peer1", "V1", 10, 10*time.Millisecond),
+		vote("peer1", "V1", 12, 20*time.Millisecond),
+		vote("", "V2", 10, -5*time.Millisecond),
+		{Kind: KindPrevote, Time: signed, Vote: &Vote{Height: 10, ValidatorAddress: "V3"}}, // no timestamp
+		{Kind: KindProposal, Time: signed, Proposal: &Proposal{Height: 10, Timestamp: signed}},
+	}
+
+	got := VoteLatencies(events)
+	want := []VoteLatency{
+		{PeerID: "", ValidatorAddress: "V2", FromHeight: 10, ToHeight: 10, Votes: 1, MinMs: -5, MedianMs: -5, P90Ms: -5, MaxMs: -5},
+		{PeerID: "peer1", ValidatorAddress: "V1", FromHeight: 10, ToHeight: 12, Votes: 3, MinMs: 10, MedianMs: 20, P90Ms: 30, MaxMs: 30},
+		{PeerID: "peer2", ValidatorAddress: "V1", FromHeight: 10, ToHeight: 10, Votes: 1, MinMs: 40, MedianMs: 40, P90Ms: 40, MaxMs: 40},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d latencies, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("latency %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the CometBFT protobuf messages decoded here.
+const (
+	walMsgInfo = 2 // WALMessage.msg_info
+
+	msgInfoMsg    = 1 // MsgInfo.msg
+	msgInfoPeerID = 2 // MsgInfo.peer_id
+
+	msgProposal  = 3 // Message.proposal
+	msgBlockPart = 5 // Message.block_part
+	msgVote      = 6 // Message.vote
+)
+
+// DecodeWALMessage decodes the protobuf tendermint.consensus.WALMessage of
+// a record in CometBFT's binary consensus WAL (see wal.CSWALReader), logged
+// at t. Like Decode, it returns ErrUnsupported for messages other than
+// proposals, votes and block parts.
+func DecodeWALMessage(t time.Time, msg []byte) (Event, error) {
+	info, err := field(msg, walMsgInfo)
+	if err != nil {
+		return Event{}, fmt.Errorf("decode wal message: %w", err)
+	}
+	if info == nil {
+		return Event{}, ErrUnsupported
+	}
+	ev := Event{Time: t}
+	var m []byte
+	err = fields(info, func(num protowire.Number, _ uint64, b []byte) error {
+		switch num {
+		case msgInfoMsg:
+			m = b
+		case msgInfoPeerID:
+			ev.PeerID = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return Event{}, fmt.Errorf("decode msg info: %w", err)
+	}
+
+	var kind protowire.Number
+	var body []byte
+	err = fields(m, func(num protowire.Number, _ uint64, b []byte) error {
+		kind, body = num, b
+		return nil
+	})
+	if err != nil {
+		return Event{}, fmt.Errorf("decode message: %w", err)
+	}
+	switch kind {
+	case msgProposal:
+		p, err := decodeProtoProposal(body)
+		if err != nil {
+			return Event{}, err
+		}
+		ev.Kind, ev.Proposal = KindProposal, p
+	case msgVote:
+		v, typ, err := decodeProtoVote(body)
+		if err != nil {
+			return Event{}, err
+		}
+		switch typ {
+		case prevoteType:
+			ev.Kind = KindPrevote
+		case precommitType:
+			ev.Kind = KindPrecommit
+		default:
+			return Event{}, fmt.Errorf("invalid vote type %d", typ)
+		}
+		ev.Vote = v
+	case msgBlockPart:
+		bp, err := decodeProtoBlockPart(body)
+		if err != nil {
+			return Event{}, err
+		}
+		ev.Kind, ev.BlockPart = KindBlockPart, bp
+	default:
+		return Event{}, ErrUnsupported
+	}
+	return ev, nil
+}
+
+// decodeProtoProposal decodes a consensus.Proposal message wrapping a
+// types.Proposal.
+func decodeProtoProposal(b []byte) (*Proposal, error) {
+	b, err := field(b, 1)
+	if err != nil || b == nil {
+		return nil, errors.New("invalid proposal")
+	}
+	var p Proposal
+	var typ uint64
+	err = fields(b, func(num protowire.Number, v uint64, bs []byte) error {
+		var err error
+		switch num {
+		case 1:
+			typ = v
+		case 2:
+			p.Height = int64(v)
+		case 3:
+			p.Round = int32(v)
+		case 4:
+			p.POLRound = int32(v)
+		case 5:
+			p.BlockHash, err = blockHash(bs)
+		case 6:
+			p.Timestamp, err = timestamp(bs)
+		}
+		return err
+	})
+	if err != nil || typ != proposalType || p.Height <= 0 || p.Round < 0 || p.POLRound < -1 {
+		return nil, errors.New("invalid proposal")
+	}
+	return &p, nil
+}
+
+// decodeProtoVote decodes a consensus.Vote message wrapping a types.Vote,
+// and returns the vote's signed message type.
+func decodeProtoVote(b []byte) (*Vote, uint64, error) {
+	b, err := field(b, 1)
+	if err != nil || b == nil {
+		return nil, 0, errors.New("invalid vote")
+	}
+	var v Vote
+	var typ uint64
+	err = fields(b, func(num protowire.Number, n uint64, bs []byte) error {
+		var err error
+		switch num {
+		case 1:
+			typ = n
+		case 2:
+			v.Height = int64(n)
+		case 3:
+			v.Round = int32(n)
+		case 4:
+			v.BlockHash, err = blockHash(bs)
+		case 5:
+			v.Timestamp, err = timestamp(bs)
+		case 6:
+			v.ValidatorAddress = hexBytes(bs)
+		case 7:
+			v.ValidatorIndex = int32(n)
+		}
+		return err
+	})
+	if err != nil || v.Height <= 0 || v.Round < 0 || v.ValidatorAddress == "" {
+		return nil, 0, errors.New("invalid vote")
+	}
+	return &v, typ, nil
+}
+
+// decodeProtoBlockPart decodes a consensus.BlockPart message.
+func decodeProtoBlockPart(b []byte) (*BlockPart, error) {
+	var bp BlockPart
+	err := fields(b, func(num protowire.Number, v uint64, bs []byte) error {
+		switch num {
+		case 1:
+			bp.Height = int64(v)
+		case 2:
+			bp.Round = int32(v)
+		case 3: // types.Part
+			return fields(bs, func(num protowire.Number, v uint64, bs []byte) error {
+				switch num {
+				case 1:
+					bp.Index = uint32(v)
+				case 2:
+					bp.Size = len(bs)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil || bp.Height <= 0 || bp.Round < 0 {
+		return nil, errors.New("invalid block part")
+	}
+	return &bp, nil
+}
+
+// blockHash returns the hash of a types.BlockID, in amino's hex form.
+func blockHash(b []byte) (string, error) {
+	hash, err := field(b, 1)
+	return hexBytes(hash), err
+}
+
+// hexBytes formats b as amino-JSON HexBytes do.
+func hexBytes(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// timestamp decodes a google.protobuf.Timestamp.
+func timestamp(b []byte) (time.Time, error) {
+	var secs, nanos int64
+	err := fields(b, func(num protowire.Number, v uint64, _ []byte) error {
+		switch num {
+		case 1:
+			secs = int64(v)
+		case 2:
+			nanos = int64(int32(v))
+		}
+		return nil
+	})
+	return time.Unix(secs, nanos).UTC(), err
+}
+
+// field returns the bytes of the last field num of the protobuf message b,
+// or nil if it has none.
+func field(b []byte, num protowire.Number) ([]byte, error) {
+	var v []byte
+	err := fields(b, func(n protowire.Number, _ uint64, bs []byte) error {
+		if n == num && bs != nil {
+			v = bs
+		}
+		return nil
+	})
+	return v, err
+}
+
+// fields calls fn with each field of the protobuf message b: v holds varint
+// and fixed-size values, bs the contents of length-delimited ones.
+func fields(b []byte, fn func(num protowire.Number, v uint64, bs []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var bs []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			bs, n = protowire.ConsumeBytes(b)
+			if bs == nil {
+				bs = []byte{}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v, bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package consensus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func varintField(num protowire.Number, v int64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func bytesField(num protowire.Number, fields ...[]byte) []byte {
+	var v []byte
+	for _, f := range fields {
+		v = append(v, f...)
+	}
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// walMessage wraps a consensus.Message field in MsgInfo and WALMessage.
+func walMessage(peer string, message []byte) []byte {
+	return bytesField(walMsgInfo, bytesField(msgInfoMsg, message), bytesField(msgInfoPeerID, []byte(peer)))
+}
+
+func TestDecodeWALMessage(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC)
+	ts := bytesField(5, varintField(1, at.Unix()))
+	blockID := bytesField(4, bytesField(1, []byte{0xab}))
+	vote := func(typ int64, height int64) []byte {
+		return bytesField(msgVote, bytesField(1, varintField(1, typ), varintField(2, height), varintField(3, 0),
+			blockID, ts, bytesField(6, []byte{0x01, 0xf2}), varintField(7, 3)))
+	}
+	proposal := bytesField(msgProposal, bytesField(1, varintField(1, proposalType), varintField(2, 10), varintField(3, 0),
+		varintField(4, -1), bytesField(5, bytesField(1, []byte{0xab}))))
+	part := bytesField(msgBlockPart, varintField(1, 10), varintField(2, 0), bytesField(3, varintField(1, 2), bytesField(2, []byte{1, 2, 3})))
+	timeoutInfo := bytesField(3, varintField(2, 10))
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    Event
+		wantErr error
+	}{
+		{name: "prevote", msg: walMessage("peer1", vote(prevoteType, 10)),
+			want: Event{Kind: KindPrevote, Time: at, PeerID: "peer1", Vote: &Vote{Height: 10, BlockHash: "AB", Timestamp: at, ValidatorAddress: "01F2", ValidatorIndex: 3}}},
+		{name: "precommit", msg: walMessage("", vote(precommitType, 11)),
+			want: Event{Kind: KindPrecommit, Time: at, Vote: &Vote{Height: 11, BlockHash: "AB", Timestamp: at, ValidatorAddress: "01F2", ValidatorIndex: 3}}},
+		{name: "proposal", msg: walMessage("", proposal),
+			want: Event{Kind: KindProposal, Time: at, Proposal: &Proposal{Height: 10, POLRound: -1, BlockHash: "AB"}}},
+		{name: "block part", msg: walMessage("", part),
+			want: Event{Kind: KindBlockPart, Time: at, BlockPart: &BlockPart{Height: 10, Index: 2, Size: 3}}},
+		{name: "zero height vote", msg: walMessage("", vote(prevoteType, 0)), wantErr: errors.New("invalid vote")},
+		{name: "unknown vote type", msg: walMessage("", vote(7, 10)), wantErr: errors.New("invalid vote type 7")},
+		{name: "timeout", msg: timeoutInfo, wantErr: ErrUnsupported},
+		{name: "truncated", msg: walMessage("", proposal)[:5], wantErr: errors.New("decode wal message")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := DecodeWALMessage(at, tt.msg)
+			if tt.wantErr != nil {
+				if err == nil || (errors.Is(tt.wantErr, ErrUnsupported) != errors.Is(err, ErrUnsupported)) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Kind != tt.want.Kind || !ev.Time.Equal(tt.want.Time) || ev.PeerID != tt.want.PeerID {
+				t.Errorf("event = %+v, want %+v", ev, tt.want)
+			}
+			switch {
+			case tt.want.Vote != nil:
+				if ev.Vote == nil || *ev.Vote != *tt.want.Vote {
+					t.Errorf("vote = %+v, want %+v", ev.Vote, tt.want.Vote)
+				}
+			case tt.want.Proposal != nil:
+				if ev.Proposal == nil || *ev.Proposal != *tt.want.Proposal {
+					t.Errorf("proposal = %+v, want %+v", ev.Proposal, tt.want.Proposal)
+				}
+			case tt.want.BlockPart != nil:
+				if ev.BlockPart == nil || *ev.BlockPart != *tt.want.BlockPart {
+					t.Errorf("block part = %+v, want %+v", ev.BlockPart, tt.want.BlockPart)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// HeightSummary condenses the consensus of one height: how many rounds it
+// took and how long the node spent in each step.
+type HeightSummary struct {
+	Height int64     `json:"height"`
+	Rounds int32     `json:"rounds"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// StepsMs is the time spent in each step over all rounds, in
+	// milliseconds, keyed by the step name without its RoundStep prefix
+	// (NewHeight, Propose, Prevote, PrevoteWait, ...).
+	StepsMs map[string]float64 `json:"steps_ms"`
+	// Partial is set when the WAL did not cover the start of the height.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// StepTracker derives HeightSummaries from the round state records
+// (tendermint/event/RoundState) CometBFT writes to its WAL on every step
+// change, and the EndHeightMessage that closes each height. Records must be
+// added in WAL order.
+type StepTracker struct {
+	cur       *HeightSummary
+	step      string
+	stepStart time.Time
+}
+
+type roundStateRecord struct {
+	Time time.Time `json:"time"`
+	Msg  struct {
+		Type  string `json:"type"`
+		Value struct {
+			Height aminoInt64 `json:"height"`
+			Round  int32      `json:"round"`
+			Step   string     `json:"step"`
+		} `json:"value"`
+	} `json:"msg"`
+}
+
+// Add processes one record and returns the summary of the height it
+// completes, if any. Records other than round states and end-of-height
+// messages are ignored.
+func (t *StepTracker) Add(record []byte) (HeightSummary, bool) {
+	// Cheap check first: most records are votes and block parts.
+	if !bytes.Contains(record, []byte("tendermint/event/RoundState")) && !bytes.Contains(record, []byte("tendermint/wal/EndHeightMessage")) {
+		return HeightSummary{}, false
+	}
+	var rec roundStateRecord
+	if json.Unmarshal(record, &rec) != nil {
+		return HeightSummary{}, false
+	}
+	v := rec.Msg.Value
+	switch rec.Msg.Type {
+	case "tendermint/event/RoundState":
+		var done HeightSummary
+		var ok bool
+		if t.cur != nil && t.cur.Height != int64(v.Height) {
+			// The end of the height was not logged; the next one ends it.
+			done, ok = t.finish(rec.Time), true
+		}
+		step := strings.TrimPrefix(v.Step, "RoundStep")
+		if t.cur == nil {
+			t.cur = &HeightSummary{Height: int64(v.Height), Start: rec.Time, StepsMs: map[string]float64{}, Partial: step != "NewHeight"}
+		} else {
+			t.closeStep(rec.Time)
+		}
+		t.step, t.stepStart = step, rec.Time
+		if v.Round+1 > t.cur.Rounds {
+			t.cur.Rounds = v.Round + 1
+		}
+		return done, ok
+	case "tendermint/wal/EndHeightMessage":
+		if t.cur == nil || t.cur.Height != int64(v.Height) {
+			t.cur = nil
+			return HeightSummary{}, false
+		}
+		return t.finish(rec.Time), true
+	}
+	return HeightSummary{}, false
+}
+
+// AddFrame processes every newline-delimited record of a decompressed frame
+// and returns the summaries of the heights they complete.
+func (t *StepTracker) AddFrame(records []byte) []HeightSummary {
+	var out []HeightSummary
+	for len(records) > 0 {
+		line := records
+		if i := bytes.IndexByte(records, '\n'); i >= 0 {
+			line, records = records[:i], records[i+1:]
+		} else {
+			records = nil
+		}
+		if s, ok := t.Add(bytes.TrimSpace(line)); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (t *StepTracker) closeStep(at time.Time) {
+	if t.step != "" && at.After(t.stepStart) {
+		t.cur.StepsMs[t.step] += float64(at.Sub(t.stepStart)) / float64(time.Millisecond)
+	}
+}
+
+func (t *StepTracker) finish(at time.Time) HeightSummary {
+	t.closeStep(at)
+	s := *t.cur
+	s.End = at
+	t.cur, t.step = nil, ""
+	return s
+}
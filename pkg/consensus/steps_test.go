@@ -0,0 +1,59 @@
+package consensus
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func roundState(sec int, height, round int, step string) string {
+	return fmt.Sprintf(`{"time":"2024-01-01T00:00:%02dZ","msg":{"type":"tendermint/event/RoundState","value":{"height":"%d","round":%d,"step":"RoundStep%s"}}}`,
+		sec, height, round, step)
+}
+
+func endHeight(sec, height int) string {
+	return fmt.Sprintf(`{"time":"2024-01-01T00:00:%02dZ","msg":{"type":"tendermint/wal/EndHeightMessage","value":{"height":"%d"}}}`, sec, height)
+}
+
+func TestStepTracker(t *testing.T) {
+	records := strings.Join([]string{
+		roundState(0, 10, 0, "Propose"), // joined mid-height
+		roundState(1, 10, 0, "Prevote"),
+		endHeight(2, 10),
+		roundState(2, 11, 0, "NewHeight"),
+		roundState(3, 11, 0, "Propose"),
+		prevoteRecord, // ignored
+		roundState(5, 11, 0, "Prevote"),
+		roundState(6, 11, 1, "NewRound"),
+		roundState(7, 11, 1, "Propose"),
+		roundState(8, 11, 1, "Commit"),
+		endHeight(9, 11),
+		roundState(9, 12, 0, "NewHeight"),
+		roundState(10, 13, 0, "NewHeight"), // end of 12 not logged
+	}, "\n")
+
+	var tr StepTracker
+	got := tr.AddFrame([]byte(records))
+	if len(got) != 3 {
+		t.Fatalf("got %d summaries, want 3: %+v", len(got), got)
+	}
+	tests := []struct {
+		height  int64
+		rounds  int32
+		partial bool
+		steps   map[string]float64
+	}{
+		{10, 1, true, map[string]float64{"Propose": 1000, "Prevote": 1000}},
+		{11, 2, false, map[string]float64{"NewHeight": 1000, "Propose": 3000, "Prevote": 1000, "NewRound": 1000, "Commit": 1000}},
+		{12, 1, false, map[string]float64{"NewHeight": 1000}},
+	}
+	for i, tt := range tests {
+		s := got[i]
+		if s.Height != tt.height || s.Rounds != tt.rounds || s.Partial != tt.partial || fmt.Sprint(s.StepsMs) != fmt.Sprint(tt.steps) {
+			t.Errorf("summary %d = %+v, want height %d rounds %d partial %v steps %v", i, s, tt.height, tt.rounds, tt.partial, tt.steps)
+		}
+	}
+	if d := got[1].End.Sub(got[1].Start).Seconds(); d != 7 {
+		t.Errorf("height 11 lasted %vs, want 7s", d)
+	}
+}
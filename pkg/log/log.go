@@ -0,0 +1,320 @@
+// Package log builds the agent's structured logger on log/slog: console or
+// JSON output with level filtering (New), and a Writer that lets loggers
+// emitting one JSON object per event, such as zerolog, log through the same
+// handler, so their callers need not change.
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats accepted by NewHandler.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// LevelTrace and LevelFatal extend slog's levels with those of zerolog.
+const (
+	LevelTrace = slog.LevelDebug - 4
+	LevelFatal = slog.LevelError + 4
+)
+
+// ParseLevel parses "debug", "info", "warn" (or "warning") or "error",
+// ignoring case. Empty means info.
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+}
+
+// NewHandler returns a handler writing records at level or above to w, as
+// key=value console lines (FormatText, or empty) or one JSON object per line
+// (FormatJSON).
+func NewHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	switch format {
+	case "", FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+}
+
+// New returns a logger on NewHandler(w, format, level).
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	h, err := NewHandler(w, format, level)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(h), nil
+}
+
+// replaceLevel names LevelTrace and LevelFatal instead of "DEBUG-4" and
+// "ERROR+4".
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.LevelKey || len(groups) > 0 {
+		return a
+	}
+	switch a.Value.Any() {
+	case LevelTrace:
+		a.Value = slog.StringValue("TRACE")
+	case LevelFatal:
+		a.Value = slog.StringValue("FATAL")
+	}
+	return a
+}
+
+// Writer turns JSON events written to it into records of its handler.
+type Writer struct {
+	h  slog.Handler
+	mu sync.Mutex
+}
+
+// NewWriter returns a Writer logging to h. Each Write must hold one JSON
+// object: "level", "time" (RFC 3339) and "message" (or "msg") become the
+// record's level, time and message, and the other keys its attributes, in
+// order. zerolog writes events this way; other lines are logged verbatim at
+// info level.
+func NewWriter(h slog.Handler) *Writer {
+	return &Writer{h: h}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	r, ok := parseEvent(p)
+	if !ok {
+		r = slog.NewRecord(time.Now(), slog.LevelInfo, string(bytes.TrimSpace(p)), 0)
+	}
+	ctx := context.Background()
+	if !w.h.Enabled(ctx, r.Level) {
+		return len(p), nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.h.Handle(ctx, r); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseEvent decodes one JSON event into a record, keeping the order of its
+// fields. It scans the flat objects zerolog writes in one pass, and only
+// hands escaped strings, nested objects and arrays to encoding/json.
+func parseEvent(p []byte) (slog.Record, bool) {
+	s := scanner{p: p}
+	if !s.consume('{') {
+		return slog.Record{}, false
+	}
+	var (
+		at    time.Time
+		level = slog.LevelInfo
+		msg   string
+		attrs = make([]slog.Attr, 0, 8)
+	)
+	for first := true; !s.consume('}'); first = false {
+		if !first && !s.consume(',') {
+			return slog.Record{}, false
+		}
+		key, ok := s.string()
+		if !ok || !s.consume(':') {
+			return slog.Record{}, false
+		}
+		v, ok := s.value()
+		if !ok {
+			return slog.Record{}, false
+		}
+		str, isString := v.(string)
+		switch {
+		case key == "level" && isString:
+			level = eventLevel(str)
+		case key == "time" && isString:
+			if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+				at = t
+			}
+		case (key == "message" || key == "msg") && isString:
+			msg = str
+		default:
+			attrs = append(attrs, attr(key, v))
+		}
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	r := slog.NewRecord(at, level, msg, 0)
+	r.AddAttrs(attrs...)
+	return r, true
+}
+
+// scanner reads the JSON values of an event.
+type scanner struct {
+	p []byte
+	i int
+}
+
+func (s *scanner) skipSpace() {
+	for s.i < len(s.p) && (s.p[s.i] == ' ' || s.p[s.i] == '\t' || s.p[s.i] == '\n' || s.p[s.i] == '\r') {
+		s.i++
+	}
+}
+
+// consume skips c, after any space, if it comes next.
+func (s *scanner) consume(c byte) bool {
+	s.skipSpace()
+	if s.i < len(s.p) && s.p[s.i] == c {
+		s.i++
+		return true
+	}
+	return false
+}
+
+// string reads a JSON string.
+func (s *scanner) string() (string, bool) {
+	s.skipSpace()
+	if s.i >= len(s.p) || s.p[s.i] != '"' {
+		return "", false
+	}
+	start := s.i
+	escaped := false
+	for s.i++; s.i < len(s.p); s.i++ {
+		switch s.p[s.i] {
+		case '\\':
+			escaped = true
+			s.i++
+		case '"':
+			s.i++
+			if !escaped {
+				return string(s.p[start+1 : s.i-1]), true
+			}
+			var out string
+			if err := json.Unmarshal(s.p[start:s.i], &out); err != nil {
+				return "", false
+			}
+			return out, true
+		}
+	}
+	return "", false
+}
+
+// value reads a JSON value: a string, a json.Number, a bool, nil, or a
+// nested object or array as encoding/json decodes it.
+func (s *scanner) value() (any, bool) {
+	s.skipSpace()
+	if s.i >= len(s.p) {
+		return nil, false
+	}
+	switch c := s.p[s.i]; {
+	case c == '"':
+		return s.string()
+	case c == '{' || c == '[':
+		start := s.i
+		if !s.skipNested() {
+			return nil, false
+		}
+		dec := json.NewDecoder(bytes.NewReader(s.p[start:s.i]))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, false
+		}
+		return v, true
+	case bytes.HasPrefix(s.p[s.i:], []byte("true")):
+		s.i += 4
+		return true, true
+	case bytes.HasPrefix(s.p[s.i:], []byte("false")):
+		s.i += 5
+		return false, true
+	case bytes.HasPrefix(s.p[s.i:], []byte("null")):
+		s.i += 4
+		return nil, true
+	}
+	start := s.i
+	for s.i < len(s.p) && strings.IndexByte("+-0123456789.eE", s.p[s.i]) >= 0 {
+		s.i++
+	}
+	if s.i == start {
+		return nil, false
+	}
+	return json.Number(s.p[start:s.i]), true
+}
+
+// skipNested moves past the object or array at s.i.
+func (s *scanner) skipNested() bool {
+	depth := 0
+	for ; s.i < len(s.p); s.i++ {
+		switch s.p[s.i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				s.i++
+				return true
+			}
+		case '"':
+			if _, ok := s.string(); !ok {
+				return false
+			}
+			s.i--
+		}
+	}
+	return false
+}
+
+// eventLevel maps zerolog's level names to slog levels.
+func eventLevel(s string) slog.Level {
+	switch s {
+	case "trace":
+		return LevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "fatal", "panic":
+		return LevelFatal
+	}
+	return slog.LevelInfo
+}
+
+// attr converts a decoded JSON value, turning objects into groups.
+func attr(key string, v any) slog.Attr {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return slog.Int64(key, i)
+		}
+		f, _ := v.Float64()
+		return slog.Float64(key, f)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		group := make([]any, 0, len(keys))
+		for _, k := range keys {
+			group = append(group, attr(k, v[k]))
+		}
+		return slog.Group(key, group...)
+	}
+	return slog.Any(key, v)
+}
@@ -0,0 +1,113 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    slog.Level
+		wantErr bool
+	}{
+		{in: "", want: slog.LevelInfo},
+		{in: "debug", want: slog.LevelDebug},
+		{in: "WARNING", want: slog.LevelWarn},
+		{in: " error ", want: slog.LevelError},
+		{in: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	l.Info("shown", "frames", 3)
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("not one JSON object: %q", buf.String())
+	}
+	if rec["msg"] != "shown" || rec["level"] != "INFO" || rec["frames"] != float64(3) {
+		t.Errorf("record = %v", rec)
+	}
+
+	buf.Reset()
+	l, _ = New(&buf, FormatText, slog.LevelDebug)
+	l.Debug("visible")
+	if !strings.Contains(buf.String(), "level=DEBUG msg=visible") {
+		t.Errorf("text output = %q", buf.String())
+	}
+
+	if _, err := NewHandler(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("NewHandler accepted an unknown format")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string // text handler output without the time
+	}{
+		{"zerolog event",
+			`{"level":"warn","error":"boom","frames":2,"ratio":0.5,"nodes":{"b":1,"a":"x"},"time":"2024-01-01T00:00:00Z","message":"send failed"}`,
+			`level=WARN msg="send failed" error=boom frames=2 ratio=0.5 nodes.a=x nodes.b=1`},
+		{"trace", `{"level":"trace","message":"tick"}`, `level=TRACE msg=tick`},
+		{"escapes and arrays",
+			`{"level":"info","path":"a\\\"b","ok":true,"none":null,"ids":[1,"x"],"message":"tab\there"}`,
+			`level=INFO msg="tab\there" path="a\\\"b" ok=true none=<nil> ids="[1 x]"`},
+		{"truncated", `{"level":"info","message":"cut`, `level=INFO msg="{\"level\":\"info\",\"message\":\"cut"`},
+		{"below level", `{"level":"debug","message":"hidden"}`, ``},
+		{"not json", "plain line\n", `level=INFO msg="plain line"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			lvl := new(slog.LevelVar)
+			lvl.Set(LevelTrace)
+			if tt.name == "below level" {
+				lvl.Set(slog.LevelInfo)
+			}
+			h, _ := NewHandler(&buf, FormatText, lvl)
+			if n, err := NewWriter(h).Write([]byte(tt.event)); err != nil || n != len(tt.event) {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+			got := strings.TrimSpace(buf.String())
+			if strings.Contains(tt.event, `"time"`) && !strings.HasPrefix(got, "time=2024-01-01T00:00:00.000Z ") {
+				t.Errorf("event time not kept: %q", got)
+			}
+			if i := strings.Index(got, "level="); i >= 0 {
+				got = got[i:]
+			}
+			if got != tt.want {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	h, _ := NewHandler(io.Discard, FormatText, slog.LevelInfo)
+	w := NewWriter(h)
+	event := []byte(`{"level":"info","node_id":"abc123","idx":"/wal/seg-000001.wal.idx","frames":128,"bytes":524288,"ratio":0.42,"time":"2024-01-01T00:00:00.123Z","message":"sent batch"}` + "\n")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// Package metrics is a small registry of counters, gauges and histograms.
+// Instrumentation records through the Counter, Gauge and Histogram
+// interfaces, made by a Provider. The Registry provider keeps the values in
+// process, where every sink reads the same samples: the Prometheus text
+// format (WriteText), expvar (Vars, PublishExpvar) and push sinks like
+// StatsD (Flatten). NewStatsD sends each update as it happens instead, and
+// Tee records into several providers at once. DefaultRegistry holds the
+// agent's own metrics; plugins can add theirs with Register.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is a Prometheus metric type.
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// Sample is one series at collection time. For histograms, Buckets holds the
+// cumulative counts and Count and Sum the totals; Value is unused.
+type Sample struct {
+	Name   string
+	Help   string
+	Type   Type
+	Labels map[string]string
+	Value  float64
+
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+// Bucket is a cumulative histogram bucket.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Collector produces samples when the registry is scraped.
+type Collector interface {
+	Collect() []Sample
+}
+
+// CollectorFunc adapts a function to Collector.
+type CollectorFunc func() []Sample
+
+func (f CollectorFunc) Collect() []Sample { return f() }
+
+// RegistryCounter is the Counter a Registry collects: a monotonically
+// increasing value kept in process. It is safe for concurrent use.
+type RegistryCounter struct {
+	name, help string
+	bits       atomic.Uint64
+}
+
+// NewCounter returns an unregistered counter.
+func NewCounter(name, help string) *RegistryCounter {
+	return &RegistryCounter{name: name, help: help}
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *RegistryCounter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	addFloat(&c.bits, v)
+}
+
+// Inc increases the counter by one.
+func (c *RegistryCounter) Inc() { c.Add(1) }
+
+// Value returns the current count.
+func (c *RegistryCounter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+func (c *RegistryCounter) Collect() []Sample {
+	return []Sample{{Name: c.name, Help: c.help, Type: CounterType, Value: c.Value()}}
+}
+
+// RegistryGauge is the Gauge a Registry collects. It is safe for concurrent
+// use.
+type RegistryGauge struct {
+	name, help string
+	bits       atomic.Uint64
+}
+
+// NewGauge returns an unregistered gauge.
+func NewGauge(name, help string) *RegistryGauge {
+	return &RegistryGauge{name: name, help: help}
+}
+
+// Set sets the gauge to v.
+func (g *RegistryGauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add adds v, which may be negative.
+func (g *RegistryGauge) Add(v float64) { addFloat(&g.bits, v) }
+
+// Value returns the current value.
+func (g *RegistryGauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func (g *RegistryGauge) Collect() []Sample {
+	return []Sample{{Name: g.name, Help: g.help, Type: GaugeType, Value: g.Value()}}
+}
+
+// RegistryHistogram is the Histogram a Registry collects, counting
+// observations into buckets. It is safe for concurrent use.
+type RegistryHistogram struct {
+	name, help string
+	bounds     []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets suit latencies in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram returns an unregistered histogram with the given bucket upper
+// bounds, or DefaultBuckets if none are given.
+func NewHistogram(name, help string, buckets ...float64) *RegistryHistogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &RegistryHistogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe records v.
+func (h *RegistryHistogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *RegistryHistogram) Collect() []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := Sample{Name: h.name, Help: h.help, Type: HistogramType, Count: h.count, Sum: h.sum}
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		s.Buckets = append(s.Buckets, Bucket{UpperBound: b, Count: cum})
+	}
+	return []Sample{s}
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Registry holds collectors and renders them for scraping.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// DefaultRegistry is the registry served by the agent's metrics listener.
+var DefaultRegistry = NewRegistry()
+
+// Register adds c to DefaultRegistry.
+func Register(c Collector) { DefaultRegistry.Register(c) }
+
+// Register adds c; its samples appear on every later scrape.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Gather collects all samples, grouped by name in order of first appearance.
+func (r *Registry) Gather() []Sample {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	var order []string
+	byName := map[string][]Sample{}
+	for _, c := range collectors {
+		for _, s := range c.Collect() {
+			if _, ok := byName[s.Name]; !ok {
+				order = append(order, s.Name)
+			}
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+	}
+	out := make([]Sample, 0, len(order))
+	for _, name := range order {
+		out = append(out, byName[name]...)
+	}
+	return out
+}
+
+// WriteText writes all samples in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	prev := ""
+	for _, s := range r.Gather() {
+		if s.Name != prev {
+			prev = s.Name
+			if s.Help != "" {
+				fmt.Fprintf(bw, "# HELP %s %s\n", s.Name, escapeHelp(s.Help))
+			}
+			if s.Type != "" {
+				fmt.Fprintf(bw, "# TYPE %s %s\n", s.Name, s.Type)
+			}
+		}
+		if s.Type != HistogramType {
+			fmt.Fprintf(bw, "%s%s %s\n", s.Name, formatLabels(s.Labels, "", 0), formatFloat(s.Value))
+			continue
+		}
+		for _, b := range s.Buckets {
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", s.Name, formatLabels(s.Labels, "le", b.UpperBound), b.Count)
+		}
+		fmt.Fprintf(bw, "%s_bucket%s %d\n", s.Name, formatLabels(s.Labels, "le", math.Inf(1)), s.Count)
+		fmt.Fprintf(bw, "%s_sum%s %s\n", s.Name, formatLabels(s.Labels, "", 0), formatFloat(s.Sum))
+		fmt.Fprintf(bw, "%s_count%s %d\n", s.Name, formatLabels(s.Labels, "", 0), s.Count)
+	}
+	return bw.Flush()
+}
+
+// ParseText reads series in the Prometheus text exposition format, such as
+// a node's /metrics page. Each line becomes an untyped Sample; histogram and
+// summary lines stay the separate series they are in the text. Comments,
+// HELP and TYPE lines and sample timestamps are ignored.
+func ParseText(r io.Reader) ([]Sample, error) {
+	var out []Sample
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		out = append(out, s)
+	}
+	return out, sc.Err()
+}
+
+// parseLine parses name{label="value",...} value [timestamp].
+func parseLine(line string) (Sample, error) {
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return Sample{}, fmt.Errorf("no value for %q", line)
+	}
+	s := Sample{Name: line[:i]}
+	rest := line[i:]
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return Sample{}, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		s.Labels, rest = labels, rest[n:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Sample{}, fmt.Errorf("%s: want a value and an optional timestamp", s.Name)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	s.Value = v
+	return s, nil
+}
+
+// parseLabels parses the label set at the start of s and returns it with the
+// number of bytes it took, including the braces.
+func parseLabels(s string) (map[string]string, int, error) {
+	labels := map[string]string{}
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, nil
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return nil, 0, fmt.Errorf("malformed labels %q", s)
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Errorf("malformed labels %q", s)
+		}
+		i++
+		var v strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				if s[i] == 'n' {
+					v.WriteByte('\n')
+					continue
+				}
+			}
+			v.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = v.String()
+		i++
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// Flatten returns samples as single-valued series for sinks without a
+// histogram type, such as StatsD and remote-write: each histogram becomes
+// its cumulative name_bucket series (with an le label) when buckets is set,
+// plus name_sum and name_count.
+func Flatten(samples []Sample, buckets bool) []Sample {
+	out := make([]Sample, 0, len(samples))
+	for _, s := range samples {
+		if s.Type != HistogramType {
+			out = append(out, s)
+			continue
+		}
+		if buckets {
+			for _, b := range s.Buckets {
+				out = append(out, Sample{Name: s.Name + "_bucket", Help: s.Help, Type: CounterType,
+					Labels: withLabel(s.Labels, "le", formatFloat(b.UpperBound)), Value: float64(b.Count)})
+			}
+			out = append(out, Sample{Name: s.Name + "_bucket", Help: s.Help, Type: CounterType,
+				Labels: withLabel(s.Labels, "le", "+Inf"), Value: float64(s.Count)})
+		}
+		out = append(out,
+			Sample{Name: s.Name + "_sum", Help: s.Help, Type: CounterType, Labels: s.Labels, Value: s.Sum},
+			Sample{Name: s.Name + "_count", Help: s.Help, Type: CounterType, Labels: s.Labels, Value: float64(s.Count)})
+	}
+	return out
+}
+
+// withLabel returns a copy of labels with k set to v.
+func withLabel(labels map[string]string, k, v string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for lk, lv := range labels {
+		out[lk] = lv
+	}
+	out[k] = v
+	return out
+}
+
+// Vars returns all samples as a JSON-friendly map keyed by metric name, for
+// publishing through expvar. Unlabelled series map to their value; labelled
+// ones to a map keyed by their labels rendered as k=v,k=v. Histograms are
+// objects with count, sum and cumulative buckets keyed by upper bound.
+func (r *Registry) Vars() map[string]any {
+	out := map[string]any{}
+	for _, s := range r.Gather() {
+		var v any = s.Value
+		if s.Type == HistogramType {
+			buckets := make(map[string]uint64, len(s.Buckets)+1)
+			for _, b := range s.Buckets {
+				buckets[formatFloat(b.UpperBound)] = b.Count
+			}
+			buckets["+Inf"] = s.Count
+			v = map[string]any{"count": s.Count, "sum": s.Sum, "buckets": buckets}
+		}
+		if len(s.Labels) == 0 {
+			out[s.Name] = v
+			continue
+		}
+		series, ok := out[s.Name].(map[string]any)
+		if !ok {
+			series = map[string]any{}
+			out[s.Name] = series
+		}
+		series[varsKey(s.Labels)] = v
+	}
+	return out
+}
+
+// varsKey renders labels sorted by name as k=v,k=v.
+func varsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + labels[k]
+	}
+	return strings.Join(keys, ",")
+}
+
+// formatLabels renders labels sorted by name, plus extra=v when extra is set.
+func formatLabels(labels map[string]string, extra string, v float64) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(labels[k]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(keys) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+		b.WriteString(`="`)
+		b.WriteString(formatFloat(v))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("test_frames_total", "Frames read.")
+	c.Add(3)
+	c.Inc()
+	c.Add(-5) // ignored
+	g := NewGauge("test_depth", "Queue depth.")
+	g.Set(7)
+	g.Add(-2)
+	h := NewHistogram("test_latency_seconds", "Latency.", 1, 0.1)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+	r.Register(c)
+	r.Register(g)
+	r.Register(h)
+	r.Register(CollectorFunc(func() []Sample {
+		return []Sample{
+			{Name: "test_state", Type: GaugeType, Labels: map[string]string{"state": "running"}, Value: 1},
+			{Name: "test_state", Type: GaugeType, Labels: map[string]string{"state": `odd"\`}, Value: 0},
+		}
+	}))
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_frames_total Frames read.
+# TYPE test_frames_total counter
+test_frames_total 4
+# HELP test_depth Queue depth.
+# TYPE test_depth gauge
+test_depth 5
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 3.55
+test_latency_seconds_count 3
+# TYPE test_state gauge
+test_state{state="running"} 1
+test_state{state="odd\"\\"} 0
+`
+	if b.String() != want {
+		t.Fatalf("WriteText =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Register(NewGauge("test_up", ""))
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if string(body) != "# TYPE test_up gauge\ntest_up 0\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestRegistry_Vars(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("test_sent_total", "")
+	c.Add(3)
+	h := NewHistogram("test_seconds", "", 1, 2)
+	h.Observe(0.5)
+	h.Observe(5)
+	r.Register(c)
+	r.Register(h)
+	r.Register(CollectorFunc(func() []Sample {
+		return []Sample{
+			{Name: "test_state", Type: GaugeType, Labels: map[string]string{"state": "up", "node": "a"}, Value: 1},
+			{Name: "test_state", Type: GaugeType, Labels: map[string]string{"state": "down", "node": "a"}},
+		}
+	}))
+
+	got, err := json.Marshal(r.Vars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"test_seconds":{"buckets":{"+Inf":2,"1":1,"2":1},"count":2,"sum":5.5},` +
+		`"test_sent_total":3,"test_state":{"node=a,state=down":0,"node=a,state=up":1}}`
+	if string(got) != want {
+		t.Errorf("Vars = %s\nwant %s", got, want)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	h := NewHistogram("test_seconds", "", 1)
+	h.Observe(0.5)
+	h.Observe(3)
+	samples := append(h.Collect(), Sample{Name: "test_up", Type: GaugeType, Labels: map[string]string{"node": "a"}, Value: 1})
+
+	render := func(ss []Sample) []string {
+		var out []string
+		for _, s := range ss {
+			out = append(out, s.Name+formatLabels(s.Labels, "", 0)+" "+formatFloat(s.Value))
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		buckets bool
+		want    []string
+	}{
+		{name: "with buckets", buckets: true, want: []string{
+			`test_seconds_bucket{le="1"} 1`, `test_seconds_bucket{le="+Inf"} 2`,
+			"test_seconds_sum 3.5", "test_seconds_count 2", `test_up{node="a"} 1`}},
+		{name: "without buckets", want: []string{
+			"test_seconds_sum 3.5", "test_seconds_count 2", `test_up{node="a"} 1`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(Flatten(samples, tt.buckets))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Flatten =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseText(t *testing.T) {
+	const text = `# HELP cometbft_consensus_height Height of the chain.
+# TYPE cometbft_consensus_height gauge
+cometbft_consensus_height{chain_id="test-chain"} 1234
+
+cometbft_p2p_peers{chain_id="test-chain",} 7 1700000000000
+test_escaped{a="x\"y\\z\nw", b = "2"} +Inf
+test_seconds_bucket{le="0.5"} 3
+test_up 1
+`
+	got, err := ParseText(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseText: %v", err)
+	}
+	var lines []string
+	for _, s := range got {
+		lines = append(lines, s.Name+formatLabels(s.Labels, "", 0)+" "+formatFloat(s.Value))
+	}
+	want := []string{
+		`cometbft_consensus_height{chain_id="test-chain"} 1234`,
+		`cometbft_p2p_peers{chain_id="test-chain"} 7`,
+		`test_escaped{a="x\"y\\z\nw",b="2"} +Inf`,
+		`test_seconds_bucket{le="0.5"} 3`,
+		"test_up 1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ParseText =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseText_Malformed(t *testing.T) {
+	for _, text := range []string{"test_up", `test_up{a="1} 1`, "test_up one", `test_up{a} 1`} {
+		if _, err := ParseText(strings.NewReader(text)); err == nil {
+			t.Errorf("ParseText(%q) succeeded", text)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"expvar"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Counter is a value that only goes up.
+type Counter interface {
+	// Add increases the counter by v; negative values are ignored.
+	Add(v float64)
+	// Inc increases the counter by one.
+	Inc()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge interface {
+	// Set sets the gauge to v.
+	Set(v float64)
+	// Add adds v, which may be negative.
+	Add(v float64)
+}
+
+// Histogram records the distribution of observed values.
+type Histogram interface {
+	Observe(v float64)
+}
+
+// Provider makes the instruments of one backend, so code is instrumented
+// once against the interfaces and exported wherever the provider sends it.
+// Histogram buckets are a hint that backends without buckets ignore.
+type Provider interface {
+	Counter(name, help string) Counter
+	Gauge(name, help string) Gauge
+	Histogram(name, help string, buckets ...float64) Histogram
+}
+
+// NewRegistryProvider returns a Provider whose instruments are registered
+// in r, which exports them in the Prometheus text format (Handler) and
+// through expvar (PublishExpvar).
+func NewRegistryProvider(r *Registry) Provider { return registryProvider{r} }
+
+type registryProvider struct{ r *Registry }
+
+func (p registryProvider) Counter(name, help string) Counter {
+	c := NewCounter(name, help)
+	p.r.Register(c)
+	return c
+}
+
+func (p registryProvider) Gauge(name, help string) Gauge {
+	g := NewGauge(name, help)
+	p.r.Register(g)
+	return g
+}
+
+func (p registryProvider) Histogram(name, help string, buckets ...float64) Histogram {
+	h := NewHistogram(name, help, buckets...)
+	p.r.Register(h)
+	return h
+}
+
+// PublishExpvar publishes r's samples, as Vars renders them, as the expvar
+// name. Like expvar.Publish it panics if name is already published.
+func PublishExpvar(name string, r *Registry) {
+	expvar.Publish(name, expvar.Func(func() any { return r.Vars() }))
+}
+
+// NewStatsD returns a Provider that writes every update to w as a StatsD
+// line, prefixed with prefix: counters as |c, gauges as |g (Add as a
+// signed delta) and histograms as |h, which DogStatsD and Etsy's StatsD both
+// accept. Each line is one Write, so w is typically a UDP connection; write
+// errors are dropped, as StatsD is best effort.
+func NewStatsD(w io.Writer, prefix string) Provider {
+	return &statsdProvider{w: w, prefix: prefix}
+}
+
+type statsdProvider struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+}
+
+func (p *statsdProvider) send(name string, v float64, typ string, delta bool) {
+	b := make([]byte, 0, len(p.prefix)+len(name)+24)
+	b = append(b, p.prefix...)
+	b = append(b, name...)
+	b = append(b, ':')
+	if delta && v >= 0 {
+		b = append(b, '+')
+	}
+	b = strconv.AppendFloat(b, v, 'g', -1, 64)
+	b = append(b, '|')
+	b = append(b, typ...)
+	p.mu.Lock()
+	_, _ = p.w.Write(b)
+	p.mu.Unlock()
+}
+
+func (p *statsdProvider) Counter(name, _ string) Counter { return statsdCounter{p, name} }
+func (p *statsdProvider) Gauge(name, _ string) Gauge     { return statsdGauge{p, name} }
+func (p *statsdProvider) Histogram(name, _ string, _ ...float64) Histogram {
+	return statsdHistogram{p, name}
+}
+
+type statsdCounter struct {
+	p    *statsdProvider
+	name string
+}
+
+func (c statsdCounter) Add(v float64) {
+	if v >= 0 {
+		c.p.send(c.name, v, "c", false)
+	}
+}
+
+func (c statsdCounter) Inc() { c.Add(1) }
+
+type statsdGauge struct {
+	p    *statsdProvider
+	name string
+}
+
+// Set sends v. A negative value would read as a delta, so the gauge is
+// zeroed first and v sent as one.
+func (g statsdGauge) Set(v float64) {
+	if v < 0 {
+		g.p.send(g.name, 0, "g", false)
+		g.p.send(g.name, v, "g", true)
+		return
+	}
+	g.p.send(g.name, v, "g", false)
+}
+
+func (g statsdGauge) Add(v float64) { g.p.send(g.name, v, "g", true) }
+
+type statsdHistogram struct {
+	p    *statsdProvider
+	name string
+}
+
+func (h statsdHistogram) Observe(v float64) { h.p.send(h.name, v, "h", false) }
+
+// Tee returns a Provider whose instruments record into every one of
+// providers.
+func Tee(providers ...Provider) Provider { return teeProvider(providers) }
+
+type teeProvider []Provider
+
+func (t teeProvider) Counter(name, help string) Counter {
+	cs := make(teeCounter, len(t))
+	for i, p := range t {
+		cs[i] = p.Counter(name, help)
+	}
+	return cs
+}
+
+func (t teeProvider) Gauge(name, help string) Gauge {
+	gs := make(teeGauge, len(t))
+	for i, p := range t {
+		gs[i] = p.Gauge(name, help)
+	}
+	return gs
+}
+
+func (t teeProvider) Histogram(name, help string, buckets ...float64) Histogram {
+	hs := make(teeHistogram, len(t))
+	for i, p := range t {
+		hs[i] = p.Histogram(name, help, buckets...)
+	}
+	return hs
+}
+
+type teeCounter []Counter
+
+func (cs teeCounter) Add(v float64) {
+	for _, c := range cs {
+		c.Add(v)
+	}
+}
+
+func (cs teeCounter) Inc() {
+	for _, c := range cs {
+		c.Inc()
+	}
+}
+
+type teeGauge []Gauge
+
+func (gs teeGauge) Set(v float64) {
+	for _, g := range gs {
+		g.Set(v)
+	}
+}
+
+func (gs teeGauge) Add(v float64) {
+	for _, g := range gs {
+		g.Add(v)
+	}
+}
+
+type teeHistogram []Histogram
+
+func (hs teeHistogram) Observe(v float64) {
+	for _, h := range hs {
+		h.Observe(v)
+	}
+}
+
+// The Registry instruments are the in-process implementations.
+var (
+	_ Counter   = (*RegistryCounter)(nil)
+	_ Gauge     = (*RegistryGauge)(nil)
+	_ Histogram = (*RegistryHistogram)(nil)
+)
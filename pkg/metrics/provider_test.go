@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+	"testing"
+)
+
+// lineWriter records each Write as one line, as a UDP socket sends each as
+// one datagram.
+type lineWriter struct{ lines []string }
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.lines = append(w.lines, string(b))
+	return len(b), nil
+}
+
+func TestStatsD(t *testing.T) {
+	var w lineWriter
+	p := NewStatsD(&w, "walship.")
+	c := p.Counter("frames_total", "Frames.")
+	c.Inc()
+	c.Add(2.5)
+	c.Add(-1) // ignored
+	g := p.Gauge("depth", "Depth.")
+	g.Set(7)
+	g.Add(-2)
+	g.Add(3)
+	g.Set(-4)
+	p.Histogram("latency_seconds", "Latency.").Observe(0.25)
+
+	want := []string{
+		"walship.frames_total:1|c",
+		"walship.frames_total:2.5|c",
+		"walship.depth:7|g",
+		"walship.depth:-2|g",
+		"walship.depth:+3|g",
+		"walship.depth:0|g",
+		"walship.depth:-4|g",
+		"walship.latency_seconds:0.25|h",
+	}
+	if strings.Join(w.lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines =\n%s\nwant\n%s", strings.Join(w.lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTee_RecordsOnceEverywhere(t *testing.T) {
+	r := NewRegistry()
+	var w lineWriter
+	p := Tee(NewRegistryProvider(r), NewStatsD(&w, ""))
+	p.Counter("test_sent_total", "Sent.").Inc()
+	p.Gauge("test_depth", "Depth.").Set(3)
+	p.Histogram("test_seconds", "Latency.", 1).Observe(0.5)
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"test_sent_total 1\n", "test_depth 3\n", `test_seconds_bucket{le="1"} 1`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("registry text lacks %q:\n%s", want, b.String())
+		}
+	}
+	if len(w.lines) != 3 {
+		t.Errorf("statsd lines = %q, want 3", w.lines)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	r := NewRegistry()
+	NewRegistryProvider(r).Counter("test_published_total", "").Add(2)
+	PublishExpvar("test_metrics", r)
+
+	var vars map[string]any
+	if err := json.Unmarshal([]byte(expvar.Get("test_metrics").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["test_published_total"] != 2.0 {
+		t.Errorf("vars = %v", vars)
+	}
+}
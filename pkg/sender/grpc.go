@@ -0,0 +1,187 @@
+// Package sender delivers WAL frames to the ingestion service.
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// StreamFramesMethod is the full gRPC method name of the frame stream.
+const StreamFramesMethod = "/walship.v1.Ingest/StreamFrames"
+
+var streamFramesDesc = &grpc.StreamDesc{
+	StreamName:    "StreamFrames",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// GRPCOptions configures a GRPCSender.
+type GRPCOptions struct {
+	// Insecure disables TLS, for ingestion endpoints on a trusted network.
+	Insecure bool
+	// TLS replaces the default TLS config (TLS 1.2 or later, system roots).
+	TLS *tls.Config
+	// Metadata is sent when each stream opens, e.g. authorization.
+	Metadata map[string]string
+	// DialOptions are appended to the sender's own.
+	DialOptions []grpc.DialOption
+}
+
+// GRPCSender streams frames to the service over one long-lived
+// bidirectional gRPC stream, which the server acknowledges incrementally.
+// A broken stream is replaced on the next Send. It is safe for concurrent
+// use, though sends are serialized.
+type GRPCSender struct {
+	conn *grpc.ClientConn
+	md   metadata.MD
+
+	mu     sync.Mutex
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+	seq    uint64 // last seq sent on stream
+}
+
+// NewGRPCSender connects lazily to target (host:port).
+func NewGRPCSender(target string, opts GRPCOptions) (*GRPCSender, error) {
+	tlsCfg := opts.TLS
+	if tlsCfg == nil {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	creds := credentials.NewTLS(tlsCfg)
+	if opts.Insecure {
+		creds = insecure.NewCredentials()
+	}
+	dial := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	}, opts.DialOptions...)
+	conn, err := grpc.NewClient(target, dial...)
+	if err != nil {
+		return nil, fmt.Errorf("grpc client: %w", err)
+	}
+	return &GRPCSender{conn: conn, md: metadata.New(opts.Metadata)}, nil
+}
+
+// Send streams frames, listed in segment, and waits until the server has
+// acknowledged all of them or ctx is done. It returns how many leading
+// frames were acknowledged; those are persisted even when err is non-nil.
+func (s *GRPCSender) Send(ctx context.Context, segment string, frames []wal.Frame) (int, error) {
+	if len(frames) == 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream == nil {
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	stream, first := s.stream, s.seq+1
+
+	type result struct {
+		acked int
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		acked, err := exchange(stream, segment, frames, first)
+		done <- result{acked, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		// Unblock the exchange by tearing the stream down.
+		s.cancel()
+		r = <-done
+		if r.err == nil || r.acked < len(frames) {
+			r.err = ctx.Err()
+		}
+	}
+	if r.err != nil {
+		s.reset()
+		return r.acked, r.err
+	}
+	s.seq += uint64(len(frames))
+	return r.acked, nil
+}
+
+// exchange writes frames numbered from first and reads acks until all are
+// acknowledged.
+func exchange(stream grpc.ClientStream, segment string, frames []wal.Frame, first uint64) (int, error) {
+	last := first + uint64(len(frames)) - 1
+	var sendErr error
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i, f := range frames {
+			msg := &frameMessage{Seq: first + uint64(i), Segment: segment, Meta: f.Meta, Data: f.Compressed}
+			if err := stream.SendMsg(msg); err != nil {
+				sendErr = err
+				return
+			}
+		}
+	}()
+
+	acked := 0
+	for acked < len(frames) {
+		var ack ackMessage
+		if err := stream.RecvMsg(&ack); err != nil {
+			<-sent
+			if sendErr != nil {
+				err = sendErr
+			}
+			return acked, fmt.Errorf("grpc stream: %w", err)
+		}
+		if ack.ThroughSeq >= first {
+			acked = int(min(ack.ThroughSeq, last) - first + 1)
+		}
+		if ack.Error != "" {
+			<-sent
+			return acked, errors.New("server rejected frame: " + ack.Error)
+		}
+	}
+	<-sent
+	return acked, nil
+}
+
+func (s *GRPCSender) open() error {
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), s.md))
+	stream, err := s.conn.NewStream(ctx, streamFramesDesc, StreamFramesMethod)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("open grpc stream: %w", err)
+	}
+	s.stream, s.cancel, s.seq = stream, cancel, 0
+	return nil
+}
+
+func (s *GRPCSender) reset() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.stream, s.cancel = nil, nil
+}
+
+// Close ends the stream and the connection.
+func (s *GRPCSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream != nil {
+		_ = s.stream.CloseSend()
+	}
+	s.reset()
+	return s.conn.Close()
+}
@@ -0,0 +1,168 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// ingestServer acks every frame it receives, except that it rejects the
+// frame with seq rejectSeq and stalls on the frame with seq stallSeq.
+type ingestServer struct {
+	rejectSeq, stallSeq uint64
+
+	mu      sync.Mutex
+	frames  []frameMessage
+	auth    []string
+	streams int
+}
+
+func (s *ingestServer) handle(_ any, stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	s.mu.Lock()
+	s.auth = append(s.auth, md.Get("authorization")...)
+	s.streams++
+	s.mu.Unlock()
+	for {
+		var f frameMessage
+		if err := stream.RecvMsg(&f); err != nil {
+			return nil
+		}
+		if f.Seq == s.stallSeq {
+			<-stream.Context().Done()
+			return nil
+		}
+		if f.Seq == s.rejectSeq {
+			return stream.SendMsg(&ackMessage{ThroughSeq: f.Seq - 1, Error: "bad frame"})
+		}
+		s.mu.Lock()
+		s.frames = append(s.frames, f)
+		s.mu.Unlock()
+		if err := stream.SendMsg(&ackMessage{ThroughSeq: f.Seq}); err != nil {
+			return err
+		}
+	}
+}
+
+func startServer(t *testing.T, s *ingestServer) *GRPCSender {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(codec{}))
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "walship.v1.Ingest",
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: "StreamFrames", Handler: s.handle, ServerStreams: true, ClientStreams: true,
+		}},
+	}, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	gs, err := NewGRPCSender("passthrough:///bufnet", GRPCOptions{
+		Insecure: true,
+		Metadata: map[string]string{"authorization": "Bearer k"},
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gs.Close() })
+	return gs
+}
+
+func testFrames(n int, firstFrame uint64) []wal.Frame {
+	out := make([]wal.Frame, n)
+	for i := range out {
+		out[i] = wal.Frame{
+			Meta:       wal.FrameMeta{File: "seg-000001.wal.gz", Frame: firstFrame + uint64(i), Len: 3, FirstTS: -5},
+			Compressed: []byte{1, 2, byte(i)},
+		}
+	}
+	return out
+}
+
+func TestGRPCSender_StreamsAndReusesStream(t *testing.T) {
+	srv := &ingestServer{}
+	gs := startServer(t, srv)
+	ctx := context.Background()
+
+	for _, first := range []uint64{1, 4} {
+		n, err := gs.Send(ctx, "seg-000001.wal.idx", testFrames(3, first))
+		if err != nil || n != 3 {
+			t.Fatalf("Send = %d, %v; want 3, nil", n, err)
+		}
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.streams != 1 {
+		t.Errorf("streams = %d, want one long-lived stream", srv.streams)
+	}
+	if len(srv.frames) != 6 || srv.frames[5].Seq != 6 || srv.frames[5].Meta.Frame != 6 {
+		t.Fatalf("server frames = %+v", srv.frames)
+	}
+	f := srv.frames[0]
+	if f.Segment != "seg-000001.wal.idx" || f.Meta.File != "seg-000001.wal.gz" || f.Meta.FirstTS != -5 || string(f.Data) != "\x01\x02\x00" {
+		t.Errorf("first frame = %+v", f)
+	}
+	if len(srv.auth) != 1 || srv.auth[0] != "Bearer k" {
+		t.Errorf("authorization metadata = %v", srv.auth)
+	}
+}
+
+func TestGRPCSender_PartialAckOnReject(t *testing.T) {
+	srv := &ingestServer{rejectSeq: 3}
+	gs := startServer(t, srv)
+
+	n, err := gs.Send(context.Background(), "seg", testFrames(4, 1))
+	if err == nil || n != 2 {
+		t.Fatalf("Send = %d, %v; want 2 acked and an error", n, err)
+	}
+
+	// The next send opens a fresh stream numbered from 1 again.
+	srv.rejectSeq = 0
+	if n, err := gs.Send(context.Background(), "seg", testFrames(2, 3)); err != nil || n != 2 {
+		t.Fatalf("retry Send = %d, %v", n, err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.streams != 2 {
+		t.Errorf("streams = %d, want 2", srv.streams)
+	}
+}
+
+func TestGRPCSender_Timeout(t *testing.T) {
+	gs := startServer(t, &ingestServer{stallSeq: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n, err := gs.Send(ctx, "seg", testFrames(3, 1))
+	if !errors.Is(err, context.DeadlineExceeded) || n != 1 {
+		t.Fatalf("Send = %d, %v; want 1 acked and deadline exceeded", n, err)
+	}
+}
+
+func TestWire_RoundTrip(t *testing.T) {
+	in := frameMessage{Seq: 7, Segment: "s", Meta: wal.FrameMeta{File: "f", Frame: 2, Off: 3, Len: 4, Recs: 5, FirstTS: 6, LastTS: -1, CRC32: 9}, Data: []byte("x")}
+	var out frameMessage
+	if err := out.unmarshal(in.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if out.Seq != in.Seq || out.Segment != in.Segment || out.Meta != in.Meta || string(out.Data) != "x" {
+		t.Errorf("round trip = %+v, want %+v", out, in)
+	}
+	if err := out.unmarshal([]byte{0x0a, 0x05}); err == nil {
+		t.Error("expected error for truncated message")
+	}
+}
@@ -0,0 +1,430 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// KafkaOptions configures a KafkaSender.
+type KafkaOptions struct {
+	// Brokers are the host:port addresses the cluster is discovered from.
+	Brokers []string
+	// Topic receives the frames; it must exist.
+	Topic string
+	// ClientID identifies the producer in broker logs and quotas.
+	ClientID string
+	// TLS, if set, secures the broker connections.
+	TLS *tls.Config
+	// Timeout bounds how long brokers wait for replicas to acknowledge a
+	// produce (default 10s).
+	Timeout time.Duration
+	// MaxBatchBytes caps the frame bytes in one record batch; larger sends
+	// are split (default 900KB, under the brokers' default 1MB limit).
+	MaxBatchBytes int
+	// MaxAttempts bounds how often one record batch is produced before Send
+	// gives up (default 3), waiting RetryBackoff, doubling, in between.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// OnRetry, if set, is called before each retry with the attempt that
+	// failed.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// Dial opens broker connections; nil uses a net.Dialer.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// KafkaError is a record batch the cluster did not take. Code is the
+// broker's error code, 0 if no broker answered.
+type KafkaError struct {
+	Code     int16
+	Attempts int
+	Err      error
+}
+
+func (e *KafkaError) Error() string {
+	return fmt.Sprintf("kafka produce failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *KafkaError) Unwrap() error { return e.Err }
+
+// Retriable reports whether sending the batch again may succeed.
+func (e *KafkaError) Retriable() bool { return e.Code == kafkaErrNone || kafkaRetriable(e.Code) }
+
+// kafkaCodeError is a broker's error response.
+type kafkaCodeError int16
+
+func (e kafkaCodeError) Error() string { return "kafka: " + kafkaErrName(int16(e)) }
+
+// KafkaSender publishes frames to a Kafka topic as an idempotent producer:
+// each frame is a record holding the walship.v1.Frame message of
+// ingest.proto, keyed so one node's frames share a partition and stay in
+// order. Retries of a record batch reuse its sequence number, so the
+// broker drops a copy it already appended. Once Send gives up the producer
+// ID is renewed; sending the same frames again may then duplicate them if
+// the lost attempt had in fact been appended. It is safe for concurrent
+// use, though sends are serialized.
+type KafkaSender struct {
+	opts KafkaOptions
+
+	mu      sync.Mutex
+	conns   map[string]*kafkaConn // by broker address
+	brokers map[int32]string      // broker ID -> host:port
+	leaders []int32               // leader broker ID by partition of Topic; nil until fetched
+	pid     int64                 // producer ID, -1 until assigned
+	epoch   int16
+	seqs    map[int32]int32 // next sequence by partition
+}
+
+// NewKafkaSender returns a sender that connects lazily to opts.Brokers.
+func NewKafkaSender(opts KafkaOptions) (*KafkaSender, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers")
+	}
+	if opts.Topic == "" {
+		return nil, errors.New("kafka: no topic")
+	}
+	for _, b := range opts.Brokers {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			return nil, fmt.Errorf("kafka broker %q: %w", b, err)
+		}
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "walship"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = 900 << 10
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.Dial == nil {
+		d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+		opts.Dial = d.DialContext
+	}
+	return &KafkaSender{opts: opts, conns: map[string]*kafkaConn{}, pid: -1, seqs: map[int32]int32{}}, nil
+}
+
+// Send publishes frames, listed in segment, under key, in record batches of
+// up to MaxBatchBytes that are each appended whole or not at all. It
+// returns how many leading frames were appended; those are durable even
+// when err, a *KafkaError, is non-nil.
+func (s *KafkaSender) Send(ctx context.Context, key []byte, segment string, frames []wal.Frame) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent := 0
+	for sent < len(frames) {
+		n, size := 0, 0
+		for sent+n < len(frames) && (n == 0 || size+len(frames[sent+n].Compressed) <= s.opts.MaxBatchBytes) {
+			size += len(frames[sent+n].Compressed)
+			n++
+		}
+		records := make([]kafkaRecord, n)
+		for i, f := range frames[sent : sent+n] {
+			msg := frameMessage{Segment: segment, Meta: f.Meta, Data: f.Compressed}
+			records[i] = kafkaRecord{Key: key, Value: msg.marshal()}
+		}
+		if err := s.produceWithRetry(ctx, key, records); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+func (s *KafkaSender) produceWithRetry(ctx context.Context, key []byte, records []kafkaRecord) error {
+	delay := s.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.produce(ctx, key, records)
+		if err == nil {
+			return nil
+		}
+		var code kafkaCodeError
+		errors.As(err, &code)
+		switch int16(code) {
+		case kafkaErrNone, kafkaErrNotLeader, kafkaErrUnknownTopicOrPart, kafkaErrLeaderNotAvailable:
+			s.leaders = nil // refetch the partition leaders
+		case kafkaErrOutOfOrderSequence, kafkaErrInvalidProducerEpoch, kafkaErrUnknownProducerID:
+			s.resetProducer()
+		}
+		if attempt >= s.opts.MaxAttempts || ctx.Err() != nil || (code != 0 && !kafkaRetriable(int16(code))) {
+			// The batch may have been appended without an answer. A new
+			// producer keeps the next, possibly different, batch from
+			// reusing its sequence and being dropped as a duplicate.
+			s.resetProducer()
+			return &KafkaError{Code: int16(code), Attempts: attempt, Err: err}
+		}
+		if s.opts.OnRetry != nil {
+			s.opts.OnRetry(attempt, delay, err)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		delay *= 2
+	}
+}
+
+// produce appends records to key's partition as one record batch.
+func (s *KafkaSender) produce(ctx context.Context, key []byte, records []kafkaRecord) error {
+	if s.pid < 0 {
+		if err := s.initProducer(ctx); err != nil {
+			return err
+		}
+	}
+	if s.leaders == nil {
+		if err := s.fetchMetadata(ctx); err != nil {
+			return err
+		}
+	}
+	part := kafkaPartition(key, len(s.leaders))
+	addr, ok := s.brokers[s.leaders[part]]
+	if !ok {
+		return kafkaCodeError(kafkaErrLeaderNotAvailable)
+	}
+	seq := s.seqs[part]
+
+	var w kafkaWriter
+	w.nullString("") // transactional ID
+	w.int16(-1)      // acks: all in-sync replicas, required for idempotence
+	w.int32(int32(s.opts.Timeout / time.Millisecond))
+	w.int32(1)
+	w.string(s.opts.Topic)
+	w.int32(1)
+	w.int32(part)
+	w.bytes(encodeRecordBatch(s.pid, s.epoch, seq, records, time.Now().UnixMilli()))
+
+	resp, err := s.roundTrip(ctx, addr, kafkaAPIProduce, kafkaProduceVersion, w)
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{b: resp}
+	code := kafkaErrLeaderNotAvailable // if the partition is missing from the response
+	for i, nt := 0, r.arrayLen(); i < nt; i++ {
+		topic := r.string()
+		for j, np := 0, r.arrayLen(); j < np; j++ {
+			p, c := r.int32(), r.int16()
+			r.int64() // base offset
+			r.int64() // log append time
+			if topic == s.opts.Topic && p == part {
+				code = c
+			}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	switch code {
+	case kafkaErrNone, kafkaErrDuplicateSequence:
+		s.seqs[part] = nextSequence(seq, len(records))
+		return nil
+	}
+	return kafkaCodeError(code)
+}
+
+// initProducer obtains a producer ID from any broker.
+func (s *KafkaSender) initProducer(ctx context.Context) error {
+	var w kafkaWriter
+	w.nullString("") // transactional ID
+	w.int32(60000)   // transaction timeout, unused without transactions
+	resp, err := s.bootstrap(ctx, kafkaAPIInitProducerID, kafkaInitProducerIDVersion, w)
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{b: resp}
+	r.int32() // throttle time
+	code, pid, epoch := r.int16(), r.int64(), r.int16()
+	if r.err != nil {
+		return r.err
+	}
+	if code != kafkaErrNone {
+		return kafkaCodeError(code)
+	}
+	s.pid, s.epoch = pid, epoch
+	clear(s.seqs)
+	return nil
+}
+
+// resetProducer drops the producer ID; the next produce obtains a new one
+// and numbers from 0.
+func (s *KafkaSender) resetProducer() {
+	s.pid = -1
+	clear(s.seqs)
+}
+
+// fetchMetadata learns the brokers and the partition leaders of Topic.
+func (s *KafkaSender) fetchMetadata(ctx context.Context) error {
+	var w kafkaWriter
+	w.int32(1)
+	w.string(s.opts.Topic)
+	w.bool(false) // do not create the topic
+	resp, err := s.bootstrap(ctx, kafkaAPIMetadata, kafkaMetadataVersion, w)
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{b: resp}
+	r.int32() // throttle time
+	brokers := map[int32]string{}
+	for i, n := 0, r.arrayLen(); i < n; i++ {
+		id, host, port := r.int32(), r.string(), r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster ID
+	r.int32()  // controller ID
+	var leaders []int32
+	topicErr := kafkaErrUnknownTopicOrPart
+	for i, nt := 0, r.arrayLen(); i < nt; i++ {
+		code, name := r.int16(), r.string()
+		r.bool() // internal
+		var parts []int32
+		for j, np := 0, r.arrayLen(); j < np; j++ {
+			r.int16() // partition error, e.g. an offline leader
+			p, leader := r.int32(), r.int32()
+			r.skipInt32Array() // replicas
+			r.skipInt32Array() // in-sync replicas
+			if p >= 0 && int(p) < np {
+				if parts == nil {
+					parts = make([]int32, np)
+				}
+				parts[p] = leader
+			}
+		}
+		if name == s.opts.Topic {
+			topicErr, leaders = code, parts
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if topicErr != kafkaErrNone {
+		return kafkaCodeError(topicErr)
+	}
+	if len(leaders) == 0 {
+		return kafkaCodeError(kafkaErrLeaderNotAvailable)
+	}
+	s.brokers, s.leaders = brokers, leaders
+	return nil
+}
+
+// bootstrap sends a request to the first of opts.Brokers that answers.
+func (s *KafkaSender) bootstrap(ctx context.Context, api, version int16, body []byte) ([]byte, error) {
+	var errs []error
+	for _, addr := range s.opts.Brokers {
+		resp, err := s.roundTrip(ctx, addr, api, version, body)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// roundTrip sends a request to the broker at addr and returns the response
+// body. A connection that fails is closed and redialed next time.
+func (s *KafkaSender) roundTrip(ctx context.Context, addr string, api, version int16, body []byte) ([]byte, error) {
+	c, ok := s.conns[addr]
+	if !ok {
+		conn, err := s.opts.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("kafka dial %s: %w", addr, err)
+		}
+		if s.opts.TLS != nil {
+			cfg := s.opts.TLS.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			conn = tls.Client(conn, cfg)
+		}
+		c = &kafkaConn{conn: conn}
+		s.conns[addr] = c
+	}
+	resp, err := c.roundTrip(ctx, s.opts.ClientID, api, version, body)
+	if err != nil {
+		c.conn.Close()
+		delete(s.conns, addr)
+		return nil, fmt.Errorf("kafka %s: %w", addr, err)
+	}
+	return resp, nil
+}
+
+// Close closes the broker connections.
+func (s *KafkaSender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for addr, c := range s.conns {
+		errs = append(errs, c.conn.Close())
+		delete(s.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+// kafkaConn is a connection to one broker, used for one request at a time.
+type kafkaConn struct {
+	conn net.Conn
+	corr int32
+}
+
+func (c *kafkaConn) roundTrip(ctx context.Context, clientID string, api, version int16, body []byte) ([]byte, error) {
+	c.corr++
+	var w kafkaWriter
+	w.int32(0) // size, patched below
+	w.int16(api)
+	w.int16(version)
+	w.int32(c.corr)
+	w.nullString(clientID)
+	w = append(w, body...)
+	binary.BigEndian.PutUint32(w, uint32(len(w)-4))
+
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { c.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if _, err := c.conn.Write(w); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	r := kafkaReader{b: hdr[:]}
+	size, corr := r.int32(), r.int32()
+	if corr != c.corr {
+		return nil, fmt.Errorf("response %d to request %d", corr, c.corr)
+	}
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("response size %d", size)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return resp, nil
+}
+
+// ctxErr prefers ctx's error to the I/O error its cancellation caused.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
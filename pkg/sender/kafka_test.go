@@ -0,0 +1,327 @@
+package sender
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func TestMurmur2(t *testing.T) {
+	// Vectors from the Java client's UtilsTest.
+	for in, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := murmur2([]byte(in)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestNextSequence(t *testing.T) {
+	if got := nextSequence(5, 3); got != 8 {
+		t.Errorf("nextSequence(5, 3) = %d", got)
+	}
+	if got := nextSequence(2147483646, 3); got != 1 {
+		t.Errorf("nextSequence wraps to %d, want 1", got)
+	}
+}
+
+// fakeKafka is a single broker holding one topic. It appends idempotent
+// record batches, answering a repeated sequence as a duplicate.
+type fakeKafka struct {
+	t          *testing.T
+	ln         net.Listener
+	topic      string
+	partitions int32
+
+	mu         sync.Mutex
+	batches    []fakeBatch
+	nextSeq    map[[2]int64]int32 // by producer ID and partition
+	pids       int64
+	dropNext   int   // produce responses to drop after appending
+	produceErr int16 // returned to every produce instead of appending
+}
+
+type fakeBatch struct {
+	partition int32
+	pid       int64
+	seq       int32
+	records   []kafkaRecord
+}
+
+func newFakeKafka(t *testing.T, topic string, partitions int32) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &fakeKafka{t: t, ln: ln, topic: topic, partitions: partitions, nextSeq: map[[2]int64]int32{}}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go k.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return k
+}
+
+func (k *fakeKafka) serve(c net.Conn) {
+	defer c.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(c, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		r := kafkaReader{b: req}
+		api, _, corr := r.int16(), r.int16(), r.int32()
+		r.string() // client ID
+
+		var w kafkaWriter
+		w.int32(0)
+		w.int32(corr)
+		switch api {
+		case kafkaAPIMetadata:
+			host, port, _ := net.SplitHostPort(k.ln.Addr().String())
+			p, _ := strconv.Atoi(port)
+			w.int32(0) // throttle
+			w.int32(1)
+			w.int32(7)
+			w.string(host)
+			w.int32(int32(p))
+			w.nullString("")
+			w.nullString("") // cluster ID
+			w.int32(7)
+			w.int32(1)
+			w.int16(0)
+			w.string(k.topic)
+			w.bool(false)
+			w.int32(k.partitions)
+			for i := int32(0); i < k.partitions; i++ {
+				w.int16(0)
+				w.int32(i)
+				w.int32(7)
+				w.int32(1)
+				w.int32(7)
+				w.int32(1)
+				w.int32(7)
+			}
+		case kafkaAPIInitProducerID:
+			k.mu.Lock()
+			k.pids++
+			pid := k.pids
+			k.mu.Unlock()
+			w.int32(0)
+			w.int16(0)
+			w.int64(pid)
+			w.int16(0)
+		case kafkaAPIProduce:
+			r.string() // transactional ID
+			if acks := r.int16(); acks != -1 {
+				k.t.Errorf("acks = %d, want -1", acks)
+			}
+			r.int32()
+			r.int32()
+			topic := r.string()
+			r.int32()
+			part := r.int32()
+			b := k.decodeBatch(r.bytes())
+			b.partition = part
+			code, drop := k.append(b)
+			if drop {
+				return
+			}
+			w.int32(1)
+			w.string(topic)
+			w.int32(1)
+			w.int32(part)
+			w.int16(code)
+			w.int64(0)
+			w.int64(-1)
+			w.int32(0) // throttle
+		default:
+			k.t.Errorf("unexpected api %d", api)
+			return
+		}
+		binary.BigEndian.PutUint32(w, uint32(len(w)-4))
+		if _, err := c.Write(w); err != nil {
+			return
+		}
+	}
+}
+
+func (k *fakeKafka) append(b fakeBatch) (code int16, drop bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.produceErr != 0 {
+		return k.produceErr, false
+	}
+	id := [2]int64{b.pid, int64(b.partition)}
+	switch want := k.nextSeq[id]; {
+	case b.seq < want:
+		return kafkaErrDuplicateSequence, false
+	case b.seq > want:
+		return kafkaErrOutOfOrderSequence, false
+	}
+	k.nextSeq[id] = nextSequence(b.seq, len(b.records))
+	k.batches = append(k.batches, b)
+	if k.dropNext > 0 {
+		k.dropNext--
+		return 0, true
+	}
+	return 0, false
+}
+
+func (k *fakeKafka) decodeBatch(b []byte) fakeBatch {
+	k.t.Helper()
+	r := kafkaReader{b: b}
+	r.int64() // base offset
+	if n := r.int32(); int(n) != len(r.b) {
+		k.t.Errorf("batch length %d, have %d bytes", n, len(r.b))
+	}
+	r.int32() // leader epoch
+	if magic := r.int8(); magic != 2 {
+		k.t.Errorf("magic = %d", magic)
+	}
+	if crc := uint32(r.int32()); crc != crc32.Checksum(r.b, crc32.MakeTable(crc32.Castagnoli)) {
+		k.t.Error("bad batch CRC")
+	}
+	r.int16() // attributes
+	r.int32() // last offset delta
+	r.int64()
+	r.int64()
+	var fb fakeBatch
+	fb.pid = r.int64()
+	r.int16()
+	fb.seq = r.int32()
+	n := r.int32()
+	for i := int32(0); i < n; i++ {
+		l, m := binary.Varint(r.b)
+		rec := r.take(int(l) + m)[m:]
+		rec = rec[1:]            // attributes
+		for j := 0; j < 2; j++ { // timestamp and offset deltas
+			_, m := binary.Varint(rec)
+			rec = rec[m:]
+		}
+		var kr kafkaRecord
+		for _, dst := range []*[]byte{&kr.Key, &kr.Value} {
+			l, m := binary.Varint(rec)
+			*dst, rec = rec[m:m+int(l)], rec[m+int(l):]
+		}
+		fb.records = append(fb.records, kr)
+	}
+	if r.err != nil {
+		k.t.Error(r.err)
+	}
+	return fb
+}
+
+func kafkaTestFrames(n int) []wal.Frame {
+	frames := make([]wal.Frame, n)
+	for i := range frames {
+		frames[i] = wal.Frame{Meta: wal.FrameMeta{File: "seg-000001.wal.gz", Frame: uint64(i + 1)}, Compressed: []byte("frame-data")}
+	}
+	return frames
+}
+
+func TestKafkaSender_IdempotentRetry(t *testing.T) {
+	k := newFakeKafka(t, "frames", 3)
+	k.dropNext = 1 // the first batch is appended but its ack lost
+
+	var retries int
+	s, err := NewKafkaSender(KafkaOptions{Brokers: []string{k.ln.Addr().String()}, Topic: "frames",
+		MaxBatchBytes: 25, RetryBackoff: time.Millisecond,
+		OnRetry: func(int, time.Duration, error) { retries++ }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	key := []byte("chain-1/node-1")
+	for _, n := range []int{3, 2} {
+		sent, err := s.Send(context.Background(), key, "seg-000001.wal.idx", kafkaTestFrames(n))
+		if err != nil || sent != n {
+			t.Fatalf("Send = %d, %v; want %d", sent, err, n)
+		}
+	}
+	if retries != 1 {
+		t.Errorf("retries = %d, want 1", retries)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// 10-byte frames at 25 bytes a batch: 2+1 then 2 frames, each once.
+	var sizes []int
+	for i, b := range k.batches {
+		sizes = append(sizes, len(b.records))
+		if b.partition != kafkaPartition(key, 3) {
+			t.Errorf("batch %d in partition %d", i, b.partition)
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 1 || sizes[2] != 2 {
+		t.Fatalf("batch sizes = %v, want [2 1 2]", sizes)
+	}
+	if k.batches[2].seq != 3 {
+		t.Errorf("third batch seq = %d, want 3", k.batches[2].seq)
+	}
+	rec := k.batches[1].records[0]
+	var msg frameMessage
+	if err := msg.unmarshal(rec.Value); err != nil {
+		t.Fatal(err)
+	}
+	if string(rec.Key) != string(key) || msg.Segment != "seg-000001.wal.idx" || msg.Meta.Frame != 3 || string(msg.Data) != "frame-data" {
+		t.Errorf("record = %q %+v", rec.Key, msg)
+	}
+}
+
+func TestKafkaSender_Errors(t *testing.T) {
+	k := newFakeKafka(t, "frames", 1)
+	k.produceErr = kafkaErrMessageTooLarge
+	s, err := NewKafkaSender(KafkaOptions{Brokers: []string{k.ln.Addr().String()}, Topic: "frames", RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	sent, err := s.Send(context.Background(), []byte("k"), "seg", kafkaTestFrames(1))
+	var ke *KafkaError
+	if sent != 0 || !errors.As(err, &ke) || ke.Code != kafkaErrMessageTooLarge || ke.Attempts != 1 || ke.Retriable() {
+		t.Errorf("too large: Send = %d, %v", sent, err)
+	}
+
+	k.mu.Lock()
+	k.produceErr = kafkaErrNotEnoughReplicas
+	k.mu.Unlock()
+	if _, err := s.Send(context.Background(), []byte("k"), "seg", kafkaTestFrames(1)); !errors.As(err, &ke) || ke.Attempts != 3 || !ke.Retriable() {
+		t.Errorf("not enough replicas: err = %v", err)
+	}
+
+	missing, err := NewKafkaSender(KafkaOptions{Brokers: []string{k.ln.Addr().String()}, Topic: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer missing.Close()
+	if _, err := missing.Send(context.Background(), []byte("k"), "seg", kafkaTestFrames(1)); !errors.As(err, &ke) || ke.Code != kafkaErrUnknownTopicOrPart {
+		t.Errorf("unknown topic: err = %v", err)
+	}
+}
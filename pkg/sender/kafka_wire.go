@@ -0,0 +1,292 @@
+package sender
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// The Kafka protocol requests KafkaSender uses, in their last non-flexible
+// versions that brokers from 0.11 through 4.x all accept.
+const (
+	kafkaAPIProduce        int16 = 0
+	kafkaAPIMetadata       int16 = 3
+	kafkaAPIInitProducerID int16 = 22
+
+	kafkaProduceVersion        int16 = 3
+	kafkaMetadataVersion       int16 = 4
+	kafkaInitProducerIDVersion int16 = 0
+)
+
+// Kafka error codes KafkaSender handles specially.
+const (
+	kafkaErrNone                   int16 = 0
+	kafkaErrUnknownTopicOrPart     int16 = 3
+	kafkaErrLeaderNotAvailable     int16 = 5
+	kafkaErrNotLeader              int16 = 6
+	kafkaErrRequestTimedOut        int16 = 7
+	kafkaErrMessageTooLarge        int16 = 10
+	kafkaErrNotEnoughReplicas      int16 = 19
+	kafkaErrNotEnoughReplicasAfter int16 = 20
+	kafkaErrOutOfOrderSequence     int16 = 45
+	kafkaErrDuplicateSequence      int16 = 46
+	kafkaErrInvalidProducerEpoch   int16 = 47
+	kafkaErrUnknownProducerID      int16 = 59
+)
+
+var kafkaErrNames = map[int16]string{
+	kafkaErrUnknownTopicOrPart:     "UNKNOWN_TOPIC_OR_PARTITION",
+	kafkaErrLeaderNotAvailable:     "LEADER_NOT_AVAILABLE",
+	kafkaErrNotLeader:              "NOT_LEADER_OR_FOLLOWER",
+	kafkaErrRequestTimedOut:        "REQUEST_TIMED_OUT",
+	kafkaErrMessageTooLarge:        "MESSAGE_TOO_LARGE",
+	kafkaErrNotEnoughReplicas:      "NOT_ENOUGH_REPLICAS",
+	kafkaErrNotEnoughReplicasAfter: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	kafkaErrOutOfOrderSequence:     "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	kafkaErrInvalidProducerEpoch:   "INVALID_PRODUCER_EPOCH",
+	kafkaErrUnknownProducerID:      "UNKNOWN_PRODUCER_ID",
+}
+
+func kafkaErrName(code int16) string {
+	if n, ok := kafkaErrNames[code]; ok {
+		return n
+	}
+	return fmt.Sprintf("error code %d", code)
+}
+
+// kafkaRetriable reports whether a produce failing with code may succeed
+// if repeated.
+func kafkaRetriable(code int16) bool {
+	switch code {
+	case kafkaErrUnknownTopicOrPart, kafkaErrLeaderNotAvailable, kafkaErrNotLeader, kafkaErrRequestTimedOut,
+		kafkaErrNotEnoughReplicas, kafkaErrNotEnoughReplicasAfter,
+		kafkaErrOutOfOrderSequence, kafkaErrInvalidProducerEpoch, kafkaErrUnknownProducerID:
+		return true
+	}
+	return false
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaWriter appends Kafka's big-endian primitive types.
+type kafkaWriter []byte
+
+func (w *kafkaWriter) int8(v int8)   { *w = append(*w, byte(v)) }
+func (w *kafkaWriter) int16(v int16) { *w = binary.BigEndian.AppendUint16(*w, uint16(v)) }
+func (w *kafkaWriter) int32(v int32) { *w = binary.BigEndian.AppendUint32(*w, uint32(v)) }
+func (w *kafkaWriter) int64(v int64) { *w = binary.BigEndian.AppendUint64(*w, uint64(v)) }
+func (w *kafkaWriter) bool(v bool) {
+	if v {
+		w.int8(1)
+	} else {
+		w.int8(0)
+	}
+}
+
+func (w *kafkaWriter) string(v string) {
+	w.int16(int16(len(v)))
+	*w = append(*w, v...)
+}
+
+// nullString writes a nullable string, null if v is empty.
+func (w *kafkaWriter) nullString(v string) {
+	if v == "" {
+		w.int16(-1)
+		return
+	}
+	w.string(v)
+}
+
+func (w *kafkaWriter) bytes(v []byte) {
+	w.int32(int32(len(v)))
+	*w = append(*w, v...)
+}
+
+// kafkaReader consumes Kafka's primitive types, remembering the first
+// short read.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+var errKafkaShort = errors.New("kafka: short response")
+
+func (r *kafkaReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = errKafkaShort
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) bool() bool { return r.int8() != 0 }
+
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+func (r *kafkaReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return r.take(int(n))
+}
+
+// arrayLen reads an array length, treating null as empty.
+func (r *kafkaReader) arrayLen() int {
+	n := r.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(r.b) {
+		// Every element takes at least a byte.
+		r.err = errKafkaShort
+		return 0
+	}
+	return int(n)
+}
+
+func (r *kafkaReader) skipInt32Array() {
+	r.take(4 * r.arrayLen())
+}
+
+// kafkaRecord is one record of a record batch.
+type kafkaRecord struct {
+	Key, Value []byte
+}
+
+// encodeRecordBatch encodes records as an uncompressed v2 record batch of
+// the idempotent producer pid, numbered from seq.
+func encodeRecordBatch(pid int64, epoch int16, seq int32, records []kafkaRecord, timestampMs int64) []byte {
+	var w kafkaWriter
+	w.int64(0) // base offset, assigned by the broker
+	lenAt := len(w)
+	w.int32(0)  // batch length, patched below
+	w.int32(-1) // partition leader epoch
+	w.int8(2)   // magic
+	crcAt := len(w)
+	w.int32(0) // CRC, patched below
+	w.int16(0) // attributes: no compression, not transactional
+	w.int32(int32(len(records) - 1))
+	w.int64(timestampMs) // first timestamp
+	w.int64(timestampMs) // max timestamp
+	w.int64(pid)
+	w.int16(epoch)
+	w.int32(seq)
+	w.int32(int32(len(records)))
+	for i, rec := range records {
+		var body []byte
+		body = append(body, 0)                       // attributes
+		body = binary.AppendVarint(body, 0)          // timestamp delta
+		body = binary.AppendVarint(body, int64(i))   // offset delta
+		body = appendVarBytes(body, rec.Key)         // key
+		body = appendVarBytes(body, rec.Value)       // value
+		body = binary.AppendVarint(body, 0)          // headers
+		w = binary.AppendVarint(w, int64(len(body))) // record length
+		w = append(w, body...)
+	}
+	binary.BigEndian.PutUint32(w[lenAt:], uint32(len(w)-lenAt-4))
+	binary.BigEndian.PutUint32(w[crcAt:], crc32.Checksum(w[crcAt+4:], castagnoli))
+	return w
+}
+
+// appendVarBytes appends v with its zigzag varint length, -1 for nil.
+func appendVarBytes(b, v []byte) []byte {
+	if v == nil {
+		return binary.AppendVarint(b, -1)
+	}
+	b = binary.AppendVarint(b, int64(len(v)))
+	return append(b, v...)
+}
+
+// nextSequence returns the sequence after n records from seq, wrapping to 0
+// past MaxInt32 as brokers do.
+func nextSequence(seq int32, n int) int32 {
+	next := int64(seq) + int64(n)
+	if next > math.MaxInt32 {
+		next -= math.MaxInt32 + 1
+	}
+	return int32(next)
+}
+
+// murmur2 is the hash the Java client's default partitioner applies to
+// record keys, so that walship and Java producers agree on partitions.
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	n := len(data)
+	h := seed ^ uint32(n)
+	for i := 0; i+4 <= n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[n&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaPartition picks key's partition of n like the Java client.
+func kafkaPartition(key []byte, n int) int32 {
+	return int32(int(murmur2(key)&0x7fffffff) % n)
+}
@@ -0,0 +1,305 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Server-side encryption modes of ObjectStoreOptions.ServerSideEncryption.
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+// ObjectStoreOptions configures an ObjectStoreSender.
+type ObjectStoreOptions struct {
+	// Endpoint is the storage service's base URL, such as
+	// https://s3.eu-west-1.amazonaws.com, https://storage.googleapis.com
+	// (with HMAC keys) or a MinIO server. Buckets are addressed in the path.
+	Endpoint string
+	Bucket   string
+	// Region signs requests (default us-east-1; GCS accepts "auto").
+	Region string
+	// Prefix is prepended to every object key.
+	Prefix string
+	// AccessKeyID and SecretAccessKey sign requests with AWS Signature
+	// Version 4; SessionToken is set for temporary credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// ServerSideEncryption, if set, asks the service to encrypt objects at
+	// rest: SSEAES256 or SSEKMS with the key KMSKeyID (the bucket's default
+	// KMS key if empty).
+	ServerSideEncryption string
+	KMSKeyID             string
+	// MaxAttempts bounds how often one object is written before Send gives
+	// up (default 3), waiting RetryBackoff, doubling, in between.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// OnRetry, if set, is called before each retry with the attempt that
+	// failed.
+	OnRetry func(attempt int, delay time.Duration, err error)
+	// HTTPClient sends the requests; nil uses http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// ObjectStoreError is an object the storage service did not take.
+// StatusCode is the HTTP status of the last attempt, 0 if none arrived.
+type ObjectStoreError struct {
+	StatusCode int
+	Attempts   int
+	Err        error
+}
+
+func (e *ObjectStoreError) Error() string {
+	return fmt.Sprintf("object store put failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *ObjectStoreError) Unwrap() error { return e.Err }
+
+// Retriable reports whether writing the object again may succeed.
+func (e *ObjectStoreError) Retriable() bool { return objectStoreRetriable(e.StatusCode) }
+
+func objectStoreRetriable(code int) bool {
+	return code == 0 || code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// ObjectStoreSender writes each batch as one object in an S3-compatible
+// bucket. The object is the batch's gzip members back to back, itself a
+// valid gzip file of the batch's records, under
+// {prefix}/{chain}/{node}/{date}/{segment}-{first}-{last}.gz. The key
+// depends on the frames only, so a resent batch overwrites its own object.
+// It is safe for concurrent use.
+type ObjectStoreSender struct {
+	opts ObjectStoreOptions
+	base *url.URL
+}
+
+// NewObjectStoreSender returns a sender writing to opts.Bucket.
+func NewObjectStoreSender(opts ObjectStoreOptions) (*ObjectStoreSender, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("object store: no bucket")
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("object store endpoint %q must be an http(s) URL", opts.Endpoint)
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("object store: no credentials")
+	}
+	switch opts.ServerSideEncryption {
+	case "", SSEAES256:
+		if opts.KMSKeyID != "" {
+			return nil, errors.New("object store: a KMS key needs aws:kms encryption")
+		}
+	case SSEKMS:
+	default:
+		return nil, fmt.Errorf("object store: unknown server-side encryption %q", opts.ServerSideEncryption)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	return &ObjectStoreSender{opts: opts, base: u}, nil
+}
+
+// ObjectKey returns the key frames, listed in segment, are written under.
+// The date is that of the first frame's first record, in UTC.
+func (s *ObjectStoreSender) ObjectKey(chain, node, segment string, frames []wal.Frame) string {
+	first, last := frames[0].Meta, frames[len(frames)-1].Meta
+	at := time.Now()
+	if first.FirstTS != 0 {
+		at = time.Unix(0, first.FirstTS)
+	}
+	segment = strings.TrimSuffix(segment, ".wal.idx")
+	name := fmt.Sprintf("%s-%d-%d.gz", segment, first.Frame, last.Frame)
+	return path.Join(s.opts.Prefix, chain, node, at.UTC().Format("2006-01-02"), name)
+}
+
+// Send writes frames as one object and returns its key. The frames are
+// written whole or not at all; err is an *ObjectStoreError.
+func (s *ObjectStoreSender) Send(ctx context.Context, chain, node, segment string, frames []wal.Frame) (string, error) {
+	if len(frames) == 0 {
+		return "", nil
+	}
+	key := s.ObjectKey(chain, node, segment, frames)
+	var body bytes.Buffer
+	for _, f := range frames {
+		body.Write(f.Compressed)
+	}
+	meta := map[string]string{
+		"segment":     segment,
+		"first-file":  frames[0].Meta.File,
+		"first-frame": strconv.FormatUint(frames[0].Meta.Frame, 10),
+		"last-file":   frames[len(frames)-1].Meta.File,
+		"last-frame":  strconv.FormatUint(frames[len(frames)-1].Meta.Frame, 10),
+		"frames":      strconv.Itoa(len(frames)),
+	}
+
+	delay := s.opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		code, err := s.put(ctx, key, body.Bytes(), meta)
+		if err == nil {
+			return key, nil
+		}
+		if attempt >= s.opts.MaxAttempts || ctx.Err() != nil || !objectStoreRetriable(code) {
+			return "", &ObjectStoreError{StatusCode: code, Attempts: attempt, Err: err}
+		}
+		if s.opts.OnRetry != nil {
+			s.opts.OnRetry(attempt, delay, err)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+		delay *= 2
+	}
+}
+
+// put writes one object and returns the response status, 0 if none came.
+func (s *ObjectStoreSender) put(ctx context.Context, key string, body []byte, meta map[string]string) (int, error) {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.opts.Bucket + "/" + key
+	u.RawPath = s3Escape(u.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	for k, v := range meta {
+		req.Header.Set("X-Amz-Meta-Walship-"+k, v)
+	}
+	if s.opts.ServerSideEncryption != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.opts.ServerSideEncryption)
+		if s.opts.KMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.opts.KMSKeyID)
+		}
+	}
+	sum := sha256.Sum256(body)
+	signV4(req, hex.EncodeToString(sum[:]), s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.SessionToken, s.opts.Region, time.Now())
+
+	resp, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("put %s: %s: %s", key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp.StatusCode, nil
+}
+
+// signV4 signs req for S3 with AWS Signature Version 4, covering its host
+// and every header set on it.
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, sessionToken, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes every byte of p but the unreserved characters
+// of RFC 3986 and '/', as S3 expects in canonical requests.
+func s3Escape(p string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
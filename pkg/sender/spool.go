@@ -0,0 +1,328 @@
+package sender
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+const spoolExt = ".batch"
+
+// SpoolOptions bounds a Spool. Zero values mean unbounded.
+type SpoolOptions struct {
+	// MaxBytes caps the total size of spooled frames. The oldest batches are
+	// evicted to make room; the newest batch is always kept.
+	MaxBytes int64
+	// MaxAge evicts batches spooled longer ago than this.
+	MaxAge time.Duration
+	// OnEvict, if set, is called for each batch dropped undelivered. It is
+	// called with the spool locked and must not call the Spool.
+	OnEvict func(EvictedBatch)
+}
+
+// Reasons a Spool drops a batch undelivered.
+const (
+	EvictMaxAge     = "max_age"
+	EvictMaxBytes   = "max_bytes"
+	EvictUnreadable = "unreadable"
+)
+
+// EvictedBatch describes a batch a Spool dropped undelivered. Segment and
+// Frames are empty if the batch could not be read.
+type EvictedBatch struct {
+	Segment string
+	Frames  []wal.FrameMeta
+	Bytes   int64
+	Reason  string
+}
+
+// SendFunc delivers frames listed in segment and returns how many leading
+// frames were accepted. GRPCSender.Send is a SendFunc.
+type SendFunc func(ctx context.Context, segment string, frames []wal.Frame) (int, error)
+
+// Spool is a bounded on-disk FIFO of batches that could not be delivered.
+// Each batch is one file, written atomically, so the queue survives restarts
+// and a crash never leaves a half-written batch behind. It is safe for
+// concurrent use.
+type Spool struct {
+	dir  string
+	opts SpoolOptions
+
+	mu      sync.Mutex
+	entries []spoolEntry // oldest first
+	bytes   int64
+	seq     uint64
+	evicted uint64
+}
+
+type spoolEntry struct {
+	name    string
+	created time.Time
+	bytes   int64
+}
+
+// spoolHeader is the first line of a batch file; the frames' compressed
+// bytes follow it back to back.
+type spoolHeader struct {
+	Segment string       `json:"segment"`
+	Created time.Time    `json:"created"`
+	Frames  []spoolFrame `json:"frames"`
+}
+
+type spoolFrame struct {
+	Meta    wal.FrameMeta `json:"meta"`
+	Index   string        `json:"index,omitempty"`
+	Offset  int64         `json:"offset,omitempty"`
+	LineLen int           `json:"line_len,omitempty"`
+	Size    int           `json:"size"`
+}
+
+// OpenSpool opens the spool in dir, creating it if needed, and picks up any
+// batches left by a previous run.
+func OpenSpool(dir string, opts SpoolOptions) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("spool dir: %w", err)
+	}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	s := &Spool{dir: dir, opts: opts}
+	for _, e := range ents {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		h, err := readSpoolHeader(filepath.Join(dir, name))
+		if err != nil {
+			// Unreadable leftovers cannot be delivered; drop them.
+			_ = os.Remove(filepath.Join(dir, name))
+			s.evicted++
+			if opts.OnEvict != nil {
+				opts.OnEvict(EvictedBatch{Reason: EvictUnreadable})
+			}
+			continue
+		}
+		s.entries = append(s.entries, spoolEntry{name: name, created: h.Created, bytes: h.size()})
+		s.bytes += h.size()
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].name < s.entries[j].name })
+	return s, nil
+}
+
+// Put appends a batch of frames listed in segment, then evicts batches past
+// the spool's bounds.
+func (s *Spool) Put(segment string, frames []wal.Frame) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := spoolHeader{Segment: segment, Created: time.Now().UTC()}
+	for _, f := range frames {
+		h.Frames = append(h.Frames, spoolFrame{Meta: f.Meta, Index: f.Index, Offset: f.Offset, LineLen: f.LineLen, Size: len(f.Compressed)})
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolExt)
+	if err := writeSpoolFile(filepath.Join(s.dir, name), h, frames); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, spoolEntry{name: name, created: h.Created, bytes: h.size()})
+	s.bytes += h.size()
+	s.evict(time.Now())
+	return nil
+}
+
+// Drain sends spooled batches, oldest first, until the spool is empty, a
+// send fails or ctx is done. Delivered batches are removed; a partially
+// accepted batch keeps only its remaining frames. It returns the number of
+// batches fully delivered.
+func (s *Spool) Drain(ctx context.Context, send SendFunc) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(time.Now())
+	drained := 0
+	for len(s.entries) > 0 {
+		if err := ctx.Err(); err != nil {
+			return drained, err
+		}
+		e := s.entries[0]
+		path := filepath.Join(s.dir, e.name)
+		h, frames, err := readSpoolFile(path)
+		if err != nil {
+			s.discard(EvictUnreadable)
+			continue
+		}
+		n, err := send(ctx, h.Segment, frames)
+		if n >= len(frames) {
+			s.drop(0)
+			drained++
+		} else if n > 0 {
+			h.Frames = h.Frames[n:]
+			if werr := writeSpoolFile(path, h, frames[n:]); werr != nil {
+				return drained, werr
+			}
+			s.bytes += h.size() - e.bytes
+			s.entries[0].bytes = h.size()
+		}
+		if err != nil {
+			return drained, err
+		}
+		if n < len(frames) {
+			return drained, fmt.Errorf("spooled batch %s: %d of %d frames accepted", e.name, n, len(frames))
+		}
+	}
+	return drained, nil
+}
+
+// Len returns the number of spooled batches.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Bytes returns the size of the spooled frames.
+func (s *Spool) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Evicted returns how many batches have been dropped undelivered since the
+// spool was opened, because of its bounds or because they were unreadable.
+func (s *Spool) Evicted() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evicted
+}
+
+// evict drops batches older than MaxAge, then the oldest batches while the
+// spool exceeds MaxBytes.
+func (s *Spool) evict(now time.Time) {
+	for len(s.entries) > 0 && s.opts.MaxAge > 0 && now.Sub(s.entries[0].created) > s.opts.MaxAge {
+		s.discard(EvictMaxAge)
+	}
+	for len(s.entries) > 1 && s.opts.MaxBytes > 0 && s.bytes > s.opts.MaxBytes {
+		s.discard(EvictMaxBytes)
+	}
+}
+
+// discard drops the oldest batch undelivered, reporting it to OnEvict.
+func (s *Spool) discard(reason string) {
+	e := s.entries[0]
+	if s.opts.OnEvict != nil {
+		b := EvictedBatch{Bytes: e.bytes, Reason: reason}
+		if h, err := readSpoolHeader(filepath.Join(s.dir, e.name)); err == nil {
+			b.Segment = h.Segment
+			for _, f := range h.Frames {
+				b.Frames = append(b.Frames, f.Meta)
+			}
+		}
+		s.opts.OnEvict(b)
+	}
+	s.drop(0)
+	s.evicted++
+}
+
+func (s *Spool) drop(i int) {
+	e := s.entries[i]
+	_ = os.Remove(filepath.Join(s.dir, e.name))
+	s.bytes -= e.bytes
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+}
+
+func (h spoolHeader) size() int64 {
+	var n int64
+	for _, f := range h.Frames {
+		n += int64(f.Size)
+	}
+	return n
+}
+
+func writeSpoolFile(path string, h spoolHeader, frames []wal.Frame) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(h); err != nil {
+		return fmt.Errorf("encode spool header: %w", err)
+	}
+	for _, f := range frames {
+		buf.Write(f.Compressed)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write spool batch: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("write spool batch: %w", err)
+	}
+	return nil
+}
+
+func readSpoolHeader(path string) (spoolHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return spoolHeader{}, err
+	}
+	defer f.Close()
+	return decodeSpoolHeader(bufio.NewReader(f))
+}
+
+func decodeSpoolHeader(r *bufio.Reader) (spoolHeader, error) {
+	var h spoolHeader
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return h, fmt.Errorf("read spool header: %w", err)
+	}
+	if err := json.Unmarshal(line, &h); err != nil {
+		return h, fmt.Errorf("decode spool header: %w", err)
+	}
+	return h, nil
+}
+
+func readSpoolFile(path string) (spoolHeader, []wal.Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return spoolHeader{}, nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	h, err := decodeSpoolHeader(r)
+	if err != nil {
+		return h, nil, err
+	}
+	frames := make([]wal.Frame, len(h.Frames))
+	for i, sf := range h.Frames {
+		b := make([]byte, sf.Size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return h, nil, fmt.Errorf("read spooled frame %d: %w", i, err)
+		}
+		frames[i] = wal.Frame{Meta: sf.Meta, Compressed: b, Index: sf.Index, Offset: sf.Offset, LineLen: sf.LineLen}
+	}
+	if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+		return h, nil, fmt.Errorf("trailing data in spool batch")
+	}
+	return h, frames, nil
+}
@@ -0,0 +1,177 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+func spoolFrames(first, n int) []wal.Frame {
+	out := make([]wal.Frame, n)
+	for i := range out {
+		b := []byte(fmt.Sprintf("frame-%03d", first+i))
+		out[i] = wal.Frame{Meta: wal.FrameMeta{File: "seg.wal.gz", Frame: uint64(first + i), Len: uint64(len(b))}, Compressed: b, LineLen: 40}
+	}
+	return out
+}
+
+// recorder is a SendFunc accepting up to accept frames per call (all when
+// negative), failing with err afterwards.
+type recorder struct {
+	accept int
+	err    error
+	got    []uint64
+	segs   []string
+}
+
+func (r *recorder) send(_ context.Context, segment string, frames []wal.Frame) (int, error) {
+	n := len(frames)
+	if r.accept >= 0 && r.accept < n {
+		n = r.accept
+	}
+	for _, f := range frames[:n] {
+		r.got = append(r.got, f.Meta.Frame)
+	}
+	r.segs = append(r.segs, segment)
+	if n < len(frames) {
+		return n, r.err
+	}
+	return n, nil
+}
+
+func TestSpool_DrainInOrderAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("a.wal.idx", spoolFrames(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("b.wal.idx", spoolFrames(3, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenSpool(dir, SpoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 2 || s.Bytes() != 5*9 {
+		t.Fatalf("reopened spool: len=%d bytes=%d", s.Len(), s.Bytes())
+	}
+	if err := s.Put("c.wal.idx", spoolFrames(5, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{accept: -1}
+	n, err := s.Drain(context.Background(), rec.send)
+	if err != nil || n != 3 {
+		t.Fatalf("Drain = %d, %v", n, err)
+	}
+	if fmt.Sprint(rec.got) != "[0 1 2 3 4 5]" || fmt.Sprint(rec.segs) != "[a.wal.idx b.wal.idx c.wal.idx]" {
+		t.Fatalf("sent frames %v from %v", rec.got, rec.segs)
+	}
+	if s.Len() != 0 || s.Bytes() != 0 {
+		t.Fatalf("drained spool: len=%d bytes=%d", s.Len(), s.Bytes())
+	}
+}
+
+func TestSpool_DrainFailures(t *testing.T) {
+	errDown := errors.New("down")
+	tests := []struct {
+		name      string
+		accept    int
+		wantLen   int
+		wantBytes int64
+		wantGot   string
+	}{
+		{name: "nothing accepted", accept: 0, wantLen: 2, wantBytes: 5 * 9, wantGot: "[]"},
+		{name: "prefix accepted", accept: 2, wantLen: 2, wantBytes: 3 * 9, wantGot: "[0 1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := OpenSpool(t.TempDir(), SpoolOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = s.Put("a", spoolFrames(0, 3))
+			_ = s.Put("b", spoolFrames(3, 2))
+
+			rec := &recorder{accept: tt.accept, err: errDown}
+			n, err := s.Drain(context.Background(), rec.send)
+			if n != 0 || !errors.Is(err, errDown) {
+				t.Fatalf("Drain = %d, %v", n, err)
+			}
+			if fmt.Sprint(rec.got) != tt.wantGot || s.Len() != tt.wantLen || s.Bytes() != tt.wantBytes {
+				t.Fatalf("got %v len=%d bytes=%d", rec.got, s.Len(), s.Bytes())
+			}
+
+			// The remainder is delivered once the service recovers.
+			rec = &recorder{accept: -1}
+			if n, err := s.Drain(context.Background(), rec.send); n != 2 || err != nil {
+				t.Fatalf("second Drain = %d, %v", n, err)
+			}
+			if rec.got[0] != uint64(tt.accept) {
+				t.Fatalf("resumed at frame %d, want %d", rec.got[0], tt.accept)
+			}
+		})
+	}
+}
+
+func TestSpool_Eviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    SpoolOptions
+		age     time.Duration
+		wantLen int
+		wantFst uint64
+		wantEv  string // evicted batches as segment/reason/frames
+	}{
+		{name: "unbounded", wantLen: 3, wantFst: 0, wantEv: "[]"},
+		{name: "max bytes", opts: SpoolOptions{MaxBytes: 4 * 9}, wantLen: 2, wantFst: 2, wantEv: "[a/max_bytes/[0 1]]"},
+		{name: "max bytes keeps newest", opts: SpoolOptions{MaxBytes: 1}, wantLen: 1, wantFst: 4,
+			wantEv: "[a/max_bytes/[0 1] b/max_bytes/[2 3]]"},
+		{name: "max age", opts: SpoolOptions{MaxAge: time.Minute}, age: time.Hour, wantLen: 1, wantFst: 4,
+			wantEv: "[a/max_age/[0 1] b/max_age/[2 3]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evicted := []string{}
+			tt.opts.OnEvict = func(b EvictedBatch) {
+				var frames []uint64
+				for _, m := range b.Frames {
+					frames = append(frames, m.Frame)
+				}
+				evicted = append(evicted, fmt.Sprintf("%s/%s/%v", b.Segment, b.Reason, frames))
+			}
+			s, err := OpenSpool(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = s.Put("a", spoolFrames(0, 2))
+			_ = s.Put("b", spoolFrames(2, 2))
+			for i := range s.entries {
+				s.entries[i].created = s.entries[i].created.Add(-tt.age)
+			}
+			_ = s.Put("c", spoolFrames(4, 1))
+
+			if s.Len() != tt.wantLen || s.Evicted() != uint64(3-tt.wantLen) {
+				t.Fatalf("len=%d evicted=%d, want len %d", s.Len(), s.Evicted(), tt.wantLen)
+			}
+			if got := fmt.Sprint(evicted); got != tt.wantEv {
+				t.Errorf("OnEvict got %s, want %s", got, tt.wantEv)
+			}
+			rec := &recorder{accept: -1}
+			if _, err := s.Drain(context.Background(), rec.send); err != nil {
+				t.Fatal(err)
+			}
+			if rec.got[0] != tt.wantFst {
+				t.Fatalf("first drained frame %d, want %d", rec.got[0], tt.wantFst)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package sender
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// frameMessage and ackMessage are the walship.v1 Frame and Ack messages of
+// ingest.proto, encoded with protowire instead of generated code.
+type frameMessage struct {
+	Seq     uint64
+	Segment string
+	Meta    wal.FrameMeta
+	Data    []byte
+}
+
+type ackMessage struct {
+	ThroughSeq uint64
+	Error      string
+}
+
+type wireMessage interface {
+	marshal() []byte
+	unmarshal([]byte) error
+}
+
+// codec lets gRPC carry wireMessages. It registers as "proto", so servers
+// built from ingest.proto with generated code interoperate.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(wireMessage)
+	if !ok {
+		return nil, fmt.Errorf("sender: cannot marshal %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(b []byte, v any) error {
+	m, ok := v.(wireMessage)
+	if !ok {
+		return fmt.Errorf("sender: cannot unmarshal into %T", v)
+	}
+	return m.unmarshal(b)
+}
+
+func (codec) Name() string { return "proto" }
+
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func (m *frameMessage) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.Seq)
+	b = appendBytes(b, 2, []byte(m.Segment))
+	b = appendBytes(b, 3, []byte(m.Meta.File))
+	b = appendUint(b, 4, m.Meta.Frame)
+	b = appendUint(b, 5, m.Meta.Off)
+	b = appendUint(b, 6, m.Meta.Len)
+	b = appendUint(b, 7, uint64(m.Meta.Recs))
+	b = appendUint(b, 8, uint64(m.Meta.FirstTS))
+	b = appendUint(b, 9, uint64(m.Meta.LastTS))
+	b = appendUint(b, 10, uint64(m.Meta.CRC32))
+	return appendBytes(b, 11, m.Data)
+}
+
+func (m *frameMessage) unmarshal(b []byte) error {
+	*m = frameMessage{}
+	return walkFields(b, func(num protowire.Number, v uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.Seq = v
+		case 2:
+			m.Segment = string(bs)
+		case 3:
+			m.Meta.File = string(bs)
+		case 4:
+			m.Meta.Frame = v
+		case 5:
+			m.Meta.Off = v
+		case 6:
+			m.Meta.Len = v
+		case 7:
+			m.Meta.Recs = uint32(v)
+		case 8:
+			m.Meta.FirstTS = int64(v)
+		case 9:
+			m.Meta.LastTS = int64(v)
+		case 10:
+			m.Meta.CRC32 = uint32(v)
+		case 11:
+			m.Data = append([]byte(nil), bs...)
+		}
+	})
+}
+
+func (m *ackMessage) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, m.ThroughSeq)
+	return appendBytes(b, 2, []byte(m.Error))
+}
+
+func (m *ackMessage) unmarshal(b []byte) error {
+	*m = ackMessage{}
+	return walkFields(b, func(num protowire.Number, v uint64, bs []byte) {
+		switch num {
+		case 1:
+			m.ThroughSeq = v
+		case 2:
+			m.Error = string(bs)
+		}
+	})
+}
+
+// walkFields calls fn for each varint or length-delimited field of b and
+// skips fields of other types.
+func walkFields(b []byte, fn func(num protowire.Number, v uint64, bs []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, 0, v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ScopeName is the instrumentation scope of exported spans.
+const ScopeName = "github.com/bft-labs/walship"
+
+// EncodeExportRequest encodes spans as an OTLP ExportTraceServiceRequest
+// (opentelemetry/proto/collector/trace/v1) with one resource and scope,
+// using protowire instead of generated code.
+func EncodeExportRequest(resource []Attr, spans []SpanData) []byte {
+	var res []byte
+	for _, a := range resource {
+		res = appendMessage(res, 1, appendKeyValue(nil, a))
+	}
+
+	var scope []byte
+	scope = appendMessage(scope, 1, appendString(nil, 1, ScopeName))
+	for _, s := range spans {
+		scope = appendMessage(scope, 2, appendSpan(nil, s))
+	}
+
+	var rs []byte
+	rs = appendMessage(rs, 1, res)
+	rs = appendMessage(rs, 2, scope)
+	return appendMessage(nil, 1, rs)
+}
+
+// appendSpan encodes an opentelemetry.proto.trace.v1.Span.
+func appendSpan(b []byte, s SpanData) []byte {
+	b = appendBytes(b, 1, s.SC.TraceID[:])
+	b = appendBytes(b, 2, s.SC.SpanID[:])
+	if s.Parent.IsValid() {
+		b = appendBytes(b, 4, s.Parent[:])
+	}
+	b = appendString(b, 5, s.Name)
+	b = appendVarint(b, 6, uint64(s.Kind))
+	b = appendFixed64(b, 7, uint64(s.Start.UnixNano()))
+	b = appendFixed64(b, 8, uint64(s.End.UnixNano()))
+	for _, a := range s.Attrs {
+		b = appendMessage(b, 9, appendKeyValue(nil, a))
+	}
+	// Status: message 2, code 3 (1 ok, 2 error).
+	var st []byte
+	if s.Err != "" {
+		st = appendString(st, 2, s.Err)
+		st = appendVarint(st, 3, 2)
+	} else {
+		st = appendVarint(st, 3, 1)
+	}
+	b = appendMessage(b, 15, st)
+	var flags uint32 // W3C trace flags
+	if s.SC.Sampled {
+		flags = 1
+	}
+	return appendFixed32(b, 16, flags)
+}
+
+// appendKeyValue encodes an opentelemetry.proto.common.v1.KeyValue.
+func appendKeyValue(b []byte, a Attr) []byte {
+	var v []byte
+	switch x := a.Value.(type) {
+	case string:
+		v = protowire.AppendTag(nil, 1, protowire.BytesType)
+		v = protowire.AppendString(v, x)
+	case bool:
+		v = protowire.AppendTag(nil, 2, protowire.VarintType)
+		v = protowire.AppendVarint(v, protowire.EncodeBool(x))
+	case int64:
+		v = protowire.AppendTag(nil, 3, protowire.VarintType)
+		v = protowire.AppendVarint(v, uint64(x))
+	case int:
+		v = protowire.AppendTag(nil, 3, protowire.VarintType)
+		v = protowire.AppendVarint(v, uint64(int64(x)))
+	case float64:
+		v = protowire.AppendTag(nil, 4, protowire.Fixed64Type)
+		v = protowire.AppendFixed64(v, math.Float64bits(x))
+	}
+	b = appendString(b, 1, a.Key)
+	return appendMessage(b, 2, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return appendMessage(b, num, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	return appendBytes(b, num, []byte(v))
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendFixed32(b []byte, num protowire.Number, v uint32) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, v)
+}
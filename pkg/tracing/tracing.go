@@ -0,0 +1,308 @@
+// Package tracing records spans and exports them to an OpenTelemetry
+// collector over OTLP (HTTP or gRPC), with W3C trace context for
+// propagation. It covers what the agent needs without the OpenTelemetry SDK:
+// spans are buffered in memory and exported in batches, and a nil *Tracer or
+// *Span is a valid no-op so instrumentation costs nothing when disabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID and SpanID identify spans as in the W3C trace context.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats sc as a W3C traceparent header value, or "" if sc is
+// not valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(v string) (SpanContext, error) {
+	// Later versions may append fields; version 00 has exactly four.
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+		len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, fmt.Errorf("tracing: malformed traceparent %q", v)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("tracing: traceparent %q has a zero ID", v)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Attr is a span attribute with a string, int or bool value.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, v string) Attr    { return Attr{key, v} }
+func Int(key string, v int64) Attr { return Attr{key, v} }
+func Bool(key string, v bool) Attr { return Attr{key, v} }
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindClient   Kind = 3
+)
+
+// SpanData is a finished span as exported.
+type SpanData struct {
+	Name   string
+	Kind   Kind
+	SC     SpanContext
+	Parent SpanID
+	Start  time.Time
+	End    time.Time
+	Attrs  []Attr
+	Err    string // set if the span failed
+}
+
+// Span is an unfinished span. Its methods are safe on a nil *Span and must
+// not be called concurrently.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span's context, for child spans and propagation.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SC
+}
+
+// StartTime returns when the span started.
+func (s *Span) StartTime() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.data.Start
+}
+
+// SetAttrs adds attributes to the span.
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.data.Attrs = append(s.data.Attrs, attrs...)
+}
+
+// SetError marks the span failed with err, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.data.Err = err.Error()
+}
+
+// End finishes the span now and queues it for export if it is sampled.
+func (s *Span) End() { s.EndAt(time.Now()) }
+
+// EndAt is like End with an explicit end time. Only the first call counts.
+func (s *Span) EndAt(t time.Time) {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.data.End = t
+	if s.data.SC.Sampled {
+		s.tracer.enqueue(s.data)
+	}
+}
+
+// Exporter delivers encoded OTLP trace export requests.
+type Exporter interface {
+	Export(ctx context.Context, req []byte) error
+	Close() error
+}
+
+// Options configures a Tracer.
+type Options struct {
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+	// Resource are further resource attributes, e.g. host.name.
+	Resource []Attr
+	// SampleRatio is the fraction of new traces that are recorded; 0
+	// records none and 1 all. Child spans follow their parent.
+	SampleRatio float64
+	// MaxQueue bounds the spans buffered between exports; further spans
+	// are dropped. Defaults to 2048.
+	MaxQueue int
+}
+
+// Tracer starts spans and exports the sampled ones in batches.
+type Tracer struct {
+	exp       Exporter
+	opts      Options
+	threshold uint64 // traces whose ID's low 8 bytes are below it are sampled
+
+	mu      sync.Mutex
+	queue   []SpanData
+	dropped uint64
+}
+
+// NewTracer returns a Tracer exporting through exp.
+func NewTracer(exp Exporter, opts Options) *Tracer {
+	if opts.MaxQueue <= 0 {
+		opts.MaxQueue = 2048
+	}
+	t := &Tracer{exp: exp, opts: opts}
+	switch {
+	case opts.SampleRatio >= 1:
+		t.threshold = ^uint64(0)
+	case opts.SampleRatio > 0:
+		t.threshold = uint64(opts.SampleRatio * (1 << 63) * 2)
+	}
+	return t
+}
+
+// Start starts a span named name. With a valid parent the span joins its
+// trace and sampling decision; otherwise it starts a new trace. A zero
+// start means now.
+func (t *Tracer) Start(name string, kind Kind, parent SpanContext, start time.Time, attrs ...Attr) *Span {
+	if t == nil {
+		return nil
+	}
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: start, Attrs: attrs}}
+	if parent.IsValid() {
+		s.data.SC = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+		s.data.Parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.data.SC.TraceID[:])
+		low := binary.BigEndian.Uint64(s.data.SC.TraceID[8:])
+		s.data.SC.Sampled = t.threshold == ^uint64(0) || low < t.threshold
+	}
+	_, _ = rand.Read(s.data.SC.SpanID[:])
+	return s
+}
+
+func (t *Tracer) enqueue(d SpanData) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= t.opts.MaxQueue {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, d)
+}
+
+// Dropped returns how many spans were dropped, because the queue was full
+// or their export failed.
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// Flush exports the queued spans. Spans that fail to export are dropped
+// rather than retried, so a missing collector cannot grow the queue.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	res := append([]Attr{String("service.name", t.opts.ServiceName)}, t.opts.Resource...)
+	if err := t.exp.Export(ctx, EncodeExportRequest(res, spans)); err != nil {
+		t.mu.Lock()
+		t.dropped += uint64(len(spans))
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes every interval until ctx is done. Export errors go to
+// onError, if set.
+func (t *Tracer) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := t.Flush(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Shutdown flushes the remaining spans and closes the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	err := t.Flush(ctx)
+	if cerr := t.exp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
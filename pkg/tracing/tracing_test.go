@@ -0,0 +1,253 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestTraceparent_RoundTrip(t *testing.T) {
+	sc := SpanContext{Sampled: true}
+	sc.TraceID[0], sc.TraceID[15] = 0x4b, 0xf9
+	sc.SpanID[7] = 0x01
+	v := sc.Traceparent()
+	if want := "00-4b0000000000000000000000000000f9-0000000000000001-01"; v != want {
+		t.Fatalf("Traceparent = %q, want %q", v, want)
+	}
+	got, err := ParseTraceparent(v)
+	if err != nil || got != sc {
+		t.Errorf("ParseTraceparent = %+v, %v; want %+v", got, err, sc)
+	}
+	if (SpanContext{}).Traceparent() != "" {
+		t.Error("invalid context should format as empty")
+	}
+}
+
+func TestParseTraceparent_Invalid(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceparent(v); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded", v)
+		}
+	}
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("future version with extra fields: %v", err)
+	}
+}
+
+type memExporter struct {
+	mu   sync.Mutex
+	reqs [][]byte
+	err  error
+}
+
+func (e *memExporter) Export(_ context.Context, req []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reqs = append(e.reqs, req)
+	return e.err
+}
+
+func (e *memExporter) Close() error { return nil }
+
+func TestTracer_SamplingAndParenting(t *testing.T) {
+	for _, tt := range []struct {
+		ratio float64
+		want  bool
+	}{{0, false}, {1, true}} {
+		exp := &memExporter{}
+		tr := NewTracer(exp, Options{ServiceName: "walship", SampleRatio: tt.ratio})
+		root := tr.Start("batch", KindInternal, SpanContext{}, time.Time{})
+		child := tr.Start("send", KindClient, root.Context(), time.Time{})
+		if child.Context().TraceID != root.Context().TraceID || child.data.Parent != root.Context().SpanID {
+			t.Errorf("ratio %v: child not parented to root", tt.ratio)
+		}
+		if root.Context().Sampled != tt.want || child.Context().Sampled != tt.want {
+			t.Errorf("ratio %v: sampled = %v/%v, want %v", tt.ratio, root.Context().Sampled, child.Context().Sampled, tt.want)
+		}
+		child.End()
+		root.End()
+		root.End() // only the first End counts
+		if err := tr.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, req := range exp.reqs {
+			names = append(names, spanNames(t, req)...)
+		}
+		if tt.want && (len(names) != 2 || names[0] != "send" || names[1] != "batch") {
+			t.Errorf("ratio %v: exported %v, want [send batch]", tt.ratio, names)
+		}
+		if !tt.want && len(names) != 0 {
+			t.Errorf("ratio %v: exported %v, want none", tt.ratio, names)
+		}
+	}
+
+	var nilTracer *Tracer
+	s := nilTracer.Start("x", KindInternal, SpanContext{}, time.Time{})
+	s.SetAttrs(Int("n", 1))
+	s.SetError(errors.New("boom"))
+	s.End()
+	if s.Context().IsValid() || nilTracer.Flush(context.Background()) != nil {
+		t.Error("nil tracer should be a no-op")
+	}
+}
+
+func TestTracer_DropsWhenFullOrExportFails(t *testing.T) {
+	exp := &memExporter{err: errors.New("collector down")}
+	tr := NewTracer(exp, Options{SampleRatio: 1, MaxQueue: 2})
+	for i := 0; i < 3; i++ {
+		tr.Start("s", KindInternal, SpanContext{}, time.Time{}).End()
+	}
+	if got := tr.Dropped(); got != 1 {
+		t.Errorf("Dropped = %d after overflow, want 1", got)
+	}
+	if err := tr.Flush(context.Background()); err == nil {
+		t.Fatal("Flush should report the export error")
+	}
+	if got := tr.Dropped(); got != 3 {
+		t.Errorf("Dropped = %d after failed export, want 3", got)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var gotPath, gotType, gotAuth string
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotType, gotAuth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	exp, err := NewHTTPExporter(ts.URL, nil, map[string]string{"Authorization": "Bearer k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracer(exp, Options{ServiceName: "walship", SampleRatio: 1})
+	s := tr.Start("walship.send", KindClient, SpanContext{}, time.Time{}, String("segment", "seg-000001.wal.idx"))
+	s.SetError(errors.New("boom"))
+	s.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/v1/traces" || gotType != "application/x-protobuf" || gotAuth != "Bearer k" {
+		t.Errorf("request = %s %s %s", gotPath, gotType, gotAuth)
+	}
+	if names := spanNames(t, body); len(names) != 1 || names[0] != "walship.send" {
+		t.Errorf("spans = %v", names)
+	}
+
+	if _, err := NewHTTPExporter("collector:4318", nil, nil); err == nil {
+		t.Error("endpoint without scheme accepted")
+	}
+}
+
+func TestGRPCExporter(t *testing.T) {
+	var mu sync.Mutex
+	var reqs [][]byte
+	var auth []string
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "opentelemetry.proto.collector.trace.v1.TraceService",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Export",
+			Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+				var in rawMessage
+				if err := dec(&in); err != nil {
+					return nil, err
+				}
+				md, _ := metadata.FromIncomingContext(ctx)
+				mu.Lock()
+				reqs = append(reqs, in)
+				auth = append(auth, md.Get("authorization")...)
+				mu.Unlock()
+				return &rawMessage{}, nil
+			},
+		}},
+	}, struct{}{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	exp, err := NewGRPCExporter("passthrough:///bufnet", true, map[string]string{"authorization": "Bearer k"},
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracer(exp, Options{ServiceName: "walship", SampleRatio: 1})
+	tr.Start("walship.batch", KindInternal, SpanContext{}, time.Time{}).End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 || len(auth) != 1 || auth[0] != "Bearer k" {
+		t.Fatalf("requests = %d, auth = %v", len(reqs), auth)
+	}
+	if names := spanNames(t, reqs[0]); len(names) != 1 || names[0] != "walship.batch" {
+		t.Errorf("spans = %v", names)
+	}
+}
+
+// spanNames decodes the span names of an ExportTraceServiceRequest.
+func spanNames(t *testing.T, req []byte) []string {
+	t.Helper()
+	var names []string
+	for _, rs := range fields(t, req, 1) {
+		for _, ss := range fields(t, rs, 2) {
+			for _, sp := range fields(t, ss, 2) {
+				for _, n := range fields(t, sp, 5) {
+					names = append(names, string(n))
+				}
+			}
+		}
+	}
+	return names
+}
+
+// fields returns the length-delimited fields numbered num in b.
+func fields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+		if typ == protowire.BytesType && n == num {
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				t.Fatal(protowire.ParseError(l))
+			}
+			out = append(out, v)
+			b = b[l:]
+			continue
+		}
+		l = protowire.ConsumeFieldValue(n, typ, b)
+		if l < 0 {
+			t.Fatal(protowire.ParseError(l))
+		}
+		b = b[l:]
+	}
+	return out
+}
@@ -0,0 +1,331 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// csWALHead is the name of the file CometBFT appends to.
+const csWALHead = "wal"
+
+// maxCSWALRecord is CometBFT's bound on the size of a record's data.
+const maxCSWALRecord = 1<<20 + 24
+
+// ErrCSWALCorrupt is returned, wrapped, for a cs.wal record that fails its
+// checksum or cannot be decoded.
+var ErrCSWALCorrupt = errors.New("corrupt cs.wal record")
+
+// CSWALSkipError is returned by Next for a record whose length is corrupt
+// once a newer file exists: the rest of File from Offset, Bytes long, was
+// skipped and Next continues at the start of Next. It wraps
+// ErrCSWALCorrupt.
+type CSWALSkipError struct {
+	File   string
+	Offset int64
+	Bytes  int64
+	Next   string
+}
+
+func (e *CSWALSkipError) Error() string {
+	return fmt.Sprintf("%v: %s at %d: corrupt length, skipped %d bytes to %s", ErrCSWALCorrupt, e.File, e.Offset, e.Bytes, e.Next)
+}
+
+func (e *CSWALSkipError) Unwrap() error { return ErrCSWALCorrupt }
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// CSWALRecord is one record of a CometBFT consensus WAL.
+type CSWALRecord struct {
+	Time time.Time
+	// Msg is the protobuf tendermint.consensus.WALMessage; see
+	// consensus.DecodeWALMessage.
+	Msg []byte
+	// File is the path of the file the record was read from, and Offset
+	// the byte offset of the record there. Offset+Len is where the next
+	// record starts.
+	File   string
+	Offset int64
+	Len    int
+}
+
+// CSWALReader iterates the records of a CometBFT consensus WAL in order,
+// following the head file across rotations. When no complete record is
+// available yet, Next returns io.EOF; calling Next again later continues
+// where it stopped, so a CSWALReader can tail a running node.
+type CSWALReader struct {
+	dir    string
+	path   string
+	offset int64
+
+	f  *os.File
+	br *bufio.Reader
+}
+
+// OpenCSWAL returns a CSWALReader positioned at the first record of the
+// oldest file in dir, a node's data/cs.wal directory.
+func OpenCSWAL(dir string) (*CSWALReader, error) {
+	files, err := CSWALFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no cs.wal files in %s", dir)
+	}
+	return OpenCSWALAt(files[0], 0)
+}
+
+// OpenCSWALAt returns a CSWALReader positioned at offset in path, typically
+// a value previously obtained from Position.
+func OpenCSWALAt(path string, offset int64) (*CSWALReader, error) {
+	r := &CSWALReader{dir: filepath.Dir(path)}
+	if err := r.open(path, offset); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// CSWALFiles lists the files of the consensus WAL in dir, oldest first.
+func CSWALFiles(dir string) ([]string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var rotated []int
+	head := false
+	for _, e := range ents {
+		name := e.Name()
+		if name == csWALHead {
+			head = true
+			continue
+		}
+		if n, ok := strings.CutPrefix(name, csWALHead+"."); ok {
+			if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+				rotated = append(rotated, i)
+			}
+		}
+	}
+	sort.Ints(rotated)
+	var files []string
+	for _, i := range rotated {
+		files = append(files, filepath.Join(dir, fmt.Sprintf("%s.%03d", csWALHead, i)))
+	}
+	if head {
+		files = append(files, filepath.Join(dir, csWALHead))
+	}
+	return files, nil
+}
+
+func (r *CSWALReader) open(path string, offset int64) error {
+	f, err := OpenFile(path)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.f, r.path, r.offset = f, path, offset
+	r.br = bufio.NewReaderSize(f, 64*1024)
+	return nil
+}
+
+// Position returns the file and offset of the next record Next will read.
+// A head file rotated since it was opened is named by its rotated name.
+func (r *CSWALReader) Position() (path string, offset int64) {
+	if files, i := r.locate(); i >= 0 {
+		r.path = files[i]
+	}
+	return r.path, r.offset
+}
+
+// locate lists the WAL's files and finds the open file among them, by
+// identity rather than name, as rotation renames the head. It returns -1
+// if the file is gone.
+func (r *CSWALReader) locate() ([]string, int) {
+	files, err := CSWALFiles(r.dir)
+	if err != nil {
+		return nil, -1
+	}
+	cur, err := r.f.Stat()
+	if err != nil {
+		return nil, -1
+	}
+	for i, path := range files {
+		if fi, err := os.Stat(path); err == nil && os.SameFile(cur, fi) {
+			return files, i
+		}
+	}
+	return nil, -1
+}
+
+// Next returns the next record. It returns io.EOF when the WAL has no
+// further complete record yet. A record that fails its checksum is skipped
+// and reported with an error wrapping ErrCSWALCorrupt. After a corrupt
+// length the next record cannot be found, so Next keeps failing there
+// until a newer file exists, and then skips to it with a *CSWALSkipError.
+func (r *CSWALReader) Next() (CSWALRecord, error) {
+	for {
+		rec, err := r.next()
+		if !errors.Is(err, io.EOF) {
+			return rec, err
+		}
+		// Move on once a newer file exists; the open one is then complete.
+		files, i := r.locate()
+		if i < 0 || i == len(files)-1 {
+			return CSWALRecord{}, io.EOF
+		}
+		if err := r.open(files[i+1], 0); err != nil {
+			return CSWALRecord{}, err
+		}
+	}
+}
+
+func (r *CSWALReader) next() (CSWALRecord, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		return CSWALRecord{}, r.partial(err)
+	}
+	sum, n := binary.BigEndian.Uint32(hdr[:4]), binary.BigEndian.Uint32(hdr[4:])
+	if n > maxCSWALRecord {
+		if files, i := r.locate(); i >= 0 && i < len(files)-1 {
+			return CSWALRecord{}, r.skipFile(files[i], files[i+1])
+		}
+		r.rewind()
+		return CSWALRecord{}, fmt.Errorf("%w: %s at %d: length %d exceeds %d", ErrCSWALCorrupt, r.path, r.offset, n, maxCSWALRecord)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.br, data); err != nil {
+		return CSWALRecord{}, r.partial(err)
+	}
+	rec := CSWALRecord{File: r.path, Offset: r.offset, Len: len(hdr) + len(data)}
+	r.offset += int64(rec.Len)
+	if crc32.Checksum(data, crc32c) != sum {
+		return CSWALRecord{}, fmt.Errorf("%w: %s at %d: checksum mismatch", ErrCSWALCorrupt, rec.File, rec.Offset)
+	}
+	t, msg, err := decodeTimedWALMessage(data)
+	if err != nil {
+		return CSWALRecord{}, fmt.Errorf("%w: %s at %d: %v", ErrCSWALCorrupt, rec.File, rec.Offset, err)
+	}
+	rec.Time, rec.Msg = t, msg
+	return rec, nil
+}
+
+// skipFile moves on from the rest of the open file, named path, to next.
+func (r *CSWALReader) skipFile(path, next string) error {
+	skip := &CSWALSkipError{File: path, Offset: r.offset, Next: next}
+	if fi, err := r.f.Stat(); err == nil {
+		skip.Bytes = fi.Size() - r.offset
+	}
+	if err := r.open(next, 0); err != nil {
+		return err
+	}
+	return skip
+}
+
+// partial turns a short read into io.EOF, leaving the reader at the start of
+// the record so that it is read again once complete.
+func (r *CSWALReader) partial(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		r.rewind()
+		return io.EOF
+	}
+	return err
+}
+
+func (r *CSWALReader) rewind() {
+	if _, err := r.f.Seek(r.offset, io.SeekStart); err == nil {
+		r.br.Reset(r.f)
+	}
+}
+
+// Close releases the open file.
+func (r *CSWALReader) Close() error {
+	return r.f.Close()
+}
+
+// decodeTimedWALMessage splits a TimedWALMessage into its time (field 1, a
+// google.protobuf.Timestamp) and its WALMessage (field 2).
+func decodeTimedWALMessage(b []byte) (time.Time, []byte, error) {
+	var t time.Time
+	var msg []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return t, nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return t, nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return t, nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			var err error
+			if t, err = decodeTimestamp(v); err != nil {
+				return t, nil, err
+			}
+		} else {
+			msg = v
+		}
+	}
+	if msg == nil {
+		return t, nil, errors.New("no message")
+	}
+	return t, msg, nil
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp.
+func decodeTimestamp(b []byte) (time.Time, error) {
+	var secs, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType || (num != 1 && num != 2) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			secs = int64(v)
+		} else {
+			nanos = int64(int32(v))
+		}
+	}
+	return time.Unix(secs, nanos).UTC(), nil
+}
@@ -0,0 +1,153 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// csWALRecord encodes a record holding a TimedWALMessage with msg at t.
+func csWALRecord(t time.Time, msg string) []byte {
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(t.Unix()))
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(t.Nanosecond()))
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendBytes(data, ts)
+	data = protowire.AppendTag(data, 2, protowire.BytesType)
+	data = protowire.AppendBytes(data, []byte(msg))
+
+	rec := binary.BigEndian.AppendUint32(nil, crc32.Checksum(data, crc32c))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(data)))
+	return append(rec, data...)
+}
+
+func appendFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCSWAL(t *testing.T, r *CSWALReader) []string {
+	t.Helper()
+	var msgs []string
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return msgs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(rec.Msg))
+	}
+}
+
+func TestCSWALReader(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 5, time.UTC)
+	appendFile(t, filepath.Join(dir, "wal.000"), append(csWALRecord(at, "a"), csWALRecord(at, "b")...))
+	appendFile(t, filepath.Join(dir, "wal.001"), csWALRecord(at, "c"))
+	head := filepath.Join(dir, "wal")
+	d := csWALRecord(at, "d")
+	appendFile(t, head, d[:5]) // being written
+
+	r, err := OpenCSWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rec, err := r.Next()
+	if err != nil || string(rec.Msg) != "a" || !rec.Time.Equal(at) {
+		t.Fatalf("first record = %+v, %v", rec, err)
+	}
+	if got := readCSWAL(t, r); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("records = %v, want [b c]", got)
+	}
+
+	appendFile(t, head, d[5:])
+	if got := readCSWAL(t, r); len(got) != 1 || got[0] != "d" {
+		t.Fatalf("after completion: records = %v, want [d]", got)
+	}
+
+	// Rotation renames the head; the reader finishes it and moves on.
+	appendFile(t, head, csWALRecord(at, "e"))
+	if err := os.Rename(head, filepath.Join(dir, "wal.002")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, head, csWALRecord(at, "f"))
+	if got := readCSWAL(t, r); len(got) != 2 || got[0] != "e" || got[1] != "f" {
+		t.Fatalf("after rotation: records = %v, want [e f]", got)
+	}
+	if path, off := r.Position(); path != head || off != int64(len(csWALRecord(at, "f"))) {
+		t.Errorf("position = %s %d", path, off)
+	}
+
+	// A record failing its checksum is skipped.
+	bad := csWALRecord(at, "g")
+	bad[0] ^= 0xff
+	appendFile(t, head, append(bad, csWALRecord(at, "h")...))
+	if _, err := r.Next(); !errors.Is(err, ErrCSWALCorrupt) {
+		t.Fatalf("corrupt record: err = %v", err)
+	}
+	if got := readCSWAL(t, r); len(got) != 1 || got[0] != "h" {
+		t.Fatalf("after corruption: records = %v, want [h]", got)
+	}
+}
+
+func TestCSWALReader_SkipsCorruptLengthOnceRotated(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	head := filepath.Join(dir, "wal")
+	bad := csWALRecord(at, "b")
+	binary.BigEndian.PutUint32(bad[4:], maxCSWALRecord+1)
+	appendFile(t, head, append(csWALRecord(at, "a"), bad...))
+
+	r, err := OpenCSWAL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if rec, err := r.Next(); err != nil || string(rec.Msg) != "a" {
+		t.Fatalf("first record = %+v, %v", rec, err)
+	}
+	// The head may still be written, so the reader stays put.
+	for i := 0; i < 2; i++ {
+		var skip *CSWALSkipError
+		if _, err := r.Next(); !errors.Is(err, ErrCSWALCorrupt) || errors.As(err, &skip) {
+			t.Fatalf("corrupt length in head: err = %v", err)
+		}
+	}
+
+	rotated := filepath.Join(dir, "wal.000")
+	if err := os.Rename(head, rotated); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, head, csWALRecord(at, "c"))
+	_, err = r.Next()
+	var skip *CSWALSkipError
+	if !errors.As(err, &skip) || !errors.Is(err, ErrCSWALCorrupt) {
+		t.Fatalf("corrupt length once rotated: err = %v", err)
+	}
+	if want := (CSWALSkipError{File: rotated, Offset: int64(len(csWALRecord(at, "a"))), Bytes: int64(len(bad)), Next: head}); *skip != want {
+		t.Errorf("skip = %+v, want %+v", *skip, want)
+	}
+	if got := readCSWAL(t, r); len(got) != 1 || got[0] != "c" {
+		t.Fatalf("after skip: records = %v, want [c]", got)
+	}
+}
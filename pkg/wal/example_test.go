@@ -0,0 +1,34 @@
+package wal_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/bft-labs/walship/pkg/wal"
+)
+
+// Count the records in every frame currently in a node's WAL.
+func Example() {
+	r, err := wal.Open("/home/validator/.osmosisd/data/log.wal/node-abc")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	var frames, recs int
+	for {
+		f, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break // nothing more yet; call Next again later to keep tailing
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		frames++
+		recs += int(f.Meta.Recs)
+	}
+	idx, off := r.Position()
+	fmt.Printf("%d frames, %d records; resume with wal.OpenAt(%q, %d)\n", frames, recs, idx, off)
+}
@@ -0,0 +1,227 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isDayDir reports whether name looks like a YYYY-MM-DD day directory.
+func isDayDir(name string) bool {
+	return len(name) == len("2006-01-02") && strings.Count(name, "-") == 2
+}
+
+// LatestIndex discovers the newest day directory (YYYY-MM-DD) under dir, then
+// returns the lexicographically newest .wal.idx inside that day. If no day
+// directories exist, it falls back to picking the newest .idx directly under dir.
+func LatestIndex(dir string) (string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	// First pass: look for day directories
+	latestDay := ""
+	for _, e := range ents {
+		if e.IsDir() && isDayDir(e.Name()) && e.Name() > latestDay {
+			latestDay = e.Name()
+		}
+	}
+	if latestDay != "" {
+		// Scan inside latest day directory for newest .wal.idx
+		dayDir := filepath.Join(dir, latestDay)
+		dayEnts, err := os.ReadDir(dayDir)
+		if err != nil {
+			return "", err
+		}
+		latest := ""
+		for _, de := range dayEnts {
+			n := de.Name()
+			if strings.HasSuffix(n, ".wal.idx") && n > latest {
+				latest = n
+			}
+		}
+		if latest == "" {
+			return "", fmt.Errorf("no index files in %s", dayDir)
+		}
+		return filepath.Join(dayDir, latest), nil
+	}
+	// Fallback: no day dirs; look directly under dir
+	var latest string
+	for _, e := range ents {
+		n := e.Name()
+		if (strings.HasSuffix(n, ".wal.idx") || strings.HasSuffix(n, ".idx")) && n > latest {
+			latest = n
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no index files in %s", dir)
+	}
+	return filepath.Join(dir, latest), nil
+}
+
+// OldestIndex mirrors LatestIndex but picks the earliest day and the
+// lexicographically smallest index within that day. Falls back to dir
+// directly if no day dirs are present.
+func OldestIndex(dir string) (string, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	// Find earliest day
+	earliestDay := "~" // larger than any valid day; we will pick smaller
+	hasDay := false
+	for _, e := range ents {
+		if e.IsDir() && isDayDir(e.Name()) {
+			hasDay = true
+			if e.Name() < earliestDay {
+				earliestDay = e.Name()
+			}
+		}
+	}
+	if hasDay {
+		dayDir := filepath.Join(dir, earliestDay)
+		dayEnts, err := os.ReadDir(dayDir)
+		if err != nil {
+			return "", err
+		}
+		// pick smallest seg index by lexicographic name
+		oldest := "~"
+		for _, de := range dayEnts {
+			n := de.Name()
+			if strings.HasSuffix(n, ".wal.idx") && n < oldest {
+				oldest = n
+			}
+		}
+		if oldest == "~" {
+			return "", fmt.Errorf("no index files in %s", dayDir)
+		}
+		return filepath.Join(dayDir, oldest), nil
+	}
+	// No day dirs; pick smallest idx directly under dir
+	oldest := "~"
+	for _, e := range ents {
+		n := e.Name()
+		if (strings.HasSuffix(n, ".wal.idx") || strings.HasSuffix(n, ".idx")) && n < oldest {
+			oldest = n
+		}
+	}
+	if oldest == "~" {
+		return "", fmt.Errorf("no index files found in %q", dir)
+	}
+	return filepath.Join(dir, oldest), nil
+}
+
+// NextIndexAfter returns the next index path after the given current index.
+// It looks for the next segment within the same day; if not present, advances
+// to the next day directory and selects the first segment there. If nothing
+// newer exists yet, returns ("", false, nil).
+func NextIndexAfter(curIdxPath string) (string, bool, error) {
+	dayDir := filepath.Dir(curIdxPath)
+	base := filepath.Base(curIdxPath)
+	// Extract current segment number
+	var cur int
+	if _, err := fmt.Sscanf(base, "seg-%06d.wal.idx", &cur); err != nil {
+		return "", false, fmt.Errorf("unrecognized index name: %s", base)
+	}
+	// Candidate in same day
+	cand := filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", cur+1))
+	if _, err := os.Stat(cand); err == nil {
+		return cand, true, nil
+	}
+	// Advance to next day directory
+	parent := filepath.Dir(dayDir)
+	ents, err := os.ReadDir(parent)
+	if err != nil {
+		return "", false, err
+	}
+	curDay := filepath.Base(dayDir)
+	nextDay := ""
+	for _, e := range ents {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		if isDayDir(name) && name > curDay && (nextDay == "" || name < nextDay) {
+			nextDay = name
+		}
+	}
+	if nextDay == "" {
+		return "", false, nil
+	}
+	nd := filepath.Join(parent, nextDay)
+	first := filepath.Join(nd, "seg-000001.wal.idx")
+	if _, err := os.Stat(first); err == nil {
+		return first, true, nil
+	}
+	// No first segment yet in the new day
+	return "", false, nil
+}
+
+// NextExistingIndexAfter returns the first index that exists after the given
+// current index: the lowest-numbered later segment in the same day, or else
+// the lowest-numbered segment of the next day directory that has any. Unlike
+// NextIndexAfter it steps over missing segments; missing counts the segment
+// numbers it stepped over that are known to be missing, not counting any at
+// the end of the current day. If no later index exists, it returns
+// ("", 0, false, nil).
+func NextExistingIndexAfter(curIdxPath string) (next string, missing int, ok bool, err error) {
+	dayDir := filepath.Dir(curIdxPath)
+	base := filepath.Base(curIdxPath)
+	var cur int
+	if _, err := fmt.Sscanf(base, "seg-%06d.wal.idx", &cur); err != nil {
+		return "", 0, false, fmt.Errorf("unrecognized index name: %s", base)
+	}
+	n, ok, err := lowestSegment(dayDir, cur)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if ok {
+		return filepath.Join(dayDir, fmt.Sprintf("seg-%06d.wal.idx", n)), n - cur - 1, true, nil
+	}
+	parent := filepath.Dir(dayDir)
+	ents, err := os.ReadDir(parent)
+	if err != nil {
+		return "", 0, false, err
+	}
+	curDay := filepath.Base(dayDir)
+	var days []string
+	for _, e := range ents {
+		if e.IsDir() && isDayDir(e.Name()) && e.Name() > curDay {
+			days = append(days, e.Name())
+		}
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		dir := filepath.Join(parent, day)
+		n, ok, err := lowestSegment(dir, 0)
+		if err != nil {
+			return "", 0, false, err
+		}
+		if ok {
+			return filepath.Join(dir, fmt.Sprintf("seg-%06d.wal.idx", n)), n - 1, true, nil
+		}
+	}
+	return "", 0, false, nil
+}
+
+// lowestSegment returns the lowest segment number above after that has an
+// index file in dir.
+func lowestSegment(dir string, after int) (int, bool, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, false, err
+	}
+	best, found := 0, false
+	for _, e := range ents {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "seg-%06d.wal.idx", &n); err != nil || e.Name() != fmt.Sprintf("seg-%06d.wal.idx", n) {
+			continue
+		}
+		if n > after && (!found || n < best) {
+			best, found = n, true
+		}
+	}
+	return best, found, nil
+}
@@ -0,0 +1,40 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// OpenFile opens path read-only. Other processes may rename or remove the
+// file while it is open, on Windows too, where os.Open would stop the node
+// from rotating or pruning the segment.
+func OpenFile(path string) (*os.File, error) { return openShared(path) }
+
+// pinsOpenFiles is PinsOpenFiles; tests replace it.
+var pinsOpenFiles = sync.OnceValue(probePins)
+
+// PinsOpenFiles reports whether a removed file keeps its name, and keeps
+// its directory from being removed, until every handle to it is closed. So
+// it goes on Windows without POSIX delete semantics, in which case readers
+// close the files they are idle on for the node to rotate and prune its
+// WAL. It is found out once, by removing an open file under os.TempDir.
+func PinsOpenFiles() bool { return pinsOpenFiles() }
+
+func probePins() bool {
+	dir, err := os.MkdirTemp("", "walship-probe-")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "open")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		return false
+	}
+	f, err := OpenFile(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return os.Remove(path) != nil || os.Remove(dir) != nil
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package wal
+
+import "os"
+
+func openShared(path string) (*os.File, error) { return os.Open(path) }
@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Reader iterates frames in WAL order, following rotation into newer index
+// files and day directories. When no complete frame is available yet, Next
+// returns io.EOF; calling Next again later continues where it stopped, so a
+// Reader can tail a live WAL. Where PinsOpenFiles, the Reader closes its
+// files whenever Next returns io.EOF and reopens them on the next call.
+type Reader struct {
+	idxPath string
+	offset  int64
+
+	idx *os.File
+	r   *bufio.Reader
+
+	// gzPath is the .gz file open as gz. Segments of different days share
+	// names, so it is the full path.
+	gzPath string
+	gz     *os.File
+}
+
+// Open returns a Reader positioned at the first frame of the oldest index
+// under dir.
+func Open(dir string) (*Reader, error) {
+	idxPath, err := OldestIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	return OpenAt(idxPath, 0)
+}
+
+// OpenAt returns a Reader positioned at offset in idxPath, typically a value
+// previously obtained from Position.
+func OpenAt(idxPath string, offset int64) (*Reader, error) {
+	r := &Reader{}
+	if err := r.openIndex(idxPath, offset); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reader) openIndex(idxPath string, offset int64) error {
+	f, err := OpenFile(idxPath)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if r.idx != nil {
+		r.idx.Close()
+	}
+	r.idx, r.idxPath, r.offset = f, idxPath, offset
+	r.r = bufio.NewReaderSize(f, 64*1024)
+	return nil
+}
+
+// Position returns the index file and offset of the next frame Next will read.
+func (r *Reader) Position() (idxPath string, offset int64) {
+	return r.idxPath, r.offset
+}
+
+// Next returns the next frame. It returns io.EOF when the WAL has no further
+// complete frame yet. Malformed index lines are skipped.
+func (r *Reader) Next() (Frame, error) {
+	if r.idx == nil {
+		if err := r.openIndex(r.idxPath, r.offset); err != nil {
+			return Frame{}, err
+		}
+	}
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// Partial line still being written; re-read it next time.
+				if err := r.openIndex(r.idxPath, r.offset); err != nil {
+					return Frame{}, err
+				}
+				return Frame{}, r.idle()
+			}
+			next, ok, nerr := NextIndexAfter(r.idxPath)
+			if nerr != nil || !ok {
+				return Frame{}, r.idle()
+			}
+			if err := r.openIndex(next, 0); err != nil {
+				return Frame{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return Frame{}, err
+		}
+
+		start := r.offset
+		r.offset += int64(len(line))
+		fm, perr := ParseIndexLine(bytes.TrimSpace(line))
+		if perr != nil {
+			continue
+		}
+		b, err := r.readFrame(fm)
+		if err != nil {
+			r.offset = start
+			_ = r.openIndex(r.idxPath, start)
+			return Frame{}, err
+		}
+		return Frame{Meta: fm, Compressed: b, Index: r.idxPath, Offset: start, LineLen: len(line)}, nil
+	}
+}
+
+func (r *Reader) readFrame(fm FrameMeta) ([]byte, error) {
+	path := filepath.Join(filepath.Dir(r.idxPath), fm.File)
+	if r.gz == nil || r.gzPath != path {
+		r.closeGz()
+		f, err := OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		r.gz, r.gzPath = f, path
+	}
+	return ReadFrame(r.gz, fm)
+}
+
+// idle returns io.EOF, first closing the open files where PinsOpenFiles.
+func (r *Reader) idle() error {
+	if pinsOpenFiles() {
+		r.Close()
+	}
+	return io.EOF
+}
+
+func (r *Reader) closeGz() {
+	if r.gz != nil {
+		r.gz.Close()
+		r.gz, r.gzPath = nil, ""
+	}
+}
+
+// Close releases the open files. A later Next opens them again.
+func (r *Reader) Close() error {
+	var err error
+	if r.idx != nil {
+		err = r.idx.Close()
+		r.idx, r.r = nil, nil
+	}
+	if r.gz != nil {
+		if cerr := r.gz.Close(); err == nil {
+			err = cerr
+		}
+		r.gz, r.gzPath = nil, ""
+	}
+	return err
+}
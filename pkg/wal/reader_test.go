@@ -0,0 +1,273 @@
+package wal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSegment writes seg-NNNNNN.wal.gz/.wal.idx in dir with one gzip member
+// per record and returns the index line lengths.
+func writeSegment(t *testing.T, dir string, seg int, records []string) []int {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	gzName := segmentName(seg, "gz")
+	var gzBuf, idxBuf bytes.Buffer
+	var lens []int
+	for i, rec := range records {
+		var member bytes.Buffer
+		zw := gzip.NewWriter(&member)
+		zw.Write([]byte(rec))
+		zw.Close()
+		fm := FrameMeta{File: gzName, Frame: uint64(i + 1), Off: uint64(gzBuf.Len()), Len: uint64(member.Len()), Recs: 1}
+		gzBuf.Write(member.Bytes())
+		line, _ := json.Marshal(fm)
+		line = append(line, '\n')
+		lens = append(lens, len(line))
+		idxBuf.Write(line)
+	}
+	if err := os.WriteFile(filepath.Join(dir, gzName), gzBuf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, segmentName(seg, "idx")), idxBuf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return lens
+}
+
+func segmentName(seg int, ext string) string {
+	return fmt.Sprintf("seg-%06d.wal.%s", seg, ext)
+}
+
+func readAll(t *testing.T, r *Reader) []string {
+	t.Helper()
+	var out []string
+	for {
+		f, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		b, err := f.Decompress()
+		if err != nil {
+			t.Fatalf("Decompress: %v", err)
+		}
+		out = append(out, string(b))
+	}
+}
+
+func TestReader_FollowsSegmentsAndDays(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, filepath.Join(dir, "2024-01-01"), 1, []string{"a", "b"})
+	writeSegment(t, filepath.Join(dir, "2024-01-01"), 2, []string{"c"})
+	writeSegment(t, filepath.Join(dir, "2024-01-02"), 1, []string{"d"})
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got := readAll(t, r)
+	want := []string{"a", "b", "c", "d"}
+	if len(got) != len(want) {
+		t.Fatalf("frames = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("frames = %v, want %v", got, want)
+		}
+	}
+	if p, _ := r.Position(); p != filepath.Join(dir, "2024-01-02", "seg-000001.wal.idx") {
+		t.Errorf("Position index = %s", p)
+	}
+}
+
+func TestReader_SameSegmentAcrossDays(t *testing.T) {
+	// Each day restarts segment numbers, and a day may hold one segment.
+	dir := t.TempDir()
+	writeSegment(t, filepath.Join(dir, "2024-01-01"), 1, []string{"a"})
+	writeSegment(t, filepath.Join(dir, "2024-01-02"), 1, []string{"b"})
+
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := readAll(t, r); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("frames = %v, want [a b]", got)
+	}
+}
+
+func TestReader_ResumesFromPosition(t *testing.T) {
+	dir := t.TempDir()
+	lens := writeSegment(t, dir, 1, []string{"a", "b", "c"})
+	idx := filepath.Join(dir, "seg-000001.wal.idx")
+
+	r, err := OpenAt(idx, int64(lens[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	f, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Meta.Frame != 2 || f.Offset != int64(lens[0]) || f.LineLen != lens[1] {
+		t.Errorf("frame = %+v, want frame 2 at offset %d", f.Meta, lens[0])
+	}
+	if _, off := r.Position(); off != int64(lens[0]+lens[1]) {
+		t.Errorf("Position offset = %d, want %d", off, lens[0]+lens[1])
+	}
+}
+
+func TestReader_TailsPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, []string{"a", "b"})
+	idx := filepath.Join(dir, "seg-000001.wal.idx")
+	full, _ := os.ReadFile(idx)
+	cut := bytes.IndexByte(full, '\n') + 5
+	if err := os.WriteFile(idx, full[:cut], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenAt(idx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := readAll(t, r); len(got) != 1 {
+		t.Fatalf("before completion: frames = %v, want 1", got)
+	}
+	if err := os.WriteFile(idx, full, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, r); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("after completion: frames = %v, want [b]", got)
+	}
+}
+
+func TestOldestAndLatestIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, filepath.Join(dir, "2024-01-02"), 1, []string{"x"})
+	writeSegment(t, filepath.Join(dir, "2024-01-01"), 3, []string{"x"})
+	writeSegment(t, filepath.Join(dir, "2024-01-01"), 4, []string{"x"})
+
+	if got, err := OldestIndex(dir); err != nil || got != filepath.Join(dir, "2024-01-01", "seg-000003.wal.idx") {
+		t.Errorf("OldestIndex = %s, %v", got, err)
+	}
+	if got, err := LatestIndex(dir); err != nil || got != filepath.Join(dir, "2024-01-02", "seg-000001.wal.idx") {
+		t.Errorf("LatestIndex = %s, %v", got, err)
+	}
+	if _, err := OldestIndex(t.TempDir()); err == nil {
+		t.Error("OldestIndex on empty dir should fail")
+	}
+}
+
+func TestNextExistingIndexAfter(t *testing.T) {
+	dir := t.TempDir()
+	day1, day2, day3 := filepath.Join(dir, "2024-01-01"), filepath.Join(dir, "2024-01-02"), filepath.Join(dir, "2024-01-03")
+	writeSegment(t, day1, 1, []string{"x"})
+	writeSegment(t, day1, 2, []string{"x"})
+	writeSegment(t, day1, 5, []string{"x"})
+	if err := os.MkdirAll(day2, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeSegment(t, day3, 3, []string{"x"})
+
+	cases := []struct {
+		cur     string
+		next    string
+		missing int
+	}{
+		{filepath.Join(day1, "seg-000001.wal.idx"), filepath.Join(day1, "seg-000002.wal.idx"), 0},
+		{filepath.Join(day1, "seg-000002.wal.idx"), filepath.Join(day1, "seg-000005.wal.idx"), 2},
+		// An empty day is stepped over.
+		{filepath.Join(day1, "seg-000005.wal.idx"), filepath.Join(day3, "seg-000003.wal.idx"), 2},
+		{filepath.Join(day3, "seg-000003.wal.idx"), "", 0},
+	}
+	for _, c := range cases {
+		next, missing, ok, err := NextExistingIndexAfter(c.cur)
+		if err != nil || ok != (c.next != "") || next != c.next || missing != c.missing {
+			t.Errorf("NextExistingIndexAfter(%s) = %s, %d, %v, %v; want %s, %d", c.cur, next, missing, ok, err, c.next, c.missing)
+		}
+	}
+}
+
+func TestReader_ReleasesFilesWhenPinned(t *testing.T) {
+	for _, pinned := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pinned=%v", pinned), func(t *testing.T) {
+			defer func(f func() bool) { pinsOpenFiles = f }(pinsOpenFiles)
+			pinsOpenFiles = func() bool { return pinned }
+
+			dir := t.TempDir()
+			writeSegment(t, dir, 1, []string{"a", "b"})
+			r, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if got := readAll(t, r); len(got) != 2 {
+				t.Fatalf("frames = %v, want 2", got)
+			}
+			if open := r.idx != nil || r.gz != nil; open == pinned {
+				t.Errorf("files open at EOF: %v", open)
+			}
+
+			// The node rotates to a new segment and prunes the one read.
+			writeSegment(t, dir, 2, []string{"c"})
+			if got := readAll(t, r); len(got) != 1 || got[0] != "c" {
+				t.Fatalf("after rotation: frames = %v, want [c]", got)
+			}
+			for _, ext := range []string{"idx", "gz"} {
+				if err := os.Remove(filepath.Join(dir, segmentName(1, ext))); err != nil {
+					t.Errorf("remove read segment: %v", err)
+				}
+			}
+			if p, off := r.Position(); p != filepath.Join(dir, segmentName(2, "idx")) || off == 0 {
+				t.Errorf("Position = %s, %d", p, off)
+			}
+		})
+	}
+}
+
+func TestOpenFile_AllowsRenameAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wal")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("write to a file opened read-only succeeded")
+	}
+	// CometBFT rotates its consensus WAL by renaming the open head file.
+	rotated := filepath.Join(dir, "wal.000")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("rename open file: %v", err)
+	}
+	if err := os.Remove(rotated); err != nil {
+		t.Fatalf("remove open file: %v", err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(f, b); err != nil || string(b) != "data" {
+		t.Errorf("read after remove = %q, %v", b, err)
+	}
+	if _, err := OpenFile(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenFile of a missing file: %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Package wal reads the write-ahead log produced by memlogger: pairs of
+// seg-NNNNNN.wal.idx (one JSON FrameMeta per line) and seg-NNNNNN.wal.gz (one
+// gzip member per frame), optionally grouped in YYYY-MM-DD day directories.
+//
+// CSWALReader reads CometBFT's own consensus WAL instead, which needs no
+// patched node. It lives in the node's data/cs.wal directory: the head file
+// "wal" and the rotated files wal.000, wal.001, ... before it. Each record is
+//
+//	crc32c(data) uint32 | len(data) uint32 | data
+//
+// (big endian), where data is a protobuf tendermint.consensus.TimedWALMessage.
+//
+// Files are only ever opened read-only.
+package wal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FrameMeta matches tools/memlogger/writer.go schema for index lines.
+// Fields are used to locate and read gzip members from the .gz file.
+type FrameMeta struct {
+	File    string `json:"file"`
+	Frame   uint64 `json:"frame"`
+	Off     uint64 `json:"off"`
+	Len     uint64 `json:"len"`
+	Recs    uint32 `json:"recs"`
+	FirstTS int64  `json:"first_ts"`
+	LastTS  int64  `json:"last_ts"`
+	CRC32   uint32 `json:"crc32"`
+}
+
+// Frame is one frame read from the WAL.
+type Frame struct {
+	Meta FrameMeta
+	// Compressed is the frame's gzip member exactly as stored.
+	Compressed []byte
+	// Index is the path of the .wal.idx file the frame was listed in, and
+	// Offset the byte offset of its line there. Offset+LineLen is where the
+	// next frame's line starts.
+	Index   string
+	Offset  int64
+	LineLen int
+}
+
+// Decompress returns the frame's uncompressed records.
+func (f Frame) Decompress() ([]byte, error) {
+	return Decompress(f.Compressed)
+}
+
+// ParseIndexLine decodes one index line.
+func ParseIndexLine(line []byte) (FrameMeta, error) {
+	var fm FrameMeta
+	if err := json.Unmarshal(line, &fm); err != nil {
+		return FrameMeta{}, fmt.Errorf("bad index line: %w", err)
+	}
+	return fm, nil
+}
+
+// ReadFrame reads the compressed bytes of fm from its .gz file.
+func ReadFrame(gz io.ReaderAt, fm FrameMeta) ([]byte, error) {
+	if gz == nil {
+		return nil, errors.New("nil file")
+	}
+	buf := make([]byte, fm.Len)
+	_, err := io.ReadFull(io.NewSectionReader(gz, int64(fm.Off), int64(fm.Len)), buf)
+	return buf, err
+}
+
+// Decompress gunzips a single frame.
+func Decompress(compressed []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
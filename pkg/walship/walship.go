@@ -0,0 +1,180 @@
+// Package walship is the library API of the WAL shipper, for programs that
+// embed it rather than run the walship binary. It exposes the agent's
+// config and entry points; everything else stays internal.
+package walship
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"github.com/bft-labs/walship/internal/agent"
+)
+
+// Config configures the agent; see DefaultConfig and Config.Validate.
+type Config = agent.Config
+
+// FrameMeta is a frame's index line.
+type FrameMeta = agent.FrameMeta
+
+// FrameFilter decides from its index line whether a frame is shipped; set
+// it as Config.FrameFilter. Frames it rejects are skipped before they are
+// read and reported to the service as sampled.
+type FrameFilter = agent.FrameFilter
+
+// EveryNthFrame is a FrameFilter keeping the frames whose number is a
+// multiple of n.
+func EveryNthFrame(n uint64) FrameFilter { return agent.EveryNthFrame(n) }
+
+// PluginHook lets code embedding the agent observe and veto batch uploads;
+// set it in Config.PluginHooks.
+type PluginHook = agent.PluginHook
+
+// SendInfo describes a batch handed to a PluginHook.
+type SendInfo = agent.SendInfo
+
+// PluginIniter is implemented by plugin hooks that need per-node setup;
+// Init is called with the node's PluginConfig before its first send.
+type PluginIniter = agent.PluginIniter
+
+// PluginConfig is handed to PluginIniter hooks when a node's pipeline
+// starts.
+type PluginConfig = agent.PluginConfig
+
+// PluginNamer is implemented by plugin hooks that choose the namespace of
+// their PluginState.
+type PluginNamer = agent.PluginNamer
+
+// PluginState is a plugin's key-value store in the node's state dir.
+type PluginState = agent.PluginState
+
+// ErrPluginKeyNotFound is returned by PluginState.Get for a missing key.
+var ErrPluginKeyNotFound = agent.ErrPluginKeyNotFound
+
+// HealthReporter is implemented by plugin hooks and scrapers that can tell
+// whether they still do their job; Health returns nil while they do.
+type HealthReporter = agent.HealthReporter
+
+// HealthReport is the agent's health, as returned by Health.
+type HealthReport = agent.HealthReport
+
+// PluginHealth is the health of one plugin hook or scraper of a node.
+type PluginHealth = agent.PluginHealth
+
+// Plugin kinds of a PluginHealth.
+const (
+	PluginKindHook    = agent.PluginKindHook
+	PluginKindScraper = agent.PluginKindScraper
+)
+
+// Health reports the health of the running agent's plugin hooks and
+// scrapers on every node it ships.
+func Health() HealthReport { return agent.Health() }
+
+// SendSuccessEvent describes a batch the service accepted, as passed to
+// Config.OnSendSuccess.
+type SendSuccessEvent = agent.SendSuccessEvent
+
+// RetryEvent describes a failed batch upload about to be retried, as
+// passed to Config.OnRetry.
+type RetryEvent = agent.RetryEvent
+
+// SendErrorEvent describes a batch upload that failed after all its
+// attempts, as passed to Config.OnSendError.
+type SendErrorEvent = agent.SendErrorEvent
+
+// ServerError is the structured error the service answered an upload
+// with; upload errors wrap it, so it can be matched with errors.As.
+type ServerError = agent.ServerError
+
+// ConfigShippedEvent describes a snapshot of the node's config files the
+// service accepted, as passed to Config.OnConfigShipped.
+type ConfigShippedEvent = agent.ConfigShippedEvent
+
+// GapEvent describes WAL data found missing while reading, as passed to
+// Config.OnGapDetected.
+type GapEvent = agent.GapEvent
+
+// DefaultConfig returns the config the walship binary starts from.
+func DefaultConfig() Config { return agent.DefaultConfig() }
+
+// LoadConfig layers the config file, WALSHIP_* environment variables and
+// the settings already in cfg, then fills in node identity and validates.
+// Settings whose flags are named in changed are never overwritten. The
+// file is path if given, else $WALSHIP_CONFIG, else the default path.
+func LoadConfig(cfg *Config, path string, changed map[string]bool) error {
+	return agent.LoadConfig(cfg, path, changed)
+}
+
+// ReloadConfig re-reads the config file and environment for a running
+// agent started with cur, for passing to Reload.
+func ReloadConfig(cur Config, path string, changed map[string]bool) (Config, error) {
+	return agent.ReloadConfig(cur, path, changed)
+}
+
+// ConfigOption describes one configurable setting and every way to set it.
+type ConfigOption = agent.ConfigOption
+
+// ConfigSchema describes every Config option: its flag, environment
+// variable, file key and default. Precedence is flag > environment > file >
+// default.
+func ConfigSchema() []ConfigOption { return agent.ConfigSchema() }
+
+// Run ships the WAL of cfg's node, or nodes, until ctx is done,
+// applying opts to cfg first.
+func Run(ctx context.Context, cfg Config, opts ...Option) error {
+	return agent.Run(ctx, apply(cfg, opts))
+}
+
+// Option adjusts the Config handed to Run or NewSupervisor.
+type Option func(*Config)
+
+// WithConfigShipping turns shipping the node's config files on or off.
+// Each snapshot the service accepts is passed to onShipped, if not nil.
+func WithConfigShipping(enabled bool, onShipped func(ConfigShippedEvent)) Option {
+	return func(cfg *Config) {
+		cfg.ShipConfig = enabled
+		if onShipped != nil {
+			cfg.OnConfigShipped = onShipped
+		}
+	}
+}
+
+func apply(cfg Config, opts []Option) Config {
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+// Logger returns the logger the agent, and its config watcher, log to.
+func Logger() zerolog.Logger { return agent.Logger() }
+
+// Reload hands cfg to the running agent, which applies the settings it
+// can change without a restart and logs the others. cfg is validated
+// first; an invalid cfg changes nothing.
+func Reload(cfg Config) error { return agent.Reload(cfg) }
+
+// Supervisor runs the agent and restarts it, with exponential backoff,
+// whenever Run fails or panics.
+type Supervisor = agent.Supervisor
+
+// SupervisorEvent is a lifecycle transition of a Supervisor's agent.
+type SupervisorEvent = agent.SupervisorEvent
+
+// NewSupervisor returns a Supervisor of cfg's agent that allows 5 restarts
+// in 10 minutes, 1s apart at first and at most a minute. opts are applied
+// to cfg first.
+func NewSupervisor(cfg Config, opts ...Option) *Supervisor {
+	return agent.NewSupervisor(apply(cfg, opts))
+}
+
+// ReplayQuery selects the consensus events Replay re-sends.
+type ReplayQuery = agent.ReplayQuery
+
+// Replay decodes the heights q selects from cfg's WAL and re-sends their
+// consensus events, marked as a replay, without touching the shipping
+// position. It returns the number of events sent.
+func Replay(ctx context.Context, cfg Config, q ReplayQuery) (int, error) {
+	return agent.Replay(ctx, cfg, q)
+}
@@ -0,0 +1,77 @@
+package walship
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEveryNthFrame(t *testing.T) {
+	var f FrameFilter = EveryNthFrame(3)
+	cfg := DefaultConfig()
+	cfg.FrameFilter = f
+	if cfg.FrameFilter(FrameMeta{Frame: 4}) || !cfg.FrameFilter(FrameMeta{Frame: 6}) {
+		t.Error("EveryNthFrame(3) kept frame 4 or dropped frame 6")
+	}
+}
+
+func TestReplay_RejectsBadRange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WALDir = t.TempDir()
+	if _, err := Replay(context.Background(), cfg, ReplayQuery{FromHeight: 5, ToHeight: 2}); err == nil {
+		t.Fatal("Replay accepted heights 5-2")
+	}
+}
+
+func TestHealth_NotRunning(t *testing.T) {
+	h := Health()
+	if !h.Healthy || h.Ready || len(h.Plugins) != 0 {
+		t.Errorf("Health() without a running agent = %+v", h)
+	}
+}
+
+func TestConfigSchema(t *testing.T) {
+	for _, o := range ConfigSchema() {
+		if o.Field == "ServiceURL" {
+			if o.Flag != "service-url" || o.Env != "WALSHIP_SERVICE_URL" {
+				t.Errorf("ServiceURL option = %+v", o)
+			}
+			return
+		}
+	}
+	t.Error("ConfigSchema has no ServiceURL option")
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "walship.toml")
+	content := "service_url = \"https://ingest.example.com\"\nnode_home = \"/home/node\"\nwal_dir = \"/wal\"\nnode_id = \"node-1\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.ChainID = "chain-1" // as if set by --chain-id
+	if err := LoadConfig(&cfg, path, map[string]bool{"chain-id": true}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServiceURL != "https://ingest.example.com" || cfg.WALDir != "/wal" || cfg.StateDir != "/wal" {
+		t.Errorf("cfg = %+v", cfg)
+	}
+}
+
+func TestWithConfigShipping(t *testing.T) {
+	cfg := DefaultConfig()
+	got := apply(cfg, []Option{WithConfigShipping(false, nil)})
+	if got.ShipConfig || got.OnConfigShipped != nil {
+		t.Errorf("WithConfigShipping(false, nil): ShipConfig = %v", got.ShipConfig)
+	}
+	var n int
+	got = apply(got, []Option{WithConfigShipping(true, func(ConfigShippedEvent) { n++ })})
+	if !got.ShipConfig || got.OnConfigShipped == nil {
+		t.Fatal("WithConfigShipping(true, fn) did not enable shipping")
+	}
+	got.OnConfigShipped(ConfigShippedEvent{})
+	if n != 1 {
+		t.Error("OnConfigShipped is not the callback passed")
+	}
+}